@@ -18,6 +18,7 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"strconv"
 	"strings"
 
@@ -27,7 +28,9 @@ import (
 	"github.com/caicloud/nirvana/log"
 	"github.com/caicloud/nirvana/service"
 	"github.com/caicloud/nirvana/utils/api"
+	"github.com/caicloud/nirvana/utils/generators/openapi"
 	"github.com/caicloud/nirvana/utils/generators/swagger"
+	"github.com/caicloud/nirvana/utils/project"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
@@ -60,16 +63,21 @@ func newAPICommand() *cobra.Command {
 }
 
 type apiOptions struct {
-	Serve  string
-	Output string
+	Serve   string
+	Output  string
+	Version string
 }
 
 func (o *apiOptions) Install(flags *pflag.FlagSet) {
 	flags.StringVar(&o.Serve, "serve", "127.0.0.1:8080", "Start a server to host api docs")
 	flags.StringVar(&o.Output, "output", "", "Directory to output api specifications")
+	flags.StringVar(&o.Version, "openapi-version", "2.0", "Version of api specifications, 2.0 (swagger) or 3.0")
 }
 
 func (o *apiOptions) Validate(cmd *cobra.Command, args []string) error {
+	if o.Version != "2.0" && o.Version != "3.0" {
+		return fmt.Errorf("unsupported openapi version %s, only 2.0 and 3.0 are supported", o.Version)
+	}
 	return nil
 }
 
@@ -87,22 +95,11 @@ func (o *apiOptions) Run(cmd *cobra.Command, args []string) error {
 
 	log.Infof("Project root directory is %s", config.Root)
 
-	generator := swagger.NewDefaultGenerator(config, definitions)
-	swaggers, err := generator.Generate()
+	files, err := o.generate(config, definitions)
 	if err != nil {
 		return err
 	}
 
-	files := make(map[string][]byte, len(swaggers))
-	for filename, s := range swaggers {
-		data, err := json.MarshalIndent(s, "", "  ")
-		if err != nil {
-			return err
-		}
-
-		files[filename] = data
-	}
-
 	if o.Output != "" {
 		if err = api.WriteFiles(o.Output, files); err != nil {
 			return err
@@ -115,6 +112,38 @@ func (o *apiOptions) Run(cmd *cobra.Command, args []string) error {
 	return err
 }
 
+func (o *apiOptions) generate(config *project.Config, definitions *api.Definitions) (map[string][]byte, error) {
+	specs := map[string]interface{}{}
+	if o.Version == "3.0" {
+		documents, err := openapi.NewDefaultGenerator(config, definitions).Generate()
+		if err != nil {
+			return nil, err
+		}
+		for filename, doc := range documents {
+			specs[filename] = doc
+		}
+	} else {
+		swaggers, err := swagger.NewDefaultGenerator(config, definitions).Generate()
+		if err != nil {
+			return nil, err
+		}
+		for filename, s := range swaggers {
+			specs[filename] = s
+		}
+	}
+
+	files := make(map[string][]byte, len(specs))
+	for filename, s := range specs {
+		data, err := json.MarshalIndent(s, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+
+		files[filename] = data
+	}
+	return files, nil
+}
+
 func (o *apiOptions) serve(apis map[string][]byte) error {
 	hosts := strings.Split(o.Serve, ":")
 	ip := strings.TrimSpace(hosts[0])
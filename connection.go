@@ -0,0 +1,89 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nirvana
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/caicloud/nirvana/errors"
+	"github.com/caicloud/nirvana/service"
+)
+
+var tooManyConnections = errors.ServiceUnavailable.Build("Nirvana:TooManyConnections", "server has more than ${limit} connections, retry later")
+
+type contextKeyConnection struct{}
+
+// connectionLimiter limits the number of concurrent connections of a server.
+// Requests of connections beyond the limit are rejected with 503, and their
+// connections are closed after responses.
+type connectionLimiter struct {
+	limit   int
+	handler http.Handler
+
+	lock   sync.Mutex
+	active int
+	// rejected contains connections beyond the limit.
+	rejected map[net.Conn]bool
+}
+
+// limitConnections makes server reject connections beyond limit.
+func limitConnections(server *http.Server, limit int) {
+	l := &connectionLimiter{
+		limit:    limit,
+		handler:  server.Handler,
+		rejected: map[net.Conn]bool{},
+	}
+	server.Handler = l
+	server.ConnState = l.track
+	server.ConnContext = func(ctx context.Context, conn net.Conn) context.Context {
+		return context.WithValue(ctx, contextKeyConnection{}, conn)
+	}
+}
+
+// track counts connections by their states.
+func (l *connectionLimiter) track(conn net.Conn, state http.ConnState) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	switch state {
+	case http.StateNew:
+		l.active++
+		if l.active > l.limit {
+			l.rejected[conn] = true
+		}
+	case http.StateHijacked, http.StateClosed:
+		l.active--
+		delete(l.rejected, conn)
+	}
+}
+
+// ServeHTTP rejects requests of connections beyond the limit.
+func (l *connectionLimiter) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	conn, _ := req.Context().Value(contextKeyConnection{}).(net.Conn)
+	l.lock.Lock()
+	rejected := l.rejected[conn]
+	l.lock.Unlock()
+	if !rejected {
+		l.handler.ServeHTTP(resp, req)
+		return
+	}
+	resp.Header().Set("Connection", "close")
+	// Errors of writing responses can't be handled here.
+	_ = service.WriteError(service.NewHTTPContext(resp, req), service.AllProducers(), tooManyConnections.Error(l.limit))
+}
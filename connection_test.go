@@ -0,0 +1,160 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nirvana
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// start starts an http server with the config of c and returns its address.
+func start(c *Config) (string, func()) {
+	server := httptest.NewUnstartedServer(nil)
+	s := c.httpServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		_, _ = io.WriteString(resp, "ok")
+	}))
+	s.Addr = ""
+	server.Config = s
+	server.Start()
+	return server.Listener.Addr().String(), server.Close
+}
+
+// get sends a request on conn and returns the status code and body.
+func get(t *testing.T, conn net.Conn) (int, string) {
+	if _, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: test\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestMaxConnections(t *testing.T) {
+	addr, stop := start(NewConfig().Configure(MaxConnections(2)))
+	defer stop()
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+
+	first, second := dial(), dial()
+	defer second.Close()
+	for _, conn := range []net.Conn{first, second} {
+		if code, body := get(t, conn); code != http.StatusOK || body != "ok" {
+			t.Fatalf("Connection within the limit should be served: %d %s", code, body)
+		}
+	}
+	third := dial()
+	code, body := get(t, third)
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("Connection beyond the limit should be rejected: %d %s", code, body)
+	}
+	// The rejected connection is closed by the server.
+	_ = third.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := third.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("Rejected connection should be closed: %v", err)
+	}
+	third.Close()
+
+	// Keep-alive connections are still served.
+	if code, _ := get(t, second); code != http.StatusOK {
+		t.Fatalf("Connection within the limit should be kept: %d", code)
+	}
+	// Closed connections release the limit.
+	first.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn := dial()
+		code, _ := get(t, conn)
+		conn.Close()
+		if code == http.StatusOK {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Closed connections should release the limit: %d", code)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServerTimeouts(t *testing.T) {
+	c := NewConfig().Configure(
+		ReadTimeout(time.Second),
+		ReadHeaderTimeout(50*time.Millisecond),
+		WriteTimeout(2*time.Second),
+		IdleTimeout(3*time.Second),
+	)
+	s := c.httpServer(http.NotFoundHandler())
+	if s.ReadTimeout != time.Second || s.ReadHeaderTimeout != 50*time.Millisecond ||
+		s.WriteTimeout != 2*time.Second || s.IdleTimeout != 3*time.Second {
+		t.Fatalf("Timeouts are not applied: %+v", s)
+	}
+	if s.ConnState != nil {
+		t.Fatal("Connections should not be tracked without limit")
+	}
+
+	addr, stop := start(c)
+	defer stop()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// The server closes the connection whose headers are not complete in time.
+	if _, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: test\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	begin := time.Now()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	data, _ := ioutil.ReadAll(conn)
+	if elapsed := time.Since(begin); elapsed > time.Second {
+		t.Fatalf("Read header timeout is not applied, the connection is closed after %v: %q", elapsed, data)
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	addr, stop := start(NewConfig().Configure(KeepAlive(false)))
+	defer stop()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if code, _ := get(t, conn); code != http.StatusOK {
+		t.Fatalf("Unexpected status code: %d", code)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("Connection should be closed without keep-alive: %v", err)
+	}
+}
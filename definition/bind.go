@@ -0,0 +1,236 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package definition
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// BindTo returns parameters which bind a request to a struct, so the function
+// takes the struct as one argument instead of declaring every parameter. v is a
+// value of the argument type, a struct or a pointer to struct:
+//  type ListRequest struct {
+//      Namespace string `source:"Path,namespace"`
+//      Page      int    `source:"query" name:"page" validate:"min=1"`
+//      Order     string `source:"Query,order,default=asc" validate:"enum=asc|desc"`
+//      Body      *Filter `source:"Body"`
+//  }
+//  Parameters: definition.BindTo(&ListRequest{}),
+// Fields are generated by tag "source" like Auto parameters (see AutoParameterFor).
+//
+// Tag "validate" contains comma-separated rules of a field:
+//  min=N, max=N  the numeric value must be in the range (NumericRangeOperator)
+//  enum=A|B|C    the value must be one of the values (EnumOperator)
+// Rules work on fields of nested structs too, such as fields of a body, and the
+// fields are named like "body.limit" in errors. Rules are checked after the
+// struct is generated. It panics if v is not a struct or a pointer to struct, or
+// a rule is invalid.
+func BindTo(v interface{}) []Parameter {
+	typ := reflect.TypeOf(v)
+	structType := typ
+	if structType != nil && structType.Kind() == reflect.Ptr {
+		structType = structType.Elem()
+	}
+	if structType == nil || structType.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("Parameter v of BindTo has type %v, but want a struct or a pointer to struct", typ))
+	}
+	var rules []fieldRule
+	collectRules(structType, nil, "", false, map[reflect.Type]bool{}, &rules)
+	p := AutoParameterFor("")
+	if len(rules) > 0 {
+		p.Operators = []Operator{NewOperator(validatorKind, typ, typ, func(ctx context.Context, field string, object interface{}) (interface{}, error) {
+			value := reflect.ValueOf(object)
+			for _, rule := range rules {
+				if err := rule.check(ctx, value); err != nil {
+					return nil, err
+				}
+			}
+			return object, nil
+		})}
+	}
+	return []Parameter{p}
+}
+
+// fieldRule contains operators of a field with tag "validate".
+type fieldRule struct {
+	index     []int
+	name      string
+	operators []Operator
+}
+
+// check runs operators on the field of value. Fields behind nil pointers are skipped.
+func (r *fieldRule) check(ctx context.Context, value reflect.Value) error {
+	for _, i := range r.index {
+		for value.Kind() == reflect.Ptr {
+			if value.IsNil() {
+				return nil
+			}
+			value = value.Elem()
+		}
+		value = value.Field(i)
+	}
+	object := value.Interface()
+	var err error
+	for _, operator := range r.operators {
+		if object, err = operator.Operate(ctx, r.name, object); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectRules walks fields of typ and collects rules. Fields in structs of source
+// fields are named by their source names and JSON names.
+func collectRules(typ reflect.Type, index []int, prefix string, nested bool, visiting map[reflect.Type]bool, rules *[]fieldRule) {
+	if visiting[typ] {
+		return
+	}
+	visiting[typ] = true
+	defer delete(visiting, typ)
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.PkgPath != "" && !(field.Anonymous && field.Type.Kind() == reflect.Struct) {
+			// Exported fields of unexported embedded structs are still accessible.
+			continue
+		}
+		fieldIndex := append(append(make([]int, 0, len(index)+1), index...), i)
+		name, isSource := bindName(field, prefix, nested)
+		if tag := field.Tag.Get("validate"); tag != "" {
+			*rules = append(*rules, fieldRule{
+				index:     fieldIndex,
+				name:      name,
+				operators: validateOperators(field, tag),
+			})
+		}
+		elem := field.Type
+		for elem.Kind() == reflect.Ptr {
+			elem = elem.Elem()
+		}
+		if elem.Kind() != reflect.Struct {
+			continue
+		}
+		switch {
+		case isSource || nested:
+			collectRules(elem, fieldIndex, name+".", true, visiting, rules)
+		default:
+			// Fields of nested structs are promoted.
+			collectRules(elem, fieldIndex, prefix, false, visiting, rules)
+		}
+	}
+}
+
+// bindName returns the name of field in errors, and whether it's a source field.
+func bindName(field reflect.StructField, prefix string, nested bool) (string, bool) {
+	if nested {
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			name = field.Name
+		}
+		return prefix + name, false
+	}
+	tag := field.Tag.Get("source")
+	if tag == "" {
+		return field.Name, false
+	}
+	parts := strings.Split(tag, ",")
+	name := ""
+	if len(parts) >= 2 {
+		name = strings.TrimSpace(parts[1])
+	}
+	if name == "" {
+		name = field.Tag.Get("name")
+	}
+	if name == "" {
+		name = strings.ToLower(strings.TrimSpace(parts[0]))
+	}
+	return name, true
+}
+
+// validateOperators creates operators from tag "validate" of field.
+func validateOperators(field reflect.StructField, tag string) []Operator {
+	min, max := math.Inf(-1), math.Inf(1)
+	hasRange := false
+	var operators []Operator
+	for _, rule := range strings.Split(tag, ",") {
+		rule = strings.TrimSpace(rule)
+		index := strings.IndexByte(rule, '=')
+		if index <= 0 {
+			panic(fmt.Sprintf("Rule %q of field %s is invalid", rule, field.Name))
+		}
+		key, value := rule[:index], rule[index+1:]
+		switch key {
+		case "min", "max":
+			number, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				panic(fmt.Sprintf("Rule %q of field %s is invalid: %v", rule, field.Name, err))
+			}
+			if key == "min" {
+				min = number
+			} else {
+				max = number
+			}
+			hasRange = true
+		case "enum":
+			var values []interface{}
+			for _, v := range strings.Split(value, "|") {
+				values = append(values, enumValue(field, v))
+			}
+			operators = append(operators, EnumOperator(values...))
+		default:
+			panic(fmt.Sprintf("Rule %q of field %s is unknown", rule, field.Name))
+		}
+	}
+	if hasRange {
+		operator := NumericRangeOperator(min, max)
+		if err := operator.(AdaptiveOperator).Accept(field.Type); err != nil {
+			panic(fmt.Sprintf("Rule min and max of field %s are invalid: %v", field.Name, err))
+		}
+		operators = append([]Operator{operator}, operators...)
+	}
+	return operators
+}
+
+// enumValue converts value to the type of field.
+func enumValue(field reflect.StructField, value string) interface{} {
+	result := reflect.New(field.Type).Elem()
+	var err error
+	switch field.Type.Kind() {
+	case reflect.String:
+		result.SetString(value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		if n, err = strconv.ParseInt(value, 10, 64); err == nil {
+			result.SetInt(n)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var n uint64
+		if n, err = strconv.ParseUint(value, 10, 64); err == nil {
+			result.SetUint(n)
+		}
+	default:
+		err = fmt.Errorf("%v is not a string or an integer", field.Type)
+	}
+	if err != nil {
+		panic(fmt.Sprintf("Enum value %q of field %s is invalid: %v", value, field.Name, err))
+	}
+	return result.Interface()
+}
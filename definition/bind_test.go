@@ -0,0 +1,105 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package definition
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/caicloud/nirvana/errors"
+)
+
+type bindFilter struct {
+	Limit int    `json:"limit" validate:"max=100"`
+	Kind  string `json:"kind,omitempty" validate:"enum=a|b"`
+}
+
+type bindPaging struct {
+	Page int `source:"query" name:"page" validate:"min=1"`
+}
+
+type bindRequest struct {
+	bindPaging
+	Name  string      `source:"Path,name"`
+	Level uint        `source:"Header,X-Level" validate:"min=1,max=3,enum=1|3"`
+	Body  *bindFilter `source:"Body"`
+}
+
+func TestBindTo(t *testing.T) {
+	ps := BindTo(&bindRequest{})
+	if len(ps) != 1 || ps[0].Source != Auto || len(ps[0].Operators) != 1 {
+		t.Fatalf("Parameters are wrong: %+v", ps)
+	}
+	op := ps[0].Operators[0]
+	if typ := reflect.TypeOf(&bindRequest{}); op.In() != typ || op.Out() != typ {
+		t.Fatalf("Operator has wrong types: %v -> %v", op.In(), op.Out())
+	}
+	valid := &bindRequest{bindPaging: bindPaging{Page: 1}, Level: 3, Body: &bindFilter{Limit: 100, Kind: "a"}}
+	if v, err := op.Operate(context.Background(), "", valid); err != nil || v != valid {
+		t.Fatalf("Valid request is rejected: %v %v", v, err)
+	}
+	// Rules of nil bodies are skipped.
+	if _, err := op.Operate(context.Background(), "", &bindRequest{bindPaging: bindPaging{Page: 2}, Level: 1}); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		request *bindRequest
+		factory errors.Factory
+		field   string
+	}{
+		{&bindRequest{Level: 1}, outOfRange, "page"},
+		{&bindRequest{bindPaging: bindPaging{Page: 1}, Level: 2}, unexpectedEnum, "X-Level"},
+		{&bindRequest{bindPaging: bindPaging{Page: 1}, Level: 4}, outOfRange, "X-Level"},
+		{&bindRequest{bindPaging: bindPaging{Page: 1}, Level: 1, Body: &bindFilter{Limit: 101, Kind: "a"}}, outOfRange, "body.limit"},
+		{&bindRequest{bindPaging: bindPaging{Page: 1}, Level: 1, Body: &bindFilter{Kind: "c"}}, unexpectedEnum, "body.kind"},
+	} {
+		_, err := op.Operate(context.Background(), "", c.request)
+		if !c.factory.Derived(err) || !strings.Contains(err.Error(), "'"+c.field+"'") {
+			t.Fatalf("Request %+v should be rejected for field %s: %v", c.request, c.field, err)
+		}
+	}
+
+	if ps := BindTo(bindPaging{}); len(ps) != 1 || ps[0].Operators[0].In() != reflect.TypeOf(bindPaging{}) {
+		t.Fatalf("Parameters of struct are wrong: %+v", ps)
+	}
+	if ps := BindTo(struct{ A int }{}); len(ps[0].Operators) != 0 {
+		t.Fatalf("Struct without rules should not have operators: %+v", ps)
+	}
+	for _, v := range []interface{}{
+		1,
+		struct {
+			A string `validate:"min=1"`
+		}{},
+		struct {
+			A float64 `validate:"enum=1.5"`
+		}{},
+		struct {
+			A int `validate:"size=1"`
+		}{},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("BindTo(%T) should panic", v)
+				}
+			}()
+			BindTo(v)
+		}()
+	}
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package definition
+
+import (
+	"strings"
+
+	"github.com/caicloud/nirvana/errors"
+)
+
+var (
+	noDescriptorPath = errors.InternalServerError.Build("Nirvana:Definition:NoDescriptorPath", "descriptor under '${parent}' has no path")
+	emptyDescriptor  = errors.InternalServerError.Build("Nirvana:Definition:EmptyDescriptor", "descriptor '${path}' has no definitions, middlewares or children")
+)
+
+// DescriptorBuilder builds a Descriptor by method chaining:
+//  desc, err := definition.NewDescriptor("/api/v1").
+//      Consumes(definition.MIMEJSON).
+//      Produces(definition.MIMEJSON).
+//      Child(
+//          definition.NewDescriptor("/users").Definitions(listUsers, createUser),
+//      ).
+//      Build()
+// Builders only fill fields of Descriptor, so descriptors from builders are the
+// same as hand-written ones.
+type DescriptorBuilder struct {
+	descriptor Descriptor
+	children   []*DescriptorBuilder
+}
+
+// NewDescriptor creates a descriptor builder for path.
+func NewDescriptor(path string) *DescriptorBuilder {
+	return &DescriptorBuilder{
+		descriptor: Descriptor{Path: path},
+	}
+}
+
+// Consumes sets content types which definitions can consume.
+func (b *DescriptorBuilder) Consumes(types ...string) *DescriptorBuilder {
+	b.descriptor.Consumes = append([]string(nil), types...)
+	return b
+}
+
+// Produces sets content types which definitions can produce.
+func (b *DescriptorBuilder) Produces(types ...string) *DescriptorBuilder {
+	b.descriptor.Produces = append([]string(nil), types...)
+	return b
+}
+
+// Tags sets tags of definitions.
+func (b *DescriptorBuilder) Tags(tags ...string) *DescriptorBuilder {
+	b.descriptor.Tags = append([]string(nil), tags...)
+	return b
+}
+
+// Description sets the description of path.
+func (b *DescriptorBuilder) Description(description string) *DescriptorBuilder {
+	b.descriptor.Description = description
+	return b
+}
+
+// Middlewares appends path middlewares.
+func (b *DescriptorBuilder) Middlewares(middlewares ...Middleware) *DescriptorBuilder {
+	b.descriptor.Middlewares = append(b.descriptor.Middlewares, middlewares...)
+	return b
+}
+
+// Definition appends a simple definition for function. Like SimpleDescriptor,
+// the definition has no parameters and results. Use Definitions for others.
+func (b *DescriptorBuilder) Definition(method Method, function interface{}) *DescriptorBuilder {
+	return b.Definitions(Definition{
+		Method:   method,
+		Function: function,
+	})
+}
+
+// Definitions appends definitions.
+func (b *DescriptorBuilder) Definitions(definitions ...Definition) *DescriptorBuilder {
+	b.descriptor.Definitions = append(b.descriptor.Definitions, definitions...)
+	return b
+}
+
+// Child appends child descriptors. Children are built with their parent.
+func (b *DescriptorBuilder) Child(children ...*DescriptorBuilder) *DescriptorBuilder {
+	b.children = append(b.children, children...)
+	return b
+}
+
+// Build builds a descriptor. Every descriptor in the tree must have a path, and
+// must have one definition, middleware or child at least.
+func (b *DescriptorBuilder) Build() (Descriptor, error) {
+	return b.build("")
+}
+
+func (b *DescriptorBuilder) build(parent string) (Descriptor, error) {
+	descriptor := b.descriptor
+	if descriptor.Path == "" {
+		return Descriptor{}, noDescriptorPath.Error(parent)
+	}
+	path := strings.TrimRight(parent, "/") + "/" + strings.Trim(descriptor.Path, "/")
+	if len(descriptor.Definitions) <= 0 && len(descriptor.Middlewares) <= 0 && len(b.children) <= 0 {
+		return Descriptor{}, emptyDescriptor.Error(path)
+	}
+	// Copy slices so that later calls of builder don't change the descriptor.
+	descriptor.Middlewares = append([]Middleware(nil), descriptor.Middlewares...)
+	descriptor.Definitions = append([]Definition(nil), descriptor.Definitions...)
+	for _, child := range b.children {
+		c, err := child.build(path)
+		if err != nil {
+			return Descriptor{}, err
+		}
+		descriptor.Children = append(descriptor.Children, c)
+	}
+	return descriptor, nil
+}
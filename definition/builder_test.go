@@ -0,0 +1,101 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package definition
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestDescriptorBuilder(t *testing.T) {
+	list := func(ctx context.Context) ([]string, error) { return nil, nil }
+	get := func(ctx context.Context, name string) (string, error) { return name, nil }
+	middleware := func(ctx context.Context, chain Chain) error { return chain.Continue(ctx) }
+	getDefinition := Definition{
+		Method:     Get,
+		Function:   get,
+		Parameters: []Parameter{PathParameterFor("name", "")},
+		Results:    DataErrorResults(""),
+	}
+
+	builder := NewDescriptor("/api/v1").
+		Consumes(MIMEAll).
+		Produces(MIMEJSON).
+		Tags("users").
+		Middlewares(middleware).
+		Child(
+			NewDescriptor("/users").
+				Description("users").
+				Definition(List, list).
+				Child(NewDescriptor("{name}").Definitions(getDefinition)),
+		)
+	desc, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Descriptor{
+		Path:        "/api/v1",
+		Consumes:    []string{MIMEAll},
+		Produces:    []string{MIMEJSON},
+		Tags:        []string{"users"},
+		Middlewares: []Middleware{middleware},
+		Children: []Descriptor{
+			{
+				Path:        "/users",
+				Description: "users",
+				Definitions: []Definition{
+					{
+						Method:   List,
+						Function: list,
+					},
+				},
+				Children: []Descriptor{
+					{
+						Path:        "{name}",
+						Definitions: []Definition{getDefinition},
+					},
+				},
+			},
+		},
+	}
+	// Functions can't be compared by reflect.DeepEqual, but their addresses are
+	// printed by %#v.
+	if got, want := fmt.Sprintf("%#v", desc), fmt.Sprintf("%#v", want); got != want {
+		t.Fatalf("Descriptor from builder is not the same as hand-written one:\n%s\n%s", got, want)
+	}
+
+	builder.Definition(Create, list)
+	if len(desc.Definitions) != 0 {
+		t.Fatalf("Built descriptor should not be changed by builder: %+v", desc.Definitions)
+	}
+
+	for _, b := range []*DescriptorBuilder{
+		NewDescriptor("").Definition(Get, get),
+		NewDescriptor("/api").Consumes(MIMEJSON),
+		NewDescriptor("/api").Child(NewDescriptor("")),
+		NewDescriptor("/api").Child(NewDescriptor("/v1").Child(NewDescriptor("/users"))),
+	} {
+		if _, err := b.Build(); err == nil {
+			t.Fatalf("Invalid descriptor should not be built: %+v", b.descriptor)
+		}
+	}
+	_, err = NewDescriptor("/api").Child(NewDescriptor("/v1").Child(NewDescriptor("/users"))).Build()
+	if !emptyDescriptor.Derived(err) || err.Error() != "descriptor '/api/v1/users' has no definitions, middlewares or children" {
+		t.Fatalf("Error should contain the full path: %v", err)
+	}
+}
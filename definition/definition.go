@@ -18,7 +18,11 @@ package definition
 
 import (
 	"context"
+	"io"
 	"reflect"
+	"time"
+
+	"github.com/caicloud/nirvana/errors"
 )
 
 // Chain contains all subsequent actions.
@@ -70,6 +74,31 @@ type Operator interface {
 	Operate(ctx context.Context, field string, object interface{}) (interface{}, error)
 }
 
+// ErrResponseWritten is returned by operators which have written the response by
+// themselves, such as a caching operator writes a cached body. Nirvana stops
+// handling the request without writing anything else:
+//  - For parameter operators, subsequent operators and parameters are skipped
+//    and the function is not called.
+//  - For result and response operators, subsequent operators and results are
+//    skipped, and no result is written.
+// Middlewares receive a nil error. The operator must write the status code by
+// http.ResponseWriter from service.HTTPContextFrom(ctx), or the request fails
+// with a no response error.
+var ErrResponseWritten = errors.InternalServerError.Build("Nirvana:Definition:ResponseWritten", "response has been written by operator").Error()
+
+// Converter converts raw values of a parameter to the type which operators and
+// function accept. A parameter with a converter skips the default type conversion
+// of its source, so a query like "ids=1,2,3" can be bound to an []int directly.
+// Operators of the parameter run after the converter and receive its result.
+type Converter interface {
+	// Source returns the source of raw values. It must be the same as the source
+	// of the parameter. Only Path, Query, Header and Form are supported.
+	Source() Source
+	// Convert converts raw values to an object. It is not called when the
+	// parameter is absent in request, in which case the default value is used.
+	Convert(ctx context.Context, raw []string) (interface{}, error)
+}
+
 // Method is an alternative of HTTP method. It's more clearer than HTTP method.
 // A definition method binds a certain HTTP method and a success status code.
 type Method string
@@ -99,6 +128,18 @@ const (
 	AsyncPatch Method = "AsyncPatch"
 	// AsyncDelete binds to http.MethodDelete and code http.StatusAccepted(202).
 	AsyncDelete Method = "AsyncDelete"
+	// WebSocket binds to http.MethodGet and code http.StatusSwitchingProtocols(101).
+	// It handles websocket handshakes. The function receives a connection from
+	// WebSocketParameterFor() and returns only an error, like:
+	//  func(ctx context.Context, room string, conn *service.WebSocketConn) error
+	// Middlewares and parameters work on the handshake request as usual, so
+	// authentication and parameter errors are normal responses. The connection is
+	// upgraded when the function reads or writes it for the first time, and the
+	// function can still return an error response before that. After upgrade, the
+	// context is cancelled when the connection is closed (such as the client
+	// goes away), and the connection is closed when the function returns. An
+	// error returned after upgrade closes the connection with status 1011.
+	WebSocket Method = "WebSocket"
 )
 
 // Source indicates which place a value is from.
@@ -111,6 +152,8 @@ const (
 	Query Source = "Query"
 	// Header means value is from request header.
 	Header Source = "Header"
+	// Cookie means value is from request cookies. URL-encoded values are decoded.
+	Cookie Source = "Cookie"
 	// Form means value is from request body and content type must be
 	// "application/x-www-form-urlencoded" and "multipart/form-data".
 	Form Source = "Form"
@@ -119,7 +162,12 @@ const (
 	File Source = "File"
 	// Body means value is from request body.
 	Body Source = "Body"
+	// RawBody means value is the unparsed request body. Consumers are not used,
+	// so the value can be used to verify signatures of request body.
+	RawBody Source = "RawBody"
 	// Auto identifies a struct and generate field values by field tag.
+	// If the request context has a value of the same type (see service.WithAutoValue),
+	// the value is injected instead.
 	//
 	// Tag name is "source". Its value format is "Source,Name".
 	//
@@ -146,8 +194,56 @@ const (
 	// An error occurs indicates that there is no data to return. So the
 	// error should be treated as data and be writed back to client.
 	Error Destination = "Error"
+	// Stream means result is an io.Reader, a receive channel or an Iterator.
+	// Chunks from the result will be written and flushed to the body of response
+	// as soon as they arrive.
+	Stream Destination = "Stream"
+	// Attachment means result is a file to download. The result must be a
+	// FileContent or *FileContent. Its content is written to the body of response
+	// with "Content-Disposition: attachment". It's named differently from the File
+	// source to avoid ambiguity.
+	Attachment Destination = "Attachment"
+	// Redirect means result is a redirection. The result must be a Redirection or
+	// *Redirection. Its location is written to header "Location" with the status
+	// code of redirection, and the body of response is empty.
+	Redirect Destination = "Redirect"
 )
 
+// Iterator yields items of a stream result one by one. Next returns io.EOF when
+// there are no more items. Next is not called again after the request context
+// is done, so the iteration stops when the client goes away.
+type Iterator interface {
+	Next(ctx context.Context) (interface{}, error)
+}
+
+// FileContent describes a file for Attachment destination.
+type FileContent struct {
+	// Name is the file name for downloading. It's set to the "filename" parameter
+	// of "Content-Disposition" header.
+	Name string
+	// ContentType is the content type of the file. If it's empty, the type is
+	// detected by the extension of Name, else "application/octet-stream" is used.
+	ContentType string
+	// Content is the content of the file. If it implements io.Seeker, range
+	// requests are supported. If it implements io.Closer, it's closed after
+	// written.
+	Content io.Reader
+	// ModTime is the modification time of the file. If it's not zero, it's used
+	// to set "Last-Modified" header and check conditional requests. It only
+	// works when Content implements io.Seeker.
+	ModTime time.Time
+}
+
+// Redirection describes a redirection for Redirect destination.
+type Redirection struct {
+	// Location is the target URL. It's written to header "Location" as is, so
+	// it can be a relative reference.
+	Location string
+	// Code is the status code of redirection. It must be 301, 302, 303, 307 or
+	// 308. Zero means 302.
+	Code int
+}
+
 // Example is just an example.
 type Example struct {
 	// Description describes the example.
@@ -163,6 +259,11 @@ type Parameter struct {
 	// Name is the name to get value from a request.
 	// ex. a query name, a header key, etc.
 	Name string
+	// Aliases are other names to get value from a request. They're useful to
+	// rename a parameter without breaking clients. The value is from the first
+	// present one of Name and Aliases, so Name wins if several are present.
+	// Only parameters from Path, Query, Header, Cookie and Form can have aliases.
+	Aliases []string
 	// Default value is used when a request does not provide a value
 	// for the parameter.
 	Default interface{}
@@ -194,6 +295,27 @@ type Parameter struct {
 	//    // do something else
 	// }
 	Optional bool
+	// Required indicates that clients must provide the parameter. If a required parameter
+	// is absent in request, nirvana returns a bad request error before operators run and
+	// the default value is not used. The flag takes precedence over the type of function
+	// parameter, so a required int or string is checked even though its zero value is valid.
+	// A parameter can't be both required and optional. If neither is set, a parameter is
+	// only rejected when its final value is nil (see Optional).
+	Required bool
+	// Converter converts raw values from request instead of the default type
+	// conversion. It takes effect before operators. For Path, Query, Header and
+	// Form parameters, nirvana converts strings to the type of function parameter
+	// (or the in type of the first operator) by built-in converters. A converter
+	// replaces the built-in one and its result must be assignable to that type.
+	Converter Converter
+	// Separator splits every raw value of the parameter into elements of a slice,
+	// so a query like "ids=1,2,3" can be bound to an []int64. Elements are trimmed
+	// of surrounding white space, empty elements are dropped and others are converted
+	// to the element type by built-in converters. Values of repeated parameters are
+	// concatenated, and a value without elements is absent. Only parameters from
+	// Path, Query, Header and Form can have a separator, and a parameter can't have
+	// both a separator and a converter.
+	Separator string
 }
 
 // Result describes how to handle a result from function results.
@@ -207,6 +329,12 @@ type Result struct {
 	Operators []Operator
 	// Description describes the result.
 	Description string
+	// Field is the key of the result in the response object. It only works
+	// with Data destination. If a definition has more than one Data result,
+	// every Data result must have an unique field and the values of these
+	// results are assembled into an object like:
+	//  {"field1": value1, "field2": value2}
+	Field string
 }
 
 // Definition defines an API handler.
@@ -220,7 +348,8 @@ type Definition struct {
 	// It will override parent descriptor's produces.
 	Produces []string
 	// Tags indicates tags of the API handler.
-	// It will override parent descriptor's tags.
+	// It will override parent descriptor's tags. API documents group operations
+	// into sections by tags, like "tags" in OpenAPI.
 	Tags []string
 	// ErrorProduces is used to generate data for error. If this field is empty,
 	// it means that this field equals to Produces.
@@ -233,10 +362,78 @@ type Definition struct {
 	Parameters []Parameter
 	// Results describes function return values.
 	Results []Result
+	// SuccessCode is the status code of responses when the function returns no
+	// error. Zero means the default code of Method, such as 201 for Create. If it's
+	// 204 (No Content) or 304 (Not Modified), the response has no body even if
+	// the function returns data. Errors are still written with their own codes.
+	// Return a Meta result to set headers like "Location" for 201.
+	SuccessCode int
 	// Summary is a one-line brief description of this definition.
 	Summary string
 	// Description describes the API handler.
 	Description string
 	// Examples contains many examples for the API handler.
 	Examples []Example
+	// Timeout is the deadline of the API handler. If it's set, the context of the
+	// handler is cancelled after the duration and a gateway timeout error is returned
+	// to client. Results returned after the deadline are discarded.
+	Timeout time.Duration
+	// MaxBodySize limits the size of request body in bytes. If a request body is
+	// larger than the limit, a request entity too large error (413) is returned
+	// before the body is fully decoded. Zero means using the server default
+	// (see service.DefaultMaxBodySize) and a negative value means unlimited.
+	MaxBodySize int64
+	// AccumulateErrors makes the handler check all parameters before returning
+	// bad request errors. If it's false, the first invalid parameter fails the
+	// request. If it's true, bad request errors (400) of all parameters are
+	// collected and returned as a service.ParameterErrors. Other errors, such as
+	// a request entity too large error, still fail the request immediately.
+	AccumulateErrors bool
+	// ResponseOperators operate the data of response after the function returns
+	// successfully and before the data is serialized. They run after operators of
+	// Data results. If the definition has more than one Data result, they receive
+	// the assembled object (map[string]interface{}). They are useful to wrap data
+	// into a standard envelope. They don't run if the function returns an error.
+	ResponseOperators []Operator
+	// Deprecated marks the API handler as deprecated. Responses of a deprecated
+	// handler have "Deprecation: true" and "Warning: 299 - <message>" headers.
+	// The body and status code of responses are not affected.
+	Deprecated bool
+	// DeprecationMessage is the message in "Warning" header. "Deprecated API" is
+	// used if it's empty.
+	DeprecationMessage string
+	// PartialResponse enables partial responses. If it's enabled, clients can
+	// request a part of data by query like "?fields=id,name,address.city". Data is
+	// pruned after ResponseOperators, and pruned data consists of generic JSON
+	// values, so it should be produced as JSON. Zero value means disabled.
+	PartialResponse PartialResponseMode
+	// Security lists security requirements of the API handler. A request must pass
+	// one of the requirements before parameters are generated, or it's rejected
+	// with the error of authentication, such as 401. Schemes of requirements must be
+	// registered by service.RegisterSecurityScheme. Empty means the API handler is
+	// public. Requests are authenticated after all middlewares (including
+	// Middlewares of the definition), just before parameters are generated, so
+	// middlewares such as rate limits, logs and metrics see rejected requests.
+	// Middlewares which write responses by themselves (such as caches) must not
+	// serve requests with credentials, like middlewares/cache does. Requirements
+	// are also described in API documents.
+	Security []SecurityRequirement
+	// Middlewares contains middlewares which only run for requests of the API
+	// handler, after middlewares of descriptors. They're useful for features which
+	// are opt-in per API handler, such as idempotency keys.
+	Middlewares []Middleware
 }
+
+// PartialResponseMode describes how to handle partial responses.
+type PartialResponseMode string
+
+const (
+	// NoPartialResponse disables partial responses. Query "fields" is ignored.
+	NoPartialResponse PartialResponseMode = ""
+	// LenientPartialResponse enables partial responses and ignores fields which
+	// are not in data.
+	LenientPartialResponse PartialResponseMode = "Lenient"
+	// StrictPartialResponse enables partial responses and returns a bad request
+	// error (400) if a field is not in data.
+	StrictPartialResponse PartialResponseMode = "Strict"
+)
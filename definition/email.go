@@ -0,0 +1,128 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package definition
+
+import (
+	"context"
+	"strings"
+)
+
+const (
+	// maxEmailLength is the maximum length of a forward path (RFC 5321) minus
+	// the angle brackets.
+	maxEmailLength = 254
+	// maxLocalPartLength is the maximum length of the local part of an address.
+	maxLocalPartLength = 64
+	// maxLabelLength is the maximum length of a label of a domain.
+	maxLabelLength = 63
+)
+
+// EmailOperator creates a converter for string values of email addresses. A
+// value is trimmed of surrounding white space and its domain is converted to
+// lower case. The local part keeps its case because it may be case-sensitive.
+//
+// Addresses are validated by a subset of RFC 5322 which rejects obviously
+// invalid values without being too strict: the local part is a dot-atom (such
+// as "john.doe+tag") or a quoted string (such as "\"john doe\""), and the domain
+// is a host name with at least two labels. Display names, comments and domain
+// literals are not accepted. An invalid value is rejected with a bad request
+// error.
+func EmailOperator() Operator {
+	return NewOperator(converterKind, stringType, stringType, func(ctx context.Context, field string, object interface{}) (interface{}, error) {
+		value := object.(string)
+		email, ok := normalizeEmail(strings.TrimSpace(value))
+		if !ok {
+			return nil, invalidEmail.Error(value, field)
+		}
+		return email, nil
+	})
+}
+
+// normalizeEmail validates an email address and lowers the case of its domain.
+func normalizeEmail(value string) (string, bool) {
+	index := strings.LastIndexByte(value, '@')
+	if index <= 0 || len(value) > maxEmailLength {
+		return "", false
+	}
+	local, domain := value[:index], strings.ToLower(value[index+1:])
+	if len(local) > maxLocalPartLength || !validLocalPart(local) || !validDomain(domain) {
+		return "", false
+	}
+	return local + "@" + domain, true
+}
+
+// validLocalPart checks whether local is a dot-atom or a quoted string.
+func validLocalPart(local string) bool {
+	if len(local) >= 2 && local[0] == '"' && local[len(local)-1] == '"' {
+		quoted := local[1 : len(local)-1]
+		for i := 0; i < len(quoted); i++ {
+			c := quoted[i]
+			switch {
+			case c == '\\':
+				// A quoted pair escapes the next printable character.
+				i++
+				if i >= len(quoted) || quoted[i] < ' ' || quoted[i] > '~' {
+					return false
+				}
+			case c == '"' || c < ' ' || c > '~':
+				return false
+			}
+		}
+		return true
+	}
+	for _, atom := range strings.Split(local, ".") {
+		if atom == "" {
+			return false
+		}
+		for i := 0; i < len(atom); i++ {
+			if !isAtomText(atom[i]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// isAtomText checks whether c is an atext character of RFC 5322.
+func isAtomText(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-/=?^_`{|}~", c) >= 0
+}
+
+// validDomain checks whether domain is a host name with at least two labels. The
+// last label must not be numeric, so IP addresses are rejected.
+func validDomain(domain string) bool {
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return false
+	}
+	for _, label := range labels {
+		if label == "" || len(label) > maxLabelLength || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			if !('a' <= c && c <= 'z') && !('0' <= c && c <= '9') && c != '-' {
+				return false
+			}
+		}
+	}
+	return strings.Trim(labels[len(labels)-1], "0123456789") != ""
+}
@@ -19,7 +19,9 @@ package definition
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"reflect"
+	"strconv"
 )
 
 // MIME types
@@ -30,15 +32,22 @@ const (
 	// It only can exist in request header "Accept".
 	// In most time, it locate at the last element of "Accept".
 	// It's default value if client have not set "Accept" header.
-	MIMEAll         = "*/*"
-	MIMENone        = ""
-	MIMEText        = "text/plain"
-	MIMEHTML        = "text/html"
-	MIMEJSON        = "application/json"
-	MIMEXML         = "application/xml"
-	MIMEOctetStream = "application/octet-stream"
-	MIMEURLEncoded  = "application/x-www-form-urlencoded"
-	MIMEFormData    = "multipart/form-data"
+	MIMEAll            = "*/*"
+	MIMENone           = ""
+	MIMEText           = "text/plain"
+	MIMEHTML           = "text/html"
+	MIMECSV            = "text/csv"
+	MIMEJSON           = "application/json"
+	MIMEXML            = "application/xml"
+	MIMEYAML           = "application/yaml"
+	MIMEMsgpack        = "application/msgpack"
+	MIMEEventStream    = "text/event-stream"
+	MIMENDJSON         = "application/x-ndjson"
+	MIMEProblemJSON    = "application/problem+json"
+	MIMEOctetStream    = "application/octet-stream"
+	MIMEURLEncoded     = "application/x-www-form-urlencoded"
+	MIMEFormData       = "multipart/form-data"
+	MIMEMultipartMixed = "multipart/mixed"
 )
 
 // DataErrorResults returns the most frequently-used results.
@@ -76,11 +85,25 @@ func QueryParameterFor(name string, description string, operators ...Operator) P
 	return ParameterFor(Query, name, description, operators...)
 }
 
+// QueryPrefixParameterFor creates a query parameter which collects all query
+// parameters whose names start with prefix into a map keyed by the rest of their
+// names. For example, the parameter of prefix "filter." for map[string]string
+// gets {"status": "active"} from query "filter.status=active". An empty prefix
+// matches all query parameters.
+func QueryPrefixParameterFor(prefix string, description string, operators ...Operator) Parameter {
+	return ParameterFor(Query, prefix, description, operators...)
+}
+
 // HeaderParameterFor creates a header parameter
 func HeaderParameterFor(name string, description string, operators ...Operator) Parameter {
 	return ParameterFor(Header, name, description, operators...)
 }
 
+// CookieParameterFor creates a cookie parameter
+func CookieParameterFor(name string, description string, operators ...Operator) Parameter {
+	return ParameterFor(Cookie, name, description, operators...)
+}
+
 // FormParameterFor creates a form parameter
 func FormParameterFor(name string, description string, operators ...Operator) Parameter {
 	return ParameterFor(Form, name, description, operators...)
@@ -96,16 +119,77 @@ func BodyParameterFor(description string, operators ...Operator) Parameter {
 	return ParameterFor(Body, "", description, operators...)
 }
 
+// RawBodyParameterFor creates a parameter of the unparsed request body. Its type
+// can be []byte, string, io.Reader or io.ReadCloser. The body is buffered when
+// it's read, so a definition can have a raw body parameter and a body parameter
+// which parses the same body. The raw body parameter must be placed before body,
+// form and file parameters, which read the request body.
+func RawBodyParameterFor(description string, operators ...Operator) Parameter {
+	return ParameterFor(RawBody, "", description, operators...)
+}
+
 // PrefabParameterFor creates a prefab parameter
 func PrefabParameterFor(name string, description string, operators ...Operator) Parameter {
 	return ParameterFor(Prefab, name, description, operators...)
 }
 
-// AutoParameterFor creates an auto parameter
+// WebSocketParameterFor creates a parameter of websocket connection for WebSocket
+// definitions. Its type is *service.WebSocketConn.
+func WebSocketParameterFor(description string) Parameter {
+	return ParameterFor(Prefab, "websocket", description)
+}
+
+// AutoParameterFor creates an auto parameter. The parameter is resolved from a context
+// value of the same type, or generated from struct fields with tag "source".
 func AutoParameterFor(description string, operators ...Operator) Parameter {
 	return ParameterFor(Auto, "", description, operators...)
 }
 
+// AsRequired returns a copy of the parameter which is required.
+func (p Parameter) AsRequired() Parameter {
+	p.Required = true
+	p.Optional = false
+	return p
+}
+
+// AsOptional returns a copy of the parameter which is optional.
+func (p Parameter) AsOptional() Parameter {
+	p.Optional = true
+	p.Required = false
+	return p
+}
+
+// AsSeparated returns a copy of the parameter whose raw values are split by separator.
+func (p Parameter) AsSeparated(separator string) Parameter {
+	p.Separator = separator
+	return p
+}
+
+// AsCSV returns a copy of the parameter whose raw values are comma-separated lists.
+func (p Parameter) AsCSV() Parameter {
+	return p.AsSeparated(",")
+}
+
+// ConverterFor creates a converter for specified source by function.
+func ConverterFor(source Source, convert func(ctx context.Context, raw []string) (interface{}, error)) Converter {
+	return &converter{source, convert}
+}
+
+type converter struct {
+	source  Source
+	convert func(ctx context.Context, raw []string) (interface{}, error)
+}
+
+// Source returns the source of raw values.
+func (c *converter) Source() Source {
+	return c.source
+}
+
+// Convert converts raw values to an object.
+func (c *converter) Convert(ctx context.Context, raw []string) (interface{}, error) {
+	return c.convert(ctx, raw)
+}
+
 // ResultFor creates a simple result.
 func ResultFor(dest Destination, description string, operators ...Operator) Result {
 	return Result{
@@ -120,11 +204,194 @@ func MetaResultFor(description string, operators ...Operator) Result {
 	return ResultFor(Meta, description, operators...)
 }
 
+// Preload describes a resource which clients should preload. It's written as a
+// header like `Link: </app.js>; rel=preload; as=script`.
+type Preload struct {
+	// URL is the URL of resource.
+	URL string
+	// As is the type of resource, such as "script", "style", "font" and "image".
+	As string
+	// Type is the MIME type of resource. It's optional.
+	Type string
+	// CrossOrigin fetches the resource in CORS mode. It's required for fonts.
+	CrossOrigin bool
+	// NoPush disables HTTP/2 server push of the resource.
+	NoPush bool
+}
+
+// String returns the value of "Link" header for the resource.
+func (p Preload) String() string {
+	value := "<" + p.URL + ">; rel=preload"
+	if p.As != "" {
+		value += "; as=" + p.As
+	}
+	if p.Type != "" {
+		value += `; type="` + p.Type + `"`
+	}
+	if p.CrossOrigin {
+		value += "; crossorigin"
+	}
+	if p.NoPush {
+		value += "; nopush"
+	}
+	return value
+}
+
+var preloadsType = reflect.TypeOf([]Preload{})
+
+// PreloadResultFor creates a meta result for []Preload. Every resource becomes a
+// "Link" header, and it's pushed by HTTP/2 server push if the connection supports
+// it and NoPush is false. resources are always preloaded before the resources
+// returned by the function, so a function without dynamic resources can return nil.
+func PreloadResultFor(resources ...Preload) Result {
+	static := append([]Preload(nil), resources...)
+	return MetaResultFor("preload resources", NewOperator(converterKind, preloadsType, preloadsType,
+		func(ctx context.Context, field string, object interface{}) (interface{}, error) {
+			preloads, _ := object.([]Preload)
+			if len(static) <= 0 {
+				return preloads, nil
+			}
+			return append(append([]Preload(nil), static...), preloads...), nil
+		}))
+}
+
+// HeaderResultOperator creates an operator for meta results. It converts a struct
+// (or a pointer to struct) to http.Header, and every field with tag `header:"X-Foo"`
+// becomes a response header:
+//  type Meta struct {
+//      RequestID string   `header:"X-Request-Id"`
+//      Total     int      `header:"X-Total-Count"`
+//      Links     []string `header:"Link"`
+//  }
+// Fields can be string, int or []string, and a []string field emits repeated
+// headers. Fields without the tag, empty strings and nil pointers are skipped.
+// The operator rejects other types when the definition is registered.
+func HeaderResultOperator() Operator {
+	return &headerOperator{}
+}
+
+var headerType = reflect.TypeOf(http.Header{})
+
+type headerOperator struct{}
+
+// Kind indicates operator type.
+func (o *headerOperator) Kind() string {
+	return converterKind
+}
+
+// In returns the type of interface{}.
+func (o *headerOperator) In() reflect.Type {
+	return interfaceType
+}
+
+// Out returns the type of http.Header.
+func (o *headerOperator) Out() reflect.Type {
+	return headerType
+}
+
+// CheckIn checks whether typ is a struct and all tagged fields have valid types.
+func (o *headerOperator) CheckIn(typ reflect.Type) error {
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		return fmt.Errorf("%v is not a struct", typ)
+	}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if headerKey(field) == "" {
+			continue
+		}
+		switch {
+		case field.Type.Kind() == reflect.String, field.Type.Kind() == reflect.Int:
+		case field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.String:
+		default:
+			return fmt.Errorf("field %s has type %v but want string, int or []string", field.Name, field.Type)
+		}
+	}
+	return nil
+}
+
+// Operate converts a struct to http.Header.
+func (o *headerOperator) Operate(ctx context.Context, field string, object interface{}) (interface{}, error) {
+	value := reflect.ValueOf(object)
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return http.Header{}, nil
+		}
+		value = value.Elem()
+	}
+	if err := o.CheckIn(value.Type()); err != nil {
+		return nil, err
+	}
+	headers := http.Header{}
+	typ := value.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		key := headerKey(typ.Field(i))
+		if key == "" {
+			continue
+		}
+		fieldValue := value.Field(i)
+		switch fieldValue.Kind() {
+		case reflect.String:
+			if s := fieldValue.String(); s != "" {
+				headers.Add(key, s)
+			}
+		case reflect.Int:
+			headers.Add(key, strconv.FormatInt(fieldValue.Int(), 10))
+		case reflect.Slice:
+			for j := 0; j < fieldValue.Len(); j++ {
+				headers.Add(key, fieldValue.Index(j).String())
+			}
+		}
+	}
+	return headers, nil
+}
+
+// headerKey returns the header key in the tag of field. It returns an empty
+// string if the field is unexported or has no tag.
+func headerKey(field reflect.StructField) string {
+	if field.PkgPath != "" {
+		return ""
+	}
+	key := field.Tag.Get("header")
+	if key == "-" {
+		return ""
+	}
+	return key
+}
+
 // DataResultFor creates data result.
 func DataResultFor(description string, operators ...Operator) Result {
 	return ResultFor(Data, description, operators...)
 }
 
+// DataFieldResultFor creates data result with a field. The field is the key
+// of the result in response object.
+func DataFieldResultFor(field, description string, operators ...Operator) Result {
+	r := ResultFor(Data, description, operators...)
+	r.Field = field
+	return r
+}
+
+// StreamResultFor creates stream result.
+func StreamResultFor(description string, operators ...Operator) Result {
+	return ResultFor(Stream, description, operators...)
+}
+
+// FileResultFor creates attachment result. The result of function must be
+// a FileContent or *FileContent.
+func FileResultFor(description string, operators ...Operator) Result {
+	return ResultFor(Attachment, description, operators...)
+}
+
+// RedirectResultFor creates redirect result. The result of function must be
+// a Redirection or *Redirection. A nil *Redirection or an empty location
+// writes nothing, so other results (such as data) are written.
+func RedirectResultFor(description string, operators ...Operator) Result {
+	return ResultFor(Redirect, description, operators...)
+}
+
 // ErrorResult creates error result.
 func ErrorResult() Result {
 	return ResultFor(Error, "")
@@ -247,14 +514,20 @@ func (o *operatorRef) Operate(ctx context.Context, field string, object interfac
 // SimpleDescriptor creates a simple REST descriptor for handler.
 // The descriptor consumes all content types and produces all accept types.
 func SimpleDescriptor(method Method, path string, f interface{}) Descriptor {
+	return SimpleDescriptorWithContentTypes(method, path, []string{MIMEAll}, []string{MIMEAll}, f)
+}
+
+// SimpleDescriptorWithContentTypes creates a simple descriptor for handler.
+// The descriptor consumes and produces specified content types.
+func SimpleDescriptorWithContentTypes(method Method, path string, consumes, produces []string, f interface{}) Descriptor {
 	return Descriptor{
 		Path: path,
 		Definitions: []Definition{
 			{
 				Method:   method,
 				Function: f,
-				Consumes: []string{MIMEAll},
-				Produces: []string{MIMEAll},
+				Consumes: append([]string(nil), consumes...),
+				Produces: append([]string(nil), produces...),
 			},
 		},
 	}
@@ -263,13 +536,19 @@ func SimpleDescriptor(method Method, path string, f interface{}) Descriptor {
 // SimpleRPCDescriptor creates a simple RPC descriptor for handler.
 // The descriptor consumes all content types and produces all accept types.
 func SimpleRPCDescriptor(path string, f interface{}) RPCDescriptor {
+	return SimpleRPCDescriptorWithContentTypes(path, []string{MIMEAll}, []string{MIMEAll}, f)
+}
+
+// SimpleRPCDescriptorWithContentTypes creates a simple RPC descriptor for handler.
+// The descriptor consumes and produces specified content types.
+func SimpleRPCDescriptorWithContentTypes(path string, consumes, produces []string, f interface{}) RPCDescriptor {
 	return RPCDescriptor{
 		Path: path,
 		Actions: []RPCAction{
 			{
 				Function: f,
-				Consumes: []string{MIMEAll},
-				Produces: []string{MIMEAll},
+				Consumes: append([]string(nil), consumes...),
+				Produces: append([]string(nil), produces...),
 			},
 		},
 	}
@@ -34,3 +34,57 @@ func TestOperatorRefOperate(t *testing.T) {
 		t.Fatalf("Operate Result[0] not be nil")
 	}
 }
+
+func TestParameterRequirement(t *testing.T) {
+	p := QueryParameterFor("test", "").AsRequired()
+	if !p.Required || p.Optional {
+		t.Fatalf("AsRequired returns a wrong parameter: %+v", p)
+	}
+	p = p.AsOptional()
+	if p.Required || !p.Optional {
+		t.Fatalf("AsOptional returns a wrong parameter: %+v", p)
+	}
+}
+
+func TestSimpleDescriptorWithContentTypes(t *testing.T) {
+	types := []string{MIMEJSON}
+	d := SimpleDescriptorWithContentTypes(Get, "/", types, types, func() {})
+	types[0] = MIMEXML
+	def := d.Definitions[0]
+	if def.Method != Get || len(def.Consumes) != 1 || def.Consumes[0] != MIMEJSON || def.Produces[0] != MIMEJSON {
+		t.Fatalf("Descriptor has wrong content types: %+v", def)
+	}
+	rd := SimpleRPCDescriptorWithContentTypes("/", []string{MIMEJSON}, []string{MIMEXML}, func() {})
+	action := rd.Actions[0]
+	if action.Consumes[0] != MIMEJSON || action.Produces[0] != MIMEXML {
+		t.Fatalf("RPC descriptor has wrong content types: %+v", action)
+	}
+}
+
+func TestPreloadResultFor(t *testing.T) {
+	result := PreloadResultFor(Preload{URL: "/app.js", As: "script"})
+	if result.Destination != Meta || len(result.Operators) != 1 {
+		t.Fatalf("PreloadResultFor returns a wrong result: %+v", result)
+	}
+	v, err := result.Operators[0].Operate(context.Background(), "", []Preload{
+		{URL: "/app.css", As: "style", NoPush: true},
+		{URL: "/font.woff2", As: "font", Type: "font/woff2", CrossOrigin: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"</app.js>; rel=preload; as=script",
+		"</app.css>; rel=preload; as=style; nopush",
+		`</font.woff2>; rel=preload; as=font; type="font/woff2"; crossorigin`,
+	}
+	preloads := v.([]Preload)
+	if len(preloads) != len(want) {
+		t.Fatalf("Preloads should contain static resources: %v", preloads)
+	}
+	for i, p := range preloads {
+		if p.String() != want[i] {
+			t.Fatalf("Link of %s is %s, but want %s", p.URL, p.String(), want[i])
+		}
+	}
+}
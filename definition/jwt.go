@@ -0,0 +1,222 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package definition
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256" // Register SHA-256 for crypto.Hash.
+	_ "crypto/sha512" // Register SHA-384 and SHA-512 for crypto.Hash.
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/caicloud/nirvana/errors"
+)
+
+var (
+	missingToken      = errors.Unauthorized.Build("Nirvana:Definition:MissingToken", "field '${field}' has no token")
+	invalidToken      = errors.Unauthorized.Build("Nirvana:Definition:InvalidToken", "token of field '${field}' is invalid: ${reason}")
+	expiredToken      = errors.Unauthorized.Build("Nirvana:Definition:ExpiredToken", "token of field '${field}' is expired")
+	unexpectedJWTAlgo = errors.Unauthorized.Build("Nirvana:Definition:UnexpectedJWTAlgorithm", "algorithm ${alg} is not allowed")
+)
+
+// JWTHeader is the header of a JSON web token.
+type JWTHeader struct {
+	// Algorithm is the algorithm which signs the token, such as "HS256".
+	Algorithm string `json:"alg"`
+	// KeyID is a hint of the key which signs the token.
+	KeyID string `json:"kid,omitempty"`
+	// Type is the media type of the token.
+	Type string `json:"typ,omitempty"`
+}
+
+// JWTKeyFunc returns the key to verify a token by its header. The key must be
+// []byte for HS256, HS384 and HS512, *rsa.PublicKey for RS256, RS384 and RS512,
+// and *ecdsa.PublicKey for ES256, ES384 and ES512. If it returns an error, the
+// token is rejected with the error.
+type JWTKeyFunc func(ctx context.Context, header JWTHeader) (interface{}, error)
+
+// JWTKey returns a key func which only accepts tokens signed by algorithm alg.
+// Checking algorithms prevents clients from choosing how their tokens are verified.
+func JWTKey(alg string, key interface{}) JWTKeyFunc {
+	return func(ctx context.Context, header JWTHeader) (interface{}, error) {
+		if header.Algorithm != alg {
+			return nil, unexpectedJWTAlgo.Error(header.Algorithm)
+		}
+		return key, nil
+	}
+}
+
+var jwtHashes = map[string]crypto.Hash{
+	"256": crypto.SHA256,
+	"384": crypto.SHA384,
+	"512": crypto.SHA512,
+}
+
+// JWTClaimsOperator creates a converter which verifies a JSON web token and parses
+// its claims. The operator takes string values like "Bearer <token>" or "<token>",
+// so it's usually used with HeaderParameterFor("Authorization", ...). The claims
+// are unmarshaled from JSON into a value with the type of claims, which is the out
+// type of the operator. If claims is nil, the out type is map[string]interface{}.
+//
+// Tokens which are missing, malformed, signed with a wrong key, expired ("exp") or
+// not valid yet ("nbf") are rejected with an unauthorized error.
+func JWTClaimsOperator(keyFunc JWTKeyFunc, claims interface{}) Operator {
+	if keyFunc == nil {
+		panic("Parameter keyFunc in JWTClaimsOperator must not be nil")
+	}
+	out := reflect.TypeOf(claims)
+	if out == nil {
+		out = reflect.TypeOf(map[string]interface{}{})
+	}
+	return NewOperator(converterKind, stringType, out, func(ctx context.Context, field string, object interface{}) (interface{}, error) {
+		token := strings.TrimSpace(object.(string))
+		if len(token) > 7 && strings.EqualFold(token[:7], "Bearer ") {
+			token = strings.TrimSpace(token[7:])
+		}
+		if token == "" {
+			return nil, missingToken.Error(field)
+		}
+		header, payload, err := verifyJWT(ctx, token, keyFunc)
+		if err != nil {
+			if header == nil {
+				// The error is from keyFunc.
+				return nil, err
+			}
+			return nil, invalidToken.Error(field, err)
+		}
+		var registered struct {
+			Expiration *json.Number `json:"exp"`
+			NotBefore  *json.Number `json:"nbf"`
+		}
+		if err := json.Unmarshal(payload, &registered); err != nil {
+			return nil, invalidToken.Error(field, err)
+		}
+		now := time.Now().Unix()
+		if t, ok := numericDate(registered.Expiration); ok && now >= t {
+			return nil, expiredToken.Error(field)
+		}
+		if t, ok := numericDate(registered.NotBefore); ok && now < t {
+			return nil, invalidToken.Error(field, "token is not valid yet")
+		}
+		typ := out
+		if typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+		value := reflect.New(typ)
+		if err := json.Unmarshal(payload, value.Interface()); err != nil {
+			return nil, invalidToken.Error(field, err)
+		}
+		if out.Kind() == reflect.Ptr {
+			return value.Interface(), nil
+		}
+		return value.Elem().Interface(), nil
+	})
+}
+
+// numericDate converts a JSON number of seconds to unix time.
+func numericDate(n *json.Number) (int64, bool) {
+	if n == nil {
+		return 0, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+// verifyJWT verifies the signature of token and returns its header and payload.
+// If keyFunc fails, the header is nil and its error is returned.
+func verifyJWT(ctx context.Context, token string, keyFunc JWTKeyFunc) (*JWTHeader, []byte, error) {
+	header := &JWTHeader{}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return header, nil, fmt.Errorf("token must have 3 parts")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err == nil {
+		err = json.Unmarshal(data, header)
+	}
+	if err != nil {
+		return header, nil, fmt.Errorf("malformed header")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return header, nil, fmt.Errorf("malformed payload")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return header, nil, fmt.Errorf("malformed signature")
+	}
+	key, err := keyFunc(ctx, *header)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := verifyJWTSignature(header.Algorithm, key, parts[0]+"."+parts[1], signature); err != nil {
+		return header, nil, err
+	}
+	return header, payload, nil
+}
+
+// verifyJWTSignature verifies signature of input by algorithm alg.
+func verifyJWTSignature(alg string, key interface{}, input string, signature []byte) error {
+	var hash crypto.Hash
+	if len(alg) == 5 {
+		hash = jwtHashes[alg[2:]]
+	}
+	if hash == 0 {
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	h := hash.New()
+	_, _ = h.Write([]byte(input))
+	digest := h.Sum(nil)
+	valid := false
+	switch k := key.(type) {
+	case []byte:
+		if alg[:2] == "HS" {
+			mac := hmac.New(hash.New, k)
+			_, _ = mac.Write([]byte(input))
+			valid = hmac.Equal(signature, mac.Sum(nil))
+		}
+	case *rsa.PublicKey:
+		if alg[:2] == "RS" {
+			valid = rsa.VerifyPKCS1v15(k, hash, digest, signature) == nil
+		}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if alg[:2] == "ES" && len(signature) == 2*size {
+			r := new(big.Int).SetBytes(signature[:size])
+			s := new(big.Int).SetBytes(signature[size:])
+			valid = ecdsa.Verify(k, digest, r, s)
+		}
+	default:
+		return fmt.Errorf("key of type %T can't verify algorithm %q", key, alg)
+	}
+	if !valid {
+		return fmt.Errorf("signature is invalid")
+	}
+	return nil
+}
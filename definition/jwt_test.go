@@ -0,0 +1,144 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package definition
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caicloud/nirvana/errors"
+)
+
+type testClaims struct {
+	Subject string `json:"sub"`
+	Admin   bool   `json:"admin"`
+}
+
+func signJWT(t *testing.T, alg string, key interface{}, claims map[string]interface{}) string {
+	header, _ := json.Marshal(JWTHeader{Algorithm: alg, Type: "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(input))
+	var signature []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		_, _ = mac.Write([]byte(input))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		r, s, e := ecdsa.Sign(rand.Reader, k, digest[:])
+		signature = append(make([]byte, 32-len(r.Bytes()), 64), r.Bytes()...)
+		signature = append(signature, make([]byte, 32-len(s.Bytes()))...)
+		signature = append(signature, s.Bytes()...)
+		err = e
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestJWTClaimsOperator(t *testing.T) {
+	secret := []byte("secret")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Unix()
+	claims := map[string]interface{}{"sub": "alice", "admin": true, "exp": now + 60, "nbf": now - 60}
+	want := &testClaims{Subject: "alice", Admin: true}
+
+	tests := []struct {
+		alg     string
+		sign    interface{}
+		verify  interface{}
+		bearer  bool
+		claims  map[string]interface{}
+		factory errors.Factory
+	}{
+		{"HS256", secret, secret, true, claims, nil},
+		{"RS256", rsaKey, &rsaKey.PublicKey, false, claims, nil},
+		{"ES256", ecKey, &ecKey.PublicKey, true, claims, nil},
+		{"HS256", []byte("wrong"), secret, true, claims, invalidToken},
+		{"HS256", secret, secret, true, map[string]interface{}{"sub": "alice", "exp": now - 1}, expiredToken},
+		{"HS256", secret, secret, true, map[string]interface{}{"sub": "alice", "nbf": now + 60}, invalidToken},
+		// A key of another algorithm can't verify the token.
+		{"HS256", secret, &rsaKey.PublicKey, true, claims, invalidToken},
+	}
+	for i, test := range tests {
+		op := JWTClaimsOperator(func(ctx context.Context, header JWTHeader) (interface{}, error) {
+			return test.verify, nil
+		}, &testClaims{})
+		if op.Out() != reflect.TypeOf(&testClaims{}) {
+			t.Fatalf("Out type of operator should be the type of claims: %v", op.Out())
+		}
+		token := signJWT(t, test.alg, test.sign, test.claims)
+		if test.bearer {
+			token = "Bearer " + token
+		}
+		v, err := op.Operate(context.Background(), "Authorization", token)
+		if test.factory == nil {
+			if err != nil || !reflect.DeepEqual(v, want) {
+				t.Fatalf("Case %d: claims should be parsed: %+v %v", i, v, err)
+			}
+			continue
+		}
+		if !test.factory.Derived(err) {
+			t.Fatalf("Case %d: token should be rejected: %v", i, err)
+		}
+	}
+
+	op := JWTClaimsOperator(JWTKey("HS256", secret), nil)
+	token := signJWT(t, "HS256", secret, claims)
+	v, err := op.Operate(context.Background(), "Authorization", token)
+	if err != nil || v.(map[string]interface{})["sub"] != "alice" {
+		t.Fatalf("Claims should be parsed into a map: %v %v", v, err)
+	}
+	parts := strings.Split(token, ".")
+	tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin"}`)) + "." + parts[2]
+	for _, token := range []string{tampered, "", "Bearer ", "a.b", parts[0] + ".!." + parts[2]} {
+		_, err := op.Operate(context.Background(), "Authorization", token)
+		if e, ok := err.(interface{ Code() int }); !ok || e.Code() != http.StatusUnauthorized {
+			t.Fatalf("Token %q should be rejected with 401: %v", token, err)
+		}
+	}
+	if _, err := JWTClaimsOperator(JWTKey("RS256", &rsaKey.PublicKey), nil).Operate(context.Background(), "Authorization", token); !unexpectedJWTAlgo.Derived(err) {
+		t.Fatalf("Token with unexpected algorithm should be rejected: %v", err)
+	}
+}
@@ -0,0 +1,744 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package definition
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"math"
+	"mime/multipart"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/caicloud/nirvana/errors"
+)
+
+const (
+	// validatorKind is the kind of operators which only validate values.
+	validatorKind = "validator"
+	// converterKind is the kind of operators which modify values.
+	converterKind = "converter"
+	// pipelineKind is the kind of operators which combine other operators.
+	pipelineKind = "pipeline"
+)
+
+var (
+	unmatchedPattern = errors.BadRequest.Build("Nirvana:Definition:UnmatchedPattern", "value '${value}' of field '${field}' doesn't match pattern '${pattern}'")
+	unexpectedEnum   = errors.BadRequest.Build("Nirvana:Definition:UnexpectedEnum", "value '${value}' of field '${field}' is not one of ${values}")
+	outOfRange       = errors.BadRequest.Build("Nirvana:Definition:OutOfRange", "value ${value} of field '${field}' is out of range [${min}, ${max}]")
+	nonNumericValue  = errors.BadRequest.Build("Nirvana:Definition:NonNumericValue", "value of field '${field}' has type ${type} but want a numeric type")
+	nonSliceValue    = errors.BadRequest.Build("Nirvana:Definition:NonSliceValue", "value of field '${field}' has type ${type} but want a slice")
+	fileTooLarge     = errors.RequestEntityTooLarge.Build("Nirvana:Definition:FileTooLarge", "file '${file}' of field '${field}' has ${size} bytes but the limit is ${limit}")
+	filesTooLarge    = errors.RequestEntityTooLarge.Build("Nirvana:Definition:FilesTooLarge", "files of field '${field}' have ${size} bytes but the limit is ${limit}")
+	invalidBase64    = errors.BadRequest.Build("Nirvana:Definition:InvalidBase64", "value of field '${field}' is not valid base64")
+	invalidUUID      = errors.BadRequest.Build("Nirvana:Definition:InvalidUUID", "value '${value}' of field '${field}' is not a valid UUID")
+	nilUUID          = errors.BadRequest.Build("Nirvana:Definition:NilUUID", "value of field '${field}' must not be the nil UUID")
+	stringTooShort   = errors.BadRequest.Build("Nirvana:Definition:StringTooShort", "value of field '${field}' has ${length} characters but want at least ${min}")
+	stringTooLong    = errors.BadRequest.Build("Nirvana:Definition:StringTooLong", "value of field '${field}' has ${length} characters but want at most ${max}")
+	invalidEmail     = errors.BadRequest.Build("Nirvana:Definition:InvalidEmail", "value '${value}' of field '${field}' is not a valid email address")
+)
+
+var (
+	stringType      = reflect.TypeOf("")
+	bytesType       = reflect.TypeOf([]byte(nil))
+	interfaceType   = reflect.TypeOf((*interface{})(nil)).Elem()
+	fileHeadersType = reflect.TypeOf([]*multipart.FileHeader{})
+)
+
+// AdaptiveOperator is an optional interface for operators which can handle
+// values of several types and return values of the same type as input. In()
+// and Out() of adaptive operators should return the type of interface{}, and
+// the types of values around them are decided by neighbouring operators or
+// the function.
+type AdaptiveOperator interface {
+	Operator
+	// Accept checks whether the operator can handle values of the type.
+	Accept(typ reflect.Type) error
+}
+
+// InTypeChecker is an optional interface for operators which take values of
+// interface{} but only handle some types of them. CheckIn is called when the
+// operators of a definition are validated.
+type InTypeChecker interface {
+	// CheckIn checks whether the operator can handle values of the type.
+	CheckIn(typ reflect.Type) error
+}
+
+// InTypeOf returns the in type of an operator chain, which is the in type of
+// the first operator that is not adaptive. If all operators are adaptive, it
+// returns typ.
+func InTypeOf(operators []Operator, typ reflect.Type) reflect.Type {
+	for _, operator := range operators {
+		if _, ok := operator.(AdaptiveOperator); !ok {
+			return operator.In()
+		}
+	}
+	return typ
+}
+
+// OutTypeOf returns the out type of an operator chain, which is the out type
+// of the last operator that is not adaptive. If all operators are adaptive,
+// it returns typ.
+func OutTypeOf(operators []Operator, typ reflect.Type) reflect.Type {
+	for i := len(operators) - 1; i >= 0; i-- {
+		if _, ok := operators[i].(AdaptiveOperator); !ok {
+			return operators[i].Out()
+		}
+	}
+	return typ
+}
+
+// Constraints describes restrictions of values which are accepted by an operator.
+// They are used to generate API documents.
+type Constraints struct {
+	// Pattern is a regular expression which string values must match.
+	Pattern string
+	// Enum contains all acceptable values.
+	Enum []interface{}
+	// Minimum is the minimum of numeric values.
+	Minimum *float64
+	// Maximum is the maximum of numeric values.
+	Maximum *float64
+	// MinLength is the minimum length of string values.
+	MinLength *int64
+	// MaxLength is the maximum length of string values.
+	MaxLength *int64
+}
+
+// ConstraintsDescriber is an optional interface for operators which can
+// describe their constraints.
+type ConstraintsDescriber interface {
+	// Constraints returns constraints of the operator.
+	Constraints() Constraints
+}
+
+// WithConstraints attaches constraints to an operator.
+func WithConstraints(operator Operator, constraints Constraints) Operator {
+	return &constrainedOperator{operator, constraints}
+}
+
+// ConstraintsFor merges constraints of operators. If several operators have
+// the same kind of constraint, the last one wins.
+func ConstraintsFor(operators ...Operator) Constraints {
+	result := Constraints{}
+	for _, operator := range operators {
+		describer, ok := operator.(ConstraintsDescriber)
+		if !ok {
+			continue
+		}
+		c := describer.Constraints()
+		if c.Pattern != "" {
+			result.Pattern = c.Pattern
+		}
+		if len(c.Enum) > 0 {
+			result.Enum = c.Enum
+		}
+		if c.Minimum != nil {
+			result.Minimum = c.Minimum
+		}
+		if c.Maximum != nil {
+			result.Maximum = c.Maximum
+		}
+		if c.MinLength != nil {
+			result.MinLength = c.MinLength
+		}
+		if c.MaxLength != nil {
+			result.MaxLength = c.MaxLength
+		}
+	}
+	return result
+}
+
+type constrainedOperator struct {
+	Operator
+	constraints Constraints
+}
+
+// Constraints returns constraints of the operator.
+func (o *constrainedOperator) Constraints() Constraints {
+	return o.constraints
+}
+
+// RegexpOperator creates a validator for string values. The pattern is compiled
+// at construction and a value which doesn't match the pattern is rejected with
+// a bad request error. It panics if the pattern is invalid.
+func RegexpOperator(pattern string) Operator {
+	r, err := regexp.Compile(pattern)
+	if err != nil {
+		panic(fmt.Sprintf("Pattern %q in RegexpOperator is invalid: %v", pattern, err))
+	}
+	operator := NewOperator(validatorKind, stringType, stringType, func(ctx context.Context, field string, object interface{}) (interface{}, error) {
+		value := object.(string)
+		if !r.MatchString(value) {
+			return nil, unmatchedPattern.Error(value, field, pattern)
+		}
+		return value, nil
+	})
+	return WithConstraints(operator, Constraints{Pattern: pattern})
+}
+
+// DefaultValueOperator creates a converter which replaces zero values with
+// defaultValue. The in and out types of the operator are the type of
+// defaultValue, so it can only be used for parameters of the same type.
+//
+// An absent parameter reaches operators as a zero value. So the operator
+// can't tell an absent parameter from an explicit zero value sent by client,
+// and both of them are replaced. It panics if defaultValue is nil.
+func DefaultValueOperator(defaultValue interface{}) Operator {
+	if defaultValue == nil {
+		panic("Parameter defaultValue in DefaultValueOperator must not be nil")
+	}
+	typ := reflect.TypeOf(defaultValue)
+	return NewOperator(converterKind, typ, typ, func(ctx context.Context, field string, object interface{}) (interface{}, error) {
+		if object == nil || reflect.ValueOf(object).IsZero() {
+			return defaultValue, nil
+		}
+		return object, nil
+	})
+}
+
+// EnumOperator creates a validator which only accepts values in the list. Values are
+// compared by reflect.DeepEqual, so strings are case sensitive and an empty string is
+// rejected unless it's in the list. The in and out types of the operator are the type
+// of values. It panics if values is empty or values have different types.
+func EnumOperator(values ...interface{}) Operator {
+	if len(values) <= 0 {
+		panic("EnumOperator must have one value at least")
+	}
+	typ := reflect.TypeOf(values[0])
+	if typ == nil {
+		panic("Values in EnumOperator must not be nil")
+	}
+	for i, v := range values {
+		if reflect.TypeOf(v) != typ {
+			panic(fmt.Sprintf("Value %d in EnumOperator has type %v, but want %v", i, reflect.TypeOf(v), typ))
+		}
+	}
+	enum := make([]interface{}, len(values))
+	copy(enum, values)
+	operator := NewOperator(validatorKind, typ, typ, func(ctx context.Context, field string, object interface{}) (interface{}, error) {
+		for _, v := range enum {
+			if reflect.DeepEqual(v, object) {
+				return object, nil
+			}
+		}
+		return nil, unexpectedEnum.Error(object, field, enum)
+	})
+	return WithConstraints(operator, Constraints{Enum: enum})
+}
+
+// TrimSpaceOperator creates a converter which removes leading and trailing
+// white space from string values. White space is defined by Unicode, so
+// characters like U+3000 (ideographic space) are removed too.
+func TrimSpaceOperator() Operator {
+	return NewOperator(converterKind, stringType, stringType, func(ctx context.Context, field string, object interface{}) (interface{}, error) {
+		return strings.TrimSpace(object.(string)), nil
+	})
+}
+
+// CaseMode describes how CaseOperator changes letter case of strings.
+type CaseMode string
+
+const (
+	// LowerCase maps all letters to lower case.
+	LowerCase CaseMode = "lower"
+	// UpperCase maps all letters to upper case.
+	UpperCase CaseMode = "upper"
+	// TitleCase maps the first letter of every word to title case and other
+	// letters to lower case. Words are separated by white space.
+	TitleCase CaseMode = "title"
+)
+
+// CaseOperator creates a converter which changes letter case of string values.
+// Letters are mapped by Unicode rules, so non-ASCII letters are handled as well.
+// It panics if mode is unknown.
+func CaseOperator(mode CaseMode) Operator {
+	var convert func(string) string
+	switch mode {
+	case LowerCase:
+		convert = strings.ToLower
+	case UpperCase:
+		convert = strings.ToUpper
+	case TitleCase:
+		convert = toTitle
+	default:
+		panic(fmt.Sprintf("Mode %q in CaseOperator is unknown", mode))
+	}
+	return NewOperator(converterKind, stringType, stringType, func(ctx context.Context, field string, object interface{}) (interface{}, error) {
+		return convert(object.(string)), nil
+	})
+}
+
+// toTitle maps the first letter of every word to title case and other letters
+// to lower case.
+func toTitle(value string) string {
+	start := true
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			start = true
+			return r
+		}
+		if start {
+			start = false
+			return unicode.ToTitle(r)
+		}
+		return unicode.ToLower(r)
+	}, value)
+}
+
+// HTMLEscapeOperator creates a converter which escapes special characters of
+// HTML ("<", ">", "&", "'" and '"') in string values, so they can be rendered in
+// HTML as text. Entities in values are unescaped before escaping, so escaped
+// values are not escaped twice, and the operator is idempotent. Characters
+// other than the special characters (including multibyte characters) are kept.
+//
+// It's a defense in depth for values which may be rendered in HTML. It's not a
+// replacement for encoding outputs by their contexts, such as attributes,
+// scripts and URLs.
+func HTMLEscapeOperator() Operator {
+	return NewOperator(converterKind, stringType, stringType, func(ctx context.Context, field string, object interface{}) (interface{}, error) {
+		return html.EscapeString(html.UnescapeString(object.(string))), nil
+	})
+}
+
+// StripTagsOperator creates a converter which removes HTML tags and comments
+// from string values, such as "<b>bold</b>" to "bold". A "<" which doesn't start
+// a tag is kept, such as "a < b", and an unclosed tag is removed with the rest
+// of the value. Entities are not unescaped, so compose HTMLEscapeOperator after
+// the operator if values may be rendered in HTML. Like HTMLEscapeOperator, it's
+// a defense in depth rather than a replacement for encoding outputs.
+func StripTagsOperator() Operator {
+	return NewOperator(converterKind, stringType, stringType, func(ctx context.Context, field string, object interface{}) (interface{}, error) {
+		return stripTags(object.(string)), nil
+	})
+}
+
+// stripTags removes tags and comments from value.
+func stripTags(value string) string {
+	builder := strings.Builder{}
+	for {
+		start := indexTag(value)
+		if start < 0 {
+			builder.WriteString(value)
+			return builder.String()
+		}
+		builder.WriteString(value[:start])
+		end := tagEnd(value[start:])
+		if end < 0 {
+			return builder.String()
+		}
+		value = value[start+end:]
+	}
+}
+
+// indexTag returns the index of the first tag or comment in value, or -1.
+func indexTag(value string) int {
+	offset := 0
+	for {
+		index := strings.IndexByte(value[offset:], '<')
+		if index < 0 || offset+index+1 >= len(value) {
+			return -1
+		}
+		index += offset
+		c := value[index+1]
+		if c == '/' || c == '!' || c == '?' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') {
+			return index
+		}
+		offset = index + 1
+	}
+}
+
+// tagEnd returns the length of the tag or comment at the beginning of value.
+// ">" in quoted attribute values doesn't end tags. It returns -1 if the tag
+// is not closed.
+func tagEnd(value string) int {
+	if strings.HasPrefix(value, "<!--") {
+		index := strings.Index(value[4:], "-->")
+		if index < 0 {
+			return -1
+		}
+		return index + 7
+	}
+	var quote byte
+	for i := 1; i < len(value); i++ {
+		switch c := value[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '>':
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// FileSizeOperator creates a validator for []*multipart.FileHeader values of file
+// parameters. maxFileSize limits the size of every file and maxTotalSize limits the
+// sum of sizes of all files. A zero or negative limit means unlimited. Values out of
+// limits are rejected with a request entity too large error.
+//
+// Files are checked after the whole form is parsed, and parts of files beyond 32MB
+// of memory are stored in temporary files. So the operator doesn't stop clients
+// from uploading large bodies. Limit bodies by Definition.MaxBodySize as well.
+func FileSizeOperator(maxFileSize, maxTotalSize int64) Operator {
+	return NewOperator(validatorKind, fileHeadersType, fileHeadersType, func(ctx context.Context, field string, object interface{}) (interface{}, error) {
+		headers := object.([]*multipart.FileHeader)
+		total := int64(0)
+		for _, h := range headers {
+			if maxFileSize > 0 && h.Size > maxFileSize {
+				return nil, fileTooLarge.Error(h.Filename, field, h.Size, maxFileSize)
+			}
+			total += h.Size
+		}
+		if maxTotalSize > 0 && total > maxTotalSize {
+			return nil, filesTooLarge.Error(field, total, maxTotalSize)
+		}
+		return headers, nil
+	})
+}
+
+// Base64DecodeOperator creates a converter which decodes base64 string values to
+// []byte. Both standard and URL-safe alphabets are accepted, with or without
+// padding. Leading and trailing white space is ignored. Invalid values are rejected
+// with a bad request error.
+func Base64DecodeOperator() Operator {
+	return NewOperator(converterKind, stringType, bytesType, func(ctx context.Context, field string, object interface{}) (interface{}, error) {
+		data, ok := decodeBase64(strings.TrimSpace(object.(string)))
+		if !ok {
+			return nil, invalidBase64.Error(field)
+		}
+		return data, nil
+	})
+}
+
+// decodeBase64 decodes value by all base64 encodings.
+func decodeBase64(value string) ([]byte, bool) {
+	encodings := []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding}
+	for _, encoding := range encodings {
+		if data, err := encoding.DecodeString(value); err == nil {
+			return data, true
+		}
+	}
+	return nil, false
+}
+
+// PipelineOperator combines operators into one operator. The operators run in
+// sequence and the output of an operator is the input of the next one:
+//  value -> ops[0] -> ops[1] -> ... -> ops[N] -> result
+// It panics if ops is empty or the out type of an operator is not assignable
+// to the in type of the next one. If all operators are adaptive, the pipeline
+// is adaptive too.
+func PipelineOperator(ops ...Operator) Operator {
+	if len(ops) <= 0 {
+		panic("PipelineOperator must have one operator at least")
+	}
+	var typ reflect.Type
+	last := -1
+	for i, op := range ops {
+		if adaptive, ok := op.(AdaptiveOperator); ok {
+			if typ != nil {
+				if err := adaptive.Accept(typ); err != nil {
+					panic(fmt.Sprintf("The out type %v of operator %d in PipelineOperator is not acceptable for operator %d: %v",
+						typ, last, i, err))
+				}
+			}
+			continue
+		}
+		if typ != nil && !typ.AssignableTo(op.In()) {
+			panic(fmt.Sprintf("The out type %v of operator %d in PipelineOperator is not assignable to the in type %v of operator %d",
+				typ, last, op.In(), i))
+		}
+		typ = op.Out()
+		last = i
+	}
+	p := &pipeline{
+		ops: make([]Operator, len(ops)),
+	}
+	copy(p.ops, ops)
+	if typ == nil {
+		return &adaptivePipeline{p}
+	}
+	return p
+}
+
+type pipeline struct {
+	ops []Operator
+}
+
+// Kind indicates operator type.
+func (p *pipeline) Kind() string {
+	return pipelineKind
+}
+
+// In returns the in type of the first operator which is not adaptive.
+func (p *pipeline) In() reflect.Type {
+	return InTypeOf(p.ops, interfaceType)
+}
+
+// Out returns the out type of the last operator which is not adaptive.
+func (p *pipeline) Out() reflect.Type {
+	return OutTypeOf(p.ops, interfaceType)
+}
+
+// Operate runs all operators in sequence. It stops at the first error.
+func (p *pipeline) Operate(ctx context.Context, field string, object interface{}) (interface{}, error) {
+	var err error
+	for _, op := range p.ops {
+		object, err = op.Operate(ctx, field, object)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return object, nil
+}
+
+// Constraints returns merged constraints of all operators.
+func (p *pipeline) Constraints() Constraints {
+	return ConstraintsFor(p.ops...)
+}
+
+// adaptivePipeline is a pipeline which only contains adaptive operators.
+type adaptivePipeline struct {
+	*pipeline
+}
+
+// Accept checks whether all operators can handle values of the type.
+func (p *adaptivePipeline) Accept(typ reflect.Type) error {
+	for _, op := range p.ops {
+		if err := op.(AdaptiveOperator).Accept(typ); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NumericRangeOperator creates an adaptive validator for numeric values, it works
+// with all kinds of int, uint and float. A value out of [min, max] is rejected with
+// a bad request error. Use math.Inf(-1) or math.Inf(1) to express open-ended ranges.
+// It panics if min is greater than max.
+func NumericRangeOperator(min, max float64) Operator {
+	if min > max {
+		panic(fmt.Sprintf("Min %v of NumericRangeOperator is greater than max %v", min, max))
+	}
+	constraints := Constraints{}
+	if !math.IsInf(min, 0) {
+		constraints.Minimum = &min
+	}
+	if !math.IsInf(max, 0) {
+		constraints.Maximum = &max
+	}
+	return &numericRange{min: min, max: max, constraints: constraints}
+}
+
+type numericRange struct {
+	min         float64
+	max         float64
+	constraints Constraints
+}
+
+// Kind indicates operator type.
+func (o *numericRange) Kind() string {
+	return validatorKind
+}
+
+// In returns the type of interface{}.
+func (o *numericRange) In() reflect.Type {
+	return interfaceType
+}
+
+// Out returns the type of interface{}.
+func (o *numericRange) Out() reflect.Type {
+	return interfaceType
+}
+
+// Accept checks whether typ is a numeric type.
+func (o *numericRange) Accept(typ reflect.Type) error {
+	if !isNumeric(typ.Kind()) {
+		return fmt.Errorf("%v is not a numeric type", typ)
+	}
+	return nil
+}
+
+// Constraints returns the bounds of the range.
+func (o *numericRange) Constraints() Constraints {
+	return o.constraints
+}
+
+// Operate checks whether the value is in range.
+func (o *numericRange) Operate(ctx context.Context, field string, object interface{}) (interface{}, error) {
+	value := reflect.ValueOf(object)
+	if !value.IsValid() || !isNumeric(value.Kind()) {
+		return nil, nonNumericValue.Error(field, reflect.TypeOf(object))
+	}
+	var number float64
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		number = float64(value.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		number = float64(value.Uint())
+	default:
+		number = value.Float()
+	}
+	if number < o.min || number > o.max {
+		return nil, outOfRange.Error(object, field, o.min, o.max)
+	}
+	return object, nil
+}
+
+func isNumeric(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// StringLengthOperator creates a validator for string values. The length of a
+// value is the number of runes rather than bytes, and a value whose length is out
+// of [min, max] is rejected with a bad request error. A negative max means no
+// upper bound. It panics if min is negative or greater than a non-negative max.
+func StringLengthOperator(min, max int) Operator {
+	if min < 0 {
+		panic(fmt.Sprintf("Min %d of StringLengthOperator is negative", min))
+	}
+	if max >= 0 && min > max {
+		panic(fmt.Sprintf("Min %d of StringLengthOperator is greater than max %d", min, max))
+	}
+	operator := NewOperator(validatorKind, stringType, stringType, func(ctx context.Context, field string, object interface{}) (interface{}, error) {
+		value := object.(string)
+		length := utf8.RuneCountInString(value)
+		if length < min {
+			return nil, stringTooShort.Error(field, length, min)
+		}
+		if max >= 0 && length > max {
+			return nil, stringTooLong.Error(field, length, max)
+		}
+		return value, nil
+	})
+	constraints := Constraints{}
+	if min > 0 {
+		minLength := int64(min)
+		constraints.MinLength = &minLength
+	}
+	if max >= 0 {
+		maxLength := int64(max)
+		constraints.MaxLength = &maxLength
+	}
+	return WithConstraints(operator, constraints)
+}
+
+// BatchOperator creates an operator which applies elementOp to every element of
+// a slice. The field name of an element is qualified by its index, such as
+// "ids[3]", and the first failure stops the operator and is returned as is.
+//
+// The in and out types of the operator are slices of the in and out types of
+// elementOp. If elementOp is adaptive, the batch operator is adaptive too and
+// accepts slices whose element type is accepted by elementOp.
+func BatchOperator(elementOp Operator) Operator {
+	if elementOp == nil {
+		panic("Parameter elementOp in BatchOperator must not be nil")
+	}
+	b := &batch{elementOp: elementOp}
+	if _, ok := elementOp.(AdaptiveOperator); ok {
+		return &adaptiveBatch{b}
+	}
+	return b
+}
+
+type batch struct {
+	elementOp Operator
+}
+
+// Kind indicates operator type.
+func (o *batch) Kind() string {
+	return o.elementOp.Kind()
+}
+
+// In returns the slice type of the in type of element operator.
+func (o *batch) In() reflect.Type {
+	return reflect.SliceOf(o.elementOp.In())
+}
+
+// Out returns the slice type of the out type of element operator.
+func (o *batch) Out() reflect.Type {
+	return reflect.SliceOf(o.elementOp.Out())
+}
+
+// Operate applies element operator to all elements.
+func (o *batch) Operate(ctx context.Context, field string, object interface{}) (interface{}, error) {
+	return o.operate(ctx, field, object, o.Out())
+}
+
+func (o *batch) operate(ctx context.Context, field string, object interface{}, out reflect.Type) (interface{}, error) {
+	value := reflect.ValueOf(object)
+	if !value.IsValid() || value.Kind() != reflect.Slice {
+		return nil, nonSliceValue.Error(field, reflect.TypeOf(object))
+	}
+	if value.IsNil() {
+		return reflect.Zero(out).Interface(), nil
+	}
+	result := reflect.MakeSlice(out, value.Len(), value.Len())
+	for i := 0; i < value.Len(); i++ {
+		element, err := o.elementOp.Operate(ctx, fmt.Sprintf("%s[%d]", field, i), value.Index(i).Interface())
+		if err != nil {
+			return nil, err
+		}
+		if element == nil {
+			continue
+		}
+		result.Index(i).Set(reflect.ValueOf(element))
+	}
+	return result.Interface(), nil
+}
+
+// adaptiveBatch is a batch operator with an adaptive element operator.
+type adaptiveBatch struct {
+	*batch
+}
+
+// In returns the type of interface{}.
+func (o *adaptiveBatch) In() reflect.Type {
+	return interfaceType
+}
+
+// Out returns the type of interface{}.
+func (o *adaptiveBatch) Out() reflect.Type {
+	return interfaceType
+}
+
+// Accept checks whether typ is a slice and its element type is acceptable.
+func (o *adaptiveBatch) Accept(typ reflect.Type) error {
+	if typ.Kind() != reflect.Slice {
+		return fmt.Errorf("%v is not a slice", typ)
+	}
+	return o.elementOp.(AdaptiveOperator).Accept(typ.Elem())
+}
+
+// Operate applies element operator to all elements and keeps the type of slice.
+func (o *adaptiveBatch) Operate(ctx context.Context, field string, object interface{}) (interface{}, error) {
+	return o.operate(ctx, field, object, reflect.TypeOf(object))
+}
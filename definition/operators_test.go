@@ -0,0 +1,640 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package definition
+
+import (
+	"context"
+	"math"
+	"mime/multipart"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/caicloud/nirvana/errors"
+)
+
+func TestRegexpOperator(t *testing.T) {
+	op := RegexpOperator(`^[a-z0-9]{4,8}$`)
+	if op.In() != stringType || op.Out() != stringType {
+		t.Fatalf("RegexpOperator has wrong types: %v -> %v", op.In(), op.Out())
+	}
+	v, err := op.Operate(context.Background(), "id", "abc123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "abc123" {
+		t.Fatalf("RegexpOperator changed the value: %v", v)
+	}
+	_, err = op.Operate(context.Background(), "id", "ABC")
+	if err == nil {
+		t.Fatal("RegexpOperator should reject unmatched value")
+	}
+	if !unmatchedPattern.Derived(err) || !strings.Contains(err.Error(), "'id'") {
+		t.Fatalf("RegexpOperator returns an unexpected error: %v", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("RegexpOperator should panic with an invalid pattern")
+		}
+	}()
+	RegexpOperator(`[a-z`)
+}
+
+func TestDefaultValueOperator(t *testing.T) {
+	op := DefaultValueOperator(20)
+	if op.In() != reflect.TypeOf(0) || op.Out() != reflect.TypeOf(0) {
+		t.Fatalf("DefaultValueOperator has wrong types: %v -> %v", op.In(), op.Out())
+	}
+	tests := []struct {
+		op    Operator
+		value interface{}
+		want  interface{}
+	}{
+		{op, 0, 20},
+		{op, 5, 5},
+		{DefaultValueOperator("asc"), "", "asc"},
+		{DefaultValueOperator("asc"), "desc", "desc"},
+		{DefaultValueOperator([]string{"a"}), []string(nil), []string{"a"}},
+	}
+	for _, test := range tests {
+		v, err := test.op.Operate(context.Background(), "test", test.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(v, test.want) {
+			t.Fatalf("DefaultValueOperator returns %v for %v, but want %v", v, test.value, test.want)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("DefaultValueOperator should panic with nil default value")
+		}
+	}()
+	DefaultValueOperator(nil)
+}
+
+func TestConstraintsFor(t *testing.T) {
+	min := 1.0
+	op := WithConstraints(DefaultValueOperator(""), Constraints{Minimum: &min, Pattern: "a"})
+	c := ConstraintsFor(op, DefaultValueOperator(""), RegexpOperator("b"))
+	if c.Pattern != "b" || c.Minimum == nil || *c.Minimum != min {
+		t.Fatalf("ConstraintsFor returns wrong constraints: %+v", c)
+	}
+	v, err := op.Operate(context.Background(), "test", "")
+	if err != nil || v != "" {
+		t.Fatalf("WithConstraints changes behavior of operator: %v, %v", v, err)
+	}
+}
+
+type color string
+
+func TestEnumOperator(t *testing.T) {
+	op := EnumOperator("asc", "desc")
+	if op.In() != stringType || op.Out() != stringType {
+		t.Fatalf("EnumOperator has wrong types: %v -> %v", op.In(), op.Out())
+	}
+	tests := []struct {
+		op    Operator
+		value interface{}
+		ok    bool
+	}{
+		{op, "asc", true},
+		{op, "desc", true},
+		{op, "ASC", false},
+		{op, "", false},
+		{EnumOperator("", "asc"), "", true},
+		{EnumOperator(color("red"), color("blue")), color("red"), true},
+		{EnumOperator(color("red"), color("blue")), color("green"), false},
+		{EnumOperator(1, 2, 3), 2, true},
+		{EnumOperator(1, 2, 3), 0, false},
+	}
+	for _, test := range tests {
+		v, err := test.op.Operate(context.Background(), "order", test.value)
+		if test.ok && (err != nil || v != test.value) {
+			t.Fatalf("EnumOperator should accept %v: %v, %v", test.value, v, err)
+		}
+		if !test.ok && !unexpectedEnum.Derived(err) {
+			t.Fatalf("EnumOperator should reject %v: %v", test.value, err)
+		}
+	}
+	_, err := op.Operate(context.Background(), "order", "ASC")
+	if !strings.Contains(err.Error(), "[asc desc]") {
+		t.Fatalf("Error of EnumOperator should contain allowed values: %v", err)
+	}
+	if c := ConstraintsFor(op); !reflect.DeepEqual(c.Enum, []interface{}{"asc", "desc"}) {
+		t.Fatalf("EnumOperator has wrong constraints: %+v", c)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("EnumOperator should panic with values of different types")
+		}
+	}()
+	EnumOperator("1", 2)
+}
+
+func TestTrimSpaceOperator(t *testing.T) {
+	op := TrimSpaceOperator()
+	if op.In() != stringType || op.Out() != stringType {
+		t.Fatalf("TrimSpaceOperator has wrong types: %v -> %v", op.In(), op.Out())
+	}
+	tests := map[string]string{
+		"  abc\t\n":             "abc",
+		"\u3000\u00a0a b\u2003": "a b",
+		"":                      "",
+	}
+	for in, want := range tests {
+		v, err := op.Operate(context.Background(), "name", in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != want {
+			t.Fatalf("TrimSpaceOperator returns %q for %q, but want %q", v, in, want)
+		}
+	}
+}
+
+func TestCaseOperator(t *testing.T) {
+	tests := []struct {
+		mode CaseMode
+		in   string
+		want string
+	}{
+		{LowerCase, "ÄBC Straße", "äbc straße"},
+		{UpperCase, "äbc ǆ", "ÄBC Ǆ"},
+		{TitleCase, "ǆungla ÉCOLE\u3000über", "ǅungla École\u3000Über"},
+	}
+	for _, test := range tests {
+		op := CaseOperator(test.mode)
+		v, err := op.Operate(context.Background(), "name", test.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != test.want {
+			t.Fatalf("CaseOperator(%s) returns %q for %q, but want %q", test.mode, v, test.in, test.want)
+		}
+	}
+
+	op := PipelineOperator(TrimSpaceOperator(), CaseOperator(LowerCase), EnumOperator("a", "b"))
+	if v, err := op.Operate(context.Background(), "name", " A\u3000"); err != nil || v != "a" {
+		t.Fatalf("Normalizers should compose with validators: %v, %v", v, err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("CaseOperator should panic with an unknown mode")
+		}
+	}()
+	CaseOperator("camel")
+}
+
+func TestHTMLEscapeOperator(t *testing.T) {
+	op := HTMLEscapeOperator()
+	if op.In() != stringType || op.Out() != stringType {
+		t.Fatalf("HTMLEscapeOperator has wrong types: %v -> %v", op.In(), op.Out())
+	}
+	tests := map[string]string{
+		`<script>alert("x")</script>`: "&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt;",
+		"Tom & Jerry's":               "Tom &amp; Jerry&#39;s",
+		// Escaped values are not escaped twice.
+		"&lt;b&gt; &amp; &#39;": "&lt;b&gt; &amp; &#39;",
+		"&amp;lt;":              "&amp;lt;",
+		"中文 <b>文字</b> ✓":        "中文 &lt;b&gt;文字&lt;/b&gt; ✓",
+		"":                      "",
+	}
+	for in, want := range tests {
+		v, err := op.Operate(context.Background(), "name", in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != want {
+			t.Fatalf("HTMLEscapeOperator returns %q for %q, but want %q", v, in, want)
+		}
+		again, _ := op.Operate(context.Background(), "name", v)
+		if again != want {
+			t.Fatalf("HTMLEscapeOperator is not idempotent for %q: %q", in, again)
+		}
+	}
+}
+
+func TestStripTagsOperator(t *testing.T) {
+	op := StripTagsOperator()
+	if op.In() != stringType || op.Out() != stringType {
+		t.Fatalf("StripTagsOperator has wrong types: %v -> %v", op.In(), op.Out())
+	}
+	tests := map[string]string{
+		"<b>bold</b> text":                      "bold text",
+		`<a href="/" title="a>b">link</a>`:      "link",
+		"a < b > c":                             "a < b > c",
+		"x <!-- <b>comment</b> --> y":           "x  y",
+		"<p>中文<br/>文字</p>":                      "中文文字",
+		"safe <script src='x.js'":               "safe ",
+		"&lt;b&gt; is kept":                     "&lt;b&gt; is kept",
+		"<?xml version=\"1.0\"?><!DOCTYPE x>ok": "ok",
+		"trailing <":                            "trailing <",
+	}
+	for in, want := range tests {
+		v, err := op.Operate(context.Background(), "name", in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != want {
+			t.Fatalf("StripTagsOperator returns %q for %q, but want %q", v, in, want)
+		}
+	}
+
+	pipeline := PipelineOperator(StripTagsOperator(), HTMLEscapeOperator())
+	v, err := pipeline.Operate(context.Background(), "name", `<i>"a" & b</i>`)
+	if err != nil || v != "&#34;a&#34; &amp; b" {
+		t.Fatalf("Pipeline returns %q, %v", v, err)
+	}
+}
+
+func TestFileSizeOperator(t *testing.T) {
+	files := []*multipart.FileHeader{
+		{Filename: "a.txt", Size: 3},
+		{Filename: "b.txt", Size: 5},
+	}
+	tests := []struct {
+		maxFileSize  int64
+		maxTotalSize int64
+		factory      errors.Factory
+	}{
+		{0, 0, nil},
+		{5, 8, nil},
+		{4, 0, fileTooLarge},
+		{0, 7, filesTooLarge},
+	}
+	for _, test := range tests {
+		op := FileSizeOperator(test.maxFileSize, test.maxTotalSize)
+		v, err := op.Operate(context.Background(), "files", files)
+		if test.factory == nil {
+			if err != nil || !reflect.DeepEqual(v, files) {
+				t.Fatalf("FileSizeOperator(%d, %d) rejects valid files: %v", test.maxFileSize, test.maxTotalSize, err)
+			}
+			continue
+		}
+		if !test.factory.Derived(err) {
+			t.Fatalf("FileSizeOperator(%d, %d) returns an unexpected error: %v", test.maxFileSize, test.maxTotalSize, err)
+		}
+	}
+}
+
+func TestBase64DecodeOperator(t *testing.T) {
+	op := Base64DecodeOperator()
+	if op.In() != stringType || op.Out() != bytesType {
+		t.Fatalf("Base64DecodeOperator has wrong types: %v -> %v", op.In(), op.Out())
+	}
+	for _, value := range []string{"aGk/Pz4+", "aGk_Pz4-", " aGk/Pz4+\n"} {
+		v, err := op.Operate(context.Background(), "token", value)
+		if err != nil || string(v.([]byte)) != "hi??>>" {
+			t.Fatalf("Base64DecodeOperator can't decode %q: %v %v", value, v, err)
+		}
+	}
+	if v, err := op.Operate(context.Background(), "token", "aGk"); err != nil || string(v.([]byte)) != "hi" {
+		t.Fatalf("Base64DecodeOperator can't decode value without padding: %v %v", v, err)
+	}
+	if _, err := op.Operate(context.Background(), "token", "a$b"); !invalidBase64.Derived(err) {
+		t.Fatalf("Base64DecodeOperator should reject invalid value: %v", err)
+	}
+}
+
+func TestPipelineOperator(t *testing.T) {
+	toUpper := NewOperator("converter", stringType, stringType, func(ctx context.Context, field string, object interface{}) (interface{}, error) {
+		return strings.ToUpper(object.(string)), nil
+	})
+	op := PipelineOperator(DefaultValueOperator("abc"), toUpper, RegexpOperator("^[A-Z]+$"))
+	if op.In() != stringType || op.Out() != stringType {
+		t.Fatalf("PipelineOperator has wrong types: %v -> %v", op.In(), op.Out())
+	}
+	v, err := op.Operate(context.Background(), "test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "ABC" {
+		t.Fatalf("PipelineOperator returns a wrong value: %v", v)
+	}
+	if _, err := op.Operate(context.Background(), "test", "a1"); !unmatchedPattern.Derived(err) {
+		t.Fatalf("PipelineOperator should return the error of operator: %v", err)
+	}
+	if c := ConstraintsFor(op); c.Pattern != "^[A-Z]+$" {
+		t.Fatalf("PipelineOperator has wrong constraints: %+v", c)
+	}
+
+	defer func() {
+		r := recover()
+		if r == nil || !strings.Contains(r.(string), "operator 1") {
+			t.Fatalf("PipelineOperator should panic with the offending index: %v", r)
+		}
+	}()
+	PipelineOperator(toUpper, DefaultValueOperator(1))
+}
+
+func TestNumericRangeOperator(t *testing.T) {
+	op := NumericRangeOperator(1, 100)
+	adaptive, ok := op.(AdaptiveOperator)
+	if !ok {
+		t.Fatal("NumericRangeOperator should be adaptive")
+	}
+	for _, typ := range []reflect.Type{reflect.TypeOf(0), reflect.TypeOf(int64(0)), reflect.TypeOf(uint8(0)), reflect.TypeOf(0.0)} {
+		if err := adaptive.Accept(typ); err != nil {
+			t.Fatalf("NumericRangeOperator should accept %v: %v", typ, err)
+		}
+	}
+	if err := adaptive.Accept(stringType); err == nil {
+		t.Fatalf("NumericRangeOperator should not accept string: %v", err)
+	}
+	tests := []struct {
+		op    Operator
+		value interface{}
+		ok    bool
+	}{
+		{op, 1, true},
+		{op, int64(100), true},
+		{op, 0, false},
+		{op, 101.0, false},
+		{op, 100.5, false},
+		{op, uint8(50), true},
+		{NumericRangeOperator(math.Inf(-1), 0), -1e10, true},
+		{NumericRangeOperator(0, math.Inf(1)), int64(1) << 60, true},
+		{NumericRangeOperator(0, math.Inf(1)), float32(-0.5), false},
+	}
+	for _, test := range tests {
+		v, err := test.op.Operate(context.Background(), "limit", test.value)
+		if test.ok && (err != nil || v != test.value) {
+			t.Fatalf("NumericRangeOperator should accept %v: %v, %v", test.value, v, err)
+		}
+		if !test.ok && !outOfRange.Derived(err) {
+			t.Fatalf("NumericRangeOperator should reject %v: %v", test.value, err)
+		}
+	}
+	if _, err := op.Operate(context.Background(), "limit", "10"); !nonNumericValue.Derived(err) {
+		t.Fatalf("NumericRangeOperator should reject string value: %v", err)
+	}
+
+	c := ConstraintsFor(op)
+	if c.Minimum == nil || *c.Minimum != 1 || c.Maximum == nil || *c.Maximum != 100 {
+		t.Fatalf("NumericRangeOperator has wrong constraints: %+v", c)
+	}
+	if c := ConstraintsFor(NumericRangeOperator(0, math.Inf(1))); c.Maximum != nil {
+		t.Fatalf("Infinite bound should not be a constraint: %+v", c)
+	}
+
+	p := PipelineOperator(NumericRangeOperator(0, 10), NumericRangeOperator(5, 20))
+	if _, ok := p.(AdaptiveOperator); !ok {
+		t.Fatal("Pipeline of adaptive operators should be adaptive")
+	}
+	if _, err := p.Operate(context.Background(), "limit", 3); !outOfRange.Derived(err) {
+		t.Fatalf("Pipeline should reject value out of range: %v", err)
+	}
+}
+
+func TestStringLengthOperator(t *testing.T) {
+	op := StringLengthOperator(2, 4)
+	tests := []struct {
+		op    Operator
+		value string
+		err   interface{ Derived(error) bool }
+	}{
+		{op, "ab", nil},
+		{op, "abcd", nil},
+		{op, "a", stringTooShort},
+		{op, "abcde", stringTooLong},
+		// Every rune has 3 bytes.
+		{op, "你好世界", nil},
+		{op, "你好世界啊", stringTooLong},
+		{op, "你", stringTooShort},
+		{op, "", stringTooShort},
+		{StringLengthOperator(0, -1), "", nil},
+		{StringLengthOperator(1, -1), strings.Repeat("é", 10000), nil},
+		{StringLengthOperator(0, 0), "", nil},
+		{StringLengthOperator(0, 0), "a", stringTooLong},
+	}
+	for _, test := range tests {
+		v, err := test.op.Operate(context.Background(), "name", test.value)
+		if test.err == nil && (err != nil || v != test.value) {
+			t.Fatalf("StringLengthOperator should accept %q: %v, %v", test.value, v, err)
+		}
+		if test.err != nil && !test.err.Derived(err) {
+			t.Fatalf("StringLengthOperator should reject %q: %v", test.value, err)
+		}
+	}
+	if _, err := op.Operate(context.Background(), "name", "a"); err == nil || !strings.Contains(err.Error(), "'name'") {
+		t.Fatalf("Error should contain the field: %v", err)
+	}
+
+	c := ConstraintsFor(op)
+	if c.MinLength == nil || *c.MinLength != 2 || c.MaxLength == nil || *c.MaxLength != 4 {
+		t.Fatalf("StringLengthOperator has wrong constraints: %+v", c)
+	}
+	if c := ConstraintsFor(StringLengthOperator(0, -1)); c.MinLength != nil || c.MaxLength != nil {
+		t.Fatalf("Unbounded lengths should not be constraints: %+v", c)
+	}
+	for _, bounds := range [][2]int{{-1, 2}, {3, 2}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("StringLengthOperator should panic for bounds %v", bounds)
+				}
+			}()
+			StringLengthOperator(bounds[0], bounds[1])
+		}()
+	}
+}
+
+func TestEmailOperator(t *testing.T) {
+	op := EmailOperator()
+	if op.In() != stringType || op.Out() != stringType {
+		t.Fatalf("EmailOperator should convert strings: %v -> %v", op.In(), op.Out())
+	}
+	valid := map[string]string{
+		"john@example.com":             "john@example.com",
+		"John.Doe@Example.COM":         "John.Doe@example.com",
+		"  user+tag@mail.example.org ": "user+tag@mail.example.org",
+		"\tx_y-z@sub-domain.io\n":      "x_y-z@sub-domain.io",
+		`"john doe"@example.com`:       `"john doe"@example.com`,
+		`"a\"b"@example.com`:           `"a\"b"@example.com`,
+		"o'reilly@example.co.uk":       "o'reilly@example.co.uk",
+	}
+	for value, expected := range valid {
+		result, err := op.Operate(context.Background(), "email", value)
+		if err != nil || result != expected {
+			t.Fatalf("EmailOperator should normalize %q to %q: %v, %v", value, expected, result, err)
+		}
+	}
+	invalid := []string{
+		"",
+		"   ",
+		"plain",
+		"@example.com",
+		"john@",
+		"john@localhost",
+		"john@@example.com",
+		"john@exam ple.com",
+		"jo hn@example.com",
+		"john..doe@example.com",
+		".john@example.com",
+		"john.@example.com",
+		"john@-example.com",
+		"john@example-.com",
+		"john@example..com",
+		"john@192.168.0.1",
+		"john@[192.168.0.1]",
+		"John <john@example.com>",
+		`"unclosed@example.com`,
+		strings.Repeat("a", 65) + "@example.com",
+		"john@" + strings.Repeat("a", 64) + ".com",
+		"john@" + strings.Repeat("a.", 125) + "com",
+	}
+	for _, value := range invalid {
+		_, err := op.Operate(context.Background(), "email", value)
+		if !invalidEmail.Derived(err) {
+			t.Fatalf("EmailOperator should reject %q: %v", value, err)
+		}
+		if !strings.Contains(err.Error(), "'email'") {
+			t.Fatalf("Error should contain the field: %v", err)
+		}
+	}
+}
+
+func TestBatchOperator(t *testing.T) {
+	op := BatchOperator(EnumOperator("a", "b"))
+	if op.In() != reflect.TypeOf([]string{}) || op.Out() != reflect.TypeOf([]string{}) {
+		t.Fatalf("BatchOperator has wrong types: %v -> %v", op.In(), op.Out())
+	}
+	v, err := op.Operate(context.Background(), "ids", []string{"a", "b", "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v, []string{"a", "b", "a"}) {
+		t.Fatalf("BatchOperator returns an unexpected value: %v", v)
+	}
+	_, err = op.Operate(context.Background(), "ids", []string{"a", "b", "a", "c"})
+	if !unexpectedEnum.Derived(err) || !strings.Contains(err.Error(), "'ids[3]'") {
+		t.Fatalf("BatchOperator returns an unexpected error: %v", err)
+	}
+	if _, err := op.Operate(context.Background(), "ids", "a"); !nonSliceValue.Derived(err) {
+		t.Fatalf("BatchOperator should reject non-slice value: %v", err)
+	}
+
+	convert := BatchOperator(OperatorFunc(converterKind, func(ctx context.Context, field string, value string) (int, error) {
+		return len(value), nil
+	}))
+	if convert.In() != reflect.TypeOf([]string{}) || convert.Out() != reflect.TypeOf([]int{}) {
+		t.Fatalf("BatchOperator has wrong types: %v -> %v", convert.In(), convert.Out())
+	}
+	v, err = convert.Operate(context.Background(), "names", []string{"a", "abc"})
+	if err != nil || !reflect.DeepEqual(v, []int{1, 3}) {
+		t.Fatalf("BatchOperator returns an unexpected result: %v, %v", v, err)
+	}
+
+	adaptive, ok := BatchOperator(NumericRangeOperator(0, 10)).(AdaptiveOperator)
+	if !ok {
+		t.Fatal("BatchOperator should be adaptive with an adaptive element operator")
+	}
+	if err := adaptive.Accept(reflect.TypeOf([]int64{})); err != nil {
+		t.Fatal(err)
+	}
+	if err := adaptive.Accept(reflect.TypeOf([]string{})); err == nil {
+		t.Fatal("BatchOperator should not accept slices of strings")
+	}
+	if err := adaptive.Accept(reflect.TypeOf(1)); err == nil {
+		t.Fatal("BatchOperator should not accept non-slice types")
+	}
+	v, err = adaptive.Operate(context.Background(), "counts", []int64{1, 2})
+	if err != nil || !reflect.DeepEqual(v, []int64{1, 2}) {
+		t.Fatalf("BatchOperator returns an unexpected result: %v, %v", v, err)
+	}
+	_, err = adaptive.Operate(context.Background(), "counts", []int64{1, 20})
+	if !outOfRange.Derived(err) || !strings.Contains(err.Error(), "'counts[1]'") {
+		t.Fatalf("BatchOperator returns an unexpected error: %v", err)
+	}
+}
+
+func TestUUIDOperator(t *testing.T) {
+	const canonical = "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+	op := UUIDOperator(UUIDOptions{})
+	if op.In() != stringType || op.Out() != stringType {
+		t.Fatalf("UUIDOperator has wrong types: %v -> %v", op.In(), op.Out())
+	}
+	for _, value := range []string{
+		canonical,
+		"6BA7B810-9DAD-11D1-80B4-00C04FD430C8",
+		"{6ba7b810-9dad-11d1-80b4-00c04fd430c8}",
+		"urn:uuid:6ba7b810-9dad-11d1-80b4-00c04fd430c8",
+		"URN:UUID:6BA7B810-9DAD-11D1-80B4-00C04FD430C8",
+		"6ba7b8109dad11d180b400c04fd430c8",
+		" 6ba7b810-9dad-11d1-80b4-00c04fd430c8\n",
+	} {
+		v, err := op.Operate(context.Background(), "id", value)
+		if err != nil || v != canonical {
+			t.Fatalf("UUIDOperator can't convert %q: %v %v", value, v, err)
+		}
+	}
+	for _, value := range []string{
+		"",
+		"6ba7b810-9dad-11d1-80b4-00c04fd430c",
+		"6ba7b810-9dad-11d1-80b4-00c04fd430cg",
+		"6ba7b810_9dad_11d1_80b4_00c04fd430c8",
+		"{6ba7b810-9dad-11d1-80b4-00c04fd430c8",
+		"urn:6ba7b810-9dad-11d1-80b4-00c04fd430c8",
+		"6ba7b8109dad11d180b400c04fd430c8aa",
+	} {
+		if _, err := op.Operate(context.Background(), "id", value); !invalidUUID.Derived(err) {
+			t.Fatalf("UUIDOperator should reject %q: %v", value, err)
+		}
+	}
+	const zero = "00000000-0000-0000-0000-000000000000"
+	if v, err := op.Operate(context.Background(), "id", zero); err != nil || v != zero {
+		t.Fatalf("UUIDOperator should accept the nil UUID by default: %v %v", v, err)
+	}
+
+	op = UUIDOperator(UUIDOptions{RejectNil: true, Typed: true})
+	if op.In() != stringType || op.Out() != reflect.TypeOf(UUID{}) {
+		t.Fatalf("Typed UUIDOperator has wrong types: %v -> %v", op.In(), op.Out())
+	}
+	v, err := op.Operate(context.Background(), "id", "{6BA7B810-9DAD-11D1-80B4-00C04FD430C8}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uuid, ok := v.(UUID); !ok || uuid.String() != canonical || uuid[0] != 0x6b || uuid[15] != 0xc8 {
+		t.Fatalf("Typed UUIDOperator returned a wrong value: %#v", v)
+	}
+	if _, err := op.Operate(context.Background(), "id", zero); !nilUUID.Derived(err) {
+		t.Fatalf("UUIDOperator should reject the nil UUID: %v", err)
+	}
+	// Values can be converted to other UUID types.
+	type otherUUID [16]byte
+	op = UUIDOperator(UUIDOptions{Typed: true, Type: reflect.TypeOf(otherUUID{})})
+	if op.Out() != reflect.TypeOf(otherUUID{}) {
+		t.Fatalf("UUIDOperator has a wrong out type: %v", op.Out())
+	}
+	v, err = op.Operate(context.Background(), "id", canonical)
+	if other, ok := v.(otherUUID); err != nil || !ok || UUID(other).String() != canonical {
+		t.Fatalf("UUIDOperator returned a wrong value: %#v %v", v, err)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("UUIDOperator should panic for types which are not convertible from UUID")
+			}
+		}()
+		UUIDOperator(UUIDOptions{Typed: true, Type: reflect.TypeOf([]byte(nil))})
+	}()
+}
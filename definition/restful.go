@@ -22,6 +22,23 @@ type Descriptor struct {
 	//
 	// If parent path is "/api/v1", current is "/some",
 	// It means current definitions handles "/api/v1/some".
+	//
+	// A segment like "{name}" captures a path parameter until the next "/",
+	// and "{name:regexp}" captures a value matched by the regular expression.
+	// A catch-all segment "{name:*}" must be at the end of path. It captures
+	// all of the rest path, including "/" and trailing slash. For instance,
+	// "/files/{path:*}" captures "a/b/" from "/files/a/b/", but doesn't match
+	// "/files/" because the captured value must not be empty. The captured
+	// value is URL-decoded, but escaped slashes stay escaped, so that they can't
+	// make new segments. For instance, "/files/a%20b/c" captures "a b/c", and
+	// "/files/a%2Fb" captures "a%2Fb". Values of other path parameters are not
+	// decoded, as they appear in the escaped path.
+	//
+	// Fixed strings take precedence over regular expressions, and regular
+	// expressions take precedence over catch-all segments. If a more specific
+	// route fails to match the rest of a path, a catch-all segment at the same
+	// position is tried. For instance, with "/files/readme" and "/files/{path:*}",
+	// "/files/readme" matches the former and "/files/readme/v2" matches the latter.
 	Path string
 	// Consumes indicates content types that current definitions
 	// and child definitions can consume.
@@ -32,11 +49,30 @@ type Descriptor struct {
 	// It will override parent descriptor's produces.
 	Produces []string
 	// Tags indicates tags of current definitions and child definitions.
-	// It will override parent descriptor's tags.
+	// It will override parent descriptor's tags. API documents group operations
+	// into sections by tags.
 	Tags []string
-	// Middlewares contains path middlewares.
+	// Middlewares contains path middlewares. Middlewares apply to definitions
+	// of current descriptor and all child descriptors. Parent middlewares run
+	// before child middlewares. A middleware can stop the request by returning
+	// an error without calling Chain.Continue(), and the error is written to
+	// client like errors of definitions.
 	Middlewares []Middleware
-	// Definitions contains definitions for current path.
+	// Headers are set to all responses of current definitions and child
+	// definitions, including error responses. They are set before middlewares
+	// run, so headers set by middlewares and Meta results override them. Headers
+	// of child descriptors override headers of parent descriptors. It's useful
+	// for security headers like "X-Content-Type-Options".
+	Headers map[string]string
+	// AutoHead derives HEAD handlers from GET definitions of current path and
+	// child paths which have no HEAD definitions. A derived handler runs the
+	// same middlewares and function as GET, and discards the body of response.
+	// The body is counted instead of being sent, so "Content-Length" and other
+	// headers are the same as GET.
+	AutoHead bool
+	// Definitions contains definitions for current path. An OPTIONS request to
+	// a path without OPTIONS definitions gets 204 with header "Allow", which
+	// lists methods of the definitions.
 	Definitions []Definition
 	// Children is used to place sub-descriptors.
 	Children []Descriptor
@@ -16,13 +16,17 @@ limitations under the License.
 
 package definition
 
+import "time"
+
 // RPCDescriptor describes a descriptor for API definition in RPC style.
 type RPCDescriptor struct {
 	// Path describes url path prefix for all RPCActions, default: "/".
 	Path string
 	// Description describes the usage of the path.
 	Description string
-	// Middlewares contains path middlewares.
+	// Middlewares contains path middlewares. If the descriptor has no actions,
+	// the middlewares apply to actions of all descriptors whose paths start with
+	// Path, and they run before middlewares of those descriptors.
 	Middlewares []Middleware
 	// Tags indicates tags of current definitions and child definitions.
 	// It will override parent descriptor's tags.
@@ -71,4 +75,22 @@ type RPCAction struct {
 	Description string
 	// Examples contains many examples for the API handler.
 	Examples []Example
+	// Timeout is the deadline of the API handler. See Definition.Timeout.
+	Timeout time.Duration
+	// MaxBodySize limits the size of request body. See Definition.MaxBodySize.
+	MaxBodySize int64
+	// AccumulateErrors collects errors of all parameters. See Definition.AccumulateErrors.
+	AccumulateErrors bool
+	// ResponseOperators operate the data of response. See Definition.ResponseOperators.
+	ResponseOperators []Operator
+	// Deprecated marks the action as deprecated. See Definition.Deprecated.
+	Deprecated bool
+	// DeprecationMessage is the message in "Warning" header.
+	DeprecationMessage string
+	// PartialResponse enables partial responses. See Definition.PartialResponse.
+	PartialResponse PartialResponseMode
+	// Security lists security requirements of the action. See Definition.Security.
+	Security []SecurityRequirement
+	// Middlewares contains middlewares of the action. See Definition.Middlewares.
+	Middlewares []Middleware
 }
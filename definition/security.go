@@ -0,0 +1,87 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package definition
+
+// SecuritySchemeType is the type of a security scheme.
+type SecuritySchemeType string
+
+const (
+	// HTTPAuthentication means credentials are in header "Authorization", such
+	// as "Bearer <token>" and "Basic <credentials>".
+	HTTPAuthentication SecuritySchemeType = "http"
+	// APIKeyAuthentication means credentials are API keys in headers, queries
+	// or cookies.
+	APIKeyAuthentication SecuritySchemeType = "apiKey"
+)
+
+// SecurityScheme describes how clients authenticate, like security schemes in
+// OpenAPI. Definitions refer to schemes by name in their security requirements.
+type SecurityScheme struct {
+	// Name is the unique name of the scheme, such as "bearer".
+	Name string `json:"name"`
+	// Type is the type of the scheme.
+	Type SecuritySchemeType `json:"type"`
+	// Description describes the scheme.
+	Description string `json:"description,omitempty"`
+	// Scheme is the authorization scheme of HTTPAuthentication, such as "bearer"
+	// and "basic". It's case-insensitive.
+	Scheme string `json:"scheme,omitempty"`
+	// BearerFormat is a hint of bearer tokens for documents, such as "JWT".
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	// In is the location of API keys of APIKeyAuthentication. It must be Header,
+	// Query or Cookie.
+	In Source `json:"in,omitempty"`
+	// Key is the name of the header, query or cookie of API keys.
+	Key string `json:"key,omitempty"`
+}
+
+// BearerScheme creates a security scheme of bearer tokens in header
+// "Authorization", such as "Bearer <token>". format is a hint of tokens for
+// documents, such as "JWT".
+func BearerScheme(name, format string) SecurityScheme {
+	return SecurityScheme{
+		Name:         name,
+		Type:         HTTPAuthentication,
+		Scheme:       "bearer",
+		BearerFormat: format,
+	}
+}
+
+// APIKeyScheme creates a security scheme of API keys. in must be Header, Query
+// or Cookie, and key is the name of the header, query or cookie.
+func APIKeyScheme(name string, in Source, key string) SecurityScheme {
+	return SecurityScheme{
+		Name: name,
+		Type: APIKeyAuthentication,
+		In:   in,
+		Key:  key,
+	}
+}
+
+// SecurityRequirement requires a request to pass a security scheme.
+type SecurityRequirement struct {
+	// Scheme is the name of a security scheme.
+	Scheme string `json:"scheme"`
+	// Scopes are scopes required by the definition, such as "users:read". They're
+	// passed to the authenticator of the scheme.
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// SecurityFor creates a security requirement of a scheme with scopes.
+func SecurityFor(scheme string, scopes ...string) SecurityRequirement {
+	return SecurityRequirement{Scheme: scheme, Scopes: scopes}
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transcoding
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/caicloud/nirvana/definition"
+	"github.com/caicloud/nirvana/service"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Serializer consumes and produces values of a content type.
+type Serializer interface {
+	// ContentType returns the content type of the serializer.
+	ContentType() string
+	// Consume reads data from r into v.
+	Consume(r io.Reader, v interface{}) error
+	// Produce writes v to w.
+	Produce(w io.Writer, v interface{}) error
+}
+
+// JSONSerializer implements service.Consumer and service.Producer for content
+// type "application/json". Proto messages are encoded by protojson, so they have
+// the canonical JSON forms of proto3, such as strings of Timestamps. Fields are
+// named by proto names, which are also the names of query parameters. Other
+// values are encoded by the fallback serializer.
+type JSONSerializer struct {
+	fallback Serializer
+}
+
+// NewJSONSerializer creates a JSON serializer which encodes values other than
+// proto messages by fallback. Default to service.JSONSerializer if fallback is
+// nil. Register it to replace the default one:
+//
+//	serializer := transcoding.NewJSONSerializer(nil)
+//	service.RegisterConsumer(serializer)
+//	service.RegisterProducer(serializer)
+func NewJSONSerializer(fallback Serializer) *JSONSerializer {
+	if fallback == nil {
+		fallback = &service.JSONSerializer{}
+	}
+	return &JSONSerializer{fallback: fallback}
+}
+
+// ContentType returns json MIME type.
+func (s *JSONSerializer) ContentType() string {
+	return definition.MIMEJSON
+}
+
+// Consume unmarshals json from r into v. An empty body leaves a message unset,
+// and an invalid body is rejected with a bad request error.
+func (s *JSONSerializer) Consume(r io.Reader, v interface{}) error {
+	message, ok := v.(proto.Message)
+	if !ok {
+		return s.fallback.Consume(r, v)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+	if err := protojson.Unmarshal(data, message); err != nil {
+		return invalidBody.Error(s.ContentType(), err)
+	}
+	return nil
+}
+
+// Produce marshals v to json and writes to w.
+func (s *JSONSerializer) Produce(w io.Writer, v interface{}) error {
+	message, ok := v.(proto.Message)
+	if !ok {
+		return s.fallback.Produce(w, v)
+	}
+	data, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(message)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// ForRequest returns a serializer whose fallback is for the request, if the
+// fallback serializer is a service.RequestAwareProducer.
+func (s *JSONSerializer) ForRequest(req *http.Request) service.Producer {
+	if rp, ok := s.fallback.(service.RequestAwareProducer); ok {
+		if fallback, ok := rp.ForRequest(req).(Serializer); ok {
+			return &JSONSerializer{fallback: fallback}
+		}
+	}
+	return s
+}
@@ -0,0 +1,295 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package transcoding creates definitions which transcode HTTP requests to unary
+// gRPC methods, like grpc-gateway. Fields of a gRPC request are filled from path
+// parameters, query parameters and the request body, and the gRPC response is
+// written in the content type negotiated by Nirvana. Bodies and responses are
+// encoded by Nirvana serializers. Register JSONSerializer to encode messages in
+// JSON by protojson, otherwise well-known types such as Timestamp have no
+// special forms.
+//
+// The package doesn't depend on gRPC. Methods are called by an Invoker, which is
+// usually a thin wrapper of a gRPC client connection:
+//
+//	invoker := func(ctx context.Context, method string, req, resp proto.Message) error {
+//		return conn.Invoke(ctx, method, req, resp)
+//	}
+package transcoding
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/caicloud/nirvana/definition"
+	"github.com/caicloud/nirvana/errors"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+var (
+	invalidField = errors.BadRequest.Build("Nirvana:Transcoding:InvalidField", "value '${value}' of field '${field}' is invalid: ${reason}")
+	invalidBody  = errors.BadRequest.Build("Nirvana:Transcoding:InvalidBody", "can't parse body as ${type}: ${reason}")
+)
+
+// Invoker calls a unary gRPC method. method is the full name of the method, such
+// as "/pkg.Service/Method". The invoker should convert gRPC status errors to
+// Nirvana errors with proper HTTP status codes, otherwise they are written as
+// internal server errors.
+type Invoker func(ctx context.Context, method string, request, response proto.Message) error
+
+// Binding maps an HTTP route to a unary gRPC method.
+type Binding struct {
+	// Method is the HTTP method of the route.
+	Method definition.Method
+	// GRPCMethod is the full name of the gRPC method, such as "/pkg.Service/Method".
+	GRPCMethod string
+	// Request is a message of the request type of the gRPC method, such as
+	// &pb.GetBookRequest{}. It's only used to get the type.
+	Request proto.Message
+	// Response is a message of the response type of the gRPC method.
+	Response proto.Message
+	// PathParameters contains names of path parameters in the path of the route.
+	// Each of them sets the request field with the same proto name or JSON name.
+	PathParameters []string
+	// Body is "*" if the whole request message is read from the request body, or
+	// empty if there is no body. Path parameters override fields in the body.
+	// Request fields which are not set by path parameters or the body are read
+	// from query parameters named by proto names of the fields, which are also
+	// used by the body and the response. Only fields of scalar, enum and their
+	// repeated types can be query parameters.
+	Body string
+	// Summary is the summary of the definition.
+	Summary string
+	// Description is the description of the definition.
+	Description string
+	// Consumes are content types of the request body. Empty means the content
+	// types of the descriptor. It's ignored if there is no body.
+	Consumes []string
+	// Produces are content types of the response. Empty means the content types
+	// of the descriptor.
+	Produces []string
+}
+
+// field is a request field which is set by a path or query parameter.
+type field struct {
+	name       string
+	descriptor protoreflect.FieldDescriptor
+}
+
+// DefinitionFor creates a definition which transcodes requests to the gRPC
+// method of binding with invoker. It panics if binding is invalid.
+func DefinitionFor(invoker Invoker, binding Binding) definition.Definition {
+	if invoker == nil {
+		panic("Parameter invoker of DefinitionFor must not be nil")
+	}
+	if binding.Request == nil || binding.Response == nil {
+		panic(fmt.Sprintf("Request and response of gRPC method %s must not be nil", binding.GRPCMethod))
+	}
+	if binding.Body != "" && binding.Body != "*" {
+		panic(fmt.Sprintf("Body %q of gRPC method %s is not supported, want \"*\" or empty", binding.Body, binding.GRPCMethod))
+	}
+	requestType := reflect.TypeOf(binding.Request)
+	responseType := reflect.TypeOf(binding.Response)
+	fields := binding.Request.ProtoReflect().Descriptor().Fields()
+
+	var pathFields, queryFields []field
+	bound := map[protoreflect.FieldNumber]bool{}
+	for _, name := range binding.PathParameters {
+		fd := fields.ByName(protoreflect.Name(name))
+		if fd == nil {
+			fd = fields.ByJSONName(name)
+		}
+		if fd == nil || !queryable(fd) || fd.IsList() {
+			panic(fmt.Sprintf("Path parameter %s of gRPC method %s doesn't match a scalar field of %s",
+				name, binding.GRPCMethod, binding.Request.ProtoReflect().Descriptor().FullName()))
+		}
+		pathFields = append(pathFields, field{name, fd})
+		bound[fd.Number()] = true
+	}
+	if binding.Body == "" {
+		for i := 0; i < fields.Len(); i++ {
+			fd := fields.Get(i)
+			if !bound[fd.Number()] && queryable(fd) {
+				queryFields = append(queryFields, field{string(fd.Name()), fd})
+			}
+		}
+	}
+
+	var parameters []definition.Parameter
+	in := []reflect.Type{reflect.TypeOf((*context.Context)(nil)).Elem()}
+	for _, f := range pathFields {
+		parameters = append(parameters, definition.PathParameterFor(f.name, fieldDescription(f.descriptor)))
+		in = append(in, reflect.TypeOf(""))
+	}
+	for _, f := range queryFields {
+		parameters = append(parameters, definition.QueryParameterFor(f.name, fieldDescription(f.descriptor)))
+		if f.descriptor.IsList() {
+			in = append(in, reflect.TypeOf([]string(nil)))
+		} else {
+			in = append(in, reflect.TypeOf(""))
+		}
+	}
+	consumes := []string{definition.MIMENone}
+	if binding.Body != "" {
+		parameters = append(parameters, definition.BodyParameterFor("request of "+binding.GRPCMethod))
+		in = append(in, requestType)
+		consumes = binding.Consumes
+	}
+	out := []reflect.Type{responseType, reflect.TypeOf((*error)(nil)).Elem()}
+
+	function := reflect.MakeFunc(reflect.FuncOf(in, out, false), func(args []reflect.Value) []reflect.Value {
+		response, err := transcode(invoker, binding, pathFields, queryFields, args)
+		if err != nil {
+			return []reflect.Value{reflect.Zero(responseType), reflect.ValueOf(&err).Elem()}
+		}
+		return []reflect.Value{reflect.ValueOf(response), reflect.Zero(out[1])}
+	})
+
+	return definition.Definition{
+		Method:      binding.Method,
+		Summary:     binding.Summary,
+		Description: binding.Description,
+		Consumes:    consumes,
+		Produces:    binding.Produces,
+		Function:    function.Interface(),
+		Parameters:  parameters,
+		Results:     definition.DataErrorResults("response of " + binding.GRPCMethod),
+	}
+}
+
+// transcode builds the gRPC request from args of the definition function and
+// calls the gRPC method.
+func transcode(invoker Invoker, binding Binding, pathFields, queryFields []field, args []reflect.Value) (proto.Message, error) {
+	ctx := args[0].Interface().(context.Context)
+	args = args[1:]
+	var request protoreflect.Message
+	if binding.Body != "" && !args[len(args)-1].IsNil() {
+		// Path parameters must not modify the decoded body which may be seen by
+		// others, such as interceptors.
+		request = proto.Clone(args[len(args)-1].Interface().(proto.Message)).ProtoReflect()
+	} else {
+		request = binding.Request.ProtoReflect().New()
+	}
+	for i, f := range pathFields {
+		if err := setField(request, f, []string{args[i].String()}); err != nil {
+			return nil, err
+		}
+	}
+	args = args[len(pathFields):]
+	for i, f := range queryFields {
+		var values []string
+		if f.descriptor.IsList() {
+			values = args[i].Interface().([]string)
+		} else if value := args[i].String(); value != "" {
+			values = []string{value}
+		}
+		if err := setField(request, f, values); err != nil {
+			return nil, err
+		}
+	}
+	response := binding.Response.ProtoReflect().New().Interface()
+	if err := invoker(ctx, binding.GRPCMethod, request.Interface(), response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// queryable checks whether a field can be set from strings.
+func queryable(fd protoreflect.FieldDescriptor) bool {
+	if fd.IsMap() {
+		return false
+	}
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return false
+	}
+	return true
+}
+
+// setField sets values to a field of message. Empty values leave the field unset.
+func setField(message protoreflect.Message, f field, values []string) error {
+	if len(values) == 0 {
+		return nil
+	}
+	if !f.descriptor.IsList() {
+		value, err := parseValue(f.descriptor, values[0])
+		if err != nil {
+			return invalidField.Error(values[0], f.name, err)
+		}
+		message.Set(f.descriptor, value)
+		return nil
+	}
+	list := message.Mutable(f.descriptor).List()
+	for _, v := range values {
+		value, err := parseValue(f.descriptor, v)
+		if err != nil {
+			return invalidField.Error(v, f.name, err)
+		}
+		list.Append(value)
+	}
+	return nil
+}
+
+// parseValue parses a string into a value of the kind of fd.
+func parseValue(fd protoreflect.FieldDescriptor, value string) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(value), nil
+	case protoreflect.BytesKind:
+		data, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			data, err = base64.URLEncoding.DecodeString(value)
+		}
+		return protoreflect.ValueOfBytes(data), err
+	case protoreflect.BoolKind:
+		v, err := strconv.ParseBool(value)
+		return protoreflect.ValueOfBool(v), err
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByName(protoreflect.Name(value)); ev != nil {
+			return protoreflect.ValueOfEnum(ev.Number()), nil
+		}
+		v, err := strconv.ParseInt(value, 10, 32)
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(v)), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		v, err := strconv.ParseInt(value, 10, 32)
+		return protoreflect.ValueOfInt32(int32(v)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		v, err := strconv.ParseInt(value, 10, 64)
+		return protoreflect.ValueOfInt64(v), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		v, err := strconv.ParseUint(value, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(v)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		v, err := strconv.ParseUint(value, 10, 64)
+		return protoreflect.ValueOfUint64(v), err
+	case protoreflect.FloatKind:
+		v, err := strconv.ParseFloat(value, 32)
+		return protoreflect.ValueOfFloat32(float32(v)), err
+	case protoreflect.DoubleKind:
+		v, err := strconv.ParseFloat(value, 64)
+		return protoreflect.ValueOfFloat64(v), err
+	}
+	return protoreflect.Value{}, fmt.Errorf("kind %v is not supported", fd.Kind())
+}
+
+// fieldDescription describes a request field for API documents.
+func fieldDescription(fd protoreflect.FieldDescriptor) string {
+	return fmt.Sprintf("field %s of the gRPC request", fd.Name())
+}
@@ -0,0 +1,201 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transcoding
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/caicloud/nirvana/definition"
+	"github.com/caicloud/nirvana/errors"
+	"github.com/caicloud/nirvana/service"
+	"github.com/caicloud/nirvana/service/rest"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func init() {
+	serializer := NewJSONSerializer(nil)
+	_ = service.RegisterConsumer(serializer)
+	_ = service.RegisterProducer(serializer)
+}
+
+// stub is a stub gRPC server which handles requests of durations and returns
+// the timestamps after the durations since epoch.
+type stub struct {
+	method  string
+	request proto.Message
+}
+
+func (s *stub) invoke(ctx context.Context, method string, request, response proto.Message) error {
+	s.method, s.request = method, request
+	switch method {
+	case "/test.Clock/Since":
+		d := request.(*durationpb.Duration)
+		if d.Seconds < 0 {
+			return errors.BadRequest.Error("negative duration")
+		}
+		r := response.(*timestamppb.Timestamp)
+		r.Seconds, r.Nanos = d.Seconds, d.Nanos
+	}
+	return nil
+}
+
+func newServer(t *testing.T, s *stub, path string, binding Binding) http.Handler {
+	builder := rest.NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:        path,
+		Consumes:    []string{definition.MIMEJSON},
+		Produces:    []string{definition.MIMEJSON, definition.MIMEYAML},
+		Definitions: []definition.Definition{DefinitionFor(s.invoke, binding)},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	server, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+func serve(server http.Handler, method, url, body string) *httptest.ResponseRecorder {
+	return serveWith(server, method, url, body, definition.MIMEJSON)
+}
+
+func serveWith(server http.Handler, method, url, body, accept string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	req.Header.Set("Accept", accept)
+	if body != "" {
+		req.Header.Set("Content-Type", definition.MIMEJSON)
+	}
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	return resp
+}
+
+func TestPathAndQuery(t *testing.T) {
+	s := &stub{}
+	server := newServer(t, s, "/since/{seconds}", Binding{
+		Method:         definition.Get,
+		GRPCMethod:     "/test.Clock/Since",
+		Request:        &durationpb.Duration{},
+		Response:       &timestamppb.Timestamp{},
+		PathParameters: []string{"seconds"},
+	})
+
+	resp := serve(server, "GET", "/since/42?nanos=7", "")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Unexpected response: %d %s", resp.Code, resp.Body.String())
+	}
+	if s.method != "/test.Clock/Since" {
+		t.Fatalf("Unexpected gRPC method: %s", s.method)
+	}
+	if d := s.request.(*durationpb.Duration); d.Seconds != 42 || d.Nanos != 7 {
+		t.Fatalf("Unexpected gRPC request: %v", d)
+	}
+	// Well-known types are written in their JSON forms.
+	var result string
+	if err := json.Unmarshal(resp.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result != "1970-01-01T00:00:42.000000007Z" {
+		t.Fatalf("Unexpected response: %s", resp.Body.String())
+	}
+
+	// Absent query parameters leave fields unset.
+	if resp := serve(server, "GET", "/since/1", ""); resp.Code != http.StatusOK || s.request.(*durationpb.Duration).Nanos != 0 {
+		t.Fatalf("Unexpected response: %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := serve(server, "GET", "/since/abc", ""); resp.Code != http.StatusBadRequest || !strings.Contains(resp.Body.String(), "seconds") {
+		t.Fatalf("Invalid field should be rejected: %d %s", resp.Code, resp.Body.String())
+	}
+	// Errors of gRPC methods are written as is.
+	if resp := serve(server, "GET", "/since/-1", ""); resp.Code != http.StatusBadRequest || !strings.Contains(resp.Body.String(), "negative") {
+		t.Fatalf("Error of gRPC method should be written: %d %s", resp.Code, resp.Body.String())
+	}
+}
+
+func TestBody(t *testing.T) {
+	s := &stub{}
+	server := newServer(t, s, "/since/{seconds}", Binding{
+		Method:         definition.Create,
+		GRPCMethod:     "/test.Clock/Since",
+		Request:        &durationpb.Duration{},
+		Response:       &timestamppb.Timestamp{},
+		PathParameters: []string{"seconds"},
+		Body:           "*",
+	})
+
+	resp := serve(server, "POST", "/since/42", `"1.000000007s"`)
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("Unexpected response: %d %s", resp.Code, resp.Body.String())
+	}
+	// Path parameters override fields in the body.
+	if d := s.request.(*durationpb.Duration); d.Seconds != 42 || d.Nanos != 7 {
+		t.Fatalf("Unexpected gRPC request: %v", d)
+	}
+	if body := resp.Body.String(); body != `"1970-01-01T00:00:42.000000007Z"` {
+		t.Fatalf("Unexpected response: %s", body)
+	}
+	if resp := serve(server, "POST", "/since/42", `{"seconds":1}`); resp.Code != http.StatusBadRequest {
+		t.Fatalf("Invalid body should be rejected: %d %s", resp.Code, resp.Body.String())
+	}
+}
+
+func TestContentTypes(t *testing.T) {
+	s := &stub{}
+	server := newServer(t, s, "/since/{seconds}", Binding{
+		Method:         definition.Get,
+		GRPCMethod:     "/test.Clock/Since",
+		Request:        &durationpb.Duration{},
+		Response:       &timestamppb.Timestamp{},
+		PathParameters: []string{"seconds"},
+	})
+
+	// Content types of the descriptor are used.
+	resp := serveWith(server, "GET", "/since/42", "", definition.MIMEYAML)
+	if resp.Code != http.StatusOK || !strings.HasPrefix(resp.Header().Get("Content-Type"), definition.MIMEYAML) {
+		t.Fatalf("Unexpected response: %d %s %s", resp.Code, resp.Header().Get("Content-Type"), resp.Body.String())
+	}
+	if body := resp.Body.String(); !strings.Contains(body, "seconds: 42") {
+		t.Fatalf("Unexpected response: %s", body)
+	}
+}
+
+func TestInvalidBinding(t *testing.T) {
+	invoker := (&stub{}).invoke
+	for _, binding := range []Binding{
+		{GRPCMethod: "/test.Clock/Since", Request: &durationpb.Duration{}},
+		{GRPCMethod: "/test.Clock/Since", Request: &durationpb.Duration{}, Response: &timestamppb.Timestamp{}, Body: "seconds"},
+		{GRPCMethod: "/test.Clock/Since", Request: &durationpb.Duration{}, Response: &timestamppb.Timestamp{}, PathParameters: []string{"minutes"}},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("DefinitionFor should panic for binding %+v", binding)
+				}
+			}()
+			DefinitionFor(invoker, binding)
+		}()
+	}
+}
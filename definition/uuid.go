@@ -0,0 +1,139 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package definition
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// UUID is a universally unique identifier defined by RFC 4122. Its bytes are in
+// the same order as other UUID packages, so it can be converted to their types
+// directly, such as uuid.UUID(u) of "github.com/google/uuid".
+type UUID [16]byte
+
+var uuidType = reflect.TypeOf(UUID{})
+
+// ParseUUID parses a UUID. Letters are case-insensitive. Besides the canonical
+// form "6ba7b810-9dad-11d1-80b4-00c04fd430c8", the braced form "{...}", the URN
+// form "urn:uuid:..." and 32 hex digits without hyphens are accepted.
+func ParseUUID(value string) (UUID, error) {
+	uuid := UUID{}
+	switch {
+	case len(value) == 38 && value[0] == '{' && value[37] == '}':
+		value = value[1:37]
+	case len(value) == 45 && strings.EqualFold(value[:9], "urn:uuid:"):
+		value = value[9:]
+	}
+	switch len(value) {
+	case 36:
+		if value[8] != '-' || value[13] != '-' || value[18] != '-' || value[23] != '-' {
+			return uuid, fmt.Errorf("invalid UUID %q", value)
+		}
+		value = value[:8] + value[9:13] + value[14:18] + value[19:23] + value[24:]
+	case 32:
+	default:
+		return uuid, fmt.Errorf("invalid length of UUID %q", value)
+	}
+	if _, err := hex.Decode(uuid[:], []byte(value)); err != nil {
+		return uuid, fmt.Errorf("invalid UUID %q: %v", value, err)
+	}
+	return uuid, nil
+}
+
+// IsNil checks whether the UUID is the nil UUID, whose bits are all zero.
+func (u UUID) IsNil() bool {
+	return u == UUID{}
+}
+
+// String returns the canonical form of the UUID, such as
+// "6ba7b810-9dad-11d1-80b4-00c04fd430c8".
+func (u UUID) String() string {
+	buf := make([]byte, 36)
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+	return string(buf)
+}
+
+// MarshalText encodes the UUID in the canonical form.
+func (u UUID) MarshalText() ([]byte, error) {
+	return []byte(u.String()), nil
+}
+
+// UnmarshalText parses a UUID in any form accepted by ParseUUID.
+func (u *UUID) UnmarshalText(data []byte) error {
+	uuid, err := ParseUUID(string(data))
+	if err != nil {
+		return err
+	}
+	*u = uuid
+	return nil
+}
+
+// UUIDOptions contains options of UUIDOperator.
+type UUIDOptions struct {
+	// RejectNil rejects the nil UUID "00000000-0000-0000-0000-000000000000".
+	RejectNil bool
+	// Typed makes the operator output UUID values instead of strings.
+	Typed bool
+	// Type is the type of output values if Typed is true. It must be convertible
+	// from UUID, such as reflect.TypeOf(uuid.UUID{}) of "github.com/google/uuid",
+	// so functions can take their own UUID types. Default to UUID.
+	Type reflect.Type
+}
+
+// UUIDOperator creates a converter for string values of UUIDs. Values in any
+// form accepted by ParseUUID are converted to the canonical form in lower case,
+// or to values of options.Type if options.Typed is true. Malformed values are
+// rejected with a bad request error. It panics if options.Type is not
+// convertible from UUID.
+func UUIDOperator(options UUIDOptions) Operator {
+	out := stringType
+	if options.Typed {
+		out = uuidType
+		if options.Type != nil {
+			if !uuidType.ConvertibleTo(options.Type) {
+				panic(fmt.Sprintf("Type %v of UUIDOperator is not convertible from %v", options.Type, uuidType))
+			}
+			out = options.Type
+		}
+	}
+	return NewOperator(converterKind, stringType, out, func(ctx context.Context, field string, object interface{}) (interface{}, error) {
+		value := object.(string)
+		uuid, err := ParseUUID(strings.TrimSpace(value))
+		if err != nil {
+			return nil, invalidUUID.Error(value, field)
+		}
+		if options.RejectNil && uuid.IsNil() {
+			return nil, nilUUID.Error(field)
+		}
+		if options.Typed {
+			return reflect.ValueOf(uuid).Convert(out).Interface(), nil
+		}
+		return uuid.String(), nil
+	})
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package errors
+
+import (
+	"strings"
+	"sync"
+)
+
+// Catalog contains localized formats of reasons. A localized format has the same
+// placeholders as the format of factory, and placeholders are replaced by data of
+// errors:
+//  catalog := errors.NewCatalog("en")
+//  catalog.Add("zh-CN", map[errors.Reason]string{
+//      "Nirvana:Definition:OutOfRange": "字段 '${field}' 的值 ${value} 不在 [${min}, ${max}] 之间",
+//  })
+// Languages are language tags like "en" and "zh-CN", and they are case insensitive.
+type Catalog struct {
+	lock            sync.RWMutex
+	defaultLanguage string
+	formats         map[string]map[Reason]string
+}
+
+// NewCatalog creates a catalog. Formats of defaultLanguage are used if no format
+// matches languages of a request.
+func NewCatalog(defaultLanguage string) *Catalog {
+	return &Catalog{
+		defaultLanguage: strings.ToLower(defaultLanguage),
+		formats:         map[string]map[Reason]string{},
+	}
+}
+
+// Add adds formats of a language. Formats with the same reasons override old ones.
+func (c *Catalog) Add(language string, formats map[Reason]string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	language = strings.ToLower(language)
+	fs := c.formats[language]
+	if fs == nil {
+		fs = map[Reason]string{}
+		c.formats[language] = fs
+	}
+	for reason, format := range formats {
+		fs[reason] = format
+	}
+}
+
+// Format formats a message of reason with data. Languages are in the order of
+// preference. A language matches formats of the same language or its base
+// language, so "zh-CN" matches "zh-CN" and then "zh". If no language matches, the
+// default language is used. Placeholders which are not in data are kept as is.
+// It returns false if there is no format for the reason.
+func (c *Catalog) Format(reason Reason, data map[string]string, languages ...string) (string, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	candidates := make([]string, 0, len(languages)+1)
+	candidates = append(candidates, languages...)
+	for _, language := range append(candidates, c.defaultLanguage) {
+		language = strings.ToLower(strings.TrimSpace(language))
+		for language != "" {
+			if format, ok := c.formats[language][reason]; ok {
+				return replace(format, data), true
+			}
+			index := strings.LastIndexByte(language, '-')
+			if index < 0 {
+				break
+			}
+			language = language[:index]
+		}
+	}
+	return "", false
+}
+
+// Localize returns a copy of error with a localized message. The copy is derived
+// from the same factory as e. It returns e if e is not created by a factory or
+// there is no format for it.
+func (c *Catalog) Localize(e error, languages ...string) error {
+	origin, ok := e.(*err)
+	if !ok {
+		return e
+	}
+	msg, ok := c.Format(origin.message.Reason, origin.message.Data, languages...)
+	if !ok {
+		return e
+	}
+	localized := *origin
+	localized.message.Message = msg
+	return &localized
+}
+
+// replace replaces placeholders like ${name} in format with data.
+func replace(format string, data map[string]string) string {
+	buf := make([]byte, 0, len(format))
+	for i := 0; i < len(format); {
+		if strings.HasPrefix(format[i:], "${") {
+			if end := strings.IndexByte(format[i:], '}'); end > 0 {
+				if value, ok := data[format[i+2:i+end]]; ok {
+					buf = append(buf, value...)
+					i += end + 1
+					continue
+				}
+			}
+		}
+		buf = append(buf, format[i])
+		i++
+	}
+	return string(buf)
+}
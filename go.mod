@@ -28,7 +28,7 @@ require (
 	golang.org/x/net v0.0.0-20200707034311-ab3426394381 // indirect
 	golang.org/x/text v0.3.3
 	golang.org/x/tools v0.0.0-20200103221440-774c71fcf114
-	google.golang.org/protobuf v1.24.0 // indirect
+	google.golang.org/protobuf v1.24.0
 	gopkg.in/go-playground/assert.v1 v1.2.1 // indirect
 	gopkg.in/go-playground/validator.v9 v9.17.1
 	gopkg.in/yaml.v2 v2.3.0
@@ -0,0 +1,350 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caicloud/nirvana/definition"
+	"github.com/caicloud/nirvana/service"
+)
+
+// Config contains options of cache.
+type Config struct {
+	// TTL is how long responses are cached. Defaults to 1 minute.
+	TTL time.Duration
+	// Vary contains names of request headers which vary responses, such as
+	// "Accept". Their values are parts of cache keys, and they are listed in
+	// header "Vary" of responses.
+	Vary []string
+	// MaxEntries limits the number of cached responses. Defaults to 10000.
+	// Responses are not cached if the cache is full of unexpired responses.
+	MaxEntries int
+	// MaxBodySize limits the size of a cached response body. Defaults to 1 MiB.
+	// Larger responses are written without being cached.
+	MaxBodySize int
+	// Private enables caching of requests with credentials. By default, requests
+	// with "Authorization" or "Cookie", and requests of definitions which have
+	// security requirements, bypass the cache. If it's enabled, values of
+	// "Authorization" and "Cookie" are parts of cache keys, so a cached response
+	// is only served to the same credentials, and it gets "Cache-Control: private"
+	// rather than "public".
+	Private bool
+}
+
+// Cache caches responses of safe methods (GET and HEAD) in memory. Responses
+// are cached by method, URL and values of Vary headers.
+type Cache struct {
+	config  Config
+	vary    string
+	lock    sync.Mutex
+	entries map[string]*entry
+	now     func() time.Time
+}
+
+type entry struct {
+	path    string
+	code    int
+	header  http.Header
+	body    []byte
+	created time.Time
+	expires time.Time
+}
+
+// New creates a cache. Responses are lost when the process exits, and are not
+// shared by multiple instances.
+func New(config Config) *Cache {
+	if config.TTL <= 0 {
+		config.TTL = time.Minute
+	}
+	if config.MaxEntries <= 0 {
+		config.MaxEntries = 10000
+	}
+	if config.MaxBodySize <= 0 {
+		config.MaxBodySize = 1 << 20
+	}
+	vary := make([]string, len(config.Vary))
+	for i, name := range config.Vary {
+		vary[i] = textproto.CanonicalMIMEHeaderKey(name)
+	}
+	config.Vary = vary
+	return &Cache{
+		config:  config,
+		vary:    strings.Join(vary, ", "),
+		entries: map[string]*entry{},
+		now:     time.Now,
+	}
+}
+
+// Middleware returns a middleware which serves cached responses. Install it to
+// the descriptors of definitions which return mostly static data.
+//
+// Responses of 200 are cached for TTL. If a response has no "Cache-Control", it
+// gets "Cache-Control: public, max-age=<TTL>" ("private" for requests with
+// credentials). Responses with "Set-Cookie" or "Cache-Control: no-store" or
+// "private" are not cached. Cached responses have header "Age", and don't reach
+// the handler. Requests with "Cache-Control:
+// no-cache" skip cached responses and refresh them, and requests with
+// "Cache-Control: no-store" bypass the cache. Requests with credentials bypass
+// the cache unless Config.Private is enabled. Requests are authenticated after
+// middlewares, so responses of definitions with security requirements are only
+// cached as private responses, whose keys contain the credentials.
+func (c *Cache) Middleware() definition.Middleware {
+	return func(ctx context.Context, chain definition.Chain) error {
+		httpCtx := service.HTTPContextFrom(ctx)
+		req := httpCtx.Request()
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			return chain.Continue(ctx)
+		}
+		directives := req.Header.Get("Cache-Control")
+		if hasDirective(directives, "no-store") {
+			return chain.Continue(ctx)
+		}
+		private := credentialed(ctx, req)
+		if private && !c.config.Private {
+			return chain.Continue(ctx)
+		}
+		key := c.key(req, private)
+		if !hasDirective(directives, "no-cache") {
+			if e, age := c.get(key); e != nil {
+				return replay(httpCtx.ResponseWriter(), e, age)
+			}
+		}
+		r := &recorder{
+			code:  http.StatusOK,
+			limit: c.config.MaxBodySize,
+			before: func(header http.Header) {
+				c.setHeaders(header, private || service.Secured(ctx))
+			},
+		}
+		if !service.WrapResponseWriter(ctx, func(w http.ResponseWriter) http.ResponseWriter {
+			r.ResponseWriter = w
+			return r
+		}) {
+			return chain.Continue(ctx)
+		}
+		if err := chain.Continue(ctx); err != nil {
+			// Errors returned by middlewares are written later, so they are not cached.
+			return err
+		}
+		if r.header == nil || r.code != http.StatusOK || r.overflowed || !cacheable(r.header, private) {
+			return nil
+		}
+		if !private && service.Secured(ctx) {
+			// Credentials are not in "Authorization" or "Cookie", such as api keys
+			// in queries or other headers, so the key can't tell them apart.
+			return nil
+		}
+		c.set(key, &entry{
+			path:   req.URL.Path,
+			code:   r.code,
+			header: r.header,
+			body:   r.body.Bytes(),
+		})
+		return nil
+	}
+}
+
+// Invalidate removes cached responses of a path, including responses of all
+// methods, queries and values of Vary headers.
+func (c *Cache) Invalidate(path string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for key, e := range c.entries {
+		if e.path == path {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// Purge removes all cached responses.
+func (c *Cache) Purge() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.entries = map[string]*entry{}
+}
+
+// credentialed checks whether a request has credentials or is handled by a
+// definition with security requirements.
+func credentialed(ctx context.Context, req *http.Request) bool {
+	return req.Header.Get("Authorization") != "" || req.Header.Get("Cookie") != "" || service.Secured(ctx)
+}
+
+// key returns the cache key of a request. Keys of private requests contain their
+// credentials.
+func (c *Cache) key(req *http.Request, private bool) string {
+	builder := strings.Builder{}
+	builder.WriteString(req.Method)
+	builder.WriteByte(' ')
+	builder.WriteString(req.URL.RequestURI())
+	for _, name := range c.config.Vary {
+		builder.WriteByte('\n')
+		builder.WriteString(name)
+		builder.WriteByte(':')
+		builder.WriteString(strings.Join(req.Header[name], ","))
+	}
+	if private {
+		for _, name := range []string{"Authorization", "Cookie"} {
+			builder.WriteString("\ncredential ")
+			builder.WriteString(name)
+			builder.WriteByte(':')
+			builder.WriteString(strings.Join(req.Header[name], ","))
+		}
+	}
+	return builder.String()
+}
+
+// get returns an unexpired entry and its age.
+func (c *Cache) get(key string) (*entry, time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, 0
+	}
+	now := c.now()
+	if !now.Before(e.expires) {
+		delete(c.entries, key)
+		return nil, 0
+	}
+	return e, now.Sub(e.created)
+}
+
+// set caches an entry. If the cache is full, expired entries are removed first.
+func (c *Cache) set(key string, e *entry) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := c.now()
+	e.created = now
+	e.expires = now.Add(c.config.TTL)
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.config.MaxEntries {
+		for k, old := range c.entries {
+			if !now.Before(old.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= c.config.MaxEntries {
+			return
+		}
+	}
+	c.entries[key] = e
+}
+
+// setHeaders sets cache headers of a response before it's written. Responses of
+// private requests are never marked as public.
+func (c *Cache) setHeaders(header http.Header, private bool) {
+	if c.vary != "" {
+		header.Add("Vary", c.vary)
+	}
+	if header.Get("Cache-Control") == "" {
+		visibility := "public"
+		if private {
+			visibility = "private"
+		}
+		header.Set("Cache-Control", visibility+", max-age="+strconv.Itoa(int(c.config.TTL/time.Second)))
+	}
+}
+
+// cacheable checks whether a response can be cached. Responses of private
+// requests may be private.
+func cacheable(header http.Header, private bool) bool {
+	if len(header["Set-Cookie"]) > 0 {
+		return false
+	}
+	directives := header.Get("Cache-Control")
+	return !hasDirective(directives, "no-store") && (private || !hasDirective(directives, "private"))
+}
+
+// hasDirective checks whether a "Cache-Control" value has a directive.
+func hasDirective(value, directive string) bool {
+	for _, d := range strings.Split(value, ",") {
+		d = strings.TrimSpace(d)
+		if index := strings.IndexByte(d, '='); index >= 0 {
+			d = d[:index]
+		}
+		if strings.EqualFold(d, directive) {
+			return true
+		}
+	}
+	return false
+}
+
+// replay writes a cached response.
+func replay(w service.ResponseWriter, e *entry, age time.Duration) error {
+	headers := w.Header()
+	for k, vs := range e.header {
+		headers[k] = append([]string(nil), vs...)
+	}
+	headers.Set("Age", strconv.Itoa(int(age/time.Second)))
+	w.WriteHeader(e.code)
+	_, err := w.Write(e.body)
+	return err
+}
+
+// recorder records the response which is written to ResponseWriter.
+type recorder struct {
+	http.ResponseWriter
+	code   int
+	header http.Header
+	body   bytes.Buffer
+	// limit is the max size of body. The body is dropped if it's exceeded.
+	limit      int
+	overflowed bool
+	// before is called with the header before it's written.
+	before func(header http.Header)
+}
+
+func (r *recorder) WriteHeader(code int) {
+	if r.header == nil {
+		r.code = code
+		if code == http.StatusOK {
+			r.before(r.Header())
+		}
+		r.header = r.Header().Clone()
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *recorder) Write(data []byte) (int, error) {
+	if r.header == nil {
+		r.WriteHeader(http.StatusOK)
+	}
+	n, err := r.ResponseWriter.Write(data)
+	if !r.overflowed {
+		if r.body.Len()+n > r.limit {
+			r.overflowed = true
+			r.body = bytes.Buffer{}
+		} else {
+			r.body.Write(data[:n])
+		}
+	}
+	return n, err
+}
+
+// Flush flushes the underlying writer.
+func (r *recorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,337 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caicloud/nirvana/definition"
+	"github.com/caicloud/nirvana/errors"
+	"github.com/caicloud/nirvana/service"
+	"github.com/caicloud/nirvana/service/rest"
+)
+
+func newServer(t *testing.T, c *Cache, calls *int) service.Service {
+	builder := rest.NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:        "/products/{id}",
+		Consumes:    []string{definition.MIMEAll},
+		Produces:    []string{definition.MIMEText, definition.MIMEJSON},
+		Middlewares: []definition.Middleware{c.Middleware()},
+		Definitions: []definition.Definition{
+			{
+				Method: definition.Get,
+				Function: func(ctx context.Context, id string) (map[string]string, string, error) {
+					*calls++
+					switch id {
+					case "private":
+						return map[string]string{"Cache-Control": "private"}, id, nil
+					case "missing":
+						return nil, "", fmt.Errorf("missing")
+					}
+					return nil, fmt.Sprintf("%s-%d", id, *calls), nil
+				},
+				Parameters: []definition.Parameter{definition.PathParameterFor("id", "")},
+				Results: []definition.Result{
+					definition.MetaResultFor(""),
+					definition.DataResultFor(""),
+					definition.ErrorResult(),
+				},
+			},
+			{
+				Method: definition.Update,
+				Function: func(ctx context.Context, id string) error {
+					*calls++
+					return nil
+				},
+				Parameters: []definition.Parameter{definition.PathParameterFor("id", "")},
+				Results:    []definition.Result{definition.ErrorResult()},
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func get(s service.Service, method, path string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp := httptest.NewRecorder()
+	s.ServeHTTP(resp, req)
+	return resp
+}
+
+func TestCache(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := New(Config{TTL: time.Minute})
+	c.now = func() time.Time { return now }
+	calls := 0
+	s := newServer(t, c, &calls)
+	text := map[string]string{"Accept": definition.MIMEText}
+
+	resp := get(s, http.MethodGet, "/products/1", text)
+	if resp.Code != http.StatusOK || resp.Body.String() != "1-1" || resp.Header().Get("Age") != "" {
+		t.Fatalf("Unexpected first response: %d %s %v", resp.Code, resp.Body.String(), resp.Header())
+	}
+	if got := resp.Header().Get("Cache-Control"); got != "public, max-age=60" {
+		t.Fatalf("Unexpected Cache-Control: %s", got)
+	}
+
+	now = now.Add(10 * time.Second)
+	resp = get(s, http.MethodGet, "/products/1", text)
+	if resp.Code != http.StatusOK || resp.Body.String() != "1-1" || calls != 1 {
+		t.Fatalf("Response should be cached: %d %s %d", resp.Code, resp.Body.String(), calls)
+	}
+	if resp.Header().Get("Age") != "10" || resp.Header().Get("Cache-Control") != "public, max-age=60" {
+		t.Fatalf("Unexpected headers of cached response: %v", resp.Header())
+	}
+
+	// Queries are parts of keys.
+	if resp = get(s, http.MethodGet, "/products/1?page=2", text); resp.Body.String() != "1-2" {
+		t.Fatalf("Response of another query should not be cached: %s", resp.Body.String())
+	}
+
+	// Unsafe methods and uncacheable responses are not cached.
+	for i := 0; i < 2; i++ {
+		get(s, http.MethodPut, "/products/1", text)
+		get(s, http.MethodGet, "/products/private", text)
+		get(s, http.MethodGet, "/products/missing", text)
+	}
+	if calls != 8 {
+		t.Fatalf("Only safe methods and responses of 200 should be cached: %d", calls)
+	}
+
+	// Requests can skip cached responses.
+	if resp = get(s, http.MethodGet, "/products/1", map[string]string{
+		"Accept":        definition.MIMEText,
+		"Cache-Control": "no-store",
+	}); resp.Body.String() != "1-9" {
+		t.Fatalf("Request with no-store should bypass the cache: %s", resp.Body.String())
+	}
+	if resp = get(s, http.MethodGet, "/products/1", text); resp.Body.String() != "1-1" {
+		t.Fatalf("Request with no-store should not change the cache: %s", resp.Body.String())
+	}
+	if resp = get(s, http.MethodGet, "/products/1", map[string]string{
+		"Accept":        definition.MIMEText,
+		"Cache-Control": "no-cache",
+	}); resp.Body.String() != "1-10" {
+		t.Fatalf("Request with no-cache should skip the cache: %s", resp.Body.String())
+	}
+	if resp = get(s, http.MethodGet, "/products/1", text); resp.Body.String() != "1-10" || resp.Header().Get("Age") != "0" {
+		t.Fatalf("Request with no-cache should refresh the cache: %s %v", resp.Body.String(), resp.Header())
+	}
+}
+
+func TestCacheExpiry(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := New(Config{TTL: time.Minute})
+	c.now = func() time.Time { return now }
+	calls := 0
+	s := newServer(t, c, &calls)
+	text := map[string]string{"Accept": definition.MIMEText}
+
+	get(s, http.MethodGet, "/products/1", text)
+	now = now.Add(time.Minute - time.Second)
+	if resp := get(s, http.MethodGet, "/products/1", text); resp.Body.String() != "1-1" || resp.Header().Get("Age") != "59" {
+		t.Fatalf("Response should be cached before TTL: %s %v", resp.Body.String(), resp.Header())
+	}
+	now = now.Add(time.Second)
+	if resp := get(s, http.MethodGet, "/products/1", text); resp.Body.String() != "1-2" || resp.Header().Get("Age") != "" {
+		t.Fatalf("Response should expire after TTL: %s %v", resp.Body.String(), resp.Header())
+	}
+
+	// Invalidated responses are removed before TTL.
+	get(s, http.MethodGet, "/products/2", text)
+	c.Invalidate("/products/1")
+	if resp := get(s, http.MethodGet, "/products/1", text); resp.Body.String() != "1-4" {
+		t.Fatalf("Invalidated response should not be cached: %s", resp.Body.String())
+	}
+	if resp := get(s, http.MethodGet, "/products/2", text); resp.Body.String() != "2-3" {
+		t.Fatalf("Responses of other paths should be kept: %s", resp.Body.String())
+	}
+	c.Purge()
+	if resp := get(s, http.MethodGet, "/products/2", text); resp.Body.String() != "2-5" {
+		t.Fatalf("Purged response should not be cached: %s", resp.Body.String())
+	}
+}
+
+func TestCacheVary(t *testing.T) {
+	c := New(Config{Vary: []string{"accept"}})
+	calls := 0
+	s := newServer(t, c, &calls)
+
+	resp := get(s, http.MethodGet, "/products/1", map[string]string{"Accept": definition.MIMEText})
+	if resp.Body.String() != "1-1" || resp.Header().Get("Vary") != "Accept" {
+		t.Fatalf("Unexpected text response: %s %v", resp.Body.String(), resp.Header())
+	}
+	resp = get(s, http.MethodGet, "/products/1", map[string]string{"Accept": definition.MIMEJSON})
+	if resp.Body.String() != "1-2" || resp.Header().Get("Content-Type") != definition.MIMEJSON {
+		t.Fatalf("Response of another Accept should not be cached: %s %v", resp.Body.String(), resp.Header())
+	}
+	resp = get(s, http.MethodGet, "/products/1", map[string]string{"Accept": definition.MIMEText})
+	if resp.Body.String() != "1-1" || resp.Header().Get("Content-Type") != definition.MIMEText {
+		t.Fatalf("Response should be cached by Accept: %s %v", resp.Body.String(), resp.Header())
+	}
+	resp = get(s, http.MethodGet, "/products/1", map[string]string{"Accept": definition.MIMEJSON})
+	if resp.Body.String() != "1-2" || calls != 2 {
+		t.Fatalf("Response should be cached by Accept: %s %d", resp.Body.String(), calls)
+	}
+}
+
+func TestCacheCredentials(t *testing.T) {
+	if err := service.RegisterSecurityScheme(definition.BearerScheme("test-cache-bearer", ""),
+		func(ctx context.Context, token string, scopes []string) error {
+			if token != "token" {
+				return errors.Unauthorized.Error("invalid token")
+			}
+			return nil
+		}); err != nil {
+		t.Fatal(err)
+	}
+	for _, private := range []bool{false, true} {
+		c := New(Config{Private: private})
+		calls := 0
+		builder := rest.NewBuilder()
+		builder.SetModifier(service.FirstContextParameter())
+		if err := builder.AddDescriptor(definition.Descriptor{
+			Path:        "/secret",
+			Consumes:    []string{definition.MIMENone},
+			Produces:    []string{definition.MIMEText},
+			Middlewares: []definition.Middleware{c.Middleware()},
+			Definitions: []definition.Definition{
+				{
+					Method: definition.Get,
+					Function: func(ctx context.Context) (string, error) {
+						calls++
+						return fmt.Sprintf("secret-%d", calls), nil
+					},
+					Results:  definition.DataErrorResults(""),
+					Security: []definition.SecurityRequirement{definition.SecurityFor("test-cache-bearer")},
+				},
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		s, err := builder.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		authorized := map[string]string{"Authorization": "Bearer token"}
+		resp := get(s, http.MethodGet, "/secret", authorized)
+		if resp.Code != http.StatusOK || resp.Body.String() != "secret-1" {
+			t.Fatalf("Unexpected response: %d %s", resp.Code, resp.Body.String())
+		}
+		if got := resp.Header().Get("Cache-Control"); strings.Contains(got, "public") {
+			t.Fatalf("Response with credentials should not be public: %s", got)
+		}
+		resp = get(s, http.MethodGet, "/secret", nil)
+		if resp.Code != http.StatusUnauthorized || strings.Contains(resp.Body.String(), "secret") {
+			t.Fatalf("Unauthenticated request should be rejected after an authenticated one was cached: %d %s", resp.Code, resp.Body.String())
+		}
+		resp = get(s, http.MethodGet, "/secret", authorized)
+		if private && (resp.Body.String() != "secret-1" || resp.Header().Get("Cache-Control") != "private, max-age=60") {
+			t.Fatalf("Private response should be cached: %s %v", resp.Body.String(), resp.Header())
+		}
+		if !private && resp.Body.String() != "secret-2" {
+			t.Fatalf("Response with credentials should not be cached: %s", resp.Body.String())
+		}
+	}
+
+	// Requests with cookies bypass the cache even if definitions are public.
+	c := New(Config{})
+	calls := 0
+	s := newServer(t, c, &calls)
+	cookie := map[string]string{"Accept": definition.MIMEText, "Cookie": "session=alice"}
+	get(s, http.MethodGet, "/products/1", cookie)
+	if resp := get(s, http.MethodGet, "/products/1", cookie); resp.Body.String() != "1-2" || resp.Header().Get("Cache-Control") != "" {
+		t.Fatalf("Request with cookie should bypass the cache: %s %v", resp.Body.String(), resp.Header())
+	}
+}
+
+func TestCacheSecuredRequests(t *testing.T) {
+	if err := service.RegisterSecurityScheme(definition.APIKeyScheme("test-cache-key", definition.Header, "X-API-Key"),
+		func(ctx context.Context, key string, scopes []string) error {
+			if key != "key" {
+				return errors.Unauthorized.Error("invalid key")
+			}
+			return nil
+		}); err != nil {
+		t.Fatal(err)
+	}
+	c := New(Config{Private: true})
+	calls := 0
+	builder := rest.NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:        "/secret",
+		Consumes:    []string{definition.MIMENone},
+		Produces:    []string{definition.MIMEText},
+		Middlewares: []definition.Middleware{c.Middleware()},
+		Definitions: []definition.Definition{
+			{
+				Method: definition.Get,
+				Function: func(ctx context.Context) (string, error) {
+					calls++
+					return fmt.Sprintf("secret-%d", calls), nil
+				},
+				Results:  definition.DataErrorResults(""),
+				Security: []definition.SecurityRequirement{definition.SecurityFor("test-cache-key")},
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Credentials which are not parts of cache keys make responses uncacheable.
+	resp := get(s, http.MethodGet, "/secret", map[string]string{"X-API-Key": "key"})
+	if resp.Code != http.StatusOK || strings.Contains(resp.Header().Get("Cache-Control"), "public") {
+		t.Fatalf("Secured response should not be public: %d %v", resp.Code, resp.Header())
+	}
+	resp = get(s, http.MethodGet, "/secret", nil)
+	if resp.Code != http.StatusUnauthorized || strings.Contains(resp.Body.String(), "secret") {
+		t.Fatalf("Secured response should not be served from the cache: %d %s", resp.Code, resp.Body.String())
+	}
+}
+
+func TestCacheMaxBodySize(t *testing.T) {
+	c := New(Config{MaxBodySize: 2})
+	calls := 0
+	s := newServer(t, c, &calls)
+	text := map[string]string{"Accept": definition.MIMEText}
+	get(s, http.MethodGet, "/products/1", text)
+	if resp := get(s, http.MethodGet, "/products/1", text); resp.Body.String() != "1-2" {
+		t.Fatalf("Large response should not be cached: %s", resp.Body.String())
+	}
+}
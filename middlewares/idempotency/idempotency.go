@@ -0,0 +1,333 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/caicloud/nirvana/definition"
+	"github.com/caicloud/nirvana/errors"
+	"github.com/caicloud/nirvana/service"
+)
+
+// HeaderKey is the name of request header which carries idempotency keys.
+const HeaderKey = "Idempotency-Key"
+
+// HeaderReplayed is set to "true" in replayed responses.
+const HeaderReplayed = "Idempotent-Replayed"
+
+var (
+	inFlight       = errors.Conflict.Build("Nirvana:Idempotency:InFlight", "a request with idempotency key ${key} is in progress")
+	mismatchedBody = errors.UnprocessableEntity.Build("Nirvana:Idempotency:MismatchedBody", "idempotency key ${key} was used by a request with another body")
+	bodyTooLarge   = errors.RequestEntityTooLarge.Build("Nirvana:Idempotency:BodyTooLarge", "request body with idempotency key is larger than ${size} bytes")
+	unreadableBody = errors.BadRequest.Build("Nirvana:Idempotency:UnreadableBody", "can't read request body: ${reason}")
+)
+
+// Response is a cached response.
+type Response struct {
+	// Code is the status code.
+	Code int
+	// Header is the header of response.
+	Header http.Header
+	// Body is the body of response.
+	Body []byte
+	// Fingerprint is the hash of the request body which got the response.
+	Fingerprint string
+}
+
+// Store stores responses of idempotency keys. It must be safe for concurrent use.
+type Store interface {
+	// Begin starts a request of key. If key has a cached response, it returns the
+	// response. If another request of key is in progress, it returns false.
+	// Otherwise the key is locked until Complete or Abort is called.
+	Begin(ctx context.Context, key string) (cached *Response, ok bool, err error)
+	// Complete caches the response of key for ttl and unlocks the key.
+	Complete(ctx context.Context, key string, resp *Response, ttl time.Duration) error
+	// Abort unlocks the key without a response, so that the request can be retried.
+	Abort(ctx context.Context, key string) error
+}
+
+// Config contains options of idempotency.
+type Config struct {
+	// TTL is how long responses are cached. Defaults to 24 hours.
+	TTL time.Duration
+	// Methods are the HTTP methods which support idempotency keys. Defaults to
+	// POST and PATCH.
+	Methods []string
+	// Store stores responses. Defaults to a MemoryStore.
+	Store Store
+	// Scope returns the scope of keys, such as the client of the request. Requests
+	// of different scopes never share responses, even if they have the same key.
+	// Defaults to the hash of header "Authorization".
+	Scope func(ctx context.Context) string
+	// MaxBodySize limits the size of request bodies with keys, which are read into
+	// memory to get their fingerprints. Larger bodies are rejected with 413.
+	// Defaults to 1 MiB.
+	MaxBodySize int64
+}
+
+// New returns a middleware which supports idempotency keys. If a request has
+// header "Idempotency-Key", its response is cached by the scope, key, method and
+// path. Replays of the request get the cached response with "Idempotent-Replayed:
+// true", and don't reach the handler. A replay during the first request gets a
+// conflict error (409), and a request which reuses a key with another body gets
+// an unprocessable entity error (422). Responses with 5xx are not cached, so the
+// request can be retried.
+//
+// Install it to Definition.Middlewares of definitions which need idempotency:
+//
+//	definition.Definition{
+//		Method:      definition.Create,
+//		Middlewares: []definition.Middleware{idempotency.New(idempotency.Config{})},
+//		...
+//	}
+//
+// Requests without the header are not affected. Requests are authenticated
+// after middlewares, so Scope should get clients from requests (such as their
+// credentials) rather than values set by authenticators.
+func New(config Config) definition.Middleware {
+	if config.TTL <= 0 {
+		config.TTL = 24 * time.Hour
+	}
+	if len(config.Methods) <= 0 {
+		config.Methods = []string{http.MethodPost, http.MethodPatch}
+	}
+	if config.Store == nil {
+		config.Store = NewMemoryStore()
+	}
+	if config.Scope == nil {
+		config.Scope = authorizationScope
+	}
+	if config.MaxBodySize <= 0 {
+		config.MaxBodySize = 1 << 20
+	}
+	methods := map[string]bool{}
+	for _, m := range config.Methods {
+		methods[m] = true
+	}
+	return func(ctx context.Context, chain definition.Chain) error {
+		httpCtx := service.HTTPContextFrom(ctx)
+		req := httpCtx.Request()
+		key := req.Header.Get(HeaderKey)
+		if key == "" || !methods[req.Method] {
+			return chain.Continue(ctx)
+		}
+		fingerprint, err := readBody(req, config.MaxBodySize)
+		if err != nil {
+			return err
+		}
+		storeKey := config.Scope(ctx) + " " + req.Method + " " + req.URL.Path + " " + key
+		cached, ok, err := config.Store.Begin(ctx, storeKey)
+		if err != nil {
+			return err
+		}
+		if cached != nil {
+			if cached.Fingerprint != fingerprint {
+				return mismatchedBody.Error(key)
+			}
+			return replay(httpCtx.ResponseWriter(), cached)
+		}
+		if !ok {
+			return inFlight.Error(key)
+		}
+		r := &recorder{code: http.StatusOK}
+		if !service.WrapResponseWriter(ctx, func(w http.ResponseWriter) http.ResponseWriter {
+			r.ResponseWriter = w
+			return r
+		}) {
+			_ = config.Store.Abort(ctx, storeKey)
+			return chain.Continue(ctx)
+		}
+		defer func() {
+			if r := recover(); r != nil {
+				// Unlock the key for retries and leave the panic to the service.
+				_ = config.Store.Abort(ctx, storeKey)
+				panic(r)
+			}
+		}()
+		err = chain.Continue(ctx)
+		if err != nil || r.header == nil || r.code >= http.StatusInternalServerError {
+			// Errors returned by middlewares are written later, so they are not cached.
+			if e := config.Store.Abort(ctx, storeKey); e != nil && err == nil {
+				err = e
+			}
+			return err
+		}
+		return config.Store.Complete(ctx, storeKey, &Response{
+			Code:        r.code,
+			Header:      r.header,
+			Body:        r.body.Bytes(),
+			Fingerprint: fingerprint,
+		}, config.TTL)
+	}
+}
+
+// authorizationScope scopes keys by the hash of header "Authorization".
+func authorizationScope(ctx context.Context) string {
+	authorization := service.HTTPContextFrom(ctx).Request().Header.Get("Authorization")
+	if authorization == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(authorization))
+	return hex.EncodeToString(sum[:])
+}
+
+// readBody reads the request body into memory and returns its fingerprint. The
+// body of request is replaced, so that the handler can read it again.
+func readBody(req *http.Request, limit int64) (string, error) {
+	hash := sha256.New()
+	if req.Body == nil || req.Body == http.NoBody {
+		return hex.EncodeToString(hash.Sum(nil)), nil
+	}
+	if req.ContentLength > limit {
+		return "", bodyTooLarge.Error(limit)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(req.Body, limit+1))
+	_ = req.Body.Close()
+	if err != nil {
+		return "", unreadableBody.Error(err)
+	}
+	if int64(len(data)) > limit {
+		return "", bodyTooLarge.Error(limit)
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(data))
+	hash.Write(data)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// replay writes a cached response.
+func replay(w service.ResponseWriter, resp *Response) error {
+	headers := w.Header()
+	for k, vs := range resp.Header {
+		headers[k] = append([]string(nil), vs...)
+	}
+	headers.Set(HeaderReplayed, "true")
+	w.WriteHeader(resp.Code)
+	_, err := w.Write(resp.Body)
+	return err
+}
+
+// recorder records the response which is written to ResponseWriter.
+type recorder struct {
+	http.ResponseWriter
+	code   int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(code int) {
+	if r.header == nil {
+		r.code = code
+		r.header = r.Header().Clone()
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *recorder) Write(data []byte) (int, error) {
+	if r.header == nil {
+		r.WriteHeader(http.StatusOK)
+	}
+	n, err := r.ResponseWriter.Write(data)
+	r.body.Write(data[:n])
+	return n, err
+}
+
+// Flush flushes the underlying writer.
+func (r *recorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// MemoryStore is an in-memory store of responses.
+type MemoryStore struct {
+	lock    sync.Mutex
+	entries map[string]*entry
+	begins  int
+	now     func() time.Time
+}
+
+type entry struct {
+	// resp is nil if the request is in progress.
+	resp    *Response
+	expires time.Time
+}
+
+// sweepInterval is the number of begins between two sweeps of expired responses.
+const sweepInterval = 1024
+
+// NewMemoryStore creates an in-memory store. Responses are lost when the process
+// exits, and are not shared by multiple instances.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries: map[string]*entry{},
+		now:     time.Now,
+	}
+}
+
+// Begin starts a request of key.
+func (s *MemoryStore) Begin(ctx context.Context, key string) (*Response, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := s.now()
+
+	s.begins++
+	if s.begins >= sweepInterval {
+		s.begins = 0
+		for k, e := range s.entries {
+			if e.resp != nil && !now.Before(e.expires) {
+				delete(s.entries, k)
+			}
+		}
+	}
+
+	e, ok := s.entries[key]
+	if ok && e.resp == nil {
+		return nil, false, nil
+	}
+	if ok && now.Before(e.expires) {
+		return e.resp, true, nil
+	}
+	s.entries[key] = &entry{}
+	return nil, true, nil
+}
+
+// Complete caches the response of key.
+func (s *MemoryStore) Complete(ctx context.Context, key string, resp *Response, ttl time.Duration) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.entries[key] = &entry{resp: resp, expires: s.now().Add(ttl)}
+	return nil
+}
+
+// Abort unlocks the key.
+func (s *MemoryStore) Abort(ctx context.Context, key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if e, ok := s.entries[key]; ok && e.resp == nil {
+		delete(s.entries, key)
+	}
+	return nil
+}
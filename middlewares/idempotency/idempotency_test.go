@@ -0,0 +1,188 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package idempotency
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caicloud/nirvana/definition"
+	"github.com/caicloud/nirvana/errors"
+	"github.com/caicloud/nirvana/service"
+	"github.com/caicloud/nirvana/service/rest"
+)
+
+func TestMemoryStore(t *testing.T) {
+	now := time.Unix(0, 0)
+	s := NewMemoryStore()
+	s.now = func() time.Time { return now }
+	ctx := context.Background()
+	if cached, ok, _ := s.Begin(ctx, "a"); cached != nil || !ok {
+		t.Fatalf("First request should lock the key: %v %v", cached, ok)
+	}
+	if _, ok, _ := s.Begin(ctx, "a"); ok {
+		t.Fatal("Key in progress should not be locked again")
+	}
+	_ = s.Abort(ctx, "a")
+	if _, ok, _ := s.Begin(ctx, "a"); !ok {
+		t.Fatal("Aborted key should be locked again")
+	}
+	resp := &Response{Code: 201, Body: []byte("ok")}
+	_ = s.Complete(ctx, "a", resp, time.Minute)
+	if cached, _, _ := s.Begin(ctx, "a"); cached != resp {
+		t.Fatalf("Cached response should be returned: %v", cached)
+	}
+	now = now.Add(time.Minute)
+	if cached, ok, _ := s.Begin(ctx, "a"); cached != nil || !ok {
+		t.Fatalf("Expired response should not be returned: %v %v", cached, ok)
+	}
+}
+
+func TestIdempotency(t *testing.T) {
+	calls := 0
+	started := make(chan struct{})
+	release := make(chan struct{})
+	failed := errors.InternalServerError.Build("Nirvana:Test:Failed", "failed")
+	builder := rest.NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/payments",
+		Consumes: []string{definition.MIMEAll},
+		Produces: []string{definition.MIMEText},
+		Definitions: []definition.Definition{
+			{
+				Method:      definition.Create,
+				Middlewares: []definition.Middleware{New(Config{})},
+				Function: func(ctx context.Context, mode string) (map[string]string, string, error) {
+					calls++
+					switch mode {
+					case "slow":
+						close(started)
+						<-release
+					case "fail":
+						return nil, "", failed.Error()
+					}
+					return map[string]string{"X-Payment": fmt.Sprint(calls)}, fmt.Sprintf("payment %d", calls), nil
+				},
+				Parameters: []definition.Parameter{definition.QueryParameterFor("mode", "")},
+				Results: []definition.Result{
+					definition.MetaResultFor(""),
+					definition.DataResultFor(""),
+					definition.ErrorResult(),
+				},
+			},
+			{
+				Method: definition.Get,
+				Function: func(ctx context.Context) (string, error) {
+					calls++
+					return "list", nil
+				},
+				Results: definition.DataErrorResults(""),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	send := func(method, key, mode, body string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/payments?mode="+mode, strings.NewReader(body))
+		if body != "" {
+			req.Header.Set("Content-Type", definition.MIMEText)
+		}
+		if key != "" {
+			req.Header.Set(HeaderKey, key)
+		}
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp := httptest.NewRecorder()
+		s.ServeHTTP(resp, req)
+		return resp
+	}
+	do := func(method, key, mode string) *httptest.ResponseRecorder {
+		return send(method, key, mode, "", nil)
+	}
+
+	resp := do("POST", "a", "")
+	if resp.Code != http.StatusCreated || resp.Body.String() != "payment 1" || resp.Header().Get(HeaderReplayed) != "" {
+		t.Fatalf("First response is %d %s %v", resp.Code, resp.Body.String(), resp.Header())
+	}
+	resp = do("POST", "a", "")
+	if resp.Code != http.StatusCreated || resp.Body.String() != "payment 1" ||
+		resp.Header().Get("X-Payment") != "1" || resp.Header().Get(HeaderReplayed) != "true" || calls != 1 {
+		t.Fatalf("Replayed response is %d %s %v with %d calls", resp.Code, resp.Body.String(), resp.Header(), calls)
+	}
+	if resp = do("POST", "", ""); resp.Body.String() != "payment 2" {
+		t.Fatalf("Requests without keys should not be cached: %s", resp.Body.String())
+	}
+	if resp = do("POST", "b", ""); resp.Body.String() != "payment 3" {
+		t.Fatalf("Different keys should not share responses: %s", resp.Body.String())
+	}
+	if do("GET", "a", ""); calls != 4 {
+		t.Fatalf("Methods without idempotency should not be cached: %d calls", calls)
+	}
+
+	// Server errors are not cached.
+	if resp = do("POST", "c", "fail"); resp.Code != http.StatusInternalServerError {
+		t.Fatalf("Response code should be 500: %d", resp.Code)
+	}
+	if resp = do("POST", "c", ""); resp.Code != http.StatusCreated || calls != 6 {
+		t.Fatalf("Failed request should be retried: %d with %d calls", resp.Code, calls)
+	}
+
+	// Duplicate in-flight requests get conflicts.
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- do("POST", "d", "slow")
+	}()
+	<-started
+	if resp = do("POST", "d", "slow"); resp.Code != http.StatusConflict {
+		t.Fatalf("Duplicate in-flight request should get 409: %d %s", resp.Code, resp.Body.String())
+	}
+	close(release)
+	first := <-done
+	if resp = do("POST", "d", "slow"); resp.Code != http.StatusCreated || resp.Body.String() != first.Body.String() {
+		t.Fatalf("Replayed response is %d %s, but want %s", resp.Code, resp.Body.String(), first.Body.String())
+	}
+
+	// Keys are scoped by credentials.
+	alice := map[string]string{"Authorization": "Bearer alice"}
+	first = send("POST", "e", "", "", alice)
+	if resp = send("POST", "e", "", "", map[string]string{"Authorization": "Bearer bob"}); resp.Body.String() == first.Body.String() {
+		t.Fatalf("Clients should not share responses of the same key: %s", resp.Body.String())
+	}
+	if resp = send("POST", "e", "", "", alice); resp.Body.String() != first.Body.String() {
+		t.Fatalf("Replayed response is %s, but want %s", resp.Body.String(), first.Body.String())
+	}
+
+	// Reusing a key with another body is rejected.
+	first = send("POST", "f", "", "amount=1", nil)
+	if resp = send("POST", "f", "", "amount=1", nil); resp.Body.String() != first.Body.String() {
+		t.Fatalf("Replayed response is %s, but want %s", resp.Body.String(), first.Body.String())
+	}
+	if resp = send("POST", "f", "", "amount=2", nil); resp.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Request with another body should get 422: %d %s", resp.Code, resp.Body.String())
+	}
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/caicloud/nirvana/definition"
+	"github.com/caicloud/nirvana/errors"
+	"github.com/caicloud/nirvana/service"
+)
+
+var tooManyRequests = errors.TooManyRequests.Build("Nirvana:RateLimit:TooManyRequests", "too many requests, retry after ${seconds} seconds")
+
+// Limit is the number of requests which a key can make in a period.
+type Limit struct {
+	// Requests is the max number of requests in a period. It's also the size
+	// of burst.
+	Requests int
+	// Period is the length of period.
+	Period time.Duration
+}
+
+// Store stores states of rate limiting. It must be safe for concurrent use.
+type Store interface {
+	// Take takes a token of key. If the key runs out of tokens, it returns false
+	// and the duration to wait before next token.
+	Take(ctx context.Context, key string, limit Limit) (ok bool, retryAfter time.Duration, err error)
+}
+
+// KeyFunc returns the key of a request. Requests with the same key share a limit.
+type KeyFunc func(ctx context.Context) (string, error)
+
+// ByClientIP returns the IP of client from the remote address of request.
+func ByClientIP() KeyFunc {
+	return func(ctx context.Context) (string, error) {
+		addr := service.HTTPContextFrom(ctx).Request().RemoteAddr
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return addr, nil
+		}
+		return host, nil
+	}
+}
+
+// ByHeader returns the value of a request header, such as an API key. Requests
+// without the header are limited by client IP, so they don't share a key.
+func ByHeader(name string) KeyFunc {
+	byClientIP := ByClientIP()
+	return func(ctx context.Context) (string, error) {
+		value := service.HTTPContextFrom(ctx).Request().Header.Get(name)
+		if value == "" {
+			return byClientIP(ctx)
+		}
+		return value, nil
+	}
+}
+
+// ByContextValue returns the value of key in context. It's used to limit requests
+// by values which are set by previous middlewares, such as authenticated users.
+// Requests without the value are limited by client IP.
+func ByContextValue(key interface{}) KeyFunc {
+	byClientIP := ByClientIP()
+	return func(ctx context.Context) (string, error) {
+		value := ctx.Value(key)
+		if value == nil {
+			return byClientIP(ctx)
+		}
+		return fmt.Sprint(value), nil
+	}
+}
+
+// Config contains options of rate limiting.
+type Config struct {
+	// Limit is the limit of every key.
+	Limit Limit
+	// Key returns the key of request. Defaults to ByClientIP().
+	Key KeyFunc
+	// Store stores states of keys. Defaults to a MemoryStore.
+	Store Store
+}
+
+// New returns a middleware which limits requests by config. Requests over the
+// limit get an error with 429 and header "Retry-After". Install it to different
+// descriptors to apply different limits to different paths.
+func New(config Config) definition.Middleware {
+	if config.Limit.Requests <= 0 || config.Limit.Period <= 0 {
+		panic(fmt.Sprintf("invalid rate limit: %+v", config.Limit))
+	}
+	if config.Key == nil {
+		config.Key = ByClientIP()
+	}
+	if config.Store == nil {
+		config.Store = NewMemoryStore()
+	}
+	return func(ctx context.Context, chain definition.Chain) error {
+		key, err := config.Key(ctx)
+		if err != nil {
+			return err
+		}
+		ok, retryAfter, err := config.Store.Take(ctx, key, config.Limit)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			seconds := strconv.Itoa(int(math.Ceil(retryAfter.Seconds())))
+			service.HTTPContextFrom(ctx).ResponseWriter().Header().Set("Retry-After", seconds)
+			return tooManyRequests.Error(seconds)
+		}
+		return chain.Continue(ctx)
+	}
+}
+
+// MemoryStore is an in-memory token bucket store.
+type MemoryStore struct {
+	lock    sync.Mutex
+	buckets map[string]*bucket
+	takes   int
+	now     func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// sweepInterval is the number of takes between two sweeps of full buckets.
+const sweepInterval = 1024
+
+// NewMemoryStore creates an in-memory store. States are lost when the process
+// exits, and are not shared by multiple instances.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: map[string]*bucket{},
+		now:     time.Now,
+	}
+}
+
+// Take takes a token of key.
+func (s *MemoryStore) Take(ctx context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := s.now()
+	capacity := float64(limit.Requests)
+	interval := limit.Period / time.Duration(limit.Requests)
+
+	s.takes++
+	if s.takes >= sweepInterval {
+		s.takes = 0
+		// Full buckets are the same as absent ones.
+		for k, b := range s.buckets {
+			if b.refill(now, interval, capacity) >= capacity {
+				delete(s.buckets, k)
+			}
+		}
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, last: now}
+		s.buckets[key] = b
+	}
+	if b.refill(now, interval, capacity) < 1 {
+		return false, time.Duration((1 - b.tokens) * float64(interval)), nil
+	}
+	b.tokens--
+	return true, 0, nil
+}
+
+// refill adds tokens generated since last refill and returns current tokens.
+func (b *bucket) refill(now time.Time, interval time.Duration, capacity float64) float64 {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(capacity, b.tokens+float64(elapsed)/float64(interval))
+		b.last = now
+	}
+	return b.tokens
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/caicloud/nirvana/definition"
+	"github.com/caicloud/nirvana/errors"
+	"github.com/caicloud/nirvana/service"
+	"github.com/caicloud/nirvana/service/rest"
+)
+
+func TestMemoryStore(t *testing.T) {
+	now := time.Unix(0, 0)
+	s := NewMemoryStore()
+	s.now = func() time.Time { return now }
+	limit := Limit{Requests: 2, Period: time.Second}
+	for i := 0; i < 2; i++ {
+		if ok, _, _ := s.Take(context.Background(), "a", limit); !ok {
+			t.Fatalf("Request %d should be allowed", i)
+		}
+	}
+	ok, retryAfter, _ := s.Take(context.Background(), "a", limit)
+	if ok || retryAfter != 500*time.Millisecond {
+		t.Fatalf("Request should be limited for 500ms, but got: %v %v", ok, retryAfter)
+	}
+	if ok, _, _ := s.Take(context.Background(), "b", limit); !ok {
+		t.Fatal("Keys should not share limits")
+	}
+	now = now.Add(500 * time.Millisecond)
+	if ok, _, _ := s.Take(context.Background(), "a", limit); !ok {
+		t.Fatal("Request should be allowed after a token is refilled")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	builder := rest.NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	handler := func(ctx context.Context) (string, error) {
+		return "ok", nil
+	}
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/api",
+		Consumes: []string{definition.MIMENone},
+		Produces: []string{definition.MIMEText},
+		Children: []definition.Descriptor{
+			{
+				Path:        "/strict",
+				Middlewares: []definition.Middleware{New(Config{Limit: Limit{Requests: 1, Period: time.Minute}, Key: ByHeader("X-API-Key")})},
+				Definitions: []definition.Definition{
+					{Method: definition.Get, Function: handler, Results: definition.DataErrorResults("")},
+				},
+			},
+			{
+				Path:        "/loose",
+				Middlewares: []definition.Middleware{New(Config{Limit: Limit{Requests: 3, Period: time.Minute}})},
+				Definitions: []definition.Definition{
+					{Method: definition.Get, Function: handler, Results: definition.DataErrorResults("")},
+				},
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(path, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		resp := httptest.NewRecorder()
+		s.ServeHTTP(resp, req)
+		return resp
+	}
+	if resp := serve("/api/strict", "a"); resp.Code != http.StatusOK {
+		t.Fatalf("Response code should be 200, but got: %d", resp.Code)
+	}
+	resp := serve("/api/strict", "a")
+	if resp.Code != http.StatusTooManyRequests || resp.Header().Get("Retry-After") != "60" {
+		t.Fatalf("Response should be 429 with Retry-After 60, but got: %d %v", resp.Code, resp.Header())
+	}
+	if resp := serve("/api/strict", "b"); resp.Code != http.StatusOK {
+		t.Fatalf("Another key should not be limited, but got: %d", resp.Code)
+	}
+	if resp := serve("/api/strict", ""); resp.Code != http.StatusOK {
+		t.Fatalf("Requests without key should be limited by client IP, but got: %d", resp.Code)
+	}
+	if resp := serve("/api/strict", ""); resp.Code != http.StatusTooManyRequests {
+		t.Fatalf("Requests without key from the same IP should be limited, but got: %d", resp.Code)
+	}
+	for i := 0; i < 3; i++ {
+		if resp := serve("/api/loose", "a"); resp.Code != http.StatusOK {
+			t.Fatalf("Request %d of loose path should be allowed, but got: %d", i, resp.Code)
+		}
+	}
+	if resp := serve("/api/loose", "b"); resp.Code != http.StatusTooManyRequests {
+		t.Fatalf("Requests from the same IP should be limited, but got: %d", resp.Code)
+	}
+}
+
+func TestRateLimitRejectedRequests(t *testing.T) {
+	if err := service.RegisterSecurityScheme(definition.BearerScheme("test-ratelimit-bearer", ""),
+		func(ctx context.Context, token string, scopes []string) error {
+			return errors.Unauthorized.Error("invalid token")
+		}); err != nil {
+		t.Fatal(err)
+	}
+	builder := rest.NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:        "/login",
+		Consumes:    []string{definition.MIMENone},
+		Produces:    []string{definition.MIMEText},
+		Middlewares: []definition.Middleware{New(Config{Limit: Limit{Requests: 2, Period: time.Minute}})},
+		Definitions: []definition.Definition{
+			{
+				Method:   definition.Get,
+				Function: func(ctx context.Context) (string, error) { return "ok", nil },
+				Results:  definition.DataErrorResults(""),
+				Security: []definition.SecurityRequirement{definition.SecurityFor("test-ratelimit-bearer")},
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Requests rejected by authentication are counted, so guessing credentials
+	// is limited.
+	for i, code := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests} {
+		req := httptest.NewRequest(http.MethodGet, "/login", nil)
+		req.Header.Set("Authorization", "Bearer guess")
+		resp := httptest.NewRecorder()
+		s.ServeHTTP(resp, req)
+		if resp.Code != code {
+			t.Fatalf("Response code of request %d should be %d, but got: %d", i, code, resp.Code)
+		}
+	}
+}
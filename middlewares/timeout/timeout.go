@@ -0,0 +1,89 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package timeout
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caicloud/nirvana/definition"
+	"github.com/caicloud/nirvana/service"
+)
+
+// DefaultHeader is the default name of request header which carries timeouts.
+const DefaultHeader = "Request-Timeout"
+
+// Config contains options of client timeouts.
+type Config struct {
+	// Header is the name of request header. Defaults to DefaultHeader.
+	Header string
+	// Default is the timeout of requests without the header or with a malformed
+	// value. Zero means no deadline.
+	Default time.Duration
+	// Max bounds the timeouts of clients. Larger timeouts are clamped to Max.
+	// Zero means unbounded.
+	Max time.Duration
+}
+
+// New returns a middleware which derives context deadlines from a request header,
+// so handlers can stop work which clients have abandoned. The header contains a
+// duration like "5s" and "1m30s", or a number of seconds like "5". Malformed and
+// non-positive values are ignored. Handlers observe the deadline by the context,
+// and definitions with Timeout have their own deadlines too: the earlier one wins.
+func New(config Config) definition.Middleware {
+	if config.Header == "" {
+		config.Header = DefaultHeader
+	}
+	return func(ctx context.Context, chain definition.Chain) error {
+		value := service.HTTPContextFrom(ctx).Request().Header.Get(config.Header)
+		timeout, ok := Parse(value)
+		if !ok {
+			timeout = config.Default
+		}
+		if config.Max > 0 && timeout > config.Max {
+			timeout = config.Max
+		}
+		if timeout <= 0 {
+			return chain.Continue(ctx)
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return chain.Continue(ctx)
+	}
+}
+
+// Parse parses a timeout. It returns false if value is malformed or not positive.
+func Parse(value string) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, e := strconv.ParseFloat(value, 64)
+		if e != nil || !(seconds > 0) || seconds > float64(1<<63-1)/float64(time.Second) {
+			return 0, false
+		}
+		timeout = time.Duration(seconds * float64(time.Second))
+	}
+	if timeout <= 0 {
+		return 0, false
+	}
+	return timeout, true
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package timeout
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/caicloud/nirvana/definition"
+	"github.com/caicloud/nirvana/service"
+	"github.com/caicloud/nirvana/service/rest"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"5s", 5 * time.Second, true},
+		{"1m30s", 90 * time.Second, true},
+		{" 250ms ", 250 * time.Millisecond, true},
+		{"5", 5 * time.Second, true},
+		{"0.5", 500 * time.Millisecond, true},
+		{"", 0, false},
+		{"0", 0, false},
+		{"-5s", 0, false},
+		{"five", 0, false},
+		{"NaN", 0, false},
+		{"1e300", 0, false},
+	}
+	for _, test := range tests {
+		got, ok := Parse(test.value)
+		if got != test.want || ok != test.ok {
+			t.Errorf("Value %q: got %v %v, want %v %v", test.value, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	builder := rest.NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	var deadline time.Time
+	var hasDeadline bool
+	handler := func(ctx context.Context) (string, error) {
+		deadline, hasDeadline = ctx.Deadline()
+		return "ok", nil
+	}
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:        "/api",
+		Consumes:    []string{definition.MIMENone},
+		Produces:    []string{definition.MIMEText},
+		Middlewares: []definition.Middleware{New(Config{Default: 10 * time.Second, Max: time.Minute})},
+		Definitions: []definition.Definition{
+			{Method: definition.Get, Function: handler, Results: definition.DataErrorResults("")},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"5s", 5 * time.Second},
+		{"2", 2 * time.Second},
+		// Clamped by the max.
+		{"1h", time.Minute},
+		// Malformed values use the default.
+		{"soon", 10 * time.Second},
+		{"-1s", 10 * time.Second},
+		{"", 10 * time.Second},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api", nil)
+		if test.header != "" {
+			req.Header.Set(DefaultHeader, test.header)
+		}
+		resp := httptest.NewRecorder()
+		start := time.Now()
+		s.ServeHTTP(resp, req)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("Header %q: response code should be 200, but got: %d", test.header, resp.Code)
+		}
+		if !hasDeadline {
+			t.Fatalf("Header %q: context should have a deadline", test.header)
+		}
+		if got := deadline.Sub(start); got < test.want || got > test.want+time.Second {
+			t.Errorf("Header %q: timeout should be about %v, but got: %v", test.header, test.want, got)
+		}
+	}
+}
+
+func TestTimeoutWithoutDefault(t *testing.T) {
+	var hasDeadline bool
+	chain := chainFunc(func(ctx context.Context) error {
+		_, hasDeadline = ctx.Deadline()
+		return nil
+	})
+	middleware := New(Config{Header: "X-Timeout"})
+	for _, header := range []string{"", "bad"} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Timeout", header)
+		ctx := service.NewHTTPContext(httptest.NewRecorder(), req)
+		if err := middleware(ctx, chain); err != nil {
+			t.Fatal(err)
+		}
+		if hasDeadline {
+			t.Fatalf("Header %q: context should not have a deadline", header)
+		}
+	}
+}
+
+type chainFunc func(ctx context.Context) error
+
+func (f chainFunc) Continue(ctx context.Context) error {
+	return f(ctx)
+}
@@ -22,11 +22,14 @@ import (
 	"net/http"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/caicloud/nirvana/errors"
 	"github.com/caicloud/nirvana/log"
 	"github.com/caicloud/nirvana/service"
 	builderutil "github.com/caicloud/nirvana/service/builder"
+	"github.com/caicloud/nirvana/service/rest"
+	"github.com/caicloud/nirvana/service/rpc"
 
 	// This blank import will make it in the dependencies of projects using Nirvana
 	// for API docs generation.
@@ -40,7 +43,7 @@ type Server interface {
 	// The method won't return except an error occurs.
 	Serve() error
 	// Shutdown gracefully shuts down the server without interrupting any
-	// active connections.
+	// active connections, and then stops plugins.
 	Shutdown(ctx context.Context) error
 	// Builder create a service builder for current server. Don't use this method directly except
 	// there is a special server to hold http services. After server shutdown, clean resources via
@@ -48,12 +51,24 @@ type Server interface {
 	// This method always returns same builder until cleaner is called. Then it will
 	// returns new one.
 	Builder() (builder service.Builder, cleaner func() error, err error)
+	// Register adds descriptors to the serving server. It returns an error if the
+	// server is not serving or descriptors conflict with existing routes.
+	Register(descriptors ...interface{}) error
+	// Deregister removes routes of paths and their sub paths from the serving
+	// server. In-flight requests of removed routes still complete.
+	Deregister(paths ...string) error
 }
 
 // Config describes configuration of server.
 type Config struct {
 	// apiStyle makes nirvana serve as REST or RPC style, default is REST.
 	apiStyle service.APIStyle
+	// rpcVersion configures version routing of RPC style. Nil means default.
+	rpcVersion *rpcVersion
+	// cors contains options of cross-origin resource sharing. Nil means disabled.
+	cors *service.CORSOptions
+	// autoHead indicates whether HEAD handlers are derived from GET definitions.
+	autoHead bool
 	// tls cert file
 	certFile string
 	// tls ket file
@@ -66,10 +81,35 @@ type Config struct {
 	logger log.Logger
 	// descriptors contains all APIs.
 	descriptors []interface{}
+	// trailingSlash is the mode to handle trailing slashes. Empty means
+	// no handling, which is the same as service.TrailingSlashStrict.
+	trailingSlash service.TrailingSlashMode
+	// maxHeaderBytes is the max size of request headers. Zero means
+	// service.DefaultMaxHeaderBytes, and negative values mean no limit.
+	maxHeaderBytes int
+	// maxURLLength is the max length of request uris. Zero means
+	// service.DefaultMaxURLLength, and negative values mean no limit.
+	maxURLLength int
+	// maxConnections is the max number of concurrent connections. Zero means
+	// no limit.
+	maxConnections int
+	// readTimeout, readHeaderTimeout, writeTimeout and idleTimeout are timeouts
+	// of http.Server. Zero means no timeout.
+	readTimeout       time.Duration
+	readHeaderTimeout time.Duration
+	writeTimeout      time.Duration
+	idleTimeout       time.Duration
+	// disableKeepAlives indicates whether connections are closed after every
+	// response.
+	disableKeepAlives bool
 	// filters is http filters.
 	filters []service.Filter
 	// modifiers is definition modifiers
 	modifiers service.DefinitionModifiers
+	// interceptors run around functions of all definitions.
+	interceptors []service.Interceptor
+	// plugins contains plugins in the order of configuration.
+	plugins []Plugin
 	// configSet contains all configurations of plugins.
 	configSet map[string]interface{}
 	// locked is for locking current config. If the field
@@ -102,6 +142,12 @@ func (c *Config) Logger() log.Logger {
 	return c.logger
 }
 
+// rpcVersion contains configs of RPC version routing.
+type rpcVersion struct {
+	header         string
+	defaultVersion string
+}
+
 // Configurer is used to configure server config.
 type Configurer func(c *Config) error
 
@@ -145,9 +191,13 @@ func (c *Config) forEach(f func(name string, config interface{}) error) error {
 	return nil
 }
 
-// NewDefaultConfig creates default config.
+// NewDefaultConfig creates default config. Note that requests with trailing
+// slashes are redirected permanently (301 for GET and HEAD, 308 for others),
+// rather than with 307 of RedirectTrailingSlash in older versions, so clients
+// and caches may remember the redirections. Configure TrailingSlash to change it.
 // Default config contains:
-//  Filters: RedirectTrailingSlash, FillLeadingSlash, ParseRequestForm.
+//  TrailingSlash: TrailingSlashRedirect.
+//  Filters: FillLeadingSlash, ParseRequestForm.
 //  Modifiers: FirstContextParameter,
 //             ConsumeAllIfConsumesIsEmpty, ProduceAllIfProducesIsEmpty,
 //             ConsumeNoneForHTTPGet, ConsumeNoneForHTTPDelete,
@@ -155,8 +205,8 @@ func (c *Config) forEach(f func(name string, config interface{}) error) error {
 func NewDefaultConfig() *Config {
 	return NewConfig().Configure(
 		Logger(log.DefaultLogger()),
+		TrailingSlash(service.TrailingSlashRedirect),
 		Filter(
-			service.RedirectTrailingSlash(),
 			service.FillLeadingSlash(),
 			service.ParseRequestForm(),
 		),
@@ -191,6 +241,11 @@ type server struct {
 	server  *http.Server
 	builder service.Builder
 	cleaner func() error
+	service service.Service
+	// plugins contains installed plugins in the order of dependencies.
+	plugins []Plugin
+	// started contains started plugins.
+	started []Plugin
 }
 
 // NewServer creates a nirvana server. After creation, don't modify
@@ -219,8 +274,27 @@ func (s *server) Builder() (builder service.Builder, cleaner func() error, err e
 	}
 	builder = builderutil.New(s.config.apiStyle)
 	builder.SetLogger(s.config.logger)
+	// Oversized requests are rejected before other filters.
+	builder.AddFilter(service.LimitRequestSize(s.config.maxHeaderBytes, s.config.maxURLLength))
+	if s.config.trailingSlash != "" {
+		// Trailing slashes are handled before other filters.
+		builder.AddFilter(service.TrailingSlash(s.config.trailingSlash))
+	}
 	builder.AddFilter(s.config.filters...)
 	builder.SetModifier(s.config.modifiers.Combine())
+	if vb, ok := builder.(rpc.VersionedBuilder); ok && s.config.rpcVersion != nil {
+		vb.SetVersionHeader(s.config.rpcVersion.header)
+		vb.SetDefaultVersion(s.config.rpcVersion.defaultVersion)
+	}
+	if cb, ok := builder.(service.CORSBuilder); ok && s.config.cors != nil {
+		cb.SetCORS(s.config.cors)
+	}
+	if hb, ok := builder.(rest.AutoHeadBuilder); ok && s.config.autoHead {
+		hb.SetAutoHead(true)
+	}
+	if ib, ok := builder.(service.InterceptorBuilder); ok && len(s.config.interceptors) > 0 {
+		ib.AddInterceptor(s.config.interceptors...)
+	}
 	if err := builder.AddDescriptor(s.config.descriptors...); err != nil {
 		return nil, nil, err
 	}
@@ -233,6 +307,16 @@ func (s *server) Builder() (builder service.Builder, cleaner func() error, err e
 	}); err != nil {
 		return nil, nil, err
 	}
+	plugins, err := sortPlugins(s.config.plugins)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, p := range plugins {
+		if err := p.Install(builder, s.config); err != nil {
+			return nil, nil, pluginFailed.Error(p.Name(), "install", err)
+		}
+	}
+	s.plugins = plugins
 	s.builder = builder
 	s.cleaner = func() (err error) {
 		// Clean builder and plugins.
@@ -286,10 +370,26 @@ func (s *server) Serve() (e error) {
 		return err
 	}
 
-	s.server = &http.Server{
-		Addr:    fmt.Sprintf("%s:%d", s.config.ip, s.config.port),
-		Handler: service,
+	s.lock.Lock()
+	s.service = service
+	s.lock.Unlock()
+
+	if err := s.startPlugins(context.Background()); err != nil {
+		return err
 	}
+	defer func() {
+		if e == http.ErrServerClosed {
+			// Plugins are stopped by Shutdown after active connections end.
+			return
+		}
+		if err := s.stopPlugins(context.Background()); err != nil {
+			s.config.logger.Error(err)
+			if e == nil {
+				e = err
+			}
+		}
+	}()
+	s.server = s.config.httpServer(service)
 
 	if len(s.config.certFile) != 0 && len(s.config.keyFile) != 0 {
 		return s.server.ListenAndServeTLS(s.config.certFile, s.config.keyFile)
@@ -297,13 +397,72 @@ func (s *server) Serve() (e error) {
 	return s.server.ListenAndServe()
 }
 
+// httpServer creates an http server for handler by config.
+func (c *Config) httpServer(handler http.Handler) *http.Server {
+	server := &http.Server{
+		Addr:              fmt.Sprintf("%s:%d", c.ip, c.port),
+		Handler:           handler,
+		ReadTimeout:       c.readTimeout,
+		ReadHeaderTimeout: c.readHeaderTimeout,
+		WriteTimeout:      c.writeTimeout,
+		IdleTimeout:       c.idleTimeout,
+	}
+	if c.maxHeaderBytes > 0 {
+		server.MaxHeaderBytes = c.maxHeaderBytes
+	}
+	if c.disableKeepAlives {
+		server.SetKeepAlivesEnabled(false)
+	}
+	if c.maxConnections > 0 {
+		limitConnections(server, c.maxConnections)
+	}
+	return server
+}
+
+var notServing = errors.InternalServerError.Build("Nirvana:NotServing", "server is not serving or its service can't change routes")
+
+// dynamicService returns the service of the serving server.
+func (s *server) dynamicService() (service.DynamicService, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ds, ok := s.service.(service.DynamicService)
+	if !ok {
+		return nil, notServing.Error()
+	}
+	return ds, nil
+}
+
+// Register adds descriptors to the serving server. It returns an error if the
+// server is not serving or descriptors conflict with existing routes.
+func (s *server) Register(descriptors ...interface{}) error {
+	ds, err := s.dynamicService()
+	if err != nil {
+		return err
+	}
+	return ds.Register(descriptors...)
+}
+
+// Deregister removes routes of paths and their sub paths from the serving
+// server. In-flight requests of removed routes still complete.
+func (s *server) Deregister(paths ...string) error {
+	ds, err := s.dynamicService()
+	if err != nil {
+		return err
+	}
+	return ds.Deregister(paths...)
+}
+
 // Shutdown gracefully shuts down the server without interrupting any
-// active connections.
+// active connections, and then stops plugins.
 func (s *server) Shutdown(ctx context.Context) error {
+	var err error
 	if s.server != nil {
-		return s.server.Shutdown(ctx)
+		err = s.server.Shutdown(ctx)
 	}
-	return nil
+	if e := s.stopPlugins(ctx); e != nil && err == nil {
+		err = e
+	}
+	return err
 }
 
 // ConfigInstaller is used to install config to service builder.
@@ -339,6 +498,38 @@ func APIStyle(apiStyle service.APIStyle) Configurer {
 	}
 }
 
+// RPCVersion returns a configurer to set version routing of RPC style. The version
+// of a request is selected from the "Version" query, then the header. If both are
+// absent, defaultVersion is used. The header defaults to rpc.DefaultVersionHeader.
+func RPCVersion(header, defaultVersion string) Configurer {
+	return func(c *Config) error {
+		c.rpcVersion = &rpcVersion{
+			header:         header,
+			defaultVersion: defaultVersion,
+		}
+		return nil
+	}
+}
+
+// CORS returns a configurer to enable cross-origin resource sharing. Preflight
+// requests for registered paths are handled by the service, and headers for
+// cross-origin requests are set on actual responses. See service.CORSOptions.
+func CORS(options service.CORSOptions) Configurer {
+	return func(c *Config) error {
+		c.cors = &options
+		return nil
+	}
+}
+
+// AutoHead returns a configurer to derive HEAD handlers from GET definitions for
+// all paths of REST style. See definition.Descriptor.AutoHead.
+func AutoHead() Configurer {
+	return func(c *Config) error {
+		c.autoHead = true
+		return nil
+	}
+}
+
 // IP returns a configurer to set ip into config.
 func IP(ip string) Configurer {
 	return func(c *Config) error {
@@ -392,6 +583,119 @@ func Filter(filters ...service.Filter) Configurer {
 	}
 }
 
+// Interceptor returns a configurer to add interceptors into config. Interceptors
+// run around functions of all definitions. See service.Interceptor.
+func Interceptor(interceptors ...service.Interceptor) Configurer {
+	return func(c *Config) error {
+		c.interceptors = append(c.interceptors, interceptors...)
+		return nil
+	}
+}
+
+var invalidTrailingSlashMode = errors.InternalServerError.Build("Nirvana:InvalidTrailingSlashMode", "unknown trailing slash mode ${mode}")
+
+// TrailingSlash returns a configurer to set the mode to handle trailing slashes
+// of request paths. The filter of mode runs before all other filters.
+func TrailingSlash(mode service.TrailingSlashMode) Configurer {
+	return func(c *Config) error {
+		switch mode {
+		case service.TrailingSlashStrict, service.TrailingSlashRedirect, service.TrailingSlashLenient:
+		default:
+			return invalidTrailingSlashMode.Error(mode)
+		}
+		c.trailingSlash = mode
+		return nil
+	}
+}
+
+// MaxBodySize returns a configurer to set the default max body size of definitions.
+// It only affects definitions whose MaxBodySize is zero.
+func MaxBodySize(size int64) Configurer {
+	return Modifier(service.DefaultMaxBodySize(size))
+}
+
+// MaxHeaderBytes returns a configurer to set the max size of request headers,
+// including the request line. Requests with larger headers are rejected with
+// 431. Zero means service.DefaultMaxHeaderBytes, and negative values disable
+// the limit.
+func MaxHeaderBytes(size int) Configurer {
+	return func(c *Config) error {
+		c.maxHeaderBytes = size
+		return nil
+	}
+}
+
+// MaxURLLength returns a configurer to set the max length of request uris.
+// Requests with longer uris are rejected with 414. Zero means
+// service.DefaultMaxURLLength, and negative values disable the limit.
+func MaxURLLength(length int) Configurer {
+	return func(c *Config) error {
+		c.maxURLLength = length
+		return nil
+	}
+}
+
+// MaxConnections returns a configurer to set the max number of concurrent
+// connections, including idle keep-alive connections. Requests of connections
+// beyond the limit are rejected with 503, and the connections are closed after
+// responses. Zero or negative values disable the limit.
+func MaxConnections(limit int) Configurer {
+	return func(c *Config) error {
+		c.maxConnections = limit
+		return nil
+	}
+}
+
+// ReadTimeout returns a configurer to set the max duration to read a request,
+// including the body. It's http.Server.ReadTimeout. Zero means no timeout.
+func ReadTimeout(timeout time.Duration) Configurer {
+	return func(c *Config) error {
+		c.readTimeout = timeout
+		return nil
+	}
+}
+
+// ReadHeaderTimeout returns a configurer to set the max duration to read the
+// headers of a request. It's http.Server.ReadHeaderTimeout. Zero means the
+// read timeout is used.
+func ReadHeaderTimeout(timeout time.Duration) Configurer {
+	return func(c *Config) error {
+		c.readHeaderTimeout = timeout
+		return nil
+	}
+}
+
+// WriteTimeout returns a configurer to set the max duration from the end of
+// reading request headers to the end of writing the response. It's
+// http.Server.WriteTimeout. Zero means no timeout. Streaming responses which
+// last longer are cut off, so leave it zero for them.
+func WriteTimeout(timeout time.Duration) Configurer {
+	return func(c *Config) error {
+		c.writeTimeout = timeout
+		return nil
+	}
+}
+
+// IdleTimeout returns a configurer to set the max duration to wait for the next
+// request of a keep-alive connection. It's http.Server.IdleTimeout. Zero means
+// the read timeout is used.
+func IdleTimeout(timeout time.Duration) Configurer {
+	return func(c *Config) error {
+		c.idleTimeout = timeout
+		return nil
+	}
+}
+
+// KeepAlive returns a configurer to enable or disable keep-alive connections.
+// They're enabled by default. If they're disabled, connections are closed after
+// every response.
+func KeepAlive(enabled bool) Configurer {
+	return func(c *Config) error {
+		c.disableKeepAlives = !enabled
+		return nil
+	}
+}
+
 // Modifier returns a configurer to add definition modifiers into config.
 func Modifier(modifiers ...service.DefinitionModifier) Configurer {
 	return func(c *Config) error {
@@ -26,10 +26,28 @@ import (
 
 	"github.com/caicloud/nirvana/definition"
 	"github.com/caicloud/nirvana/errors"
+	"github.com/caicloud/nirvana/service"
 )
 
 var std = val.New()
 
+// fields validates structs and names fields by json tags.
+var fields = newFieldValidator()
+
+func newFieldValidator() *val.Validate {
+	v := val.New()
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	return v
+}
+
+var invalidField = errors.BadRequest.Build("Nirvana:Validator:InvalidField", "value '${value}' cannot pass validator tag '${tag}'")
+
 // OperatorKind means opeartor kind. All operators generated in this package
 // are has kind `validator`.
 const OperatorKind = "validator"
@@ -155,6 +173,61 @@ func Struct(instance interface{}) Validator {
 	}
 }
 
+// Body returns an operator to validate a struct body by tag "validate", such as:
+//  type User struct {
+//      Email    string    `json:"email" validate:"required,email"`
+//      Age      int       `json:"age" validate:"min=18,max=150"`
+//      Password string    `json:"password" validate:"required"`
+//      Confirm  string    `json:"confirm" validate:"eqfield=Password"`
+//      Address  Address   `json:"address"`
+//      Phones   []Phone   `json:"phones" validate:"dive"`
+//  }
+//  definition.BodyParameterFor("", validator.Body(&User{}))
+// Nested structs are validated, and elements of slices and maps are validated
+// with tag "dive". Failures are returned as service.ParameterErrors, in which
+// fields are named by json tags like "phones[0].number" and their source is
+// Body, so they are written like errors of other parameters. Nil bodies are
+// not validated, and should be checked by required parameters.
+func Body(instance interface{}) Validator {
+	return &validator{
+		in:  reflect.TypeOf(instance),
+		out: reflect.TypeOf(instance),
+		f: func(ctx context.Context, field string, object interface{}) (interface{}, error) {
+			if v := reflect.ValueOf(object); !v.IsValid() || (v.Kind() == reflect.Ptr && v.IsNil()) {
+				return object, nil
+			}
+			err := fields.StructCtx(ctx, object)
+			return object, fieldErrors(err)
+		},
+		category: CategoryStruct,
+	}
+}
+
+// fieldErrors converts validation errors to parameter errors of body fields.
+func fieldErrors(err error) error {
+	if err == nil {
+		return nil
+	}
+	ves, ok := err.(val.ValidationErrors)
+	if !ok {
+		return errors.BadRequest.Error("${err}", err)
+	}
+	result := make(service.ParameterErrors, 0, len(ves))
+	for _, fe := range ves {
+		// Namespaces start with the name of the struct type.
+		name := fe.Namespace()
+		if index := strings.IndexByte(name, '.'); index >= 0 {
+			name = name[index+1:]
+		}
+		tag := fe.Tag()
+		if fe.Param() != "" {
+			tag += "=" + fe.Param()
+		}
+		result = append(result, service.NewFieldError(name, definition.Body, invalidField.Error(fmt.Sprint(fe.Value()), tag)))
+	}
+	return result
+}
+
 // String creates validator for string type.
 func String(tag string) Validator {
 	return varFor(tag, "")
@@ -19,9 +19,12 @@ package validator
 import (
 	"context"
 	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/caicloud/nirvana/definition"
 	"github.com/caicloud/nirvana/errors"
+	"github.com/caicloud/nirvana/service"
 )
 
 func TestVar(t *testing.T) {
@@ -106,3 +109,80 @@ func TestNewCustom(t *testing.T) {
 		t.Fatalf("%+v", validator)
 	}
 }
+
+type testAddress struct {
+	City string `json:"city" validate:"required"`
+}
+
+type testPhone struct {
+	Number string `json:"number" validate:"required,min=5"`
+}
+
+type testUser struct {
+	Name     string      `json:"name" validate:"required"`
+	Email    string      `json:"email" validate:"required,email"`
+	Age      int         `json:"age" validate:"min=18,max=150"`
+	Password string      `json:"password" validate:"required"`
+	Confirm  string      `json:"confirm" validate:"eqfield=Password"`
+	Address  testAddress `json:"address"`
+	Phones   []testPhone `json:"phones" validate:"dive"`
+}
+
+func TestBody(t *testing.T) {
+	op := Body(&testUser{})
+	if op.(Validator).Category() != CategoryStruct {
+		t.Fatalf("Unexpected category: %s", op.(Validator).Category())
+	}
+	valid := &testUser{
+		Name:     "nirvana",
+		Email:    "nirvana@caicloud.io",
+		Age:      20,
+		Password: "secret",
+		Confirm:  "secret",
+		Address:  testAddress{City: "Hangzhou"},
+		Phones:   []testPhone{{Number: "12345"}},
+	}
+	if _, err := op.Operate(context.Background(), "", valid); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := op.Operate(context.Background(), "", (*testUser)(nil)); err != nil {
+		t.Fatalf("Nil body should not be validated, but got: %v", err)
+	}
+
+	invalid := &testUser{
+		Email:    "nirvana",
+		Age:      200,
+		Password: "secret",
+		Confirm:  "public",
+		Phones:   []testPhone{{Number: "12345"}, {Number: "123"}},
+	}
+	_, err := op.Operate(context.Background(), "", invalid)
+	pe, ok := err.(service.ParameterErrors)
+	if !ok {
+		t.Fatalf("Errors should be parameter errors, but got: %v", err)
+	}
+	want := map[string]string{
+		"name":             "required",
+		"email":            "email",
+		"age":              "max=150",
+		"confirm":          "eqfield=Password",
+		"address.city":     "required",
+		"phones[1].number": "min=5",
+	}
+	if len(pe) != len(want) {
+		t.Fatalf("There should be %d errors, but got: %v", len(want), pe)
+	}
+	for _, e := range pe {
+		if e.Source != definition.Body {
+			t.Errorf("Source of field %s should be body, but got: %s", e.Field, e.Source)
+		}
+		tag, ok := want[e.Field]
+		if !ok {
+			t.Errorf("Unexpected field: %s", e.Field)
+			continue
+		}
+		if e.Reason != "Nirvana:Validator:InvalidField" || !strings.Contains(e.Message, "validator tag '"+tag+"'") {
+			t.Errorf("Field %s should fail tag %s, but got: %+v", e.Field, tag, e)
+		}
+	}
+}
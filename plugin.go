@@ -0,0 +1,185 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nirvana
+
+import (
+	"context"
+	"strings"
+
+	"github.com/caicloud/nirvana/errors"
+	"github.com/caicloud/nirvana/service"
+)
+
+// Plugin is an optional feature of server, such as metrics and tracing.
+// The lifecycle of a plugin is:
+//  Install: when the service builder is created, before the service is built.
+//  Start:   after the service is built, before the server listens.
+//  Stop:    when the server is shut down or fails to serve.
+// Plugins are installed and started in the order of dependencies, and stopped in
+// the reverse order. Plugins without dependencies between them keep the order
+// in which they are configured. Embed NopPlugin to implement only some hooks.
+type Plugin interface {
+	// Name is the unique name of the plugin.
+	Name() string
+	// Dependencies returns names of plugins which must be installed and started
+	// before the plugin.
+	Dependencies() []string
+	// Install installs stuffs to builder, such as descriptors and filters.
+	Install(builder service.Builder, config *Config) error
+	// Start starts the plugin. It must not block, so long running tasks should
+	// run in goroutines which end in Stop.
+	Start(ctx context.Context) error
+	// Stop stops the plugin and releases its resources.
+	Stop(ctx context.Context) error
+}
+
+// NopPlugin is a plugin which does nothing. It can be embedded to implement
+// plugins with some hooks.
+type NopPlugin struct {
+	// PluginName is the name of the plugin.
+	PluginName string
+}
+
+// Name returns the name of the plugin.
+func (p *NopPlugin) Name() string {
+	return p.PluginName
+}
+
+// Dependencies returns nothing.
+func (p *NopPlugin) Dependencies() []string {
+	return nil
+}
+
+// Install does nothing.
+func (p *NopPlugin) Install(builder service.Builder, config *Config) error {
+	return nil
+}
+
+// Start does nothing.
+func (p *NopPlugin) Start(ctx context.Context) error {
+	return nil
+}
+
+// Stop does nothing.
+func (p *NopPlugin) Stop(ctx context.Context) error {
+	return nil
+}
+
+var duplicatePlugin = errors.InternalServerError.Build("Nirvana:DuplicatePlugin", "plugin ${name} has been configured")
+var unknownDependency = errors.InternalServerError.Build("Nirvana:UnknownDependency", "plugin ${name} depends on ${dependency}, which is not configured")
+var circularDependency = errors.InternalServerError.Build("Nirvana:CircularDependency", "plugins have circular dependencies: ${plugins}")
+var pluginFailed = errors.InternalServerError.Build("Nirvana:PluginFailed", "plugin ${name} failed to ${action}: ${err}")
+
+// Plugins returns a configurer to add plugins into config.
+func Plugins(plugins ...Plugin) Configurer {
+	return func(c *Config) error {
+		for _, p := range plugins {
+			for _, existing := range c.plugins {
+				if existing.Name() == p.Name() {
+					return duplicatePlugin.Error(p.Name())
+				}
+			}
+			c.plugins = append(c.plugins, p)
+		}
+		return nil
+	}
+}
+
+// sortPlugins sorts plugins by dependencies. A plugin is placed after its
+// dependencies, and as early as possible in the order of plugins.
+func sortPlugins(plugins []Plugin) ([]Plugin, error) {
+	index := make(map[string]Plugin, len(plugins))
+	for _, p := range plugins {
+		index[p.Name()] = p
+	}
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	states := make(map[string]int, len(plugins))
+	sorted := make([]Plugin, 0, len(plugins))
+	var path []string
+	var visit func(p Plugin) error
+	visit = func(p Plugin) error {
+		name := p.Name()
+		switch states[name] {
+		case visited:
+			return nil
+		case visiting:
+			for i, n := range path {
+				if n == name {
+					return circularDependency.Error(strings.Join(append(path[i:], name), " -> "))
+				}
+			}
+		}
+		states[name] = visiting
+		path = append(path, name)
+		for _, dependency := range p.Dependencies() {
+			d, ok := index[dependency]
+			if !ok {
+				return unknownDependency.Error(name, dependency)
+			}
+			if err := visit(d); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		states[name] = visited
+		sorted = append(sorted, p)
+		return nil
+	}
+	for _, p := range plugins {
+		if err := visit(p); err != nil {
+			return nil, err
+		}
+	}
+	return sorted, nil
+}
+
+// startPlugins starts plugins in order. If a plugin fails, started plugins are
+// stopped.
+func (s *server) startPlugins(ctx context.Context) error {
+	for _, p := range s.plugins {
+		if err := p.Start(ctx); err != nil {
+			if e := s.stopPlugins(ctx); e != nil {
+				s.config.logger.Error(e)
+			}
+			return pluginFailed.Error(p.Name(), "start", err)
+		}
+		s.lock.Lock()
+		s.started = append(s.started, p)
+		s.lock.Unlock()
+	}
+	return nil
+}
+
+// stopPlugins stops started plugins in the reverse order. It returns the first
+// error, and plugins after the failed one are still stopped.
+func (s *server) stopPlugins(ctx context.Context) error {
+	s.lock.Lock()
+	started := s.started
+	s.started = nil
+	s.lock.Unlock()
+	var err error
+	for i := len(started) - 1; i >= 0; i-- {
+		p := started[i]
+		if e := p.Stop(ctx); e != nil && err == nil {
+			err = pluginFailed.Error(p.Name(), "stop", e)
+		}
+	}
+	return err
+}
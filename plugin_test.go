@@ -0,0 +1,173 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nirvana
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/caicloud/nirvana/service"
+)
+
+type testPlugin struct {
+	NopPlugin
+	dependencies []string
+	events       *[]string
+	failOn       string
+}
+
+func (p *testPlugin) Dependencies() []string {
+	return p.dependencies
+}
+
+func (p *testPlugin) record(action string) error {
+	*p.events = append(*p.events, action+" "+p.Name())
+	if p.failOn == action {
+		return fmt.Errorf("%s is broken", p.Name())
+	}
+	return nil
+}
+
+func (p *testPlugin) Install(builder service.Builder, config *Config) error {
+	return p.record("install")
+}
+
+func (p *testPlugin) Start(ctx context.Context) error {
+	return p.record("start")
+}
+
+func (p *testPlugin) Stop(ctx context.Context) error {
+	return p.record("stop")
+}
+
+func newTestPlugins(events *[]string, specs ...string) []Plugin {
+	plugins := make([]Plugin, 0, len(specs))
+	for _, spec := range specs {
+		// Specs are like "name:dependency,dependency".
+		parts := strings.SplitN(spec, ":", 2)
+		p := &testPlugin{NopPlugin: NopPlugin{PluginName: parts[0]}, events: events}
+		if len(parts) > 1 {
+			p.dependencies = strings.Split(parts[1], ",")
+		}
+		plugins = append(plugins, p)
+	}
+	return plugins
+}
+
+func TestSortPlugins(t *testing.T) {
+	tests := []struct {
+		specs []string
+		want  []string
+		err   string
+	}{
+		{[]string{"a", "b", "c"}, []string{"a", "b", "c"}, ""},
+		{[]string{"a:c", "b", "c"}, []string{"c", "a", "b"}, ""},
+		{[]string{"tracing:logger,metrics", "metrics:logger", "logger", "nop"}, []string{"logger", "metrics", "tracing", "nop"}, ""},
+		{[]string{"a:b", "b:c", "c:a"}, nil, "circular dependencies: a -> b -> c -> a"},
+		{[]string{"a:a"}, nil, "circular dependencies: a -> a"},
+		{[]string{"a:missing"}, nil, "a depends on missing"},
+	}
+	for _, test := range tests {
+		sorted, err := sortPlugins(newTestPlugins(&[]string{}, test.specs...))
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("Plugins %v: error should contain %q, but got: %v", test.specs, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Plugins %v: %v", test.specs, err)
+		}
+		var names []string
+		for _, p := range sorted {
+			names = append(names, p.Name())
+		}
+		if !reflect.DeepEqual(names, test.want) {
+			t.Errorf("Plugins %v: got %v, want %v", test.specs, names, test.want)
+		}
+	}
+}
+
+func TestPluginLifecycle(t *testing.T) {
+	var events []string
+	plugins := newTestPlugins(&events, "b:a", "a", "c")
+	s := NewServer(NewConfig().Configure(Plugins(plugins...))).(*server)
+	if _, _, err := s.Builder(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.startPlugins(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"install a", "install b", "install c",
+		"start a", "start b", "start c",
+		"stop c", "stop b", "stop a",
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Fatalf("Events: got %v, want %v", events, want)
+	}
+	// Stopped plugins are not stopped again.
+	if err := s.Shutdown(context.Background()); err != nil || len(events) != len(want) {
+		t.Fatalf("Plugins should be stopped once: %v %v", err, events)
+	}
+}
+
+func TestPluginErrors(t *testing.T) {
+	if err := Plugins(&NopPlugin{PluginName: "a"})(NewConfig()); err != nil {
+		t.Fatal(err)
+	}
+	c := NewConfig()
+	if err := Plugins(&NopPlugin{PluginName: "a"}, &NopPlugin{PluginName: "a"})(c); err == nil || !duplicatePlugin.Derived(err) {
+		t.Fatalf("Duplicate plugins should be rejected, but got: %v", err)
+	}
+
+	var events []string
+	plugins := newTestPlugins(&events, "a", "b")
+	plugins[1].(*testPlugin).failOn = "install"
+	s := NewServer(NewConfig().Configure(Plugins(plugins...))).(*server)
+	if _, _, err := s.Builder(); err == nil || !pluginFailed.Derived(err) || !strings.Contains(err.Error(), "b is broken") {
+		t.Fatalf("Install error should be returned, but got: %v", err)
+	}
+
+	events = nil
+	plugins = newTestPlugins(&events, "a", "b", "c")
+	plugins[2].(*testPlugin).failOn = "start"
+	plugins[0].(*testPlugin).failOn = "stop"
+	s = NewServer(NewConfig().Configure(Plugins(plugins...))).(*server)
+	if _, _, err := s.Builder(); err != nil {
+		t.Fatal(err)
+	}
+	err := s.startPlugins(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "plugin c failed to start: c is broken") {
+		t.Fatalf("Start error should be returned, but got: %v", err)
+	}
+	// Started plugins are stopped even if one of them fails to stop.
+	want := []string{
+		"install a", "install b", "install c",
+		"start a", "start b", "start c",
+		"stop b", "stop a",
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Fatalf("Events: got %v, want %v", events, want)
+	}
+}
@@ -0,0 +1,258 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package compression
+
+import (
+	"compress/flate"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/caicloud/nirvana"
+	"github.com/caicloud/nirvana/definition"
+	"github.com/caicloud/nirvana/service"
+)
+
+func init() {
+	nirvana.RegisterConfigInstaller(&compressionInstaller{})
+}
+
+// ExternalConfigName is the external config name of compression.
+const ExternalConfigName = "compression"
+
+const (
+	// EncodingGzip is the content coding of gzip.
+	EncodingGzip = "gzip"
+	// EncodingDeflate is the content coding of deflate.
+	EncodingDeflate = "deflate"
+)
+
+// config is compression config.
+type config struct {
+	minSize       int
+	level         int
+	excludedTypes []string
+}
+
+type compressionInstaller struct{}
+
+// Name is the external config name.
+func (i *compressionInstaller) Name() string {
+	return ExternalConfigName
+}
+
+// Install installs stuffs before server starting.
+func (i *compressionInstaller) Install(builder service.Builder, cfg *nirvana.Config) error {
+	var err error
+	wrapper(cfg, func(c *config) {
+		middlewares := []definition.Middleware{middleware(c)}
+		if builder.APIStyle() == service.APIStyleRPC {
+			err = builder.AddDescriptor(definition.RPCDescriptor{Path: "/", Middlewares: middlewares})
+			return
+		}
+		err = builder.AddDescriptor(definition.Descriptor{Path: "/", Middlewares: middlewares})
+	})
+	return err
+}
+
+func middleware(c *config) definition.Middleware {
+	return func(ctx context.Context, next definition.Chain) error {
+		httpCtx := service.HTTPContextFrom(ctx)
+		if httpCtx == nil {
+			return next.Continue(ctx)
+		}
+		encoding := negotiate(httpCtx.Request().Header.Get("Accept-Encoding"))
+		if encoding == "" {
+			return next.Continue(ctx)
+		}
+		var w *compressWriter
+		if !service.WrapResponseWriter(ctx, func(resp http.ResponseWriter) http.ResponseWriter {
+			w = newCompressWriter(resp, encoding, c)
+			return w
+		}) {
+			return next.Continue(ctx)
+		}
+		err := next.Continue(ctx)
+		if e := w.Close(); e != nil && err == nil {
+			err = e
+		}
+		return err
+	}
+}
+
+// negotiate chooses a content coding by Accept-Encoding. The coding with the
+// highest q-value wins, and gzip is preferred if both gzip and deflate have the
+// same q-value. "*" applies to codings which are not listed explicitly, so
+// "gzip;q=0, *" chooses deflate. It returns an empty string if none is
+// acceptable.
+func negotiate(accept string) string {
+	explicit := map[string]float64{}
+	wildcard := -1.0
+	for _, part := range strings.Split(accept, ",") {
+		coding := strings.TrimSpace(part)
+		q := 1.0
+		if index := strings.Index(coding, ";"); index >= 0 {
+			param := strings.TrimSpace(coding[index+1:])
+			coding = strings.TrimSpace(coding[:index])
+			if strings.HasPrefix(param, "q=") {
+				v, err := strconv.ParseFloat(param[2:], 64)
+				if err != nil {
+					continue
+				}
+				q = v
+			}
+		}
+		coding = strings.ToLower(coding)
+		if coding == "*" {
+			wildcard = q
+		} else if coding != "" {
+			explicit[coding] = q
+		}
+	}
+	result, best := "", 0.0
+	for _, coding := range []string{EncodingGzip, EncodingDeflate} {
+		q, ok := explicit[coding]
+		if !ok {
+			q = wildcard
+		}
+		if q > best {
+			result, best = coding, q
+		}
+	}
+	return result
+}
+
+// Uninstall uninstalls stuffs after server terminating.
+func (i *compressionInstaller) Uninstall(builder service.Builder, cfg *nirvana.Config) error {
+	return nil
+}
+
+// Disable returns a configurer to disable compression.
+func Disable() nirvana.Configurer {
+	return func(c *nirvana.Config) error {
+		c.Set(ExternalConfigName, nil)
+		return nil
+	}
+}
+
+// Default returns a configurer to enable compression with default config.
+func Default() nirvana.Configurer {
+	return func(c *nirvana.Config) error {
+		wrapper(c, func(c *config) {})
+		return nil
+	}
+}
+
+// MinSize returns a configurer to set the minimum size of response body to
+// compress. Smaller bodies are written without compression.
+// Defaults to 1024.
+func MinSize(size int) nirvana.Configurer {
+	return func(c *nirvana.Config) error {
+		wrapper(c, func(c *config) {
+			c.minSize = size
+		})
+		return nil
+	}
+}
+
+// Level returns a configurer to set compression level. The level should be
+// in [-2, 9]. See compress/flate for details.
+// Defaults to flate.DefaultCompression.
+func Level(level int) nirvana.Configurer {
+	return func(c *nirvana.Config) error {
+		wrapper(c, func(c *config) {
+			c.level = level
+		})
+		return nil
+	}
+}
+
+// ExcludedContentTypes returns a configurer to set content types which should not be
+// compressed. A type ends with "/" matches all subtypes, such as "image/".
+// Defaults to already-compressed types: application/octet-stream, application/zip,
+// application/gzip, image/, audio/ and video/.
+func ExcludedContentTypes(types ...string) nirvana.Configurer {
+	return func(c *nirvana.Config) error {
+		wrapper(c, func(c *config) {
+			c.excludedTypes = types
+		})
+		return nil
+	}
+}
+
+func wrapper(c *nirvana.Config, f func(c *config)) {
+	conf := c.Config(ExternalConfigName)
+	var cfg *config
+	if conf == nil {
+		// Default config.
+		cfg = &config{
+			minSize: 1024,
+			level:   flate.DefaultCompression,
+			excludedTypes: []string{
+				definition.MIMEOctetStream,
+				"application/zip",
+				"application/gzip",
+				"image/",
+				"audio/",
+				"video/",
+			},
+		}
+	} else {
+		// Panic if config type is wrong.
+		cfg = conf.(*config)
+	}
+	f(cfg)
+	c.Set(ExternalConfigName, cfg)
+}
+
+// Option contains basic configurations of compression.
+type Option struct {
+	// Enable enables response compression.
+	Enable bool `desc:"Enable response compression"`
+	// MinSize is the minimum size of response body to compress.
+	MinSize int `desc:"Minimum size of response body to compress"`
+	// Level is compression level.
+	Level int `desc:"Compression level in [-2, 9]"`
+}
+
+// NewDefaultOption creates default option.
+func NewDefaultOption() *Option {
+	return &Option{
+		Enable:  false,
+		MinSize: 1024,
+		Level:   flate.DefaultCompression,
+	}
+}
+
+// Name returns plugin name.
+func (p *Option) Name() string {
+	return ExternalConfigName
+}
+
+// Configure configures nirvana config via current options.
+func (p *Option) Configure(cfg *nirvana.Config) error {
+	if !p.Enable {
+		cfg.Configure(Disable())
+		return nil
+	}
+	cfg.Configure(
+		MinSize(p.MinSize),
+		Level(p.Level),
+	)
+	return nil
+}
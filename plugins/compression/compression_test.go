@@ -0,0 +1,174 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package compression
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/caicloud/nirvana"
+	"github.com/caicloud/nirvana/definition"
+	"github.com/caicloud/nirvana/service"
+	"github.com/caicloud/nirvana/service/rest"
+	"github.com/caicloud/nirvana/service/rpc"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := map[string]string{
+		"":                        "",
+		"gzip":                    EncodingGzip,
+		"deflate, gzip":           EncodingGzip,
+		"deflate":                 EncodingDeflate,
+		"gzip;q=0, deflate;q=0.5": EncodingDeflate,
+		"br":                      "",
+		"*":                       EncodingGzip,
+		"br;q=1, gzip;q=0.5":      EncodingGzip,
+		"gzip;q=0, *":             EncodingDeflate,
+		"gzip;q=0.5, deflate":     EncodingDeflate,
+		"*;q=0, deflate;q=0.1":    EncodingDeflate,
+		"*;q=0":                   "",
+	}
+	for accept, want := range tests {
+		if got := negotiate(accept); got != want {
+			t.Fatalf("Encoding for %q should be %q, but got: %q", accept, want, got)
+		}
+	}
+}
+
+func TestCompression(t *testing.T) {
+	cfg := nirvana.NewConfig().Configure(MinSize(16))
+	builder := rest.NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := (&compressionInstaller{}).Install(builder, cfg); err != nil {
+		t.Fatal(err)
+	}
+	long := strings.Repeat("nirvana", 10)
+	newDefinition := func(produces string, data string) definition.Definition {
+		return definition.Definition{
+			Method:   definition.Get,
+			Consumes: []string{definition.MIMENone},
+			Produces: []string{produces},
+			Function: func(ctx context.Context) (string, error) {
+				return data, nil
+			},
+			Results: definition.DataErrorResults(""),
+		}
+	}
+	if err := builder.AddDescriptor(
+		definition.Descriptor{Path: "/long", Definitions: []definition.Definition{newDefinition(definition.MIMEText, long)}},
+		definition.Descriptor{Path: "/short", Definitions: []definition.Definition{newDefinition(definition.MIMEText, "short")}},
+		definition.Descriptor{Path: "/binary", Definitions: []definition.Definition{newDefinition(definition.MIMEOctetStream, long)}},
+	); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path     string
+		accept   string
+		encoding string
+		body     string
+	}{
+		{"/long", "gzip", EncodingGzip, long},
+		{"/long", "deflate", EncodingDeflate, long},
+		{"/long", "", "", long},
+		{"/short", "gzip", "", "short"},
+		{"/binary", "gzip", "", long},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", test.path, nil)
+		req.Header.Set("Accept-Encoding", test.accept)
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("Status code of %s should be 200, but got: %d", test.path, recorder.Code)
+		}
+		encoding := recorder.Header().Get("Content-Encoding")
+		if encoding != test.encoding {
+			t.Fatalf("Content encoding of %s should be %q, but got: %q", test.path, test.encoding, encoding)
+		}
+		var reader io.Reader = recorder.Body
+		switch encoding {
+		case EncodingGzip:
+			reader, err = gzip.NewReader(reader)
+		case EncodingDeflate:
+			reader, err = zlib.NewReader(reader)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != test.body {
+			t.Fatalf("Body of %s is not desired: %s", test.path, data)
+		}
+	}
+}
+
+func TestRPCCompression(t *testing.T) {
+	builder := rpc.NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := (&compressionInstaller{}).Install(builder, nirvana.NewConfig().Configure(MinSize(16))); err != nil {
+		t.Fatal(err)
+	}
+	long := strings.Repeat("nirvana", 10)
+	if err := builder.AddDescriptor(definition.RPCDescriptor{
+		Path:     "/",
+		Consumes: []string{definition.MIMEAll},
+		Produces: []string{definition.MIMEText},
+		Actions: []definition.RPCAction{
+			{
+				Name: "Get",
+				Function: func(ctx context.Context) (string, error) {
+					return long, nil
+				},
+				Results: definition.DataErrorResults(""),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/?Action=Get", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp := httptest.NewRecorder()
+	s.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK || resp.Header().Get("Content-Encoding") != EncodingGzip {
+		t.Fatalf("Response should be compressed: %d %v", resp.Code, resp.Header())
+	}
+	reader, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadAll(reader); err != nil || string(data) != long {
+		t.Fatalf("Unexpected body: %q %v", data, err)
+	}
+}
@@ -0,0 +1,174 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package compression
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// compressWriter buffers response body until it reaches the minimum size. Then it
+// decides whether to compress the body by response headers. The status code is
+// held until the decision is made, so that Content-Encoding can be set.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	config   *config
+	code     int
+	buf      []byte
+	// decided is true if the headers have been written.
+	decided bool
+	// writer is not nil if the body is compressed.
+	writer io.WriteCloser
+}
+
+func newCompressWriter(w http.ResponseWriter, encoding string, c *config) *compressWriter {
+	return &compressWriter{
+		ResponseWriter: w,
+		encoding:       encoding,
+		config:         c,
+	}
+}
+
+// WriteHeader holds status code until the body is decided to be compressed or not.
+func (w *compressWriter) WriteHeader(code int) {
+	if w.decided {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.code = code
+}
+
+// Write buffers data or writes it to the compressor.
+func (w *compressWriter) Write(data []byte) (int, error) {
+	if w.decided {
+		if w.writer != nil {
+			return w.writer.Write(data)
+		}
+		return w.ResponseWriter.Write(data)
+	}
+	w.buf = append(w.buf, data...)
+	if len(w.buf) >= w.config.minSize {
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+// Flush writes buffered data to client. If the body is not compressed yet,
+// it won't be compressed, because a flushed response is likely a stream.
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		if err := w.decide(false); err != nil {
+			return
+		}
+	}
+	if f, ok := w.writer.(interface{ Flush() error }); ok {
+		if err := f.Flush(); err != nil {
+			return
+		}
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack hijacks the underlying connection. The response won't be compressed.
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("underlying response writer is not a http.Hijacker")
+	}
+	w.decided = true
+	return h.Hijack()
+}
+
+// Close writes all buffered data and closes the compressor.
+func (w *compressWriter) Close() error {
+	if !w.decided {
+		if err := w.decide(false); err != nil {
+			return err
+		}
+	}
+	if w.writer != nil {
+		return w.writer.Close()
+	}
+	return nil
+}
+
+func (w *compressWriter) decide(compress bool) error {
+	w.decided = true
+	if compress && w.compressible() {
+		header := w.Header()
+		header.Set("Content-Encoding", w.encoding)
+		header.Del("Content-Length")
+		header.Add("Vary", "Accept-Encoding")
+		var err error
+		switch w.encoding {
+		case EncodingGzip:
+			w.writer, err = gzip.NewWriterLevel(w.ResponseWriter, w.config.level)
+		case EncodingDeflate:
+			w.writer, err = zlib.NewWriterLevel(w.ResponseWriter, w.config.level)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if w.code > 0 {
+		w.ResponseWriter.WriteHeader(w.code)
+	}
+	buf := w.buf
+	w.buf = nil
+	if len(buf) <= 0 {
+		return nil
+	}
+	if w.writer != nil {
+		_, err := w.writer.Write(buf)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+func (w *compressWriter) compressible() bool {
+	if w.code == http.StatusNoContent || w.code == http.StatusNotModified {
+		return false
+	}
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+	ct, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		// Unknown content type is not compressed.
+		return false
+	}
+	for _, excluded := range w.config.excludedTypes {
+		if ct == excluded || (strings.HasSuffix(excluded, "/") && strings.HasPrefix(ct, excluded)) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,279 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package healthcheck
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caicloud/nirvana"
+	"github.com/caicloud/nirvana/definition"
+	"github.com/caicloud/nirvana/service"
+)
+
+// DefaultCheckTimeout is the timeout of checks which have no timeout.
+const DefaultCheckTimeout = 5 * time.Second
+
+// Check is a named health check.
+type Check struct {
+	// Name is the name of the check in reports.
+	Name string
+	// Checker returns an error if the check fails.
+	Checker HealthChecker
+	// Timeout limits the duration of Checker. Zero means DefaultCheckTimeout.
+	// A check fails if it doesn't return in time, even if it ignores the
+	// cancellation of its context.
+	Timeout time.Duration
+}
+
+// CheckResult is the result of a check.
+type CheckResult struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Report is the result of a set of checks.
+type Report struct {
+	// Healthy is true if all checks pass.
+	Healthy bool          `json:"healthy"`
+	Checks  []CheckResult `json:"checks"`
+}
+
+// Prober runs liveness checks and readiness checks. They are registered
+// separately, so add a check to both if it affects liveness and readiness.
+type Prober struct {
+	lock      sync.RWMutex
+	liveness  []Check
+	readiness []Check
+}
+
+// NewProber creates a prober without checks.
+func NewProber() *Prober {
+	return &Prober{}
+}
+
+// AddLivenessChecks adds checks to liveness checks.
+func (p *Prober) AddLivenessChecks(checks ...Check) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.liveness = append(p.liveness, checks...)
+}
+
+// AddReadinessChecks adds checks to readiness checks.
+func (p *Prober) AddReadinessChecks(checks ...Check) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.readiness = append(p.readiness, checks...)
+}
+
+// Liveness runs liveness checks.
+func (p *Prober) Liveness(ctx context.Context) *Report {
+	p.lock.RLock()
+	checks := p.liveness
+	p.lock.RUnlock()
+	return runChecks(ctx, checks)
+}
+
+// Readiness runs readiness checks.
+func (p *Prober) Readiness(ctx context.Context) *Report {
+	p.lock.RLock()
+	checks := p.readiness
+	p.lock.RUnlock()
+	return runChecks(ctx, checks)
+}
+
+// runChecks runs checks concurrently. Results keep the order of checks.
+func runChecks(ctx context.Context, checks []Check) *Report {
+	report := &Report{Healthy: true, Checks: make([]CheckResult, len(checks))}
+	wg := sync.WaitGroup{}
+	wg.Add(len(checks))
+	for i := range checks {
+		go func(i int) {
+			defer wg.Done()
+			report.Checks[i] = runCheck(ctx, &checks[i])
+		}(i)
+	}
+	wg.Wait()
+	for _, result := range report.Checks {
+		if !result.Healthy {
+			report.Healthy = false
+		}
+	}
+	return report
+}
+
+func runCheck(ctx context.Context, check *Check) CheckResult {
+	timeout := check.Timeout
+	if timeout <= 0 {
+		timeout = DefaultCheckTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	// The channel is buffered, so the goroutine of a timed out check ends
+	// when the check returns.
+	done := make(chan error, 1)
+	go func() {
+		done <- check.Checker(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("check timed out after %v", timeout)
+	}
+	result := CheckResult{Name: check.Name, Healthy: err == nil}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// unhealthy is the error of a report which has failed checks. Its message is
+// the report, so responses contain results of all checks.
+type unhealthy struct {
+	report *Report
+}
+
+func (e *unhealthy) Error() string {
+	var names []string
+	for _, result := range e.report.Checks {
+		if !result.Healthy {
+			names = append(names, result.Name)
+		}
+	}
+	return "health checks failed: " + strings.Join(names, ", ")
+}
+
+// Code returns 503.
+func (e *unhealthy) Code() int {
+	return http.StatusServiceUnavailable
+}
+
+// Message returns the report.
+func (e *unhealthy) Message() interface{} {
+	return e.report
+}
+
+// handler returns a function for definitions. It responds the report with 200
+// if all checks pass, or with 503 otherwise.
+func handler(run func(ctx context.Context) *Report) func(ctx context.Context) (*Report, error) {
+	return func(ctx context.Context) (*Report, error) {
+		report := run(ctx)
+		if !report.Healthy {
+			return nil, &unhealthy{report}
+		}
+		return report, nil
+	}
+}
+
+// Descriptors returns descriptors of liveness path and readiness path. Empty
+// paths mean "/healthz" and "/readyz".
+func (p *Prober) Descriptors(livenessPath, readinessPath string) []definition.Descriptor {
+	if livenessPath == "" {
+		livenessPath = "/healthz"
+	}
+	if readinessPath == "" {
+		readinessPath = "/readyz"
+	}
+	descriptor := func(path, description string, run func(ctx context.Context) *Report) definition.Descriptor {
+		return definition.Descriptor{
+			Path:        path,
+			Description: description,
+			Consumes:    []string{definition.MIMEAll},
+			Produces:    []string{definition.MIMEJSON},
+			Definitions: []definition.Definition{{
+				Method:   definition.Get,
+				Results:  definition.DataErrorResults("results of checks"),
+				Function: handler(run),
+			}},
+		}
+	}
+	return []definition.Descriptor{
+		descriptor(livenessPath, "liveness checks", p.Liveness),
+		descriptor(readinessPath, "readiness checks", p.Readiness),
+	}
+}
+
+// rpcDescriptors returns descriptors of liveness path and readiness path for
+// RPC style builders.
+func (p *Prober) rpcDescriptors(livenessPath, readinessPath string) []definition.RPCDescriptor {
+	descriptors := p.Descriptors(livenessPath, readinessPath)
+	result := make([]definition.RPCDescriptor, len(descriptors))
+	for i, d := range descriptors {
+		def := d.Definitions[0]
+		result[i] = definition.RPCDescriptor{
+			Path:        d.Path,
+			Description: d.Description,
+			Consumes:    d.Consumes,
+			Produces:    d.Produces,
+			Actions: []definition.RPCAction{{
+				Results:  def.Results,
+				Function: def.Function,
+			}},
+		}
+	}
+	return result
+}
+
+// Plugin serves checks of a prober as a nirvana.Plugin. It's an alternative to
+// the external config, so use nirvana.Plugins(healthcheck.NewPlugin(...))
+// without other configurers of the package.
+type Plugin struct {
+	nirvana.NopPlugin
+	prober        *Prober
+	livenessPath  string
+	readinessPath string
+}
+
+// NewPlugin creates a plugin which serves liveness checks and readiness checks
+// of prober. Empty paths mean "/healthz" and "/readyz".
+func NewPlugin(prober *Prober, livenessPath, readinessPath string) *Plugin {
+	return &Plugin{
+		NopPlugin:     nirvana.NopPlugin{PluginName: ExternalConfigName},
+		prober:        prober,
+		livenessPath:  livenessPath,
+		readinessPath: readinessPath,
+	}
+}
+
+// Prober returns the prober of the plugin.
+func (p *Plugin) Prober() *Prober {
+	return p.prober
+}
+
+// Install adds the liveness path and the readiness path.
+func (p *Plugin) Install(builder service.Builder, cfg *nirvana.Config) error {
+	if builder.APIStyle() == service.APIStyleRPC {
+		for _, d := range p.prober.rpcDescriptors(p.livenessPath, p.readinessPath) {
+			if err := builder.AddDescriptor(d); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, d := range p.prober.Descriptors(p.livenessPath, p.readinessPath) {
+		if err := builder.AddDescriptor(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package healthcheck
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/caicloud/nirvana"
+	"github.com/caicloud/nirvana/service"
+	"github.com/caicloud/nirvana/service/rest"
+)
+
+func pass(ctx context.Context) error {
+	return nil
+}
+
+func newServer(t *testing.T, prober *Prober) service.Service {
+	builder := rest.NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := NewPlugin(prober, "", "").Install(builder, nirvana.NewConfig()); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func probe(t *testing.T, s service.Service, path string) (int, *Report) {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("Accept", "application/json")
+	resp := httptest.NewRecorder()
+	s.ServeHTTP(resp, req)
+	report := &Report{}
+	if err := json.Unmarshal(resp.Body.Bytes(), report); err != nil {
+		t.Fatalf("Response of %s should be a report: %v %s", path, err, resp.Body.String())
+	}
+	return resp.Code, report
+}
+
+func TestProberAllPassing(t *testing.T) {
+	prober := NewProber()
+	prober.AddLivenessChecks(Check{Name: "goroutines", Checker: pass})
+	prober.AddReadinessChecks(Check{Name: "database", Checker: pass}, Check{Name: "cache", Checker: pass})
+	s := newServer(t, prober)
+
+	code, report := probe(t, s, "/healthz")
+	if code != http.StatusOK || !report.Healthy || len(report.Checks) != 1 || report.Checks[0].Name != "goroutines" {
+		t.Fatalf("Unexpected liveness: %d %+v", code, report)
+	}
+	code, report = probe(t, s, "/readyz")
+	if code != http.StatusOK || !report.Healthy || len(report.Checks) != 2 {
+		t.Fatalf("Unexpected readiness: %d %+v", code, report)
+	}
+	for i, name := range []string{"database", "cache"} {
+		if result := report.Checks[i]; result.Name != name || !result.Healthy || result.Error != "" {
+			t.Fatalf("Unexpected result of %s: %+v", name, result)
+		}
+	}
+}
+
+func TestProberOneFailing(t *testing.T) {
+	prober := NewProber()
+	prober.AddLivenessChecks(Check{Name: "goroutines", Checker: pass})
+	prober.AddReadinessChecks(
+		Check{Name: "database", Checker: func(ctx context.Context) error {
+			return errors.New("connection refused")
+		}},
+		Check{Name: "cache", Checker: pass},
+	)
+	s := newServer(t, prober)
+
+	// Readiness checks don't affect liveness.
+	if code, report := probe(t, s, "/healthz"); code != http.StatusOK || !report.Healthy {
+		t.Fatalf("Unexpected liveness: %d %+v", code, report)
+	}
+	code, report := probe(t, s, "/readyz")
+	if code != http.StatusServiceUnavailable || report.Healthy || len(report.Checks) != 2 {
+		t.Fatalf("Unexpected readiness: %d %+v", code, report)
+	}
+	if result := report.Checks[0]; result.Healthy || result.Error != "connection refused" {
+		t.Fatalf("Unexpected result of database: %+v", result)
+	}
+	if result := report.Checks[1]; !result.Healthy {
+		t.Fatalf("Unexpected result of cache: %+v", result)
+	}
+}
+
+func TestProberTimeout(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	prober := NewProber()
+	prober.AddReadinessChecks(
+		// The check respects the cancellation of its context.
+		Check{Name: "slow", Timeout: 20 * time.Millisecond, Checker: func(ctx context.Context) error {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Second):
+				return nil
+			}
+		}},
+		// The check ignores its context.
+		Check{Name: "stuck", Timeout: 20 * time.Millisecond, Checker: func(ctx context.Context) error {
+			<-block
+			return nil
+		}},
+		Check{Name: "fast", Timeout: time.Second, Checker: pass},
+	)
+
+	start := time.Now()
+	report := prober.Readiness(context.Background())
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("Checks should time out separately, but took %v", elapsed)
+	}
+	if report.Healthy {
+		t.Fatalf("Report should be unhealthy: %+v", report)
+	}
+	for _, result := range report.Checks[:2] {
+		if result.Healthy || result.Error == "" {
+			t.Fatalf("Check %s should time out: %+v", result.Name, result)
+		}
+	}
+	if result := report.Checks[2]; !result.Healthy {
+		t.Fatalf("Check fast should pass: %+v", result)
+	}
+	if report := prober.Liveness(context.Background()); !report.Healthy || len(report.Checks) != 0 {
+		t.Fatalf("Liveness without checks should be healthy: %+v", report)
+	}
+}
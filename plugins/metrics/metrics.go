@@ -44,18 +44,32 @@ func (i *metricsInstaller) Name() string {
 	return ExternalConfigName
 }
 
-// Install installs stuffs before server starting.
+// Install installs stuffs before server starting. Requests are recorded with
+// route templates (such as "/api/v1/users/{id}") for RESTful services, and
+// with actions and versions for RPC services.
 func (i *metricsInstaller) Install(builder service.Builder, cfg *nirvana.Config) error {
 	var err error
 	wrapper(cfg, func(c *config) {
+		err = install(builder, c)
+	})
+	return err
+}
 
-		monitorMiddleware := definition.Descriptor{
+// install adds the metrics middleware and the metrics path to builder.
+func install(builder service.Builder, c *config) error {
+	options := &metrics.Options{NamespaceValue: c.namespace}
+	if builder.APIStyle() == service.APIStyleRPC {
+		monitorMiddleware := definition.RPCDescriptor{
 			Path:        "/",
-			Middlewares: []definition.Middleware{metricsmiddleware.Restful(&metrics.Options{NamespaceValue: c.namespace})},
+			Middlewares: []definition.Middleware{metricsmiddleware.RPC(options)},
 		}
-		err = builder.AddDescriptor(monitorMiddleware, metricsmiddleware.Descriptor(c.path))
-	})
-	return err
+		return builder.AddDescriptor(monitorMiddleware, metricsmiddleware.RPCDescriptor(c.path))
+	}
+	monitorMiddleware := definition.Descriptor{
+		Path:        "/",
+		Middlewares: []definition.Middleware{metricsmiddleware.Restful(options)},
+	}
+	return builder.AddDescriptor(monitorMiddleware, metricsmiddleware.Descriptor(c.path))
 }
 
 // Uninstall uninstalls stuffs after server terminating.
@@ -63,6 +77,34 @@ func (i *metricsInstaller) Uninstall(builder service.Builder, cfg *nirvana.Confi
 	return nil
 }
 
+// Plugin is metrics as a nirvana.Plugin. It's an alternative to the external
+// config, so use nirvana.Plugins(metrics.NewPlugin(...)) without other configurers
+// of the package.
+type Plugin struct {
+	nirvana.NopPlugin
+	config config
+}
+
+// NewPlugin creates a metrics plugin. Empty path and namespace mean "/metrics"
+// and "nirvana".
+func NewPlugin(path, namespace string) *Plugin {
+	if path == "" {
+		path = "/metrics"
+	}
+	if namespace == "" {
+		namespace = "nirvana"
+	}
+	return &Plugin{
+		NopPlugin: nirvana.NopPlugin{PluginName: ExternalConfigName},
+		config:    config{path: path, namespace: namespace},
+	}
+}
+
+// Install adds the metrics middleware and the metrics path.
+func (p *Plugin) Install(builder service.Builder, cfg *nirvana.Config) error {
+	return install(builder, &p.config)
+}
+
 // Disable returns a configurer to disable metrics.
 func Disable() nirvana.Configurer {
 	return func(c *nirvana.Config) error {
@@ -0,0 +1,127 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/caicloud/nirvana"
+	"github.com/caicloud/nirvana/definition"
+	"github.com/caicloud/nirvana/errors"
+	"github.com/caicloud/nirvana/service"
+	"github.com/caicloud/nirvana/service/rest"
+	"github.com/caicloud/nirvana/service/rpc"
+)
+
+func serve(s http.Handler, method, url string) *httptest.ResponseRecorder {
+	resp := httptest.NewRecorder()
+	s.ServeHTTP(resp, httptest.NewRequest(method, url, nil))
+	return resp
+}
+
+func TestRestfulMetrics(t *testing.T) {
+	builder := rest.NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := (&metricsInstaller{}).Install(builder, nirvana.NewConfig().Configure(Default())); err != nil {
+		t.Fatal(err)
+	}
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/api/v1/users/{id}",
+		Consumes: []string{definition.MIMEAll},
+		Produces: []string{definition.MIMEText},
+		Definitions: []definition.Definition{
+			{
+				Method: definition.Get,
+				Function: func(ctx context.Context, id string) (string, error) {
+					if id == "0" {
+						return "", errors.NotFound.Error("user not found")
+					}
+					return id, nil
+				},
+				Parameters: []definition.Parameter{definition.PathParameterFor("id", "")},
+				Results:    definition.DataErrorResults(""),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, url := range []string{"/api/v1/users/1", "/api/v1/users/2", "/api/v1/users/0", "/unknown"} {
+		serve(s, "GET", url)
+	}
+	resp := serve(s, "GET", "/metrics")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Metrics should be exposed: %d %s", resp.Code, resp.Body.String())
+	}
+	body := resp.Body.String()
+	for _, want := range []string{
+		`nirvana_request_total{action="",code="200",path="/api/v1/users/{id}",verb="GET",version=""} 2`,
+		`nirvana_request_total{action="",code="404",path="/api/v1/users/{id}",verb="GET",version=""} 1`,
+		`nirvana_request_duration_seconds_count{action="",path="/api/v1/users/{id}",verb="GET",version=""} 3`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("Metrics should contain %s, but got:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "/api/v1/users/1") || strings.Contains(body, "/unknown") {
+		t.Fatalf("Metrics should not be labeled by concrete paths:\n%s", body)
+	}
+}
+
+func TestRPCMetrics(t *testing.T) {
+	builder := rpc.NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := (&metricsInstaller{}).Install(builder, nirvana.NewConfig().Configure(Default())); err != nil {
+		t.Fatal(err)
+	}
+	if err := builder.AddDescriptor(definition.RPCDescriptor{
+		Path:     "/",
+		Consumes: []string{definition.MIMEAll},
+		Produces: []string{definition.MIMEText},
+		Actions: []definition.RPCAction{
+			{
+				Name:    "Ping",
+				Version: "2020-10-10",
+				Function: func(ctx context.Context) (string, error) {
+					return "pong", nil
+				},
+				Results: definition.DataErrorResults(""),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp := serve(s, "POST", "/?Action=Ping&Version=2020-10-10"); resp.Body.String() != "pong" {
+		t.Fatalf("Action returns wrong data: %d %s", resp.Code, resp.Body.String())
+	}
+	resp := serve(s, "GET", "/metrics")
+	want := `nirvana_request_total{action="Ping",code="200",path="",verb="",version="2020-10-10"} 1`
+	if !strings.Contains(resp.Body.String(), want) {
+		t.Fatalf("Metrics should contain %s, but got:\n%s", want, resp.Body.String())
+	}
+}
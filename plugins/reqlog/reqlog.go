@@ -18,6 +18,11 @@ package reqlog
 
 import (
 	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/caicloud/nirvana"
@@ -33,6 +38,33 @@ func init() {
 // ExternalConfigName is the external config name of request logger.
 const ExternalConfigName = "reqlog"
 
+// requestIDPrefab is the name of request id prefab from plugin requestid.
+const requestIDPrefab = "request-id"
+
+// Field is a field of structured request logs.
+type Field string
+
+const (
+	// FieldMethod is the http method.
+	FieldMethod Field = "method"
+	// FieldPath is the url path.
+	FieldPath Field = "path"
+	// FieldURL is the url with query.
+	FieldURL Field = "url"
+	// FieldStatus is the final status code of response.
+	FieldStatus Field = "status"
+	// FieldLatency is the duration of request handling.
+	FieldLatency Field = "latency"
+	// FieldSize is the content length of response.
+	FieldSize Field = "size"
+	// FieldRequestID is the request id. It's omitted if the request has no id.
+	FieldRequestID Field = "request_id"
+	// FieldSourceAddr is the address of client.
+	FieldSourceAddr Field = "source_addr"
+	// FieldError is the error returned by handlers. It's omitted if there is no error.
+	FieldError Field = "error"
+)
+
 // config is reqlog config.
 type config struct {
 	doubleLog  bool
@@ -40,6 +72,8 @@ type config struct {
 	requestKey string
 	requestID  bool
 	logger     log.Logger
+	fields     []Field
+	sampleRate float64
 }
 
 type reqlogInstaller struct{}
@@ -53,20 +87,40 @@ func (i *reqlogInstaller) Name() string {
 func (i *reqlogInstaller) Install(builder service.Builder, cfg *nirvana.Config) error {
 	var err error
 	wrapper(cfg, func(c *config) {
-		begin, end := i.buildPrinters(c)
+		if c.sampleRate <= 0 || c.sampleRate > 1 {
+			err = fmt.Errorf("sample rate of request logs must be in (0, 1], but got %v", c.sampleRate)
+			return
+		}
+		var begin, end printer
+		begin, end, err = i.buildPrinters(c)
+		if err != nil {
+			return
+		}
+		s := &sampler{rate: c.sampleRate}
 		err = builder.AddDescriptor(definition.Descriptor{
 			Path: "/",
 			Middlewares: []definition.Middleware{
 				func(ctx context.Context, next definition.Chain) error {
 					start := time.Now()
 					httpCtx := service.HTTPContextFrom(ctx)
-					begin(httpCtx, nil)
+					sampled := s.sample()
+					if sampled {
+						begin(httpCtx, map[string]interface{}{
+							requestContext: ctx,
+						})
+					}
 
 					err := next.Continue(ctx)
-					end(httpCtx, map[string]interface{}{
-						intervalDuration: time.Since(start),
-						responseError:    err,
-					})
+					code := finalStatusCode(httpCtx, err)
+					// Failed requests are always logged.
+					if sampled || code >= http.StatusInternalServerError {
+						end(httpCtx, map[string]interface{}{
+							intervalDuration: time.Since(start),
+							responseError:    err,
+							statusCode:       code,
+							requestContext:   ctx,
+						})
+					}
 					return err
 				},
 			},
@@ -75,17 +129,53 @@ func (i *reqlogInstaller) Install(builder service.Builder, cfg *nirvana.Config)
 	return err
 }
 
+// sampler samples requests by rate. It's deterministic, so exactly one of every
+// 1/rate requests is sampled.
+type sampler struct {
+	rate  float64
+	count uint64
+}
+
+func (s *sampler) sample() bool {
+	if s.rate >= 1 {
+		return true
+	}
+	n := atomic.AddUint64(&s.count, 1)
+	return uint64(float64(n)*s.rate) != uint64(float64(n-1)*s.rate)
+}
+
+// finalStatusCode returns the status code of response. If a middleware or an
+// executor returns an error before writing response, the server writes the error
+// after middlewares. So the status code is from the error. A request without
+// an error is successful even if nothing is written yet.
+func finalStatusCode(ctx service.HTTPContext, err error) int {
+	resp := ctx.ResponseWriter()
+	if !resp.HeaderWritable() {
+		return resp.StatusCode()
+	}
+	if err == nil {
+		return http.StatusOK
+	}
+	if e, ok := err.(service.Error); ok {
+		return e.Code()
+	}
+	return http.StatusInternalServerError
+}
+
 type printer func(ctx service.HTTPContext, data map[string]interface{})
 
 const (
 	intervalDuration = "intervalDuration"
 	responseError    = "responseError"
+	statusCode       = "statusCode"
+	requestContext   = "requestContext"
 )
 
-func (i *reqlogInstaller) buildPrinters(c *config) (begin printer, end printer) {
+type component func(ctx service.HTTPContext, data map[string]interface{}) interface{}
+
+func (i *reqlogInstaller) buildPrinters(c *config) (begin printer, end printer, err error) {
 	logger := c.logger
-	output := func(ctx service.HTTPContext, data map[string]interface{},
-		components []func(ctx service.HTTPContext, data map[string]interface{}) interface{}) {
+	output := func(ctx service.HTTPContext, data map[string]interface{}, components []component) {
 		results := make([]interface{}, 0, len(components))
 		for _, c := range components {
 			result := c(ctx, data)
@@ -109,13 +199,30 @@ func (i *reqlogInstaller) buildPrinters(c *config) (begin printer, end printer)
 	clientAddr := func(ctx service.HTTPContext, data map[string]interface{}) interface{} {
 		return ctx.Request().RemoteAddr
 	}
-	statusCode := func(ctx service.HTTPContext, data map[string]interface{}) interface{} {
+	path := func(ctx service.HTTPContext, data map[string]interface{}) interface{} {
+		return ctx.Request().URL.Path
+	}
+	status := func(ctx service.HTTPContext, data map[string]interface{}) interface{} {
+		if data != nil {
+			if result, ok := data[statusCode]; ok {
+				return result
+			}
+		}
 		return ctx.ResponseWriter().StatusCode()
 	}
 	contentLength := func(ctx service.HTTPContext, data map[string]interface{}) interface{} {
 		return ctx.ResponseWriter().ContentLength()
 	}
 	requestID := func(ctx service.HTTPContext, data map[string]interface{}) interface{} {
+		// The request id prefab is available if the plugin of request id runs before
+		// current middleware. Otherwise the id is only in request header.
+		if prefab := service.PrefabFor(requestIDPrefab); prefab != nil && data != nil {
+			if reqCtx, ok := data[requestContext].(context.Context); ok {
+				if id, err := prefab.Make(reqCtx); err == nil && id != "" {
+					return id
+				}
+			}
+		}
 		id := ctx.Request().Header.Get(c.requestKey)
 		if id == "" {
 			return nil
@@ -139,7 +246,46 @@ func (i *reqlogInstaller) buildPrinters(c *config) (begin printer, end printer)
 		return nil
 	}
 
-	beginning := []func(ctx service.HTTPContext, data map[string]interface{}) interface{}{}
+	if len(c.fields) > 0 {
+		components := map[Field]component{
+			FieldMethod:     method,
+			FieldPath:       path,
+			FieldURL:        url,
+			FieldStatus:     status,
+			FieldLatency:    interval,
+			FieldSize:       contentLength,
+			FieldRequestID:  requestID,
+			FieldSourceAddr: clientAddr,
+			FieldError:      respErr,
+		}
+		fields := make([]Field, 0, len(c.fields))
+		selected := make([]component, 0, len(c.fields))
+		for _, f := range c.fields {
+			if components[f] == nil {
+				return nil, nil, fmt.Errorf("unknown field %q of request logs", f)
+			}
+			fields = append(fields, f)
+			selected = append(selected, components[f])
+		}
+		structured := func(ctx service.HTTPContext, data map[string]interface{}) {
+			output(ctx, data, []component{func(ctx service.HTTPContext, data map[string]interface{}) interface{} {
+				pairs := make([]string, 0, len(fields))
+				for i, f := range fields {
+					if result := selected[i](ctx, data); result != nil {
+						pairs = append(pairs, string(f)+"="+formatValue(result))
+					}
+				}
+				return strings.Join(pairs, " ")
+			}})
+		}
+		return func(ctx service.HTTPContext, data map[string]interface{}) {
+			if c.doubleLog {
+				structured(ctx, data)
+			}
+		}, structured, nil
+	}
+
+	beginning := []component{}
 	if c.doubleLog {
 		beginning = append(beginning, method, url)
 		if c.requestID {
@@ -150,9 +296,9 @@ func (i *reqlogInstaller) buildPrinters(c *config) (begin printer, end printer)
 		}
 	}
 
-	ending := []func(ctx service.HTTPContext, data map[string]interface{}) interface{}{
+	ending := []component{
 		method,
-		statusCode,
+		status,
 		contentLength,
 		interval,
 		url,
@@ -171,7 +317,17 @@ func (i *reqlogInstaller) buildPrinters(c *config) (begin printer, end printer)
 		},
 		func(ctx service.HTTPContext, data map[string]interface{}) {
 			output(ctx, data, ending)
-		}
+		}, nil
+}
+
+// formatValue formats a value of structured logs. Values with spaces, quotes or
+// equal signs are quoted.
+func formatValue(v interface{}) string {
+	value := fmt.Sprint(v)
+	if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+		return strconv.Quote(value)
+	}
+	return value
 }
 
 // Uninstall uninstalls stuffs after server terminating.
@@ -197,6 +353,32 @@ func Default() nirvana.Configurer {
 	}
 }
 
+// Fields returns a configurer to output structured entries with fields in order,
+// such as "method=GET path=/api status=200 latency=1ms". Fields without values
+// are omitted. If no fields are set, entries are in the default layout.
+// Defaults to no fields.
+func Fields(fields ...Field) nirvana.Configurer {
+	return func(c *nirvana.Config) error {
+		wrapper(c, func(c *config) {
+			c.fields = fields
+		})
+		return nil
+	}
+}
+
+// SampleRate returns a configurer to set the rate of requests to log. The rate
+// should be in (0, 1]. For instance, 0.1 logs one of every 10 requests. Requests
+// with status codes of server errors are always logged.
+// Defaults to 1.
+func SampleRate(rate float64) nirvana.Configurer {
+	return func(c *nirvana.Config) error {
+		wrapper(c, func(c *config) {
+			c.sampleRate = rate
+		})
+		return nil
+	}
+}
+
 // Logger Configurer sets logger.
 func Logger(l log.Logger) nirvana.Configurer {
 	return func(c *nirvana.Config) error {
@@ -268,6 +450,7 @@ func wrapper(c *nirvana.Config, f func(c *config)) {
 		// Default config.
 		cfg = &config{
 			requestKey: "X-Request-Id",
+			sampleRate: 1,
 		}
 	} else {
 		// Panic if config type is wrong.
@@ -279,10 +462,12 @@ func wrapper(c *nirvana.Config, f func(c *config)) {
 
 // Option contains basic configurations of reqlog.
 type Option struct {
-	DoubleLog    bool   `desc:"Output two entries for every request"`
-	SourceAddr   bool   `desc:"Output source addr for request log"`
-	RequestID    bool   `desc:"Output request id for request log"`
-	RequestIDKey string `desc:"Request header key for request id"`
+	DoubleLog    bool     `desc:"Output two entries for every request"`
+	SourceAddr   bool     `desc:"Output source addr for request log"`
+	RequestID    bool     `desc:"Output request id for request log"`
+	RequestIDKey string   `desc:"Request header key for request id"`
+	Fields       []string `desc:"Fields of structured request logs, such as method, path, status and latency"`
+	SampleRate   float64  `desc:"Rate of requests to log, in (0, 1]"`
 }
 
 // NewDefaultOption creates default option.
@@ -292,6 +477,7 @@ func NewDefaultOption() *Option {
 		SourceAddr:   false,
 		RequestID:    false,
 		RequestIDKey: "X-Request-Id",
+		SampleRate:   1,
 	}
 }
 
@@ -307,6 +493,14 @@ func (p *Option) Configure(cfg *nirvana.Config) error {
 		SourceAddr(p.SourceAddr),
 		RequestID(p.RequestID),
 		RequestIDKey(p.RequestIDKey),
+		SampleRate(p.SampleRate),
 	)
+	if len(p.Fields) > 0 {
+		fields := make([]Field, len(p.Fields))
+		for i, f := range p.Fields {
+			fields[i] = Field(f)
+		}
+		cfg.Configure(Fields(fields...))
+	}
 	return nil
 }
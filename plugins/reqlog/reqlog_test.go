@@ -0,0 +1,200 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reqlog
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/caicloud/nirvana"
+	"github.com/caicloud/nirvana/definition"
+	"github.com/caicloud/nirvana/errors"
+	"github.com/caicloud/nirvana/log"
+	"github.com/caicloud/nirvana/plugins/requestid"
+	"github.com/caicloud/nirvana/service"
+	"github.com/caicloud/nirvana/service/rest"
+)
+
+type recorder struct {
+	log.SilentLogger
+	entries []string
+}
+
+func (r *recorder) Infoln(v ...interface{}) {
+	r.entries = append(r.entries, fmt.Sprint(v...))
+}
+
+func newServer(t *testing.T, configurers ...nirvana.Configurer) (service.Service, error) {
+	cfg := nirvana.NewConfig().Configure(configurers...)
+	builder := rest.NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := (&reqlogInstaller{}).Install(builder, cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Config(requestid.ExternalConfigName) != nil {
+		if err := nirvana.ConfigInstallerFor(requestid.ExternalConfigName).Install(builder, cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/",
+		Consumes: []string{definition.MIMENone},
+		Produces: []string{definition.MIMEText},
+		Children: []definition.Descriptor{
+			{
+				Path: "/ok",
+				Definitions: []definition.Definition{
+					{
+						Method: definition.Get,
+						Function: func(ctx context.Context) (string, error) {
+							return "ok", nil
+						},
+						Results: definition.DataErrorResults(""),
+					},
+				},
+			},
+			{
+				Path: "/fail",
+				Definitions: []definition.Definition{
+					{
+						Method: definition.Get,
+						Function: func(ctx context.Context) (string, error) {
+							return "", errors.ServiceUnavailable.Build("Test:Unavailable", "unavailable").Error()
+						},
+						Results: definition.DataErrorResults(""),
+					},
+				},
+			},
+			{
+				Path: "/denied",
+				Middlewares: []definition.Middleware{
+					func(ctx context.Context, chain definition.Chain) error {
+						return errors.Forbidden.Build("Test:Denied", "denied").Error()
+					},
+				},
+				Definitions: []definition.Definition{
+					{
+						Method: definition.Get,
+						Function: func(ctx context.Context) (string, error) {
+							return "ok", nil
+						},
+						Results: definition.DataErrorResults(""),
+					},
+				},
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, nil
+}
+
+func serve(s service.Service, path string, header map[string]string) int {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp := httptest.NewRecorder()
+	s.ServeHTTP(resp, req)
+	return resp.Code
+}
+
+func TestStructuredLog(t *testing.T) {
+	r := &recorder{}
+	s, err := newServer(t, Default(), Logger(r), Fields(FieldMethod, FieldPath, FieldStatus, FieldRequestID, FieldLatency, FieldError))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := serve(s, "/ok", map[string]string{"X-Request-ID": "abc"}); code != http.StatusOK {
+		t.Fatalf("Response code should be 200, but got: %d", code)
+	}
+	if code := serve(s, "/denied", nil); code != http.StatusForbidden {
+		t.Fatalf("Response code should be 403, but got: %d", code)
+	}
+	if len(r.entries) != 2 {
+		t.Fatalf("Every request should have one entry: %v", r.entries)
+	}
+	if !regexp.MustCompile(`^method=GET path=/ok status=200 request_id=abc latency=\S+$`).MatchString(r.entries[0]) {
+		t.Fatalf("Entry is not desired: %s", r.entries[0])
+	}
+	// The error of middleware is written by server, and the status code is from the error.
+	if !regexp.MustCompile(`^method=GET path=/denied status=403 latency=\S+ error=denied$`).MatchString(r.entries[1]) {
+		t.Fatalf("Entry is not desired: %s", r.entries[1])
+	}
+
+	r = &recorder{}
+	s, err = newServer(t, Default(), Logger(r), Fields(FieldPath, FieldRequestID), requestid.Default(), requestid.Generator(func() string {
+		return "generated"
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve(s, "/ok", nil)
+	if !reflect.DeepEqual(r.entries, []string{"path=/ok request_id=generated"}) {
+		t.Fatalf("Entries should contain generated request id: %v", r.entries)
+	}
+}
+
+func TestSampleRate(t *testing.T) {
+	r := &recorder{}
+	s, err := newServer(t, Default(), Logger(r), Fields(FieldPath, FieldStatus), SampleRate(0.5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		serve(s, "/ok", nil)
+	}
+	serve(s, "/fail", nil)
+	if !reflect.DeepEqual(r.entries, []string{"path=/ok status=200", "path=/ok status=200", "path=/fail status=503"}) {
+		t.Fatalf("Entries are not sampled: %v", r.entries)
+	}
+
+	for _, c := range []nirvana.Configurer{SampleRate(0), SampleRate(1.5), Fields("unknown")} {
+		if _, err := newServer(t, Default(), c); err == nil {
+			t.Fatal("Invalid config should not be installed")
+		}
+	}
+}
+
+func TestFinalStatusCode(t *testing.T) {
+	newCtx := func() service.HTTPContext {
+		return service.NewHTTPContext(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+	if code := finalStatusCode(newCtx(), nil); code != http.StatusOK {
+		t.Fatalf("Status code without error should be 200, but got: %d", code)
+	}
+	if code := finalStatusCode(newCtx(), errors.Forbidden.Build("Test:Denied", "denied").Error()); code != http.StatusForbidden {
+		t.Fatalf("Status code should be from the error, but got: %d", code)
+	}
+	if code := finalStatusCode(newCtx(), fmt.Errorf("unknown")); code != http.StatusInternalServerError {
+		t.Fatalf("Status code of unknown error should be 500, but got: %d", code)
+	}
+	ctx := newCtx()
+	ctx.ResponseWriter().WriteHeader(http.StatusAccepted)
+	if code := finalStatusCode(ctx, fmt.Errorf("unknown")); code != http.StatusAccepted {
+		t.Fatalf("Status code should be the written one, but got: %d", code)
+	}
+}
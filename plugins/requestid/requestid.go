@@ -0,0 +1,224 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"reflect"
+
+	"github.com/caicloud/nirvana"
+	"github.com/caicloud/nirvana/definition"
+	"github.com/caicloud/nirvana/service"
+)
+
+func init() {
+	nirvana.RegisterConfigInstaller(&requestIDInstaller{})
+	if err := service.RegisterPrefab(&requestIDPrefab{}); err != nil {
+		panic(err)
+	}
+}
+
+// ExternalConfigName is the external config name of request id.
+const ExternalConfigName = "requestid"
+
+// PrefabName is the name of request id prefab. Handlers can get the request
+// id by definition.PrefabParameterFor(PrefabName, "").
+const PrefabName = "request-id"
+
+// DefaultHeader is the default header of request id.
+const DefaultHeader = "X-Request-ID"
+
+// config is request id config.
+type config struct {
+	header    string
+	generator func() string
+}
+
+type contextKey struct{}
+
+// FromContext returns the request id in ctx. It returns an empty string if
+// the plugin is not installed.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+type requestIDInstaller struct{}
+
+// Name is the external config name.
+func (i *requestIDInstaller) Name() string {
+	return ExternalConfigName
+}
+
+// Install installs stuffs before server starting.
+func (i *requestIDInstaller) Install(builder service.Builder, cfg *nirvana.Config) error {
+	var err error
+	wrapper(cfg, func(c *config) {
+		middlewares := []definition.Middleware{middleware(c)}
+		if builder.APIStyle() == service.APIStyleRPC {
+			err = builder.AddDescriptor(definition.RPCDescriptor{Path: "/", Middlewares: middlewares})
+			return
+		}
+		err = builder.AddDescriptor(definition.Descriptor{Path: "/", Middlewares: middlewares})
+	})
+	return err
+}
+
+func middleware(c *config) definition.Middleware {
+	return func(ctx context.Context, next definition.Chain) error {
+		httpCtx := service.HTTPContextFrom(ctx)
+		if httpCtx == nil {
+			return next.Continue(ctx)
+		}
+		req := httpCtx.Request()
+		id := req.Header.Get(c.header)
+		if id == "" {
+			id = c.generator()
+			// Set the header so that other components (such as request logs)
+			// can get the request id from the request.
+			req.Header.Set(c.header, id)
+		}
+		httpCtx.ResponseWriter().Header().Set(c.header, id)
+		return next.Continue(context.WithValue(ctx, contextKey{}, id))
+	}
+}
+
+// NewUUID generates a random UUID (version 4).
+func NewUUID() string {
+	var uuid [16]byte
+	if _, err := rand.Read(uuid[:]); err != nil {
+		panic(err)
+	}
+	uuid[6] = (uuid[6] & 0x0f) | 0x40
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:])
+}
+
+// requestIDPrefab returns the request id in context.
+type requestIDPrefab struct{}
+
+// Name returns prefab name.
+func (p *requestIDPrefab) Name() string {
+	return PrefabName
+}
+
+// Type is type of string.
+func (p *requestIDPrefab) Type() reflect.Type {
+	return reflect.TypeOf("")
+}
+
+// Make returns the request id in context.
+func (p *requestIDPrefab) Make(ctx context.Context) (interface{}, error) {
+	return FromContext(ctx), nil
+}
+
+// Uninstall uninstalls stuffs after server terminating.
+func (i *requestIDInstaller) Uninstall(builder service.Builder, cfg *nirvana.Config) error {
+	return nil
+}
+
+// Disable returns a configurer to disable request id.
+func Disable() nirvana.Configurer {
+	return func(c *nirvana.Config) error {
+		c.Set(ExternalConfigName, nil)
+		return nil
+	}
+}
+
+// Default returns a configurer to enable request id with default config.
+func Default() nirvana.Configurer {
+	return func(c *nirvana.Config) error {
+		wrapper(c, func(c *config) {})
+		return nil
+	}
+}
+
+// Header returns a configurer to set the header of request id. The header is
+// read from requests and echoed on responses.
+// Defaults to DefaultHeader.
+func Header(header string) nirvana.Configurer {
+	return func(c *nirvana.Config) error {
+		wrapper(c, func(c *config) {
+			c.header = header
+		})
+		return nil
+	}
+}
+
+// Generator returns a configurer to set the generator of request id. It's used
+// when a request has no request id.
+// Defaults to NewUUID.
+func Generator(generator func() string) nirvana.Configurer {
+	return func(c *nirvana.Config) error {
+		wrapper(c, func(c *config) {
+			c.generator = generator
+		})
+		return nil
+	}
+}
+
+func wrapper(c *nirvana.Config, f func(c *config)) {
+	conf := c.Config(ExternalConfigName)
+	var cfg *config
+	if conf == nil {
+		// Default config.
+		cfg = &config{
+			header:    DefaultHeader,
+			generator: NewUUID,
+		}
+	} else {
+		// Panic if config type is wrong.
+		cfg = conf.(*config)
+	}
+	f(cfg)
+	c.Set(ExternalConfigName, cfg)
+}
+
+// Option contains basic configurations of request id.
+type Option struct {
+	// Enable enables request id.
+	Enable bool `desc:"Enable request id"`
+	// Header is the header of request id.
+	Header string `desc:"Header of request id"`
+}
+
+// NewDefaultOption creates default option.
+func NewDefaultOption() *Option {
+	return &Option{
+		Enable: false,
+		Header: DefaultHeader,
+	}
+}
+
+// Name returns plugin name.
+func (p *Option) Name() string {
+	return ExternalConfigName
+}
+
+// Configure configures nirvana config via current options.
+func (p *Option) Configure(cfg *nirvana.Config) error {
+	if !p.Enable {
+		cfg.Configure(Disable())
+		return nil
+	}
+	cfg.Configure(
+		Header(p.Header),
+	)
+	return nil
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package requestid
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/caicloud/nirvana"
+	"github.com/caicloud/nirvana/definition"
+	"github.com/caicloud/nirvana/service"
+	"github.com/caicloud/nirvana/service/rest"
+	"github.com/caicloud/nirvana/service/rpc"
+)
+
+func TestNewUUID(t *testing.T) {
+	pattern := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	a, b := NewUUID(), NewUUID()
+	if !pattern.MatchString(a) || a == b {
+		t.Fatalf("UUIDs are not valid: %s, %s", a, b)
+	}
+}
+
+func newServer(t *testing.T, configurers ...nirvana.Configurer) service.Service {
+	cfg := nirvana.NewConfig().Configure(configurers...)
+	builder := rest.NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := (&requestIDInstaller{}).Install(builder, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/id",
+		Consumes: []string{definition.MIMENone},
+		Produces: []string{definition.MIMEText},
+		Definitions: []definition.Definition{
+			{
+				Method: definition.Get,
+				Function: func(ctx context.Context, id string) (string, error) {
+					return id, nil
+				},
+				Parameters: []definition.Parameter{definition.PrefabParameterFor(PrefabName, "")},
+				Results:    definition.DataErrorResults(""),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestRequestID(t *testing.T) {
+	s := newServer(t, Default())
+	req := httptest.NewRequest(http.MethodGet, "/id", nil)
+	req.Header.Set(DefaultHeader, "abc")
+	resp := httptest.NewRecorder()
+	s.ServeHTTP(resp, req)
+	if resp.Body.String() != "abc" || resp.Header().Get(DefaultHeader) != "abc" {
+		t.Fatalf("Request id should be propagated: %q, %q", resp.Body.String(), resp.Header().Get(DefaultHeader))
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/id", nil)
+	resp = httptest.NewRecorder()
+	s.ServeHTTP(resp, req)
+	id := resp.Header().Get(DefaultHeader)
+	if id == "" || resp.Body.String() != id {
+		t.Fatalf("Request id should be generated: %q, %q", resp.Body.String(), id)
+	}
+
+	s = newServer(t, Header("X-Trace-Id"), Generator(func() string { return "generated" }))
+	req = httptest.NewRequest(http.MethodGet, "/id", nil)
+	req.Header.Set(DefaultHeader, "ignored")
+	resp = httptest.NewRecorder()
+	s.ServeHTTP(resp, req)
+	if resp.Body.String() != "generated" || resp.Header().Get("X-Trace-Id") != "generated" {
+		t.Fatalf("Request id should be generated by custom generator: %q, %v", resp.Body.String(), resp.Header())
+	}
+}
+
+func TestRPCRequestID(t *testing.T) {
+	builder := rpc.NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := (&requestIDInstaller{}).Install(builder, nirvana.NewConfig().Configure(Default())); err != nil {
+		t.Fatal(err)
+	}
+	if err := builder.AddDescriptor(definition.RPCDescriptor{
+		Path:     "/",
+		Consumes: []string{definition.MIMEAll},
+		Produces: []string{definition.MIMEText},
+		Actions: []definition.RPCAction{
+			{
+				Name: "GetID",
+				Function: func(ctx context.Context) (string, error) {
+					return FromContext(ctx), nil
+				},
+				Results: definition.DataErrorResults(""),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/?Action=GetID", nil)
+	req.Header.Set(DefaultHeader, "abc")
+	resp := httptest.NewRecorder()
+	s.ServeHTTP(resp, req)
+	if resp.Body.String() != "abc" || resp.Header().Get(DefaultHeader) != "abc" {
+		t.Fatalf("Request id should be propagated: %d %q, %q", resp.Code, resp.Body.String(), resp.Header().Get(DefaultHeader))
+	}
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/caicloud/nirvana"
+	"github.com/caicloud/nirvana/definition"
+	"github.com/caicloud/nirvana/service"
+)
+
+// PluginName is the name of the telemetry plugin.
+const PluginName = "telemetry"
+
+// Plugin traces requests as server spans. Install it by nirvana.Plugins:
+//
+//	nirvana.NewDefaultConfig().Configure(nirvana.Plugins(telemetry.NewPlugin(exporter)))
+//
+// Handlers get the span by SpanFromContext, and start child spans by
+// TracerFromContext. Without the plugin they get nil spans and tracers, which
+// do nothing.
+type Plugin struct {
+	nirvana.NopPlugin
+	tracer   *Tracer
+	exporter Exporter
+}
+
+// NewPlugin creates a plugin which sends spans to exporter. If exporter has
+// method "Shutdown(context.Context) error", it's called when the plugin stops.
+func NewPlugin(exporter Exporter) *Plugin {
+	return &Plugin{
+		NopPlugin: nirvana.NopPlugin{PluginName: PluginName},
+		tracer:    NewTracer(exporter),
+		exporter:  exporter,
+	}
+}
+
+// Tracer returns the tracer of the plugin.
+func (p *Plugin) Tracer() *Tracer {
+	return p.tracer
+}
+
+// Install adds the tracing middleware to the root path.
+func (p *Plugin) Install(builder service.Builder, config *nirvana.Config) error {
+	middlewares := []definition.Middleware{Middleware(p.tracer)}
+	if builder.APIStyle() == service.APIStyleRPC {
+		return builder.AddDescriptor(definition.RPCDescriptor{Path: "/", Middlewares: middlewares})
+	}
+	return builder.AddDescriptor(definition.Descriptor{Path: "/", Middlewares: middlewares})
+}
+
+// Stop shuts down the exporter.
+func (p *Plugin) Stop(ctx context.Context) error {
+	if s, ok := p.exporter.(interface{ Shutdown(context.Context) error }); ok {
+		return s.Shutdown(ctx)
+	}
+	return nil
+}
+
+// Middleware returns a middleware which traces requests. A span starts before
+// subsequent middlewares and ends after the response is written. The span is
+// named by the route template, such as "/api/v1/users/{id}", and continues the
+// trace in header "traceparent". Install it on the root path to trace all
+// requests.
+func Middleware(tracer *Tracer) definition.Middleware {
+	return func(ctx context.Context, chain definition.Chain) error {
+		httpCtx := service.HTTPContextFrom(ctx)
+		req := httpCtx.Request()
+		if sc, ok := Extract(req.Header); ok {
+			ctx = ContextWithRemoteSpanContext(ctx, sc)
+		}
+		route := httpCtx.RoutePath()
+		name := route
+		if name == "" {
+			name = req.Method
+		}
+		ctx, span := tracer.Start(ctx, name, SpanKindServer)
+		defer span.End()
+		span.SetAttribute("http.method", req.Method)
+		span.SetAttribute("http.route", route)
+		span.SetAttribute("http.target", req.URL.RequestURI())
+
+		err := chain.Continue(ctx)
+		code := httpCtx.ResponseWriter().StatusCode()
+		if err != nil {
+			// Errors of middlewares are written after the chain.
+			code = http.StatusInternalServerError
+			if e, ok := err.(service.Error); ok {
+				code = e.Code()
+			}
+			span.RecordError(err)
+		}
+		span.SetAttribute("http.status_code", code)
+		if code >= http.StatusInternalServerError {
+			span.RecordError(errors.New(http.StatusText(code)))
+		}
+		return err
+	}
+}
+
+// Transport traces outgoing requests as client spans, and propagates traces
+// to servers by header "traceparent". Requests are traced only if their contexts
+// have tracers, such as contexts of handlers.
+//
+//	client := &http.Client{Transport: &telemetry.Transport{}}
+//	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+type Transport struct {
+	// Base sends requests. Defaults to http.DefaultTransport.
+	Base http.RoundTripper
+}
+
+// RoundTrip sends req in a client span.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	tracer := TracerFromContext(req.Context())
+	if tracer == nil {
+		return base.RoundTrip(req)
+	}
+	ctx, span := tracer.Start(req.Context(), "HTTP "+req.Method, SpanKindClient)
+	defer span.End()
+	req = req.Clone(ctx)
+	Inject(ctx, req.Header)
+	span.SetAttribute("http.method", req.Method)
+	span.SetAttribute("http.url", req.URL.String())
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	span.SetAttribute("http.status_code", resp.StatusCode)
+	if resp.StatusCode >= http.StatusBadRequest {
+		span.RecordError(errors.New(http.StatusText(resp.StatusCode)))
+	}
+	return resp, nil
+}
@@ -0,0 +1,363 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package telemetry traces requests by spans of W3C Trace Context, which is the
+// propagation format of OpenTelemetry. Spans are sent to an Exporter when they
+// end, so they can be forwarded to OpenTelemetry SDKs and collectors.
+package telemetry
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// HeaderTraceParent is the header which carries the trace and parent span.
+	HeaderTraceParent = "traceparent"
+	// HeaderTraceState is the header which carries vendor specific trace data.
+	HeaderTraceState = "tracestate"
+)
+
+// TraceID identifies a trace.
+type TraceID [16]byte
+
+// String returns the hex form of the id.
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanID identifies a span.
+type SpanID [8]byte
+
+// String returns the hex form of the id.
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanContext identifies a span and carries its propagated states.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	// Sampled indicates whether the span is recorded and exported.
+	Sampled bool
+	// TraceState is the value of header "tracestate". It's propagated as is.
+	TraceState string
+	// Remote indicates whether the span context is extracted from a request.
+	Remote bool
+}
+
+// IsValid checks whether ids of the span context are not zero.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// TraceParent returns the value of header "traceparent", such as
+// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01".
+func (sc SpanContext) TraceParent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceParent parses the value of header "traceparent". It returns false if
+// the value is malformed or contains zero ids.
+func ParseTraceParent(value string) (SpanContext, bool) {
+	sc := SpanContext{Remote: true}
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		// Later versions may have more parts.
+		return sc, false
+	}
+	var version, flags [1]byte
+	if !decodeHex(version[:], parts[0]) || !decodeHex(sc.TraceID[:], parts[1]) ||
+		!decodeHex(sc.SpanID[:], parts[2]) || !decodeHex(flags[:], parts[3]) {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.IsValid()
+}
+
+// decodeHex decodes lower case hex of exactly len(dst) bytes.
+func decodeHex(dst []byte, s string) bool {
+	if len(s) != hex.EncodedLen(len(dst)) || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
+
+// Extract returns the span context in headers.
+func Extract(header http.Header) (SpanContext, bool) {
+	sc, ok := ParseTraceParent(header.Get(HeaderTraceParent))
+	if ok {
+		sc.TraceState = header.Get(HeaderTraceState)
+	}
+	return sc, ok
+}
+
+// Inject sets headers of the span in ctx, so the trace is continued by the
+// receiver of headers. It does nothing if ctx has no span.
+func Inject(ctx context.Context, header http.Header) {
+	span := SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+	header.Set(HeaderTraceParent, span.context.TraceParent())
+	if span.context.TraceState != "" {
+		header.Set(HeaderTraceState, span.context.TraceState)
+	} else {
+		header.Del(HeaderTraceState)
+	}
+}
+
+// SpanKind describes the role of a span.
+type SpanKind string
+
+const (
+	// SpanKindInternal is a span of internal operations.
+	SpanKindInternal SpanKind = "internal"
+	// SpanKindServer is a span of incoming requests.
+	SpanKindServer SpanKind = "server"
+	// SpanKindClient is a span of outgoing requests.
+	SpanKindClient SpanKind = "client"
+)
+
+// SpanData is a snapshot of an ended span.
+type SpanData struct {
+	Name        string
+	Kind        SpanKind
+	SpanContext SpanContext
+	// Parent is the span context of the parent. It's invalid for root spans.
+	Parent     SpanContext
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]interface{}
+	// Error describes why the span failed. It's empty if the span succeeded.
+	Error string
+}
+
+// Exporter receives ended spans which are sampled. It must be safe for
+// concurrent use, and should not block requests.
+type Exporter interface {
+	Export(span SpanData)
+}
+
+// Span is an operation in a trace. Methods of a nil span do nothing, so
+// handlers can use spans without checking whether tracing is installed.
+type Span struct {
+	lock     sync.Mutex
+	tracer   *Tracer
+	data     SpanData
+	context  SpanContext
+	ended    bool
+	recorded bool
+}
+
+// SpanContext returns the span context. It's zero for nil spans.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.context
+}
+
+// SetName changes the name of span.
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.data.Name = name
+}
+
+// SetAttribute sets an attribute of span.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil || !s.recorded {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.data.Attributes == nil {
+		s.data.Attributes = map[string]interface{}{}
+	}
+	s.data.Attributes[key] = value
+}
+
+// RecordError marks span as failed by err. The first error wins.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.data.Error == "" {
+		s.data.Error = err.Error()
+	}
+}
+
+// End ends span and exports it. Only the first call works.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	if s.ended {
+		s.lock.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = s.tracer.now()
+	data := s.data
+	s.lock.Unlock()
+	if s.recorded && s.tracer.exporter != nil {
+		s.tracer.exporter.Export(data)
+	}
+}
+
+// Tracer creates spans. Methods of a nil tracer create nil spans.
+type Tracer struct {
+	exporter Exporter
+	now      func() time.Time
+}
+
+// NewTracer creates a tracer which sends spans to exporter.
+func NewTracer(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter, now: time.Now}
+}
+
+type contextKey string
+
+const (
+	contextKeySpan   contextKey = "telemetry:span"
+	contextKeyTracer contextKey = "telemetry:tracer"
+	contextKeyRemote contextKey = "telemetry:remote"
+)
+
+// SpanFromContext returns the current span in ctx. It returns nil if there is no span.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(contextKeySpan).(*Span)
+	return span
+}
+
+// TracerFromContext returns the tracer of the request in ctx. It returns nil if
+// tracing is not installed.
+func TracerFromContext(ctx context.Context) *Tracer {
+	tracer, _ := ctx.Value(contextKeyTracer).(*Tracer)
+	return tracer
+}
+
+// ContextWithTracer returns a context with tracer.
+func ContextWithTracer(ctx context.Context, tracer *Tracer) context.Context {
+	return context.WithValue(ctx, contextKeyTracer, tracer)
+}
+
+// ContextWithRemoteSpanContext returns a context whose spans are children of a
+// remote span.
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, contextKeyRemote, sc)
+}
+
+// Start starts a span which is a child of the span in ctx, or a remote span in
+// ctx. Otherwise the span starts a new trace. The returned context contains the
+// span. Call End of the span when the operation ends.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	var parent SpanContext
+	if span := SpanFromContext(ctx); span != nil {
+		parent = span.context
+	} else if sc, ok := ctx.Value(contextKeyRemote).(SpanContext); ok {
+		parent = sc
+	}
+	sc := SpanContext{Sampled: true}
+	if parent.IsValid() {
+		sc.TraceID = parent.TraceID
+		sc.Sampled = parent.Sampled
+		sc.TraceState = parent.TraceState
+	} else {
+		parent = SpanContext{}
+		randomID(sc.TraceID[:])
+	}
+	randomID(sc.SpanID[:])
+	span := &Span{
+		tracer:   t,
+		context:  sc,
+		recorded: sc.Sampled,
+		data: SpanData{
+			Name:        name,
+			Kind:        kind,
+			SpanContext: sc,
+			Parent:      parent,
+			Start:       t.now(),
+		},
+	}
+	ctx = context.WithValue(ctx, contextKeySpan, span)
+	if TracerFromContext(ctx) != t {
+		ctx = ContextWithTracer(ctx, t)
+	}
+	return ctx, span
+}
+
+// randomID fills id with random bytes which are not all zero.
+func randomID(id []byte) {
+	for {
+		if _, err := rand.Read(id); err != nil {
+			panic(fmt.Sprintf("Can't generate random ids: %v", err))
+		}
+		for _, b := range id {
+			if b != 0 {
+				return
+			}
+		}
+	}
+}
+
+// InMemoryExporter keeps spans in memory. It's used for tests.
+type InMemoryExporter struct {
+	lock  sync.Mutex
+	spans []SpanData
+}
+
+// Export appends span.
+func (e *InMemoryExporter) Export(span SpanData) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.spans = append(e.spans, span)
+}
+
+// Spans returns exported spans in the order of ending.
+func (e *InMemoryExporter) Spans() []SpanData {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return append([]SpanData(nil), e.spans...)
+}
+
+// Reset removes exported spans.
+func (e *InMemoryExporter) Reset() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.spans = nil
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package telemetry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caicloud/nirvana"
+	"github.com/caicloud/nirvana/definition"
+	"github.com/caicloud/nirvana/errors"
+	"github.com/caicloud/nirvana/service"
+	"github.com/caicloud/nirvana/service/rest"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	tests := []struct {
+		value string
+		ok    bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true},
+		// Later versions may have more fields.
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01", false},
+		{"garbage", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		sc, ok := ParseTraceParent(test.value)
+		if ok != test.ok {
+			t.Errorf("Value %q: got %v, want %v", test.value, ok, test.ok)
+			continue
+		}
+		if ok && test.value[:2] == "00" && sc.TraceParent() != test.value {
+			t.Errorf("Value %q: got %q after formatting", test.value, sc.TraceParent())
+		}
+	}
+}
+
+func TestNoop(t *testing.T) {
+	ctx := context.Background()
+	if SpanFromContext(ctx) != nil || TracerFromContext(ctx) != nil {
+		t.Fatal("Context should have no span and tracer")
+	}
+	ctx, span := TracerFromContext(ctx).Start(ctx, "noop", SpanKindInternal)
+	span.SetAttribute("key", "value")
+	span.RecordError(errors.InternalServerError.Error("error"))
+	span.End()
+	header := http.Header{}
+	Inject(ctx, header)
+	if span != nil || len(header) != 0 {
+		t.Fatalf("Nil tracer should do nothing: %v %v", span, header)
+	}
+}
+
+func TestPlugin(t *testing.T) {
+	var outgoing http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		outgoing = r.Header.Clone()
+	}))
+	defer backend.Close()
+	client := &http.Client{Transport: &Transport{}}
+
+	exporter := &InMemoryExporter{}
+	plugin := NewPlugin(exporter)
+	builder := rest.NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := plugin.Install(builder, nirvana.NewConfig()); err != nil {
+		t.Fatal(err)
+	}
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/api/users/{id}",
+		Consumes: []string{definition.MIMENone},
+		Produces: []string{definition.MIMEText},
+		Definitions: []definition.Definition{
+			{
+				Method: definition.Get,
+				Function: func(ctx context.Context, id string) (string, error) {
+					if id == "0" {
+						return "", errors.InternalServerError.Error("user 0 is broken")
+					}
+					SpanFromContext(ctx).SetAttribute("user.id", id)
+					req, err := http.NewRequest(http.MethodGet, backend.URL, nil)
+					if err != nil {
+						return "", err
+					}
+					resp, err := client.Do(req.WithContext(ctx))
+					if err != nil {
+						return "", err
+					}
+					return id, resp.Body.Close()
+				},
+				Parameters: []definition.Parameter{definition.PathParameterFor("id", "")},
+				Results:    definition.DataErrorResults(""),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req := httptest.NewRequest(http.MethodGet, "/api/users/1?verbose=true", nil)
+	req.Header.Set(HeaderTraceParent, parent)
+	req.Header.Set(HeaderTraceState, "vendor=value")
+	resp := httptest.NewRecorder()
+	s.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Response code should be 200, but got: %d %s", resp.Code, resp.Body.String())
+	}
+	spans := exporter.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("There should be a client span and a server span, but got: %+v", spans)
+	}
+	clientSpan, serverSpan := spans[0], spans[1]
+	remote, _ := ParseTraceParent(parent)
+	if serverSpan.Name != "/api/users/{id}" || serverSpan.Kind != SpanKindServer || serverSpan.Error != "" {
+		t.Fatalf("Unexpected server span: %+v", serverSpan)
+	}
+	if serverSpan.Parent.SpanID != remote.SpanID || serverSpan.SpanContext.TraceID != remote.TraceID ||
+		serverSpan.SpanContext.TraceState != "vendor=value" {
+		t.Fatalf("Server span should continue the remote trace: %+v", serverSpan)
+	}
+	for key, want := range map[string]interface{}{
+		"http.method":      http.MethodGet,
+		"http.route":       "/api/users/{id}",
+		"http.target":      "/api/users/1?verbose=true",
+		"http.status_code": http.StatusOK,
+		"user.id":          "1",
+	} {
+		if got := serverSpan.Attributes[key]; got != want {
+			t.Errorf("Attribute %s of server span: got %v, want %v", key, got, want)
+		}
+	}
+	if clientSpan.Kind != SpanKindClient || clientSpan.Parent.SpanID != serverSpan.SpanContext.SpanID ||
+		clientSpan.Attributes["http.status_code"] != http.StatusOK {
+		t.Fatalf("Client span should be a child of server span: %+v", clientSpan)
+	}
+	if got := outgoing.Get(HeaderTraceParent); got != clientSpan.SpanContext.TraceParent() {
+		t.Fatalf("Trace should be propagated to outgoing requests: %q", got)
+	}
+	if got := outgoing.Get(HeaderTraceState); got != "vendor=value" {
+		t.Fatalf("Trace state should be propagated to outgoing requests: %q", got)
+	}
+
+	exporter.Reset()
+	req = httptest.NewRequest(http.MethodGet, "/api/users/0", nil)
+	req.Header.Set(HeaderTraceParent, "malformed")
+	s.ServeHTTP(httptest.NewRecorder(), req)
+	spans = exporter.Spans()
+	if len(spans) != 1 {
+		t.Fatalf("There should be a server span, but got: %+v", spans)
+	}
+	if spans[0].Parent.IsValid() || !spans[0].SpanContext.IsValid() {
+		t.Fatalf("Malformed trace parent should start a new trace: %+v", spans[0])
+	}
+	if spans[0].Attributes["http.status_code"] != http.StatusInternalServerError || spans[0].Error == "" {
+		t.Fatalf("Server span should record the error: %+v", spans[0])
+	}
+
+	// Unsampled traces are not exported.
+	exporter.Reset()
+	req = httptest.NewRequest(http.MethodGet, "/api/users/0", nil)
+	req.Header.Set(HeaderTraceParent, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	s.ServeHTTP(httptest.NewRecorder(), req)
+	if spans := exporter.Spans(); len(spans) != 0 {
+		t.Fatalf("Unsampled spans should not be exported: %+v", spans)
+	}
+}
@@ -0,0 +1,274 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// KeyCasing converts a key of objects, such as "userId" to "user_id".
+// Conversions should be idempotent, so keys which are already converted are not
+// changed.
+type KeyCasing func(key string) string
+
+// SnakeCase converts a camel case key to snake case, such as "userId" and
+// "UserID" to "user_id", and "HTTPServer" to "http_server".
+func SnakeCase(key string) string {
+	runes := []rune(key)
+	builder := strings.Builder{}
+	builder.Grow(len(key) + 4)
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 && runes[i-1] != '_' {
+			prev := runes[i-1]
+			switch {
+			case unicode.IsLower(prev), unicode.IsDigit(prev):
+				builder.WriteByte('_')
+			case unicode.IsUpper(prev) && i+1 < len(runes) && unicode.IsLower(runes[i+1]):
+				// The last letter of an acronym starts a new word.
+				builder.WriteByte('_')
+			}
+		}
+		builder.WriteRune(unicode.ToLower(r))
+	}
+	return builder.String()
+}
+
+// CamelCase converts a snake case key to lower camel case, such as "user_id" to
+// "userId". Leading underscores are kept, and letters in words are not changed
+// except the first letter of the key, so "user_ID" is converted to "userID".
+func CamelCase(key string) string {
+	trimmed := strings.TrimLeft(key, "_")
+	builder := strings.Builder{}
+	builder.Grow(len(key))
+	builder.WriteString(key[:len(key)-len(trimmed)])
+	first := true
+	for _, word := range strings.Split(trimmed, "_") {
+		if word == "" {
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(word)
+		if first {
+			r = unicode.ToLower(r)
+			first = false
+		} else {
+			r = unicode.ToUpper(r)
+		}
+		builder.WriteRune(r)
+		builder.WriteString(word[size:])
+	}
+	return builder.String()
+}
+
+var jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// TransformKeys converts keys of objects in data by casing. Keys of structs are
+// their JSON names, and they are converted in nested structs, slices and maps.
+// Keys of maps are data rather than names, so they are converted only if
+// mapKeys is true. Structs and maps are converted to map[string]interface{},
+// slices and arrays are converted to []interface{}, and other values such as
+// readers, []byte and values which implement json.Marshaler or
+// encoding.TextMarshaler are returned as is.
+func TransformKeys(data interface{}, casing KeyCasing, mapKeys bool) interface{} {
+	switch data.(type) {
+	case nil, io.Reader, []byte:
+		return data
+	}
+	c := &genericConverter{casing: casing, mapKeys: mapKeys}
+	return c.convert(reflect.ValueOf(data))
+}
+
+// genericConverter converts values to generic values, which are composed of
+// map[string]interface{}, []interface{} and scalars.
+type genericConverter struct {
+	// casing converts keys of objects. Nil means keeping keys.
+	casing KeyCasing
+	// mapKeys indicates whether keys of maps are converted.
+	mapKeys bool
+	// int64AsString converts int64 and uint64 values to strings.
+	int64AsString bool
+}
+
+// key converts a key of objects.
+func (c *genericConverter) key(key string, isMapKey bool) string {
+	if c.casing == nil || (isMapKey && !c.mapKeys) {
+		return key
+	}
+	return c.casing(key)
+}
+
+func (c *genericConverter) convert(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	if v.Kind() != reflect.Ptr && v.Kind() != reflect.Interface && marshalsItself(v) {
+		return scalarValue(v)
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return c.convert(v.Elem())
+	case reflect.Struct:
+		fields := structFields(v.Type())
+		object := make(map[string]interface{}, len(fields))
+		for _, field := range fields {
+			fv, ok := structFieldValue(v, field.index)
+			if !ok || (field.omitEmpty && isEmptyValue(fv)) {
+				continue
+			}
+			object[c.key(field.name, false)] = c.convert(fv)
+		}
+		return object
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		object := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			object[c.key(mapKey(iter.Key()), true)] = c.convert(iter.Value())
+		}
+		return object
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return scalarValue(v)
+		}
+		list := make([]interface{}, v.Len())
+		for i := range list {
+			list[i] = c.convert(v.Index(i))
+		}
+		return list
+	case reflect.Int64:
+		if c.int64AsString {
+			return strconv.FormatInt(v.Int(), 10)
+		}
+	case reflect.Uint64:
+		if c.int64AsString {
+			return strconv.FormatUint(v.Uint(), 10)
+		}
+	}
+	return scalarValue(v)
+}
+
+// marshalsItself checks whether v has its own JSON or text form.
+func marshalsItself(v reflect.Value) bool {
+	typ := v.Type()
+	if typ.Implements(jsonMarshalerType) || typ.Implements(textMarshalerType) {
+		return true
+	}
+	ptr := reflect.PtrTo(typ)
+	return v.CanAddr() && (ptr.Implements(jsonMarshalerType) || ptr.Implements(textMarshalerType))
+}
+
+// mapKey returns the JSON form of a map key.
+func mapKey(key reflect.Value) string {
+	if key.Kind() == reflect.String {
+		return key.String()
+	}
+	if key.CanInterface() {
+		if m, ok := key.Interface().(encoding.TextMarshaler); ok {
+			if text, err := m.MarshalText(); err == nil {
+				return string(text)
+			}
+		}
+	}
+	switch key.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return fmt.Sprint(key.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return fmt.Sprint(key.Uint())
+	}
+	return fmt.Sprint(key)
+}
+
+// scalarValue returns the value of v. Values of fields in unexported embedded
+// structs can't be used as interfaces, so basic values are copied.
+func scalarValue(v reflect.Value) interface{} {
+	if v.CanInterface() {
+		return v.Interface()
+	}
+	result := reflect.New(v.Type()).Elem()
+	switch v.Kind() {
+	case reflect.Bool:
+		result.SetBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		result.SetInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		result.SetUint(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		result.SetFloat(v.Float())
+	case reflect.String:
+		result.SetString(v.String())
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			result.SetBytes(append([]byte(nil), v.Bytes()...))
+		}
+	}
+	return result.Interface()
+}
+
+// CasingProducer is a producer which converts keys of objects before producing.
+// It works with producers of generic values, such as JSON, YAML and msgpack. For
+// example, a definition can produce snake case JSON as a vendor content type:
+//  service.RegisterProducer(service.NewCasingProducer("application/vnd.partner+json",
+//      service.ProducerFor(definition.MIMEJSON), service.SnakeCase))
+// Register it with the content type of the producer to convert keys for all
+// definitions which produce the content type. Keys of objects are sorted in
+// results.
+type CasingProducer struct {
+	contentType string
+	producer    Producer
+	casing      KeyCasing
+	// MapKeys indicates whether keys of maps are converted. Defaults to false.
+	MapKeys bool
+}
+
+// NewCasingProducer creates a producer for content type. It converts keys of
+// objects by casing, and produces them by producer. If content type is empty,
+// the content type of producer is used.
+func NewCasingProducer(contentType string, producer Producer, casing KeyCasing) *CasingProducer {
+	if contentType == "" {
+		contentType = producer.ContentType()
+	}
+	return &CasingProducer{
+		contentType: contentType,
+		producer:    producer,
+		casing:      casing,
+	}
+}
+
+// ContentType returns the content type of the producer.
+func (p *CasingProducer) ContentType() string {
+	return p.contentType
+}
+
+// Produce converts keys of v and produces it to w.
+func (p *CasingProducer) Produce(w io.Writer, v interface{}) error {
+	return p.producer.Produce(w, TransformKeys(v, p.casing, p.MapKeys))
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/caicloud/nirvana/definition"
+)
+
+func TestKeyCasings(t *testing.T) {
+	tests := []struct {
+		casing KeyCasing
+		key    string
+		want   string
+	}{
+		{SnakeCase, "userId", "user_id"},
+		{SnakeCase, "UserID", "user_id"},
+		{SnakeCase, "HTTPServer", "http_server"},
+		{SnakeCase, "address2Line", "address2_line"},
+		{SnakeCase, "user_id", "user_id"},
+		{SnakeCase, "id", "id"},
+		{CamelCase, "user_id", "userId"},
+		{CamelCase, "http_server_url", "httpServerUrl"},
+		{CamelCase, "_id", "_id"},
+		{CamelCase, "UserID", "userID"},
+		{CamelCase, "userId", "userId"},
+	}
+	for _, test := range tests {
+		got := test.casing(test.key)
+		if got != test.want {
+			t.Errorf("Key %q: got %q, want %q", test.key, got, test.want)
+		}
+		if again := test.casing(got); again != got {
+			t.Errorf("Key %q: conversion is not idempotent: %q", got, again)
+		}
+	}
+}
+
+type casingBase struct {
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type casingItem struct {
+	ItemID   string            `json:"itemId"`
+	Labels   map[string]string `json:"labels"`
+	Children []casingItem      `json:"childItems,omitempty"`
+}
+
+type casingObject struct {
+	casingBase
+	UserName string                 `json:"userName"`
+	Items    []casingItem           `json:"items"`
+	Extra    map[string]interface{} `json:"extraData"`
+	Raw      []byte                 `json:"rawBytes"`
+	Skipped  string                 `json:"-"`
+	Empty    *casingItem            `json:"emptyItem,omitempty"`
+}
+
+func TestCasingProducer(t *testing.T) {
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	data := &casingObject{
+		casingBase: casingBase{CreatedAt: created},
+		UserName:   "alice",
+		Items: []casingItem{{
+			ItemID:   "a",
+			Labels:   map[string]string{"appName": "x"},
+			Children: []casingItem{{ItemID: "b"}},
+		}},
+		Extra: map[string]interface{}{"someKey": casingItem{ItemID: "c"}},
+		Raw:   []byte("hi"),
+	}
+	tests := []struct {
+		casing  KeyCasing
+		mapKeys bool
+		data    interface{}
+		want    string
+	}{
+		{
+			SnakeCase, false, data,
+			`{"created_at":"2020-01-02T03:04:05Z",` +
+				`"extra_data":{"someKey":{"item_id":"c","labels":null}},` +
+				`"items":[{"child_items":[{"item_id":"b","labels":null}],"item_id":"a","labels":{"appName":"x"}}],` +
+				`"raw_bytes":"aGk=","user_name":"alice"}`,
+		},
+		{
+			SnakeCase, true, data,
+			`{"created_at":"2020-01-02T03:04:05Z",` +
+				`"extra_data":{"some_key":{"item_id":"c","labels":null}},` +
+				`"items":[{"child_items":[{"item_id":"b","labels":null}],"item_id":"a","labels":{"app_name":"x"}}],` +
+				`"raw_bytes":"aGk=","user_name":"alice"}`,
+		},
+		{
+			// Keys which already match are not changed.
+			SnakeCase, false, map[string]interface{}{"items": []map[string]int{{"item_id": 1}}},
+			`{"items":[{"item_id":1}]}`,
+		},
+		{
+			CamelCase, true, map[string]interface{}{"user_name": "alice", "userId": 1},
+			`{"userId":1,"userName":"alice"}`,
+		},
+		{SnakeCase, false, []casingItem{{ItemID: "a"}}, `[{"item_id":"a","labels":null}]`},
+		{SnakeCase, false, 42, `42`},
+		{SnakeCase, false, nil, `null`},
+	}
+	for i, test := range tests {
+		p := NewCasingProducer("", &JSONSerializer{}, test.casing)
+		p.MapKeys = test.mapKeys
+		if p.ContentType() != definition.MIMEJSON {
+			t.Fatalf("Unexpected content type: %s", p.ContentType())
+		}
+		buf := &bytes.Buffer{}
+		if err := p.Produce(buf, test.data); err != nil {
+			t.Fatalf("Test %d: %v", i, err)
+		}
+		if got := buf.String(); got != test.want+"\n" {
+			t.Errorf("Test %d: got %s, want %s", i, got, test.want)
+		}
+		// Producing converted data again doesn't change it.
+		again := &bytes.Buffer{}
+		if err := p.Produce(again, TransformKeys(test.data, test.casing, test.mapKeys)); err != nil {
+			t.Fatalf("Test %d: %v", i, err)
+		}
+		if again.String() != buf.String() {
+			t.Errorf("Test %d: conversion is not idempotent: %s", i, again.String())
+		}
+	}
+
+	p := NewCasingProducer("application/vnd.partner+json", &JSONSerializer{}, SnakeCase)
+	if p.ContentType() != "application/vnd.partner+json" {
+		t.Fatalf("Unexpected content type: %s", p.ContentType())
+	}
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/caicloud/nirvana/definition"
+)
+
+// WhenConsumes returns an operator which runs operator only if the content type
+// of request matches one of contentTypes, such as:
+//  definition.BodyParameterFor("", service.WhenConsumes(jsonOnly, definition.MIMEJSON))
+// Otherwise values are returned as is, so operator is skipped for other content
+// types in definitions which consume several types. Content types are compared
+// without parameters, and wildcards like "application/*" are supported.
+//
+// The content type of request is known before operators run: a definition is
+// chosen by the content type when the request is routed, and then parameters
+// are generated and operated. If there is no http context in ctx (such as in
+// tests of operators), operator always runs.
+//
+// Skipped values must have the in type of operator, so the in type and out type
+// of operator must be the same. It panics if they are different or contentTypes
+// is empty.
+func WhenConsumes(operator definition.Operator, contentTypes ...string) definition.Operator {
+	if len(contentTypes) <= 0 {
+		panic("WhenConsumes must have one content type at least")
+	}
+	if operator.In() != operator.Out() {
+		panic(fmt.Sprintf("The in type %v and out type %v of operator in WhenConsumes must be the same", operator.In(), operator.Out()))
+	}
+	o := &conditionalOperator{
+		Operator: operator,
+		ranges:   make([]AcceptRange, len(contentTypes)),
+	}
+	for i, ct := range contentTypes {
+		o.ranges[i] = AcceptRange{Name: ct, Quality: 1}
+	}
+	if adaptive, ok := operator.(definition.AdaptiveOperator); ok {
+		return &adaptiveConditionalOperator{o, adaptive}
+	}
+	return o
+}
+
+type conditionalOperator struct {
+	definition.Operator
+	ranges []AcceptRange
+}
+
+// Operate runs the operator if the content type of request matches.
+func (o *conditionalOperator) Operate(ctx context.Context, field string, object interface{}) (interface{}, error) {
+	if httpCtx := HTTPContextFrom(ctx); httpCtx != nil {
+		ct, err := ContentType(httpCtx.Request())
+		if err == nil && !o.matches(ct) {
+			return object, nil
+		}
+	}
+	return o.Operator.Operate(ctx, field, object)
+}
+
+// CheckIn checks in types by the operator if it's an InTypeChecker.
+func (o *conditionalOperator) CheckIn(typ reflect.Type) error {
+	if checker, ok := o.Operator.(definition.InTypeChecker); ok {
+		return checker.CheckIn(typ)
+	}
+	return nil
+}
+
+// matches checks whether ct matches one of content types.
+func (o *conditionalOperator) matches(ct string) bool {
+	for _, r := range o.ranges {
+		if r.Matches(ct) {
+			return true
+		}
+	}
+	return false
+}
+
+type adaptiveConditionalOperator struct {
+	*conditionalOperator
+	adaptive definition.AdaptiveOperator
+}
+
+// Accept checks whether the operator can handle values of the type.
+func (o *adaptiveConditionalOperator) Accept(typ reflect.Type) error {
+	return o.adaptive.Accept(typ)
+}
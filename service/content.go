@@ -23,10 +23,15 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
+	"net/http"
 	"reflect"
 	"strconv"
+	"strings"
 	"time"
 
+	"gopkg.in/yaml.v2"
+
 	"github.com/caicloud/nirvana/definition"
 )
 
@@ -46,23 +51,41 @@ type Producer interface {
 	Produce(w io.Writer, v interface{}) error
 }
 
+// RequestAwareProducer is a producer which writes differently for requests, such
+// as indenting JSON for requests with query "pretty".
+type RequestAwareProducer interface {
+	Producer
+	// ForRequest returns the producer for req. It must have the same content type.
+	ForRequest(req *http.Request) Producer
+}
+
 var consumers = map[string]Consumer{
 	definition.MIMENone:        &NoneSerializer{},
 	definition.MIMEText:        NewSimpleSerializer(definition.MIMEText),
 	definition.MIMEJSON:        &JSONSerializer{},
 	definition.MIMEXML:         &XMLSerializer{},
+	definition.MIMEYAML:        &YAMLSerializer{},
+	definition.MIMEMsgpack:     &MsgpackSerializer{},
 	definition.MIMEOctetStream: NewSimpleSerializer(definition.MIMEOctetStream),
 	definition.MIMEURLEncoded:  &URLEncodedConsumer{},
 	definition.MIMEFormData:    &FormDataConsumer{},
+	definition.MIMENDJSON:      &NDJSONSerializer{},
 }
 
 var producers = map[string]Producer{
-	definition.MIMENone:        &NoneSerializer{},
-	definition.MIMEText:        NewSimpleSerializer(definition.MIMEText),
-	definition.MIMEJSON:        &JSONSerializer{},
-	definition.MIMEXML:         &XMLSerializer{},
-	definition.MIMEOctetStream: NewSimpleSerializer(definition.MIMEOctetStream),
-	definition.MIMEHTML:        NewSimpleSerializer(definition.MIMEHTML),
+	definition.MIMENone:           &NoneSerializer{},
+	definition.MIMEText:           NewSimpleSerializer(definition.MIMEText),
+	definition.MIMEJSON:           &JSONSerializer{},
+	definition.MIMEXML:            &XMLSerializer{},
+	definition.MIMEYAML:           &YAMLSerializer{},
+	definition.MIMEMsgpack:        &MsgpackSerializer{},
+	definition.MIMEOctetStream:    NewSimpleSerializer(definition.MIMEOctetStream),
+	definition.MIMEHTML:           NewSimpleSerializer(definition.MIMEHTML),
+	definition.MIMEEventStream:    NewSimpleSerializer(definition.MIMEEventStream),
+	definition.MIMENDJSON:         &NDJSONSerializer{},
+	definition.MIMEProblemJSON:    &ProblemSerializer{},
+	definition.MIMECSV:            &CSVSerializer{},
+	definition.MIMEMultipartMixed: &MultipartProducer{},
 }
 
 // AllConsumers returns all consumers.
@@ -74,9 +97,17 @@ func AllConsumers() []Consumer {
 	return cs
 }
 
-// ConsumerFor gets a consumer for specified content type.
+// ConsumerFor gets a consumer for specified content type. If there is no
+// consumer for a type with structured syntax suffix, such as
+// "application/vnd.myapp.v2+json", the consumer of the suffix is used.
 func ConsumerFor(contentType string) Consumer {
-	return consumers[contentType]
+	if c, ok := consumers[contentType]; ok {
+		return c
+	}
+	if suffix := suffixType(contentType); suffix != "" && consumers[suffix] != nil {
+		return &suffixConsumer{contentType, consumers[suffix]}
+	}
+	return nil
 }
 
 // AllProducers returns all producers.
@@ -97,9 +128,17 @@ func AllProducers() []Producer {
 	return ps
 }
 
-// ProducerFor gets a producer for specified content type.
+// ProducerFor gets a producer for specified content type. If there is no
+// producer for a type with structured syntax suffix, such as
+// "application/vnd.myapp.v2+json", the producer of the suffix is used.
 func ProducerFor(contentType string) Producer {
-	return producers[contentType]
+	if p, ok := producers[contentType]; ok {
+		return p
+	}
+	if suffix := suffixType(contentType); suffix != "" && producers[suffix] != nil {
+		return &suffixProducer{contentType, producers[suffix]}
+	}
+	return nil
 }
 
 // RegisterConsumer register a consumer. A consumer must not handle "*/*".
@@ -248,9 +287,22 @@ func (s *URLEncodedConsumer) ContentType() string {
 	return definition.MIMEURLEncoded
 }
 
-// Consume reads data and converts it to string, []byte.
+// Consume reads data and converts it to string, []byte, or parses it as a
+// form and fills the struct which v points to. Keys of form are matched with
+// fields by tag "form":
+//  type User struct {
+//      Name    string   `form:"name"`
+//      Tags    []string `form:"tag"`
+//      Address struct {
+//          City string `form:"city"`
+//      } `form:"address"`
+//  }
+// The struct above is filled by form "name=a&tag=x&tag=y&address.city=b".
 func (s *URLEncodedConsumer) Consume(r io.Reader, v interface{}) error {
-	return s.ConsumeData(s.ContentType(), r, v)
+	if s.CanConsumeData(s.ContentType(), r, v) {
+		return s.ConsumeData(s.ContentType(), r, v)
+	}
+	return consumeForm(r, v)
 }
 
 // FormDataConsumer implements Consumer for content type "multipart/form-data"
@@ -267,7 +319,36 @@ func (s *FormDataConsumer) Consume(r io.Reader, v interface{}) error {
 }
 
 // JSONSerializer implements Consumer and Producer for content type "application/json".
-type JSONSerializer struct{ RawSerializer }
+// The zero value writes compact JSON with HTML escaping. Use NewJSONSerializer
+// to change how JSON is written.
+type JSONSerializer struct {
+	RawSerializer
+	options JSONOptions
+}
+
+// JSONOptions contains options of writing JSON.
+type JSONOptions struct {
+	// Indent indents objects and arrays, such as "  ". Empty means compact JSON.
+	Indent string
+	// DisableHTMLEscape stops escaping "<", ">" and "&" in strings.
+	DisableHTMLEscape bool
+	// Int64AsString writes int64 and uint64 values as strings, so that JavaScript
+	// clients don't lose precision of large numbers such as IDs. Values which
+	// implement json.Marshaler or encoding.TextMarshaler are written as is. Data
+	// is converted to generic values first, so keys of objects are sorted.
+	Int64AsString bool
+	// PrettyQuery is the name of a query, such as "pretty". Requests with the
+	// query get indented JSON, unless its value is "false" or "0". Indent is
+	// used if it's not empty, or else two spaces.
+	PrettyQuery string
+}
+
+// NewJSONSerializer creates a JSON serializer with options. Register it to
+// replace the default one:
+//  service.RegisterProducer(service.NewJSONSerializer(service.JSONOptions{Indent: "  "}))
+func NewJSONSerializer(options JSONOptions) *JSONSerializer {
+	return &JSONSerializer{options: options}
+}
 
 // ContentType returns json MIME type.
 func (s *JSONSerializer) ContentType() string {
@@ -291,10 +372,93 @@ func (s *JSONSerializer) Produce(w io.Writer, v interface{}) error {
 	if s.CanProduceData(s.ContentType(), w, v) {
 		return s.ProduceData(s.ContentType(), w, v)
 	}
-	return json.NewEncoder(w).Encode(v)
+	if s.options.Int64AsString && v != nil {
+		v = (&genericConverter{int64AsString: true}).convert(reflect.ValueOf(v))
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(!s.options.DisableHTMLEscape)
+	if s.options.Indent != "" {
+		encoder.SetIndent("", s.options.Indent)
+	}
+	return encoder.Encode(v)
+}
+
+// ForRequest returns an indented serializer for requests with PrettyQuery.
+func (s *JSONSerializer) ForRequest(req *http.Request) Producer {
+	if s.options.PrettyQuery == "" {
+		return s
+	}
+	values, ok := req.URL.Query()[s.options.PrettyQuery]
+	if !ok || (len(values) > 0 && (values[0] == "false" || values[0] == "0")) {
+		return s
+	}
+	options := s.options
+	if options.Indent == "" {
+		options.Indent = "  "
+	}
+	return &JSONSerializer{options: options}
+}
+
+// NDJSONSerializer implements Consumer and Producer for content type
+// "application/x-ndjson". Every element of a slice or an array is written as a
+// line of json, and other values are written as one line. Lines are consumed
+// as elements if the target is a slice.
+type NDJSONSerializer struct{ RawSerializer }
+
+// ContentType returns ndjson MIME type.
+func (s *NDJSONSerializer) ContentType() string {
+	return definition.MIMENDJSON
+}
+
+// Consume unmarshals lines of json from r into v. If v is a pointer to a slice,
+// every line is appended as an element. Otherwise only the first line is read.
+func (s *NDJSONSerializer) Consume(r io.Reader, v interface{}) error {
+	if s.CanConsumeData(s.ContentType(), r, v) {
+		return s.ConsumeData(s.ContentType(), r, v)
+	}
+	decoder := json.NewDecoder(r)
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Slice {
+		err := decoder.Decode(v)
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+	slice := value.Elem()
+	for {
+		elem := reflect.New(slice.Type().Elem())
+		if err := decoder.Decode(elem.Interface()); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		slice.Set(reflect.Append(slice, elem.Elem()))
+	}
+}
+
+// Produce marshals v to lines of json and write to w.
+func (s *NDJSONSerializer) Produce(w io.Writer, v interface{}) error {
+	if s.CanProduceData(s.ContentType(), w, v) {
+		return s.ProduceData(s.ContentType(), w, v)
+	}
+	encoder := json.NewEncoder(w)
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+		return encoder.Encode(v)
+	}
+	for i := 0; i < value.Len(); i++ {
+		if err := encoder.Encode(value.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // XMLSerializer implements Consumer and Producer for content type "application/xml".
+// Values are marshaled by encoding/xml, so struct fields are mapped by "xml" tags.
+// Use XMLElement to override the name of root element.
 type XMLSerializer struct{ RawSerializer }
 
 // ContentType returns xml MIME type.
@@ -322,6 +486,84 @@ func (s *XMLSerializer) Produce(w io.Writer, v interface{}) error {
 	return xml.NewEncoder(w).Encode(v)
 }
 
+// XMLElement overrides the name of root element when Value is marshaled to xml.
+// The name takes precedence over the XMLName field and the type name of Value.
+// If Value is a slice or an array (except []byte), its elements are wrapped in
+// the root element:
+//  XMLElement{Name: "items", Value: []Item{...}} -> <items><Item>...</Item>...</items>
+// For other content types, XMLElement is marshaled as Value.
+type XMLElement struct {
+	// Name is the local name of root element.
+	Name string
+	// Value is the content of root element.
+	Value interface{}
+}
+
+// MarshalXML encodes Value in the root element.
+func (e XMLElement) MarshalXML(encoder *xml.Encoder, start xml.StartElement) error {
+	start = xml.StartElement{Name: xml.Name{Local: e.Name}}
+	value := reflect.ValueOf(e.Value)
+	if (value.Kind() != reflect.Slice && value.Kind() != reflect.Array) || value.Type().Elem().Kind() == reflect.Uint8 {
+		return encoder.EncodeElement(e.Value, start)
+	}
+	if err := encoder.EncodeToken(start); err != nil {
+		return err
+	}
+	for i := 0; i < value.Len(); i++ {
+		if err := encoder.Encode(value.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+	return encoder.EncodeToken(start.End())
+}
+
+// MarshalJSON encodes Value to json.
+func (e XMLElement) MarshalJSON() ([]byte, error) {
+	return json.Marshal(e.Value)
+}
+
+// MarshalYAML returns Value for yaml.
+func (e XMLElement) MarshalYAML() (interface{}, error) {
+	return e.Value, nil
+}
+
+// YAMLSerializer implements Consumer and Producer for content type "application/yaml".
+// Struct fields are mapped by "yaml" tags. Unlike json, fields of embedded structs
+// are only promoted with `yaml:",inline"` tags.
+type YAMLSerializer struct{ RawSerializer }
+
+// ContentType returns yaml MIME type.
+func (s *YAMLSerializer) ContentType() string {
+	return definition.MIMEYAML
+}
+
+// Consume unmarshals yaml from r into v.
+func (s *YAMLSerializer) Consume(r io.Reader, v interface{}) error {
+	if s.CanConsumeData(s.ContentType(), r, v) {
+		return s.ConsumeData(s.ContentType(), r, v)
+	}
+	err := yaml.NewDecoder(r).Decode(v)
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return invalidBody.Error(s.ContentType(), err)
+	}
+	return nil
+}
+
+// Produce marshals v to yaml and write to w.
+func (s *YAMLSerializer) Produce(w io.Writer, v interface{}) error {
+	if s.CanProduceData(s.ContentType(), w, v) {
+		return s.ProduceData(s.ContentType(), w, v)
+	}
+	encoder := yaml.NewEncoder(w)
+	if err := encoder.Encode(v); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
 // Prefab creates instances for internal type. These instances are not
 // unmarshaled form http request data.
 type Prefab interface {
@@ -334,7 +576,13 @@ type Prefab interface {
 }
 
 var prefabs = map[string]Prefab{
-	"context": &ContextPrefab{},
+	"context":           &ContextPrefab{},
+	"request":           &RequestPrefab{},
+	"response":          &ResponsePrefab{},
+	"remote-addr":       &RemoteAddrPrefab{},
+	"tls-version":       &TLSVersionPrefab{},
+	"negotiated-accept": &NegotiatedAcceptPrefab{},
+	"websocket":         &WebSocketPrefab{},
 }
 
 // PrefabFor gets a prefab by name.
@@ -367,6 +615,174 @@ func (p *ContextPrefab) Make(ctx context.Context) (interface{}, error) {
 	return ctx, nil
 }
 
+// RequestPrefab returns *http.Request of current request.
+type RequestPrefab struct{}
+
+// Name returns prefab name.
+func (p *RequestPrefab) Name() string {
+	return "request"
+}
+
+// Type is type of *http.Request.
+func (p *RequestPrefab) Type() reflect.Type {
+	return reflect.TypeOf((*http.Request)(nil))
+}
+
+// Make returns request from http context.
+func (p *RequestPrefab) Make(ctx context.Context) (interface{}, error) {
+	httpCtx := HTTPContextFrom(ctx)
+	if httpCtx == nil {
+		return nil, NoContext.Error()
+	}
+	return httpCtx.Request(), nil
+}
+
+// ResponsePrefab returns http.ResponseWriter of current request.
+type ResponsePrefab struct{}
+
+// Name returns prefab name.
+func (p *ResponsePrefab) Name() string {
+	return "response"
+}
+
+// Type is type of http.ResponseWriter.
+func (p *ResponsePrefab) Type() reflect.Type {
+	return reflect.TypeOf((*http.ResponseWriter)(nil)).Elem()
+}
+
+// Make returns response writer from http context.
+func (p *ResponsePrefab) Make(ctx context.Context) (interface{}, error) {
+	httpCtx := HTTPContextFrom(ctx)
+	if httpCtx == nil {
+		return nil, NoContext.Error()
+	}
+	return httpCtx.ResponseWriter(), nil
+}
+
+// RemoteAddrPrefab returns the IP of client without port. The IP is from the
+// remote address of connection, so it's the IP of proxy if there is a proxy.
+type RemoteAddrPrefab struct{}
+
+// Name returns prefab name.
+func (p *RemoteAddrPrefab) Name() string {
+	return "remote-addr"
+}
+
+// Type is type of string.
+func (p *RemoteAddrPrefab) Type() reflect.Type {
+	return reflect.TypeOf("")
+}
+
+// Make returns the IP of client. It returns nil if the remote address is empty.
+func (p *RemoteAddrPrefab) Make(ctx context.Context) (interface{}, error) {
+	httpCtx := HTTPContextFrom(ctx)
+	if httpCtx == nil {
+		return nil, NoContext.Error()
+	}
+	addr := httpCtx.Request().RemoteAddr
+	if addr == "" {
+		return nil, nil
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host, nil
+	}
+	return addr, nil
+}
+
+// TLSVersionPrefab returns the TLS version of connection, such as tls.VersionTLS13.
+type TLSVersionPrefab struct{}
+
+// Name returns prefab name.
+func (p *TLSVersionPrefab) Name() string {
+	return "tls-version"
+}
+
+// Type is type of uint16.
+func (p *TLSVersionPrefab) Type() reflect.Type {
+	return reflect.TypeOf(uint16(0))
+}
+
+// Make returns the TLS version. It returns nil if the connection is not TLS, so
+// the parameter is absent: it's 0 unless it has a default value, and it's rejected
+// if it's required.
+func (p *TLSVersionPrefab) Make(ctx context.Context) (interface{}, error) {
+	httpCtx := HTTPContextFrom(ctx)
+	if httpCtx == nil {
+		return nil, NoContext.Error()
+	}
+	state := httpCtx.Request().TLS
+	if state == nil {
+		return nil, nil
+	}
+	return state.Version, nil
+}
+
+// NegotiatedAcceptPrefab returns the content type which is chosen for response by
+// header "Accept" of request and content types that the definition produces.
+type NegotiatedAcceptPrefab struct{}
+
+// Name returns prefab name.
+func (p *NegotiatedAcceptPrefab) Name() string {
+	return "negotiated-accept"
+}
+
+// Type is type of string.
+func (p *NegotiatedAcceptPrefab) Type() reflect.Type {
+	return reflect.TypeOf("")
+}
+
+// Make returns the negotiated content type. It returns nil if no content type is
+// acceptable.
+func (p *NegotiatedAcceptPrefab) Make(ctx context.Context) (interface{}, error) {
+	value := ctx.Value(contextKeyUnderlyingHTTPContext)
+	httpCtx, ok := value.(*HTTPCtx)
+	if !ok {
+		return nil, NoContext.Error()
+	}
+	producers := httpCtx.producers
+	if producers == nil {
+		producers = AllProducers()
+	}
+	producer, err := Negotiate(httpCtx.Request(), producers)
+	if err != nil {
+		return nil, err
+	}
+	if producer == nil {
+		return nil, nil
+	}
+	return producer.ContentType(), nil
+}
+
+// NewPrefab creates a prefab with a maker. typ is the type of instances made by maker.
+func NewPrefab(name string, typ reflect.Type, maker func(ctx context.Context) (interface{}, error)) Prefab {
+	return &prefab{
+		name:  name,
+		typ:   typ,
+		maker: maker,
+	}
+}
+
+type prefab struct {
+	name  string
+	typ   reflect.Type
+	maker func(ctx context.Context) (interface{}, error)
+}
+
+// Name returns prefab name.
+func (p *prefab) Name() string {
+	return p.name
+}
+
+// Type is instance type.
+func (p *prefab) Type() reflect.Type {
+	return p.typ
+}
+
+// Make makes an instance by maker.
+func (p *prefab) Make(ctx context.Context) (interface{}, error) {
+	return p.maker(ctx)
+}
+
 // Converter is used to convert []string to specific type. Data must have one
 // element at least or it will panic.
 type Converter func(ctx context.Context, data []string) (interface{}, error)
@@ -669,14 +1085,60 @@ func ConvertToFloat64(ctx context.Context, data []string) (interface{}, error) {
 	return target, nil
 }
 
+var timeLayouts = []string{time.RFC3339}
+
+// SetTimeLayouts sets layouts of time parameters, such as time.RFC1123 and
+// "2006-01-02". Layouts are tried in order. No layouts resets to time.RFC3339.
+func SetTimeLayouts(layouts ...string) {
+	if len(layouts) <= 0 {
+		layouts = []string{time.RFC3339}
+	}
+	timeLayouts = append([]string(nil), layouts...)
+}
+
 // ConvertToTime converts []string to time.Time. Only the first data is used.
+// Data is parsed by layouts of SetTimeLayouts (RFC3339 by default). If no layout
+// matches, numeric data is parsed as Unix seconds, such as "1577934245" and
+// "1577934245.5", and the time is in UTC.
 func ConvertToTime(ctx context.Context, data []string) (interface{}, error) {
 	origin := data[0]
-	target, err := time.Parse(time.RFC3339, origin)
+	for _, layout := range timeLayouts {
+		if target, err := time.Parse(layout, origin); err == nil {
+			return target, nil
+		}
+	}
+	if target, ok := parseUnixTime(origin); ok {
+		return target, nil
+	}
+	return nil, invalidTime.Error(origin, strings.Join(timeLayouts, ", "))
+}
+
+// parseUnixTime parses Unix seconds with an optional fraction.
+func parseUnixTime(data string) (time.Time, bool) {
+	seconds, fraction := data, ""
+	if index := strings.IndexByte(data, '.'); index >= 0 {
+		seconds, fraction = data[:index], data[index+1:]
+		if fraction == "" || len(fraction) > 9 {
+			return time.Time{}, false
+		}
+	}
+	sec, err := strconv.ParseInt(seconds, 10, 64)
 	if err != nil {
-		return nil, invalidConversion.Error(origin, "time")
+		return time.Time{}, false
 	}
-	return target, nil
+	var nsec int64
+	if fraction != "" {
+		if fraction[0] == '+' || fraction[0] == '-' {
+			return time.Time{}, false
+		}
+		if nsec, err = strconv.ParseInt(fraction+strings.Repeat("0", 9-len(fraction)), 10, 64); err != nil {
+			return time.Time{}, false
+		}
+		if strings.HasPrefix(seconds, "-") {
+			nsec = -nsec
+		}
+	}
+	return time.Unix(sec, nsec).UTC(), true
 }
 
 // ConvertToFloat64P converts []string to *float64. Only the first data is used.
@@ -19,8 +19,13 @@ package service
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"encoding/xml"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -43,9 +48,12 @@ func TestConsumer(t *testing.T) {
 		definition.MIMEText,
 		definition.MIMEJSON,
 		definition.MIMEXML,
+		definition.MIMEYAML,
+		definition.MIMEMsgpack,
 		definition.MIMEOctetStream,
 		definition.MIMEURLEncoded,
 		definition.MIMEFormData,
+		definition.MIMENDJSON,
 	}
 	targets := []reflect.Type{
 		reflect.TypeOf(""),
@@ -96,7 +104,10 @@ func TestProducer(t *testing.T) {
 		definition.MIMEText,
 		definition.MIMEJSON,
 		definition.MIMEXML,
+		definition.MIMEYAML,
+		definition.MIMEMsgpack,
 		definition.MIMEOctetStream,
+		definition.MIMECSV,
 	}
 	values := []interface{}{
 		data,
@@ -119,6 +130,542 @@ func TestProducer(t *testing.T) {
 	}
 }
 
+type msgpackBase struct {
+	ID      int64     `json:"id"`
+	Created time.Time `json:"created"`
+}
+
+type msgpackInner struct {
+	Labels map[string]string `json:"labels"`
+	Ports  []uint16          `json:"ports,omitempty"`
+	Ratio  float64           `json:"ratio"`
+}
+
+type msgpackOuter struct {
+	msgpackBase
+	Name    string                 `json:"name"`
+	Inner   *msgpackInner          `json:"inner"`
+	Items   []msgpackInner         `json:"items"`
+	Data    []byte                 `json:"data"`
+	Extra   map[string]interface{} `json:"extra"`
+	Ignored string                 `json:"-"`
+	Offset  int8
+}
+
+func TestMsgpackSerializer(t *testing.T) {
+	want := &msgpackOuter{
+		msgpackBase: msgpackBase{ID: -1 << 40, Created: time.Unix(1600000000, 123456789)},
+		Name:        strings.Repeat("nirvana", 10),
+		Inner:       &msgpackInner{Labels: map[string]string{"app": "nirvana"}, Ports: []uint16{80, 65535}, Ratio: 0.5},
+		Items:       []msgpackInner{{Labels: map[string]string{}}, {Ratio: -2}},
+		Data:        []byte{0, 1, 2},
+		Extra:       map[string]interface{}{"nested": map[string]interface{}{"ok": true}, "list": []interface{}{"a", nil}},
+		Offset:      -100,
+	}
+	s := ProducerFor(definition.MIMEMsgpack)
+	buf := bytes.NewBuffer(nil)
+	if err := s.Produce(buf, want); err != nil {
+		t.Fatal(err)
+	}
+	got := &msgpackOuter{Ignored: "kept"}
+	if err := ConsumerFor(definition.MIMEMsgpack).Consume(buf, got); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Created.Equal(want.Created) {
+		t.Fatalf("Time is %v, but want %v", got.Created, want.Created)
+	}
+	got.Created = want.Created
+	got.Ignored = ""
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Value is %+v, but want %+v", got, want)
+	}
+
+	// Fields are encoded with json names.
+	buf.Reset()
+	if err := s.Produce(buf, map[string]interface{}{"a": []int{1, 300, -40000}}); err != nil {
+		t.Fatal(err)
+	}
+	encoded := []byte{0x81, 0xa1, 'a', 0x93, 0x01, 0xcd, 0x01, 0x2c, 0xd2, 0xff, 0xff, 0x63, 0xc0}
+	if !bytes.Equal(buf.Bytes(), encoded) {
+		t.Fatalf("Encoded data is %x, but want %x", buf.Bytes(), encoded)
+	}
+	var v struct {
+		A []int8 `json:"a"`
+	}
+	if err := ConsumerFor(definition.MIMEMsgpack).Consume(bytes.NewReader(encoded), &v); err == nil {
+		t.Fatalf("Overflowed value should be rejected: %v", v)
+	}
+	for _, data := range [][]byte{{0x92, 0x01}, {0xc1}, {0x01, 0x02}} {
+		var v interface{}
+		if err := ConsumerFor(definition.MIMEMsgpack).Consume(bytes.NewReader(data), &v); err == nil {
+			t.Fatalf("Invalid data %x should be rejected: %v", data, v)
+		}
+	}
+
+	// Deeply nested data is rejected as a bad request rather than exhausting the stack.
+	nested := bytes.Repeat([]byte{0x91}, 1<<20)
+	var deep interface{}
+	err := ConsumerFor(definition.MIMEMsgpack).Consume(bytes.NewReader(append(nested, 0xc0)), &deep)
+	if e, ok := err.(Error); !ok || e.Code() != http.StatusBadRequest {
+		t.Fatalf("Deeply nested data should be rejected with 400: %v", err)
+	}
+	var shallow interface{}
+	if err := ConsumerFor(definition.MIMEMsgpack).Consume(bytes.NewReader(append(nested[:100], 0xc0)), &shallow); err != nil {
+		t.Fatalf("Nested data within the limit should be accepted: %v", err)
+	}
+}
+
+type yamlInner struct {
+	Labels map[string]string `yaml:"labels"`
+	Ports  []int             `yaml:"ports"`
+}
+
+type yamlOuter struct {
+	Name  string      `yaml:"name"`
+	Inner yamlInner   `yaml:"inner"`
+	Items []yamlInner `yaml:"items"`
+}
+
+type jsonOptionsData struct {
+	ID      int64             `json:"id"`
+	Count   int               `json:"count"`
+	Owner   uint64            `json:"owner"`
+	HTML    string            `json:"html"`
+	Created time.Time         `json:"created"`
+	Tags    map[string]int64  `json:"tags"`
+	Items   []jsonOptionsItem `json:"items"`
+}
+
+type jsonOptionsItem struct {
+	ID int64 `json:"id"`
+}
+
+func TestJSONSerializerOptions(t *testing.T) {
+	data := &jsonOptionsData{
+		ID:      9007199254740993,
+		Count:   1,
+		Owner:   18446744073709551615,
+		HTML:    "<a>&",
+		Created: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Tags:    map[string]int64{"a": -1},
+		Items:   []jsonOptionsItem{{ID: 2}},
+	}
+	tests := []struct {
+		options JSONOptions
+		want    string
+	}{
+		{
+			JSONOptions{},
+			`{"id":9007199254740993,"count":1,"owner":18446744073709551615,"html":"\u003ca\u003e\u0026",` +
+				`"created":"2020-01-02T03:04:05Z","tags":{"a":-1},"items":[{"id":2}]}` + "\n",
+		},
+		{
+			JSONOptions{DisableHTMLEscape: true},
+			`{"id":9007199254740993,"count":1,"owner":18446744073709551615,"html":"<a>&",` +
+				`"created":"2020-01-02T03:04:05Z","tags":{"a":-1},"items":[{"id":2}]}` + "\n",
+		},
+		{
+			JSONOptions{Int64AsString: true},
+			`{"count":1,"created":"2020-01-02T03:04:05Z","html":"\u003ca\u003e\u0026","id":"9007199254740993",` +
+				`"items":[{"id":"2"}],"owner":"18446744073709551615","tags":{"a":"-1"}}` + "\n",
+		},
+		{
+			JSONOptions{Indent: "\t"},
+			"{\n\t\"id\": 9007199254740993,\n\t\"count\": 1,\n\t\"owner\": 18446744073709551615,\n" +
+				"\t\"html\": \"\\u003ca\\u003e\\u0026\",\n\t\"created\": \"2020-01-02T03:04:05Z\",\n" +
+				"\t\"tags\": {\n\t\t\"a\": -1\n\t},\n\t\"items\": [\n\t\t{\n\t\t\t\"id\": 2\n\t\t}\n\t]\n}\n",
+		},
+	}
+	for i, test := range tests {
+		buf := &bytes.Buffer{}
+		if err := NewJSONSerializer(test.options).Produce(buf, data); err != nil {
+			t.Fatalf("Test %d: %v", i, err)
+		}
+		if buf.String() != test.want {
+			t.Errorf("Test %d: got %s, want %s", i, buf.String(), test.want)
+		}
+	}
+
+	// The zero value keeps the default behavior.
+	buf := &bytes.Buffer{}
+	if err := (&JSONSerializer{}).Produce(buf, data); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != tests[0].want {
+		t.Fatalf("Zero value should write compact JSON, but got: %s", buf.String())
+	}
+}
+
+func TestJSONPrettyQuery(t *testing.T) {
+	data := map[string]interface{}{"name": "nirvana", "tags": []string{"a", "b"}}
+	producers := []Producer{NewJSONSerializer(JSONOptions{PrettyQuery: "pretty"})}
+	tests := []struct {
+		url      string
+		indented bool
+	}{
+		{"/", false},
+		{"/?pretty", true},
+		{"/?pretty=true", true},
+		{"/?pretty=false", false},
+		{"/?pretty=0", false},
+	}
+	var compact interface{}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", test.url, nil)
+		recorder := httptest.NewRecorder()
+		if err := WriteData(NewHTTPContext(recorder, req), producers, http.StatusOK, data); err != nil {
+			t.Fatal(err)
+		}
+		body := recorder.Body.String()
+		if indented := strings.Contains(body, "\n  "); indented != test.indented {
+			t.Fatalf("URL %s: indentation should be %v, but got: %s", test.url, test.indented, body)
+		}
+		if recorder.Header().Get("Content-Type") != definition.MIMEJSON {
+			t.Fatalf("URL %s: unexpected content type: %s", test.url, recorder.Header().Get("Content-Type"))
+		}
+		// Indentation doesn't change values.
+		var value interface{}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &value); err != nil {
+			t.Fatal(err)
+		}
+		if compact == nil {
+			compact = value
+		} else if !reflect.DeepEqual(compact, value) {
+			t.Fatalf("URL %s: values are changed: %v", test.url, value)
+		}
+	}
+}
+
+func TestCSVSerializer(t *testing.T) {
+	type Address struct {
+		City string `csv:"city"`
+		Zip  string
+	}
+	type Base struct {
+		ID int `csv:"id"`
+	}
+	type Row struct {
+		Base
+		Name     string    `csv:"name"`
+		Score    float64   `csv:"score"`
+		Created  time.Time `csv:"created"`
+		Address  *Address  `csv:"address"`
+		Tags     []string  `csv:"tags"`
+		Secret   string    `csv:"-"`
+		internal string
+	}
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		serializer *CSVSerializer
+		value      interface{}
+		result     string
+	}{
+		{
+			&CSVSerializer{},
+			[]Row{
+				{Base{1}, "plain", 1.5, created, &Address{"Hangzhou", "310000"}, []string{"a"}, "s", "i"},
+				{Base{2}, `say "hi", bye`, 0, created, nil, nil, "", ""},
+				{Base{3}, "multi\nline", -2, time.Time{}, &Address{}, []string{}, "", ""},
+			},
+			"id,name,score,created,address.city,address.Zip,tags\n" +
+				`1,plain,1.5,2020-01-02T03:04:05Z,Hangzhou,310000,"[""a""]"` + "\n" +
+				`2,"say ""hi"", bye",0,2020-01-02T03:04:05Z,,,` + "\n" +
+				"3,\"multi\nline\",-2,0001-01-01T00:00:00Z,,,[]\n",
+		},
+		{
+			&CSVSerializer{TimeFormat: "2006-01-02"},
+			[]*Row{{Name: "ptr", Created: created}},
+			"id,name,score,created,address.city,address.Zip,tags\n0,ptr,0,2020-01-02,,,\n",
+		},
+		{&CSVSerializer{}, []Row{}, "id,name,score,created,address.city,address.Zip,tags\n"},
+		{&CSVSerializer{}, []Row(nil), "id,name,score,created,address.city,address.Zip,tags\n"},
+		{&CSVSerializer{}, &Address{"a,b", "c"}, "city,Zip\n\"a,b\",c\n"},
+		{&CSVSerializer{}, [][]string{{"a", "b"}, {"c,d", "e"}}, "a,b\n\"c,d\",e\n"},
+		{&CSVSerializer{}, "raw", "raw"},
+		{
+			&CSVSerializer{},
+			[]Address{{"=1+2", "+1"}, {"-1", "@SUM(A1)"}, {"a=b", ""}},
+			"city,Zip\n'=1+2,'+1\n'-1,'@SUM(A1)\na=b,\n",
+		},
+		{&CSVSerializer{}, []Address{{"\t=1+2", "\r=1+2"}}, "city,Zip\n'\t=1+2,\"'\r=1+2\"\n"},
+		{&CSVSerializer{DisableFormulaEscaping: true}, []Address{{"=1+2", "-1"}}, "city,Zip\n=1+2,-1\n"},
+		{&CSVSerializer{}, []Row{{Score: -2, Tags: []string{"=1"}}}, "id,name,score,created,address.city,address.Zip,tags\n0,,-2,0001-01-01T00:00:00Z,,,\"[\"\"=1\"\"]\"\n"},
+	}
+	for _, test := range tests {
+		w := bytes.NewBuffer(nil)
+		if err := test.serializer.Produce(w, test.value); err != nil {
+			t.Fatal(err)
+		}
+		if w.String() != test.result {
+			t.Fatalf("CSV of %+v is not desired:\n%s\nwant:\n%s", test.value, w.String(), test.result)
+		}
+	}
+	for _, v := range []interface{}{nil, 1, []int{1}, []time.Time{created}} {
+		if err := (&CSVSerializer{}).Produce(bytes.NewBuffer(nil), v); !invalidTypeForProducer.Derived(err) {
+			t.Fatalf("CSVSerializer should reject %#v: %v", v, err)
+		}
+	}
+}
+
+func TestNDJSONConsumer(t *testing.T) {
+	type record struct {
+		ID int `json:"id"`
+	}
+	c := ConsumerFor(definition.MIMENDJSON)
+	records := []record{}
+	if err := c.Consume(strings.NewReader("{\"id\":1}\n{\"id\":2}\n\n{\"id\":3}\n"), &records); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(records, []record{{1}, {2}, {3}}) {
+		t.Fatalf("Unexpected records: %v", records)
+	}
+	r := record{}
+	if err := c.Consume(strings.NewReader("{\"id\":1}\n{\"id\":2}\n"), &r); err != nil || r.ID != 1 {
+		t.Fatalf("Only the first line should be consumed: %v %v", r, err)
+	}
+	if err := c.Consume(strings.NewReader("{\"id\":1}\n{\"id\":\n"), &records); err == nil {
+		t.Fatal("Malformed line should be rejected")
+	}
+
+	decoder := c.(StreamConsumer).NewStreamDecoder(strings.NewReader("{\"id\":1}\n{\"id\":2}\n"))
+	for i := 1; i <= 2; i++ {
+		if err := decoder.Decode(&r); err != nil || r.ID != i {
+			t.Fatalf("Unexpected record %d: %v %v", i, r, err)
+		}
+	}
+	if err := decoder.Decode(&r); err != io.EOF {
+		t.Fatalf("Decoder should end with EOF: %v", err)
+	}
+}
+
+func TestJSONStreamDecoder(t *testing.T) {
+	c := ConsumerFor(definition.MIMEJSON).(StreamConsumer)
+	decoder := c.NewStreamDecoder(strings.NewReader(` [1, 2, 3] `))
+	values := []int{}
+	for {
+		value := 0
+		err := decoder.Decode(&value)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, value)
+	}
+	if !reflect.DeepEqual(values, []int{1, 2, 3}) {
+		t.Fatalf("Unexpected values: %v", values)
+	}
+	value := 0
+	if err := c.NewStreamDecoder(strings.NewReader("")).Decode(&value); err != io.EOF {
+		t.Fatalf("Empty body should have no value: %v", err)
+	}
+	if err := c.NewStreamDecoder(strings.NewReader(`{"id":1}`)).Decode(&value); err == nil || err == io.EOF {
+		t.Fatalf("Object should be rejected: %v", err)
+	}
+}
+
+func TestYAMLSerializer(t *testing.T) {
+	want := &yamlOuter{
+		Name: "nirvana",
+		Inner: yamlInner{
+			Labels: map[string]string{"app": "web", "tier": "frontend"},
+			Ports:  []int{80, 443},
+		},
+		Items: []yamlInner{{Labels: map[string]string{"k": "v"}, Ports: []int{8080}}},
+	}
+	w := bytes.NewBuffer(nil)
+	if err := ProducerFor(definition.MIMEYAML).Produce(w, want); err != nil {
+		t.Fatal(err)
+	}
+	g := &BodyParameterGenerator{}
+	result, err := g.Generate(
+		context.Background(),
+		&vc2{
+			contentType: definition.MIMEYAML,
+			data:        w.String(),
+		},
+		AllConsumers(),
+		"test",
+		reflect.TypeOf(&yamlOuter{}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(want, result) {
+		t.Fatalf("YAML round trip is not equal: %+v, %+v", want, result)
+	}
+
+	_, err = g.Generate(
+		context.Background(),
+		&vc2{
+			contentType: definition.MIMEYAML,
+			data:        "name: [unclosed",
+		},
+		AllConsumers(),
+		"test",
+		reflect.TypeOf(&yamlOuter{}),
+	)
+	if err == nil {
+		t.Fatal("YAMLSerializer should reject malformed body")
+	}
+	if e, ok := err.(Error); !ok || e.Code() != http.StatusBadRequest {
+		t.Fatalf("YAMLSerializer should return a bad request error: %v", err)
+	}
+}
+
+type xmlTag struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type xmlItem struct {
+	XMLName xml.Name `xml:"item"`
+	ID      int      `xml:"id,attr"`
+	Name    string   `xml:"name,omitempty"`
+	Tags    []xmlTag `xml:"tags>tag"`
+}
+
+type xmlList struct {
+	XMLName xml.Name   `xml:"list"`
+	Total   int        `xml:"total,attr"`
+	Items   []*xmlItem `xml:"item"`
+	Owner   struct {
+		Name string `xml:"name"`
+	} `xml:"owner"`
+}
+
+func TestXMLSerializer(t *testing.T) {
+	list := &xmlList{Total: 2}
+	list.Items = []*xmlItem{
+		{ID: 1, Name: "a", Tags: []xmlTag{{"k1", "v1"}, {"k2", "v2"}}},
+		{ID: 2},
+	}
+	list.Owner.Name = "nirvana"
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{
+			list,
+			`<list total="2"><item id="1"><name>a</name><tags><tag key="k1">v1</tag><tag key="k2">v2</tag></tags></item>` +
+				`<item id="2"><tags></tags></item><owner><name>nirvana</name></owner></list>`,
+		},
+		{
+			XMLElement{Name: "items", Value: []xmlTag{{"k1", "v1"}, {"k2", "v2"}}},
+			`<items><xmlTag key="k1">v1</xmlTag><xmlTag key="k2">v2</xmlTag></items>`,
+		},
+		{
+			XMLElement{Name: "entry", Value: list.Items[1]},
+			`<entry id="2"><tags></tags></entry>`,
+		},
+	}
+	producer := ProducerFor(definition.MIMEXML)
+	for _, test := range tests {
+		w := bytes.NewBuffer(nil)
+		if err := producer.Produce(w, test.value); err != nil {
+			t.Fatal(err)
+		}
+		if w.String() != test.want {
+			t.Fatalf("XMLSerializer writes wrong data: %s, want: %s", w.String(), test.want)
+		}
+	}
+
+	w := bytes.NewBuffer(nil)
+	if err := producer.Produce(w, list); err != nil {
+		t.Fatal(err)
+	}
+	result := &xmlList{}
+	if err := ConsumerFor(definition.MIMEXML).Consume(w, result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Total != 2 || len(result.Items) != 2 || result.Owner.Name != "nirvana" ||
+		!reflect.DeepEqual(result.Items[0].Tags, list.Items[0].Tags) || result.Items[1].ID != 2 {
+		t.Fatalf("XML round trip is not equal: %+v", result)
+	}
+
+	w.Reset()
+	if err := ProducerFor(definition.MIMEJSON).Produce(w, XMLElement{Name: "items", Value: []int{1, 2}}); err != nil {
+		t.Fatal(err)
+	}
+	if w.String() != "[1,2]\n" {
+		t.Fatalf("XMLElement should be marshaled to json as its value: %s", w.String())
+	}
+}
+
+type formAddress struct {
+	City string `form:"city"`
+	Zip  int    `form:"zip"`
+}
+
+type formBase struct {
+	ID int64 `form:"id"`
+}
+
+type formUser struct {
+	formBase
+	Name     string       `form:"name"`
+	Age      *int         `form:"age"`
+	Tags     []string     `form:"tag"`
+	Scores   []float32    `form:"score"`
+	Address  formAddress  `form:"address"`
+	Previous *formAddress `form:"previous"`
+	Ignored  string       `form:"-"`
+	Nickname string
+}
+
+func TestURLEncodedConsumer(t *testing.T) {
+	generate := func(data string) (interface{}, error) {
+		g := &BodyParameterGenerator{}
+		return g.Generate(
+			context.Background(),
+			&vc2{
+				contentType: definition.MIMEURLEncoded,
+				data:        data,
+			},
+			AllConsumers(),
+			"test",
+			reflect.TypeOf(&formUser{}),
+		)
+	}
+	age := 18
+	tests := []struct {
+		data string
+		want *formUser
+	}{
+		{
+			"id=1&name=nirvana&age=18&tag=a&tag=&tag=b&score=1.5&address.city=x&address.zip=100&Ignored=i&Nickname=n&unknown=u",
+			&formUser{
+				formBase: formBase{ID: 1},
+				Name:     "nirvana",
+				Age:      &age,
+				Tags:     []string{"a", "b"},
+				Scores:   []float32{1.5},
+				Address:  formAddress{City: "x", Zip: 100},
+				Nickname: "n",
+			},
+		},
+		{"", &formUser{}},
+		{"previous.city=y", &formUser{Previous: &formAddress{City: "y"}}},
+	}
+	for _, test := range tests {
+		result, err := generate(test.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(test.want, result) {
+			t.Fatalf("Form %q is not decoded as expected: %+v, %+v", test.data, test.want, result)
+		}
+	}
+
+	for _, data := range []string{"age=x", "address.zip=1.5", "score=1&score=a", "name=%zz"} {
+		_, err := generate(data)
+		if e, ok := err.(Error); !ok || e.Code() != http.StatusBadRequest {
+			t.Fatalf("Form %q should be rejected with a bad request error: %v", data, err)
+		}
+	}
+}
+
 func TestConverterFor(t *testing.T) {
 	wantTime, _ := time.Parse(time.RFC3339, "2020-08-25T05:12:18Z")
 	tests := []struct {
@@ -345,3 +892,53 @@ func TestConverterFor(t *testing.T) {
 	}
 
 }
+
+func TestConvertToTime(t *testing.T) {
+	defer SetTimeLayouts()
+	tests := []struct {
+		layouts []string
+		data    string
+		want    time.Time
+		err     bool
+	}{
+		{nil, "2020-01-02T03:04:05Z", time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), false},
+		{nil, "2020-01-02T11:04:05.5+08:00", time.Date(2020, 1, 2, 3, 4, 5, 5e8, time.UTC), false},
+		{nil, "1577934245", time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), false},
+		{nil, "1577934245.25", time.Date(2020, 1, 2, 3, 4, 5, 25e7, time.UTC), false},
+		{nil, "-0.5", time.Date(1969, 12, 31, 23, 59, 59, 5e8, time.UTC), false},
+		{nil, "2020-01-02", time.Time{}, true},
+		{nil, "yesterday", time.Time{}, true},
+		{nil, "1577934245.", time.Time{}, true},
+		{nil, "1577934245.-5", time.Time{}, true},
+		{nil, "", time.Time{}, true},
+		{[]string{"2006-01-02", time.RFC1123}, "2020-01-02", time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC), false},
+		{[]string{"2006-01-02", time.RFC1123}, "Thu, 02 Jan 2020 03:04:05 UTC", time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), false},
+		// RFC3339 is not accepted if it's not in layouts.
+		{[]string{"2006-01-02"}, "2020-01-02T03:04:05Z", time.Time{}, true},
+		// Numbers are tried after layouts.
+		{[]string{"20060102"}, "20200102", time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC), false},
+	}
+	for _, test := range tests {
+		SetTimeLayouts(test.layouts...)
+		result, err := ConverterFor(reflect.TypeOf(time.Time{}))(context.Background(), []string{test.data})
+		if test.err {
+			if err == nil || !invalidTime.Derived(err) {
+				t.Errorf("Data %q: should be invalid, but got: %v %v", test.data, result, err)
+			} else if !strings.Contains(err.Error(), test.data) {
+				t.Errorf("Data %q: error should contain the data: %v", test.data, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Data %q: %v", test.data, err)
+			continue
+		}
+		if got := result.(time.Time); !got.Equal(test.want) {
+			t.Errorf("Data %q: got %v, want %v", test.data, got, test.want)
+		}
+		p, err := ConverterFor(reflect.TypeOf(new(time.Time)))(context.Background(), []string{test.data})
+		if err != nil || !p.(*time.Time).Equal(test.want) {
+			t.Errorf("Data %q: got %v %v for pointer", test.data, p, err)
+		}
+	}
+}
@@ -18,13 +18,18 @@ package service
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"io"
+	"io/ioutil"
 	"mime/multipart"
 	"net"
 	"net/http"
 	"net/textproto"
 	"net/url"
+	"reflect"
+	"strings"
+	"sync"
 )
 
 var (
@@ -40,6 +45,20 @@ type HTTPCtx struct {
 	container container
 	response  response
 	path      string
+	// producers are producers of the definition which handles request.
+	producers []Producer
+	// autoValues are values set by SetAutoValue. They're keyed by types.
+	autoValues     map[reflect.Type]interface{}
+	autoValuesLock sync.RWMutex
+	// stream is the state of decoding request body into a channel parameter.
+	stream *bodyStream
+	// bound contains values of parameters which are bound. They're keyed by
+	// names of parameters.
+	bound     map[string]interface{}
+	boundLock sync.RWMutex
+	// secured indicates whether the request is authenticated for security
+	// requirements.
+	secured bool
 }
 
 // NewHTTPContext generates the http context from ResponseWriter and Request.
@@ -93,6 +112,9 @@ type container struct {
 	request *http.Request
 	params  []param
 	query   url.Values
+	// raw is the buffered request body. It's read by raw body parameters.
+	raw     []byte
+	rawRead bool
 }
 
 // Set sets path parameter key-value pairs.
@@ -124,12 +146,47 @@ func (c *container) Query(key string) ([]string, bool) {
 	return c.removeEmpties(c.query[key])
 }
 
+// QueryPrefix returns values of query parameters whose names start with prefix,
+// keyed by the rest of their names.
+func (c *container) QueryPrefix(prefix string) map[string][]string {
+	if c.query == nil {
+		c.query = c.request.URL.Query()
+	}
+	result := map[string][]string{}
+	for key, values := range c.query {
+		if len(key) <= len(prefix) || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if values, ok := c.removeEmpties(values); ok {
+			result[key[len(prefix):]] = values
+		}
+	}
+	return result
+}
+
 // Header returns value by header key.
 func (c *container) Header(key string) ([]string, bool) {
 	h := c.request.Header[textproto.CanonicalMIMEHeaderKey(key)]
 	return c.removeEmpties(h)
 }
 
+// Cookie returns values of cookies with the name. Values are URL-decoded if
+// possible, and cookies with the same name are in the order of request.
+func (c *container) Cookie(name string) ([]string, bool) {
+	values := []string{}
+	for _, cookie := range c.request.Cookies() {
+		if cookie.Name != name {
+			continue
+		}
+		value, err := url.PathUnescape(cookie.Value)
+		if err != nil {
+			value = cookie.Value
+		}
+		values = append(values, value)
+	}
+	return c.removeEmpties(values)
+}
+
 // Form returns value from request. It is valid when
 // http "Content-Type" is "application/x-www-form-urlencoded"
 // or "multipart/form-data".
@@ -157,6 +214,25 @@ func (c *container) File(key string) (multipart.File, bool) {
 	return file, err == nil
 }
 
+// FileHeaders returns headers of all files of a form field when "Content-Type"
+// is "multipart/form-data". A malformed form is rejected with a bad request error.
+func (c *container) FileHeaders(key string) ([]*multipart.FileHeader, error) {
+	if c.request.MultipartForm == nil {
+		// Parse the form with 32MB memory like FormFile does.
+		err := c.request.ParseMultipartForm(32 << 20)
+		if err == http.ErrNotMultipart {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, invalidMultipartForm.Error(err)
+		}
+	}
+	if c.request.MultipartForm == nil {
+		return nil, nil
+	}
+	return c.request.MultipartForm.File[key], nil
+}
+
 // Body returns a reader to read data from request body.
 // The reader only can read once.
 func (c *container) Body() (reader io.ReadCloser, contentType string, ok bool) {
@@ -164,6 +240,24 @@ func (c *container) Body() (reader io.ReadCloser, contentType string, ok bool) {
 	return c.request.Body, contentType, err == nil
 }
 
+// rawBody reads and buffers the request body. The request body is replaced
+// by a reader of the buffer, so it can be read by later parameters.
+func (c *container) rawBody() ([]byte, error) {
+	if c.rawRead || c.request.Body == nil {
+		return c.raw, nil
+	}
+	data, err := ioutil.ReadAll(c.request.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.request.Body.Close(); err != nil {
+		return nil, err
+	}
+	c.raw, c.rawRead = data, true
+	c.request.Body = ioutil.NopCloser(bytes.NewReader(data))
+	return data, nil
+}
+
 // ResponseWriter extends http.ResponseWriter.
 type ResponseWriter interface {
 	http.ResponseWriter
@@ -217,7 +311,18 @@ func (c *response) WriteHeader(code int) {
 
 // Flush is a disguise of http.response.Flush().
 func (c *response) Flush() {
-	c.writer.(http.Flusher).Flush()
+	if f, ok := c.writer.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Push is a disguise of http.Pusher.Push(). It returns http.ErrNotSupported if
+// the underlying http.ResponseWriter doesn't support server push.
+func (c *response) Push(target string, opts *http.PushOptions) error {
+	if p, ok := c.writer.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
 }
 
 // CloseNotify is a disguise of http.response.CloseNotify().
@@ -266,6 +371,33 @@ func (c *response) SetWrapRespBodyPolicy(v bool) {
 	c.ifWrapRespBody = v
 }
 
+// WrapResponseWriter replaces the underlying http.ResponseWriter of the http context
+// in ctx with the result of wrap. It's used by middlewares which transform response
+// bodies (such as compression). It returns false if there is no http context or the
+// header of response has been written.
+func WrapResponseWriter(ctx context.Context, wrap func(w http.ResponseWriter) http.ResponseWriter) bool {
+	value := ctx.Value(contextKeyUnderlyingHTTPContext)
+	c, ok := value.(*HTTPCtx)
+	if !ok || !c.response.HeaderWritable() {
+		return false
+	}
+	c.response.writer = wrap(c.response.writer)
+	return true
+}
+
+// SetProducers sets producers of the definition which handles the request in ctx.
+// They're used to negotiate content types for response, such as by the prefab
+// "negotiated-accept". It returns false if there is no http context.
+func SetProducers(ctx context.Context, producers []Producer) bool {
+	value := ctx.Value(contextKeyUnderlyingHTTPContext)
+	c, ok := value.(*HTTPCtx)
+	if !ok {
+		return false
+	}
+	c.producers = producers
+	return true
+}
+
 // HTTPContext describes an http context.
 type HTTPContext interface {
 	Request() *http.Request
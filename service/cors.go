@@ -0,0 +1,210 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSBuilder is a service builder which handles cross-origin requests.
+type CORSBuilder interface {
+	Builder
+	// SetCORS sets options of cross-origin resource sharing. Nil disables it.
+	SetCORS(options *CORSOptions)
+}
+
+// CORSOptions contains options of cross-origin resource sharing.
+//
+// A preflight request (an OPTIONS request with headers "Origin" and
+// "Access-Control-Request-Method") for a registered path is answered by the
+// service without executing definitions. If the origin, method or headers are
+// not allowed, the response omits "Access-Control-Allow-*" headers, and browsers
+// reject the actual request. A preflight request for an unregistered path still
+// gets a not found error.
+type CORSOptions struct {
+	// AllowedOrigins contains origins which can access APIs, such as
+	// "https://example.com". "*" allows all origins. Empty means all origins.
+	AllowedOrigins []string
+	// AllowedMethods contains methods which can be used by cross-origin requests.
+	// Empty means GET, HEAD, POST, PUT, PATCH and DELETE.
+	AllowedMethods []string
+	// AllowedHeaders contains request headers which can be used by cross-origin
+	// requests. "*" allows all headers. Empty means all headers requested by
+	// preflight requests are allowed.
+	AllowedHeaders []string
+	// ExposedHeaders contains response headers which can be read by browsers.
+	ExposedHeaders []string
+	// AllowCredentials indicates whether cross-origin requests can include
+	// credentials, such as cookies. It requires explicit AllowedOrigins.
+	AllowCredentials bool
+	// MaxAge indicates how long the results of preflight requests can be cached.
+	// Zero means browsers decide by themselves.
+	MaxAge time.Duration
+}
+
+var defaultCORSMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+}
+
+// Validate checks whether options are consistent. Browsers reject credentials
+// of requests which may come from any origin, so AllowCredentials requires
+// explicit AllowedOrigins. Builders validate options when services are built.
+func (o *CORSOptions) Validate() error {
+	if o.AllowCredentials && o.allowAll(o.AllowedOrigins) {
+		return invalidCORSOptions.Error("credentials can't be allowed for all origins, AllowedOrigins must be explicit")
+	}
+	return nil
+}
+
+// IsPreflight checks whether the request is a preflight request.
+func (o *CORSOptions) IsPreflight(req *http.Request) bool {
+	return req.Method == http.MethodOptions &&
+		req.Header.Get("Origin") != "" &&
+		req.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// HandlePreflight writes response for a preflight request.
+func (o *CORSOptions) HandlePreflight(resp http.ResponseWriter, req *http.Request) {
+	headers := resp.Header()
+	headers.Add("Vary", "Origin")
+	headers.Add("Vary", "Access-Control-Request-Method")
+	headers.Add("Vary", "Access-Control-Request-Headers")
+	origin := req.Header.Get("Origin")
+	method := req.Header.Get("Access-Control-Request-Method")
+	requested := splitHeaderList(req.Header.Get("Access-Control-Request-Headers"))
+	if o.allowOrigin(origin) && o.allowMethod(method) && o.allowHeaders(requested) {
+		o.setOrigin(headers, origin)
+		methods := o.AllowedMethods
+		if len(methods) <= 0 {
+			methods = defaultCORSMethods
+		}
+		headers.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		if len(requested) > 0 {
+			headers.Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+		}
+		if o.MaxAge > 0 {
+			headers.Set("Access-Control-Max-Age", strconv.FormatInt(int64(o.MaxAge/time.Second), 10))
+		}
+	}
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+// SetHeaders sets headers for an actual cross-origin request. It does nothing
+// if the request has no origin or the origin is not allowed.
+func (o *CORSOptions) SetHeaders(headers http.Header, req *http.Request) {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return
+	}
+	headers.Add("Vary", "Origin")
+	if !o.allowOrigin(origin) {
+		return
+	}
+	o.setOrigin(headers, origin)
+	if len(o.ExposedHeaders) > 0 {
+		headers.Set("Access-Control-Expose-Headers", strings.Join(o.ExposedHeaders, ", "))
+	}
+}
+
+func (o *CORSOptions) setOrigin(headers http.Header, origin string) {
+	if o.AllowCredentials {
+		// Browsers reject "*" for requests with credentials.
+		headers.Set("Access-Control-Allow-Origin", origin)
+		headers.Set("Access-Control-Allow-Credentials", "true")
+		return
+	}
+	if o.allowAll(o.AllowedOrigins) {
+		headers.Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	headers.Set("Access-Control-Allow-Origin", origin)
+}
+
+func (o *CORSOptions) allowAll(values []string) bool {
+	if len(values) <= 0 {
+		return true
+	}
+	for _, v := range values {
+		if v == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *CORSOptions) allowOrigin(origin string) bool {
+	if o.allowAll(o.AllowedOrigins) {
+		return true
+	}
+	for _, v := range o.AllowedOrigins {
+		if strings.EqualFold(v, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *CORSOptions) allowMethod(method string) bool {
+	methods := o.AllowedMethods
+	if len(methods) <= 0 {
+		methods = defaultCORSMethods
+	}
+	for _, m := range methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *CORSOptions) allowHeaders(requested []string) bool {
+	if o.allowAll(o.AllowedHeaders) {
+		return true
+	}
+	for _, h := range requested {
+		allowed := false
+		for _, v := range o.AllowedHeaders {
+			if strings.EqualFold(v, h) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
+// splitHeaderList splits a comma-separated header value.
+func splitHeaderList(value string) []string {
+	var result []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
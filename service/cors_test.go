@@ -0,0 +1,80 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORSOptionsDefaults(t *testing.T) {
+	options := &CORSOptions{}
+	req := httptest.NewRequest(http.MethodOptions, "/", nil)
+	if options.IsPreflight(req) {
+		t.Fatal("Request without origin should not be a preflight request")
+	}
+	req.Header.Set("Origin", "https://example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodDelete)
+	req.Header.Set("Access-Control-Request-Headers", "X-Custom")
+	if !options.IsPreflight(req) {
+		t.Fatal("Request should be a preflight request")
+	}
+	resp := httptest.NewRecorder()
+	options.HandlePreflight(resp, req)
+	if resp.Code != http.StatusNoContent {
+		t.Fatalf("Preflight response code should be 204, but got: %d", resp.Code)
+	}
+	headers := resp.Header()
+	if headers.Get("Access-Control-Allow-Origin") != "*" ||
+		headers.Get("Access-Control-Allow-Methods") != "GET, HEAD, POST, PUT, PATCH, DELETE" ||
+		headers.Get("Access-Control-Allow-Headers") != "X-Custom" ||
+		headers.Get("Access-Control-Max-Age") != "" {
+		t.Fatalf("Preflight headers are not desired: %v", headers)
+	}
+
+	req.Header.Set("Access-Control-Request-Method", "PURGE")
+	resp = httptest.NewRecorder()
+	options.HandlePreflight(resp, req)
+	if resp.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("Preflight of disallowed method should omit allow headers: %v", resp.Header())
+	}
+
+	options.AllowedHeaders = []string{"Content-Type"}
+	req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+	resp = httptest.NewRecorder()
+	options.HandlePreflight(resp, req)
+	if resp.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("Preflight of disallowed headers should omit allow headers: %v", resp.Header())
+	}
+}
+
+func TestCORSOptionsValidate(t *testing.T) {
+	for _, origins := range [][]string{nil, {"*"}, {"https://example.com", "*"}} {
+		options := &CORSOptions{AllowedOrigins: origins, AllowCredentials: true}
+		if err := options.Validate(); err == nil {
+			t.Fatalf("Credentials should not be allowed for origins %v", origins)
+		}
+	}
+	options := &CORSOptions{AllowedOrigins: []string{"https://example.com"}, AllowCredentials: true}
+	if err := options.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&CORSOptions{}).Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,263 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"encoding"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caicloud/nirvana/definition"
+)
+
+// CSVSerializer implements Producer for content type "text/csv". A slice or an
+// array of structs (or pointers to structs) is written as a header row and a row
+// per element, and a single struct is written as a header row and a row. [][]string
+// is written as rows directly.
+//
+// Columns are exported fields named by tag "csv" or field names, and tag "-" skips
+// a field. Fields of nested structs are flattened into columns named like
+// "address.city", and fields of embedded structs are promoted. time.Time is
+// formatted by TimeFormat, other types implementing encoding.TextMarshaler are
+// written as texts, and slices and maps are written as json. Nil pointers, slices
+// and maps are written as empty cells. The header row is written even if there is
+// no element.
+//
+// Spreadsheets run cells starting with "=", "+", "-", "@", tab or carriage return
+// as formulas, so such strings and texts are prefixed with a single quote, which
+// makes them plain texts. Numbers, times and [][]string are written as is.
+type CSVSerializer struct {
+	RawSerializer
+	// TimeFormat is the layout of times. Default to time.RFC3339.
+	TimeFormat string
+	// DisableFormulaEscaping writes strings starting with formula characters as
+	// is. Only disable it if the csv is not opened by spreadsheets.
+	DisableFormulaEscaping bool
+}
+
+// ContentType returns csv MIME type.
+func (s *CSVSerializer) ContentType() string {
+	return definition.MIMECSV
+}
+
+// Produce writes v to w as csv.
+func (s *CSVSerializer) Produce(w io.Writer, v interface{}) error {
+	if s.CanProduceData(s.ContentType(), w, v) {
+		return s.ProduceData(s.ContentType(), w, v)
+	}
+	cw := csv.NewWriter(w)
+	if records, ok := v.([][]string); ok {
+		return cw.WriteAll(records)
+	}
+	value := reflect.ValueOf(v)
+	if !value.IsValid() {
+		return invalidTypeForProducer.Error(s.ContentType(), nil)
+	}
+	for value.Kind() == reflect.Ptr && !value.IsNil() {
+		value = value.Elem()
+	}
+	var elements []reflect.Value
+	typ := value.Type()
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		typ = typ.Elem()
+		for i := 0; i < value.Len(); i++ {
+			elements = append(elements, value.Index(i))
+		}
+	case reflect.Struct:
+		elements = append(elements, value)
+	}
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct || csvLeaf(typ) {
+		return invalidTypeForProducer.Error(s.ContentType(), reflect.TypeOf(v))
+	}
+
+	columns := csvColumns(typ)
+	record := make([]string, len(columns))
+	for i, column := range columns {
+		record[i] = column.name
+	}
+	if err := cw.Write(record); err != nil {
+		return err
+	}
+	for _, element := range elements {
+		for i, column := range columns {
+			cell, err := s.format(csvField(element, column.index))
+			if err != nil {
+				return err
+			}
+			record[i] = cell
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// format formats a value of a column.
+func (s *CSVSerializer) format(value reflect.Value) (string, error) {
+	for value.IsValid() && (value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface) {
+		if value.IsNil() {
+			return "", nil
+		}
+		value = value.Elem()
+	}
+	if !value.IsValid() {
+		return "", nil
+	}
+	if t, ok := value.Interface().(time.Time); ok {
+		layout := s.TimeFormat
+		if layout == "" {
+			layout = time.RFC3339
+		}
+		return t.Format(layout), nil
+	}
+	if m, ok := value.Interface().(encoding.TextMarshaler); ok {
+		text, err := m.MarshalText()
+		return s.escape(string(text)), err
+	}
+	if value.CanAddr() {
+		if m, ok := value.Addr().Interface().(encoding.TextMarshaler); ok {
+			text, err := m.MarshalText()
+			return s.escape(string(text)), err
+		}
+	}
+	switch value.Kind() {
+	case reflect.String:
+		return s.escape(value.String()), nil
+	case reflect.Bool:
+		return strconv.FormatBool(value.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(value.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(value.Uint(), 10), nil
+	case reflect.Float32:
+		return strconv.FormatFloat(value.Float(), 'f', -1, 32), nil
+	case reflect.Float64:
+		return strconv.FormatFloat(value.Float(), 'f', -1, 64), nil
+	case reflect.Slice, reflect.Map:
+		if value.IsNil() {
+			return "", nil
+		}
+		data, err := json.Marshal(value.Interface())
+		return string(data), err
+	case reflect.Array, reflect.Struct:
+		data, err := json.Marshal(value.Interface())
+		return string(data), err
+	}
+	return s.escape(fmt.Sprint(value.Interface())), nil
+}
+
+// escape prefixes a cell with a single quote if it starts with a formula
+// character.
+func (s *CSVSerializer) escape(cell string) string {
+	if s.DisableFormulaEscaping || cell == "" || !strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+		return cell
+	}
+	return "'" + cell
+}
+
+// csvColumn is a column of struct fields.
+type csvColumn struct {
+	name  string
+	index []int
+}
+
+var csvColumnCache sync.Map
+
+// csvColumns returns columns of struct typ.
+func csvColumns(typ reflect.Type) []csvColumn {
+	if columns, ok := csvColumnCache.Load(typ); ok {
+		return columns.([]csvColumn)
+	}
+	var columns []csvColumn
+	var walk func(typ reflect.Type, index []int, prefix string, visited map[reflect.Type]bool)
+	walk = func(typ reflect.Type, index []int, prefix string, visited map[reflect.Type]bool) {
+		visited[typ] = true
+		defer delete(visited, typ)
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			tag := field.Tag.Get("csv")
+			if tag == "-" || (field.PkgPath != "" && !field.Anonymous) {
+				continue
+			}
+			name := strings.Split(tag, ",")[0]
+			fieldIndex := append(append([]int{}, index...), i)
+			fieldType := field.Type
+			if fieldType.Kind() == reflect.Ptr {
+				fieldType = fieldType.Elem()
+			}
+			if fieldType.Kind() == reflect.Struct && !csvLeaf(fieldType) {
+				if visited[fieldType] {
+					// Recursive structs can't be flattened.
+					continue
+				}
+				switch {
+				case field.Anonymous && name == "":
+					walk(fieldType, fieldIndex, prefix, visited)
+				case field.PkgPath == "":
+					if name == "" {
+						name = field.Name
+					}
+					walk(fieldType, fieldIndex, prefix+name+".", visited)
+				}
+				continue
+			}
+			if field.PkgPath != "" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			columns = append(columns, csvColumn{name: prefix + name, index: fieldIndex})
+		}
+	}
+	walk(typ, nil, "", map[reflect.Type]bool{})
+	csvColumnCache.Store(typ, columns)
+	return columns
+}
+
+// csvLeaf checks whether struct typ is written as a cell rather than flattened.
+func csvLeaf(typ reflect.Type) bool {
+	return typ == reflect.TypeOf(time.Time{}) || typ.Implements(textMarshalerType) || reflect.PtrTo(typ).Implements(textMarshalerType)
+}
+
+// csvField returns the field of value by index. It returns an invalid value if
+// a pointer on the way is nil.
+func csvField(value reflect.Value, index []int) reflect.Value {
+	for _, i := range index {
+		for value.Kind() == reflect.Ptr {
+			if value.IsNil() {
+				return reflect.Value{}
+			}
+			value = value.Elem()
+		}
+		value = value.Field(i)
+	}
+	return value
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// StrongETag creates a strong entity tag from value, such as "v1". A handler
+// can return it by a Meta result, then WriteData responds 304 for matched
+// "If-None-Match" headers:
+//  return map[string]string{"ETag": service.StrongETag(version)}, data, nil
+func StrongETag(value string) string {
+	return `"` + value + `"`
+}
+
+// WeakETag creates a weak entity tag from value, such as W/"v1".
+func WeakETag(value string) string {
+	return `W/"` + value + `"`
+}
+
+// CheckIfMatch checks "If-Match" header of the request in ctx against etag, which
+// is the current entity tag of the resource. It returns a PreconditionFailed error
+// (412) if the header exists and doesn't match. Matching of "If-Match" uses strong
+// comparison, so a weak entity tag never matches. "*" matches any non-empty etag.
+func CheckIfMatch(ctx context.Context, etag string) error {
+	header := HTTPContextFrom(ctx).Request().Header.Get("If-Match")
+	if header == "" {
+		return nil
+	}
+	for _, tag := range etagList(header) {
+		if (tag == "*" && etag != "") || (tag == etag && !strings.HasPrefix(tag, "W/")) {
+			return nil
+		}
+	}
+	return PreconditionFailed.Error("If-Match")
+}
+
+// notModified checks whether the response of a GET or HEAD request can be replaced
+// by a 304 response.
+func notModified(req *http.Request, headers http.Header) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	etag := headers.Get("ETag")
+	header := req.Header.Get("If-None-Match")
+	if etag == "" || header == "" {
+		return false
+	}
+	for _, tag := range etagList(header) {
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// etagList splits entity tags in a header value. Parsing stops at the first
+// invalid entity tag.
+func etagList(value string) []string {
+	var tags []string
+	for value = strings.TrimLeft(value, " \t,"); value != ""; value = strings.TrimLeft(value, " \t,") {
+		if value[0] == '*' {
+			tags = append(tags, "*")
+			value = value[1:]
+			continue
+		}
+		start := 0
+		if strings.HasPrefix(value, "W/") {
+			start = 2
+		}
+		if len(value) <= start || value[start] != '"' {
+			break
+		}
+		end := strings.IndexByte(value[start+1:], '"')
+		if end < 0 {
+			break
+		}
+		end += start + 2
+		tags = append(tags, value[:end])
+		value = value[end:]
+	}
+	return tags
+}
@@ -0,0 +1,53 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"io"
+)
+
+// limitedBody limits the size of request body. It returns an error once the body
+// exceeds the limit, so consumers stop decoding oversized bodies.
+type limitedBody struct {
+	io.ReadCloser
+	limit     int64
+	remaining int64
+	exceeded  bool
+}
+
+// Read reads at most remaining bytes from the body.
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.exceeded {
+		return 0, bodyTooLarge.Error(b.limit)
+	}
+	if len(p) <= 0 {
+		return 0, nil
+	}
+	// Read one more byte to find out whether the body exceeds the limit.
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) > b.remaining {
+		n = int(b.remaining)
+		b.remaining = 0
+		b.exceeded = true
+		return n, bodyTooLarge.Error(b.limit)
+	}
+	b.remaining -= int64(n)
+	return n, err
+}
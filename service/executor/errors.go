@@ -33,12 +33,18 @@ var (
 	DefinitionNoFunction = errors.InternalServerError.Build("Nirvana:Service:DefinitionNoFunction", "no function in [${method}]${path}")
 	// DefinitionInvalidFunctionType represents invalid function type.
 	DefinitionInvalidFunctionType = errors.InternalServerError.Build("Nirvana:Service:DefinitionInvalidFunctionType", "${type} is not function in [${method}]${path}")
+	// DefinitionInvalidSuccessCode represents invalid success code.
+	DefinitionInvalidSuccessCode = errors.InternalServerError.Build("Nirvana:Service:DefinitionInvalidSuccessCode", "${code} is not a valid success code in [${method}]${path}")
 	// DefinitionNoConsumer represents no consumer error.
 	DefinitionNoConsumer = errors.InternalServerError.Build("Nirvana:Service:DefinitionNoConsumer", "no consumer for content type ${type} in [${method}]${path}")
 	// DefinitionNoProducer represents no producer error.
 	DefinitionNoProducer = errors.InternalServerError.Build("Nirvana:Service:DefinitionNoProducer", "no producer for content type ${type} in [${method}]${path}")
 	// DefinitionConflict represents conflict error.
 	DefinitionConflict = errors.InternalServerError.Build("Nirvana:Service:DefinitionConflict", "consumer-producer pair ${key}:${value} conflicts in [http.${method}]${path}")
+	// DefinitionInvalidWebSocket represents invalid websocket definition error.
+	DefinitionInvalidWebSocket = errors.InternalServerError.Build("Nirvana:Service:DefinitionInvalidWebSocket", "invalid websocket handler in [${method}]${path}: ${reason}")
+	// DefinitionNoSecurityScheme represents no security scheme error.
+	DefinitionNoSecurityScheme = errors.InternalServerError.Build("Nirvana:Service:DefinitionNoSecurityScheme", "no security scheme ${scheme} in [${method}]${path}")
 	// DefinitionUnmatchedParameters represents parameters unmatch.
 	DefinitionUnmatchedParameters = errors.InternalServerError.Build(
 		"Nirvana:Service:DefinitionUnmatchedParameters",
@@ -49,6 +55,11 @@ var (
 		"Nirvana:Service:DefinitionUnmatchedResults",
 		"function ${function} has ${count} results but want ${desired} in ${path}, you can define it with descriptor->definition[]->results[]",
 	)
+	// DefinitionAmbiguousDataResults represents ambiguous data results error.
+	DefinitionAmbiguousDataResults = errors.InternalServerError.Build(
+		"Nirvana:Service:DefinitionAmbiguousDataResults",
+		"function ${function} has ${count} data results in ${path}, every data result must have an unique field: ${reason}",
+	)
 	// NoDestinationHandler represents no DestinationHandler error.
 	NoDestinationHandler = errors.InternalServerError.Build(
 		"Nirvana:Service:NoDestinationHandler",
@@ -60,12 +71,19 @@ var (
 	InvalidResult = errors.InternalServerError.Build("Nirvana:Service:InvalidResult", "can't validate ${order} result of function ${name}: ${err}")
 	// InvalidOperatorsForParameter represents invalid operators error.
 	InvalidOperatorsForParameter = errors.InternalServerError.Build("Nirvana:Service:InvalidOperatorsForParameter", "can't validate operators for ${order} parameter of function ${name}: ${err}")
+	// InvalidResponseOperators represents invalid response operators error.
+	InvalidResponseOperators = errors.InternalServerError.Build("Nirvana:Service:InvalidResponseOperators", "can't validate response operators of function ${name}: ${err}")
 	// InvalidOperatorsForResult represents invalid operators error.
 	InvalidOperatorsForResult = errors.InternalServerError.Build("Nirvana:Service:InvalidOperatorsForResult", "can't validate operators for ${order} result of function ${name}: ${err}")
 )
 
 var (
+	bodyTooLarge           = errors.RequestEntityTooLarge.Build("Nirvana:Service:BodyTooLarge", "request body is larger than ${size} bytes")
+	timeout                = errors.GatewayTimeout.Build("Nirvana:Service:Timeout", "request timed out after ${timeout}")
 	requiredField          = errors.InternalServerError.Build("Nirvana:Service:RequiredField", "required field ${field} in ${source} but got empty")
+	invalidElement         = errors.BadRequest.Build("Nirvana:Service:InvalidElement", "element '${element}' of ${field} is invalid: ${err}")
+	unassignableValue      = errors.InternalServerError.Build("Nirvana:Service:unassignableValue", "the value type ${type} for ${field} can't be assigned or converted to ${target}")
+	invalidConverterResult = errors.InternalServerError.Build("Nirvana:Service:invalidConverterResult", "the result type ${type} of converter for ${field} is not assignable to ${target}")
 	invalidOperatorInType  = errors.InternalServerError.Build("Nirvana:Service:invalidOperatorInType", "the type ${type} is not compatible to the in type of the ${index} operator")
 	invalidOperatorOutType = errors.InternalServerError.Build("Nirvana:Service:invalidOperatorOutType", "the out type of the ${index} operator is not compatible to the type ${type}")
 )
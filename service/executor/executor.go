@@ -25,6 +25,9 @@ import (
 	"reflect"
 	"runtime"
 	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/caicloud/nirvana/definition"
 	"github.com/caicloud/nirvana/service"
@@ -39,8 +42,18 @@ type Executor interface {
 	Producible([]string) bool
 }
 
-// DefinitionToExecutor generates a Executor for the Definition.
-func DefinitionToExecutor(urlPath string, d definition.Definition, customCode int) (Executor, error) {
+// NegotiatingExecutor is an optional interface of executors which negotiate
+// producers by the whole request, such as q-values of "Accept". Inspectors prefer
+// it to Executor.Producible.
+type NegotiatingExecutor interface {
+	// ProducibleFor checks whether the executor can produce responses and errors
+	// for req.
+	ProducibleFor(req *http.Request) bool
+}
+
+// DefinitionToExecutor generates a Executor for the Definition. Interceptors
+// run around the function of the definition.
+func DefinitionToExecutor(urlPath string, d definition.Definition, customCode int, interceptors ...service.Interceptor) (Executor, error) {
 	var method string
 	if d.Method == definition.Any {
 		method = string(definition.Any)
@@ -66,13 +79,40 @@ func DefinitionToExecutor(urlPath string, d definition.Definition, customCode in
 	if value.Kind() != reflect.Func {
 		return nil, DefinitionInvalidFunctionType.Error(value.Type(), d.Method, urlPath)
 	}
+	if d.SuccessCode != 0 {
+		if d.SuccessCode < 100 || d.SuccessCode >= 600 {
+			return nil, DefinitionInvalidSuccessCode.Error(d.SuccessCode, d.Method, urlPath)
+		}
+		customCode = d.SuccessCode
+	}
 	if customCode == 0 {
 		customCode = service.HTTPCodeFor(d.Method)
 	}
 	c := &executor{
-		method:   method,
-		code:     customCode,
-		function: value,
+		method:           method,
+		code:             customCode,
+		function:         value,
+		timeout:          d.Timeout,
+		maxBodySize:      d.MaxBodySize,
+		accumulateErrors: d.AccumulateErrors,
+		partialResponse:  d.PartialResponse,
+		security:         d.Security,
+		middlewares:      d.Middlewares,
+	}
+	if len(interceptors) > 0 {
+		c.interceptors = interceptors
+		c.definition = &d
+	}
+	for _, r := range d.Security {
+		if _, ok := service.SecuritySchemeFor(r.Scheme); !ok {
+			return nil, DefinitionNoSecurityScheme.Error(r.Scheme, d.Method, urlPath)
+		}
+	}
+	if d.Deprecated {
+		c.deprecation = d.DeprecationMessage
+		if c.deprecation == "" {
+			c.deprecation = "Deprecated API"
+		}
 	}
 	consumeAll := false
 	consumes := map[string]bool{}
@@ -158,6 +198,29 @@ func DefinitionToExecutor(urlPath string, d definition.Definition, customCode in
 		return nil, err
 	}
 	c.results = rs
+	c.websocket = -1
+	for i, p := range ps {
+		if p.targetType == webSocketConnType && c.websocket < 0 {
+			c.websocket = i
+		}
+		if p.generator.Source() == definition.Body && p.targetType.Kind() == reflect.Chan {
+			c.stream = true
+		}
+	}
+	if c.websocket >= 0 || d.Method == definition.WebSocket {
+		if c.websocket < 0 {
+			return nil, DefinitionInvalidWebSocket.Error(d.Method, urlPath, "no websocket connection parameter")
+		}
+		if len(rs) != 1 || rs[0].handler.Destination() != definition.Error {
+			return nil, DefinitionInvalidWebSocket.Error(d.Method, urlPath, "function must return only an error")
+		}
+	}
+	if len(d.ResponseOperators) > 0 {
+		if err := validateResponseOperators(funcName, value.Type(), d.Results, d.ResponseOperators); err != nil {
+			return nil, err
+		}
+		c.responseOperators = d.ResponseOperators
+	}
 	return c, nil
 }
 
@@ -166,11 +229,22 @@ func generateParameters(path, funcName string, typ reflect.Type, ps []definition
 		return nil, DefinitionUnmatchedParameters.Error(funcName, typ.NumIn(), len(ps), path)
 	}
 	parameters := make([]parameter, 0, len(ps))
+	// readBody indicates whether a previous parameter reads request body.
+	readBody := false
 	for index, p := range ps {
 		generator := service.ParameterGeneratorFor(p.Source)
 		if generator == nil {
 			return nil, service.NoParameterGenerator.Error(p.Source)
 		}
+		switch p.Source {
+		case definition.Body, definition.Form, definition.File:
+			readBody = true
+		case definition.RawBody:
+			if readBody {
+				return nil, InvalidParameter.Error(order(index+1), funcName,
+					"raw body parameter must be placed before parameters which read request body")
+			}
+		}
 
 		param := parameter{
 			name:         p.Name,
@@ -178,16 +252,39 @@ func generateParameters(path, funcName string, typ reflect.Type, ps []definition
 			generator:    generator,
 			operators:    p.Operators,
 			optional:     p.Optional,
+			required:     p.Required,
 		}
-		if len(p.Operators) <= 0 {
-			param.targetType = typ.In(index)
-		} else {
-			param.targetType = p.Operators[0].In()
+		if param.optional && param.required {
+			return nil, InvalidParameter.Error(order(index+1), funcName, "parameter can't be both required and optional")
+		}
+		param.targetType = definition.InTypeOf(p.Operators, typ.In(index))
+		if p.Converter != nil && p.Separator != "" {
+			return nil, InvalidParameter.Error(order(index+1), funcName, "parameter can't have both a separator and a converter")
 		}
-		if err := generator.Validate(param.name, param.defaultValue, param.targetType); err != nil {
+		if p.Converter != nil {
+			if err := validateConverter(p, p.Converter.Source(), param.targetType); err != nil {
+				return nil, InvalidParameter.Error(order(index+1), funcName, err.Error())
+			}
+			param.converter = p.Converter
+			param.rawValues = service.RawValuesFor(p.Source)
+		} else if p.Separator != "" {
+			converter, err := separatedConverterFor(p, param.targetType)
+			if err != nil {
+				return nil, InvalidParameter.Error(order(index+1), funcName, err.Error())
+			}
+			param.converter = converter
+			param.rawValues = service.RawValuesFor(p.Source)
+		} else if err := generator.Validate(param.name, param.defaultValue, param.targetType); err != nil {
 			// Order from 0 is odd. So index+1.
 			return nil, InvalidParameter.Error(order(index+1), funcName, err.Error())
 		}
+		if len(p.Aliases) > 0 {
+			if err := validateAliases(p); err != nil {
+				return nil, InvalidParameter.Error(order(index+1), funcName, err.Error())
+			}
+			param.aliases = p.Aliases
+			param.rawValues = service.RawValuesFor(p.Source)
+		}
 		if len(param.operators) > 0 {
 			if err := validateOperators(param.targetType, typ.In(index), param.operators); err != nil {
 				return nil, InvalidOperatorsForParameter.Error(order(index+1), funcName, err.Error())
@@ -198,10 +295,106 @@ func generateParameters(path, funcName string, typ reflect.Type, ps []definition
 	return parameters, nil
 }
 
+// validateConverter validates the converter of a parameter. Converters replace the
+// validation of parameter generators, so the name and default value are checked here.
+func validateConverter(p definition.Parameter, source definition.Source, target reflect.Type) error {
+	if source != p.Source {
+		return fmt.Errorf("converter is for %s but the parameter is from %s", source, p.Source)
+	}
+	if service.RawValuesFor(p.Source) == nil {
+		return fmt.Errorf("parameters from %s can't have converters", p.Source)
+	}
+	if p.Name == "" {
+		return fmt.Errorf("parameter from %s must have a name", p.Source)
+	}
+	if p.Default != nil && !reflect.TypeOf(p.Default).AssignableTo(target) {
+		return fmt.Errorf("default value of type %s is not assignable to %s", reflect.TypeOf(p.Default), target)
+	}
+	return nil
+}
+
+// separatedConverter converts raw values which are lists split by a separator.
+type separatedConverter struct {
+	source    definition.Source
+	name      string
+	separator string
+	target    reflect.Type
+	convert   service.Converter
+}
+
+// separatedConverterFor creates a converter for a parameter with separator. The
+// target must be a slice and its element type must have a built-in converter.
+func separatedConverterFor(p definition.Parameter, target reflect.Type) (*separatedConverter, error) {
+	if err := validateConverter(p, p.Source, target); err != nil {
+		return nil, err
+	}
+	if target.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("parameter with separator must be a slice, but got %s", target)
+	}
+	convert := service.ConverterFor(target.Elem())
+	if convert == nil {
+		return nil, fmt.Errorf("no converter for element type %s", target.Elem())
+	}
+	return &separatedConverter{
+		source:    p.Source,
+		name:      p.Name,
+		separator: p.Separator,
+		target:    target,
+		convert:   convert,
+	}, nil
+}
+
+// Source returns the source of raw values.
+func (c *separatedConverter) Source() definition.Source {
+	return c.source
+}
+
+// Convert splits raw values and converts every element to the element type.
+func (c *separatedConverter) Convert(ctx context.Context, raw []string) (interface{}, error) {
+	result := reflect.MakeSlice(c.target, 0, len(raw))
+	for _, value := range raw {
+		for _, element := range strings.Split(value, c.separator) {
+			element = strings.TrimSpace(element)
+			if element == "" {
+				continue
+			}
+			v, err := c.convert(ctx, []string{element})
+			if err != nil {
+				return nil, invalidElement.Error(element, c.name, err.Error())
+			}
+			result = reflect.Append(result, reflect.ValueOf(v))
+		}
+	}
+	if result.Len() <= 0 {
+		// Like empty raw values, a list without elements is absent.
+		return nil, nil
+	}
+	return result.Interface(), nil
+}
+
+// validateAliases validates the aliases of a parameter.
+func validateAliases(p definition.Parameter) error {
+	if service.RawValuesFor(p.Source) == nil {
+		return fmt.Errorf("parameters from %s can't have aliases", p.Source)
+	}
+	if p.Name == "" {
+		return fmt.Errorf("parameter from %s must have a name", p.Source)
+	}
+	for _, alias := range p.Aliases {
+		if alias == "" || alias == p.Name {
+			return fmt.Errorf("alias %q of parameter %s is invalid", alias, p.Name)
+		}
+	}
+	return nil
+}
+
 func generateResults(path, funcName string, typ reflect.Type, rs []definition.Result) ([]result, error) {
 	if typ.NumOut() != len(rs) {
 		return nil, DefinitionUnmatchedResults.Error(funcName, typ.NumOut(), len(rs), path)
 	}
+	if err := validateDataFields(path, funcName, rs); err != nil {
+		return nil, err
+	}
 	results := make([]result, 0, len(rs))
 	for index, r := range rs {
 		handler := service.DestinationHandlerFor(r.Destination)
@@ -213,9 +406,12 @@ func generateResults(path, funcName string, typ reflect.Type, rs []definition.Re
 			handler:   handler,
 			operators: r.Operators,
 		}
+		if r.Destination == definition.Data {
+			result.field = r.Field
+		}
 		outType := typ.Out(index)
 		if len(result.operators) > 0 {
-			LastOperatorOutType := result.operators[len(result.operators)-1].Out()
+			LastOperatorOutType := definition.OutTypeOf(result.operators, outType)
 			if err := validateOperators(outType, LastOperatorOutType, result.operators); err != nil {
 				return nil, InvalidOperatorsForResult.Error(order(index+1), funcName, err.Error())
 			}
@@ -227,15 +423,69 @@ func generateResults(path, funcName string, typ reflect.Type, rs []definition.Re
 		}
 		results = append(results, result)
 	}
-	sort.Sort(resultsSorter(results))
+	sort.Stable(resultsSorter(results))
 	return results, nil
 }
 
+// validateResponseOperators checks if response operators can operate the data of response.
+func validateResponseOperators(funcName string, typ reflect.Type, rs []definition.Result, ops []definition.Operator) error {
+	var in reflect.Type
+	count := 0
+	for index, r := range rs {
+		if r.Destination != definition.Data {
+			continue
+		}
+		count++
+		in = definition.OutTypeOf(r.Operators, typ.Out(index))
+		if r.Field != "" {
+			in = reflect.TypeOf(map[string]interface{}{})
+		}
+	}
+	if count <= 0 {
+		return InvalidResponseOperators.Error(funcName, "no data result")
+	}
+	out := definition.OutTypeOf(ops, in)
+	if err := validateOperators(in, out, ops); err != nil {
+		return InvalidResponseOperators.Error(funcName, err.Error())
+	}
+	if err := service.DestinationHandlerFor(definition.Data).Validate(out); err != nil {
+		return InvalidResponseOperators.Error(funcName, err.Error())
+	}
+	return nil
+}
+
+// validateDataFields checks if data results can be assembled into an object.
+// A definition can have only one data result without field. Otherwise all data
+// results must have unique fields.
+func validateDataFields(path, funcName string, rs []definition.Result) error {
+	count := 0
+	for _, r := range rs {
+		if r.Destination == definition.Data {
+			count++
+		}
+	}
+	fields := map[string]bool{}
+	for _, r := range rs {
+		if r.Destination != definition.Data || (r.Field == "" && count <= 1) {
+			continue
+		}
+		if r.Field == "" {
+			return DefinitionAmbiguousDataResults.Error(funcName, count, path, "found a data result without field")
+		}
+		if fields[r.Field] {
+			return DefinitionAmbiguousDataResults.Error(funcName, count, path, fmt.Sprintf("field %s is duplicated", r.Field))
+		}
+		fields[r.Field] = true
+	}
+	return nil
+}
+
 // validateOperators checks if the chain is valid:
-//   in -> operators[0].In()
-//   operators[0].Out() -> operators[1].In()
-//   ...
-//   operators[N].Out() -> out
+//
+//	in -> operators[0].In()
+//	operators[0].Out() -> operators[1].In()
+//	...
+//	operators[N].Out() -> out
 func validateOperators(in, out reflect.Type, operators []definition.Operator) error {
 	if len(operators) <= 0 {
 		return nil
@@ -243,14 +493,25 @@ func validateOperators(in, out reflect.Type, operators []definition.Operator) er
 	index := 0
 	for ; index < len(operators); index++ {
 		operator := operators[index]
+		if adaptive, ok := operator.(definition.AdaptiveOperator); ok {
+			// Adaptive operator doesn't change the type.
+			if err := adaptive.Accept(in); err != nil {
+				return invalidOperatorInType.Error(in, order(index+1))
+			}
+			continue
+		}
 		if !in.AssignableTo(operator.In()) {
 			// The out type of operator[index-1] is not compatible to operator[index].
 			return invalidOperatorInType.Error(in, order(index+1))
 		}
+		if checker, ok := operator.(definition.InTypeChecker); ok {
+			if err := checker.CheckIn(in); err != nil {
+				return invalidOperatorInType.Error(in, order(index+1))
+			}
+		}
 		in = operator.Out()
 	}
-	typ := operators[index-1].Out()
-	if !typ.AssignableTo(out) {
+	if !in.AssignableTo(out) {
 		// The last operator is not compatible to out type.
 		return invalidOperatorOutType.Error(order(index), out)
 	}
@@ -266,8 +527,37 @@ type executor struct {
 	parameters     []parameter
 	results        []result
 	function       reflect.Value
+	timeout        time.Duration
+	maxBodySize    int64
+	// accumulateErrors indicates whether bad request errors of all parameters
+	// are collected.
+	accumulateErrors bool
+	// responseOperators operate the data of response.
+	responseOperators []definition.Operator
+	// deprecation is the warning message of a deprecated definition.
+	deprecation string
+	// partialResponse is the mode of partial responses.
+	partialResponse definition.PartialResponseMode
+	// security contains security requirements. A request must pass one of them.
+	security []definition.SecurityRequirement
+	// middlewares are middlewares of the definition. They run after middlewares
+	// of descriptors.
+	middlewares []definition.Middleware
+	// websocket is the index of websocket connection parameter. It's -1 if
+	// there is no such parameter.
+	websocket int
+	// stream indicates whether request body is decoded into a channel parameter.
+	stream bool
+	// interceptors run around the function.
+	interceptors []service.Interceptor
+	// definition is the definition for interceptors. It's nil if there is no
+	// interceptor.
+	definition *definition.Definition
 }
 
+// webSocketConnType is the type of websocket connection parameters.
+var webSocketConnType = reflect.TypeOf((*service.WebSocketConn)(nil))
+
 type parameter struct {
 	name         string
 	targetType   reflect.Type
@@ -275,12 +565,92 @@ type parameter struct {
 	generator    service.ParameterGenerator
 	operators    []definition.Operator
 	optional     bool
+	required     bool
+	// aliases are other names of the parameter.
+	aliases []string
+	// converter replaces the generator if it's not nil.
+	converter definition.Converter
+	rawValues func(vc service.ValueContainer, name string) ([]string, bool)
+}
+
+// lookup returns the first present one of name and aliases. It returns name
+// if none is present.
+func (p *parameter) lookup(vc service.ValueContainer) string {
+	if len(p.aliases) <= 0 {
+		return p.name
+	}
+	if _, ok := p.rawValues(vc, p.name); ok {
+		return p.name
+	}
+	for _, alias := range p.aliases {
+		if _, ok := p.rawValues(vc, alias); ok {
+			return alias
+		}
+	}
+	return p.name
+}
+
+// field returns the field name of the parameter in errors. Parameters
+// without name use their sources.
+func (p *parameter) field() string {
+	if p.name != "" {
+		return p.name
+	}
+	return string(p.generator.Source())
+}
+
+// generate generates the value of parameter by converter or generator.
+func (p *parameter) generate(ctx context.Context, vc service.ValueContainer, consumers []service.Consumer) (interface{}, error) {
+	name := p.lookup(vc)
+	if p.converter == nil {
+		return p.generator.Generate(ctx, vc, consumers, name, p.targetType)
+	}
+	raw, ok := p.rawValues(vc, name)
+	if !ok {
+		return nil, nil
+	}
+	result, err := p.converter.Convert(ctx, raw)
+	if err != nil || result == nil {
+		return nil, err
+	}
+	if typ := reflect.TypeOf(result); !typ.AssignableTo(p.targetType) {
+		return nil, invalidConverterResult.Error(typ, p.name, p.targetType)
+	}
+	return result, nil
+}
+
+// operate completes a generated value of parameter. It checks whether the value is
+// required, applies default value and runs operators.
+func (p *parameter) operate(ctx context.Context, value interface{}) (interface{}, error) {
+	if value == nil && p.required {
+		return nil, service.RequiredParameter.Error(p.name, p.generator.Source())
+	}
+	if value == nil {
+		if p.defaultValue != nil {
+			value = p.defaultValue
+		} else {
+			value = reflect.Zero(p.targetType).Interface()
+		}
+	}
+	var err error
+	for _, operator := range p.operators {
+		value, err = operator.Operate(ctx, p.name, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if value == nil && !p.optional {
+		return nil, requiredField.Error(p.name, p.generator.Source())
+	}
+	return value, nil
 }
 
 type result struct {
 	index     int
 	handler   service.DestinationHandler
 	operators []definition.Operator
+	// field is the key of data result in response object.
+	field string
 }
 
 type resultsSorter []result
@@ -303,6 +673,7 @@ func (s resultsSorter) Swap(i, j int) {
 
 func (e *executor) check(producers []service.Producer, ats []string) bool {
 	for _, at := range ats {
+		at = service.MediaType(at)
 		for _, c := range producers {
 			if c.ContentType() == at {
 				return true
@@ -325,6 +696,16 @@ func (e *executor) Producible(ats []string) bool {
 	return e.check(e.producers, ats) && e.check(e.errorProducers, ats)
 }
 
+// ProducibleFor negotiates producers and error producers for req.
+func (e *executor) ProducibleFor(req *http.Request) bool {
+	p, err := service.Negotiate(req, e.producers)
+	if err != nil || p == nil {
+		return false
+	}
+	p, err = service.Negotiate(req, e.errorProducers)
+	return err == nil && p != nil
+}
+
 func (e *executor) ContentTypeMap() map[string][]string {
 	result := map[string][]string{}
 	for _, c := range e.consumers {
@@ -336,34 +717,95 @@ func (e *executor) ContentTypeMap() map[string][]string {
 	return result
 }
 
-// Execute executes with context.
-func (e *executor) Execute(ctx context.Context) (err error) {
+// Execute executes with context. Middlewares of the definition run before the
+// definition.
+func (e *executor) Execute(ctx context.Context) error {
+	if len(e.middlewares) > 0 {
+		return NewMiddlewareExecutor(e.middlewares, definitionExecutor{e}).Execute(ctx)
+	}
+	return e.execute(ctx)
+}
+
+// definitionExecutor executes a definition after middlewares of the definition.
+type definitionExecutor struct {
+	*executor
+}
+
+// Execute executes the definition.
+func (e definitionExecutor) Execute(ctx context.Context) error {
+	return e.execute(ctx)
+}
+
+// execute executes the definition with context.
+func (e *executor) execute(ctx context.Context) (err error) {
 	c := service.HTTPContextFrom(ctx)
 	if c == nil {
 		return service.NoContext.Error()
 	}
+	service.SetProducers(ctx, e.producers)
+	if e.deprecation != "" {
+		headers := c.ResponseWriter().Header()
+		headers.Set("Deprecation", "true")
+		headers.Set("Warning", "299 - "+strconv.Quote(e.deprecation))
+	}
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+	// Requests are authenticated after all middlewares, so that rejected requests
+	// are still limited, logged and traced by middlewares.
+	if err := service.Authenticate(ctx, e.security); err != nil {
+		return service.WriteError(ctx, e.errorProducers, err)
+	}
+	var cancelWebSocket context.CancelFunc
+	if e.websocket >= 0 {
+		// The context is cancelled when the websocket connection is closed.
+		ctx, cancelWebSocket = context.WithCancel(ctx)
+		defer cancelWebSocket()
+	}
+	if e.stream {
+		// Goroutines which decode the request body stop when the function returns.
+		var cancelStream context.CancelFunc
+		ctx, cancelStream = context.WithCancel(ctx)
+		defer cancelStream()
+	}
+	var body *limitedBody
+	if e.maxBodySize > 0 {
+		req := c.Request()
+		if req.ContentLength > e.maxBodySize {
+			return service.WriteError(ctx, e.errorProducers, bodyTooLarge.Error(e.maxBodySize))
+		}
+		if req.Body != nil {
+			body = &limitedBody{ReadCloser: req.Body, limit: e.maxBodySize, remaining: e.maxBodySize}
+			req.Body = body
+		}
+	}
 	paramValues := make([]reflect.Value, 0, len(e.parameters))
+	var paramErrors service.ParameterErrors
 	for _, p := range e.parameters {
-		result, err := p.generator.Generate(ctx, c.ValueContainer(), e.consumers, p.name, p.targetType)
-		if err != nil {
-			return service.WriteError(ctx, e.errorProducers, err)
+		result, err := p.generate(ctx, c.ValueContainer(), e.consumers)
+		if err != nil && body != nil && body.exceeded {
+			// Consumers may wrap the error of reader, so it's replaced here.
+			return service.WriteError(ctx, e.errorProducers, bodyTooLarge.Error(e.maxBodySize))
 		}
-		if result == nil {
-			if p.defaultValue != nil {
-				result = p.defaultValue
-			} else {
-				result = reflect.Zero(p.targetType).Interface()
-			}
+		if err == nil {
+			result, err = p.operate(ctx, result)
 		}
-		for _, operator := range p.operators {
-			result, err = operator.Operate(ctx, p.name, result)
-			if err != nil {
-				return service.WriteError(ctx, e.errorProducers, err)
-			}
+		if err == definition.ErrResponseWritten {
+			return nil
 		}
-
-		if result == nil && !p.optional {
-			return service.WriteError(ctx, e.errorProducers, requiredField.Error(p.name, p.generator.Source()))
+		if err != nil {
+			if pe, ok := err.(service.ParameterErrors); ok && e.accumulateErrors {
+				// Errors of fields in a parameter, such as fields of a body.
+				paramErrors = append(paramErrors, pe...)
+				continue
+			}
+			if se, ok := err.(service.Error); ok && e.accumulateErrors && se.Code() == http.StatusBadRequest {
+				paramErrors = append(paramErrors, service.NewFieldError(p.field(), p.generator.Source(), err))
+				continue
+			}
+			return service.WriteError(ctx, e.errorProducers, err)
 		}
 
 		if closer, ok := result.(io.Closer); ok {
@@ -380,6 +822,15 @@ func (e *executor) Execute(ctx context.Context) (err error) {
 		} else {
 			paramValues = append(paramValues, reflect.ValueOf(result))
 		}
+		service.SetBoundParameter(ctx, p.field(), paramValues[len(paramValues)-1].Interface())
+	}
+
+	if len(paramErrors) > 0 {
+		return service.WriteError(ctx, e.errorProducers, paramErrors)
+	}
+
+	if e.websocket >= 0 {
+		return e.serveWebSocket(ctx, cancelWebSocket, paramValues)
 	}
 
 	code := e.code
@@ -394,25 +845,71 @@ func (e *executor) Execute(ctx context.Context) (err error) {
 		}
 	}
 
-	resultValues := e.function.Call(paramValues)
+	fields := e.fields()
+	var object map[string]interface{}
+	resultValues, err := e.intercept(ctx, paramValues)
+	if err != nil {
+		return service.WriteError(ctx, e.errorProducers, err)
+	}
+	// Readers in results are closed after the response is written, even if
+	// an error occurs or the results are not written.
+	closers := e.resultClosers(resultValues)
+	defer func() {
+		for _, closer := range closers {
+			if e := closer.Close(); e != nil && err == nil {
+				// Need to print error here.
+				err = e
+			}
+		}
+	}()
+	if e.stream {
+		if err := e.streamError(ctx, resultValues); err != nil {
+			return service.WriteError(ctx, e.errorProducers, err)
+		}
+	}
 	for _, r := range e.results {
 		v := resultValues[r.index]
 		data := v.Interface()
 		for _, operator := range r.operators {
 			newData, err := operator.Operate(ctx, string(r.handler.Destination()), data)
+			if err == definition.ErrResponseWritten {
+				return nil
+			}
 			if err != nil {
 				return err
 			}
 			data = newData
 		}
-		if data != nil {
-			if closer, ok := data.(io.Closer); ok {
-				defer func() {
-					if e := closer.Close(); e != nil && err == nil {
-						// Need to print error here.
-						err = e
-					}
-				}()
+		// Operators may replace readers.
+		closers = appendCloser(closers, data)
+		if r.field != "" {
+			// Assemble data results into an object and handle it
+			// when all fields are collected.
+			if object == nil {
+				object = make(map[string]interface{}, fields)
+			}
+			object[r.field] = data
+			if len(object) < fields {
+				continue
+			}
+			data = object
+		}
+		if r.handler.Destination() == definition.Data {
+			for _, operator := range e.responseOperators {
+				data, err = operator.Operate(ctx, string(definition.Data), data)
+				if err == definition.ErrResponseWritten {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+			}
+			if e.partialResponse != definition.NoPartialResponse {
+				strict := e.partialResponse == definition.StrictPartialResponse
+				data, err = service.PruneFields(data, service.RequestedFields(c.Request()), strict)
+				if err != nil {
+					return service.WriteError(ctx, e.errorProducers, err)
+				}
 			}
 		}
 		producers := e.producers
@@ -435,6 +932,173 @@ func (e *executor) Execute(ctx context.Context) (err error) {
 	return nil
 }
 
+// serveWebSocket calls the function with a websocket connection. Errors before
+// upgrade are written as responses, and errors after upgrade close the connection.
+func (e *executor) serveWebSocket(ctx context.Context, cancel context.CancelFunc, paramValues []reflect.Value) error {
+	conn, _ := paramValues[e.websocket].Interface().(*service.WebSocketConn)
+	if conn == nil {
+		return service.WriteError(ctx, e.errorProducers, service.NoContext.Error())
+	}
+	go func() {
+		select {
+		case <-conn.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	resultValues, err := e.intercept(ctx, paramValues)
+	if err == nil {
+		err, _ = resultValues[e.results[0].index].Interface().(error)
+	}
+	if err == nil && !conn.Upgraded() {
+		err = conn.Upgrade()
+	}
+	if !conn.Upgraded() {
+		return service.WriteError(ctx, e.errorProducers, err)
+	}
+	if err != nil {
+		return conn.CloseWithStatus(service.WebSocketInternalError, err.Error())
+	}
+	return conn.Close()
+}
+
+// intercept calls the function through interceptors. Like call, it returns an
+// error only if the function is not called or doesn't return in time. Errors
+// returned by the function are in results.
+func (e *executor) intercept(ctx context.Context, paramValues []reflect.Value) ([]reflect.Value, error) {
+	if len(e.interceptors) <= 0 {
+		return e.call(ctx, paramValues)
+	}
+	invocation := &service.Invocation{
+		Definition: e.definition,
+		Parameters: make([]interface{}, len(paramValues)),
+	}
+	for i, v := range paramValues {
+		invocation.Parameters[i] = v.Interface()
+	}
+	var resultValues []reflect.Value
+	_, err := service.Intercept(ctx, e.interceptors, invocation, func() ([]interface{}, error) {
+		var err error
+		resultValues, err = e.call(ctx, paramValues)
+		if err != nil {
+			return nil, err
+		}
+		results := make([]interface{}, len(resultValues))
+		for i, v := range resultValues {
+			results[i] = v.Interface()
+		}
+		for _, r := range e.results {
+			if r.handler.Destination() != definition.Error {
+				continue
+			}
+			if failure, ok := results[r.index].(error); ok && failure != nil {
+				return results, failure
+			}
+		}
+		return results, nil
+	})
+	if resultValues == nil {
+		return nil, err
+	}
+	return resultValues, nil
+}
+
+// streamError returns the error which stops decoding the request body if the
+// function returns no error.
+func (e *executor) streamError(ctx context.Context, values []reflect.Value) error {
+	for _, r := range e.results {
+		if r.handler.Destination() == definition.Error && values[r.index].Interface() != nil {
+			return nil
+		}
+	}
+	return service.BodyStreamError(ctx)
+}
+
+// call calls the function. If the executor has a timeout, the function runs
+// in a new goroutine and call returns an error when the context is done.
+func (e *executor) call(ctx context.Context, paramValues []reflect.Value) ([]reflect.Value, error) {
+	if e.timeout <= 0 {
+		return e.function.Call(paramValues), nil
+	}
+	type returns struct {
+		values []reflect.Value
+		panic  interface{}
+	}
+	// The channel is buffered so that the goroutine can always exit
+	// even if nobody receives the results.
+	ch := make(chan returns, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				ch <- returns{panic: r}
+			}
+		}()
+		ch <- returns{values: e.function.Call(paramValues)}
+	}()
+	select {
+	case r := <-ch:
+		if r.panic != nil {
+			panic(r.panic)
+		}
+		return r.values, nil
+	case <-ctx.Done():
+		go func() {
+			// Results are discarded, so their readers are closed when they return.
+			if r := <-ch; r.values != nil {
+				for _, closer := range e.resultClosers(r.values) {
+					_ = closer.Close()
+				}
+			}
+		}()
+		return nil, timeout.Error(e.timeout)
+	}
+}
+
+// resultClosers returns closers in values of results except errors.
+func (e *executor) resultClosers(values []reflect.Value) []io.Closer {
+	var closers []io.Closer
+	for _, r := range e.results {
+		if r.handler.Destination() != definition.Error {
+			closers = appendCloser(closers, values[r.index].Interface())
+		}
+	}
+	return closers
+}
+
+// appendCloser appends v to closers if v is a closer which is not nil and not in
+// closers.
+func appendCloser(closers []io.Closer, v interface{}) []io.Closer {
+	closer, ok := v.(io.Closer)
+	if !ok {
+		return closers
+	}
+	value := reflect.ValueOf(closer)
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan, reflect.Interface:
+		if value.IsNil() {
+			return closers
+		}
+	}
+	typ := value.Type()
+	for _, c := range closers {
+		if reflect.TypeOf(c) == typ && typ.Comparable() && c == closer {
+			return closers
+		}
+	}
+	return append(closers, closer)
+}
+
+// fields returns the number of data results which have field.
+func (e *executor) fields() int {
+	count := 0
+	for _, r := range e.results {
+		if r.field != "" {
+			count++
+		}
+	}
+	return count
+}
+
 func order(i int) string {
 	switch i % 10 {
 	case 1:
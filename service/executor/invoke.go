@@ -0,0 +1,178 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/caicloud/nirvana/definition"
+	"github.com/caicloud/nirvana/service"
+)
+
+// InvokeDefinition invokes the function of a definition without an HTTP server.
+// It's useful to test definitions in unit tests.
+//
+// Values in params are keyed by parameter names or aliases. A parameter without
+// name (such as a body) is keyed by its source. A value is used directly if it's assignable
+// to the parameter type, and string or []string values are converted like query
+// values. Prefab parameters without values are made from prefabs with ctx.
+//
+// Parameter operators, result operators, response operators and interceptors run
+// as they do in a real server. If the function returns an error, the error is
+// returned. If the definition has only one data result, the data is returned. If
+// data results have fields, a map[string]interface{} is returned.
+//
+// There is no HTTP request, so security requirements and middlewares are
+// bypassed. If an operator returns definition.ErrResponseWritten, the function
+// is not called and the error is returned.
+func InvokeDefinition(ctx context.Context, d definition.Definition, params map[string]interface{}, interceptors ...service.Interceptor) (interface{}, error) {
+	if d.Method == "" {
+		d.Method = definition.Any
+	}
+	if len(d.Consumes) <= 0 {
+		d.Consumes = []string{definition.MIMEAll}
+	}
+	if len(d.Produces) <= 0 {
+		d.Produces = []string{definition.MIMEAll}
+	}
+	if len(d.ErrorProduces) <= 0 {
+		d.ErrorProduces = d.Produces
+	}
+	if typ := reflect.TypeOf(d.Function); typ != nil && typ.Kind() == reflect.Func &&
+		typ.NumIn() == len(d.Parameters)+1 && typ.In(0) == reflect.TypeOf((*context.Context)(nil)).Elem() {
+		service.FirstContextParameter()(&d)
+	}
+	e, err := DefinitionToExecutor("", d, 0, interceptors...)
+	if err != nil {
+		return nil, err
+	}
+	return e.(*executor).invoke(ctx, params)
+}
+
+// invoke calls the function with params and returns the data of results.
+func (e *executor) invoke(ctx context.Context, params map[string]interface{}) (interface{}, error) {
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+	paramValues := make([]reflect.Value, 0, len(e.parameters))
+	for _, p := range e.parameters {
+		key := p.name
+		if key == "" {
+			key = string(p.generator.Source())
+		}
+		for _, alias := range p.aliases {
+			if params[key] != nil {
+				break
+			}
+			key = alias
+		}
+		value, err := p.bind(ctx, params[key])
+		if err != nil {
+			return nil, err
+		}
+		value, err = p.operate(ctx, value)
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			paramValues = append(paramValues, reflect.New(p.targetType).Elem())
+		} else {
+			paramValues = append(paramValues, reflect.ValueOf(value))
+		}
+	}
+	resultValues, err := e.intercept(ctx, paramValues)
+	if err != nil {
+		return nil, err
+	}
+	fields := e.fields()
+	var object map[string]interface{}
+	var data interface{}
+	for _, r := range e.results {
+		value := resultValues[r.index].Interface()
+		for _, operator := range r.operators {
+			value, err = operator.Operate(ctx, string(r.handler.Destination()), value)
+			if err != nil {
+				return nil, err
+			}
+		}
+		switch r.handler.Destination() {
+		case definition.Error:
+			if value != nil {
+				return nil, value.(error)
+			}
+		case definition.Data:
+			if r.field != "" {
+				if object == nil {
+					object = make(map[string]interface{}, fields)
+				}
+				object[r.field] = value
+				if len(object) < fields {
+					continue
+				}
+				value = object
+			}
+			for _, operator := range e.responseOperators {
+				value, err = operator.Operate(ctx, string(definition.Data), value)
+				if err != nil {
+					return nil, err
+				}
+			}
+			data = value
+		}
+	}
+	return data, nil
+}
+
+// bind converts a value from params to the type of parameter.
+func (p *parameter) bind(ctx context.Context, value interface{}) (interface{}, error) {
+	if value == nil {
+		if p.generator.Source() == definition.Prefab {
+			return p.generator.Generate(ctx, nil, nil, p.name, p.targetType)
+		}
+		return nil, nil
+	}
+	if reflect.TypeOf(value).AssignableTo(p.targetType) {
+		return value, nil
+	}
+	var raw []string
+	switch v := value.(type) {
+	case string:
+		raw = []string{v}
+	case []string:
+		raw = v
+	default:
+		return nil, unassignableValue.Error(reflect.TypeOf(value), p.name, p.targetType)
+	}
+	if p.converter != nil {
+		result, err := p.converter.Convert(ctx, raw)
+		if err != nil || result == nil {
+			return nil, err
+		}
+		if typ := reflect.TypeOf(result); !typ.AssignableTo(p.targetType) {
+			return nil, invalidConverterResult.Error(typ, p.name, p.targetType)
+		}
+		return result, nil
+	}
+	converter := service.ConverterFor(p.targetType)
+	if converter == nil {
+		return nil, unassignableValue.Error(reflect.TypeOf(value), p.name, p.targetType)
+	}
+	return converter(ctx, raw)
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/caicloud/nirvana/definition"
+	"github.com/caicloud/nirvana/service"
+)
+
+type invokeApp struct {
+	Name  string
+	Count int
+}
+
+func TestInvokeDefinition(t *testing.T) {
+	d := definition.Definition{
+		Method: definition.Get,
+		Function: func(ctx context.Context, name string, count int) (*invokeApp, error) {
+			if name == "ERROR" {
+				return nil, fmt.Errorf("invalid name")
+			}
+			return &invokeApp{Name: name, Count: count}, nil
+		},
+		Parameters: []definition.Parameter{
+			{
+				Source:   definition.Path,
+				Name:     "name",
+				Required: true,
+				Operators: []definition.Operator{
+					definition.OperatorFunc("upper", func(ctx context.Context, field string, value string) (string, error) {
+						return strings.ToUpper(value), nil
+					}),
+				},
+			},
+			{
+				Source:  definition.Query,
+				Name:    "count",
+				Default: 1,
+			},
+		},
+		Results: definition.DataErrorResults(""),
+	}
+
+	data, err := InvokeDefinition(context.Background(), d, map[string]interface{}{"name": "app", "count": "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, ok := data.(*invokeApp)
+	if !ok || app.Name != "APP" || app.Count != 3 {
+		t.Fatalf("Unexpected data: %+v", data)
+	}
+
+	data, err = InvokeDefinition(context.Background(), d, map[string]interface{}{"name": "app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app := data.(*invokeApp); app.Count != 1 {
+		t.Fatalf("Unexpected count: %d", app.Count)
+	}
+
+	_, err = InvokeDefinition(context.Background(), d, map[string]interface{}{"name": "error"})
+	if err == nil || err.Error() != "invalid name" {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	_, err = InvokeDefinition(context.Background(), d, nil)
+	if !service.RequiredParameter.Derived(err) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	_, err = InvokeDefinition(context.Background(), d, map[string]interface{}{"name": "app", "count": true})
+	if !unassignableValue.Derived(err) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+type invokeInterceptor struct {
+	before, after int
+	err           error
+}
+
+func (i *invokeInterceptor) BeforeHandler(ctx context.Context, invocation *service.Invocation) error {
+	i.before++
+	return nil
+}
+
+func (i *invokeInterceptor) AfterHandler(ctx context.Context, invocation *service.Invocation, results []interface{}, err error) {
+	i.after++
+	i.err = err
+}
+
+func TestInvokeDefinitionOptional(t *testing.T) {
+	d := definition.Definition{
+		Method: definition.Create,
+		Function: func(ctx context.Context, app *invokeApp, tag string) (string, error) {
+			if app == nil {
+				return "no app " + tag, nil
+			}
+			return app.Name, nil
+		},
+		Parameters: []definition.Parameter{
+			definition.BodyParameterFor(""),
+			definition.QueryParameterFor("tag", ""),
+		},
+		Results: definition.DataErrorResults(""),
+	}
+	interceptor := &invokeInterceptor{}
+	// Absent optional parameters are zero values.
+	data, err := InvokeDefinition(context.Background(), d, nil, interceptor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data != "no app " {
+		t.Fatalf("Unexpected data: %v", data)
+	}
+	if interceptor.before != 1 || interceptor.after != 1 || interceptor.err != nil {
+		t.Fatalf("Interceptor should run around the function: %+v", interceptor)
+	}
+}
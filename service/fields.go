@@ -0,0 +1,265 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// RequestedFields returns fields in query "fields" of a request. Fields are
+// separated by commas, and the query can be repeated:
+//  ?fields=id,name&fields=address.city
+// It returns nil if there is no field.
+func RequestedFields(req *http.Request) []string {
+	var fields []string
+	for _, value := range req.URL.Query()["fields"] {
+		for _, field := range strings.Split(value, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				fields = append(fields, field)
+			}
+		}
+	}
+	return fields
+}
+
+// fieldTree is a tree of requested fields. A nil subtree means the whole field.
+type fieldTree map[string]fieldTree
+
+func newFieldTree(fields []string) fieldTree {
+	tree := fieldTree{}
+	for _, field := range fields {
+		current := tree
+		keys := strings.Split(field, ".")
+		for i, key := range keys {
+			sub, ok := current[key]
+			if ok && sub == nil {
+				// The whole field is requested.
+				break
+			}
+			if i == len(keys)-1 {
+				current[key] = nil
+				break
+			}
+			if sub == nil {
+				sub = fieldTree{}
+				current[key] = sub
+			}
+			current = sub
+		}
+	}
+	return tree
+}
+
+// PruneFields keeps the fields of data and removes others. Fields are keys of
+// JSON objects, and keys of nested objects are joined by dots, such as
+// "address.city". Fields apply to every element of arrays. Data is converted to
+// generic values by encoding/json, so the result is composed of
+// map[string]interface{}, []interface{} and JSON scalars.
+//
+// If strict is true, it returns a bad request error if a field is not in data.
+// A field in arrays is valid if any element has it. Data which isn't an object
+// or array, such as strings and readers, is returned as is.
+func PruneFields(data interface{}, fields []string, strict bool) (interface{}, error) {
+	if data == nil || len(fields) <= 0 || !prunable(data) {
+		return data, nil
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	// Keep numbers as is.
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	found := map[string]bool{}
+	value = pruneValue(value, newFieldTree(fields), "", found)
+	if strict {
+		var missing []string
+		for field, ok := range found {
+			if !ok {
+				missing = append(missing, field)
+			}
+		}
+		if len(missing) > 0 {
+			sort.Strings(missing)
+			return nil, unknownField.Error(missing[0])
+		}
+	}
+	return value, nil
+}
+
+// prunable checks whether data is encoded as a JSON object or array.
+func prunable(data interface{}) bool {
+	switch data.(type) {
+	case io.Reader, []byte:
+		return false
+	}
+	typ := reflect.TypeOf(data)
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	switch typ.Kind() {
+	case reflect.Struct, reflect.Map:
+		return true
+	case reflect.Slice, reflect.Array:
+		return typ.Elem().Kind() != reflect.Uint8
+	}
+	return false
+}
+
+// pruneValue prunes value by tree. It records whether fields are in value to
+// found. A field in arrays is found if any element has it, and fields in null
+// values are not recorded.
+func pruneValue(value interface{}, tree fieldTree, prefix string, found map[string]bool) interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = pruneValue(elem, tree, prefix, found)
+		}
+		return v
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(tree))
+		for key, sub := range tree {
+			field, ok := v[key]
+			found[prefix+key] = found[prefix+key] || ok
+			if !ok {
+				continue
+			}
+			if sub != nil && field != nil {
+				field = pruneValue(field, sub, prefix+key+".", found)
+			}
+			result[key] = field
+		}
+		return result
+	case nil:
+		return nil
+	}
+	// Scalars have no fields.
+	for key := range tree {
+		found[prefix+key] = found[prefix+key] || false
+	}
+	return value
+}
+
+// structField is an encoded field of struct. Fields are named by json tags, so that
+// all serializers agree on names.
+type structField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+var structFieldCache sync.Map
+
+// structFields returns fields of struct typ by json tags. Embedded structs are
+// promoted, and shallower fields win if names conflict.
+func structFields(typ reflect.Type) []structField {
+	if fields, ok := structFieldCache.Load(typ); ok {
+		return fields.([]structField)
+	}
+	var fields []structField
+	names := map[string]int{}
+	var walk func(typ reflect.Type, index []int)
+	walk = func(typ reflect.Type, index []int) {
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			tag := field.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name, options := tag, ""
+			if idx := strings.Index(tag, ","); idx >= 0 {
+				name, options = tag[:idx], tag[idx:]
+			}
+			fieldIndex := append(append([]int(nil), index...), i)
+			ft := field.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if field.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+				if field.Type.Kind() != reflect.Ptr || field.PkgPath == "" {
+					// Pointers of unexported embedded structs can't be allocated.
+					walk(ft, fieldIndex)
+				}
+				continue
+			}
+			if field.PkgPath != "" {
+				// Unexported.
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			if j, ok := names[name]; ok {
+				// Shallower fields win.
+				if len(fields[j].index) > len(fieldIndex) {
+					fields[j] = structField{name, fieldIndex, strings.Contains(options, ",omitempty")}
+				}
+				continue
+			}
+			names[name] = len(fields)
+			fields = append(fields, structField{name, fieldIndex, strings.Contains(options, ",omitempty")})
+		}
+	}
+	walk(typ, nil)
+	structFieldCache.Store(typ, fields)
+	return fields
+}
+
+// structFieldValue returns the field of v by index. It returns false if an embedded
+// pointer is nil.
+func structFieldValue(v reflect.Value, index []int) (reflect.Value, bool) {
+	for _, i := range index {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	return v, true
+}
+
+// isEmptyValue checks whether v is empty for option "omitempty", like encoding/json.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"mime"
 	"net/http"
+	"net/url"
 	"sort"
 	"strconv"
 	"strings"
@@ -36,12 +37,14 @@ type Filter func(resp http.ResponseWriter, req *http.Request) bool
 
 // RedirectTrailingSlash returns a filter to redirect request.
 // If a request has trailing slash like `some-url/`, the filter will
-// redirect the request to `some-url`.
+// redirect the request to `some-url` with 307. Use TrailingSlash for other
+// modes or status codes.
 func RedirectTrailingSlash() Filter {
 	return func(resp http.ResponseWriter, req *http.Request) bool {
 		path := req.URL.Path
 		if len(path) > 1 && path[len(path)-1] == '/' {
 			req.URL.Path = strings.TrimRight(path, "/")
+			collapseLeadingSlashes(req.URL)
 			// Redirect to path without trailing slash.
 			http.Redirect(resp, req, req.URL.String(), http.StatusTemporaryRedirect)
 			return false
@@ -50,6 +53,79 @@ func RedirectTrailingSlash() Filter {
 	}
 }
 
+// TrailingSlashMode decides how requests with trailing slashes are handled.
+type TrailingSlashMode string
+
+const (
+	// TrailingSlashStrict treats `some-url/` and `some-url` as different paths.
+	TrailingSlashStrict TrailingSlashMode = "Strict"
+	// TrailingSlashRedirect redirects `some-url/` to `some-url`. GET and HEAD
+	// requests are redirected with 301, and others are redirected with 308 so
+	// that clients keep methods and bodies.
+	TrailingSlashRedirect TrailingSlashMode = "Redirect"
+	// TrailingSlashLenient serves `some-url/` as `some-url` without redirecting.
+	TrailingSlashLenient TrailingSlashMode = "Lenient"
+)
+
+// TrailingSlash returns a filter to handle trailing slashes by mode. Query
+// strings are kept in redirections. It panics if mode is unknown.
+func TrailingSlash(mode TrailingSlashMode) Filter {
+	switch mode {
+	case TrailingSlashStrict:
+		return func(resp http.ResponseWriter, req *http.Request) bool {
+			return true
+		}
+	case TrailingSlashRedirect:
+		return func(resp http.ResponseWriter, req *http.Request) bool {
+			if !trimTrailingSlash(req.URL) {
+				return true
+			}
+			collapseLeadingSlashes(req.URL)
+			code := http.StatusPermanentRedirect
+			if req.Method == http.MethodGet || req.Method == http.MethodHead {
+				code = http.StatusMovedPermanently
+			}
+			http.Redirect(resp, req, req.URL.String(), code)
+			return false
+		}
+	case TrailingSlashLenient:
+		return func(resp http.ResponseWriter, req *http.Request) bool {
+			trimTrailingSlash(req.URL)
+			return true
+		}
+	}
+	panic(fmt.Sprintf("unknown trailing slash mode %q", mode))
+}
+
+// trimTrailingSlash removes trailing slashes of u's path. It returns false if
+// the path has no trailing slash.
+func trimTrailingSlash(u *url.URL) bool {
+	path := u.Path
+	if len(path) <= 1 || path[len(path)-1] != '/' {
+		return false
+	}
+	u.Path = strings.TrimRight(path, "/")
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	if u.RawPath != "" {
+		u.RawPath = strings.TrimRight(u.RawPath, "/")
+	}
+	return true
+}
+
+// collapseLeadingSlashes collapses leading slashes of u's path to one. Paths
+// like "//evil.com" are protocol-relative urls in "Location", which would
+// redirect clients to other hosts.
+func collapseLeadingSlashes(u *url.URL) {
+	if strings.HasPrefix(u.Path, "//") {
+		u.Path = "/" + strings.TrimLeft(u.Path, "/")
+	}
+	if strings.HasPrefix(u.RawPath, "//") {
+		u.RawPath = "/" + strings.TrimLeft(u.RawPath, "/")
+	}
+}
+
 // FillLeadingSlash returns a pseudo filter to fill a leading slash when
 // a request path does not have a leading slash.
 // The filter won't filter anything.
@@ -94,6 +170,68 @@ func ParseRequestForm() Filter {
 	return ParseRequestFormWithMaxMemory(32 << 20)
 }
 
+const (
+	// DefaultMaxHeaderBytes is the default max size of request headers. It's the
+	// same as http.DefaultMaxHeaderBytes.
+	DefaultMaxHeaderBytes = http.DefaultMaxHeaderBytes
+	// DefaultMaxURLLength is the default max length of request uris. It's the
+	// common limit of servers and proxies.
+	DefaultMaxURLLength = 8 << 10
+)
+
+// LimitRequestSize returns a filter which rejects requests whose uris are longer
+// than maxURLLength with 414, and requests whose headers are larger than
+// maxHeaderBytes with 431. Errors are written as other errors before routing, so
+// clients get structured messages rather than truncated requests. Like
+// http.Server, the size of headers contains the request line and lines of
+// header keys and values. Zero means the default limit, and negative values
+// disable the limit.
+//
+// Set http.Server.MaxHeaderBytes to the same limit. The server closes requests
+// which exceed its limit (with 4KB slack) before they reach the filter.
+func LimitRequestSize(maxHeaderBytes, maxURLLength int) Filter {
+	if maxHeaderBytes == 0 {
+		maxHeaderBytes = DefaultMaxHeaderBytes
+	}
+	if maxURLLength == 0 {
+		maxURLLength = DefaultMaxURLLength
+	}
+	return func(resp http.ResponseWriter, req *http.Request) bool {
+		uri := req.RequestURI
+		if uri == "" {
+			uri = req.URL.RequestURI()
+		}
+		var err error
+		switch {
+		case maxURLLength > 0 && len(uri) > maxURLLength:
+			err = requestURITooLong.Error(maxURLLength)
+		case maxHeaderBytes > 0 && headerSize(req, uri) > maxHeaderBytes:
+			err = requestHeaderTooLarge.Error(maxHeaderBytes)
+		default:
+			return true
+		}
+		// Errors of writing responses can't be handled by filters.
+		_ = WriteError(NewHTTPContext(resp, req), AllProducers(), err)
+		return false
+	}
+}
+
+// headerSize returns the size of the request line and headers of req.
+func headerSize(req *http.Request, uri string) int {
+	// Like "GET /path HTTP/1.1\r\n".
+	size := len(req.Method) + len(uri) + len(req.Proto) + 4
+	if req.Host != "" {
+		// Header "Host" is removed from req.Header.
+		size += len("Host: \r\n") + len(req.Host)
+	}
+	for key, values := range req.Header {
+		for _, value := range values {
+			size += len(key) + len(value) + len(": \r\n")
+		}
+	}
+	return size
+}
+
 // isGTZero returns a boolean result indicating if the content length is greater than 0.
 func isGTZero(length string) bool {
 	if length == "" {
@@ -117,15 +255,28 @@ func ContentType(req *http.Request) (string, error) {
 		}
 		return definition.MIMENone, nil
 	}
+	// Parameters like "charset" and "boundary" are ignored. The boundary of
+	// multipart requests is parsed by http.Request when reading forms.
 	result, _, err := mime.ParseMediaType(ct)
-	if err != nil {
+	if err != nil && err != mime.ErrInvalidMediaParameter {
 		return "", invalidContentType.Error(ct)
 	}
 	return result, nil
 }
 
+// MediaType returns the media type of a content type or an accept type without
+// parameters, such as "application/json" for "application/json; charset=utf-8".
+// The result is in lower case.
+func MediaType(v string) string {
+	if index := strings.IndexByte(v, ';'); index >= 0 {
+		v = v[:index]
+	}
+	return strings.ToLower(strings.TrimSpace(v))
+}
+
 // AcceptTypes is a util to get accept types from a request.
-// Accept types are sorted by q.
+// Accept types are sorted by q and then by specificity. Use AcceptRanges to get
+// their qualities.
 func AcceptTypes(req *http.Request) ([]string, error) {
 	ct := req.Header.Get("Accept")
 	if ct == "" {
@@ -134,13 +285,40 @@ func AcceptTypes(req *http.Request) ([]string, error) {
 	return parseAcceptTypes(ct)
 }
 
-type acceptType struct {
-	name       string
-	preference float64
+// AcceptLanguages returns languages in header "Accept-Language" of a request.
+// Languages are sorted by q, and wildcards and invalid values are ignored.
+func AcceptLanguages(req *http.Request) []string {
+	v := req.Header.Get("Accept-Language")
+	if v == "" {
+		return nil
+	}
+	languages, err := parseAcceptTypes(v)
+	if err != nil {
+		return nil
+	}
+	result := languages[:0]
+	for _, language := range languages {
+		if language != "" && language != "*" {
+			result = append(result, language)
+		}
+	}
+	return result
 }
 
 func parseAcceptTypes(v string) ([]string, error) {
-	var types []acceptType
+	ranges, err := parseAcceptRanges(v)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]string, 0, len(ranges))
+	for _, r := range ranges {
+		ret = append(ret, r.Name)
+	}
+	return ret, nil
+}
+
+func parseAcceptRanges(v string) ([]AcceptRange, error) {
+	var types []AcceptRange
 	strs := strings.Split(v, ",")
 	for _, str := range strs {
 		fields := strings.Split(str, ";")
@@ -170,17 +348,16 @@ func parseAcceptTypes(v string) ([]string, error) {
 				ctFields = append(ctFields, fmt.Sprintf("%s=%s", key, value))
 			}
 		}
-		types = append(types, acceptType{
-			name:       strings.Join(ctFields, ";"),
-			preference: factor,
+		types = append(types, AcceptRange{
+			Name:    strings.Join(ctFields, ";"),
+			Quality: factor,
 		})
 	}
-	sort.Slice(types, func(i, j int) bool {
-		return types[i].preference > types[j].preference
+	sort.SliceStable(types, func(i, j int) bool {
+		if types[i].Quality != types[j].Quality {
+			return types[i].Quality > types[j].Quality
+		}
+		return types[i].Specificity() > types[j].Specificity()
 	})
-	var ret []string
-	for _, t := range types {
-		ret = append(ret, t.name)
-	}
-	return ret, nil
+	return types, nil
 }
@@ -17,8 +17,14 @@ limitations under the License.
 package service
 
 import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/caicloud/nirvana/definition"
 )
 
 func TestParseAcceptTypes(t *testing.T) {
@@ -49,3 +55,169 @@ func TestParseAcceptTypes(t *testing.T) {
 		}
 	}
 }
+
+func TestContentType(t *testing.T) {
+	cts := []struct {
+		ct     string
+		result string
+	}{
+		{"application/json", definition.MIMEJSON},
+		{"application/json; charset=utf-8", definition.MIMEJSON},
+		{`Application/JSON; charset="utf-8"; foo=bar`, definition.MIMEJSON},
+		{`multipart/form-data; charset=utf-8; boundary="a;b"`, definition.MIMEFormData},
+		{"text/plain; charset", definition.MIMEText},
+	}
+	for _, ct := range cts {
+		req, _ := http.NewRequest("POST", "/", nil)
+		req.Header.Set("Content-Type", ct.ct)
+		result, err := ContentType(req)
+		if err != nil {
+			t.Fatalf("Can't get content type from %s: %v", ct.ct, err)
+		}
+		if result != ct.result {
+			t.Fatalf("Content type of %s is %s, but want %s", ct.ct, result, ct.result)
+		}
+	}
+	req, _ := http.NewRequest("POST", "/", nil)
+	req.Header.Set("Content-Type", "application/")
+	if _, err := ContentType(req); err == nil {
+		t.Fatal("Invalid content type should be rejected")
+	}
+}
+
+func TestChooseProducer(t *testing.T) {
+	producers := []Producer{NewSimpleSerializer(definition.MIMEText), &JSONSerializer{}}
+	ats, err := parseAcceptTypes(`application/json; charset="utf-8"; q=0.9, text/html`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := ChooseProducer(ats, producers); p == nil || p.ContentType() != definition.MIMEJSON {
+		t.Fatalf("Producer is not chosen by media type: %v", p)
+	}
+}
+
+func TestTrailingSlash(t *testing.T) {
+	tests := []struct {
+		mode     TrailingSlashMode
+		method   string
+		url      string
+		passed   bool
+		code     int
+		location string
+		path     string
+	}{
+		{TrailingSlashStrict, http.MethodGet, "/api/users/?page=1", true, 0, "", "/api/users/"},
+		{TrailingSlashStrict, http.MethodPost, "/api/users/", true, 0, "", "/api/users/"},
+		{TrailingSlashRedirect, http.MethodGet, "/api/users/?page=1", false, http.StatusMovedPermanently, "/api/users?page=1", ""},
+		{TrailingSlashRedirect, http.MethodPost, "/api/users//?page=1", false, http.StatusPermanentRedirect, "/api/users?page=1", ""},
+		{TrailingSlashRedirect, http.MethodPost, "/api/users", true, 0, "", "/api/users"},
+		{TrailingSlashRedirect, http.MethodGet, "/", true, 0, "", "/"},
+		// Redirections never go to other hosts.
+		{TrailingSlashRedirect, http.MethodGet, "//evil.com/", false, http.StatusMovedPermanently, "/evil.com", ""},
+		{TrailingSlashRedirect, http.MethodGet, "///evil.com//?a=b", false, http.StatusMovedPermanently, "/evil.com?a=b", ""},
+		{TrailingSlashRedirect, http.MethodGet, "//", false, http.StatusMovedPermanently, "/", ""},
+		{TrailingSlashLenient, http.MethodGet, "/api/users/?page=1", true, 0, "", "/api/users"},
+		{TrailingSlashLenient, http.MethodPost, "/api/users/", true, 0, "", "/api/users"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.url, nil)
+		resp := httptest.NewRecorder()
+		passed := TrailingSlash(test.mode)(resp, req)
+		if passed != test.passed {
+			t.Fatalf("%s %s %s: filter result should be %v", test.mode, test.method, test.url, test.passed)
+		}
+		if !passed {
+			if resp.Code != test.code || resp.Header().Get("Location") != test.location {
+				t.Fatalf("%s %s %s: redirection should be %d %s, but got: %d %s", test.mode, test.method, test.url,
+					test.code, test.location, resp.Code, resp.Header().Get("Location"))
+			}
+			continue
+		}
+		if req.URL.Path != test.path {
+			t.Fatalf("%s %s %s: path should be %s, but got: %s", test.mode, test.method, test.url, test.path, req.URL.Path)
+		}
+	}
+}
+
+func TestRedirectTrailingSlash(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "//evil.com/", nil)
+	resp := httptest.NewRecorder()
+	if RedirectTrailingSlash()(resp, req) || resp.Code != http.StatusTemporaryRedirect || resp.Header().Get("Location") != "/evil.com" {
+		t.Fatalf("Redirection should stay on the host: %d %s", resp.Code, resp.Header().Get("Location"))
+	}
+}
+
+func TestLimitRequestSize(t *testing.T) {
+	long := strings.Repeat("a", 200)
+	tests := []struct {
+		maxHeaderBytes int
+		maxURLLength   int
+		url            string
+		header         string
+		code           int
+		reason         string
+	}{
+		{0, 0, "/api?q=" + long, long, 0, ""},
+		{0, 100, "/api?q=" + long, "", http.StatusRequestURITooLong, "Nirvana:Service:RequestURITooLong"},
+		{300, 0, "/api", long + long, http.StatusRequestHeaderFieldsTooLarge, "Nirvana:Service:RequestHeaderTooLarge"},
+		// The request line is a part of headers.
+		{300, 0, "/api?q=" + long + long, "", http.StatusRequestHeaderFieldsTooLarge, "Nirvana:Service:RequestHeaderTooLarge"},
+		{300, 0, "/api", long, 0, ""},
+		{-1, -1, "/api?q=" + long, long + long, 0, ""},
+	}
+	for i, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, test.url, nil)
+		req.Header.Set("Accept", definition.MIMEJSON)
+		if test.header != "" {
+			req.Header.Set("X-Data", test.header)
+		}
+		resp := httptest.NewRecorder()
+		passed := LimitRequestSize(test.maxHeaderBytes, test.maxURLLength)(resp, req)
+		if passed != (test.code == 0) {
+			t.Fatalf("Test %d: filter result should be %v, but got: %d %s", i, test.code == 0, resp.Code, resp.Body.String())
+		}
+		if passed {
+			continue
+		}
+		msg := struct {
+			Reason string `json:"reason"`
+		}{}
+		if resp.Code != test.code || json.Unmarshal(resp.Body.Bytes(), &msg) != nil || msg.Reason != test.reason {
+			t.Fatalf("Test %d: response should be %d %s, but got: %d %s", i, test.code, test.reason, resp.Code, resp.Body.String())
+		}
+	}
+
+	// Requests of a real server.
+	filter := LimitRequestSize(2048, 1024)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if filter(w, r) {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	server.Config.MaxHeaderBytes = 2048
+	server.Start()
+	defer server.Close()
+	for _, test := range []struct {
+		query  string
+		header string
+		code   int
+	}{
+		{"q=a", "b", http.StatusNoContent},
+		{"q=" + strings.Repeat("a", 1024), "b", http.StatusRequestURITooLong},
+		{"q=a", strings.Repeat("b", 2048), http.StatusRequestHeaderFieldsTooLarge},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/api?"+test.query, nil)
+		req.Header.Set("X-Data", test.header)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.code {
+			t.Fatalf("Response code should be %d, but got: %d", test.code, resp.StatusCode)
+		}
+		if test.code != http.StatusNoContent && resp.Header.Get("Content-Type") != definition.MIMEJSON {
+			t.Fatalf("Errors should be structured, but got: %s", resp.Header.Get("Content-Type"))
+		}
+	}
+}
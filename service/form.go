@@ -0,0 +1,178 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"github.com/caicloud/nirvana/definition"
+)
+
+// consumeForm parses url encoded data from r and fills struct which v points to.
+func consumeForm(r io.Reader, v interface{}) error {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Struct {
+		return invalidTypeForConsumer.Error(definition.MIMEURLEncoded, reflect.TypeOf(v))
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	values, err := url.ParseQuery(string(data))
+	if err != nil {
+		return invalidBody.Error(definition.MIMEURLEncoded, err)
+	}
+	return decodeForm(values, "", value.Elem())
+}
+
+// decodeForm fills fields of struct v by values. The key of a field is the name
+// in tag "form", or the field name if there is no tag. A field with tag "-" is
+// ignored. Keys of fields in nested structs are joined by dots, such as
+// "address.city". Fields of embedded structs without tags are promoted.
+// Repeated keys are converted to slices. Missing keys keep fields unchanged, and
+// unknown keys are ignored.
+func decodeForm(values url.Values, prefix string, v reflect.Value) error {
+	typ := v.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			// Unexported.
+			continue
+		}
+		tag := field.Tag.Get("form")
+		if tag == "-" {
+			continue
+		}
+		name := tag
+		if idx := strings.Index(name, ","); idx >= 0 {
+			name = name[:idx]
+		}
+		fieldValue := v.Field(i)
+		if field.Anonymous && name == "" && indirectType(field.Type).Kind() == reflect.Struct {
+			if field.PkgPath != "" && field.Type.Kind() == reflect.Ptr {
+				// Pointers of unexported embedded structs can't be allocated.
+				continue
+			}
+			if err := decodeStructField(values, prefix, fieldValue); err != nil {
+				return err
+			}
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		key := prefix + name
+		if err := decodeFormField(values, key, fieldValue); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeFormField fills v by values of key.
+func decodeFormField(values url.Values, key string, v reflect.Value) error {
+	typ := v.Type()
+	data := removeEmptyStrings(values[key])
+	if converter := ConverterFor(typ); converter != nil {
+		if len(data) <= 0 {
+			return nil
+		}
+		return convertFormField(converter, key, data, v)
+	}
+	if typ.Kind() == reflect.Slice {
+		converter := ConverterFor(typ.Elem())
+		if converter == nil {
+			return invalidTypeForConsumer.Error(definition.MIMEURLEncoded, typ)
+		}
+		if len(data) <= 0 {
+			return nil
+		}
+		slice := reflect.MakeSlice(typ, len(data), len(data))
+		for i, d := range data {
+			if err := convertFormField(converter, key, []string{d}, slice.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+		return nil
+	}
+	if indirectType(typ).Kind() == reflect.Struct {
+		return decodeStructField(values, key+".", v)
+	}
+	return invalidTypeForConsumer.Error(definition.MIMEURLEncoded, typ)
+}
+
+// decodeStructField fills a struct or a pointer to struct. A nil pointer is only
+// allocated if there are values for the struct.
+func decodeStructField(values url.Values, prefix string, v reflect.Value) error {
+	if v.Kind() != reflect.Ptr {
+		return decodeForm(values, prefix, v)
+	}
+	if v.IsNil() {
+		if !hasFormPrefix(values, prefix) {
+			return nil
+		}
+		v.Set(reflect.New(v.Type().Elem()))
+	}
+	return decodeForm(values, prefix, v.Elem())
+}
+
+func convertFormField(converter Converter, key string, data []string, v reflect.Value) error {
+	result, err := converter(context.Background(), data)
+	if err != nil {
+		return invalidFormField.Error(key, err)
+	}
+	if result == nil {
+		return nil
+	}
+	v.Set(reflect.ValueOf(result))
+	return nil
+}
+
+func hasFormPrefix(values url.Values, prefix string) bool {
+	for key := range values {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func indirectType(typ reflect.Type) reflect.Type {
+	if typ.Kind() == reflect.Ptr {
+		return typ.Elem()
+	}
+	return typ
+}
+
+func removeEmptyStrings(values []string) []string {
+	results := make([]string, 0, len(values))
+	for _, value := range values {
+		if value != "" {
+			results = append(results, value)
+		}
+	}
+	return results
+}
@@ -17,12 +17,18 @@ limitations under the License.
 package service
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"io"
+	"mime"
 	"net/http"
+	"path/filepath"
 	"reflect"
 	"strings"
 
 	"github.com/caicloud/nirvana/definition"
+	"github.com/caicloud/nirvana/errors"
 )
 
 // Error is a common interface for error.
@@ -70,9 +76,12 @@ type DestinationHandler interface {
 }
 
 var handlers = map[definition.Destination]DestinationHandler{
-	definition.Meta:  &MetaDestinationHandler{},
-	definition.Data:  &DataDestinationHandler{},
-	definition.Error: &ErrorDestinationHandler{},
+	definition.Meta:       &MetaDestinationHandler{},
+	definition.Data:       &DataDestinationHandler{},
+	definition.Error:      &ErrorDestinationHandler{},
+	definition.Stream:     &StreamDestinationHandler{},
+	definition.Attachment: &AttachmentDestinationHandler{},
+	definition.Redirect:   &RedirectDestinationHandler{},
 }
 
 // DestinationHandlerFor gets a type handler for specified type.
@@ -86,7 +95,8 @@ func RegisterDestinationHandler(handler DestinationHandler) error {
 	return nil
 }
 
-// MetaDestinationHandler writes metadata to http.ResponseWriter.Header and value type should be map[string]string.
+// MetaDestinationHandler writes metadata to http.ResponseWriter.Header and value type should be map[string]string
+// or http.Header. Values in http.Header are emitted as repeated headers.
 // If value type is not map, the handler will stop the handlers chain and return an error.
 // If there is no error, it always expect that the next handler goes on.
 type MetaDestinationHandler struct{}
@@ -105,12 +115,32 @@ func (h *MetaDestinationHandler) Handle(ctx context.Context, producers []Produce
 	if value == nil {
 		return true, nil
 	}
-	if values, ok := value.(map[string]string); ok {
-		headers := HTTPContextFrom(ctx).ResponseWriter().Header()
+	headers := HTTPContextFrom(ctx).ResponseWriter().Header()
+	switch values := value.(type) {
+	case map[string]string:
 		for key, value := range values {
 			headers.Set(key, value)
 		}
 		return true, nil
+	case http.Header:
+		for key, vs := range values {
+			headers.Del(key)
+			for _, v := range vs {
+				headers.Add(key, v)
+			}
+		}
+		return true, nil
+	case []definition.Preload:
+		resp := HTTPContextFrom(ctx).ResponseWriter()
+		pusher, _ := resp.(http.Pusher)
+		for _, p := range values {
+			headers.Add("Link", p.String())
+			if pusher != nil && !p.NoPush {
+				// Push is best-effort. It fails if clients disable it.
+				_ = pusher.Push(p.URL, nil)
+			}
+		}
+		return true, nil
 	}
 	return false, invalidMetaType.Error(reflect.TypeOf(value))
 }
@@ -158,16 +188,449 @@ func (h *ErrorDestinationHandler) Handle(ctx context.Context, producers []Produc
 	return false, WriteError(ctx, producers, value)
 }
 
-// WriteError writes error data to context.
+// StreamDestinationHandler writes chunks from an io.Reader, a receive channel or a
+// definition.Iterator to http.ResponseWriter and flushes every chunk immediately.
+// Items from channels and iterators are written by the negotiated producer:
+//  - "text/event-stream": every item is written as a server-sent event.
+//  - "application/x-ndjson": every item is written as a line of json.
+//  - "application/json": items are written as elements of a json array.
+// The handler stops when the source is drained or the request context is done.
+// So API handlers which send values to a channel should also watch ctx.Done().
+type StreamDestinationHandler struct{}
+
+// Destination returns definition.Destination which the destination handler can handle.
+func (h *StreamDestinationHandler) Destination() definition.Destination { return definition.Stream }
+
+// Priority returns priority of the type handler.
+func (h *StreamDestinationHandler) Priority() int { return LowPriority }
+
+// Validate validates whether the type handler can handle the target type.
+func (h *StreamDestinationHandler) Validate(target reflect.Type) error {
+	if target.Implements(reflect.TypeOf((*io.Reader)(nil)).Elem()) {
+		return nil
+	}
+	if target.Kind() == reflect.Chan && target.ChanDir()&reflect.RecvDir != 0 {
+		return nil
+	}
+	if target.Implements(reflect.TypeOf((*definition.Iterator)(nil)).Elem()) {
+		return nil
+	}
+	return invalidStreamType.Error(target)
+}
+
+// Handle handles a value. If the handler has something wrong, it should return an error.
+func (h *StreamDestinationHandler) Handle(ctx context.Context, producers []Producer, code int, value interface{}) (goon bool, err error) {
+	if value == nil {
+		return true, nil
+	}
+	httpCtx := HTTPContextFrom(ctx)
+	producer, err := Negotiate(httpCtx.Request(), producers)
+	if err != nil {
+		return false, err
+	}
+	if producer == nil {
+		return false, NoProducerToWrite.Error(acceptHeader(httpCtx.Request()))
+	}
+	w := &streamWriter{
+		resp:     httpCtx.ResponseWriter(),
+		producer: producer,
+		event:    producer.ContentType() == definition.MIMEEventStream,
+		lines:    producer.ContentType() == definition.MIMENDJSON,
+	}
+	if w.resp.HeaderWritable() {
+		headers := w.resp.Header()
+		if strings.TrimSpace(headers.Get("Content-Type")) == "" {
+			headers.Set("Content-Type", producer.ContentType())
+		}
+		if w.event {
+			headers.Set("Cache-Control", "no-cache")
+		}
+		headers.Del("Content-Length")
+		if req := httpCtx.Request(); req.ProtoMajor == 1 && req.ProtoMinor == 1 {
+			headers.Set("Transfer-Encoding", "chunked")
+		}
+		w.resp.WriteHeader(code)
+	}
+	if r, ok := value.(io.Reader); ok {
+		return false, w.copy(ctx, r)
+	}
+	if producer.ContentType() == definition.MIMEJSON {
+		w.array = true
+		defer func() {
+			if e := w.close(); e != nil && err == nil {
+				err = e
+			}
+		}()
+	}
+	if it, ok := value.(definition.Iterator); ok {
+		return false, w.iterate(ctx, it)
+	}
+	return false, w.drain(ctx, reflect.ValueOf(value))
+}
+
+// AttachmentDestinationHandler writes a file to http.ResponseWriter for downloading.
+// If the content of file is an io.ReadSeeker, range and conditional requests are
+// handled by http.ServeContent.
+type AttachmentDestinationHandler struct{}
+
+// Destination returns definition.Destination which the destination handler can handle.
+func (h *AttachmentDestinationHandler) Destination() definition.Destination {
+	return definition.Attachment
+}
+
+// Priority returns priority of the type handler.
+func (h *AttachmentDestinationHandler) Priority() int { return LowPriority }
+
+// Validate validates whether the type handler can handle the target type.
+func (h *AttachmentDestinationHandler) Validate(target reflect.Type) error {
+	typ := reflect.TypeOf(definition.FileContent{})
+	if target == typ || target == reflect.PtrTo(typ) {
+		return nil
+	}
+	return invalidAttachmentType.Error(target)
+}
+
+// Handle handles a value. If the handler has something wrong, it should return an error.
+func (h *AttachmentDestinationHandler) Handle(ctx context.Context, producers []Producer, code int, value interface{}) (goon bool, err error) {
+	var file definition.FileContent
+	switch v := value.(type) {
+	case definition.FileContent:
+		file = v
+	case *definition.FileContent:
+		if v == nil {
+			return true, nil
+		}
+		file = *v
+	default:
+		return true, nil
+	}
+	if file.Content == nil {
+		return true, nil
+	}
+	if closer, ok := file.Content.(io.Closer); ok {
+		defer func() {
+			if e := closer.Close(); e != nil && err == nil {
+				err = e
+			}
+		}()
+	}
+	httpCtx := HTTPContextFrom(ctx)
+	resp := httpCtx.ResponseWriter()
+	if !resp.HeaderWritable() {
+		_, err = io.Copy(resp, file.Content)
+		return false, err
+	}
+	contentType := file.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(file.Name))
+	}
+	if contentType == "" {
+		contentType = definition.MIMEOctetStream
+	}
+	headers := resp.Header()
+	headers.Set("Content-Type", contentType)
+	disposition := "attachment"
+	if file.Name != "" {
+		if d := mime.FormatMediaType("attachment", map[string]string{"filename": file.Name}); d != "" {
+			disposition = d
+		}
+	}
+	headers.Set("Content-Disposition", disposition)
+	if seeker, ok := file.Content.(io.ReadSeeker); ok {
+		http.ServeContent(resp, httpCtx.Request(), file.Name, file.ModTime, seeker)
+		return false, nil
+	}
+	resp.WriteHeader(code)
+	_, err = io.Copy(resp, file.Content)
+	return false, err
+}
+
+// RedirectDestinationHandler writes a redirection to http.ResponseWriter. The
+// location is written to header "Location" with the status code of redirection,
+// and the body is empty. Redirections are handled after meta and before data,
+// so headers in meta results (such as "Set-Cookie") are kept, and data results
+// are written only if there is no redirection.
+type RedirectDestinationHandler struct{}
+
+// Destination returns definition.Destination which the destination handler can handle.
+func (h *RedirectDestinationHandler) Destination() definition.Destination {
+	return definition.Redirect
+}
+
+// Priority returns priority of the type handler.
+func (h *RedirectDestinationHandler) Priority() int { return LowPriority - 1 }
+
+// Validate validates whether the type handler can handle the target type.
+func (h *RedirectDestinationHandler) Validate(target reflect.Type) error {
+	typ := reflect.TypeOf(definition.Redirection{})
+	if target == typ || target == reflect.PtrTo(typ) {
+		return nil
+	}
+	return invalidRedirectionType.Error(target)
+}
+
+// Handle handles a value. If the handler has something wrong, it should return an error.
+func (h *RedirectDestinationHandler) Handle(ctx context.Context, producers []Producer, code int, value interface{}) (goon bool, err error) {
+	var redirection definition.Redirection
+	switch v := value.(type) {
+	case definition.Redirection:
+		redirection = v
+	case *definition.Redirection:
+		if v == nil {
+			return true, nil
+		}
+		redirection = *v
+	default:
+		return true, nil
+	}
+	if redirection.Location == "" {
+		return true, nil
+	}
+	switch redirection.Code {
+	case 0:
+		redirection.Code = http.StatusFound
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return false, invalidRedirectCode.Error(redirection.Code)
+	}
+	resp := HTTPContextFrom(ctx).ResponseWriter()
+	if !resp.HeaderWritable() {
+		return false, nil
+	}
+	resp.Header().Set("Location", redirection.Location)
+	resp.WriteHeader(redirection.Code)
+	return false, nil
+}
+
+type streamWriter struct {
+	resp     ResponseWriter
+	producer Producer
+	// event writes items as server-sent events.
+	event bool
+	// lines writes items as lines of json.
+	lines bool
+	// array writes items as elements of a json array.
+	array bool
+	// count is the number of written items.
+	count int
+}
+
+// copy reads chunks from r and writes them until r is drained or ctx is done.
+func (w *streamWriter) copy(ctx context.Context, r io.Reader) error {
+	buf := make([]byte, 32*1024)
+	for ctx.Err() == nil {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if e := w.write(buf[:n]); e != nil {
+				return e
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// drain receives values from ch and writes them until ch is closed or ctx is done.
+func (w *streamWriter) drain(ctx context.Context, ch reflect.Value) error {
+	cases := []reflect.SelectCase{
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())},
+		{Dir: reflect.SelectRecv, Chan: ch},
+	}
+	for ctx.Err() == nil {
+		chosen, v, ok := reflect.Select(cases)
+		if chosen == 0 || !ok {
+			return nil
+		}
+		if err := w.writeValue(v.Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// iterate gets items from it and writes them until it is drained or ctx is done.
+func (w *streamWriter) iterate(ctx context.Context, it definition.Iterator) error {
+	for ctx.Err() == nil {
+		v, err := it.Next(ctx)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := w.writeValue(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// close ends the json array in array mode.
+func (w *streamWriter) close() error {
+	if !w.array {
+		return nil
+	}
+	end := "]"
+	if w.count <= 0 {
+		end = "[]"
+	}
+	return w.write([]byte(end))
+}
+
+// writeValue writes an item. Only written items are counted, so the json array
+// is opened by the first item which is written successfully.
+func (w *streamWriter) writeValue(v interface{}) error {
+	if err := w.produceValue(v); err != nil {
+		return err
+	}
+	w.count++
+	return nil
+}
+
+func (w *streamWriter) produceValue(v interface{}) error {
+	if w.lines || w.array {
+		buf := bytes.NewBuffer(nil)
+		if w.array {
+			if w.count <= 0 {
+				buf.WriteByte('[')
+			} else {
+				buf.WriteByte(',')
+			}
+		}
+		// Raw strings are quoted too, so the encoder is used instead of the producer.
+		if err := json.NewEncoder(buf).Encode(v); err != nil {
+			return err
+		}
+		data := buf.Bytes()
+		if w.array {
+			data = bytes.TrimRight(data, "\n")
+		}
+		return w.write(data)
+	}
+	if !w.event {
+		if err := w.producer.Produce(w.resp, v); err != nil {
+			return err
+		}
+		w.flush()
+		return nil
+	}
+	switch data := v.(type) {
+	case string:
+		return w.write([]byte(data))
+	case []byte:
+		return w.write(data)
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := ProducerFor(definition.MIMEJSON).Produce(buf, v); err != nil {
+		return err
+	}
+	return w.write(bytes.TrimRight(buf.Bytes(), "\n"))
+}
+
+// write writes a chunk and flushes it. In event mode, a chunk is written as an event.
+func (w *streamWriter) write(chunk []byte) error {
+	if w.event {
+		event := bytes.NewBuffer(nil)
+		for _, line := range bytes.Split(bytes.TrimRight(chunk, "\n"), []byte("\n")) {
+			event.WriteString("data: ")
+			event.Write(line)
+			event.WriteByte('\n')
+		}
+		event.WriteByte('\n')
+		chunk = event.Bytes()
+	}
+	if _, err := w.resp.Write(chunk); err != nil {
+		return err
+	}
+	w.flush()
+	return nil
+}
+
+func (w *streamWriter) flush() {
+	if f, ok := w.resp.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// ErrorSerializer writes errors for a specific content type. It replaces the default
+// behavior which produces the message of an error by the producer of the content type.
+type ErrorSerializer interface {
+	// ContentType returns a HTTP MIME type.
+	ContentType() string
+	// Serialize writes err to w. code is the status code of the error and it has
+	// been written to response by the time Serialize is called.
+	Serialize(w io.Writer, code int, err interface{}) error
+}
+
+var errorSerializers = map[string]ErrorSerializer{
+	definition.MIMEProblemJSON: &ProblemSerializer{},
+}
+
+// ErrorSerializerFor gets an error serializer for specified content type.
+func ErrorSerializerFor(contentType string) ErrorSerializer {
+	return errorSerializers[contentType]
+}
+
+// RegisterErrorSerializer registers an error serializer. An error serializer must not handle "*/*".
+func RegisterErrorSerializer(s ErrorSerializer) error {
+	if s.ContentType() == definition.MIMEAll {
+		return invalidErrorSerializer.Error(definition.MIMEAll)
+	}
+	errorSerializers[s.ContentType()] = s
+	return nil
+}
+
+var messageCatalog *errors.Catalog
+
+// SetMessageCatalog sets a catalog to localize error messages. Messages of errors
+// created by error factories (including errors of ParameterErrors) are localized
+// by languages in header "Accept-Language" of requests. Nil disables localization.
+func SetMessageCatalog(catalog *errors.Catalog) {
+	messageCatalog = catalog
+}
+
+// localize localizes err by the message catalog.
+func localize(req *http.Request, err interface{}) interface{} {
+	catalog := messageCatalog
+	if catalog == nil {
+		return err
+	}
+	languages := AcceptLanguages(req)
+	switch e := err.(type) {
+	case ParameterErrors:
+		localized := make(ParameterErrors, len(e))
+		for i, fe := range e {
+			if msg, ok := catalog.Format(errors.Reason(fe.Reason), fe.Data, languages...); ok {
+				fe.Message = msg
+			}
+			localized[i] = fe
+		}
+		return localized
+	case error:
+		return catalog.Localize(e, languages...)
+	}
+	return err
+}
+
+// WriteError writes error data to context. If there is an error serializer for
+// the chosen content type, the error is written by the serializer. Error messages
+// are localized if there is a message catalog.
 func WriteError(ctx context.Context, producers []Producer, err interface{}) error {
 	httpCtx := HTTPContextFrom(ctx)
-	ats, e := AcceptTypes(httpCtx.Request())
+	if len(producers) <= 0 {
+		return NoProducerToWrite.Error(acceptHeader(httpCtx.Request()))
+	}
+	producer, e := Negotiate(httpCtx.Request(), producers)
 	if e != nil {
 		return e
 	}
-	if len(producers) <= 0 {
-		return NoProducerToWrite.Error(ats)
-	}
+	err = localize(httpCtx.Request(), err)
 	code := http.StatusInternalServerError
 	var msg interface{}
 	switch e := err.(type) {
@@ -180,36 +643,53 @@ func WriteError(ctx context.Context, producers []Producer, err interface{}) erro
 		msg = err
 	}
 
-	producer := ChooseProducer(ats, producers)
 	if producer == nil {
 		// Choose the first producer
 		producer = producers[0]
 	}
+	if p, ok := producer.(RequestAwareProducer); ok {
+		producer = p.ForRequest(httpCtx.Request())
+	}
 	resp := httpCtx.ResponseWriter()
 	if resp.HeaderWritable() {
 		// Error always has highest priority. So it can override "Content-Type".
 		resp.Header().Set("Content-Type", producer.ContentType())
 		resp.WriteHeader(code)
 	}
+	if serializer := ErrorSerializerFor(producer.ContentType()); serializer != nil {
+		return serializer.Serialize(resp, code, err)
+	}
 	return producer.Produce(resp, msg)
 }
 
 // WriteData chooses right producer by "Accrpt" header and writes data to context.
+// If code is 204 or 304, only the status code is written. A successful response
+// to a GET or HEAD request is replaced by 304 if the "ETag" header of response
+// matches the "If-None-Match" header of request.
 // You should never call the function except you are writing a type handler.
 func WriteData(ctx context.Context, producers []Producer, code int, data interface{}) error {
 	httpCtx := HTTPContextFrom(ctx)
-	ats, err := AcceptTypes(httpCtx.Request())
+	producer, err := Negotiate(httpCtx.Request(), producers)
 	if err != nil {
 		return err
 	}
-	if len(producers) <= 0 {
-		return NoProducerToWrite.Error(ats)
-	}
-	producer := ChooseProducer(ats, producers)
 	if producer == nil {
-		return NoProducerToWrite.Error(ats)
+		return NoProducerToWrite.Error(acceptHeader(httpCtx.Request()))
+	}
+	if p, ok := producer.(RequestAwareProducer); ok {
+		producer = p.ForRequest(httpCtx.Request())
 	}
 	resp := httpCtx.ResponseWriter()
+	if code >= 200 && code < 300 && notModified(httpCtx.Request(), resp.Header()) {
+		code = http.StatusNotModified
+	}
+	if code == http.StatusNoContent || code == http.StatusNotModified {
+		// Responses with these codes must not have a body.
+		if resp.HeaderWritable() {
+			resp.WriteHeader(code)
+		}
+		return nil
+	}
 	if resp.HeaderWritable() {
 		// If "Content-Type" has been set, ignore producer's.
 		ctype := resp.Header().Get("Content-Type")
@@ -221,20 +701,24 @@ func WriteData(ctx context.Context, producers []Producer, code int, data interfa
 	return producer.Produce(resp, data)
 }
 
-// ChooseProducer chooses the right producer.
+// ChooseProducer chooses the right producer. Accept types are in the order of
+// preference, and parameters of accept types are ignored. Wildcards like "text/*"
+// and "*/*" are supported. Use Negotiate to choose producers for requests.
 func ChooseProducer(acceptTypes []string, producers []Producer) Producer {
 	if len(acceptTypes) <= 0 || len(producers) <= 0 {
 		return nil
 	}
-	for _, v := range acceptTypes {
-		if v == definition.MIMEAll {
-			return producers[0]
-		}
-		for _, p := range producers {
-			if p.ContentType() == v {
-				return p
-			}
-		}
+	ranges := make([]AcceptRange, len(acceptTypes))
+	for i, at := range acceptTypes {
+		ranges[i] = AcceptRange{Name: at, Quality: 1}
 	}
-	return nil
+	return chooseProducer(ranges, producers)
+}
+
+// acceptHeader returns header "Accept" of a request for error messages.
+func acceptHeader(req *http.Request) string {
+	if v := req.Header.Get("Accept"); v != "" {
+		return v
+	}
+	return definition.MIMEAll
 }
@@ -0,0 +1,202 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/caicloud/nirvana/definition"
+	"github.com/caicloud/nirvana/errors"
+)
+
+type xmlErrorSerializer struct {
+	contentType string
+}
+
+func (s *xmlErrorSerializer) ContentType() string {
+	return s.contentType
+}
+
+func (s *xmlErrorSerializer) Serialize(w io.Writer, code int, err interface{}) error {
+	_, e := fmt.Fprintf(w, "<error><code>%d</code><message>%v</message></error>", code, err)
+	return e
+}
+
+func TestErrorSerializer(t *testing.T) {
+	if err := RegisterErrorSerializer(&xmlErrorSerializer{definition.MIMEAll}); err == nil {
+		t.Fatal("Error serializer for */* should be rejected")
+	}
+	if err := RegisterErrorSerializer(&xmlErrorSerializer{definition.MIMEXML}); err != nil {
+		t.Fatal(err)
+	}
+	defer delete(errorSerializers, definition.MIMEXML)
+
+	producers := []Producer{ProducerFor(definition.MIMEJSON), ProducerFor(definition.MIMEXML)}
+	tests := []struct {
+		accept string
+		body   string
+	}{
+		{definition.MIMEXML, "<error><code>404</code><message>not found</message></error>"},
+		{definition.MIMEJSON, `{"message":"not found"}` + "\n"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept", test.accept)
+		recorder := httptest.NewRecorder()
+		ctx := NewHTTPContext(recorder, req)
+		if err := WriteError(ctx, producers, errors.NotFound.Error("not found")); err != nil {
+			t.Fatal(err)
+		}
+		if recorder.Code != http.StatusNotFound {
+			t.Fatalf("Status code should be 404, but got: %d", recorder.Code)
+		}
+		if recorder.Header().Get("Content-Type") != test.accept {
+			t.Fatalf("Content type should be %s, but got: %s", test.accept, recorder.Header().Get("Content-Type"))
+		}
+		if recorder.Body.String() != test.body {
+			t.Fatalf("Error body is not desired: %s", recorder.Body.String())
+		}
+	}
+}
+
+func TestProblemDetails(t *testing.T) {
+	producers := []Producer{ProducerFor(definition.MIMEJSON), ProducerFor(definition.MIMEProblemJSON)}
+	tests := []struct {
+		err    interface{}
+		code   int
+		object map[string]interface{}
+	}{
+		{
+			errors.NotFound.Build("Test:NotFound", "${name} is not found").Error("abc"),
+			http.StatusNotFound,
+			map[string]interface{}{
+				"type":   "about:blank",
+				"title":  "Not Found",
+				"status": 404.0,
+				"detail": "abc is not found",
+				"reason": "Test:NotFound",
+				"data":   map[string]interface{}{"name": "abc"},
+			},
+		},
+		{
+			&Problem{
+				Type:       "https://example.com/probs/out-of-credit",
+				Title:      "You do not have enough credit.",
+				Status:     http.StatusForbidden,
+				Detail:     "Your current balance is 30, but that costs 50.",
+				Instance:   "/account/12345/msgs/abc",
+				Extensions: map[string]interface{}{"balance": 30, "status": 200},
+			},
+			http.StatusForbidden,
+			map[string]interface{}{
+				"type":     "https://example.com/probs/out-of-credit",
+				"title":    "You do not have enough credit.",
+				"status":   403.0,
+				"detail":   "Your current balance is 30, but that costs 50.",
+				"instance": "/account/12345/msgs/abc",
+				"balance":  30.0,
+			},
+		},
+		{
+			fmt.Errorf("unknown"),
+			http.StatusInternalServerError,
+			map[string]interface{}{
+				"type":   "about:blank",
+				"title":  "Internal Server Error",
+				"status": 500.0,
+				"detail": "unknown",
+			},
+		},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept", "application/problem+json, application/json;q=0.9")
+		recorder := httptest.NewRecorder()
+		ctx := NewHTTPContext(recorder, req)
+		if err := WriteError(ctx, producers, test.err); err != nil {
+			t.Fatal(err)
+		}
+		if recorder.Code != test.code {
+			t.Fatalf("Status code should be %d, but got: %d", test.code, recorder.Code)
+		}
+		if ct := recorder.Header().Get("Content-Type"); ct != definition.MIMEProblemJSON {
+			t.Fatalf("Content type should be %s, but got: %s", definition.MIMEProblemJSON, ct)
+		}
+		object := map[string]interface{}{}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &object); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(object, test.object) {
+			t.Fatalf("Problem details are not desired: %s", recorder.Body.String())
+		}
+	}
+
+	d := &definition.Definition{Produces: []string{definition.MIMEJSON, definition.MIMEProblemJSON}}
+	ProblemDetailsForErrors()(d)
+	if !reflect.DeepEqual(d.ErrorProduces, []string{definition.MIMEProblemJSON, definition.MIMEJSON}) {
+		t.Fatalf("Error produces are not desired: %v", d.ErrorProduces)
+	}
+}
+
+func TestLocalizedErrors(t *testing.T) {
+	factory := errors.BadRequest.Build("Test:OutOfRange", "value ${value} of field '${field}' is out of range")
+	catalog := errors.NewCatalog("en")
+	catalog.Add("zh", map[errors.Reason]string{"Test:OutOfRange": "字段 '${field}' 的值 ${value} 超出范围"})
+	catalog.Add("fr-FR", map[errors.Reason]string{"Test:OutOfRange": "la valeur ${value} du champ '${field}' est hors limites"})
+	SetMessageCatalog(catalog)
+	defer SetMessageCatalog(nil)
+
+	producers := []Producer{ProducerFor(definition.MIMEJSON)}
+	tests := []struct {
+		languages string
+		err       interface{}
+		body      string
+	}{
+		{"zh-CN, en;q=0.8", factory.Error(10, "size"), `{"reason":"Test:OutOfRange","message":"字段 'size' 的值 10 超出范围","data":{"field":"size","value":"10"}}`},
+		{"de, fr-FR;q=0.9, zh;q=0.8", factory.Error(10, "size"), `{"reason":"Test:OutOfRange","message":"la valeur 10 du champ 'size' est hors limites","data":{"field":"size","value":"10"}}`},
+		// English has no format, so the original message is kept.
+		{"de", factory.Error(10, "size"), `{"reason":"Test:OutOfRange","message":"value 10 of field 'size' is out of range","data":{"field":"size","value":"10"}}`},
+		{"zh", ParameterErrors{NewFieldError("size", definition.Query, factory.Error(10, "size"))},
+			`{"reason":"Nirvana:Service:InvalidParameters","message":"1 parameter is invalid","errors":[{"field":"size","source":"Query","reason":"Test:OutOfRange","message":"字段 'size' 的值 10 超出范围"}]}`},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Language", test.languages)
+		recorder := httptest.NewRecorder()
+		if err := WriteError(NewHTTPContext(recorder, req), producers, test.err); err != nil {
+			t.Fatal(err)
+		}
+		if body := strings.TrimSpace(recorder.Body.String()); body != test.body {
+			t.Fatalf("Error of languages %q is not localized as expected:\n%s\n%s", test.languages, body, test.body)
+		}
+	}
+
+	catalog.Add("en", map[errors.Reason]string{"Test:OutOfRange": "${field} is out of range (${unknown})"})
+	if msg, ok := catalog.Format("Test:OutOfRange", map[string]string{"field": "size"}, "ja"); !ok || msg != "size is out of range (${unknown})" {
+		t.Fatalf("Default language should be used: %s", msg)
+	}
+	if err := catalog.Localize(factory.Error(1, "size"), "ja"); !factory.Derived(err) {
+		t.Fatalf("Localized error should be derived from the same factory: %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+
+	"github.com/caicloud/nirvana/definition"
+)
+
+// InterceptorBuilder is a service builder which runs interceptors around
+// functions of all definitions.
+type InterceptorBuilder interface {
+	Builder
+	// AddInterceptor adds interceptors. They only apply to definitions built
+	// after they are added. Requests rejected by authentication or parameter
+	// errors never reach interceptors.
+	AddInterceptor(interceptors ...Interceptor)
+}
+
+// Invocation describes a call of a definition function.
+type Invocation struct {
+	// Definition is the definition after modifiers are applied. It's shared by
+	// all requests and must not be modified.
+	Definition *definition.Definition
+	// Parameters contains bound values of parameters in the order of
+	// Definition.Parameters, after operators ran.
+	Parameters []interface{}
+}
+
+// Interceptor sees calls of definition functions. Different from middlewares,
+// it works with structured parameters and results rather than HTTP requests and
+// responses, so it's suitable for audit logging.
+//
+// Interceptors only see requests whose parameters are bound successfully.
+// Requests rejected earlier (such as by authentication or invalid parameters)
+// don't reach interceptors.
+type Interceptor interface {
+	// BeforeHandler is called before the function is called. If it returns an
+	// error, the function is not called and the error is written as response.
+	BeforeHandler(ctx context.Context, invocation *Invocation) error
+	// AfterHandler is called after the function returns, before results are
+	// written. results contains values returned by the function in order of
+	// Definition.Results. err is the error returned by the function, or the
+	// error which stops the call, such as a timeout or an error returned by a
+	// BeforeHandler. results is nil if the function is not called or doesn't
+	// return in time. If the function or an interceptor panics, err is an
+	// internal server error of the panic value, and the panic goes on after
+	// AfterHandler of all interceptors which should see it.
+	AfterHandler(ctx context.Context, invocation *Invocation, results []interface{}, err error)
+}
+
+// Intercept calls BeforeHandler of interceptors in order, then call, then
+// AfterHandler of interceptors in reverse order. If a BeforeHandler returns an
+// error, the remaining interceptors and call are skipped, and only interceptors
+// whose BeforeHandler succeeded get AfterHandler. It returns the error passed
+// to AfterHandler. Panics are recovered to notify interceptors, then they are
+// raised again.
+func Intercept(ctx context.Context, interceptors []Interceptor, invocation *Invocation, call func() ([]interface{}, error)) ([]interface{}, error) {
+	var results []interface{}
+	var err error
+	index := 0
+	defer func() {
+		if r := recover(); r != nil {
+			// index is the interceptor which panics, or the number of interceptors
+			// if call panics.
+			err := panicWithValue.Error(r)
+			for index--; index >= 0; index-- {
+				interceptors[index].AfterHandler(ctx, invocation, nil, err)
+			}
+			panic(r)
+		}
+	}()
+	for ; index < len(interceptors); index++ {
+		if err = interceptors[index].BeforeHandler(ctx, invocation); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		results, err = call()
+	}
+	for index--; index >= 0; index-- {
+		interceptors[index].AfterHandler(ctx, invocation, results, err)
+	}
+	return results, err
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"testing"
+)
+
+type recordInterceptor struct {
+	panicBefore bool
+	afters      []error
+}
+
+func (i *recordInterceptor) BeforeHandler(ctx context.Context, invocation *Invocation) error {
+	if i.panicBefore {
+		panic("before")
+	}
+	return nil
+}
+
+func (i *recordInterceptor) AfterHandler(ctx context.Context, invocation *Invocation, results []interface{}, err error) {
+	i.afters = append(i.afters, err)
+}
+
+func TestInterceptPanic(t *testing.T) {
+	intercept := func(interceptors []Interceptor, call func() ([]interface{}, error)) (recovered interface{}) {
+		defer func() {
+			recovered = recover()
+		}()
+		_, _ = Intercept(context.Background(), interceptors, &Invocation{}, call)
+		return nil
+	}
+
+	first, second := &recordInterceptor{}, &recordInterceptor{}
+	r := intercept([]Interceptor{first, second}, func() ([]interface{}, error) {
+		panic("call")
+	})
+	if r != "call" {
+		t.Fatalf("Panic should be raised again: %v", r)
+	}
+	for _, i := range []*recordInterceptor{first, second} {
+		if len(i.afters) != 1 || !panicWithValue.Derived(i.afters[0]) || PanicRecovered.Derived(i.afters[0]) {
+			t.Fatalf("AfterHandler should get the panic: %v", i.afters)
+		}
+	}
+
+	first, second = &recordInterceptor{}, &recordInterceptor{panicBefore: true}
+	called := false
+	r = intercept([]Interceptor{first, second}, func() ([]interface{}, error) {
+		called = true
+		return nil, nil
+	})
+	if r != "before" || called {
+		t.Fatalf("Panic of BeforeHandler should stop the call: %v %v", r, called)
+	}
+	if len(first.afters) != 1 || len(second.afters) != 0 {
+		t.Fatalf("Only interceptors whose BeforeHandler succeeded should get AfterHandler: %v %v", first.afters, second.afters)
+	}
+}
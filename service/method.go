@@ -42,6 +42,7 @@ var mappings = map[definition.Method]statusMapping{
 	definition.AsyncUpdate: {http.MethodPut, http.StatusAccepted},
 	definition.AsyncPatch:  {http.MethodPatch, http.StatusAccepted},
 	definition.AsyncDelete: {http.MethodDelete, http.StatusAccepted},
+	definition.WebSocket:   {http.MethodGet, http.StatusSwitchingProtocols},
 }
 
 // HTTPMethodFor gets a HTTP method for specified definition method.
@@ -0,0 +1,140 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+
+	"github.com/caicloud/nirvana/definition"
+)
+
+// Part is a part of a "multipart/mixed" response, such as a sub-response of a
+// batch request.
+type Part struct {
+	// StatusCode is the status of the part. It's written as header "Status" like
+	// "404 Not Found" if it's not zero.
+	StatusCode int
+	// Header contains headers of the part.
+	Header http.Header
+	// ContentType is the content type of Body, such as "application/json". If it's
+	// empty, strings are "text/plain", []byte and readers are
+	// "application/octet-stream", and others are "application/json".
+	ContentType string
+	// Body is produced by the producer of ContentType.
+	Body interface{}
+}
+
+// MultipartProducer produces "multipart/mixed" responses. Data must be []Part or
+// []*Part, and each part is produced by the producer of its content type.
+// Other data (such as errors) is produced as a single JSON part.
+//
+// Boundaries are generated for each request, and the content type of response
+// contains the boundary, such as "multipart/mixed; boundary=...".
+type MultipartProducer struct {
+	boundary string
+}
+
+// ContentType returns "multipart/mixed" with the boundary if there is one.
+func (p *MultipartProducer) ContentType() string {
+	if p.boundary == "" {
+		return definition.MIMEMultipartMixed
+	}
+	return mime.FormatMediaType(definition.MIMEMultipartMixed, map[string]string{"boundary": p.boundary})
+}
+
+// ForRequest returns a producer with a random boundary.
+func (p *MultipartProducer) ForRequest(req *http.Request) Producer {
+	return &MultipartProducer{boundary: multipart.NewWriter(ioutil.Discard).Boundary()}
+}
+
+// Boundary returns the boundary of parts. It's empty if the producer is not for
+// a request, and then a random boundary is used to produce data.
+func (p *MultipartProducer) Boundary() string {
+	return p.boundary
+}
+
+// Produce writes parts in v to w.
+func (p *MultipartProducer) Produce(w io.Writer, v interface{}) error {
+	var parts []*Part
+	switch data := v.(type) {
+	case []*Part:
+		parts = data
+	case []Part:
+		parts = make([]*Part, len(data))
+		for i := range data {
+			parts[i] = &data[i]
+		}
+	default:
+		parts = []*Part{{ContentType: definition.MIMEJSON, Body: v}}
+	}
+	mw := multipart.NewWriter(w)
+	if p.boundary != "" {
+		if err := mw.SetBoundary(p.boundary); err != nil {
+			return err
+		}
+	}
+	for i, part := range parts {
+		if part == nil {
+			continue
+		}
+		if err := writePart(mw, i, part); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+// writePart writes a part by the producer of its content type.
+func writePart(mw *multipart.Writer, index int, part *Part) error {
+	contentType := part.ContentType
+	if contentType == "" {
+		switch part.Body.(type) {
+		case string:
+			contentType = definition.MIMEText
+		case []byte, io.Reader:
+			contentType = definition.MIMEOctetStream
+		default:
+			contentType = definition.MIMEJSON
+		}
+	}
+	producer := ProducerFor(MediaType(contentType))
+	if producer == nil || MediaType(contentType) == definition.MIMEMultipartMixed {
+		return noPartProducer.Error(contentType, index)
+	}
+	header := textproto.MIMEHeader{}
+	for key, values := range part.Header {
+		header[textproto.CanonicalMIMEHeaderKey(key)] = values
+	}
+	header.Set("Content-Type", contentType)
+	if part.StatusCode != 0 {
+		header.Set("Status", strconv.Itoa(part.StatusCode)+" "+http.StatusText(part.StatusCode))
+	}
+	pw, err := mw.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	if part.Body == nil {
+		return nil
+	}
+	return producer.Produce(pw, part.Body)
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/caicloud/nirvana/definition"
+)
+
+// readParts parses a multipart/mixed body by its content type.
+func readParts(t *testing.T, contentType string, body io.Reader) ([]*multipart.Part, []string) {
+	mt, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mt != definition.MIMEMultipartMixed || params["boundary"] == "" {
+		t.Fatalf("Content type should be multipart/mixed with a boundary, but got: %q %v", contentType, err)
+	}
+	reader := multipart.NewReader(body, params["boundary"])
+	var parts []*multipart.Part
+	var bodies []string
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return parts, bodies
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts = append(parts, part)
+		bodies = append(bodies, string(data))
+	}
+}
+
+func TestMultipartProducer(t *testing.T) {
+	base := ProducerFor(definition.MIMEMultipartMixed)
+	if base == nil || base.ContentType() != definition.MIMEMultipartMixed {
+		t.Fatalf("Producer for multipart/mixed should be registered: %v", base)
+	}
+	req, _ := http.NewRequest("POST", "/batch", nil)
+	p1 := base.(RequestAwareProducer).ForRequest(req)
+	p2 := base.(RequestAwareProducer).ForRequest(req)
+	if p1.ContentType() == p2.ContentType() {
+		t.Fatalf("Boundaries should be generated for each request: %s", p1.ContentType())
+	}
+
+	parts := []Part{
+		{StatusCode: http.StatusOK, ContentType: definition.MIMEJSON, Body: map[string]string{"name": "a"}},
+		{StatusCode: http.StatusNotFound, Header: http.Header{"x-request-id": {"2"}}, Body: "not found"},
+		{Body: []byte{0, 1}},
+		{ContentType: "text/html; charset=utf-8", Body: strings.NewReader("<b>c</b>")},
+		{StatusCode: http.StatusNoContent},
+	}
+	buf := &bytes.Buffer{}
+	if err := p1.Produce(buf, parts); err != nil {
+		t.Fatal(err)
+	}
+	if boundary := p1.(*MultipartProducer).Boundary(); !strings.HasSuffix(buf.String(), "--"+boundary+"--\r\n") {
+		t.Fatalf("Body should end with boundary %s: %s", boundary, buf.String())
+	}
+	got, bodies := readParts(t, p1.ContentType(), buf)
+	if len(got) != len(parts) {
+		t.Fatalf("There should be %d parts, but got: %d", len(parts), len(got))
+	}
+	tests := []struct {
+		contentType string
+		status      string
+		body        string
+	}{
+		{definition.MIMEJSON, "200 OK", `{"name":"a"}`},
+		{definition.MIMEText, "404 Not Found", "not found"},
+		{definition.MIMEOctetStream, "", "\x00\x01"},
+		{"text/html; charset=utf-8", "", "<b>c</b>"},
+		{definition.MIMEJSON, "204 No Content", ""},
+	}
+	for i, test := range tests {
+		header := got[i].Header
+		if header.Get("Content-Type") != test.contentType || header.Get("Status") != test.status ||
+			strings.TrimSpace(bodies[i]) != test.body {
+			t.Errorf("Part %d: got %v %q, want %s %s %q", i, header, bodies[i], test.contentType, test.status, test.body)
+		}
+	}
+	if got[1].Header.Get("X-Request-Id") != "2" {
+		t.Errorf("Headers of parts should be written: %v", got[1].Header)
+	}
+
+	// Other data is written as a single JSON part.
+	buf.Reset()
+	if err := p2.Produce(buf, map[string]string{"message": "error"}); err != nil {
+		t.Fatal(err)
+	}
+	got, bodies = readParts(t, p2.ContentType(), buf)
+	if len(got) != 1 || got[0].Header.Get("Content-Type") != definition.MIMEJSON || strings.TrimSpace(bodies[0]) != `{"message":"error"}` {
+		t.Fatalf("Data should be a JSON part: %v %v", got, bodies)
+	}
+
+	err := p2.Produce(ioutil.Discard, []*Part{{ContentType: "image/unknown", Body: "x"}})
+	if err == nil || !noPartProducer.Derived(err) {
+		t.Fatalf("Parts without producers should be rejected, but got: %v", err)
+	}
+}
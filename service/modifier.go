@@ -59,6 +59,36 @@ func FirstContextParameter() DefinitionModifier {
 	}
 }
 
+// DefaultMaxBodySize sets the max body size of definitions which don't have one.
+// It's the server-wide default of definition.Definition.MaxBodySize.
+func DefaultMaxBodySize(size int64) DefinitionModifier {
+	return func(d *definition.Definition) {
+		if d.MaxBodySize == 0 {
+			d.MaxBodySize = size
+		}
+	}
+}
+
+// ProblemDetailsForErrors makes "application/problem+json" the first content
+// type of error produces. Then clients accepting problem details or all types
+// get errors as problem details. Clients explicitly accepting other types can
+// still get errors in those types.
+func ProblemDetailsForErrors() DefinitionModifier {
+	return func(d *definition.Definition) {
+		types := d.ErrorProduces
+		if len(types) <= 0 {
+			types = d.Produces
+		}
+		errorProduces := []string{definition.MIMEProblemJSON}
+		for _, t := range types {
+			if t != definition.MIMEProblemJSON {
+				errorProduces = append(errorProduces, t)
+			}
+		}
+		d.ErrorProduces = errorProduces
+	}
+}
+
 // ConsumeAllIfConsumesIsEmpty adds definition.MIMEAll to consumes if consumes
 // is empty.
 func ConsumeAllIfConsumesIsEmpty() DefinitionModifier {
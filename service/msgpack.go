@@ -0,0 +1,725 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"bufio"
+	"encoding"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/caicloud/nirvana/definition"
+)
+
+// MsgpackSerializer implements Consumer and Producer for content type "application/msgpack".
+// Struct fields are mapped by "json" tags, including "omitempty" and "-", and fields
+// of embedded structs are promoted like encoding/json. time.Time is encoded as the
+// timestamp extension, and other types implementing encoding.TextMarshaler are
+// encoded as strings.
+type MsgpackSerializer struct{ RawSerializer }
+
+// ContentType returns msgpack MIME type.
+func (s *MsgpackSerializer) ContentType() string {
+	return definition.MIMEMsgpack
+}
+
+// Consume unmarshals msgpack from r into v.
+func (s *MsgpackSerializer) Consume(r io.Reader, v interface{}) error {
+	if s.CanConsumeData(s.ContentType(), r, v) {
+		return s.ConsumeData(s.ContentType(), r, v)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if len(data) <= 0 {
+		return nil
+	}
+	return unmarshalMsgpack(data, v)
+}
+
+// Produce marshals v to msgpack and write to w.
+func (s *MsgpackSerializer) Produce(w io.Writer, v interface{}) error {
+	if s.CanProduceData(s.ContentType(), w, v) {
+		return s.ProduceData(s.ContentType(), w, v)
+	}
+	bw := bufio.NewWriter(w)
+	if err := encodeMsgpack(bw, reflect.ValueOf(v)); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+var (
+	timeType            = reflect.TypeOf(time.Time{})
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// encodeMsgpack writes v to w.
+func encodeMsgpack(w *bufio.Writer, v reflect.Value) error {
+	if !v.IsValid() {
+		return w.WriteByte(0xc0)
+	}
+	if v.Type() == timeType {
+		return encodeMsgpackTime(w, v.Interface().(time.Time))
+	}
+	if v.Kind() != reflect.Ptr && v.Kind() != reflect.Interface && v.Type().Implements(textMarshalerType) {
+		text, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return err
+		}
+		return encodeMsgpackString(w, 0xa0, 0xd9, string(text))
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return w.WriteByte(0xc0)
+		}
+		return encodeMsgpack(w, v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			return w.WriteByte(0xc3)
+		}
+		return w.WriteByte(0xc2)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return encodeMsgpackInt(w, v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return encodeMsgpackUint(w, v.Uint())
+	case reflect.Float32:
+		_ = w.WriteByte(0xca)
+		return writeUint(w, uint64(math.Float32bits(float32(v.Float()))), 4)
+	case reflect.Float64:
+		_ = w.WriteByte(0xcb)
+		return writeUint(w, math.Float64bits(v.Float()), 8)
+	case reflect.String:
+		return encodeMsgpackString(w, 0xa0, 0xd9, v.String())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return w.WriteByte(0xc0)
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			data := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(data), v)
+			return encodeMsgpackString(w, 0, 0xc4, string(data))
+		}
+		if err := encodeMsgpackLength(w, 0x90, 0xdc, v.Len()); err != nil {
+			return err
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := encodeMsgpack(w, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		if v.IsNil() {
+			return w.WriteByte(0xc0)
+		}
+		if err := encodeMsgpackLength(w, 0x80, 0xde, v.Len()); err != nil {
+			return err
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := encodeMsgpack(w, iter.Key()); err != nil {
+				return err
+			}
+			if err := encodeMsgpack(w, iter.Value()); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Struct:
+		fields := structFields(v.Type())
+		values := make([]reflect.Value, len(fields))
+		count := 0
+		for i, field := range fields {
+			fv, ok := structFieldValue(v, field.index)
+			if !ok || (field.omitEmpty && isEmptyValue(fv)) {
+				continue
+			}
+			values[i] = fv
+			count++
+		}
+		if err := encodeMsgpackLength(w, 0x80, 0xde, count); err != nil {
+			return err
+		}
+		for i, field := range fields {
+			if !values[i].IsValid() {
+				continue
+			}
+			if err := encodeMsgpackString(w, 0xa0, 0xd9, field.name); err != nil {
+				return err
+			}
+			if err := encodeMsgpack(w, values[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("msgpack: unsupported type %s", v.Type())
+}
+
+func writeUint(w *bufio.Writer, n uint64, size int) error {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, n)
+	_, err := w.Write(buf[8-size:])
+	return err
+}
+
+func encodeMsgpackInt(w *bufio.Writer, n int64) error {
+	switch {
+	case n >= 0:
+		return encodeMsgpackUint(w, uint64(n))
+	case n >= -32:
+		return w.WriteByte(byte(n))
+	case n >= math.MinInt8:
+		_ = w.WriteByte(0xd0)
+		return writeUint(w, uint64(n), 1)
+	case n >= math.MinInt16:
+		_ = w.WriteByte(0xd1)
+		return writeUint(w, uint64(n), 2)
+	case n >= math.MinInt32:
+		_ = w.WriteByte(0xd2)
+		return writeUint(w, uint64(n), 4)
+	}
+	_ = w.WriteByte(0xd3)
+	return writeUint(w, uint64(n), 8)
+}
+
+func encodeMsgpackUint(w *bufio.Writer, n uint64) error {
+	switch {
+	case n <= 0x7f:
+		return w.WriteByte(byte(n))
+	case n <= math.MaxUint8:
+		_ = w.WriteByte(0xcc)
+		return writeUint(w, n, 1)
+	case n <= math.MaxUint16:
+		_ = w.WriteByte(0xcd)
+		return writeUint(w, n, 2)
+	case n <= math.MaxUint32:
+		_ = w.WriteByte(0xce)
+		return writeUint(w, n, 4)
+	}
+	_ = w.WriteByte(0xcf)
+	return writeUint(w, n, 8)
+}
+
+// encodeMsgpackLength writes the header of an array or a map. fix is the prefix
+// of fix format, and base is the prefix of 16-bit format.
+func encodeMsgpackLength(w *bufio.Writer, fix byte, base byte, n int) error {
+	switch {
+	case n < 16:
+		return w.WriteByte(fix | byte(n))
+	case n <= math.MaxUint16:
+		_ = w.WriteByte(base)
+		return writeUint(w, uint64(n), 2)
+	}
+	_ = w.WriteByte(base + 1)
+	return writeUint(w, uint64(n), 4)
+}
+
+// encodeMsgpackString writes a str or bin. fix is the prefix of fixstr, and zero
+// means no fix format. base is the prefix of 8-bit format.
+func encodeMsgpackString(w *bufio.Writer, fix byte, base byte, s string) error {
+	n := len(s)
+	var err error
+	switch {
+	case fix != 0 && n < 32:
+		err = w.WriteByte(fix | byte(n))
+	case n <= math.MaxUint8:
+		_ = w.WriteByte(base)
+		err = writeUint(w, uint64(n), 1)
+	case n <= math.MaxUint16:
+		_ = w.WriteByte(base + 1)
+		err = writeUint(w, uint64(n), 2)
+	default:
+		_ = w.WriteByte(base + 2)
+		err = writeUint(w, uint64(n), 4)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = w.WriteString(s)
+	return err
+}
+
+// encodeMsgpackTime writes t in the timestamp extension (type -1).
+func encodeMsgpackTime(w *bufio.Writer, t time.Time) error {
+	sec, nsec := uint64(t.Unix()), uint64(t.Nanosecond())
+	if sec>>34 == 0 {
+		data := nsec<<34 | sec
+		if data&0xffffffff00000000 == 0 {
+			_, _ = w.Write([]byte{0xd6, 0xff})
+			return writeUint(w, data, 4)
+		}
+		_, _ = w.Write([]byte{0xd7, 0xff})
+		return writeUint(w, data, 8)
+	}
+	_, _ = w.Write([]byte{0xc7, 12, 0xff})
+	_ = writeUint(w, nsec, 4)
+	return writeUint(w, sec, 8)
+}
+
+// maxMsgpackDepth limits nesting of arrays and maps, so that malicious data can't
+// exhaust the stack.
+const maxMsgpackDepth = 10000
+
+// msgpackDecoder decodes msgpack into generic values: nil, bool, int64, uint64,
+// float64, string, []byte, time.Time, []interface{} and map[interface{}]interface{}.
+type msgpackDecoder struct {
+	data []byte
+	pos  int
+	// depth is the number of arrays and maps which contain the current value.
+	depth int
+}
+
+var errMsgpackShort = fmt.Errorf("msgpack: unexpected end of data")
+
+func (d *msgpackDecoder) next(n int) ([]byte, error) {
+	if n < 0 || d.pos+n > len(d.data) {
+		return nil, errMsgpackShort
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *msgpackDecoder) uint(n int) (uint64, error) {
+	b, err := d.next(n)
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+func (d *msgpackDecoder) decode() (interface{}, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	c := b[0]
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xf0 == 0x80:
+		return d.decodeMap(int(c & 0x0f))
+	case c&0xf0 == 0x90:
+		return d.decodeArray(int(c & 0x0f))
+	case c&0xe0 == 0xa0:
+		return d.decodeString(int(c&0x1f), false)
+	}
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6, 0xd9, 0xda, 0xdb:
+		size := 1 << (c - 0xc4)
+		if c >= 0xd9 {
+			size = 1 << (c - 0xd9)
+		}
+		n, err := d.uint(size)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeString(int(n), c < 0xd9)
+	case 0xc7, 0xc8, 0xc9:
+		n, err := d.uint(1 << (c - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeExt(int(n))
+	case 0xca:
+		n, err := d.uint(4)
+		return float64(math.Float32frombits(uint32(n))), err
+	case 0xcb:
+		n, err := d.uint(8)
+		return math.Float64frombits(n), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return d.uint(1 << (c - 0xcc))
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (c - 0xd0)
+		n, err := d.uint(size)
+		// Sign extension.
+		shift := uint(64 - 8*size)
+		return int64(n<<shift) >> shift, err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return d.decodeExt(1 << (c - 0xd4))
+	case 0xdc, 0xdd:
+		n, err := d.uint(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeArray(int(n))
+	case 0xde, 0xdf:
+		n, err := d.uint(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeMap(int(n))
+	}
+	return nil, fmt.Errorf("msgpack: invalid prefix 0x%x", c)
+}
+
+func (d *msgpackDecoder) decodeString(n int, binary bool) (interface{}, error) {
+	b, err := d.next(n)
+	if err != nil {
+		return nil, err
+	}
+	if binary {
+		return append([]byte(nil), b...), nil
+	}
+	return string(b), nil
+}
+
+// enter enters an array or a map. The returned function leaves it.
+func (d *msgpackDecoder) enter() (func(), error) {
+	if d.depth >= maxMsgpackDepth {
+		return nil, fmt.Errorf("msgpack: exceeded max depth of %d", maxMsgpackDepth)
+	}
+	d.depth++
+	return func() { d.depth-- }, nil
+}
+
+func (d *msgpackDecoder) decodeArray(n int) (interface{}, error) {
+	if n > len(d.data)-d.pos {
+		return nil, errMsgpackShort
+	}
+	leave, err := d.enter()
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+	values := make([]interface{}, n)
+	for i := range values {
+		v, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+func (d *msgpackDecoder) decodeMap(n int) (interface{}, error) {
+	if n > len(d.data)-d.pos {
+		return nil, errMsgpackShort
+	}
+	leave, err := d.enter()
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+	values := make(map[interface{}]interface{}, n)
+	for i := 0; i < n; i++ {
+		key, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		value, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		switch key.(type) {
+		case []byte, []interface{}, map[interface{}]interface{}:
+			return nil, fmt.Errorf("msgpack: unsupported map key %T", key)
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+func (d *msgpackDecoder) decodeExt(n int) (interface{}, error) {
+	b, err := d.next(1 + n)
+	if err != nil {
+		return nil, err
+	}
+	if int8(b[0]) != -1 {
+		return nil, fmt.Errorf("msgpack: unsupported extension type %d", int8(b[0]))
+	}
+	data := b[1:]
+	switch n {
+	case 4:
+		return time.Unix(int64(binary.BigEndian.Uint32(data)), 0), nil
+	case 8:
+		v := binary.BigEndian.Uint64(data)
+		return time.Unix(int64(v&0x3ffffffff), int64(v>>34)), nil
+	case 12:
+		return time.Unix(int64(binary.BigEndian.Uint64(data[4:])), int64(binary.BigEndian.Uint32(data))), nil
+	}
+	return nil, fmt.Errorf("msgpack: invalid timestamp of %d bytes", n)
+}
+
+// unmarshalMsgpack decodes data into the value which v points to.
+func unmarshalMsgpack(data []byte, v interface{}) error {
+	target := reflect.ValueOf(v)
+	if target.Kind() != reflect.Ptr || target.IsNil() {
+		return invalidTypeForConsumer.Error(definition.MIMEMsgpack, reflect.TypeOf(v))
+	}
+	d := &msgpackDecoder{data: data}
+	value, err := d.decode()
+	if err == nil && d.pos != len(data) {
+		err = fmt.Errorf("msgpack: %d bytes after the value", len(data)-d.pos)
+	}
+	if err == nil {
+		err = assignMsgpack(target.Elem(), value)
+	}
+	if err != nil {
+		return invalidBody.Error(definition.MIMEMsgpack, err)
+	}
+	return nil
+}
+
+// genericMsgpack converts maps with string keys to map[string]interface{}.
+func genericMsgpack(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = genericMsgpack(elem)
+		}
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			s, ok := key.(string)
+			if !ok {
+				for key, elem := range v {
+					v[key] = genericMsgpack(elem)
+				}
+				return v
+			}
+			result[s] = genericMsgpack(elem)
+		}
+		return result
+	}
+	return value
+}
+
+// assignMsgpack assigns a generic value to target.
+func assignMsgpack(target reflect.Value, value interface{}) error {
+	typ := target.Type()
+	if value == nil {
+		switch target.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+			target.Set(reflect.Zero(typ))
+		}
+		return nil
+	}
+	if target.Kind() == reflect.Ptr {
+		if target.IsNil() {
+			target.Set(reflect.New(typ.Elem()))
+		}
+		return assignMsgpack(target.Elem(), value)
+	}
+	mismatch := func() error {
+		return fmt.Errorf("msgpack: can't assign %T to %s", value, typ)
+	}
+	if typ == timeType {
+		if t, ok := value.(time.Time); ok {
+			target.Set(reflect.ValueOf(t))
+			return nil
+		}
+	}
+	if s, ok := value.(string); ok && reflect.PtrTo(typ).Implements(textUnmarshalerType) {
+		return target.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+	switch target.Kind() {
+	case reflect.Interface:
+		generic := reflect.ValueOf(genericMsgpack(value))
+		if !generic.Type().AssignableTo(typ) {
+			return mismatch()
+		}
+		target.Set(generic)
+	case reflect.Bool:
+		b, ok := value.(bool)
+		if !ok {
+			return mismatch()
+		}
+		target.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		switch v := value.(type) {
+		case int64:
+			n = v
+		case uint64:
+			if v > math.MaxInt64 {
+				return mismatch()
+			}
+			n = int64(v)
+		default:
+			return mismatch()
+		}
+		if target.OverflowInt(n) {
+			return fmt.Errorf("msgpack: %d overflows %s", n, typ)
+		}
+		target.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var n uint64
+		switch v := value.(type) {
+		case uint64:
+			n = v
+		case int64:
+			if v < 0 {
+				return mismatch()
+			}
+			n = uint64(v)
+		default:
+			return mismatch()
+		}
+		if target.OverflowUint(n) {
+			return fmt.Errorf("msgpack: %d overflows %s", n, typ)
+		}
+		target.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		switch v := value.(type) {
+		case float64:
+			target.SetFloat(v)
+		case int64:
+			target.SetFloat(float64(v))
+		case uint64:
+			target.SetFloat(float64(v))
+		default:
+			return mismatch()
+		}
+	case reflect.String:
+		switch v := value.(type) {
+		case string:
+			target.SetString(v)
+		case []byte:
+			target.SetString(string(v))
+		default:
+			return mismatch()
+		}
+	case reflect.Slice:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			switch v := value.(type) {
+			case []byte:
+				target.SetBytes(v)
+				return nil
+			case string:
+				target.SetBytes([]byte(v))
+				return nil
+			}
+		}
+		values, ok := value.([]interface{})
+		if !ok {
+			return mismatch()
+		}
+		slice := reflect.MakeSlice(typ, len(values), len(values))
+		for i, v := range values {
+			if err := assignMsgpack(slice.Index(i), v); err != nil {
+				return err
+			}
+		}
+		target.Set(slice)
+	case reflect.Array:
+		values, ok := value.([]interface{})
+		if !ok {
+			return mismatch()
+		}
+		for i := 0; i < target.Len(); i++ {
+			var v interface{}
+			if i < len(values) {
+				v = values[i]
+			}
+			if err := assignMsgpack(target.Index(i), v); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		values, ok := value.(map[interface{}]interface{})
+		if !ok {
+			return mismatch()
+		}
+		if target.IsNil() {
+			target.Set(reflect.MakeMapWithSize(typ, len(values)))
+		}
+		for k, v := range values {
+			key := reflect.New(typ.Key()).Elem()
+			if err := assignMsgpack(key, k); err != nil {
+				return err
+			}
+			elem := reflect.New(typ.Elem()).Elem()
+			if err := assignMsgpack(elem, v); err != nil {
+				return err
+			}
+			target.SetMapIndex(key, elem)
+		}
+	case reflect.Struct:
+		values, ok := value.(map[interface{}]interface{})
+		if !ok {
+			return mismatch()
+		}
+		fields := structFields(typ)
+		for k, v := range values {
+			name, ok := k.(string)
+			if !ok {
+				continue
+			}
+			field := msgpackFieldFor(fields, name)
+			if field == nil {
+				// Unknown fields are ignored.
+				continue
+			}
+			fv := target
+			for _, i := range field.index {
+				if fv.Kind() == reflect.Ptr {
+					if fv.IsNil() {
+						fv.Set(reflect.New(fv.Type().Elem()))
+					}
+					fv = fv.Elem()
+				}
+				fv = fv.Field(i)
+			}
+			if err := assignMsgpack(fv, v); err != nil {
+				return err
+			}
+		}
+	default:
+		return mismatch()
+	}
+	return nil
+}
+
+// msgpackFieldFor finds a field by name. Like encoding/json, an exact match is
+// preferred over a case-insensitive match.
+func msgpackFieldFor(fields []structField, name string) *structField {
+	var fold *structField
+	for i := range fields {
+		if fields[i].name == name {
+			return &fields[i]
+		}
+		if fold == nil && strings.EqualFold(fields[i].name, name) {
+			fold = &fields[i]
+		}
+	}
+	return fold
+}
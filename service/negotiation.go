@@ -0,0 +1,166 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/caicloud/nirvana/definition"
+)
+
+// AcceptRange is a media range in header "Accept", such as "text/*;q=0.5".
+type AcceptRange struct {
+	// Name is the media range with parameters except "q", such as "text/html;level=1".
+	Name string
+	// Quality is the value of parameter "q". It's 1 if there is no "q".
+	Quality float64
+}
+
+// MediaType returns the media range without parameters, such as "text/*".
+func (r AcceptRange) MediaType() string {
+	return MediaType(r.Name)
+}
+
+// Specificity returns how specific the range is. "*/*" is 0, "type/*" is 1,
+// "type/subtype" is 2, and a range with parameters is 3.
+func (r AcceptRange) Specificity() int {
+	mt := r.MediaType()
+	switch {
+	case mt == definition.MIMEAll:
+		return 0
+	case strings.HasSuffix(mt, "/*"):
+		return 1
+	case strings.IndexByte(r.Name, ';') >= 0:
+		return 3
+	}
+	return 2
+}
+
+// Matches checks whether the range matches a content type. Parameters are ignored.
+// A vendor type of the default version (see SetDefaultVersion) also matches its
+// suffix type and other versions of the vendor, so "application/json" and
+// "application/vnd.myapp.v9+json" match "application/vnd.myapp.v1+json" if v1 is
+// the default version of myapp.
+func (r AcceptRange) Matches(contentType string) bool {
+	mt := r.MediaType()
+	if mt == definition.MIMEAll {
+		return true
+	}
+	contentType = MediaType(contentType)
+	if strings.HasSuffix(mt, "/*") {
+		return strings.HasPrefix(contentType, mt[:len(mt)-1])
+	}
+	if mt == contentType {
+		return true
+	}
+	t, ok := ParseVendorType(contentType)
+	if !ok || t.Version == "" || t.Version != DefaultVersion(t.Vendor) {
+		return false
+	}
+	if mt == suffixType(contentType) {
+		return true
+	}
+	rt, ok := ParseVendorType(mt)
+	return ok && rt.Vendor == t.Vendor && rt.Suffix == t.Suffix
+}
+
+// AcceptRanges returns media ranges in header "Accept" of a request. Ranges are
+// sorted by quality, and ranges with the same quality are sorted by specificity.
+// If there is no "Accept" header, it returns "*/*".
+func AcceptRanges(req *http.Request) ([]AcceptRange, error) {
+	v := req.Header.Get("Accept")
+	if v == "" {
+		return []AcceptRange{{Name: definition.MIMEAll, Quality: 1}}, nil
+	}
+	return parseAcceptRanges(v)
+}
+
+// Negotiator chooses a producer from producers for a request. It returns nil if
+// no producer is acceptable. The order of producers is the order of Produces in
+// definitions.
+type Negotiator func(req *http.Request, producers []Producer) (Producer, error)
+
+var negotiator Negotiator = DefaultNegotiator
+
+// SetNegotiator overrides the negotiator which chooses producers for responses
+// and errors. Nil resets it to DefaultNegotiator.
+func SetNegotiator(n Negotiator) {
+	if n == nil {
+		n = DefaultNegotiator
+	}
+	negotiator = n
+}
+
+var defaultContentType string
+
+// SetDefaultContentType sets the content type which "*/*" (and requests without
+// "Accept" header) prefers. If it's empty or not in producers, the first producer
+// is chosen.
+func SetDefaultContentType(contentType string) {
+	defaultContentType = contentType
+}
+
+// Negotiate chooses a producer for a request by the negotiator.
+func Negotiate(req *http.Request, producers []Producer) (Producer, error) {
+	if len(producers) <= 0 {
+		return nil, nil
+	}
+	return negotiator(req, producers)
+}
+
+// DefaultNegotiator chooses a producer by RFC 7231. The quality of a producer is
+// the quality of the most specific range which matches its content type, and the
+// producer with the highest quality is chosen. Producers with zero quality are
+// not acceptable. If qualities are equal, the producer which matches an earlier
+// range wins, and then the earlier producer wins.
+func DefaultNegotiator(req *http.Request, producers []Producer) (Producer, error) {
+	ranges, err := AcceptRanges(req)
+	if err != nil {
+		return nil, err
+	}
+	return chooseProducer(ranges, producers), nil
+}
+
+// chooseProducer chooses a producer for sorted ranges.
+func chooseProducer(ranges []AcceptRange, producers []Producer) Producer {
+	var best Producer
+	bestQuality, bestIndex := 0.0, len(ranges)
+	for _, p := range producers {
+		index := -1
+		for i, r := range ranges {
+			if r.Matches(p.ContentType()) && (index < 0 || r.Specificity() > ranges[index].Specificity()) {
+				index = i
+			}
+		}
+		if index < 0 || ranges[index].Quality <= 0 {
+			continue
+		}
+		quality := ranges[index].Quality
+		switch {
+		case quality > bestQuality, quality == bestQuality && index < bestIndex:
+		case quality == bestQuality && index == bestIndex && defaultContentType != "" &&
+			ranges[index].MediaType() == definition.MIMEAll &&
+			p.ContentType() == defaultContentType && best.ContentType() != defaultContentType:
+			// "*/*" prefers the default content type.
+		default:
+			continue
+		}
+		best, bestQuality, bestIndex = p, quality, index
+	}
+	return best
+}
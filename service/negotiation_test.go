@@ -0,0 +1,173 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/caicloud/nirvana/definition"
+)
+
+func TestDefaultNegotiator(t *testing.T) {
+	producers := []Producer{&JSONSerializer{}, &XMLSerializer{}, NewSimpleSerializer(definition.MIMEText), NewSimpleSerializer(definition.MIMEHTML)}
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", definition.MIMEJSON},
+		{"*/*", definition.MIMEJSON},
+		{"application/json, application/xml;q=0.9", definition.MIMEJSON},
+		{"application/json;q=0.5, application/xml;q=0.9", definition.MIMEXML},
+		{"application/xml;q=0.1, text/*;q=0.5", definition.MIMEText},
+		// The most specific range decides the quality.
+		{"text/*;q=0.9, text/plain;q=0.1", definition.MIMEHTML},
+		{"*/*;q=0.8, application/json;q=0.2", definition.MIMEXML},
+		// Zero quality means not acceptable.
+		{"application/json;q=0, */*", definition.MIMEXML},
+		{"text/*, text/plain;q=0", definition.MIMEHTML},
+		// Ranges with the same quality are ordered by specificity.
+		{"*/*, text/*, text/html", definition.MIMEHTML},
+		{"image/*, application/pdf", ""},
+		{"*/*;q=0", ""},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept", test.accept)
+		p, err := Negotiate(req, producers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if p != nil {
+			got = p.ContentType()
+		}
+		if got != test.want {
+			t.Fatalf("Producer for %q is %q, but want %q", test.accept, got, test.want)
+		}
+	}
+
+	SetDefaultContentType(definition.MIMEXML)
+	defer SetDefaultContentType("")
+	for accept, want := range map[string]string{"": definition.MIMEXML, "*/*": definition.MIMEXML, "text/html, */*": definition.MIMEHTML} {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept", accept)
+		if p, _ := Negotiate(req, producers); p == nil || p.ContentType() != want {
+			t.Fatalf("Producer for %q is %v, but want %s", accept, p, want)
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "application/json;q=abc")
+	if _, err := Negotiate(req, producers); err == nil {
+		t.Fatalf("Invalid accept header should be rejected")
+	}
+}
+
+func TestSetNegotiator(t *testing.T) {
+	producers := []Producer{&JSONSerializer{}, &XMLSerializer{}}
+	SetNegotiator(func(req *http.Request, producers []Producer) (Producer, error) {
+		// Always prefer the last producer.
+		return producers[len(producers)-1], nil
+	})
+	defer SetNegotiator(nil)
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", definition.MIMEJSON)
+	if p, _ := Negotiate(req, producers); p == nil || p.ContentType() != definition.MIMEXML {
+		t.Fatalf("Producer should be chosen by the negotiator: %v", p)
+	}
+	SetNegotiator(nil)
+	if p, _ := Negotiate(req, producers); p == nil || p.ContentType() != definition.MIMEJSON {
+		t.Fatalf("Producer should be chosen by the default negotiator: %v", p)
+	}
+}
+
+func TestParseVendorType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        VendorType
+		ok          bool
+	}{
+		{"application/vnd.myapp.v2+json", VendorType{"myapp", "v2", "json"}, true},
+		{"application/vnd.myapp.v2beta1+xml; charset=utf-8", VendorType{"myapp", "v2beta1", "xml"}, true},
+		{"application/vnd.myapp.2+json", VendorType{"myapp.2", "", "json"}, true},
+		{"application/vnd.github.v3", VendorType{"github", "v3", ""}, true},
+		{"application/vnd.ms-excel", VendorType{"ms-excel", "", ""}, true},
+		{"application/vnd.myapp+json", VendorType{"myapp", "", "json"}, true},
+		{"application/vnd.v2+json", VendorType{"v2", "", "json"}, true},
+		{"application/json", VendorType{}, false},
+		{"application/vnd.+json", VendorType{}, false},
+	}
+	for _, test := range tests {
+		got, ok := ParseVendorType(test.contentType)
+		if ok != test.ok || got != test.want {
+			t.Errorf("Type %q: got %+v %v, want %+v %v", test.contentType, got, ok, test.want, test.ok)
+		}
+		if ok && got.Version != "" && got.Suffix != "" && got.String() != MediaType(test.contentType) {
+			t.Errorf("Type %q: got %q after formatting", test.contentType, got.String())
+		}
+	}
+}
+
+func TestVendorNegotiation(t *testing.T) {
+	const v1, v2 = "application/vnd.myapp.v1+json", "application/vnd.myapp.v2+json"
+	for _, ct := range []string{v1, v2} {
+		if p := ProducerFor(ct); p == nil || p.ContentType() != ct {
+			t.Fatalf("Producer of %s should use the JSON producer: %v", ct, p)
+		}
+		if c := ConsumerFor(ct); c == nil || c.ContentType() != ct {
+			t.Fatalf("Consumer of %s should use the JSON consumer: %v", ct, c)
+		}
+	}
+	if p := ProducerFor("application/vnd.myapp.v1+unknown"); p != nil {
+		t.Fatalf("Unknown suffix should have no producer: %v", p)
+	}
+
+	producers := []Producer{ProducerFor(v1), ProducerFor(v2)}
+	tests := []struct {
+		accept         string
+		defaultVersion string
+		want           string
+	}{
+		{v2, "", v2},
+		{v1, "", v1},
+		{"application/vnd.myapp.v3+json", "", ""},
+		{"application/json", "", ""},
+		{"application/vnd.myapp.v3+json", "v2", v2},
+		{"application/json", "v2", v2},
+		{"application/vnd.myapp.v1+json", "v2", v1},
+		{"application/vnd.myapp.v1+xml", "v2", ""},
+		{"application/vnd.other.v1+json", "v2", ""},
+	}
+	defer SetDefaultVersion("myapp", "")
+	for _, test := range tests {
+		SetDefaultVersion("myapp", test.defaultVersion)
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept", test.accept)
+		p, err := Negotiate(req, producers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if p != nil {
+			got = p.ContentType()
+		}
+		if got != test.want {
+			t.Errorf("Producer for %q with default version %q is %q, but want %q", test.accept, test.defaultVersion, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/caicloud/nirvana/definition"
+)
+
+// Problem is a problem details object defined by RFC 7807. It's an error, so
+// functions can return it to customize problem details of responses:
+//  return nil, &service.Problem{
+//      Type:       "https://example.com/probs/out-of-credit",
+//      Status:     http.StatusForbidden,
+//      Detail:     "Your current balance is 30, but that costs 50.",
+//      Extensions: map[string]interface{}{"balance": 30},
+//  }
+type Problem struct {
+	// Type is a URI reference that identifies the problem type.
+	// Defaults to "about:blank".
+	Type string
+	// Title is a short summary of the problem type.
+	// Defaults to the status text of Status.
+	Title string
+	// Status is the HTTP status code.
+	// Defaults to 500.
+	Status int
+	// Detail is an explanation specific to this occurrence of the problem.
+	Detail string
+	// Instance is a URI reference that identifies the specific occurrence of
+	// the problem.
+	Instance string
+	// Extensions contains extension members. Members with the same names as
+	// standard members are ignored.
+	Extensions map[string]interface{}
+}
+
+// Code returns the status code of problem.
+func (p *Problem) Code() int {
+	if p.Status == 0 {
+		return http.StatusInternalServerError
+	}
+	return p.Status
+}
+
+// Message returns the problem itself.
+func (p *Problem) Message() interface{} {
+	return p
+}
+
+// Error returns the detail of problem, or the title if there is no detail.
+func (p *Problem) Error() string {
+	if p.Detail != "" {
+		return p.Detail
+	}
+	if p.Title != "" {
+		return p.Title
+	}
+	return http.StatusText(p.Code())
+}
+
+// MarshalJSON marshals the problem with extension members at top level.
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	object := make(map[string]interface{}, len(p.Extensions)+5)
+	for k, v := range p.Extensions {
+		object[k] = v
+	}
+	code := p.Code()
+	object["type"] = p.Type
+	if p.Type == "" {
+		object["type"] = "about:blank"
+	}
+	object["title"] = p.Title
+	if p.Title == "" {
+		object["title"] = http.StatusText(code)
+	}
+	object["status"] = code
+	delete(object, "detail")
+	if p.Detail != "" {
+		object["detail"] = p.Detail
+	}
+	delete(object, "instance")
+	if p.Instance != "" {
+		object["instance"] = p.Instance
+	}
+	return json.Marshal(object)
+}
+
+// ProblemFor converts an error to a problem with status code. If err is a
+// problem, a copy of it is returned. Reason and data of errors built by error
+// factories are in extension members "reason" and "data", and errors of
+// ParameterErrors are in "errors".
+func ProblemFor(code int, err interface{}) *Problem {
+	if p, ok := err.(*Problem); ok {
+		problem := *p
+		problem.Status = code
+		return &problem
+	}
+	problem := &Problem{Status: code}
+	switch e := err.(type) {
+	case error:
+		problem.Detail = e.Error()
+	case nil:
+	default:
+		problem.Extensions = map[string]interface{}{"error": e}
+		return problem
+	}
+	extensions := map[string]interface{}{}
+	if e, ok := err.(interface{ Reason() string }); ok && e.Reason() != "" {
+		extensions["reason"] = e.Reason()
+	}
+	if e, ok := err.(interface{ Data() map[string]string }); ok && len(e.Data()) > 0 {
+		extensions["data"] = e.Data()
+	}
+	if e, ok := err.(ParameterErrors); ok {
+		extensions["reason"] = e.Reason()
+		extensions["errors"] = []FieldError(e)
+	}
+	if len(extensions) > 0 {
+		problem.Extensions = extensions
+	}
+	return problem
+}
+
+// ProblemSerializer implements Producer and ErrorSerializer for content type
+// "application/problem+json". Errors are written as problem details.
+type ProblemSerializer struct{}
+
+// ContentType returns problem json MIME type.
+func (s *ProblemSerializer) ContentType() string {
+	return definition.MIMEProblemJSON
+}
+
+// Produce marshals v to json and write to w.
+func (s *ProblemSerializer) Produce(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+// Serialize writes err as problem details.
+func (s *ProblemSerializer) Serialize(w io.Writer, code int, err interface{}) error {
+	return json.NewEncoder(w).Encode(ProblemFor(code, err))
+}
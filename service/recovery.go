@@ -0,0 +1,92 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/caicloud/nirvana/log"
+)
+
+// RecoveryHandler converts a value recovered from a panic to an error. The error
+// is written to response like errors returned by functions, so a handler can
+// return an error built by an error factory to customize the response.
+type RecoveryHandler func(ctx context.Context, rcv interface{}) error
+
+// DefaultRecoveryHandler returns PanicRecovered for all panics. The recovered
+// value is not exposed to clients.
+func DefaultRecoveryHandler(ctx context.Context, rcv interface{}) error {
+	return PanicRecovered.Error()
+}
+
+// DetailedRecoveryHandler returns an internal server error containing the
+// recovered value. It may leak internals to clients, so it should only be
+// registered for debugging.
+func DetailedRecoveryHandler(ctx context.Context, rcv interface{}) error {
+	return panicWithValue.Error(rcv)
+}
+
+var recoveryHandler RecoveryHandler = DefaultRecoveryHandler
+
+// RegisterRecoveryHandler registers a recovery handler for panics in request
+// handling, including middlewares, parameter generators, operators, functions
+// and destination handlers. A nil handler restores DefaultRecoveryHandler.
+func RegisterRecoveryHandler(h RecoveryHandler) {
+	if h == nil {
+		h = DefaultRecoveryHandler
+	}
+	recoveryHandler = h
+}
+
+// Recover handles a value recovered from a panic in request handling. It logs the
+// value with stack trace, converts the value by the recovery handler and writes
+// the error to response if the response header is not written yet. If the recovery
+// handler panics too, DefaultRecoveryHandler is used instead.
+//
+// Like net/http, it panics again with http.ErrAbortHandler, which is used to abort
+// a response on purpose.
+func Recover(ctx context.Context, logger log.Logger, producers []Producer, rcv interface{}) {
+	if rcv == http.ErrAbortHandler {
+		panic(rcv)
+	}
+	logger.Errorf("Panic recovered: %v\n%s", rcv, debug.Stack())
+	err := recoverWith(ctx, logger, rcv)
+	httpCtx := HTTPContextFrom(ctx)
+	if httpCtx == nil || !httpCtx.ResponseWriter().HeaderWritable() {
+		return
+	}
+	if err := WriteError(ctx, producers, err); err != nil {
+		logger.Error(err)
+	}
+}
+
+// recoverWith converts rcv by the recovery handler.
+func recoverWith(ctx context.Context, logger log.Logger, rcv interface{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorf("Panic recovered in recovery handler: %v\n%s", r, debug.Stack())
+			err = DefaultRecoveryHandler(ctx, rcv)
+		}
+	}()
+	err = recoveryHandler(ctx, rcv)
+	if err == nil {
+		err = DefaultRecoveryHandler(ctx, rcv)
+	}
+	return err
+}
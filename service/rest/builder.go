@@ -17,10 +17,14 @@ limitations under the License.
 package rest
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"reflect"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/caicloud/nirvana/definition"
 	"github.com/caicloud/nirvana/log"
@@ -28,9 +32,20 @@ import (
 	"github.com/caicloud/nirvana/service/rest/router"
 )
 
+// AutoHeadBuilder is a service builder which can derive HEAD handlers from GET
+// definitions.
+type AutoHeadBuilder interface {
+	service.Builder
+	// SetAutoHead enables HEAD handlers derived from GET definitions for all
+	// paths. See definition.Descriptor.AutoHead.
+	SetAutoHead(enabled bool)
+}
+
 type binding struct {
 	middlewares []definition.Middleware
 	definitions []definition.Definition
+	// autoHead indicates whether HEAD handlers are derived from GET definitions.
+	autoHead bool
 }
 
 type builder struct {
@@ -38,6 +53,11 @@ type builder struct {
 	modifier service.DefinitionModifier
 	filters  []service.Filter
 	logger   log.Logger
+	cors     *service.CORSOptions
+	// autoHead indicates whether HEAD handlers are derived for all paths.
+	autoHead bool
+	// interceptors run around functions of all definitions.
+	interceptors []service.Interceptor
 }
 
 // NewBuilder creates a service builder.
@@ -84,6 +104,21 @@ func (b *builder) SetModifier(m service.DefinitionModifier) {
 	b.modifier = m
 }
 
+// SetAutoHead enables HEAD handlers derived from GET definitions for all paths.
+func (b *builder) SetAutoHead(enabled bool) {
+	b.autoHead = enabled
+}
+
+// SetCORS sets options of cross-origin resource sharing.
+func (b *builder) SetCORS(options *service.CORSOptions) {
+	b.cors = options
+}
+
+// AddInterceptor adds interceptors which run around functions of all definitions.
+func (b *builder) AddInterceptor(interceptors ...service.Interceptor) {
+	b.interceptors = append(b.interceptors, interceptors...)
+}
+
 // AddDescriptor adds descriptors to router.
 func (b *builder) AddDescriptor(descriptors ...interface{}) error {
 	for _, obj := range descriptors {
@@ -91,12 +126,12 @@ func (b *builder) AddDescriptor(descriptors ...interface{}) error {
 		if !ok {
 			return fmt.Errorf("%s is not a definition.Descriptor", reflect.TypeOf(obj).String())
 		}
-		b.addDescriptor("", nil, nil, nil, descriptor)
+		b.addDescriptor("", nil, nil, nil, false, descriptor)
 	}
 	return nil
 }
 
-func (b *builder) addDescriptor(prefix string, consumes []string, produces []string, tags []string, descriptor definition.Descriptor) {
+func (b *builder) addDescriptor(prefix string, consumes []string, produces []string, tags []string, autoHead bool, descriptor definition.Descriptor) {
 	path := strings.Join([]string{prefix, strings.Trim(descriptor.Path, "/")}, "/")
 	if descriptor.Consumes != nil {
 		consumes = descriptor.Consumes
@@ -107,33 +142,62 @@ func (b *builder) addDescriptor(prefix string, consumes []string, produces []str
 	if descriptor.Tags != nil {
 		tags = descriptor.Tags
 	}
-	if len(descriptor.Middlewares) > 0 || len(descriptor.Definitions) > 0 {
+	autoHead = autoHead || descriptor.AutoHead
+	if len(descriptor.Headers) > 0 || len(descriptor.Middlewares) > 0 || len(descriptor.Definitions) > 0 {
 		bd, ok := b.bindings[path]
 		if !ok {
 			bd = &binding{}
 			b.bindings[path] = bd
 		}
+		if len(descriptor.Headers) > 0 {
+			bd.middlewares = append(bd.middlewares, headersMiddleware(descriptor.Headers))
+		}
 		if len(descriptor.Middlewares) > 0 {
 			bd.middlewares = append(bd.middlewares, descriptor.Middlewares...)
 		}
 		if len(descriptor.Definitions) > 0 {
+			bd.autoHead = bd.autoHead || autoHead
 			for _, d := range descriptor.Definitions {
 				bd.definitions = append(bd.definitions, *b.copyDefinition(&d, consumes, produces, tags))
 			}
 		}
 	}
 	for _, child := range descriptor.Children {
-		b.addDescriptor(strings.TrimRight(path, "/"), consumes, produces, tags, child)
+		b.addDescriptor(strings.TrimRight(path, "/"), consumes, produces, tags, autoHead, child)
+	}
+}
+
+// headersMiddleware returns a middleware which sets headers to responses.
+func headersMiddleware(headers map[string]string) definition.Middleware {
+	copied := make(map[string]string, len(headers))
+	for k, v := range headers {
+		copied[k] = v
+	}
+	return func(ctx context.Context, chain definition.Chain) error {
+		if httpCtx := service.HTTPContextFrom(ctx); httpCtx != nil {
+			h := httpCtx.ResponseWriter().Header()
+			for k, v := range copied {
+				h.Set(k, v)
+			}
+		}
+		return chain.Continue(ctx)
 	}
 }
 
 // copyDefinition creates a copy from original definition. Those fields with type interface{} only have shallow copies.
 func (b *builder) copyDefinition(d *definition.Definition, consumes []string, produces []string, tags []string) *definition.Definition {
 	newOne := &definition.Definition{
-		Method:      d.Method,
-		Summary:     d.Summary,
-		Function:    d.Function,
-		Description: d.Description,
+		Method:             d.Method,
+		Summary:            d.Summary,
+		Function:           d.Function,
+		Description:        d.Description,
+		Timeout:            d.Timeout,
+		MaxBodySize:        d.MaxBodySize,
+		SuccessCode:        d.SuccessCode,
+		AccumulateErrors:   d.AccumulateErrors,
+		Deprecated:         d.Deprecated,
+		DeprecationMessage: d.DeprecationMessage,
+		PartialResponse:    d.PartialResponse,
 	}
 	if len(d.Consumes) > 0 {
 		consumes = d.Consumes
@@ -164,8 +228,24 @@ func (b *builder) copyDefinition(d *definition.Definition, consumes []string, pr
 		newParameter := p
 		newParameter.Operators = make([]definition.Operator, len(p.Operators))
 		copy(newParameter.Operators, p.Operators)
+		if len(p.Aliases) > 0 {
+			newParameter.Aliases = make([]string, len(p.Aliases))
+			copy(newParameter.Aliases, p.Aliases)
+		}
 		newOne.Parameters[i] = newParameter
 	}
+	if len(d.ResponseOperators) > 0 {
+		newOne.ResponseOperators = make([]definition.Operator, len(d.ResponseOperators))
+		copy(newOne.ResponseOperators, d.ResponseOperators)
+	}
+	if len(d.Security) > 0 {
+		newOne.Security = make([]definition.SecurityRequirement, len(d.Security))
+		copy(newOne.Security, d.Security)
+	}
+	if len(d.Middlewares) > 0 {
+		newOne.Middlewares = make([]definition.Middleware, len(d.Middlewares))
+		copy(newOne.Middlewares, d.Middlewares)
+	}
 	newOne.Results = make([]definition.Result, len(d.Results))
 	for i, r := range d.Results {
 		newResult := r
@@ -204,19 +284,67 @@ func (b *builder) APIStyle() service.APIStyle {
 	return service.APIStyleREST
 }
 
-// Build builds a service to handle request.
+// Build builds a service to handle request. The service implements
+// service.DynamicService.
 func (b *builder) Build() (service.Service, error) {
 	if len(b.bindings) <= 0 {
 		return nil, noRouter.Error()
 	}
+	if b.cors != nil {
+		if err := b.cors.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	root, err := b.buildRouter()
+	if err != nil {
+		return nil, err
+	}
+	s := &server{
+		builder:   b.clone(),
+		filters:   b.filters,
+		logger:    b.logger,
+		producers: service.AllProducers(),
+		cors:      b.cors,
+	}
+	s.root.Store(root)
+	return s, nil
+}
+
+// buildRouter builds a router tree from bindings.
+func (b *builder) buildRouter() (router.Router, error) {
+	return b.buildRouterWith(nil)
+}
+
+// buildRouterWith builds the router of bindings. Paths are built in order. If
+// errs is nil, it returns the first error. Otherwise errors are appended to errs
+// and invalid paths and definitions are skipped, so that all errors are found.
+func (b *builder) buildRouterWith(errs *DescriptorErrors) (router.Router, error) {
+	// fail returns true if the error should stop building.
+	fail := func(path string, method definition.Method, err error) bool {
+		if errs == nil {
+			return true
+		}
+		*errs = append(*errs, &DescriptorError{Path: path, Method: method, Err: err})
+		return false
+	}
+	paths := make([]string, 0, len(b.bindings))
+	for path := range b.bindings {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
 	var root router.Router
-	for path, bd := range b.bindings {
+	for _, path := range paths {
+		bd := b.bindings[path]
 		b.logger.V(log.LevelDebug).Infof("Definitions: %d Middlewares: %d Path: %s",
 			len(bd.definitions), len(bd.middlewares), path)
 		top, leaf, err := router.Parse(path)
 		if err != nil {
 			b.logger.Errorf("Can't parse path: %s, %s", path, err.Error())
-			return nil, err
+			if fail(path, "", err) {
+				return nil, err
+			}
+			continue
 		}
 		if len(bd.definitions) > 0 {
 			// RedirectTrailingSlash would redirect "/somepath/" to "/somepath". Any definition under "/somepath/"
@@ -224,17 +352,20 @@ func (b *builder) Build() (service.Service, error) {
 			if len(path) > 1 && strings.HasSuffix(path, "/") {
 				b.logger.Warningf("If RedirectTrailingSlash filter is enabled, following %d definition(s) would not be executed", len(bd.definitions))
 			}
-			inspector := newInspector(path)
+			inspector := newInspector(path, b.interceptors)
 			for _, d := range bd.definitions {
 				b.logger.V(log.LevelDebug).Infof("  Method: %s Consumes: %v Produces: %v",
 					d.Method, d.Consumes, d.Produces)
 				if b.modifier != nil {
 					b.modifier(&d)
 				}
-				if err := inspector.addDefinition(d); err != nil {
+				if err := inspector.addDefinition(d); err != nil && fail(path, d.Method, err) {
 					return nil, err
 				}
 			}
+			if b.autoHead || bd.autoHead {
+				inspector.deriveHead()
+			}
 
 			leaf.SetInspector(inspector)
 		}
@@ -243,24 +374,99 @@ func (b *builder) Build() (service.Service, error) {
 		}
 		if root == nil {
 			root = top
-		} else if root, err = root.Merge(top); err != nil {
-			return nil, err
+			continue
+		}
+		merged, err := root.Merge(top)
+		if err != nil {
+			if fail(path, "", err) {
+				return nil, err
+			}
+			continue
 		}
+		root = merged
 	}
-	s := &server{
-		root:      root,
-		filters:   b.filters,
-		logger:    b.logger,
-		producers: service.AllProducers(),
+	return root, nil
+}
+
+// clone copies the builder. Bindings of the copy can be changed without
+// affecting the original one.
+func (b *builder) clone() *builder {
+	newOne := *b
+	newOne.interceptors = append([]service.Interceptor(nil), b.interceptors...)
+	newOne.bindings = make(map[string]*binding, len(b.bindings))
+	for path, bd := range b.bindings {
+		newOne.bindings[path] = &binding{
+			middlewares: append([]definition.Middleware(nil), bd.middlewares...),
+			definitions: append([]definition.Definition(nil), bd.definitions...),
+			autoHead:    bd.autoHead,
+		}
 	}
-	return s, nil
+	return &newOne
 }
 
 type server struct {
-	root      router.Router
+	// lock serializes changes of routes.
+	lock sync.Mutex
+	// builder holds bindings of current routes.
+	builder *builder
+	// root stores current router.Router. It's replaced as a whole when routes
+	// change, so requests always match a complete router tree.
+	root      atomic.Value
 	filters   []service.Filter
 	logger    log.Logger
 	producers []service.Producer
+	cors      *service.CORSOptions
+}
+
+// Register adds descriptors to the running service. Requests after it returns
+// are matched with new routes. If descriptors conflict with existing routes, an
+// error is returned and routes are unchanged.
+func (s *server) Register(descriptors ...interface{}) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	b := s.builder.clone()
+	if err := b.AddDescriptor(descriptors...); err != nil {
+		return err
+	}
+	return s.swap(b)
+}
+
+// Deregister removes definitions and middlewares of paths and their sub paths
+// from the running service. In-flight requests of removed routes still complete,
+// but new requests get 404. Paths must be the same as paths of descriptors, and
+// it returns an error if a path has no routes.
+func (s *server) Deregister(paths ...string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	b := s.builder.clone()
+	for _, path := range paths {
+		path = "/" + strings.Trim(path, "/")
+		removed := false
+		for p := range b.bindings {
+			if p == path || strings.HasPrefix(p, strings.TrimRight(path, "/")+"/") {
+				delete(b.bindings, p)
+				removed = true
+			}
+		}
+		if !removed {
+			return noRouteForPath.Error(path)
+		}
+	}
+	if len(b.bindings) <= 0 {
+		return noRouter.Error()
+	}
+	return s.swap(b)
+}
+
+// swap builds a router from b and replaces current router with it.
+func (s *server) swap(b *builder) error {
+	root, err := b.buildRouter()
+	if err != nil {
+		return err
+	}
+	s.builder = b
+	s.root.Store(root)
+	return nil
 }
 
 func (s *server) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
@@ -270,10 +476,34 @@ func (s *server) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 		}
 	}
 	ctx := service.NewHTTPContext(resp, req)
+	defer func() {
+		if r := recover(); r != nil {
+			service.Recover(ctx, s.logger, s.producers, r)
+		}
+	}()
 
-	executor, err := s.root.Match(ctx, ctx.ValueContainer(), req.URL.EscapedPath())
+	root := s.root.Load().(router.Router)
+	executor, err := root.Match(ctx, ctx.ValueContainer(), req.URL.EscapedPath())
+	if s.cors != nil {
+		if s.cors.IsPreflight(req) && routeExists(err) {
+			s.cors.HandlePreflight(ctx.ResponseWriter(), req)
+			return
+		}
+		s.cors.SetHeaders(ctx.ResponseWriter().Header(), req)
+	}
 	if err != nil {
-		if err := service.WriteError(ctx, s.producers, err); err != nil {
+		if noExecutorForMethod.Derived(err) {
+			if e, ok := err.(interface{ Data() map[string]string }); ok {
+				ctx.ResponseWriter().Header().Set("Allow", e.Data()["allow"])
+			}
+		}
+		producers := s.producers
+		if noExecutorToProduce.Derived(err) {
+			// The client accepts none of the types which the definitions produce,
+			// so the error is written as json rather than an undeclared type.
+			producers = []service.Producer{service.ProducerFor(definition.MIMEJSON)}
+		}
+		if err := service.WriteError(ctx, producers, err); err != nil {
 			s.logger.Error(err)
 		}
 		return
@@ -292,3 +522,12 @@ func (s *server) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 		}
 	}
 }
+
+// routeExists checks whether the error of matching means that the path is
+// registered but no definition can handle the request.
+func routeExists(err error) bool {
+	return err == nil ||
+		noExecutorForMethod.Derived(err) ||
+		noExecutorForContentType.Derived(err) ||
+		noExecutorToProduce.Derived(err)
+}
@@ -17,18 +17,37 @@ limitations under the License.
 package rest
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/caicloud/nirvana/definition"
 	"github.com/caicloud/nirvana/errors"
+	"github.com/caicloud/nirvana/operators/validator"
 	"github.com/caicloud/nirvana/service"
+	"github.com/caicloud/nirvana/service/executor"
 )
 
 type responseWriter struct {
@@ -485,6 +504,4402 @@ func TestErrorProduces(t *testing.T) {
 	}
 }
 
+func TestMultipleDataResults(t *testing.T) {
+	newDesc := func(results ...definition.Result) definition.Descriptor {
+		return definition.Descriptor{
+			Path:     "/api/v1/items",
+			Consumes: []string{definition.MIMENone},
+			Produces: []string{definition.MIMEJSON},
+			Definitions: []definition.Definition{
+				{
+					Method: definition.List,
+					Function: func(ctx context.Context) ([]string, map[string]string, string, error) {
+						return []string{"a", "b"}, map[string]string{"X-Total": "2"}, "next", nil
+					},
+					Results: results,
+				},
+			},
+		}
+	}
+
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	err := builder.AddDescriptor(newDesc(
+		definition.DataFieldResultFor("items", ""),
+		definition.MetaResultFor(""),
+		definition.DataFieldResultFor("continue", ""),
+		definition.ErrorResult(),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &http.Request{
+		Method: "GET",
+		Header: http.Header{
+			"Accept": []string{definition.MIMEJSON},
+		},
+	}
+	req = req.WithContext(context.Background())
+	req.URL, _ = url.Parse("/api/v1/items")
+	resp := newRW()
+	s.ServeHTTP(resp, req)
+	if resp.code != 200 {
+		t.Fatalf("Response code should be 200, but got: %d", resp.code)
+	}
+	if resp.header.Get("X-Total") != "2" {
+		t.Fatalf("Response header X-Total is not desired: %s", resp.header.Get("X-Total"))
+	}
+	desired := `{"continue":"next","items":["a","b"]}` + "\n"
+	if resp.buf.String() != desired {
+		t.Fatalf("Response data is not desired: %s", resp.buf.String())
+	}
+
+	invalid := [][]definition.Result{
+		{
+			definition.DataResultFor(""),
+			definition.MetaResultFor(""),
+			definition.DataFieldResultFor("continue", ""),
+			definition.ErrorResult(),
+		},
+		{
+			definition.DataFieldResultFor("items", ""),
+			definition.MetaResultFor(""),
+			definition.DataFieldResultFor("items", ""),
+			definition.ErrorResult(),
+		},
+	}
+	for _, results := range invalid {
+		builder := NewBuilder()
+		builder.SetModifier(service.FirstContextParameter())
+		if err := builder.AddDescriptor(newDesc(results...)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := builder.Build(); err == nil {
+			t.Fatalf("Ambiguous data results should be rejected: %+v", results)
+		}
+	}
+}
+
+func TestStreamResult(t *testing.T) {
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/api/v1",
+		Consumes: []string{definition.MIMENone},
+		Produces: []string{definition.MIMEEventStream, definition.MIMEText},
+		Children: []definition.Descriptor{
+			{
+				Path: "/events",
+				Definitions: []definition.Definition{
+					{
+						Method: definition.Get,
+						Function: func(ctx context.Context) (<-chan interface{}, error) {
+							ch := make(chan interface{})
+							go func() {
+								defer close(ch)
+								for _, v := range []interface{}{"hello", map[string]int{"count": 1}} {
+									select {
+									case ch <- v:
+									case <-ctx.Done():
+										return
+									}
+								}
+							}()
+							return ch, nil
+						},
+						Results: []definition.Result{
+							definition.StreamResultFor(""),
+							definition.ErrorResult(),
+						},
+					},
+				},
+			},
+			{
+				Path: "/logs",
+				Definitions: []definition.Definition{
+					{
+						Method: definition.Get,
+						Function: func(ctx context.Context) (io.Reader, error) {
+							return strings.NewReader("line1\nline2\n"), nil
+						},
+						Results: []definition.Result{
+							definition.StreamResultFor(""),
+							definition.ErrorResult(),
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("GET", "/api/v1/events", nil)
+	req.Header.Set("Accept", definition.MIMEEventStream)
+	resp := newRW()
+	s.ServeHTTP(resp, req)
+	if resp.code != 200 {
+		t.Fatalf("Response code should be 200, but got: %d", resp.code)
+	}
+	if resp.header.Get("Content-Type") != definition.MIMEEventStream {
+		t.Fatalf("Content-Type should be %s, but got: %s", definition.MIMEEventStream, resp.header.Get("Content-Type"))
+	}
+	desired := "data: hello\n\ndata: {\"count\":1}\n\n"
+	if resp.buf.String() != desired {
+		t.Fatalf("Response data is not desired: %q", resp.buf.String())
+	}
+
+	req, _ = http.NewRequest("GET", "/api/v1/logs", nil)
+	req.Header.Set("Accept", definition.MIMEText)
+	resp = newRW()
+	s.ServeHTTP(resp, req)
+	if resp.header.Get("Transfer-Encoding") != "chunked" {
+		t.Fatalf("Transfer-Encoding should be chunked, but got: %s", resp.header.Get("Transfer-Encoding"))
+	}
+	if resp.buf.String() != "line1\nline2\n" {
+		t.Fatalf("Response data is not desired: %q", resp.buf.String())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ = http.NewRequest("GET", "/api/v1/events", nil)
+	req.Header.Set("Accept", definition.MIMEEventStream)
+	resp = newRW()
+	s.ServeHTTP(resp, req.WithContext(ctx))
+	if resp.buf.Len() != 0 {
+		t.Fatalf("Canceled request should not receive events: %q", resp.buf.String())
+	}
+}
+
+func TestDefaultValueOperator(t *testing.T) {
+	newDesc := func(op definition.Operator) definition.Descriptor {
+		return definition.Descriptor{
+			Path:     "/api/v1/items",
+			Consumes: []string{definition.MIMENone},
+			Produces: []string{definition.MIMEJSON},
+			Definitions: []definition.Definition{
+				{
+					Method: definition.List,
+					Function: func(ctx context.Context, limit int) (int, error) {
+						return limit, nil
+					},
+					Parameters: []definition.Parameter{
+						definition.QueryParameterFor("limit", "", op),
+					},
+					Results: definition.DataErrorResults(""),
+				},
+			},
+		}
+	}
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(newDesc(definition.DefaultValueOperator(20))); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for query, desired := range map[string]string{
+		"":         "20",
+		"?limit=":  "20",
+		"?limit=0": "20",
+		"?limit=5": "5",
+	} {
+		req, _ := http.NewRequest("GET", "/api/v1/items"+query, nil)
+		req.Header.Set("Accept", definition.MIMEJSON)
+		resp := newRW()
+		s.ServeHTTP(resp, req)
+		if resp.code != 200 {
+			t.Fatalf("Response code should be 200 for %q, but got: %d", query, resp.code)
+		}
+		if strings.TrimSpace(resp.buf.String()) != desired {
+			t.Fatalf("Response data for %q is not desired: %s", query, resp.buf.String())
+		}
+	}
+
+	builder = NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(newDesc(definition.DefaultValueOperator("20"))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := builder.Build(); err == nil {
+		t.Fatal("String default value should not be applied to an int parameter")
+	}
+}
+
+func TestRequiredParameter(t *testing.T) {
+	newDesc := func(param definition.Parameter) definition.Descriptor {
+		return definition.Descriptor{
+			Path:     "/api/v1/items",
+			Consumes: []string{definition.MIMENone},
+			Produces: []string{definition.MIMEJSON},
+			Definitions: []definition.Definition{
+				{
+					Method: definition.List,
+					Function: func(ctx context.Context, limit int) (int, error) {
+						return limit, nil
+					},
+					Parameters: []definition.Parameter{param},
+					Results:    definition.DataErrorResults(""),
+				},
+			},
+		}
+	}
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(newDesc(definition.QueryParameterFor("limit", "").AsRequired())); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for query, code := range map[string]int{
+		"":         400,
+		"?limit=0": 200,
+	} {
+		req, _ := http.NewRequest("GET", "/api/v1/items"+query, nil)
+		req.Header.Set("Accept", definition.MIMEJSON)
+		resp := newRW()
+		s.ServeHTTP(resp, req)
+		if resp.code != code {
+			t.Fatalf("Response code should be %d for %q, but got: %d", code, query, resp.code)
+		}
+	}
+
+	param := definition.QueryParameterFor("limit", "")
+	param.Required = true
+	param.Optional = true
+	builder = NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(newDesc(param)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := builder.Build(); err == nil {
+		t.Fatal("Parameter should not be both required and optional")
+	}
+}
+
+func TestDefinitionTimeout(t *testing.T) {
+	aborted := make(chan struct{})
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/api/v1",
+		Consumes: []string{definition.MIMENone},
+		Produces: []string{definition.MIMEJSON},
+		Children: []definition.Descriptor{
+			{
+				Path: "/slow",
+				Definitions: []definition.Definition{
+					{
+						Method:  definition.Get,
+						Timeout: 10 * time.Millisecond,
+						Function: func(ctx context.Context) (string, error) {
+							<-ctx.Done()
+							close(aborted)
+							return "slow", nil
+						},
+						Results: definition.DataErrorResults(""),
+					},
+				},
+			},
+			{
+				Path: "/fast",
+				Definitions: []definition.Definition{
+					{
+						Method:  definition.Get,
+						Timeout: time.Minute,
+						Function: func(ctx context.Context) (string, error) {
+							return "fast", nil
+						},
+						Results: definition.DataErrorResults(""),
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("GET", "/api/v1/slow", nil)
+	req.Header.Set("Accept", definition.MIMEJSON)
+	resp := newRW()
+	s.ServeHTTP(resp, req)
+	if resp.code != http.StatusGatewayTimeout {
+		t.Fatalf("Response code should be 504, but got: %d", resp.code)
+	}
+	if strings.Contains(resp.buf.String(), "slow") {
+		t.Fatalf("Results after timeout should be discarded: %s", resp.buf.String())
+	}
+	select {
+	case <-aborted:
+	case <-time.After(time.Second):
+		t.Fatal("Handler should observe the cancelled context")
+	}
+
+	req, _ = http.NewRequest("GET", "/api/v1/fast", nil)
+	req.Header.Set("Accept", definition.MIMEJSON)
+	resp = newRW()
+	s.ServeHTTP(resp, req)
+	if resp.code != 200 || resp.buf.String() != "fast" {
+		t.Fatalf("Response is not desired: %d, %s", resp.code, resp.buf.String())
+	}
+}
+
+func TestNumericRangeOperator(t *testing.T) {
+	newDesc := func(function interface{}) definition.Descriptor {
+		return definition.Descriptor{
+			Path:     "/api/v1/items",
+			Consumes: []string{definition.MIMENone},
+			Produces: []string{definition.MIMEJSON},
+			Definitions: []definition.Definition{
+				{
+					Method:   definition.List,
+					Function: function,
+					Parameters: []definition.Parameter{
+						definition.QueryParameterFor("limit", "", definition.NumericRangeOperator(1, 100)),
+					},
+					Results: definition.DataErrorResults(""),
+				},
+			},
+		}
+	}
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(newDesc(func(ctx context.Context, limit int64) (int64, error) {
+		return limit, nil
+	})); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for query, code := range map[string]int{
+		"?limit=1":   200,
+		"?limit=100": 200,
+		"?limit=101": 400,
+	} {
+		req, _ := http.NewRequest("GET", "/api/v1/items"+query, nil)
+		req.Header.Set("Accept", definition.MIMEJSON)
+		resp := newRW()
+		s.ServeHTTP(resp, req)
+		if resp.code != code {
+			t.Fatalf("Response code should be %d for %q, but got: %d", code, query, resp.code)
+		}
+	}
+
+	builder = NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(newDesc(func(ctx context.Context, limit string) (string, error) {
+		return limit, nil
+	})); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := builder.Build(); err == nil {
+		t.Fatal("NumericRangeOperator should not be applied to a string parameter")
+	}
+}
+
+func TestParameterConverter(t *testing.T) {
+	commaSeparated := definition.ConverterFor(definition.Query, func(ctx context.Context, raw []string) (interface{}, error) {
+		ids := []int{}
+		for _, value := range strings.Split(raw[0], ",") {
+			id, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, errors.BadRequest.Build("Test:InvalidID", "invalid id ${id}").Error(value)
+			}
+			ids = append(ids, id)
+		}
+		return ids, nil
+	})
+	newDesc := func(p definition.Parameter) definition.Descriptor {
+		return definition.Descriptor{
+			Path:     "/api/v1/items",
+			Consumes: []string{definition.MIMENone},
+			Produces: []string{definition.MIMEJSON},
+			Definitions: []definition.Definition{
+				{
+					Method: definition.List,
+					Function: func(ctx context.Context, ids []int) (int, error) {
+						return len(ids), nil
+					},
+					Parameters: []definition.Parameter{p},
+					Results:    definition.DataErrorResults(""),
+				},
+			},
+		}
+	}
+	param := definition.QueryParameterFor("ids", "")
+	param.Converter = commaSeparated
+	param.Default = []int{}
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(newDesc(param)); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		query string
+		code  int
+		body  string
+	}{
+		{"?ids=1,2,3", 200, "3\n"},
+		{"", 200, "0\n"},
+		{"?ids=1,a", 400, ""},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/api/v1/items"+test.query, nil)
+		req.Header.Set("Accept", definition.MIMEJSON)
+		resp := newRW()
+		s.ServeHTTP(resp, req)
+		if resp.code != test.code {
+			t.Fatalf("Response code should be %d for %q, but got: %d", test.code, test.query, resp.code)
+		}
+		if test.body != "" && resp.buf.String() != test.body {
+			t.Fatalf("Response body is not desired for %q: %s", test.query, resp.buf.String())
+		}
+	}
+
+	param = definition.PathParameterFor("ids", "")
+	param.Converter = commaSeparated
+	builder = NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(newDesc(param)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := builder.Build(); err == nil {
+		t.Fatal("Converter for query should not be applied to a path parameter")
+	}
+}
+
+func TestDescriptorMiddlewares(t *testing.T) {
+	trace := []string{}
+	record := func(name string) definition.Middleware {
+		return func(ctx context.Context, next definition.Chain) error {
+			trace = append(trace, name)
+			return next.Continue(ctx)
+		}
+	}
+	auth := func(ctx context.Context, next definition.Chain) error {
+		trace = append(trace, "auth")
+		if service.HTTPContextFrom(ctx).Request().Header.Get("Authorization") == "" {
+			return errors.Unauthorized.Error("unauthorized")
+		}
+		return next.Continue(ctx)
+	}
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:        "/api/v1",
+		Consumes:    []string{definition.MIMENone},
+		Produces:    []string{definition.MIMEJSON},
+		Middlewares: []definition.Middleware{auth},
+		Children: []definition.Descriptor{
+			{
+				Path:        "/apps",
+				Middlewares: []definition.Middleware{record("apps")},
+				Children: []definition.Descriptor{
+					{
+						Path:        "/{app}",
+						Middlewares: []definition.Middleware{record("app")},
+						Definitions: []definition.Definition{
+							{
+								Method: definition.Get,
+								Function: func(ctx context.Context) (string, error) {
+									trace = append(trace, "handler")
+									return "ok", nil
+								},
+								Results: definition.DataErrorResults(""),
+							},
+						},
+					},
+				},
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("GET", "/api/v1/apps/test", nil)
+	req.Header.Set("Accept", definition.MIMEJSON)
+	req.Header.Set("Authorization", "token")
+	resp := newRW()
+	s.ServeHTTP(resp, req)
+	if resp.code != http.StatusOK {
+		t.Fatalf("Response code should be 200, but got: %d", resp.code)
+	}
+	if strings.Join(trace, ",") != "auth,apps,app,handler" {
+		t.Fatalf("Middlewares are executed in wrong order: %v", trace)
+	}
+
+	trace = nil
+	req.Header.Del("Authorization")
+	resp = newRW()
+	s.ServeHTTP(resp, req)
+	if resp.code != http.StatusUnauthorized {
+		t.Fatalf("Response code should be 401, but got: %d", resp.code)
+	}
+	if strings.Join(trace, ",") != "auth" {
+		t.Fatalf("Middlewares should be short-circuited: %v", trace)
+	}
+	if !strings.Contains(resp.buf.String(), "unauthorized") {
+		t.Fatalf("Error is not written: %s", resp.buf.String())
+	}
+}
+
+func TestAttachmentResult(t *testing.T) {
+	content := "id,name\n1,nirvana\n"
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/api/v1/exports",
+		Consumes: []string{definition.MIMENone},
+		Produces: []string{definition.MIMEOctetStream},
+		Definitions: []definition.Definition{
+			{
+				Method: definition.Get,
+				Function: func(ctx context.Context) (*definition.FileContent, error) {
+					return &definition.FileContent{
+						Name:        "export.csv",
+						ContentType: "text/csv",
+						Content:     strings.NewReader(content),
+					}, nil
+				},
+				Results: []definition.Result{
+					definition.FileResultFor(""),
+					definition.ErrorResult(),
+				},
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("GET", "/api/v1/exports", nil)
+	resp := newRW()
+	s.ServeHTTP(resp, req)
+	if resp.code != http.StatusOK {
+		t.Fatalf("Response code should be 200, but got: %d", resp.code)
+	}
+	if d := resp.header.Get("Content-Disposition"); d != `attachment; filename=export.csv` {
+		t.Fatalf("Content-Disposition is not desired: %s", d)
+	}
+	if ct := resp.header.Get("Content-Type"); ct != "text/csv" {
+		t.Fatalf("Content-Type is not desired: %s", ct)
+	}
+	if resp.buf.String() != content {
+		t.Fatalf("Response body is not desired: %s", resp.buf.String())
+	}
+
+	req.Header.Set("Range", "bytes=3-6")
+	resp = newRW()
+	s.ServeHTTP(resp, req)
+	if resp.code != http.StatusPartialContent {
+		t.Fatalf("Response code should be 206, but got: %d", resp.code)
+	}
+	if resp.buf.String() != content[3:7] {
+		t.Fatalf("Partial content is not desired: %s", resp.buf.String())
+	}
+}
+
+func TestMaxBodySize(t *testing.T) {
+	newDefinition := func(size int64) definition.Definition {
+		return definition.Definition{
+			Method:      definition.Create,
+			MaxBodySize: size,
+			Function: func(ctx context.Context, data *struct{ Name string }) (string, error) {
+				return data.Name, nil
+			},
+			Parameters: []definition.Parameter{definition.BodyParameterFor("")},
+			Results:    definition.DataErrorResults(""),
+		}
+	}
+	builder := NewBuilder()
+	builder.SetModifier(service.DefinitionModifiers{
+		service.FirstContextParameter(),
+		service.DefaultMaxBodySize(32),
+	}.Combine())
+	if err := builder.AddDescriptor(
+		definition.Descriptor{
+			Path:        "/limited",
+			Consumes:    []string{definition.MIMEJSON},
+			Produces:    []string{definition.MIMEJSON},
+			Definitions: []definition.Definition{newDefinition(0)},
+		},
+		definition.Descriptor{
+			Path:        "/unlimited",
+			Consumes:    []string{definition.MIMEJSON},
+			Produces:    []string{definition.MIMEJSON},
+			Definitions: []definition.Definition{newDefinition(-1)},
+		},
+	); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	small := `{"name":"nirvana"}`
+	large := `{"name":"` + strings.Repeat("a", 64) + `"}`
+	tests := []struct {
+		path string
+		body io.Reader
+		code int
+	}{
+		{"/limited", strings.NewReader(small), http.StatusCreated},
+		{"/limited", strings.NewReader(large), http.StatusRequestEntityTooLarge},
+		// Readers without length make requests with unknown content length.
+		{"/limited", ioutil.NopCloser(strings.NewReader(large)), http.StatusRequestEntityTooLarge},
+		{"/unlimited", strings.NewReader(large), http.StatusCreated},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("POST", test.path, test.body)
+		req.Header.Set("Content-Type", definition.MIMEJSON)
+		req.Header.Set("Accept", definition.MIMEJSON)
+		resp := newRW()
+		s.ServeHTTP(resp, req)
+		if resp.code != test.code {
+			t.Fatalf("Response code of %s should be %d, but got: %d %s", test.path, test.code, resp.code, resp.buf.String())
+		}
+	}
+}
+
+type envelope struct {
+	XMLName   xml.Name    `json:"-" xml:"envelope"`
+	RequestID string      `json:"requestID" xml:"requestID"`
+	Data      interface{} `json:"data" xml:"data"`
+}
+
+func TestResponseOperators(t *testing.T) {
+	wrap := definition.NewOperator(
+		"converter",
+		reflect.TypeOf((*interface{})(nil)).Elem(),
+		reflect.TypeOf(&envelope{}),
+		func(ctx context.Context, field string, object interface{}) (interface{}, error) {
+			id := service.HTTPContextFrom(ctx).Request().Header.Get("X-Request-Id")
+			return &envelope{RequestID: id, Data: object}, nil
+		},
+	)
+	upper := definition.OperatorFunc("converter", func(ctx context.Context, field string, value string) (string, error) {
+		return strings.ToUpper(value), nil
+	})
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/api/v1/apps/{app}",
+		Consumes: []string{definition.MIMENone},
+		Produces: []string{definition.MIMEJSON, definition.MIMEXML},
+		Definitions: []definition.Definition{
+			{
+				Method: definition.Get,
+				Function: func(ctx context.Context, app string) (string, error) {
+					if app == "missing" {
+						return "", errors.NotFound.Error("not found")
+					}
+					return app, nil
+				},
+				Parameters: []definition.Parameter{definition.PathParameterFor("app", "")},
+				Results: []definition.Result{
+					definition.DataResultFor("", upper),
+					definition.ErrorResult(),
+				},
+				ResponseOperators: []definition.Operator{wrap},
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path   string
+		accept string
+		code   int
+		body   string
+	}{
+		{"/api/v1/apps/test", definition.MIMEJSON, 200, `{"requestID":"1","data":"TEST"}` + "\n"},
+		{"/api/v1/apps/test", definition.MIMEXML, 200, `<envelope><requestID>1</requestID><data>TEST</data></envelope>`},
+		{"/api/v1/apps/missing", definition.MIMEJSON, 404, `{"message":"not found"}` + "\n"},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", test.path, nil)
+		req.Header.Set("Accept", test.accept)
+		req.Header.Set("X-Request-Id", "1")
+		resp := newRW()
+		s.ServeHTTP(resp, req)
+		if resp.code != test.code {
+			t.Fatalf("Response code of %s should be %d, but got: %d", test.path, test.code, resp.code)
+		}
+		if resp.buf.String() != test.body {
+			t.Fatalf("Response body of %s is not desired: %s", test.path, resp.buf.String())
+		}
+	}
+}
+
+func TestDeprecatedDefinition(t *testing.T) {
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/api/v1/apps",
+		Consumes: []string{definition.MIMENone},
+		Produces: []string{definition.MIMEJSON},
+		Definitions: []definition.Definition{
+			{
+				Method:             definition.List,
+				Deprecated:         true,
+				DeprecationMessage: "use /api/v2/apps instead",
+				Function: func(ctx context.Context) (string, error) {
+					return "apps", nil
+				},
+				Results: definition.DataErrorResults(""),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "/api/v1/apps", nil)
+	req.Header.Set("Accept", definition.MIMEJSON)
+	resp := newRW()
+	s.ServeHTTP(resp, req)
+	if resp.code != http.StatusOK || resp.buf.String() != "apps" {
+		t.Fatalf("Response should not be modified: %d %s", resp.code, resp.buf.String())
+	}
+	if resp.header.Get("Deprecation") != "true" {
+		t.Fatalf("Deprecation header is not desired: %s", resp.header.Get("Deprecation"))
+	}
+	if w := resp.header.Get("Warning"); w != `299 - "use /api/v2/apps instead"` {
+		t.Fatalf("Warning header is not desired: %s", w)
+	}
+}
+
+type pageHeaders struct {
+	RequestID string   `header:"X-Request-Id"`
+	Total     int      `header:"X-Total-Count"`
+	Links     []string `header:"Link"`
+	Ignored   string
+}
+
+func TestHeaderResultOperator(t *testing.T) {
+	newDesc := func(f interface{}) definition.Descriptor {
+		return definition.Descriptor{
+			Path:     "/api/v1/apps",
+			Consumes: []string{definition.MIMENone},
+			Produces: []string{definition.MIMEJSON},
+			Definitions: []definition.Definition{
+				{
+					Method:   definition.List,
+					Function: f,
+					Results: []definition.Result{
+						definition.DataResultFor(""),
+						definition.MetaResultFor("", definition.HeaderResultOperator()),
+						definition.ErrorResult(),
+					},
+				},
+			},
+		}
+	}
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(newDesc(func(ctx context.Context) ([]string, *pageHeaders, error) {
+		return []string{"a"}, &pageHeaders{
+			RequestID: "abc",
+			Total:     10,
+			Links:     []string{"</apps?page=2>; rel=next", "</apps?page=5>; rel=last"},
+			Ignored:   "ignored",
+		}, nil
+	})); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "/api/v1/apps", nil)
+	req.Header.Set("Accept", definition.MIMEJSON)
+	resp := newRW()
+	s.ServeHTTP(resp, req)
+	if resp.code != http.StatusOK {
+		t.Fatalf("Response code should be 200, but got: %d", resp.code)
+	}
+	if resp.header.Get("X-Request-Id") != "abc" || resp.header.Get("X-Total-Count") != "10" {
+		t.Fatalf("Response headers are not desired: %v", resp.header)
+	}
+	if links := resp.header["Link"]; !reflect.DeepEqual(links, []string{"</apps?page=2>; rel=next", "</apps?page=5>; rel=last"}) {
+		t.Fatalf("Link headers are not desired: %v", links)
+	}
+	if _, ok := resp.header["Ignored"]; ok {
+		t.Fatalf("Fields without tag should be skipped: %v", resp.header)
+	}
+
+	for _, f := range []interface{}{
+		func(ctx context.Context) ([]string, map[string]string, error) { return nil, nil, nil },
+		func(ctx context.Context) ([]string, *struct {
+			Value float64 `header:"X-Value"`
+		}, error) {
+			return nil, nil, nil
+		},
+	} {
+		builder := NewBuilder()
+		builder.SetModifier(service.FirstContextParameter())
+		if err := builder.AddDescriptor(newDesc(f)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := builder.Build(); err == nil {
+			t.Fatalf("Invalid meta type should be rejected: %T", f)
+		}
+	}
+}
+
+type autoUser struct {
+	Name string
+}
+
+type autoTenant struct {
+	Name string
+}
+
+func TestAutoContextValues(t *testing.T) {
+	inject := func(ctx context.Context, next definition.Chain) error {
+		if service.HTTPContextFrom(ctx).Request().Header.Get("Authorization") != "" {
+			ctx = service.WithAutoValue(ctx, &autoTenant{Name: "tenant"})
+			ctx = service.WithAutoValue(ctx, &autoUser{Name: "user"})
+		}
+		return next.Continue(ctx)
+	}
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:        "/api/v1/whoami",
+		Consumes:    []string{definition.MIMENone},
+		Produces:    []string{definition.MIMEText},
+		Middlewares: []definition.Middleware{inject},
+		Definitions: []definition.Definition{
+			{
+				Method: definition.Get,
+				Function: func(ctx context.Context, user *autoUser, tenant *autoTenant) (string, error) {
+					return user.Name + "@" + tenant.Name, nil
+				},
+				Parameters: []definition.Parameter{
+					definition.AutoParameterFor("current user"),
+					definition.AutoParameterFor("current tenant"),
+				},
+				Results: definition.DataErrorResults(""),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "/api/v1/whoami", nil)
+	req.Header.Set("Accept", definition.MIMEText)
+	req.Header.Set("Authorization", "token")
+	resp := newRW()
+	s.ServeHTTP(resp, req)
+	if resp.code != http.StatusOK || resp.buf.String() != "user@tenant" {
+		t.Fatalf("Response is not desired: %d %s", resp.code, resp.buf.String())
+	}
+
+	req, _ = http.NewRequest("GET", "/api/v1/whoami", nil)
+	req.Header.Set("Accept", definition.MIMEText)
+	resp = newRW()
+	s.ServeHTTP(resp, req)
+	if resp.code != http.StatusInternalServerError {
+		t.Fatalf("Absent auto values should be rejected, but got: %d %s", resp.code, resp.buf.String())
+	}
+}
+
+type autoUserPrefab struct{}
+
+func (p *autoUserPrefab) Name() string { return "test-auto-user-name" }
+
+func (p *autoUserPrefab) Type() reflect.Type { return reflect.TypeOf("") }
+
+func (p *autoUserPrefab) Make(ctx context.Context) (interface{}, error) {
+	if user, ok := service.AutoValueFrom(ctx, reflect.TypeOf(&autoUser{})); ok {
+		return user.(*autoUser).Name, nil
+	}
+	return "", nil
+}
+
+func TestOperatorAutoValues(t *testing.T) {
+	if err := service.RegisterPrefab(&autoUserPrefab{}); err != nil {
+		t.Fatal(err)
+	}
+	authenticate := definition.OperatorFunc("authenticate", func(ctx context.Context, field string, token string) (string, error) {
+		if token != "" {
+			service.SetAutoValue(ctx, &autoUser{Name: strings.TrimPrefix(token, "Bearer ")})
+		}
+		return token, nil
+	})
+	authorize := definition.OperatorFunc("authorize", func(ctx context.Context, field string, tenant string) (string, error) {
+		user, ok := service.AutoValueFrom(ctx, reflect.TypeOf(&autoUser{}))
+		if !ok {
+			return "", errors.Unauthorized.Error("no user")
+		}
+		service.SetAutoValue(ctx, &autoTenant{Name: user.(*autoUser).Name + "/" + tenant})
+		return tenant, nil
+	})
+	inject := func(ctx context.Context, next definition.Chain) error {
+		return next.Continue(service.WithAutoValue(ctx, &autoUser{Name: "middleware"}))
+	}
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/api/v1/whoami",
+		Consumes: []string{definition.MIMENone},
+		Produces: []string{definition.MIMEText},
+		Definitions: []definition.Definition{
+			{
+				Method: definition.Get,
+				Function: func(ctx context.Context, token, tenant string, user *autoUser, t *autoTenant, name string) (string, error) {
+					return user.Name + "@" + t.Name + ":" + name, nil
+				},
+				Parameters: []definition.Parameter{
+					definition.HeaderParameterFor("Authorization", "", authenticate),
+					definition.QueryParameterFor("tenant", "", authorize),
+					definition.AutoParameterFor("current user"),
+					definition.AutoParameterFor("current tenant"),
+					definition.PrefabParameterFor("test-auto-user-name", ""),
+				},
+				Results: definition.DataErrorResults(""),
+			},
+		},
+	}, definition.Descriptor{
+		Path:        "/api/v1/injected",
+		Consumes:    []string{definition.MIMENone},
+		Produces:    []string{definition.MIMEText},
+		Middlewares: []definition.Middleware{inject},
+		Definitions: []definition.Definition{
+			{
+				Method: definition.Get,
+				Function: func(ctx context.Context, token string, user *autoUser) (string, error) {
+					return user.Name, nil
+				},
+				Parameters: []definition.Parameter{
+					definition.HeaderParameterFor("Authorization", "", authenticate),
+					definition.AutoParameterFor("current user"),
+				},
+				Results: definition.DataErrorResults(""),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("GET", "/api/v1/whoami?tenant=caicloud", nil)
+	req.Header.Set("Authorization", "Bearer alice")
+	resp := newRW()
+	s.ServeHTTP(resp, req)
+	if resp.code != http.StatusOK || resp.buf.String() != "alice@alice/caicloud:alice" {
+		t.Fatalf("Values set by operators should be visible to later operators and the handler: %d %s", resp.code, resp.buf.String())
+	}
+
+	req, _ = http.NewRequest("GET", "/api/v1/whoami?tenant=caicloud", nil)
+	resp = newRW()
+	s.ServeHTTP(resp, req)
+	if resp.code != http.StatusUnauthorized {
+		t.Fatalf("Values should not leak to other requests, but got: %d %s", resp.code, resp.buf.String())
+	}
+
+	// Values set by operators win over values of middlewares.
+	for token, want := range map[string]string{"": "middleware", "Bearer bob": "bob"} {
+		req, _ = http.NewRequest("GET", "/api/v1/injected", nil)
+		req.Header.Set("Authorization", token)
+		resp = newRW()
+		s.ServeHTTP(resp, req)
+		if resp.code != http.StatusOK || resp.buf.String() != want {
+			t.Fatalf("Token %q: user should be %s, but got: %d %s", token, want, resp.code, resp.buf.String())
+		}
+	}
+}
+
+func TestDescriptorTags(t *testing.T) {
+	builder := NewBuilder()
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/api/v1",
+		Consumes: []string{definition.MIMENone},
+		Produces: []string{definition.MIMEJSON},
+		Tags:     []string{"v1"},
+		Children: []definition.Descriptor{
+			{
+				Path: "/apps",
+				Tags: []string{"apps"},
+				Definitions: []definition.Definition{
+					{Method: definition.List, Function: func() (string, error) { return "", nil }, Results: definition.DataErrorResults("")},
+					{Method: definition.Create, Tags: []string{"admin"}, Function: func() (string, error) { return "", nil }, Results: definition.DataErrorResults("")},
+				},
+			},
+			{
+				Path: "/version",
+				Definitions: []definition.Definition{
+					{Method: definition.Get, Function: func() (string, error) { return "", nil }, Results: definition.DataErrorResults("")},
+				},
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	definitions := builder.Definitions()
+	tags := map[string][]string{}
+	for path, defs := range definitions {
+		for _, d := range defs {
+			tags[path+" "+string(d.Method)] = d.Tags
+		}
+	}
+	desired := map[string][]string{
+		"/api/v1/apps List":   {"apps"},
+		"/api/v1/apps Create": {"admin"},
+		"/api/v1/version Get": {"v1"},
+	}
+	if !reflect.DeepEqual(tags, desired) {
+		t.Fatalf("Tags are not inherited correctly: %v", tags)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	builder.(service.CORSBuilder).SetCORS(&service.CORSOptions{
+		AllowedOrigins:   []string{"https://app.example.com"},
+		AllowedMethods:   []string{http.MethodGet, http.MethodPost},
+		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		ExposedHeaders:   []string{"X-Total"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	})
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/api/v1/apps",
+		Consumes: []string{definition.MIMENone},
+		Produces: []string{definition.MIMEText},
+		Definitions: []definition.Definition{
+			{
+				Method: definition.List,
+				Function: func(ctx context.Context) (string, error) {
+					return "apps", nil
+				},
+				Results: definition.DataErrorResults(""),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	preflight := func(path, origin string) *responseWriter {
+		req, _ := http.NewRequest(http.MethodOptions, path, nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		req.Header.Set("Access-Control-Request-Headers", "content-type, authorization")
+		resp := newRW()
+		s.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := preflight("/api/v1/apps", "https://app.example.com")
+	if resp.code != http.StatusNoContent {
+		t.Fatalf("Preflight should be handled, but got: %d %s", resp.code, resp.buf.String())
+	}
+	desired := map[string]string{
+		"Access-Control-Allow-Origin":      "https://app.example.com",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Allow-Methods":     "GET, POST",
+		"Access-Control-Allow-Headers":     "content-type, authorization",
+		"Access-Control-Max-Age":           "600",
+	}
+	for key, value := range desired {
+		if resp.header.Get(key) != value {
+			t.Fatalf("Header %s is not desired: %s", key, resp.header.Get(key))
+		}
+	}
+
+	resp = preflight("/api/v1/apps", "https://evil.example.com")
+	if resp.code != http.StatusNoContent || resp.header.Get("Access-Control-Allow-Origin") != "" ||
+		resp.header.Get("Access-Control-Allow-Methods") != "" {
+		t.Fatalf("Preflight of disallowed origin should omit allow headers: %d %v", resp.code, resp.header)
+	}
+
+	resp = preflight("/api/v1/users", "https://app.example.com")
+	if resp.code != http.StatusNotFound {
+		t.Fatalf("Preflight of unregistered path should be not found, but got: %d", resp.code)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/apps", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	resp = newRW()
+	s.ServeHTTP(resp, req)
+	if resp.code != http.StatusOK || resp.buf.String() != "apps" {
+		t.Fatalf("Response is not desired: %d %s", resp.code, resp.buf.String())
+	}
+	if resp.header.Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		resp.header.Get("Access-Control-Expose-Headers") != "X-Total" {
+		t.Fatalf("CORS headers of actual response are not desired: %v", resp.header)
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, "/api/v1/apps", nil)
+	resp = newRW()
+	s.ServeHTTP(resp, req)
+	if resp.header.Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("Same-origin request should not have CORS headers: %v", resp.header)
+	}
+}
+
+func TestParameterAliases(t *testing.T) {
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/api/v1/users",
+		Consumes: []string{definition.MIMENone},
+		Produces: []string{definition.MIMEText},
+		Definitions: []definition.Definition{
+			{
+				Method: definition.List,
+				Function: func(ctx context.Context, id string, page int) (string, error) {
+					return id + ":" + strconv.Itoa(page), nil
+				},
+				Parameters: []definition.Parameter{
+					{Source: definition.Query, Name: "userId", Aliases: []string{"user_id", "uid"}, Default: "none"},
+					{Source: definition.Query, Name: "page", Aliases: []string{"p"}, Required: true},
+				},
+				Results: definition.DataErrorResults(""),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		query string
+		code  int
+		body  string
+	}{
+		{"userId=a&page=1", http.StatusOK, "a:1"},
+		{"user_id=b&p=2", http.StatusOK, "b:2"},
+		{"uid=c&page=3", http.StatusOK, "c:3"},
+		{"uid=c&user_id=b&userId=a&page=4&p=5", http.StatusOK, "a:4"},
+		{"uid=c&user_id=b&page=6", http.StatusOK, "b:6"},
+		{"page=7", http.StatusOK, "none:7"},
+		{"userId=a", http.StatusBadRequest, ""},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/api/v1/users?"+test.query, nil)
+		req.Header.Set("Accept", definition.MIMEText)
+		resp := newRW()
+		s.ServeHTTP(resp, req)
+		if resp.code != test.code {
+			t.Fatalf("Response code of %s should be %d, but got: %d %s", test.query, test.code, resp.code, resp.buf.String())
+		}
+		if test.body != "" && resp.buf.String() != test.body {
+			t.Fatalf("Response of %s is not desired: %s", test.query, resp.buf.String())
+		}
+	}
+
+	builder = NewBuilder()
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/api/v1/users",
+		Consumes: []string{definition.MIMEJSON},
+		Produces: []string{definition.MIMEText},
+		Definitions: []definition.Definition{
+			{
+				Method:     definition.Create,
+				Function:   func(body *struct{ Name string }) (string, error) { return "", nil },
+				Parameters: []definition.Parameter{{Source: definition.Body, Aliases: []string{"data"}}},
+				Results:    definition.DataErrorResults(""),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := builder.Build(); err == nil {
+		t.Fatal("Aliases of body parameters should be rejected")
+	}
+}
+
+type itemIterator struct {
+	items  []interface{}
+	calls  int
+	cancel context.CancelFunc
+}
+
+func (it *itemIterator) Next(ctx context.Context) (interface{}, error) {
+	it.calls++
+	if it.cancel != nil && it.calls >= 2 {
+		it.cancel()
+	}
+	if len(it.items) <= 0 {
+		return nil, io.EOF
+	}
+	item := it.items[0]
+	it.items = it.items[1:]
+	return item, nil
+}
+
+func TestNDJSONStream(t *testing.T) {
+	var it *itemIterator
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/api/v1",
+		Consumes: []string{definition.MIMENone},
+		Produces: []string{definition.MIMEJSON, definition.MIMENDJSON},
+		Children: []definition.Descriptor{
+			{
+				Path: "/stream",
+				Definitions: []definition.Definition{
+					{
+						Method: definition.Get,
+						Function: func(ctx context.Context) (definition.Iterator, error) {
+							return it, nil
+						},
+						Results: []definition.Result{
+							definition.StreamResultFor(""),
+							definition.ErrorResult(),
+						},
+					},
+				},
+			},
+			{
+				Path: "/list",
+				Definitions: []definition.Definition{
+					{
+						Method: definition.List,
+						Function: func(ctx context.Context) ([]map[string]int, error) {
+							return []map[string]int{{"id": 1}, {"id": 2}}, nil
+						},
+						Results: definition.DataErrorResults(""),
+					},
+				},
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(ctx context.Context, path, accept string) *responseWriter {
+		req, _ := http.NewRequest("GET", path, nil)
+		req = req.WithContext(ctx)
+		req.Header.Set("Accept", accept)
+		resp := newRW()
+		s.ServeHTTP(resp, req)
+		return resp
+	}
+
+	tests := []struct {
+		path    string
+		accept  string
+		items   []interface{}
+		desired string
+	}{
+		{"/api/v1/stream", definition.MIMENDJSON, []interface{}{"a", map[string]int{"id": 1}}, "\"a\"\n{\"id\":1}\n"},
+		{"/api/v1/stream", definition.MIMEJSON, []interface{}{"a", map[string]int{"id": 1}}, `["a",{"id":1}]`},
+		{"/api/v1/stream", definition.MIMEJSON, nil, `[]`},
+		{"/api/v1/list", definition.MIMENDJSON, nil, "{\"id\":1}\n{\"id\":2}\n"},
+		{"/api/v1/list", definition.MIMEJSON, nil, `[{"id":1},{"id":2}]` + "\n"},
+	}
+	for _, test := range tests {
+		it = &itemIterator{items: test.items}
+		resp := serve(context.Background(), test.path, test.accept)
+		if resp.code != http.StatusOK {
+			t.Fatalf("Response code should be 200, but got: %d %s", resp.code, resp.buf.String())
+		}
+		if ct := resp.header.Get("Content-Type"); ct != test.accept {
+			t.Fatalf("Content-Type should be %s, but got: %s", test.accept, ct)
+		}
+		if resp.buf.String() != test.desired {
+			t.Fatalf("Response of %s in %s is not desired: %q", test.path, test.accept, resp.buf.String())
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	it = &itemIterator{items: []interface{}{1, 2, 3, 4, 5}, cancel: cancel}
+	resp := serve(ctx, "/api/v1/stream", definition.MIMENDJSON)
+	if it.calls != 2 || resp.buf.String() != "1\n2\n" {
+		t.Fatalf("Iteration should stop when context is done: %d calls, %q", it.calls, resp.buf.String())
+	}
+
+	// An item which fails to be encoded is not counted, so the array is still closed.
+	it = &itemIterator{items: []interface{}{func() {}}}
+	resp = serve(context.Background(), "/api/v1/stream", definition.MIMEJSON)
+	if resp.buf.String() != "[]" {
+		t.Fatalf("Array should be closed after a failed item: %q", resp.buf.String())
+	}
+}
+
+func TestCatchAllPath(t *testing.T) {
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/files/{path:*}",
+		Consumes: []string{definition.MIMENone},
+		Produces: []string{definition.MIMEText},
+		Definitions: []definition.Definition{
+			{
+				Method: definition.Get,
+				Function: func(ctx context.Context, path string) (string, error) {
+					return path, nil
+				},
+				Parameters: []definition.Parameter{definition.PathParameterFor("path", "")},
+				Results:    definition.DataErrorResults(""),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		url  string
+		code int
+		body string
+	}{
+		{"/files/docs/guide.md", http.StatusOK, "docs/guide.md"},
+		{"/files/docs/", http.StatusOK, "docs/"},
+		{"/files/a%2Fb%20c", http.StatusOK, "a%2Fb c"},
+		{"/files/", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", test.url, nil)
+		resp := newRW()
+		s.ServeHTTP(resp, req)
+		if resp.code != test.code {
+			t.Fatalf("Response code of %s should be %d, but got: %d %s", test.url, test.code, resp.code, resp.buf.String())
+		}
+		if test.body != "" && resp.buf.String() != test.body {
+			t.Fatalf("Response of %s is not desired: %s", test.url, resp.buf.String())
+		}
+	}
+}
+
+func TestSuccessCode(t *testing.T) {
+	tests := []struct {
+		method   definition.Method
+		code     int
+		wantCode int
+		wantBody string
+	}{
+		{definition.Get, 0, http.StatusOK, "data"},
+		{definition.Create, 0, http.StatusCreated, "data"},
+		{definition.Create, http.StatusOK, http.StatusOK, "data"},
+		{definition.Get, http.StatusCreated, http.StatusCreated, "data"},
+		{definition.Update, http.StatusAccepted, http.StatusAccepted, "data"},
+		{definition.Update, http.StatusNoContent, http.StatusNoContent, ""},
+		{definition.Delete, 0, http.StatusNoContent, ""},
+	}
+	for _, test := range tests {
+		builder := NewBuilder()
+		builder.SetModifier(service.FirstContextParameter())
+		if err := builder.AddDescriptor(definition.Descriptor{
+			Path:     "/api/v1/jobs",
+			Consumes: []string{definition.MIMEAll},
+			Produces: []string{definition.MIMEText},
+			Definitions: []definition.Definition{
+				{
+					Method:      test.method,
+					SuccessCode: test.code,
+					Function: func(ctx context.Context, fail bool) (map[string]string, string, error) {
+						if fail {
+							return nil, "", errors.NotFound.Error("job not found")
+						}
+						return map[string]string{"Location": "/api/v1/jobs/1"}, "data", nil
+					},
+					Parameters: []definition.Parameter{definition.QueryParameterFor("fail", "")},
+					Results: []definition.Result{
+						definition.MetaResultFor(""),
+						definition.DataResultFor(""),
+						definition.ErrorResult(),
+					},
+				},
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		s, err := builder.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		httpMethod := service.HTTPMethodFor(test.method)
+		req, _ := http.NewRequest(httpMethod, "/api/v1/jobs", nil)
+		resp := newRW()
+		s.ServeHTTP(resp, req)
+		if resp.code != test.wantCode || resp.buf.String() != test.wantBody {
+			t.Fatalf("%s with code %d should return %d %q, but got: %d %q", httpMethod, test.code, test.wantCode, test.wantBody, resp.code, resp.buf.String())
+		}
+		if resp.header.Get("Location") != "/api/v1/jobs/1" {
+			t.Fatalf("Location header is not desired: %v", resp.header)
+		}
+
+		req, _ = http.NewRequest(httpMethod, "/api/v1/jobs?fail=true", nil)
+		resp = newRW()
+		s.ServeHTTP(resp, req)
+		if resp.code != http.StatusNotFound {
+			t.Fatalf("Error should be written with its own code, but got: %d", resp.code)
+		}
+	}
+
+	builder := NewBuilder()
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/api/v1/jobs",
+		Consumes: []string{definition.MIMEAll},
+		Produces: []string{definition.MIMEText},
+		Definitions: []definition.Definition{
+			{
+				Method:      definition.Get,
+				SuccessCode: 1000,
+				Function:    func() (string, error) { return "", nil },
+				Results:     definition.DataErrorResults(""),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := builder.Build(); !executor.DefinitionInvalidSuccessCode.Derived(err) {
+		t.Fatalf("Invalid success code should be rejected: %v", err)
+	}
+}
+
+func TestETag(t *testing.T) {
+	etag := service.StrongETag("v2")
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/api/v1/configs",
+		Consumes: []string{definition.MIMEAll},
+		Produces: []string{definition.MIMEText},
+		Definitions: []definition.Definition{
+			{
+				Method: definition.Get,
+				Function: func(ctx context.Context) (map[string]string, string, error) {
+					return map[string]string{"ETag": etag}, "config", nil
+				},
+				Results: []definition.Result{
+					definition.MetaResultFor(""),
+					definition.DataResultFor(""),
+					definition.ErrorResult(),
+				},
+			},
+			{
+				Method: definition.Update,
+				Function: func(ctx context.Context) (string, error) {
+					if err := service.CheckIfMatch(ctx, etag); err != nil {
+						return "", err
+					}
+					return "updated", nil
+				},
+				Results: definition.DataErrorResults(""),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		method string
+		header string
+		value  string
+		code   int
+		body   string
+	}{
+		{"GET", "", "", http.StatusOK, "config"},
+		{"GET", "If-None-Match", `"v2"`, http.StatusNotModified, ""},
+		{"GET", "If-None-Match", `"v1", W/"v2"`, http.StatusNotModified, ""},
+		{"GET", "If-None-Match", `*`, http.StatusNotModified, ""},
+		{"GET", "If-None-Match", `"v1"`, http.StatusOK, "config"},
+		{"GET", "If-None-Match", `"v1,v2"`, http.StatusOK, "config"},
+		{"PUT", "", "", http.StatusOK, "updated"},
+		{"PUT", "If-Match", `"v1", "v2"`, http.StatusOK, "updated"},
+		{"PUT", "If-Match", `*`, http.StatusOK, "updated"},
+		{"PUT", "If-Match", `"v1"`, http.StatusPreconditionFailed, ""},
+		{"PUT", "If-Match", `W/"v2"`, http.StatusPreconditionFailed, ""},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest(test.method, "/api/v1/configs", nil)
+		if test.header != "" {
+			req.Header.Set(test.header, test.value)
+		}
+		resp := newRW()
+		s.ServeHTTP(resp, req)
+		if resp.code != test.code {
+			t.Fatalf("%s with %s: %s should return %d, but got: %d %s", test.method, test.header, test.value, test.code, resp.code, resp.buf.String())
+		}
+		if test.code != http.StatusPreconditionFailed && resp.buf.String() != test.body {
+			t.Fatalf("%s with %s: %s returns wrong body: %q", test.method, test.header, test.value, resp.buf.String())
+		}
+		if test.method == "GET" && resp.header.Get("ETag") != etag {
+			t.Fatalf("ETag is not desired: %v", resp.header)
+		}
+	}
+}
+
+func TestAccumulateErrors(t *testing.T) {
+	for _, accumulate := range []bool{true, false} {
+		builder := NewBuilder()
+		builder.SetModifier(service.FirstContextParameter())
+		if err := builder.AddDescriptor(definition.Descriptor{
+			Path:     "/api/v1/users",
+			Consumes: []string{definition.MIMEAll},
+			Produces: []string{definition.MIMEJSON},
+			Definitions: []definition.Definition{
+				{
+					Method:           definition.Create,
+					AccumulateErrors: accumulate,
+					Function: func(ctx context.Context, name string, age int, page int, limit int) (string, error) {
+						return name, nil
+					},
+					Parameters: []definition.Parameter{
+						definition.QueryParameterFor("name", "", definition.RegexpOperator("^[a-z]+$")),
+						definition.QueryParameterFor("age", ""),
+						definition.QueryParameterFor("page", "").AsRequired(),
+						definition.HeaderParameterFor("X-Limit", ""),
+					},
+					Results: definition.DataErrorResults(""),
+				},
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		s, err := builder.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, _ := http.NewRequest("POST", "/api/v1/users?name=A1&age=old", nil)
+		req.Header.Set("X-Limit", "all")
+		resp := newRW()
+		s.ServeHTTP(resp, req)
+		if resp.code != http.StatusBadRequest {
+			t.Fatalf("Response code should be 400, but got: %d %s", resp.code, resp.buf.String())
+		}
+		if !accumulate {
+			if strings.Contains(resp.buf.String(), "old") || !strings.Contains(resp.buf.String(), "A1") {
+				t.Fatalf("Request should fail on the first error: %s", resp.buf.String())
+			}
+			continue
+		}
+		msg := struct {
+			Reason  string               `json:"reason"`
+			Message string               `json:"message"`
+			Errors  []service.FieldError `json:"errors"`
+		}{}
+		if err := json.Unmarshal(resp.buf.Bytes(), &msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Reason != service.InvalidParametersReason || msg.Message != "4 parameters are invalid" || len(msg.Errors) != 4 {
+			t.Fatalf("Errors are not aggregated: %s", resp.buf.String())
+		}
+		fields := []string{}
+		for _, e := range msg.Errors {
+			fields = append(fields, string(e.Source)+":"+e.Field)
+		}
+		if !reflect.DeepEqual(fields, []string{"Query:name", "Query:age", "Query:page", "Header:X-Limit"}) {
+			t.Fatalf("Errors are not in the order of parameters: %v", fields)
+		}
+		if msg.Errors[0].Reason != "Nirvana:Definition:UnmatchedPattern" || msg.Errors[2].Reason != "Nirvana:Service:RequiredParameter" {
+			t.Fatalf("Reasons of errors are not desired: %+v", msg.Errors)
+		}
+
+		req, _ = http.NewRequest("POST", "/api/v1/users?name=abc&page=1", nil)
+		resp = newRW()
+		s.ServeHTTP(resp, req)
+		if resp.code != http.StatusCreated || resp.buf.String() != "abc" {
+			t.Fatalf("Valid request should succeed: %d %s", resp.code, resp.buf.String())
+		}
+	}
+}
+
+func TestMultipleFiles(t *testing.T) {
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/api/v1/files",
+		Consumes: []string{definition.MIMEAll},
+		Produces: []string{definition.MIMEText},
+		Definitions: []definition.Definition{
+			{
+				Method: definition.Create,
+				Function: func(ctx context.Context, files []*multipart.FileHeader) (string, error) {
+					names := []string{}
+					for _, f := range files {
+						file, err := f.Open()
+						if err != nil {
+							return "", err
+						}
+						data, err := ioutil.ReadAll(file)
+						file.Close()
+						if err != nil {
+							return "", err
+						}
+						names = append(names, f.Filename+"="+string(data))
+					}
+					return fmt.Sprintf("%d:%s", len(files), strings.Join(names, ",")), nil
+				},
+				Parameters: []definition.Parameter{
+					definition.FileParameterFor("files", "", definition.FileSizeOperator(4, 10)),
+				},
+				Results: definition.DataErrorResults(""),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		files map[string]string
+		code  int
+		body  string
+	}{
+		{nil, http.StatusCreated, "0:"},
+		{map[string]string{"a.txt": "aa"}, http.StatusCreated, "1:a.txt=aa"},
+		{map[string]string{"a.txt": "aa", "b.txt": "bbb", "c.txt": "cccc"}, http.StatusCreated, "3:a.txt=aa,b.txt=bbb,c.txt=cccc"},
+		{map[string]string{"a.txt": "aaaaa"}, http.StatusRequestEntityTooLarge, "a.txt"},
+		{map[string]string{"a.txt": "aaaa", "b.txt": "bbbb", "c.txt": "cccc"}, http.StatusRequestEntityTooLarge, "12 bytes"},
+	}
+	for _, test := range tests {
+		body := &bytes.Buffer{}
+		w := multipart.NewWriter(body)
+		for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+			content, ok := test.files[name]
+			if !ok {
+				continue
+			}
+			part, err := w.CreateFormFile("files", name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := part.Write([]byte(content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.WriteField("other", "value"); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		req, _ := http.NewRequest("POST", "/api/v1/files", body)
+		req.Header.Set("Content-Type", w.FormDataContentType())
+		resp := newRW()
+		s.ServeHTTP(resp, req)
+		if resp.code != test.code || !strings.Contains(resp.buf.String(), test.body) {
+			t.Fatalf("Response of files %v is %d %s, but want %d %s", test.files, resp.code, resp.buf.String(), test.code, test.body)
+		}
+	}
+
+	// Malformed forms are rejected rather than treated as no files.
+	req, _ := http.NewRequest("POST", "/api/v1/files", strings.NewReader("--x\r\nbroken"))
+	req.Header.Set("Content-Type", `multipart/form-data; boundary="x"`)
+	resp := newRW()
+	s.ServeHTTP(resp, req)
+	if resp.code != http.StatusBadRequest || !strings.Contains(resp.buf.String(), "multipart") {
+		t.Fatalf("Malformed form should be rejected: %d %s", resp.code, resp.buf.String())
+	}
+}
+
+func TestSeparatedParameter(t *testing.T) {
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/api/v1/items",
+		Consumes: []string{definition.MIMENone},
+		Produces: []string{definition.MIMEJSON},
+		Definitions: []definition.Definition{
+			{
+				Method: definition.List,
+				Function: func(ctx context.Context, ids []int64, sizes []int, names []string) (map[string]interface{}, error) {
+					return map[string]interface{}{"ids": ids, "sizes": sizes, "names": names}, nil
+				},
+				Parameters: []definition.Parameter{
+					definition.QueryParameterFor("ids", "").AsCSV(),
+					definition.QueryParameterFor("sizes", "").AsCSV(),
+					definition.HeaderParameterFor("X-Names", "").AsSeparated(";"),
+				},
+				Results: definition.DataErrorResults(""),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		query string
+		names string
+		code  int
+		body  string
+	}{
+		{"?ids=1,2,3", "", 200, `{"ids":[1,2,3],"names":null,"sizes":null}` + "\n"},
+		{"?ids=1,,%202%20,&ids=3&sizes=,,", "a; b;;c d", 200, `{"ids":[1,2,3],"names":["a","b","c d"],"sizes":null}` + "\n"},
+		{"?sizes=1,x", "", 400, "element 'x' of sizes"},
+		{"?ids=1,9223372036854775808", "", 400, "ids"},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/api/v1/items"+test.query, nil)
+		req.Header.Set("Accept", definition.MIMEJSON)
+		if test.names != "" {
+			req.Header.Set("X-Names", test.names)
+		}
+		resp := newRW()
+		s.ServeHTTP(resp, req)
+		if resp.code != test.code || !strings.Contains(resp.buf.String(), test.body) {
+			t.Fatalf("Response of %q is %d %s, but want %d %s", test.query, resp.code, resp.buf.String(), test.code, test.body)
+		}
+	}
+
+	for _, p := range []definition.Parameter{
+		definition.QueryParameterFor("id", "").AsCSV(),
+		definition.BodyParameterFor("").AsCSV(),
+	} {
+		builder = NewBuilder()
+		builder.SetModifier(service.FirstContextParameter())
+		if err := builder.AddDescriptor(definition.Descriptor{
+			Path:     "/api/v1/items",
+			Consumes: []string{definition.MIMEAll},
+			Produces: []string{definition.MIMEJSON},
+			Definitions: []definition.Definition{
+				{
+					Method: definition.List,
+					Function: func(ctx context.Context, id int) (int, error) {
+						return id, nil
+					},
+					Parameters: []definition.Parameter{p},
+					Results:    definition.DataErrorResults(""),
+				},
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := builder.Build(); err == nil {
+			t.Fatalf("Separator should not be applied to parameter %+v", p)
+		}
+	}
+
+	p := definition.QueryParameterFor("ids", "").AsCSV()
+	p.Converter = definition.ConverterFor(definition.Query, func(ctx context.Context, raw []string) (interface{}, error) {
+		return []int{}, nil
+	})
+	builder = NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/api/v1/items",
+		Consumes: []string{definition.MIMEAll},
+		Produces: []string{definition.MIMEJSON},
+		Definitions: []definition.Definition{
+			{
+				Method: definition.List,
+				Function: func(ctx context.Context, ids []int) ([]int, error) {
+					return ids, nil
+				},
+				Parameters: []definition.Parameter{p},
+				Results:    definition.DataErrorResults(""),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := builder.Build(); err == nil || !strings.Contains(err.Error(), "both a separator and a converter") {
+		t.Fatalf("Parameter with both a separator and a converter should be rejected: %v", err)
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	defer service.RegisterRecoveryHandler(nil)
+	panicOperator := definition.NewOperator("validator", reflect.TypeOf(""), reflect.TypeOf(""), func(ctx context.Context, field string, object interface{}) (interface{}, error) {
+		if object == "operator" {
+			panic("secret in operator")
+		}
+		return object, nil
+	})
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/api/v1/panic",
+		Consumes: []string{definition.MIMEAll},
+		Produces: []string{definition.MIMEJSON},
+		Definitions: []definition.Definition{
+			{
+				Method: definition.Get,
+				Function: func(ctx context.Context, where string) (string, error) {
+					if where == "function" {
+						panic("secret in function")
+					}
+					return where, nil
+				},
+				Parameters: []definition.Parameter{
+					definition.QueryParameterFor("where", "", panicOperator),
+				},
+				Results: definition.DataErrorResults(""),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		handler service.RecoveryHandler
+		where   string
+		code    int
+		body    string
+	}{
+		{nil, "function", http.StatusInternalServerError, "internal server error"},
+		{nil, "operator", http.StatusInternalServerError, "internal server error"},
+		{service.DetailedRecoveryHandler, "operator", http.StatusInternalServerError, "panic: secret in operator"},
+		{func(ctx context.Context, rcv interface{}) error {
+			return errors.ServiceUnavailable.Build("Test:Panic", "try again").Error()
+		}, "function", http.StatusServiceUnavailable, "try again"},
+		{func(ctx context.Context, rcv interface{}) error {
+			panic("panic again")
+		}, "function", http.StatusInternalServerError, "internal server error"},
+		{nil, "nothing", http.StatusOK, "nothing"},
+	}
+	for _, test := range tests {
+		service.RegisterRecoveryHandler(test.handler)
+		req, _ := http.NewRequest("GET", "/api/v1/panic?where="+test.where, nil)
+		req.Header.Set("Accept", definition.MIMEJSON)
+		resp := newRW()
+		s.ServeHTTP(resp, req)
+		if resp.code != test.code || !strings.Contains(resp.buf.String(), test.body) {
+			t.Fatalf("Response of %s is %d %s, but want %d %s", test.where, resp.code, resp.buf.String(), test.code, test.body)
+		}
+		if test.handler == nil && strings.Contains(resp.buf.String(), "secret") {
+			t.Fatalf("Response leaks the panic value: %s", resp.buf.String())
+		}
+	}
+}
+
+func TestContentTypeParameters(t *testing.T) {
+	type echo struct {
+		Name string `json:"name"`
+	}
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	builder.AddFilter(service.ParseRequestForm())
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/api/v1/echo",
+		Consumes: []string{definition.MIMEJSON, definition.MIMEFormData},
+		Produces: []string{definition.MIMEJSON},
+		Definitions: []definition.Definition{
+			{
+				Method: definition.Create,
+				Function: func(ctx context.Context, body *echo) (*echo, error) {
+					return body, nil
+				},
+				Parameters: []definition.Parameter{
+					definition.BodyParameterFor(""),
+				},
+				Results: definition.DataErrorResults(""),
+			},
+			{
+				Method:   definition.Update,
+				Consumes: []string{definition.MIMEFormData},
+				Function: func(ctx context.Context, name string) (string, error) {
+					return name, nil
+				},
+				Parameters: []definition.Parameter{
+					definition.FormParameterFor("name", ""),
+				},
+				Results: definition.DataErrorResults(""),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("POST", "/api/v1/echo", strings.NewReader(`{"name":"nirvana"}`))
+	req.Header.Set("Content-Type", `application/json; charset="utf-8"; foo=bar`)
+	req.Header.Set("Accept", "Application/JSON; charset=utf-8; q=0.8")
+	resp := newRW()
+	s.ServeHTTP(resp, req)
+	if resp.code != http.StatusCreated || resp.buf.String() != `{"name":"nirvana"}`+"\n" {
+		t.Fatalf("Response is not desired: %d %s", resp.code, resp.buf.String())
+	}
+
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+	if err := w.WriteField("name", "nirvana"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	req, _ = http.NewRequest("PUT", "/api/v1/echo", body)
+	req.Header.Set("Content-Type", fmt.Sprintf(`multipart/form-data; charset=utf-8; boundary="%s"`, w.Boundary()))
+	req.Header.Set("Accept", "application/json;charset=utf-8")
+	resp = newRW()
+	s.ServeHTTP(resp, req)
+	if resp.code != http.StatusOK || resp.buf.String() != "nirvana" {
+		t.Fatalf("Response is not desired: %d %s", resp.code, resp.buf.String())
+	}
+}
+
+func TestResponseWrittenByOperator(t *testing.T) {
+	cache := map[string]string{"cached": "from cache"}
+	cacheOperator := definition.NewOperator("validator", reflect.TypeOf(""), reflect.TypeOf(""), func(ctx context.Context, field string, object interface{}) (interface{}, error) {
+		key := object.(string)
+		if key == "lost" {
+			// Forget to write response.
+			return nil, definition.ErrResponseWritten
+		}
+		if value, ok := cache[key]; ok {
+			resp := service.HTTPContextFrom(ctx).ResponseWriter()
+			resp.WriteHeader(http.StatusOK)
+			if _, err := resp.Write([]byte(value)); err != nil {
+				return nil, err
+			}
+			return nil, definition.ErrResponseWritten
+		}
+		return object, nil
+	})
+	calls := 0
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/api/v1/items",
+		Consumes: []string{definition.MIMENone},
+		Produces: []string{definition.MIMEText},
+		Definitions: []definition.Definition{
+			{
+				Method: definition.Get,
+				Function: func(ctx context.Context, key string, other string) (string, error) {
+					calls++
+					return key, nil
+				},
+				Parameters: []definition.Parameter{
+					definition.QueryParameterFor("key", "", cacheOperator),
+					definition.QueryParameterFor("other", "", definition.NewOperator("validator", reflect.TypeOf(""), reflect.TypeOf(""), func(ctx context.Context, field string, object interface{}) (interface{}, error) {
+						t.Fatal("Subsequent parameters should be skipped")
+						return object, nil
+					})),
+				},
+				Results: definition.DataErrorResults(""),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		query string
+		code  int
+		body  string
+		calls int
+	}{
+		{"?key=cached", http.StatusOK, "from cache", 0},
+		{"?key=lost", http.StatusInternalServerError, "no response", 0},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/api/v1/items"+test.query, nil)
+		resp := newRW()
+		s.ServeHTTP(resp, req)
+		if resp.code != test.code || !strings.Contains(resp.buf.String(), test.body) || calls != test.calls {
+			t.Fatalf("Response of %s is %d %s with %d calls, but want %d %s with %d calls",
+				test.query, resp.code, resp.buf.String(), calls, test.code, test.body, test.calls)
+		}
+	}
+
+	// The result of function is cached by response operator.
+	cache = map[string]string{}
+	builder = NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/api/v1/items",
+		Consumes: []string{definition.MIMENone},
+		Produces: []string{definition.MIMEText},
+		Definitions: []definition.Definition{
+			{
+				Method: definition.Get,
+				Function: func(ctx context.Context) (string, error) {
+					calls++
+					return "cached", nil
+				},
+				Results:           definition.DataErrorResults(""),
+				ResponseOperators: []definition.Operator{cacheOperator},
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = builder.Build(); err != nil {
+		t.Fatal(err)
+	}
+	cache["cached"] = "replaced"
+	req, _ := http.NewRequest("GET", "/api/v1/items", nil)
+	resp := newRW()
+	s.ServeHTTP(resp, req)
+	if resp.code != http.StatusOK || resp.buf.String() != "replaced" || calls != 1 {
+		t.Fatalf("Response operator should write the response: %d %s", resp.code, resp.buf.String())
+	}
+}
+
+func TestCookieParameter(t *testing.T) {
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/api/v1/session",
+		Consumes: []string{definition.MIMENone},
+		Produces: []string{definition.MIMEJSON},
+		Definitions: []definition.Definition{
+			{
+				Method: definition.Get,
+				Function: func(ctx context.Context, session string, theme string, tags []string) (map[string]interface{}, error) {
+					return map[string]interface{}{"session": session, "theme": theme, "tags": tags}, nil
+				},
+				Parameters: []definition.Parameter{
+					{Source: definition.Cookie, Name: "session", Required: true},
+					{Source: definition.Cookie, Name: "theme", Default: "light"},
+					definition.CookieParameterFor("tag", ""),
+				},
+				Results: definition.DataErrorResults(""),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		cookie string
+		code   int
+		body   string
+	}{
+		{"session=a%20b", 200, `{"session":"a b","tags":null,"theme":"light"}` + "\n"},
+		{"session=s; tag=x; theme=dark; tag=y", 200, `{"session":"s","tags":["x","y"],"theme":"dark"}` + "\n"},
+		{"theme=dark", 400, "session"},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/api/v1/session", nil)
+		req.Header.Set("Accept", definition.MIMEJSON)
+		req.Header.Set("Cookie", test.cookie)
+		resp := newRW()
+		s.ServeHTTP(resp, req)
+		if resp.code != test.code || !strings.Contains(resp.buf.String(), test.body) {
+			t.Fatalf("Response of %q is %d %s, but want %d %s", test.cookie, resp.code, resp.buf.String(), test.code, test.body)
+		}
+	}
+}
+
+func TestMount(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(r.Method + " " + r.URL.Path))
+		if r.URL.RawPath != "" {
+			_, _ = w.Write([]byte(" " + r.URL.EscapedPath()))
+		}
+	})
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(
+		service.Mount("/legacy", mux, true),
+		service.Mount("/raw", mux, false),
+		definition.Descriptor{
+			Path:     "/legacy/v2",
+			Consumes: []string{definition.MIMENone},
+			Produces: []string{definition.MIMEText},
+			Definitions: []definition.Definition{
+				{
+					Method: definition.Get,
+					Function: func(ctx context.Context) (string, error) {
+						return "definition", nil
+					},
+					Results: definition.DataErrorResults(""),
+				},
+			},
+		},
+	); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{"GET", "/legacy", "GET /"},
+		{"POST", "/legacy/a/b", "POST /a/b"},
+		{"DELETE", "/raw/a", "DELETE /raw/a"},
+		{"GET", "/legacy/v2", "definition"},
+		{"GET", "/legacy/v2/a", "GET /v2/a"},
+		{"GET", "/legacy/a%2Fb%20c/d", "GET /a/b c/d /a%2Fb%20c/d"},
+		{"GET", "/legacy/a%20b", "GET /a b"},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest(test.method, test.path, nil)
+		resp := newRW()
+		s.ServeHTTP(resp, req)
+		if resp.buf.String() != test.body {
+			t.Fatalf("Response of %s %s is %d %s, but want %s", test.method, test.path, resp.code, resp.buf.String(), test.body)
+		}
+	}
+}
+
+func TestStatic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"index.html":         "<html>app</html>",
+		"assets/app.js":      "console.log(1)",
+		"docs/index.html":    "<html>docs</html>",
+		"assets/empty/.keep": "",
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(
+		service.Static("/", http.Dir(dir), service.StaticOptions{Fallback: true, MaxAge: time.Hour}),
+		service.Static("/plain", http.Dir(dir), service.StaticOptions{}),
+		definition.Descriptor{
+			Path:     "/api/ping",
+			Consumes: []string{definition.MIMENone},
+			Produces: []string{definition.MIMEText},
+			Definitions: []definition.Definition{
+				{
+					Method: definition.Get,
+					Function: func(ctx context.Context) (string, error) {
+						return "pong", nil
+					},
+					Results: definition.DataErrorResults(""),
+				},
+			},
+		},
+	); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(method, path string, header map[string]string) *responseWriter {
+		req, _ := http.NewRequest(method, path, nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp := newRW()
+		s.ServeHTTP(resp, req)
+		return resp
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		code   int
+		body   string
+		cache  string
+	}{
+		{"GET", "/assets/app.js", http.StatusOK, "console.log(1)", "public, max-age=3600"},
+		{"GET", "/", http.StatusOK, "<html>app</html>", "no-cache"},
+		{"GET", "/index.html", http.StatusOK, "<html>app</html>", "no-cache"},
+		{"GET", "/docs/", http.StatusOK, "<html>docs</html>", "no-cache"},
+		{"GET", "/users/1/settings", http.StatusOK, "<html>app</html>", "no-cache"},
+		{"GET", "/assets/empty", http.StatusOK, "<html>app</html>", "no-cache"},
+		{"GET", "/assets/missing.js", http.StatusNotFound, "", ""},
+		{"GET", "/assets/../../static.go", http.StatusNotFound, "", ""},
+		{"GET", "/api/ping", http.StatusOK, "pong", ""},
+		{"GET", "/plain/assets/app.js", http.StatusOK, "console.log(1)", "no-cache"},
+		{"GET", "/plain/users/1", http.StatusNotFound, "", ""},
+		{"POST", "/assets/app.js", http.StatusMethodNotAllowed, "", ""},
+	}
+	for _, test := range tests {
+		resp := serve(test.method, test.path, nil)
+		if resp.code != test.code || (test.body != "" && resp.buf.String() != test.body) {
+			t.Fatalf("Response of %s %s is %d %s, but want %d %s", test.method, test.path, resp.code, resp.buf.String(), test.code, test.body)
+		}
+		if cache := resp.header.Get("Cache-Control"); test.cache != "" && cache != test.cache {
+			t.Fatalf("Cache-Control of %s is %q, but want %q", test.path, cache, test.cache)
+		}
+	}
+
+	resp := serve("GET", "/assets/app.js", nil)
+	etag, modified := resp.header.Get("ETag"), resp.header.Get("Last-Modified")
+	if !strings.HasPrefix(etag, `W/"`) || modified == "" {
+		t.Fatalf("Static files should have validators: %v", resp.header)
+	}
+	if resp := serve("GET", "/assets/app.js", map[string]string{"If-None-Match": etag}); resp.code != http.StatusNotModified {
+		t.Fatalf("Matched etag should get 304, but got %d", resp.code)
+	}
+	if resp := serve("GET", "/assets/app.js", map[string]string{"If-Modified-Since": modified}); resp.code != http.StatusNotModified {
+		t.Fatalf("Unmodified file should get 304, but got %d", resp.code)
+	}
+	if resp := serve("HEAD", "/assets/app.js", nil); resp.code != http.StatusOK || resp.buf.Len() != 0 {
+		t.Fatalf("Unexpected response of HEAD: %d %s", resp.code, resp.buf.String())
+	}
+}
+
+func TestMetaPrefabs(t *testing.T) {
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/api/v1/meta",
+		Consumes: []string{definition.MIMENone},
+		Produces: []string{definition.MIMEJSON, definition.MIMEText},
+		Definitions: []definition.Definition{
+			{
+				Method: definition.Get,
+				Function: func(ctx context.Context, addr string, version uint16, accept string) (string, error) {
+					return fmt.Sprintf("%s %d %s", addr, version, accept), nil
+				},
+				Parameters: []definition.Parameter{
+					definition.PrefabParameterFor("remote-addr", ""),
+					definition.PrefabParameterFor("tls-version", ""),
+					definition.PrefabParameterFor("negotiated-accept", ""),
+				},
+				Results: definition.DataErrorResults(""),
+			},
+		},
+	}, definition.Descriptor{
+		Path:     "/api/v1/secure",
+		Consumes: []string{definition.MIMENone},
+		Produces: []string{definition.MIMEText},
+		Definitions: []definition.Definition{
+			{
+				Method: definition.Get,
+				Function: func(ctx context.Context, version uint16) (string, error) {
+					return fmt.Sprint(version), nil
+				},
+				Parameters: []definition.Parameter{
+					{Source: definition.Prefab, Name: "tls-version", Required: true},
+				},
+				Results: definition.DataErrorResults(""),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path   string
+		accept string
+		tls    *tls.ConnectionState
+		code   int
+		body   string
+	}{
+		{"/api/v1/meta", "text/plain", nil, 200, "192.0.2.1 0 text/plain"},
+		{"/api/v1/meta", "application/xml, text/plain;q=0.5", &tls.ConnectionState{Version: tls.VersionTLS12}, 200, "192.0.2.1 771 text/plain"},
+		{"/api/v1/secure", "text/plain", &tls.ConnectionState{Version: tls.VersionTLS13}, 200, "772"},
+		{"/api/v1/secure", "text/plain", nil, 400, "tls-version"},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", test.path, nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		req.Header.Set("Accept", test.accept)
+		req.TLS = test.tls
+		resp := newRW()
+		s.ServeHTTP(resp, req)
+		if resp.code != test.code || !strings.Contains(resp.buf.String(), test.body) {
+			t.Fatalf("Response of %s is %d %s, but want %d %s", test.path, resp.code, resp.buf.String(), test.code, test.body)
+		}
+	}
+}
+
+type pusherRW struct {
+	*responseWriter
+	pushed []string
+}
+
+func (r *pusherRW) Push(target string, opts *http.PushOptions) error {
+	r.pushed = append(r.pushed, target)
+	return nil
+}
+
+func TestPreloadResult(t *testing.T) {
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/index",
+		Consumes: []string{definition.MIMENone},
+		Produces: []string{definition.MIMEText},
+		Definitions: []definition.Definition{
+			{
+				Method: definition.Get,
+				Function: func(ctx context.Context) ([]definition.Preload, string, error) {
+					return []definition.Preload{{URL: "/app.css", As: "style", NoPush: true}}, "index", nil
+				},
+				Results: []definition.Result{
+					definition.PreloadResultFor(definition.Preload{URL: "/app.js", As: "script"}),
+					definition.DataResultFor(""),
+					definition.ErrorResult(),
+				},
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"</app.js>; rel=preload; as=script", "</app.css>; rel=preload; as=style; nopush"}
+	for _, pusher := range []bool{false, true} {
+		req, _ := http.NewRequest("GET", "/index", nil)
+		rw := newRW()
+		var resp http.ResponseWriter = rw
+		p := &pusherRW{responseWriter: rw}
+		if pusher {
+			resp = p
+		}
+		s.ServeHTTP(resp, req)
+		if rw.code != 200 || rw.buf.String() != "index" {
+			t.Fatalf("Response is %d %s", rw.code, rw.buf.String())
+		}
+		if links := rw.header["Link"]; !reflect.DeepEqual(links, want) {
+			t.Fatalf("Link headers are %v, but want %v", links, want)
+		}
+		if pusher && !reflect.DeepEqual(p.pushed, []string{"/app.js"}) {
+			t.Fatalf("Pushed resources are %v, but want [/app.js]", p.pushed)
+		}
+	}
+}
+
+func textDescriptor(path string, body string) definition.Descriptor {
+	return definition.Descriptor{
+		Path:     path,
+		Consumes: []string{definition.MIMENone},
+		Produces: []string{definition.MIMEText},
+		Definitions: []definition.Definition{
+			{
+				Method: definition.Get,
+				Function: func(ctx context.Context) (string, error) {
+					return body, nil
+				},
+				Results: definition.DataErrorResults(""),
+			},
+		},
+	}
+}
+
+func TestDynamicService(t *testing.T) {
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(textDescriptor("/static", "static")); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds, ok := s.(service.DynamicService)
+	if !ok {
+		t.Fatalf("Service should be dynamic: %T", s)
+	}
+	get := func(path string) (int, string) {
+		req, _ := http.NewRequest("GET", path, nil)
+		resp := newRW()
+		s.ServeHTTP(resp, req)
+		return resp.code, resp.buf.String()
+	}
+
+	plugin := textDescriptor("/plugins/a", "a")
+	plugin.Children = []definition.Descriptor{textDescriptor("/b", "b")}
+	if err := ds.Register(plugin); err != nil {
+		t.Fatal(err)
+	}
+	for path, body := range map[string]string{"/static": "static", "/plugins/a": "a", "/plugins/a/b": "b"} {
+		if code, text := get(path); code != 200 || text != body {
+			t.Fatalf("Response of %s is %d %s, but want 200 %s", path, code, text, body)
+		}
+	}
+	if err := ds.Register(textDescriptor("/plugins/a", "conflict")); err == nil {
+		t.Fatalf("Register should fail on conflicting routes")
+	}
+	if code, text := get("/plugins/a"); code != 200 || text != "a" {
+		t.Fatalf("Routes should be unchanged after a failed registration: %d %s", code, text)
+	}
+	if err := ds.Deregister("/plugins/a"); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/plugins/a", "/plugins/a/b"} {
+		if code, _ := get(path); code != 404 {
+			t.Fatalf("Response of removed %s is %d, but want 404", path, code)
+		}
+	}
+	if err := ds.Deregister("/plugins/a"); !noRouteForPath.Derived(err) {
+		t.Fatalf("Deregister should fail on unknown paths: %v", err)
+	}
+	if err := ds.Deregister("/static"); !noRouter.Derived(err) {
+		t.Fatalf("Deregister should not remove all routes: %v", err)
+	}
+
+	// Change routes while serving requests.
+	registrations := sync.WaitGroup{}
+	requests := sync.WaitGroup{}
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		path := fmt.Sprintf("/dynamic/%d", i)
+		registrations.Add(1)
+		go func() {
+			defer registrations.Done()
+			for j := 0; j < 50; j++ {
+				if err := ds.Register(textDescriptor(path, path)); err != nil {
+					t.Error(err)
+					return
+				}
+				if err := ds.Deregister(path); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+		requests.Add(1)
+		go func() {
+			defer requests.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if code, text := get("/static"); code != 200 || text != "static" {
+					t.Errorf("Response of /static is %d %s", code, text)
+					return
+				}
+				if code, text := get(path); code != 404 && (code != 200 || text != path) {
+					t.Errorf("Response of %s is %d %s", path, code, text)
+					return
+				}
+			}
+		}()
+	}
+	registrations.Wait()
+	close(done)
+	requests.Wait()
+}
+
+func TestPartialResponse(t *testing.T) {
+	type address struct {
+		City   string `json:"city"`
+		Street string `json:"street"`
+	}
+	type user struct {
+		ID      int      `json:"id"`
+		Name    string   `json:"name"`
+		Address *address `json:"address"`
+	}
+	users := []user{
+		{1, "alice", &address{"Paris", "Rue"}},
+		{2, "bob", nil},
+	}
+	def := func(mode definition.PartialResponseMode) definition.Definition {
+		return definition.Definition{
+			Method: definition.Get,
+			Function: func(ctx context.Context) ([]user, error) {
+				return users, nil
+			},
+			Results:         definition.DataErrorResults(""),
+			PartialResponse: mode,
+		}
+	}
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/users",
+		Consumes: []string{definition.MIMENone},
+		Produces: []string{definition.MIMEJSON},
+		Children: []definition.Descriptor{
+			{Path: "/lenient", Definitions: []definition.Definition{def(definition.LenientPartialResponse)}},
+			{Path: "/strict", Definitions: []definition.Definition{def(definition.StrictPartialResponse)}},
+			{Path: "/all", Definitions: []definition.Definition{def(definition.NoPartialResponse)}},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := `[{"id":1,"name":"alice","address":{"city":"Paris","street":"Rue"}},{"id":2,"name":"bob","address":null}]` + "\n"
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/users/lenient?fields=id,address.city", 200, `[{"address":{"city":"Paris"},"id":1},{"address":null,"id":2}]` + "\n"},
+		{"/users/lenient?fields=name&fields=address,address.city", 200, `[{"address":{"city":"Paris","street":"Rue"},"name":"alice"},{"address":null,"name":"bob"}]` + "\n"},
+		{"/users/lenient?fields=id,unknown", 200, `[{"id":1},{"id":2}]` + "\n"},
+		{"/users/lenient", 200, all},
+		{"/users/strict?fields=id,address.city", 200, `[{"address":{"city":"Paris"},"id":1},{"address":null,"id":2}]` + "\n"},
+		{"/users/strict?fields=id,address.zip", 400, "address.zip"},
+		{"/users/strict?fields=id.value", 400, "id.value"},
+		{"/users/all?fields=id", 200, all},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", test.path, nil)
+		resp := newRW()
+		s.ServeHTTP(resp, req)
+		if resp.code != test.code || !strings.Contains(resp.buf.String(), test.body) {
+			t.Fatalf("Response of %s is %d %s, but want %d %s", test.path, resp.code, resp.buf.String(), test.code, test.body)
+		}
+	}
+}
+
+func TestMsgpackNegotiation(t *testing.T) {
+	type item struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/items",
+		Consumes: []string{definition.MIMEJSON, definition.MIMEMsgpack},
+		Produces: []string{definition.MIMEJSON, definition.MIMEMsgpack},
+		Definitions: []definition.Definition{
+			{
+				Method: definition.Update,
+				Function: func(ctx context.Context, i *item) (*item, error) {
+					i.ID++
+					return i, nil
+				},
+				Parameters: []definition.Parameter{definition.BodyParameterFor("")},
+				Results:    definition.DataErrorResults(""),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// {"id": 1, "name": "a"}
+	body := []byte{0x82, 0xa2, 'i', 'd', 0x01, 0xa4, 'n', 'a', 'm', 'e', 0xa1, 'a'}
+	req, _ := http.NewRequest("PUT", "/items", bytes.NewReader(body))
+	req.Header.Set("Content-Type", definition.MIMEMsgpack)
+	req.Header.Set("Accept", "application/msgpack, application/json;q=0.9")
+	resp := newRW()
+	s.ServeHTTP(resp, req)
+	if resp.code != 200 || resp.header.Get("Content-Type") != definition.MIMEMsgpack {
+		t.Fatalf("Response is %d %s %x", resp.code, resp.header.Get("Content-Type"), resp.buf.Bytes())
+	}
+	var got item
+	if err := service.ConsumerFor(definition.MIMEMsgpack).Consume(resp.buf, &got); err != nil || got != (item{2, "a"}) {
+		t.Fatalf("Response data is %+v: %v", got, err)
+	}
+}
+
+func TestContentNegotiation(t *testing.T) {
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/negotiation",
+		Consumes: []string{definition.MIMENone},
+		Produces: []string{definition.MIMEJSON, definition.MIMEXML, definition.MIMEText},
+		Definitions: []definition.Definition{
+			{
+				Method: definition.Get,
+				Function: func(ctx context.Context) (string, error) {
+					return "data", nil
+				},
+				Results: definition.DataErrorResults(""),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		accept string
+		code   int
+		ct     string
+	}{
+		{"application/json, application/xml;q=0.9", 200, definition.MIMEJSON},
+		{"application/json;q=0.8, application/xml", 200, definition.MIMEXML},
+		{"text/*, application/json;q=0.5", 200, definition.MIMEText},
+		{"application/*;q=0.5, application/xml;q=0", 200, definition.MIMEJSON},
+		{"image/*", http.StatusNotAcceptable, ""},
+		{"application/json;q=0, application/xml;q=0, text/plain;q=0", http.StatusNotAcceptable, ""},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/negotiation", nil)
+		req.Header.Set("Accept", test.accept)
+		resp := newRW()
+		s.ServeHTTP(resp, req)
+		if resp.code != test.code {
+			t.Fatalf("Response code for %q is %d, but want %d: %s", test.accept, resp.code, test.code, resp.buf.String())
+		}
+		if test.ct != "" && resp.header.Get("Content-Type") != test.ct {
+			t.Fatalf("Content type for %q is %s, but want %s", test.accept, resp.header.Get("Content-Type"), test.ct)
+		}
+	}
+}
+
+func TestUnsupportedContentTypes(t *testing.T) {
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/items",
+		Consumes: []string{definition.MIMEJSON, definition.MIMEYAML},
+		Produces: []string{definition.MIMEJSON, definition.MIMEXML},
+		Definitions: []definition.Definition{
+			{
+				Method: definition.Create,
+				Function: func(ctx context.Context, body *struct{ A string }) (string, error) {
+					return body.A, nil
+				},
+				Parameters: []definition.Parameter{definition.BodyParameterFor("")},
+				Results:    definition.DataErrorResults(""),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		contentType string
+		accept      string
+		code        int
+		message     string
+	}{
+		{definition.MIMEJSON, definition.MIMEJSON, http.StatusCreated, ""},
+		{definition.MIMEXML, definition.MIMEJSON, http.StatusUnsupportedMediaType,
+			"content type 'application/xml' is not supported, supported types: application/json, application/yaml"},
+		{definition.MIMEJSON, "text/html, image/*", http.StatusNotAcceptable,
+			"no acceptable type for 'text/html, image/*', available types: application/json, application/xml"},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("POST", "/items", strings.NewReader(`{"a":"b"}`))
+		req.Header.Set("Content-Type", test.contentType)
+		req.Header.Set("Accept", test.accept)
+		resp := newRW()
+		s.ServeHTTP(resp, req)
+		if resp.code != test.code {
+			t.Fatalf("Response code is %d, but want %d: %s", resp.code, test.code, resp.buf.String())
+		}
+		if test.message == "" {
+			continue
+		}
+		var body struct {
+			Reason  string            `json:"reason"`
+			Message string            `json:"message"`
+			Data    map[string]string `json:"data"`
+		}
+		if err := json.Unmarshal(resp.buf.Bytes(), &body); err != nil {
+			t.Fatalf("Error body should be json: %s", resp.buf.String())
+		}
+		if body.Message != test.message || body.Reason == "" || body.Data["types"] == "" {
+			t.Fatalf("Error body is %+v, but want message %q", body, test.message)
+		}
+	}
+}
+
+// webSocketDial sends a websocket handshake and returns the connection, the
+// reader of connection and the response of handshake.
+func webSocketDial(t *testing.T, addr, target string, header http.Header) (net.Conn, *bufio.Reader, *http.Response) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://"+addr+target, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	if err := req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, reader, resp
+}
+
+// webSocketWrite writes a masked frame.
+func webSocketWrite(t *testing.T, conn net.Conn, op byte, payload []byte) {
+	mask := []byte{1, 2, 3, 4}
+	frame := []byte{0x80 | op, 0x80 | byte(len(payload))}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// webSocketRead reads a short unmasked frame.
+func webSocketRead(t *testing.T, reader *bufio.Reader) (byte, []byte) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		t.Fatal(err)
+	}
+	payload := make([]byte, header[1]&0x7F)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		t.Fatal(err)
+	}
+	return header[0] & 0x0F, payload
+}
+
+func TestWebSocket(t *testing.T) {
+	cancelled := make(chan struct{})
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/rooms/{room}",
+		Consumes: []string{definition.MIMENone},
+		Produces: []string{definition.MIMEJSON},
+		Middlewares: []definition.Middleware{
+			func(ctx context.Context, chain definition.Chain) error {
+				if service.HTTPContextFrom(ctx).Request().Header.Get("Authorization") != "token" {
+					return errors.Unauthorized.Build("Nirvana:Test:Unauthorized", "unauthorized").Error()
+				}
+				return chain.Continue(ctx)
+			},
+		},
+		Definitions: []definition.Definition{
+			{
+				Method: definition.WebSocket,
+				Function: func(ctx context.Context, room string, name string, conn *service.WebSocketConn) error {
+					if room == "closed" {
+						return errors.NotFound.Build("Nirvana:Test:NoRoom", "no room ${room}").Error(room)
+					}
+					if err := conn.WriteMessage(service.WebSocketTextMessage, []byte("hello "+name+" in "+room)); err != nil {
+						return err
+					}
+					for {
+						typ, msg, err := conn.ReadMessage()
+						if err == io.EOF {
+							<-ctx.Done()
+							close(cancelled)
+							return nil
+						}
+						if err != nil {
+							return err
+						}
+						if string(msg) == "fail" {
+							return fmt.Errorf("failed")
+						}
+						if err := conn.WriteMessage(typ, msg); err != nil {
+							return err
+						}
+					}
+				},
+				Parameters: []definition.Parameter{
+					definition.PathParameterFor("room", ""),
+					definition.QueryParameterFor("name", ""),
+					definition.WebSocketParameterFor(""),
+				},
+				Results: []definition.Result{definition.ErrorResult()},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(s)
+	defer server.Close()
+	addr := server.Listener.Addr().String()
+	auth := http.Header{"Authorization": []string{"token"}}
+
+	conn, reader, resp := webSocketDial(t, addr, "/rooms/go?name=alice", auth)
+	defer conn.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Handshake response is %d %v", resp.StatusCode, resp.Header)
+	}
+	if op, msg := webSocketRead(t, reader); op != 1 || string(msg) != "hello alice in go" {
+		t.Fatalf("Greeting is %d %s", op, msg)
+	}
+	webSocketWrite(t, conn, 0x9, []byte("ping"))
+	if op, msg := webSocketRead(t, reader); op != 0xA || string(msg) != "ping" {
+		t.Fatalf("Pong is %d %s", op, msg)
+	}
+	webSocketWrite(t, conn, 0x2, []byte("echo"))
+	if op, msg := webSocketRead(t, reader); op != 2 || string(msg) != "echo" {
+		t.Fatalf("Echo is %d %s", op, msg)
+	}
+	webSocketWrite(t, conn, 0x8, []byte{0x03, 0xE8})
+	if op, msg := webSocketRead(t, reader); op != 0x8 || !bytes.Equal(msg, []byte{0x03, 0xE8}) {
+		t.Fatalf("Close reply is %d %v", op, msg)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Context should be cancelled after the connection is closed")
+	}
+
+	// Errors after upgrade close the connection with 1011.
+	conn, reader, _ = webSocketDial(t, addr, "/rooms/go?name=bob", auth)
+	defer conn.Close()
+	webSocketRead(t, reader)
+	webSocketWrite(t, conn, 0x1, []byte("fail"))
+	if op, msg := webSocketRead(t, reader); op != 0x8 || !bytes.Equal(msg, []byte("\x03\xf3failed")) {
+		t.Fatalf("Close frame is %d %q", op, msg)
+	}
+
+	// Errors before upgrade are normal responses.
+	for _, c := range []struct {
+		target string
+		header http.Header
+		code   int
+	}{
+		{"/rooms/go", nil, http.StatusUnauthorized},
+		{"/rooms/closed", auth, http.StatusNotFound},
+		{"/rooms/go", http.Header{"Authorization": []string{"token"}, "Origin": []string{"http://evil.com"}}, http.StatusForbidden},
+		{"/rooms/go", http.Header{"Authorization": []string{"token"}, "Sec-Websocket-Version": []string{"8"}}, http.StatusBadRequest},
+	} {
+		conn, _, resp := webSocketDial(t, addr, c.target, c.header)
+		conn.Close()
+		if resp.StatusCode != c.code {
+			t.Fatalf("Response code of %s %v is %d, but want %d", c.target, c.header, resp.StatusCode, c.code)
+		}
+	}
+}
+
+func TestDescriptorHeaders(t *testing.T) {
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/api",
+		Consumes: []string{definition.MIMENone},
+		Produces: []string{definition.MIMEJSON},
+		Headers: map[string]string{
+			"X-Content-Type-Options": "nosniff",
+			"X-Frame-Options":        "DENY",
+		},
+		Children: []definition.Descriptor{
+			{
+				Path:    "/pages",
+				Headers: map[string]string{"X-Frame-Options": "SAMEORIGIN"},
+				Definitions: []definition.Definition{
+					{
+						Method: definition.Get,
+						Function: func(ctx context.Context, fail bool) (map[string]string, string, error) {
+							if fail {
+								return nil, "", errors.NotFound.Build("Nirvana:Test:NotFound", "not found").Error()
+							}
+							return map[string]string{"X-Content-Type-Options": "custom"}, "page", nil
+						},
+						Parameters: []definition.Parameter{definition.QueryParameterFor("fail", "")},
+						Results: []definition.Result{
+							definition.MetaResultFor(""),
+							definition.DataResultFor(""),
+							definition.ErrorResult(),
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := url.Parse("http://localhost/api/pages")
+	resp := newRW()
+	s.ServeHTTP(resp, &http.Request{Method: "GET", URL: u, Header: http.Header{}})
+	if resp.code != http.StatusOK {
+		t.Fatalf("Response code is %d: %s", resp.code, resp.buf.String())
+	}
+	// Meta results override descriptor headers, and child headers override parent headers.
+	if v := resp.header.Get("X-Content-Type-Options"); v != "custom" {
+		t.Fatalf("X-Content-Type-Options is %s", v)
+	}
+	if v := resp.header.Get("X-Frame-Options"); v != "SAMEORIGIN" {
+		t.Fatalf("X-Frame-Options is %s", v)
+	}
+
+	u, _ = url.Parse("http://localhost/api/pages?fail=true")
+	resp = newRW()
+	s.ServeHTTP(resp, &http.Request{Method: "GET", URL: u, Header: http.Header{}})
+	if resp.code != http.StatusNotFound {
+		t.Fatalf("Response code is %d: %s", resp.code, resp.buf.String())
+	}
+	if resp.header.Get("X-Content-Type-Options") != "nosniff" || resp.header.Get("X-Frame-Options") != "SAMEORIGIN" {
+		t.Fatalf("Error response should have descriptor headers: %v", resp.header)
+	}
+}
+
+type bindToFilter struct {
+	Limit int `json:"limit" validate:"max=10"`
+}
+
+type bindToRequest struct {
+	Namespace string        `source:"Path,namespace"`
+	Page      int           `source:"query" name:"page" validate:"min=1"`
+	Token     string        `source:"Header,X-Token"`
+	Filter    *bindToFilter `source:"Body"`
+}
+
+func TestBindTo(t *testing.T) {
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/namespaces/{namespace}/items",
+		Consumes: []string{definition.MIMEJSON},
+		Produces: []string{definition.MIMEJSON},
+		Definitions: []definition.Definition{
+			{
+				Method: definition.Create,
+				Function: func(ctx context.Context, r *bindToRequest) (string, error) {
+					return fmt.Sprintf("%s %d %s %d", r.Namespace, r.Page, r.Token, r.Filter.Limit), nil
+				},
+				Parameters: definition.BindTo(&bindToRequest{}),
+				Results:    definition.DataErrorResults(""),
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		query string
+		body  string
+		code  int
+		want  string
+	}{
+		{"?page=2", `{"limit":5}`, http.StatusCreated, "default 2 secret 5"},
+		{"?page=0", `{"limit":5}`, http.StatusBadRequest, "'page'"},
+		{"?page=2", `{"limit":11}`, http.StatusBadRequest, "'body.limit'"},
+	} {
+		u, _ := url.Parse("http://localhost/namespaces/default/items" + c.query)
+		resp := newRW()
+		s.ServeHTTP(resp, &http.Request{
+			Method: "POST",
+			URL:    u,
+			Header: http.Header{"Content-Type": []string{definition.MIMEJSON}, "X-Token": []string{"secret"}},
+			Body:   ioutil.NopCloser(strings.NewReader(c.body)),
+		})
+		if resp.code != c.code || !strings.Contains(resp.buf.String(), c.want) {
+			t.Fatalf("Response of %s %s is %d %s, but want %d %s", c.query, c.body, resp.code, resp.buf.String(), c.code, c.want)
+		}
+	}
+}
+
+func TestAutoHead(t *testing.T) {
+	calls := 0
+	get := definition.Definition{
+		Method: definition.Get,
+		Function: func(ctx context.Context) (map[string]string, map[string]string, error) {
+			calls++
+			return map[string]string{"X-Version": "3"}, map[string]string{"name": strings.Repeat("a", 8192)}, nil
+		},
+		Results: []definition.Result{
+			definition.MetaResultFor(""),
+			definition.DataResultFor(""),
+			definition.ErrorResult(),
+		},
+	}
+	newServer := func(global bool) service.Service {
+		builder := NewBuilder()
+		builder.SetModifier(service.FirstContextParameter())
+		builder.(AutoHeadBuilder).SetAutoHead(global)
+		err := builder.AddDescriptor(definition.Descriptor{
+			Path:     "/api",
+			Consumes: []string{definition.MIMENone},
+			Produces: []string{definition.MIMEJSON},
+			Children: []definition.Descriptor{
+				{
+					Path:     "/derived",
+					AutoHead: true,
+					Children: []definition.Descriptor{
+						{Path: "/child", Definitions: []definition.Definition{get}},
+					},
+				},
+				{Path: "/plain", Definitions: []definition.Definition{get}},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := builder.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	do := func(s service.Service, method, path string) *responseWriter {
+		u, _ := url.Parse("http://localhost" + path)
+		resp := newRW()
+		s.ServeHTTP(resp, &http.Request{Method: method, URL: u, Header: http.Header{}})
+		return resp
+	}
+
+	s := newServer(false)
+	getResp := do(s, http.MethodGet, "/api/derived/child")
+	headResp := do(s, http.MethodHead, "/api/derived/child")
+	if calls != 2 {
+		t.Fatalf("Function should be called by GET and HEAD: %d calls", calls)
+	}
+	if headResp.code != getResp.code || headResp.buf.Len() != 0 {
+		t.Fatalf("HEAD response is %d with %d bytes", headResp.code, headResp.buf.Len())
+	}
+	if v := headResp.header.Get("Content-Length"); v != strconv.Itoa(getResp.buf.Len()) {
+		t.Fatalf("Content-Length of HEAD is %s, but GET has %d bytes", v, getResp.buf.Len())
+	}
+	headResp.header.Del("Content-Length")
+	if !reflect.DeepEqual(headResp.header, getResp.header) {
+		t.Fatalf("Headers of HEAD %v are different from GET %v", headResp.header, getResp.header)
+	}
+	if resp := do(s, http.MethodHead, "/api/plain"); resp.code != http.StatusMethodNotAllowed {
+		t.Fatalf("HEAD without AutoHead should not be allowed: %d", resp.code)
+	}
+	if resp := do(newServer(true), http.MethodHead, "/api/plain"); resp.code != http.StatusOK || resp.buf.Len() != 0 {
+		t.Fatalf("HEAD with global AutoHead is %d with %d bytes", resp.code, resp.buf.Len())
+	}
+}
+
+func TestAutoOptions(t *testing.T) {
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	builder.(service.CORSBuilder).SetCORS(&service.CORSOptions{
+		AllowedOrigins: []string{"https://app.example.com"},
+		AllowedMethods: []string{http.MethodGet, http.MethodPost},
+	})
+	handler := func(ctx context.Context) error { return nil }
+	err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/apps",
+		Consumes: []string{definition.MIMENone},
+		Produces: []string{definition.MIMEJSON},
+		Definitions: []definition.Definition{
+			{Method: definition.List, Function: handler, Results: []definition.Result{definition.ErrorResult()}},
+			{Method: definition.Create, Function: handler, Results: []definition.Result{definition.ErrorResult()}},
+			{Method: definition.Delete, Function: handler, Results: []definition.Result{definition.ErrorResult()}},
+		},
+		Children: []definition.Descriptor{
+			{
+				Path:        "/{app}",
+				Definitions: []definition.Definition{{Method: definition.Get, Function: handler, Results: []definition.Result{definition.ErrorResult()}}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, allow := range map[string]string{
+		"/apps":     "DELETE, GET, OPTIONS, POST",
+		"/apps/app": "GET, OPTIONS",
+	} {
+		req, _ := http.NewRequest(http.MethodOptions, path, nil)
+		resp := newRW()
+		s.ServeHTTP(resp, req)
+		if resp.code != http.StatusNoContent || resp.header.Get("Allow") != allow {
+			t.Fatalf("OPTIONS %s gets %d with Allow %q, but want %q", path, resp.code, resp.header.Get("Allow"), allow)
+		}
+	}
+	req, _ := http.NewRequest(http.MethodOptions, "/unknown", nil)
+	resp := newRW()
+	s.ServeHTTP(resp, req)
+	if resp.code != http.StatusNotFound {
+		t.Fatalf("OPTIONS of unknown path gets %d", resp.code)
+	}
+
+	// Preflight requests are handled by CORS.
+	req, _ = http.NewRequest(http.MethodOptions, "/apps", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	resp = newRW()
+	s.ServeHTTP(resp, req)
+	if resp.header.Get("Access-Control-Allow-Methods") == "" || resp.header.Get("Allow") != "" {
+		t.Fatalf("Preflight should be handled by CORS: %d %v", resp.code, resp.header)
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	handler := func(ctx context.Context) error { return nil }
+	err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/apps/{app}",
+		Consumes: []string{definition.MIMENone},
+		Produces: []string{definition.MIMEJSON},
+		Definitions: []definition.Definition{
+			{Method: definition.Get, Function: handler, Results: []definition.Result{definition.ErrorResult()}},
+			{Method: definition.Update, Function: handler, Results: []definition.Result{definition.ErrorResult()}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodDelete, "/apps/app", nil)
+	resp := newRW()
+	s.ServeHTTP(resp, req)
+	if resp.code != http.StatusMethodNotAllowed || resp.header.Get("Allow") != "GET, OPTIONS, PUT" {
+		t.Fatalf("DELETE gets %d with Allow %q", resp.code, resp.header.Get("Allow"))
+	}
+	if ct := resp.header.Get("Content-Type"); !strings.HasPrefix(ct, definition.MIMEJSON) ||
+		!strings.Contains(resp.buf.String(), "method DELETE is not allowed, allowed methods: GET, OPTIONS, PUT") {
+		t.Fatalf("Error is written as %s: %s", ct, resp.buf.String())
+	}
+	req, _ = http.NewRequest(http.MethodDelete, "/unknown", nil)
+	resp = newRW()
+	s.ServeHTTP(resp, req)
+	if resp.code != http.StatusNotFound || resp.header.Get("Allow") != "" {
+		t.Fatalf("Unknown path gets %d with Allow %q", resp.code, resp.header.Get("Allow"))
+	}
+}
+
+type trackedReader struct {
+	io.Reader
+	closed chan struct{}
+	count  int32
+}
+
+func newTrackedReader(r io.Reader) *trackedReader {
+	return &trackedReader{Reader: r, closed: make(chan struct{})}
+}
+
+func (r *trackedReader) Close() error {
+	if atomic.AddInt32(&r.count, 1) == 1 {
+		close(r.closed)
+	}
+	return nil
+}
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return copy(p, "partial"), fmt.Errorf("upstream is broken")
+}
+
+func TestReadCloserData(t *testing.T) {
+	var reader *trackedReader
+	newReader := func(r io.Reader) io.ReadCloser {
+		reader = newTrackedReader(r)
+		return reader
+	}
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/api/v1/blobs/{name}",
+		Consumes: []string{definition.MIMENone},
+		Produces: []string{definition.MIMEOctetStream},
+		Definitions: []definition.Definition{
+			{
+				Method: definition.Get,
+				Function: func(ctx context.Context, name string) (io.ReadCloser, map[string]string, error) {
+					switch name {
+					case "image":
+						return newReader(strings.NewReader("png")), map[string]string{"Content-Type": "image/png"}, nil
+					case "broken":
+						return newReader(failingReader{}), nil, nil
+					case "missing":
+						// The reader is closed even if it's not written.
+						return newReader(strings.NewReader("unused")), nil, errors.NotFound.Error("blob not found")
+					}
+					return newReader(strings.NewReader("data of " + name)), nil, nil
+				},
+				Parameters: []definition.Parameter{definition.PathParameterFor("name", "")},
+				Results: []definition.Result{
+					definition.DataResultFor(""),
+					definition.MetaResultFor(""),
+					definition.ErrorResult(),
+				},
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		code        int
+		contentType string
+		body        string
+	}{
+		{"blob", http.StatusOK, definition.MIMEOctetStream, "data of blob"},
+		{"image", http.StatusOK, "image/png", "png"},
+		{"missing", http.StatusNotFound, "", ""},
+		// The error of copy is logged after the header is written.
+		{"broken", http.StatusOK, definition.MIMEOctetStream, "partial"},
+	}
+	for _, test := range tests {
+		reader = nil
+		req, _ := http.NewRequest("GET", "/api/v1/blobs/"+test.name, nil)
+		resp := newRW()
+		s.ServeHTTP(resp, req)
+		if resp.code != test.code {
+			t.Fatalf("Blob %s: response code should be %d, but got: %d %s", test.name, test.code, resp.code, resp.buf.String())
+		}
+		if test.contentType != "" && resp.header.Get("Content-Type") != test.contentType {
+			t.Fatalf("Blob %s: Content-Type should be %s, but got: %s", test.name, test.contentType, resp.header.Get("Content-Type"))
+		}
+		if test.body != "" && resp.buf.String() != test.body {
+			t.Fatalf("Blob %s: body should be %q, but got: %q", test.name, test.body, resp.buf.String())
+		}
+		if reader == nil || atomic.LoadInt32(&reader.count) != 1 {
+			t.Fatalf("Blob %s: reader should be closed once", test.name)
+		}
+	}
+}
+
+func TestReadCloserDataAfterTimeout(t *testing.T) {
+	reader := newTrackedReader(strings.NewReader("late"))
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/api/v1/slow",
+		Consumes: []string{definition.MIMENone},
+		Produces: []string{definition.MIMEOctetStream},
+		Definitions: []definition.Definition{
+			{
+				Method:  definition.Get,
+				Timeout: 10 * time.Millisecond,
+				Function: func(ctx context.Context) (io.ReadCloser, error) {
+					<-ctx.Done()
+					return reader, nil
+				},
+				Results: definition.DataErrorResults(""),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "/api/v1/slow", nil)
+	resp := newRW()
+	s.ServeHTTP(resp, req)
+	if resp.code != http.StatusGatewayTimeout {
+		t.Fatalf("Response code should be 504, but got: %d", resp.code)
+	}
+	select {
+	case <-reader.closed:
+	case <-time.After(time.Second):
+		t.Fatal("Reader returned after timeout should be closed")
+	}
+}
+
+func TestAccumulateBodyFieldErrors(t *testing.T) {
+	type address struct {
+		City string `json:"city" validate:"required"`
+	}
+	type user struct {
+		Email   string    `json:"email" validate:"required,email"`
+		Address address   `json:"address"`
+		Tags    []address `json:"tags" validate:"dive"`
+	}
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/api/v1/users",
+		Consumes: []string{definition.MIMEJSON},
+		Produces: []string{definition.MIMEJSON},
+		Definitions: []definition.Definition{
+			{
+				Method:           definition.Create,
+				AccumulateErrors: true,
+				Function: func(ctx context.Context, dryRun bool, u *user) (*user, error) {
+					return u, nil
+				},
+				Parameters: []definition.Parameter{
+					definition.QueryParameterFor("dryRun", ""),
+					definition.BodyParameterFor("", validator.Body(&user{})),
+				},
+				Results: definition.DataErrorResults(""),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("POST", "/api/v1/users?dryRun=maybe", strings.NewReader(`{"email":"x","tags":[{"city":"a"},{}]}`))
+	req.Header.Set("Content-Type", definition.MIMEJSON)
+	resp := newRW()
+	s.ServeHTTP(resp, req)
+	if resp.code != http.StatusBadRequest {
+		t.Fatalf("Response code should be 400, but got: %d %s", resp.code, resp.buf.String())
+	}
+	msg := struct {
+		Errors []service.FieldError `json:"errors"`
+	}{}
+	if err := json.Unmarshal(resp.buf.Bytes(), &msg); err != nil {
+		t.Fatal(err)
+	}
+	fields := []string{}
+	for _, e := range msg.Errors {
+		fields = append(fields, string(e.Source)+":"+e.Field)
+	}
+	if !reflect.DeepEqual(fields, []string{"Query:dryRun", "Body:email", "Body:address.city", "Body:tags[1].city"}) {
+		t.Fatalf("Field errors of body are not flattened: %s", resp.buf.String())
+	}
+
+	req, _ = http.NewRequest("POST", "/api/v1/users", strings.NewReader(`{"email":"a@b.io","address":{"city":"c"}}`))
+	req.Header.Set("Content-Type", definition.MIMEJSON)
+	resp = newRW()
+	s.ServeHTTP(resp, req)
+	if resp.code != http.StatusCreated {
+		t.Fatalf("Valid request should succeed: %d %s", resp.code, resp.buf.String())
+	}
+}
+
+func TestVendorVersions(t *testing.T) {
+	const v1, v2 = "application/vnd.myapp.v1+json", "application/vnd.myapp.v2+json"
+	type user struct {
+		Name string `json:"name" xml:"name"`
+	}
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/api/users/{name}",
+		Consumes: []string{definition.MIMENone},
+		Definitions: []definition.Definition{
+			{
+				Method:   definition.Get,
+				Produces: []string{v1, "application/vnd.myapp.v1+xml"},
+				Function: func(ctx context.Context, name string) (*user, error) {
+					return &user{Name: "v1:" + name}, nil
+				},
+				Parameters: []definition.Parameter{definition.PathParameterFor("name", "")},
+				Results:    definition.DataErrorResults(""),
+			},
+			{
+				Method:   definition.Get,
+				Produces: []string{v2},
+				Function: func(ctx context.Context, name string) (*user, error) {
+					return &user{Name: "v2:" + name}, nil
+				},
+				Parameters: []definition.Parameter{definition.PathParameterFor("name", "")},
+				Results:    definition.DataErrorResults(""),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		accept         string
+		defaultVersion string
+		code           int
+		contentType    string
+		body           string
+	}{
+		{v2, "", http.StatusOK, v2, `{"name":"v2:a"}`},
+		{v1, "", http.StatusOK, v1, `{"name":"v1:a"}`},
+		{"application/vnd.myapp.v1+xml", "", http.StatusOK, "application/vnd.myapp.v1+xml", "<user><name>v1:a</name></user>"},
+		{"application/vnd.myapp.v3+json", "", http.StatusNotAcceptable, "", ""},
+		// Requested versions win over the default version.
+		{v2, "v1", http.StatusOK, v2, `{"name":"v2:a"}`},
+		{"application/vnd.myapp.v3+json", "v2", http.StatusOK, v2, `{"name":"v2:a"}`},
+		{"application/json", "v2", http.StatusOK, v2, `{"name":"v2:a"}`},
+		{"*/*", "v2", http.StatusOK, v2, `{"name":"v2:a"}`},
+		{"", "v2", http.StatusOK, v2, `{"name":"v2:a"}`},
+		{"application/vnd.myapp.v3+xml", "v1", http.StatusOK, "application/vnd.myapp.v1+xml", "<user><name>v1:a</name></user>"},
+	}
+	defer service.SetDefaultVersion("myapp", "")
+	for _, test := range tests {
+		service.SetDefaultVersion("myapp", test.defaultVersion)
+		req, _ := http.NewRequest("GET", "/api/users/a", nil)
+		if test.accept != "" {
+			req.Header.Set("Accept", test.accept)
+		}
+		resp := newRW()
+		s.ServeHTTP(resp, req)
+		if resp.code != test.code {
+			t.Errorf("Accept %q with default version %q: code should be %d, but got: %d %s",
+				test.accept, test.defaultVersion, test.code, resp.code, resp.buf.String())
+			continue
+		}
+		if test.code != http.StatusOK {
+			continue
+		}
+		if ct := resp.header.Get("Content-Type"); ct != test.contentType || strings.TrimSpace(resp.buf.String()) != test.body {
+			t.Errorf("Accept %q with default version %q: got %s %s, want %s %s",
+				test.accept, test.defaultVersion, ct, resp.buf.String(), test.contentType, test.body)
+		}
+	}
+}
+
+func TestMultipartMixedResponse(t *testing.T) {
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/api/v1/batch",
+		Consumes: []string{definition.MIMENone},
+		Produces: []string{definition.MIMEMultipartMixed},
+		Definitions: []definition.Definition{
+			{
+				Method: definition.Get,
+				Function: func(ctx context.Context) ([]service.Part, error) {
+					return []service.Part{
+						{StatusCode: http.StatusOK, Body: map[string]int{"id": 1}},
+						{StatusCode: http.StatusNotFound, Body: "no user 2"},
+					}, nil
+				},
+				Results: definition.DataErrorResults(""),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "/api/v1/batch", nil)
+	resp := newRW()
+	s.ServeHTTP(resp, req)
+	if resp.code != http.StatusOK {
+		t.Fatalf("Response code should be 200, but got: %d %s", resp.code, resp.buf.String())
+	}
+	mt, params, err := mime.ParseMediaType(resp.header.Get("Content-Type"))
+	if err != nil || mt != definition.MIMEMultipartMixed {
+		t.Fatalf("Content type is not desired: %s %v", resp.header.Get("Content-Type"), err)
+	}
+	reader := multipart.NewReader(resp.buf, params["boundary"])
+	statuses := []string{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		statuses = append(statuses, part.Header.Get("Status"))
+	}
+	if !reflect.DeepEqual(statuses, []string{"200 OK", "404 Not Found"}) {
+		t.Fatalf("Parts are not desired: %v", statuses)
+	}
+}
+
+func TestRedirectResults(t *testing.T) {
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/oauth/callback",
+		Consumes: []string{definition.MIMENone},
+		Produces: []string{definition.MIMEJSON},
+		Definitions: []definition.Definition{
+			{
+				Method: definition.Get,
+				Function: func(ctx context.Context, code int) (map[string]string, *definition.Redirection, string, error) {
+					meta := map[string]string{"Set-Cookie": "session=1"}
+					if code < 0 {
+						return meta, nil, "no redirection", nil
+					}
+					return meta, &definition.Redirection{Location: "/home?from=oauth", Code: code}, "ignored", nil
+				},
+				Parameters: []definition.Parameter{definition.QueryParameterFor("code", "")},
+				Results: []definition.Result{
+					definition.MetaResultFor(""),
+					definition.RedirectResultFor(""),
+					definition.DataResultFor(""),
+					definition.ErrorResult(),
+				},
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for code, want := range map[int]int{
+		0:                            http.StatusFound,
+		http.StatusMovedPermanently:  http.StatusMovedPermanently,
+		http.StatusFound:             http.StatusFound,
+		http.StatusSeeOther:          http.StatusSeeOther,
+		http.StatusTemporaryRedirect: http.StatusTemporaryRedirect,
+		http.StatusPermanentRedirect: http.StatusPermanentRedirect,
+		http.StatusOK:                http.StatusInternalServerError,
+		http.StatusMultipleChoices:   http.StatusInternalServerError,
+	} {
+		req, _ := http.NewRequest("GET", "/oauth/callback?code="+strconv.Itoa(code), nil)
+		resp := newRW()
+		s.ServeHTTP(resp, req)
+		if resp.code != want {
+			t.Fatalf("Code %d: response code should be %d, but got: %d %s", code, want, resp.code, resp.buf.String())
+		}
+		if want == http.StatusInternalServerError {
+			continue
+		}
+		if resp.header.Get("Location") != "/home?from=oauth" || resp.header.Get("Set-Cookie") != "session=1" {
+			t.Fatalf("Code %d: headers are not desired: %v", code, resp.header)
+		}
+		if resp.buf.Len() != 0 {
+			t.Fatalf("Code %d: redirections should have no body, but got: %s", code, resp.buf.String())
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "/oauth/callback?code=-1", nil)
+	resp := newRW()
+	s.ServeHTTP(resp, req)
+	if resp.code != http.StatusOK || resp.header.Get("Location") != "" || !strings.Contains(resp.buf.String(), "no redirection") {
+		t.Fatalf("Data should be written without redirection: %d %v %s", resp.code, resp.header, resp.buf.String())
+	}
+
+	builder = NewBuilder()
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path: "/invalid",
+		Definitions: []definition.Definition{{
+			Method:   definition.Get,
+			Function: func() (string, error) { return "", nil },
+			Results:  []definition.Result{definition.RedirectResultFor(""), definition.ErrorResult()},
+		}},
+	}); err == nil {
+		if _, err := builder.Build(); err == nil {
+			t.Fatal("Redirect results of string should be rejected")
+		}
+	}
+}
+
+func TestWhenConsumes(t *testing.T) {
+	type user struct {
+		Name string `json:"name" form:"name"`
+	}
+	ran := 0
+	upper := definition.OperatorFunc("upper", func(ctx context.Context, field string, u *user) (*user, error) {
+		ran++
+		u.Name = strings.ToUpper(u.Name)
+		return u, nil
+	})
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/api/v1/users",
+		Consumes: []string{definition.MIMEJSON, definition.MIMEURLEncoded},
+		Produces: []string{definition.MIMEText},
+		Definitions: []definition.Definition{
+			{
+				Method: definition.Create,
+				Function: func(ctx context.Context, u *user) (string, error) {
+					return u.Name, nil
+				},
+				Parameters: []definition.Parameter{
+					definition.BodyParameterFor("", service.WhenConsumes(upper, definition.MIMEJSON)),
+				},
+				Results: definition.DataErrorResults(""),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		contentType string
+		body        string
+		want        string
+		ran         int
+	}{
+		{definition.MIMEJSON, `{"name":"alice"}`, "ALICE", 1},
+		{definition.MIMEJSON + "; charset=utf-8", `{"name":"bob"}`, "BOB", 2},
+		{definition.MIMEURLEncoded, "name=carol", "carol", 2},
+	} {
+		req, _ := http.NewRequest("POST", "/api/v1/users", strings.NewReader(test.body))
+		req.Header.Set("Content-Type", test.contentType)
+		resp := newRW()
+		s.ServeHTTP(resp, req)
+		if resp.code != http.StatusCreated || resp.buf.String() != test.want || ran != test.ran {
+			t.Fatalf("Content type %s: got %d %s and %d runs, want %s and %d runs",
+				test.contentType, resp.code, resp.buf.String(), ran, test.want, test.ran)
+		}
+	}
+
+	// Operators always run without http contexts.
+	op := service.WhenConsumes(upper, "application/*")
+	v, err := op.Operate(context.Background(), "", &user{Name: "dave"})
+	if err != nil || v.(*user).Name != "DAVE" {
+		t.Fatalf("Operator should run without http contexts: %v %v", v, err)
+	}
+}
+
+func TestRawBodyParameter(t *testing.T) {
+	type event struct {
+		Type string `json:"type"`
+	}
+	sign := func(data []byte) string {
+		return fmt.Sprintf("%x", sha256.Sum256(data))
+	}
+	webhook := func(ctx context.Context, signature string, raw []byte, e *event) (string, error) {
+		if sign(raw) != signature {
+			return "", fmt.Errorf("invalid signature")
+		}
+		return e.Type, nil
+	}
+	descriptor := func(parameters ...definition.Parameter) definition.Descriptor {
+		return definition.Descriptor{
+			Path:     "/webhooks",
+			Consumes: []string{definition.MIMEJSON},
+			Produces: []string{definition.MIMEText},
+			Definitions: []definition.Definition{
+				{
+					Method:     definition.Create,
+					Function:   webhook,
+					Parameters: parameters,
+					Results:    definition.DataErrorResults(""),
+				},
+			},
+		}
+	}
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(descriptor(
+		definition.HeaderParameterFor("X-Signature", ""),
+		definition.RawBodyParameterFor(""),
+		definition.BodyParameterFor(""),
+	)); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Spaces and the order of keys are kept in raw body.
+	body := []byte("{ \"type\" : \"push\",\n  \"id\": 1 }\n")
+	req, _ := http.NewRequest("POST", "/webhooks", bytes.NewReader(body))
+	req.Header.Set("Content-Type", definition.MIMEJSON)
+	req.Header.Set("X-Signature", sign(body))
+	resp := newRW()
+	s.ServeHTTP(resp, req)
+	if resp.code != http.StatusCreated || resp.buf.String() != "push" {
+		t.Fatalf("Raw body should match the request body: %d %s", resp.code, resp.buf.String())
+	}
+
+	req, _ = http.NewRequest("POST", "/webhooks", bytes.NewReader(body))
+	req.Header.Set("Content-Type", definition.MIMEJSON)
+	req.Header.Set("X-Signature", sign([]byte(`{"type":"push","id":1}`)))
+	resp = newRW()
+	s.ServeHTTP(resp, req)
+	if resp.code != http.StatusInternalServerError {
+		t.Fatalf("Response code should be 500, but got: %d %s", resp.code, resp.buf.String())
+	}
+
+	// A raw body parameter after a body parameter would read nothing.
+	builder = NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	d := descriptor(
+		definition.HeaderParameterFor("X-Signature", ""),
+		definition.BodyParameterFor(""),
+		definition.RawBodyParameterFor(""),
+	)
+	d.Definitions[0].Function = func(ctx context.Context, signature string, e *event, raw []byte) (string, error) {
+		return webhook(ctx, signature, raw, e)
+	}
+	if err := builder.AddDescriptor(d); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := builder.Build(); err == nil || !strings.Contains(err.Error(), "raw body") {
+		t.Fatalf("Raw body parameter after body parameter should be rejected: %v", err)
+	}
+}
+
+func TestSecurity(t *testing.T) {
+	type caller struct {
+		Name string
+	}
+	if err := service.RegisterSecurityScheme(definition.BearerScheme("test-bearer", "JWT"),
+		func(ctx context.Context, token string, scopes []string) error {
+			if token != "alice-token" {
+				return errors.Unauthorized.Error("invalid token")
+			}
+			if len(scopes) > 0 && scopes[0] == "admin" {
+				return errors.Forbidden.Error("scope admin is required")
+			}
+			service.SetAutoValue(ctx, &caller{Name: "alice"})
+			return nil
+		}); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.RegisterSecurityScheme(definition.APIKeyScheme("test-key", definition.Header, "X-API-Key"),
+		func(ctx context.Context, key string, scopes []string) error {
+			if key != "bob-key" {
+				return errors.Unauthorized.Error("invalid key")
+			}
+			service.SetAutoValue(ctx, &caller{Name: "bob"})
+			return nil
+		}); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.RegisterSecurityScheme(definition.SecurityScheme{Name: "test-invalid", Type: definition.APIKeyAuthentication},
+		func(ctx context.Context, key string, scopes []string) error { return nil }); err == nil {
+		t.Fatal("API key scheme without location should be rejected")
+	}
+
+	called := 0
+	whoami := func(ctx context.Context, c *caller) (string, error) {
+		called++
+		return c.Name, nil
+	}
+	descriptor := func(path string, security ...definition.SecurityRequirement) definition.Descriptor {
+		return definition.Descriptor{
+			Path:     path,
+			Consumes: []string{definition.MIMENone},
+			Produces: []string{definition.MIMEText},
+			Definitions: []definition.Definition{
+				{
+					Method:     definition.Get,
+					Function:   whoami,
+					Parameters: []definition.Parameter{definition.AutoParameterFor("")},
+					Results:    definition.DataErrorResults(""),
+					Security:   security,
+				},
+			},
+		}
+	}
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(
+		descriptor("/whoami", definition.SecurityFor("test-bearer"), definition.SecurityFor("test-key")),
+		descriptor("/admin", definition.SecurityFor("test-bearer", "admin")),
+	); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path   string
+		header map[string]string
+		code   int
+		body   string
+	}{
+		{"/whoami", nil, http.StatusUnauthorized, ""},
+		{"/whoami", map[string]string{"Authorization": "Basic YWxpY2U6"}, http.StatusUnauthorized, ""},
+		{"/whoami", map[string]string{"Authorization": "Bearer wrong"}, http.StatusUnauthorized, "invalid token"},
+		{"/whoami", map[string]string{"Authorization": "bearer alice-token"}, http.StatusOK, "alice"},
+		{"/whoami", map[string]string{"X-API-Key": "bob-key"}, http.StatusOK, "bob"},
+		// The first rejection is returned if no requirement is passed.
+		{"/whoami", map[string]string{"Authorization": "Bearer wrong", "X-API-Key": "wrong"}, http.StatusUnauthorized, "invalid token"},
+		{"/whoami", map[string]string{"Authorization": "Bearer wrong", "X-API-Key": "bob-key"}, http.StatusOK, "bob"},
+		{"/admin", map[string]string{"Authorization": "Bearer alice-token"}, http.StatusForbidden, "scope admin is required"},
+	}
+	for _, test := range tests {
+		called = 0
+		req, _ := http.NewRequest("GET", test.path, nil)
+		for k, v := range test.header {
+			req.Header.Set(k, v)
+		}
+		resp := newRW()
+		s.ServeHTTP(resp, req)
+		if resp.code != test.code || !strings.Contains(resp.buf.String(), test.body) {
+			t.Fatalf("Unexpected response of %s with %v: %d %s", test.path, test.header, resp.code, resp.buf.String())
+		}
+		if resp.code != http.StatusOK {
+			if called != 0 {
+				t.Fatalf("Handler should not be called for rejected request %s with %v", test.path, test.header)
+			}
+			if got := resp.header.Get("WWW-Authenticate"); got != "Bearer" {
+				t.Fatalf("Rejected request should get a bearer challenge: %q", got)
+			}
+		}
+	}
+
+	builder = NewBuilder()
+	if err := builder.AddDescriptor(descriptor("/unknown", definition.SecurityFor("test-unknown"))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := builder.Build(); err == nil || !strings.Contains(err.Error(), "test-unknown") {
+		t.Fatalf("Unknown security scheme should be rejected: %v", err)
+	}
+}
+
+type testInterceptor struct {
+	before func(ctx context.Context, invocation *service.Invocation) error
+	after  func(ctx context.Context, invocation *service.Invocation, results []interface{}, err error)
+}
+
+func (i *testInterceptor) BeforeHandler(ctx context.Context, invocation *service.Invocation) error {
+	return i.before(ctx, invocation)
+}
+
+func (i *testInterceptor) AfterHandler(ctx context.Context, invocation *service.Invocation, results []interface{}, err error) {
+	i.after(ctx, invocation, results, err)
+}
+
+func TestInterceptors(t *testing.T) {
+	var events []string
+	var last struct {
+		name       string
+		parameters []interface{}
+		results    []interface{}
+		err        error
+	}
+	audit := &testInterceptor{
+		before: func(ctx context.Context, invocation *service.Invocation) error {
+			events = append(events, "audit before")
+			return nil
+		},
+		after: func(ctx context.Context, invocation *service.Invocation, results []interface{}, err error) {
+			events = append(events, "audit after")
+			last.name = invocation.Definition.Parameters[1].Name
+			last.parameters = invocation.Parameters
+			last.results = results
+			last.err = err
+		},
+	}
+	guard := &testInterceptor{
+		before: func(ctx context.Context, invocation *service.Invocation) error {
+			events = append(events, "guard before")
+			if invocation.Parameters[1] == "locked" {
+				return errors.Forbidden.Error("product ${id} is locked", invocation.Parameters[1])
+			}
+			return nil
+		},
+		after: func(ctx context.Context, invocation *service.Invocation, results []interface{}, err error) {
+			events = append(events, "guard after")
+		},
+	}
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	builder.(service.InterceptorBuilder).AddInterceptor(audit, guard)
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/products/{id}",
+		Consumes: []string{definition.MIMENone},
+		Produces: []string{definition.MIMEText},
+		Definitions: []definition.Definition{
+			{
+				Method: definition.Update,
+				Function: func(ctx context.Context, id string) (string, error) {
+					events = append(events, "handler")
+					if id == "missing" {
+						return "", errors.NotFound.Error("product ${id} is not found", id)
+					}
+					return "updated " + id, nil
+				},
+				Parameters: []definition.Parameter{definition.PathParameterFor("id", "")},
+				Results:    definition.DataErrorResults(""),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	put := func(id string) *responseWriter {
+		events = nil
+		last.name, last.parameters, last.results, last.err = "", nil, nil, nil
+		req, _ := http.NewRequest("PUT", "/products/"+id, nil)
+		resp := newRW()
+		s.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := put("1")
+	if resp.code != http.StatusOK || resp.buf.String() != "updated 1" {
+		t.Fatalf("Unexpected response: %d %s", resp.code, resp.buf.String())
+	}
+	if !reflect.DeepEqual(events, []string{"audit before", "guard before", "handler", "guard after", "audit after"}) {
+		t.Fatalf("Unexpected order of interceptors: %v", events)
+	}
+	if last.name != "id" || len(last.parameters) != 2 || last.parameters[1] != "1" || last.err != nil {
+		t.Fatalf("Unexpected invocation: %+v", last)
+	}
+	if len(last.results) != 2 || last.results[0] != "updated 1" || last.results[1] != nil {
+		t.Fatalf("Unexpected results: %v", last.results)
+	}
+
+	resp = put("missing")
+	if resp.code != http.StatusNotFound {
+		t.Fatalf("Unexpected response: %d %s", resp.code, resp.buf.String())
+	}
+	if len(events) != 5 || last.err == nil || !strings.Contains(last.err.Error(), "not found") || len(last.results) != 2 || last.results[1] != last.err {
+		t.Fatalf("Interceptors should get the error of handler: %v %+v", events, last)
+	}
+
+	// A rejection of an interceptor skips the handler and later interceptors.
+	resp = put("locked")
+	if resp.code != http.StatusForbidden || !strings.Contains(resp.buf.String(), "locked") {
+		t.Fatalf("Unexpected response: %d %s", resp.code, resp.buf.String())
+	}
+	if !reflect.DeepEqual(events, []string{"audit before", "guard before", "audit after"}) {
+		t.Fatalf("Unexpected calls of rejected request: %v", events)
+	}
+	if last.err == nil || !strings.Contains(last.err.Error(), "locked") || last.results != nil {
+		t.Fatalf("Interceptors should get the rejection: %+v", last)
+	}
+}
+
+func TestBodyStream(t *testing.T) {
+	type record struct {
+		ID int `json:"id"`
+	}
+	// received is notified for every record received by the handler.
+	var received chan int
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/records",
+		Consumes: []string{definition.MIMENDJSON, definition.MIMEJSON, definition.MIMEText},
+		Produces: []string{definition.MIMEText},
+		Definitions: []definition.Definition{
+			{
+				Method: definition.Create,
+				Function: func(ctx context.Context, records <-chan *record) (string, error) {
+					count, sum := 0, 0
+					for r := range records {
+						count++
+						sum += r.ID
+						if received != nil {
+							received <- r.ID
+						}
+					}
+					if err := service.BodyStreamError(ctx); err != nil && received != nil {
+						close(received)
+					}
+					return fmt.Sprintf("%d %d", count, sum), nil
+				},
+				Parameters: []definition.Parameter{definition.BodyParameterFor("")},
+				Results:    definition.DataErrorResults(""),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	post := func(ctx context.Context, contentType string, body io.Reader) *responseWriter {
+		req, _ := http.NewRequest("POST", "/records", body)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", contentType)
+		resp := newRW()
+		s.ServeHTTP(resp, req)
+		return resp
+	}
+
+	buf := bytes.NewBuffer(nil)
+	for i := 1; i <= 10000; i++ {
+		fmt.Fprintf(buf, "{\"id\":%d}\n", i)
+	}
+	resp := post(context.Background(), definition.MIMENDJSON, buf)
+	if resp.code != http.StatusCreated || resp.buf.String() != "10000 50005000" {
+		t.Fatalf("Unexpected response of large stream: %d %s", resp.code, resp.buf.String())
+	}
+	resp = post(context.Background(), definition.MIMEJSON, strings.NewReader(`[{"id":1}, {"id":2}, {"id":3}]`))
+	if resp.code != http.StatusCreated || resp.buf.String() != "3 6" {
+		t.Fatalf("Unexpected response of json array: %d %s", resp.code, resp.buf.String())
+	}
+	resp = post(context.Background(), definition.MIMENDJSON, strings.NewReader(""))
+	if resp.code != http.StatusCreated || resp.buf.String() != "0 0" {
+		t.Fatalf("Unexpected response of empty stream: %d %s", resp.code, resp.buf.String())
+	}
+	resp = post(context.Background(), definition.MIMEText, strings.NewReader("1\n2\n"))
+	if resp.code != http.StatusUnsupportedMediaType {
+		t.Fatalf("Content type without stream consumer should be rejected: %d %s", resp.code, resp.buf.String())
+	}
+
+	// Records are received before the whole body is written.
+	received = make(chan int)
+	reader, writer := io.Pipe()
+	done := make(chan *responseWriter)
+	go func() {
+		done <- post(context.Background(), definition.MIMENDJSON, reader)
+	}()
+	for i := 1; i <= 3; i++ {
+		fmt.Fprintf(writer, "{\"id\":%d}\n", i)
+		if id := <-received; id != i {
+			t.Fatalf("Unexpected record: %d", id)
+		}
+	}
+	writer.Close()
+	if resp = <-done; resp.code != http.StatusCreated || resp.buf.String() != "3 6" {
+		t.Fatalf("Unexpected response of incremental stream: %d %s", resp.code, resp.buf.String())
+	}
+
+	// A malformed record stops the stream, and the error is written as the
+	// response if the handler returns no error.
+	received = make(chan int, 10)
+	resp = post(context.Background(), definition.MIMENDJSON, strings.NewReader("{\"id\":1}\n{\"id\":2}\n{\"id\":\n{\"id\":4}\n"))
+	if resp.code != http.StatusBadRequest {
+		t.Fatalf("Malformed record should be rejected: %d %s", resp.code, resp.buf.String())
+	}
+	ids := []int{}
+	for id := range received {
+		ids = append(ids, id)
+	}
+	if !reflect.DeepEqual(ids, []int{1, 2}) {
+		t.Fatalf("Records before the malformed one should be received: %v", ids)
+	}
+
+	// Cancellation of the request stops decoding.
+	received = make(chan int)
+	reader, writer = io.Pipe()
+	defer writer.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		done <- post(ctx, definition.MIMENDJSON, reader)
+	}()
+	fmt.Fprint(writer, "{\"id\":1}\n{\"id\":2}\n")
+	if id := <-received; id != 1 {
+		t.Fatalf("Unexpected record: %d", id)
+	}
+	cancel()
+	for range received {
+		// Record 2 may be received before the cancellation is seen.
+	}
+	select {
+	case resp = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Cancellation should stop the stream")
+	}
+	if resp.code == http.StatusCreated {
+		t.Fatalf("Cancelled stream should not succeed: %d %s", resp.code, resp.buf.String())
+	}
+}
+
+func TestBoundParameter(t *testing.T) {
+	var seen []string
+	after := definition.OperatorFunc("validator", func(ctx context.Context, field string, to int) (int, error) {
+		if _, ok := service.BoundParameter(ctx, "limit"); ok {
+			seen = append(seen, "limit")
+		}
+		from, ok := service.BoundParameter(ctx, "from")
+		if !ok {
+			return to, errors.InternalServerError.Error("from is not bound")
+		}
+		if to < from.(int) {
+			return to, errors.BadRequest.Error("${field} must not be less than from", field)
+		}
+		return to, nil
+	})
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/range",
+		Consumes: []string{definition.MIMENone},
+		Produces: []string{definition.MIMEText},
+		Definitions: []definition.Definition{
+			{
+				Method: definition.Get,
+				Function: func(ctx context.Context, from, to, limit int) (string, error) {
+					return fmt.Sprintf("%d-%d", from, to), nil
+				},
+				Parameters: []definition.Parameter{
+					definition.QueryParameterFor("from", "", definition.NumericRangeOperator(0, 100)),
+					definition.QueryParameterFor("to", "", after),
+					definition.QueryParameterFor("limit", ""),
+				},
+				Results: definition.DataErrorResults(""),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(query string) *responseWriter {
+		req, _ := http.NewRequest("GET", "/range?"+query, nil)
+		resp := newRW()
+		s.ServeHTTP(resp, req)
+		return resp
+	}
+
+	if resp := get("from=3&to=5&limit=1"); resp.code != http.StatusOK || resp.buf.String() != "3-5" {
+		t.Fatalf("Valid range should be accepted: %d %s", resp.code, resp.buf.String())
+	}
+	if resp := get("from=3&to=3"); resp.code != http.StatusOK || resp.buf.String() != "3-3" {
+		t.Fatalf("Valid range should be accepted: %d %s", resp.code, resp.buf.String())
+	}
+	if resp := get("from=5&to=3"); resp.code != http.StatusBadRequest || !strings.Contains(resp.buf.String(), "to must not be less than from") {
+		t.Fatalf("Invalid range should be rejected: %d %s", resp.code, resp.buf.String())
+	}
+	// Absent parameters are bound as zero values.
+	if resp := get("to=0"); resp.code != http.StatusOK || resp.buf.String() != "0-0" {
+		t.Fatalf("Absent parameter should be bound: %d %s", resp.code, resp.buf.String())
+	}
+	// A parameter whose operators failed is not bound.
+	if resp := get("from=200&to=300"); resp.code != http.StatusBadRequest || strings.Contains(resp.buf.String(), "not bound") {
+		t.Fatalf("Invalid parameter should stop binding: %d %s", resp.code, resp.buf.String())
+	}
+	if len(seen) != 0 {
+		t.Fatalf("Later parameters should not be visible: %v", seen)
+	}
+}
+
+func TestCSVProducer(t *testing.T) {
+	type Report struct {
+		Name  string `csv:"name" json:"name"`
+		Count int    `csv:"count" json:"count"`
+	}
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/reports",
+		Consumes: []string{definition.MIMENone},
+		Produces: []string{definition.MIMEJSON, definition.MIMECSV},
+		Definitions: []definition.Definition{
+			{
+				Method: definition.Get,
+				Function: func(ctx context.Context) ([]Report, error) {
+					return []Report{{"a, b", 1}, {"c", 2}}, nil
+				},
+				Results: definition.DataErrorResults(""),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "/reports", nil)
+	req.Header.Set("Accept", "text/csv")
+	resp := newRW()
+	s.ServeHTTP(resp, req)
+	if resp.code != http.StatusOK || resp.header.Get("Content-Type") != definition.MIMECSV {
+		t.Fatalf("Unexpected response: %d %v", resp.code, resp.header)
+	}
+	if body := resp.buf.String(); body != "name,count\n\"a, b\",1\nc,2\n" {
+		t.Fatalf("Unexpected csv: %q", body)
+	}
+}
+
+func TestQueryPrefixParameter(t *testing.T) {
+	var filters map[string]string
+	var limits map[string]int
+	var all map[string][]string
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:     "/items",
+		Consumes: []string{definition.MIMENone},
+		Produces: []string{definition.MIMEText},
+		Definitions: []definition.Definition{
+			{
+				Method: definition.Get,
+				Function: func(ctx context.Context, f map[string]string, l map[string]int, a map[string][]string) (string, error) {
+					filters, limits, all = f, l, a
+					return "ok", nil
+				},
+				Parameters: []definition.Parameter{
+					definition.QueryPrefixParameterFor("filter.", ""),
+					definition.QueryPrefixParameterFor("limit.", ""),
+					definition.QueryPrefixParameterFor("", ""),
+				},
+				Results: definition.DataErrorResults(""),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(query string) *responseWriter {
+		filters, limits, all = nil, nil, nil
+		req, _ := http.NewRequest("GET", "/items?"+query, nil)
+		resp := newRW()
+		s.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := get("filter.status=active&filter.region=us&filter.=x&filter.empty=&limit.page=10&sort=name&sort=id")
+	if resp.code != http.StatusOK {
+		t.Fatalf("Unexpected response: %d %s", resp.code, resp.buf.String())
+	}
+	if !reflect.DeepEqual(filters, map[string]string{"status": "active", "region": "us"}) {
+		t.Fatalf("Unexpected filters: %v", filters)
+	}
+	if !reflect.DeepEqual(limits, map[string]int{"page": 10}) {
+		t.Fatalf("Unexpected limits: %v", limits)
+	}
+	if len(all) != 5 || !reflect.DeepEqual(all["sort"], []string{"name", "id"}) || !reflect.DeepEqual(all["filter.status"], []string{"active"}) {
+		t.Fatalf("Empty prefix should match all query parameters: %v", all)
+	}
+
+	// No matches bind nil maps.
+	if resp := get("other=1"); resp.code != http.StatusOK || filters != nil || limits != nil || len(all) != 1 {
+		t.Fatalf("Unexpected maps without matches: %d %v %v %v", resp.code, filters, limits, all)
+	}
+	if resp := get("limit.page=ten"); resp.code != http.StatusBadRequest {
+		t.Fatalf("Invalid value should be rejected: %d %s", resp.code, resp.buf.String())
+	}
+}
+
+func TestSecurityAfterMiddlewares(t *testing.T) {
+	authenticated := 0
+	if err := service.RegisterSecurityScheme(definition.BearerScheme("test-middleware-bearer", ""),
+		func(ctx context.Context, token string, scopes []string) error {
+			authenticated++
+			if token != "token" {
+				return errors.Unauthorized.Error("invalid token")
+			}
+			return nil
+		}); err != nil {
+		t.Fatal(err)
+	}
+	seen := 0
+	counter := func(ctx context.Context, chain definition.Chain) error {
+		seen++
+		return chain.Continue(ctx)
+	}
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(definition.Descriptor{
+		Path:        "/secret",
+		Consumes:    []string{definition.MIMENone},
+		Produces:    []string{definition.MIMEText},
+		Middlewares: []definition.Middleware{counter},
+		Definitions: []definition.Definition{
+			{
+				Method:      definition.Get,
+				Function:    func(ctx context.Context) (string, error) { return "secret", nil },
+				Results:     definition.DataErrorResults(""),
+				Security:    []definition.SecurityRequirement{definition.SecurityFor("test-middleware-bearer")},
+				Middlewares: []definition.Middleware{counter},
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Middlewares see rejected requests.
+	req, _ := http.NewRequest("GET", "/secret", nil)
+	resp := newRW()
+	s.ServeHTTP(resp, req)
+	if resp.code != http.StatusUnauthorized || seen != 2 {
+		t.Fatalf("Middlewares should run before security: %d %d %s", resp.code, seen, resp.buf.String())
+	}
+
+	authenticated, seen = 0, 0
+	req, _ = http.NewRequest("GET", "/secret", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	resp = newRW()
+	s.ServeHTTP(resp, req)
+	if resp.code != http.StatusOK || resp.buf.String() != "secret" || seen != 2 {
+		t.Fatalf("Unexpected response: %d %d %s", resp.code, seen, resp.buf.String())
+	}
+	if authenticated != 1 {
+		t.Fatalf("Request should be authenticated once, but got %d", authenticated)
+	}
+}
+
 func BenchmarkServer(b *testing.B) {
 	u, _ := url.Parse("/api/v1/1222/false?target1=1&target2=false")
 	data := []byte(`{
@@ -21,8 +21,9 @@ import (
 )
 
 var (
-	noExecutorForMethod      = errors.MethodNotAllowed.Build("Nirvana:Service:NoExecutorForMethod", "method not allowed")
-	noExecutorForContentType = errors.UnsupportedMediaType.Build("Nirvana:Service:NoExecutorForContentType", "unsupported media type")
-	noExecutorToProduce      = errors.NotAcceptable.Build("Nirvana:Service:NoExecutorToProduce", "not acceptable")
+	noExecutorForMethod      = errors.MethodNotAllowed.Build("Nirvana:Service:NoExecutorForMethod", "method ${method} is not allowed, allowed methods: ${allow}")
+	noExecutorForContentType = errors.UnsupportedMediaType.Build("Nirvana:Service:NoExecutorForContentType", "content type '${type}' is not supported, supported types: ${types}")
+	noExecutorToProduce      = errors.NotAcceptable.Build("Nirvana:Service:NoExecutorToProduce", "no acceptable type for '${accept}', available types: ${types}")
 	noRouter                 = errors.InternalServerError.Build("Nirvana:Service:NoRouter", "no router to build service")
+	noRouteForPath           = errors.NotFound.Build("Nirvana:Service:NoRouteForPath", "no route for path ${path}")
 )
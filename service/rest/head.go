@@ -0,0 +1,116 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/caicloud/nirvana/service"
+	"github.com/caicloud/nirvana/service/executor"
+)
+
+// headExecutor executes a GET executor for HEAD requests.
+type headExecutor struct {
+	executor.Executor
+}
+
+// ProducibleFor checks whether the GET executor can produce responses for req.
+func (e *headExecutor) ProducibleFor(req *http.Request) bool {
+	return producible(e.Executor, req)
+}
+
+// Execute executes the GET executor and discards the body of response.
+func (e *headExecutor) Execute(ctx context.Context) error {
+	w := &headWriter{}
+	if !service.WrapResponseWriter(ctx, func(rw http.ResponseWriter) http.ResponseWriter {
+		w.ResponseWriter = rw
+		return w
+	}) {
+		return e.Executor.Execute(ctx)
+	}
+	err := e.Executor.Execute(ctx)
+	w.finish()
+	return err
+}
+
+// headWriter counts the body of response instead of writing it. The header is
+// delayed until the handler finishes, so that "Content-Length" can be set.
+type headWriter struct {
+	http.ResponseWriter
+	code   int
+	length int
+	// written indicates whether the header has been written to the underlying writer.
+	written bool
+	// finished indicates whether the handler has finished. Headers of errors
+	// written after that are written directly.
+	finished bool
+}
+
+// WriteHeader records code.
+func (w *headWriter) WriteHeader(code int) {
+	if w.code != 0 {
+		return
+	}
+	w.code = code
+	if w.finished {
+		w.writeHeader(false)
+	}
+}
+
+// Write counts data and discards it.
+func (w *headWriter) Write(data []byte) (int, error) {
+	if w.code == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.length += len(data)
+	return len(data), nil
+}
+
+// Flush writes the header without "Content-Length", because the body of a
+// flushed response (such as a stream) is incomplete.
+func (w *headWriter) Flush() {
+	if w.code == 0 {
+		return
+	}
+	w.writeHeader(false)
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// finish writes the header with the length of body.
+func (w *headWriter) finish() {
+	w.finished = true
+	if w.code != 0 {
+		w.writeHeader(true)
+	}
+}
+
+func (w *headWriter) writeHeader(withLength bool) {
+	if w.written {
+		return
+	}
+	w.written = true
+	h := w.Header()
+	if withLength && w.code >= http.StatusOK && w.code != http.StatusNoContent && w.code != http.StatusNotModified &&
+		h.Get("Content-Length") == "" && h.Get("Transfer-Encoding") == "" {
+		h.Set("Content-Length", strconv.Itoa(w.length))
+	}
+	w.ResponseWriter.WriteHeader(w.code)
+}
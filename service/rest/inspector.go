@@ -18,6 +18,9 @@ package rest
 
 import (
 	"context"
+	"net/http"
+	"sort"
+	"strings"
 
 	"github.com/caicloud/nirvana/definition"
 	"github.com/caicloud/nirvana/service"
@@ -27,12 +30,15 @@ import (
 type inspector struct {
 	path      string
 	executors map[string][]executor.Executor
+	// interceptors run around functions of definitions.
+	interceptors []service.Interceptor
 }
 
-func newInspector(path string) *inspector {
+func newInspector(path string, interceptors []service.Interceptor) *inspector {
 	return &inspector{
-		path:      path,
-		executors: make(map[string][]executor.Executor),
+		path:         path,
+		executors:    make(map[string][]executor.Executor),
+		interceptors: interceptors,
 	}
 }
 
@@ -46,7 +52,7 @@ func (i *inspector) addDefinition(d definition.Definition) error {
 	if method == "" {
 		return executor.DefinitionNoMethod.Error(d.Method, i.path)
 	}
-	c, err := executor.DefinitionToExecutor(i.path, d, 0)
+	c, err := executor.DefinitionToExecutor(i.path, d, 0, i.interceptors...)
 	if err != nil {
 		return err
 	}
@@ -57,6 +63,17 @@ func (i *inspector) addDefinition(d definition.Definition) error {
 	return nil
 }
 
+// deriveHead adds executors for HEAD from executors for GET if there is no
+// executor for HEAD.
+func (i *inspector) deriveHead() {
+	if len(i.executors[http.MethodHead]) > 0 {
+		return
+	}
+	for _, c := range i.executors[http.MethodGet] {
+		i.executors[http.MethodHead] = append(i.executors[http.MethodHead], &headExecutor{c})
+	}
+}
+
 func (i *inspector) conflictCheck(c executor.Executor, method string) error {
 	cs := i.executors[method]
 	if len(cs) <= 0 {
@@ -82,6 +99,15 @@ func (i *inspector) conflictCheck(c executor.Executor, method string) error {
 	return nil
 }
 
+// producible checks whether an executor can produce responses for req.
+func producible(e executor.Executor, req *http.Request) bool {
+	if ne, ok := e.(executor.NegotiatingExecutor); ok {
+		return ne.ProducibleFor(req)
+	}
+	ats, err := service.AcceptTypes(req)
+	return err == nil && e.Producible(ats)
+}
+
 // Inspect finds a valid executor to execute target context.
 func (i *inspector) Inspect(ctx context.Context) (executor.MiddlewareExecutor, error) {
 	httpCtx := service.HTTPContextFrom(ctx)
@@ -97,7 +123,11 @@ func (i *inspector) Inspect(ctx context.Context) (executor.MiddlewareExecutor, e
 		executors = append(executors, cs...)
 	}
 	if len(executors) <= 0 {
-		return nil, noExecutorForMethod.Error()
+		if req.Method == http.MethodOptions {
+			httpCtx.SetRoutePath(i.path)
+			return &optionsExecutor{allow: i.allow()}, nil
+		}
+		return nil, noExecutorForMethod.Error(req.Method, i.allow())
 	}
 	ct, err := service.ContentType(req)
 	if err != nil {
@@ -113,30 +143,130 @@ func (i *inspector) Inspect(ctx context.Context) (executor.MiddlewareExecutor, e
 		}
 	}
 	if accepted <= 0 {
-		return nil, noExecutorForContentType.Error()
+		consumes := map[string]bool{}
+		for _, c := range executors {
+			for ct := range c.ContentTypeMap() {
+				consumes[ct] = true
+			}
+		}
+		return nil, noExecutorForContentType.Error(ct, joinContentTypes(consumes))
 	}
-	ats, err := service.AcceptTypes(req)
+	ranges, err := service.AcceptRanges(req)
 	if err != nil {
 		return nil, err
 	}
 	executors = executors[:accepted]
-	var target executor.Executor
-	for _, c := range executors {
-		if c.Producible(ats) {
-			target = c
-			break
+	target := chooseVersion(executors, ct, ranges)
+	if target == nil {
+		for _, c := range executors {
+			if producible(c, req) {
+				target = c
+				break
+			}
 		}
 	}
 	if target == nil {
-		for _, at := range ats {
-			if at == definition.MIMEAll {
+		for _, r := range ranges {
+			if r.MediaType() == definition.MIMEAll && r.Quality > 0 {
 				target = executors[0]
 			}
 		}
 	}
 	if target == nil {
-		return nil, noExecutorToProduce.Error()
+		produces := map[string]bool{}
+		for _, c := range executors {
+			for _, pt := range c.ContentTypeMap()[ct] {
+				produces[pt] = true
+			}
+		}
+		accept := req.Header.Get("Accept")
+		if accept == "" {
+			accept = definition.MIMEAll
+		}
+		return nil, noExecutorToProduce.Error(accept, joinContentTypes(produces))
 	}
 	httpCtx.SetRoutePath(i.path)
 	return target, nil
 }
+
+// chooseVersion chooses the executor which produces the vendor type in ranges,
+// such as "application/vnd.myapp.v2+json", so a requested version wins over the
+// default version. For "*/*", the executor which produces the default version
+// wins. It returns nil if the most preferred range is not a vendor type or
+// "*/*", or no executor produces the vendor type.
+func chooseVersion(executors []executor.Executor, ct string, ranges []service.AcceptRange) executor.Executor {
+	for _, r := range ranges {
+		if r.Quality <= 0 {
+			continue
+		}
+		wildcard := r.MediaType() == definition.MIMEAll
+		if _, ok := service.ParseVendorType(r.Name); !ok && !wildcard {
+			return nil
+		}
+		for _, c := range executors {
+			for _, pt := range c.ContentTypeMap()[ct] {
+				t, ok := service.ParseVendorType(pt)
+				if !ok || t.Version == "" {
+					continue
+				}
+				if (wildcard && t.Version == service.DefaultVersion(t.Vendor)) ||
+					(!wildcard && service.MediaType(pt) == r.MediaType()) {
+					return c
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// allow returns methods which the path allows, for header "Allow". OPTIONS is
+// always allowed. If there is a definition for any methods, all methods are allowed.
+func (i *inspector) allow() string {
+	methods := map[string]bool{http.MethodOptions: true}
+	for method, cs := range i.executors {
+		if len(cs) <= 0 {
+			continue
+		}
+		if method != string(definition.Any) {
+			methods[method] = true
+			continue
+		}
+		for _, m := range []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+			http.MethodPatch, http.MethodDelete, http.MethodConnect, http.MethodTrace} {
+			methods[m] = true
+		}
+	}
+	result := make([]string, 0, len(methods))
+	for m := range methods {
+		result = append(result, m)
+	}
+	sort.Strings(result)
+	return strings.Join(result, ", ")
+}
+
+// optionsExecutor responds OPTIONS requests of paths which have no definition for
+// OPTIONS.
+type optionsExecutor struct {
+	allow string
+}
+
+// Execute writes 204 with header "Allow".
+func (e *optionsExecutor) Execute(ctx context.Context) error {
+	resp := service.HTTPContextFrom(ctx).ResponseWriter()
+	resp.Header().Set("Allow", e.allow)
+	resp.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// joinContentTypes joins content types in alphabetical order. The empty content
+// type (definition.MIMENone) is ignored.
+func joinContentTypes(types map[string]bool) string {
+	result := make([]string, 0, len(types))
+	for ct := range types {
+		if ct != definition.MIMENone {
+			result = append(result, ct)
+		}
+	}
+	sort.Strings(result)
+	return strings.Join(result, ", ")
+}
@@ -32,7 +32,7 @@ type definitionMap struct {
 }
 
 func TestAddDefinition(t *testing.T) {
-	inspector := newInspector("/test")
+	inspector := newInspector("/test", nil)
 	units := []definitionMap{
 		{
 			definition.Definition{
@@ -18,12 +18,17 @@ package router
 
 import (
 	"context"
+	"net/url"
 	"reflect"
+	"strings"
 
 	"github.com/caicloud/nirvana/service/executor"
 )
 
-// pathNode matches all rest path.
+// pathNode matches all rest path. Segments of the path are URL-decoded before
+// being saved to container, but escaped slashes ("%2F") stay escaped, so that
+// they can't make new segments. If the path can't be decoded, the original one
+// is saved.
 type pathNode struct {
 	handler
 	// key is the key for the rest path.
@@ -46,10 +51,23 @@ func (n *pathNode) Kind() RouteKind {
 // If the router is the leaf node to match the path, it will return
 // the first executor which Inspect() returns true.
 func (n *pathNode) Match(ctx context.Context, c Container, path string) (executor.MiddlewareExecutor, error) {
-	c.Set(n.key, path)
+	c.Set(n.key, unescapeSegments(path))
 	return n.handler.unionExecutor(ctx)
 }
 
+// unescapeSegments decodes segments of a path and keeps escaped slashes.
+func unescapeSegments(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		value, err := url.PathUnescape(segment)
+		if err != nil {
+			return path
+		}
+		segments[i] = strings.Replace(value, "/", "%2F", -1)
+	}
+	return strings.Join(segments, "/")
+}
+
 // Merge merges r to the current router. The type of r should be same
 // as the current one.
 func (n *pathNode) Merge(r Router) (Router, error) {
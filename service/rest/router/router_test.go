@@ -458,6 +458,32 @@ func TestRouter(t *testing.T) {
 	testMatch(t, makeRouter(t, rds), right, wrong)
 }
 
+func TestCatchAll(t *testing.T) {
+	rds := []TestRouterData{
+		{"/files/{path:*}", getExecs, nil},
+		{"/files/readme", delExecs, nil},
+		{"/files/{name}.json", putExecs, nil},
+	}
+	right := []TestData{
+		{"/files/a", "GET", 200, map[string]string{"path": "a"}},
+		{"/files/a/b/c.txt", "GET", 200, map[string]string{"path": "a/b/c.txt"}},
+		{"/files/a/b/", "GET", 200, map[string]string{"path": "a/b/"}},
+		{"/files/a%2Fb%20c", "GET", 200, map[string]string{"path": "a%2Fb c"}},
+		{"/files/a%20b/..%2F..%2Fetc", "GET", 200, map[string]string{"path": "a b/..%2F..%2Fetc"}},
+		{"/files/readme", "DELETE", 201, nil},
+		{"/files/readme/v2", "GET", 200, map[string]string{"path": "readme/v2"}},
+		{"/files/data.json", "PUT", 202, map[string]string{"name": "data"}},
+		// Only catch-all values are decoded.
+		{"/files/a%2Fb%20c.json", "PUT", 202, map[string]string{"name": "a%2Fb%20c"}},
+		{"/files/data.json/raw", "GET", 200, map[string]string{"path": "data.json/raw"}},
+	}
+	wrong := []TestData{
+		{"/files/", "GET", 0, nil},
+		{"/files", "GET", 0, nil},
+	}
+	testMatch(t, makeRouter(t, rds), right, wrong)
+}
+
 // borrowed from https://github.com/go-chi/chi/blob/master/tree_test.go
 func TestFromChiTree(t *testing.T) {
 	rds := []TestRouterData{
@@ -0,0 +1,78 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/caicloud/nirvana/definition"
+	"github.com/caicloud/nirvana/log"
+	"github.com/caicloud/nirvana/service"
+)
+
+// DescriptorError describes why a path or a definition under the path is invalid.
+type DescriptorError struct {
+	// Path is the full path of descriptor.
+	Path string
+	// Method is the method of definition. It's empty if the path is invalid.
+	Method definition.Method
+	// Err is the original error.
+	Err error
+}
+
+// Error returns the error with path and method.
+func (e *DescriptorError) Error() string {
+	if e.Method == "" {
+		return fmt.Sprintf("path %s: %s", e.Path, e.Err.Error())
+	}
+	return fmt.Sprintf("%s %s: %s", e.Method, e.Path, e.Err.Error())
+}
+
+// DescriptorErrors contains all errors of descriptors.
+type DescriptorErrors []*DescriptorError
+
+// Error returns all errors line by line.
+func (e DescriptorErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("%d invalid descriptor(s):\n%s", len(e), strings.Join(messages, "\n"))
+}
+
+// Validate checks descriptors as Build does, but doesn't stop at the first error.
+// Definitions are modified by modifier before checking, so modifier should be the
+// same as the one of builder. It checks paths, function signatures, parameters
+// (including prefabs), operator chains, results and conflicts of definitions.
+// It returns DescriptorErrors if there is any error, so that invalid descriptors
+// can be found before serving, such as in CI.
+func Validate(modifier service.DefinitionModifier, descriptors ...definition.Descriptor) error {
+	b := NewBuilder().(*builder)
+	b.modifier = modifier
+	// Errors are returned rather than logged.
+	b.logger = &log.SilentLogger{}
+	for _, descriptor := range descriptors {
+		b.addDescriptor("", nil, nil, nil, false, descriptor)
+	}
+	var errs DescriptorErrors
+	_, _ = b.buildRouterWith(&errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/caicloud/nirvana/definition"
+	"github.com/caicloud/nirvana/service"
+)
+
+func TestValidate(t *testing.T) {
+	get := func(ctx context.Context, name string) (string, error) {
+		return name, nil
+	}
+	valid := definition.Descriptor{
+		Path:     "/api/v1/items",
+		Consumes: []string{definition.MIMENone},
+		Produces: []string{definition.MIMEJSON},
+		Children: []definition.Descriptor{
+			{
+				Path: "{name}",
+				Definitions: []definition.Definition{
+					{
+						Method:     definition.Get,
+						Function:   get,
+						Parameters: []definition.Parameter{definition.PathParameterFor("name", "")},
+						Results:    definition.DataErrorResults(""),
+					},
+				},
+			},
+		},
+	}
+	if err := Validate(service.FirstContextParameter(), valid); err != nil {
+		t.Fatalf("Descriptor should be valid: %v", err)
+	}
+
+	invalid := definition.Descriptor{
+		Path:     "/api/v1",
+		Consumes: []string{definition.MIMENone},
+		Produces: []string{definition.MIMEJSON},
+		Children: []definition.Descriptor{
+			{
+				Path: "/params",
+				Definitions: []definition.Definition{
+					{
+						Method:   definition.Get,
+						Function: get,
+						Results:  definition.DataErrorResults(""),
+					},
+				},
+			},
+			{
+				Path: "/prefabs",
+				Definitions: []definition.Definition{
+					{
+						Method:     definition.Get,
+						Function:   get,
+						Parameters: []definition.Parameter{definition.PrefabParameterFor("unknown", "")},
+						Results:    definition.DataErrorResults(""),
+					},
+				},
+			},
+			{
+				Path: "/operators",
+				Definitions: []definition.Definition{
+					{
+						Method:   definition.Get,
+						Function: get,
+						Parameters: []definition.Parameter{
+							definition.QueryParameterFor("name", "", definition.OperatorFunc("int", func(ctx context.Context, field string, value int) (int, error) {
+								return value, nil
+							})),
+						},
+						Results: definition.DataErrorResults(""),
+					},
+				},
+			},
+			{
+				Path: "/duplicates",
+				Definitions: []definition.Definition{
+					{
+						Method:     definition.Get,
+						Function:   get,
+						Parameters: []definition.Parameter{definition.QueryParameterFor("name", "")},
+						Results:    definition.DataErrorResults(""),
+					},
+					{
+						Method:     definition.Get,
+						Function:   get,
+						Parameters: []definition.Parameter{definition.QueryParameterFor("name", "")},
+						Results:    definition.DataErrorResults(""),
+					},
+				},
+			},
+		},
+	}
+	err := Validate(service.FirstContextParameter(), valid, invalid)
+	errs, ok := err.(DescriptorErrors)
+	if !ok {
+		t.Fatalf("Validate should return DescriptorErrors, but got: %v", err)
+	}
+	paths := []string{"/api/v1/duplicates", "/api/v1/operators", "/api/v1/params", "/api/v1/prefabs"}
+	if len(errs) != len(paths) {
+		t.Fatalf("All invalid definitions should be reported: %v", err)
+	}
+	for i, e := range errs {
+		if e.Path != paths[i] || e.Method != definition.Get {
+			t.Fatalf("Error %d should be for Get %s, but got: %v", i, paths[i], e)
+		}
+	}
+
+	// Invalid paths are reported without methods. Errors are sorted by paths.
+	broken := definition.Descriptor{
+		Path:        "/api/v1/{name",
+		Consumes:    []string{definition.MIMENone},
+		Produces:    []string{definition.MIMEJSON},
+		Definitions: valid.Children[0].Definitions,
+	}
+	err = Validate(service.FirstContextParameter(), broken, invalid)
+	if errs, ok := err.(DescriptorErrors); !ok || len(errs) != len(paths)+1 || errs[len(paths)].Path != broken.Path || errs[len(paths)].Method != "" {
+		t.Fatalf("Invalid path should be reported: %v", err)
+	}
+
+	// Build should fail if Validate fails.
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(invalid); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := builder.Build(); err == nil {
+		t.Fatal("Invalid descriptor should not be built")
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"net/http"
 	"reflect"
 	"sort"
+	"strings"
 
 	"github.com/caicloud/nirvana/definition"
 	"github.com/caicloud/nirvana/log"
@@ -29,27 +30,93 @@ import (
 )
 
 type binding struct {
+	path        string
 	middlewares []definition.Middleware
 	definition  definition.Definition
 	executor    executor.MiddlewareExecutor
 }
 
+// pathMiddlewares contains middlewares for actions under a path.
+type pathMiddlewares struct {
+	path        string
+	middlewares []definition.Middleware
+}
+
+// DefaultVersionHeader is the default header to select action version.
+const DefaultVersionHeader = "X-RPC-Version"
+
+// VersionedBuilder is a service builder which routes requests by action versions.
+// The version of a request is from the "Version" query, then the version header.
+// If both are absent, the default version is used.
+type VersionedBuilder interface {
+	service.Builder
+	// SetVersionHeader sets the header to select action version. An empty key
+	// disables selecting version by header.
+	SetVersionHeader(key string)
+	// SetDefaultVersion sets the version for requests without a version.
+	SetDefaultVersion(version string)
+	// Versions returns available versions of all actions. The key is formatted
+	// as "path?Action=name", and versions are sorted.
+	Versions() map[string][]string
+}
+
 type builder struct {
 	// bindings contains all RPC action definitions, the key is a unique id (path + version + name),
 	// it is currently formatted as an API URL path, eg: /?Version=2020-10-10&Action=Echo, which is useful for both
 	// printing logs and generating API documents/client
 	bindings map[string]*binding
-	modifier service.DefinitionModifier
-	filters  []service.Filter
-	logger   log.Logger
+	// middlewares contains middlewares of descriptors without actions. They are
+	// added to actions under their paths when the service is built.
+	middlewares []pathMiddlewares
+	// versions contains versions of actions. The key is generated by genActionKey.
+	versions       map[string][]string
+	versionHeader  string
+	defaultVersion string
+	modifier       service.DefinitionModifier
+	filters        []service.Filter
+	logger         log.Logger
+	cors           *service.CORSOptions
+	// interceptors run around functions of all actions.
+	interceptors []service.Interceptor
 }
 
 // NewBuilder creates a service builder.
 func NewBuilder() service.Builder {
 	return &builder{
-		bindings: make(map[string]*binding),
-		logger:   &log.SilentLogger{},
+		bindings:      make(map[string]*binding),
+		versions:      make(map[string][]string),
+		versionHeader: DefaultVersionHeader,
+		logger:        &log.SilentLogger{},
+	}
+}
+
+// SetVersionHeader sets the header to select action version.
+func (b *builder) SetVersionHeader(key string) {
+	b.versionHeader = key
+}
+
+// SetDefaultVersion sets the version for requests without a version.
+func (b *builder) SetDefaultVersion(version string) {
+	b.defaultVersion = version
+}
+
+// SetCORS sets options of cross-origin resource sharing.
+func (b *builder) SetCORS(options *service.CORSOptions) {
+	b.cors = options
+}
+
+// AddInterceptor adds interceptors which run around functions of all actions.
+func (b *builder) AddInterceptor(interceptors ...service.Interceptor) {
+	b.interceptors = append(b.interceptors, interceptors...)
+}
+
+// Versions returns available versions of all actions.
+func (b *builder) Versions() map[string][]string {
+	result := make(map[string][]string, len(b.versions))
+	for key, versions := range b.versions {
+		result[key] = append([]string(nil), versions...)
 	}
+	return result
 }
 
 // Filters returns all request filters.
@@ -95,6 +162,17 @@ func genRPCPath(path, version, action string) string {
 	return fmt.Sprintf("%s?Version=%s&Action=%s", path, version, action)
 }
 
+// underPath checks whether path is prefix or under prefix. Paths are matched by
+// whole segments, so "/administrators" is not under "/admin".
+func underPath(path, prefix string) bool {
+	prefix = strings.TrimRight(prefix, "/")
+	return prefix == "" || path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+func genActionKey(path, action string) string {
+	return fmt.Sprintf("%s?Action=%s", path, action)
+}
+
 // AddDescriptor adds descriptors to router.
 func (b *builder) AddDescriptor(descriptors ...interface{}) error {
 	for _, obj := range descriptors {
@@ -106,15 +184,23 @@ func (b *builder) AddDescriptor(descriptors ...interface{}) error {
 		if path == "" {
 			path = "/"
 		}
+		if len(descriptor.Actions) <= 0 && len(descriptor.Middlewares) > 0 {
+			b.middlewares = append(b.middlewares, pathMiddlewares{path, descriptor.Middlewares})
+			continue
+		}
 		for _, action := range descriptor.Actions {
 			rpcPath := genRPCPath(path, action.Version, action.Name)
 			if _, ok := b.bindings[rpcPath]; ok {
 				return fmt.Errorf("duplicated rpc path: %s", rpcPath)
 			}
 			b.bindings[rpcPath] = &binding{
+				path:        path,
 				middlewares: descriptor.Middlewares,
 				definition:  b.genDefinition(action, descriptor.Consumes, descriptor.Produces, descriptor.Tags),
 			}
+			key := genActionKey(path, action.Name)
+			b.versions[key] = append(b.versions[key], action.Version)
+			sort.Strings(b.versions[key])
 		}
 	}
 	return nil
@@ -136,17 +222,26 @@ func (b *builder) genDefinition(action definition.RPCAction, consumes []string,
 	}
 
 	return definition.Definition{
-		Method:        definition.Create,
-		Consumes:      consumes,
-		Produces:      produces,
-		Tags:          tags,
-		ErrorProduces: errorProduces,
-		Function:      action.Function,
-		Parameters:    action.Parameters,
-		Results:       action.Results,
-		Summary:       action.Name,
-		Description:   action.Description,
-		Examples:      action.Examples,
+		Method:             definition.Create,
+		Consumes:           consumes,
+		Produces:           produces,
+		Tags:               tags,
+		ErrorProduces:      errorProduces,
+		Function:           action.Function,
+		Parameters:         action.Parameters,
+		Results:            action.Results,
+		Summary:            action.Name,
+		Description:        action.Description,
+		Examples:           action.Examples,
+		Timeout:            action.Timeout,
+		MaxBodySize:        action.MaxBodySize,
+		AccumulateErrors:   action.AccumulateErrors,
+		ResponseOperators:  action.ResponseOperators,
+		Deprecated:         action.Deprecated,
+		DeprecationMessage: action.DeprecationMessage,
+		PartialResponse:    action.PartialResponse,
+		Security:           action.Security,
+		Middlewares:        action.Middlewares,
 	}
 }
 
@@ -175,6 +270,11 @@ func (b *builder) Build() (service.Service, error) {
 	if len(b.bindings) <= 0 {
 		return nil, fmt.Errorf("no router")
 	}
+	if b.cors != nil {
+		if err := b.cors.Validate(); err != nil {
+			return nil, err
+		}
+	}
 
 	paths := make([]string, 0, len(b.bindings))
 	for path := range b.bindings {
@@ -183,32 +283,52 @@ func (b *builder) Build() (service.Service, error) {
 	sort.Strings(paths)
 
 	var err error
+	// Bindings are copied, so building again doesn't apply modifier and path
+	// middlewares to them twice.
+	executors := make(map[string]*binding, len(b.bindings))
 	for _, path := range paths {
-		bd := b.bindings[path]
+		bd := new(binding)
+		*bd = *b.bindings[path]
+		executors[path] = bd
 		b.logger.V(log.LevelDebug).Infof("Path: %s, Consumes: %v, Produces: %v", path, bd.definition.Consumes, bd.definition.Produces)
 		if b.modifier != nil {
 			b.modifier(&bd.definition)
 		}
-		bd.executor, err = executor.DefinitionToExecutor(path, bd.definition, http.StatusOK)
+		bd.executor, err = executor.DefinitionToExecutor(path, bd.definition, http.StatusOK, b.interceptors...)
 		if err != nil {
 			return nil, err
 		}
+		var middlewares []definition.Middleware
+		for _, pm := range b.middlewares {
+			if underPath(bd.path, pm.path) {
+				middlewares = append(middlewares, pm.middlewares...)
+			}
+		}
+		bd.middlewares = append(middlewares, bd.middlewares...)
 	}
 
 	s := &server{
-		executors: b.bindings,
-		filters:   b.filters,
-		logger:    b.logger,
-		producers: service.AllProducers(),
+		executors:      executors,
+		versions:       b.Versions(),
+		versionHeader:  b.versionHeader,
+		defaultVersion: b.defaultVersion,
+		filters:        b.filters,
+		logger:         b.logger,
+		producers:      service.AllProducers(),
+		cors:           b.cors,
 	}
 	return s, nil
 }
 
 type server struct {
-	executors map[string]*binding
-	filters   []service.Filter
-	logger    log.Logger
-	producers []service.Producer
+	executors      map[string]*binding
+	versions       map[string][]string
+	versionHeader  string
+	defaultVersion string
+	filters        []service.Filter
+	logger         log.Logger
+	producers      []service.Producer
+	cors           *service.CORSOptions
 }
 
 func (s *server) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
@@ -218,13 +338,38 @@ func (s *server) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 		}
 	}
 	ctx := service.NewHTTPContext(resp, req)
+	defer func() {
+		if r := recover(); r != nil {
+			service.Recover(ctx, s.logger, s.producers, r)
+		}
+	}()
 
 	action := req.URL.Query().Get("Action")
 	version := req.URL.Query().Get("Version")
+	if version == "" && s.versionHeader != "" {
+		version = req.Header.Get(s.versionHeader)
+	}
+	if version == "" {
+		version = s.defaultVersion
+	}
 	path := genRPCPath(req.URL.Path, version, action)
 	e, ok := s.executors[path]
+	if s.cors != nil {
+		// Preflight requests don't carry custom headers, so the version header is
+		// absent. They're answered for actions of any version, like ServeHTTP finds
+		// actions by genActionKey.
+		if (ok || len(s.versions[genActionKey(req.URL.Path, action)]) > 0) && s.cors.IsPreflight(req) {
+			s.cors.HandlePreflight(ctx.ResponseWriter(), req)
+			return
+		}
+		s.cors.SetHeaders(ctx.ResponseWriter().Header(), req)
+	}
 	if !ok {
-		if err := service.WriteError(ctx, s.producers, noExecutorForAction.Error(path)); err != nil {
+		err := noExecutorForAction.Error(path)
+		if versions := s.versions[genActionKey(req.URL.Path, action)]; len(versions) > 0 {
+			err = noVersionForAction.Error(version, action, strings.Join(versions, ", "))
+		}
+		if err := service.WriteError(ctx, s.producers, err); err != nil {
 			s.logger.Error(err)
 		}
 		return
@@ -330,6 +330,175 @@ func TestDuplicatedRPCPath(t *testing.T) {
 	}
 }
 
+func TestVersionRouting(t *testing.T) {
+	newAction := func(version string) definition.RPCAction {
+		return definition.RPCAction{
+			Name:     "GetVersion",
+			Version:  version,
+			Consumes: []string{definition.MIMENone},
+			Produces: []string{definition.MIMEText},
+			Function: func(ctx context.Context) (string, error) {
+				return version, nil
+			},
+			Results: definition.DataErrorResults(""),
+		}
+	}
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	vb, ok := builder.(VersionedBuilder)
+	if !ok {
+		t.Fatal("RPC builder should be a VersionedBuilder")
+	}
+	vb.SetDefaultVersion("2020-01-01")
+	if err := builder.AddDescriptor(definition.RPCDescriptor{
+		Path:    "/",
+		Actions: []definition.RPCAction{newAction("2020-10-10"), newAction("2020-01-01")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	versions := vb.Versions()["/?Action=GetVersion"]
+	if !reflect.DeepEqual(versions, []string{"2020-01-01", "2020-10-10"}) {
+		t.Fatalf("Versions are not desired: %v", versions)
+	}
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		query  string
+		header string
+		code   int
+		body   string
+	}{
+		{"?Action=GetVersion&Version=2020-10-10", "", 200, "2020-10-10"},
+		{"?Action=GetVersion", "2020-10-10", 200, "2020-10-10"},
+		{"?Action=GetVersion", "", 200, "2020-01-01"},
+		{"?Action=GetVersion", "2019-01-01", 404, "2020-01-01, 2020-10-10"},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/"+test.query, nil)
+		if test.header != "" {
+			req.Header.Set(DefaultVersionHeader, test.header)
+		}
+		resp := newRW()
+		s.ServeHTTP(resp, req)
+		if resp.code != test.code {
+			t.Fatalf("Response code of %s (%s) should be %d, but got: %d", test.query, test.header, test.code, resp.code)
+		}
+		if !strings.Contains(resp.buf.String(), test.body) {
+			t.Fatalf("Response body of %s (%s) is not desired: %s", test.query, test.header, resp.buf.String())
+		}
+	}
+}
+
+func TestPathMiddlewares(t *testing.T) {
+	newMiddleware := func(name string) definition.Middleware {
+		return func(ctx context.Context, chain definition.Chain) error {
+			service.HTTPContextFrom(ctx).ResponseWriter().Header().Add("X-Middlewares", name)
+			return chain.Continue(ctx)
+		}
+	}
+	newDescriptor := func(path string, middlewares ...definition.Middleware) definition.RPCDescriptor {
+		return definition.RPCDescriptor{
+			Path:        path,
+			Middlewares: middlewares,
+			Actions: []definition.RPCAction{
+				{
+					Name:     "Ping",
+					Consumes: []string{definition.MIMENone},
+					Produces: []string{definition.MIMEText},
+					Function: func(ctx context.Context) (string, error) {
+						return "pong", nil
+					},
+					Results: definition.DataErrorResults(""),
+				},
+			},
+		}
+	}
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(
+		newDescriptor("/", newMiddleware("root")),
+		newDescriptor("/admin", newMiddleware("admin")),
+		newDescriptor("/admin/users"),
+		newDescriptor("/administrators"),
+		definition.RPCDescriptor{Middlewares: []definition.Middleware{newMiddleware("all")}},
+		definition.RPCDescriptor{Path: "/admin", Middlewares: []definition.Middleware{newMiddleware("admins")}},
+	); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string][]string{
+		"/?Action=Ping":      {"all", "root"},
+		"/admin?Action=Ping": {"all", "admins", "admin"},
+		// Path middlewares apply to whole segments.
+		"/admin/users?Action=Ping":    {"all", "admins"},
+		"/administrators?Action=Ping": {"all"},
+	}
+	// Building again doesn't apply path middlewares twice.
+	for i := 0; i < 2; i++ {
+		s, err := builder.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for url, want := range tests {
+			req, _ := http.NewRequest("GET", url, nil)
+			resp := newRW()
+			s.ServeHTTP(resp, req)
+			if resp.code != http.StatusOK || !reflect.DeepEqual(resp.header["X-Middlewares"], want) {
+				t.Fatalf("Middlewares of %s in build %d are not desired: %d %v", url, i, resp.code, resp.header)
+			}
+		}
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	builder := NewBuilder()
+	builder.SetModifier(service.FirstContextParameter())
+	if err := builder.AddDescriptor(definition.RPCDescriptor{
+		Path: "/",
+		Actions: []definition.RPCAction{
+			{
+				Name:     "Ping",
+				Version:  "2020-10-10",
+				Consumes: []string{definition.MIMENone},
+				Produces: []string{definition.MIMEText},
+				Function: func(ctx context.Context) (string, error) {
+					return "pong", nil
+				},
+				Results: definition.DataErrorResults(""),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	cb := builder.(service.CORSBuilder)
+	cb.SetCORS(&service.CORSOptions{AllowCredentials: true})
+	if _, err := builder.Build(); err == nil {
+		t.Fatal("Credentials should not be allowed for all origins")
+	}
+	cb.SetCORS(&service.CORSOptions{AllowedOrigins: []string{"https://example.com"}, AllowCredentials: true})
+	s, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The version is in a header of the actual request, which preflight requests
+	// don't carry.
+	for url, code := range map[string]int{"/?Action=Ping": http.StatusNoContent, "/?Action=Pong": http.StatusMethodNotAllowed} {
+		req, _ := http.NewRequest(http.MethodOptions, url, nil)
+		req.Header.Set("Origin", "https://example.com")
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		req.Header.Set("Access-Control-Request-Headers", DefaultVersionHeader)
+		resp := newRW()
+		s.ServeHTTP(resp, req)
+		if resp.code != code {
+			t.Fatalf("Response code of preflight request %s should be %d, but got: %d %s", url, code, resp.code, resp.buf.String())
+		}
+		if code == http.StatusNoContent && resp.header.Get("Access-Control-Allow-Origin") != "https://example.com" {
+			t.Fatalf("Preflight headers are not desired: %v", resp.header)
+		}
+	}
+}
+
 func BenchmarkServer(b *testing.B) {
 	u, _ := url.Parse("/?Action=GetEcho&Version=2020-01-01&name=alice")
 
@@ -20,4 +20,7 @@ import (
 	"github.com/caicloud/nirvana/errors"
 )
 
-var noExecutorForAction = errors.MethodNotAllowed.Build("Nirvana:Service:NoExecutorForAction", "no executor for action ${path}")
+var (
+	noExecutorForAction = errors.MethodNotAllowed.Build("Nirvana:Service:NoExecutorForAction", "no executor for action ${path}")
+	noVersionForAction  = errors.NotFound.Build("Nirvana:Service:NoVersionForAction", "version '${version}' doesn't exist for action ${action}, available versions: ${versions}")
+)
@@ -0,0 +1,187 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/caicloud/nirvana/definition"
+)
+
+// Authenticator verifies a credential of a request for a security scheme. The
+// credential is the part after the scheme in header "Authorization" (such as the
+// token of "Bearer <token>") for HTTPAuthentication, or the API key for
+// APIKeyAuthentication. scopes are scopes required by the definition.
+//
+// It returns an error if the credential is invalid, such as an unauthorized
+// error (401) or a forbidden error (403) for missing scopes. Authenticators run
+// before parameters are generated, so they can provide values for parameters by
+// SetAutoValue, such as the user of a token.
+type Authenticator func(ctx context.Context, credential string, scopes []string) error
+
+type securityScheme struct {
+	scheme        definition.SecurityScheme
+	authenticator Authenticator
+}
+
+var securitySchemes = map[string]*securityScheme{}
+
+// RegisterSecurityScheme registers a security scheme and its authenticator.
+// Schemes must be registered before services are built. A scheme with the same
+// name is replaced.
+func RegisterSecurityScheme(scheme definition.SecurityScheme, authenticator Authenticator) error {
+	if scheme.Name == "" {
+		return invalidSecurityScheme.Error(scheme.Name, "no name")
+	}
+	if authenticator == nil {
+		return invalidSecurityScheme.Error(scheme.Name, "no authenticator")
+	}
+	switch scheme.Type {
+	case definition.HTTPAuthentication:
+		if scheme.Scheme == "" {
+			return invalidSecurityScheme.Error(scheme.Name, "no authorization scheme")
+		}
+	case definition.APIKeyAuthentication:
+		if scheme.In != definition.Header && scheme.In != definition.Query && scheme.In != definition.Cookie {
+			return invalidSecurityScheme.Error(scheme.Name, "API keys must be in header, query or cookie")
+		}
+		if scheme.Key == "" {
+			return invalidSecurityScheme.Error(scheme.Name, "no name of API keys")
+		}
+	default:
+		return invalidSecurityScheme.Error(scheme.Name, "unknown type "+string(scheme.Type))
+	}
+	securitySchemes[scheme.Name] = &securityScheme{scheme, authenticator}
+	return nil
+}
+
+// SecuritySchemeFor gets a registered security scheme by name.
+func SecuritySchemeFor(name string) (definition.SecurityScheme, bool) {
+	s, ok := securitySchemes[name]
+	if !ok {
+		return definition.SecurityScheme{}, false
+	}
+	return s.scheme, true
+}
+
+// SecuritySchemes returns all registered security schemes. They're sorted by name.
+func SecuritySchemes() []definition.SecurityScheme {
+	schemes := make([]definition.SecurityScheme, 0, len(securitySchemes))
+	for _, s := range securitySchemes {
+		schemes = append(schemes, s.scheme)
+	}
+	sort.Slice(schemes, func(i, j int) bool {
+		return schemes[i].Name < schemes[j].Name
+	})
+	return schemes
+}
+
+// Authenticate checks whether the request in ctx passes one of requirements. If
+// the request has no credential of any requirement, it returns an unauthorized
+// error (401). Otherwise it returns the error of the first authenticator which
+// rejects the request. Header "WWW-Authenticate" of response is set for schemes
+// of HTTPAuthentication if the request fails. It returns nil if there is no
+// requirement.
+func Authenticate(ctx context.Context, requirements []definition.SecurityRequirement) error {
+	if len(requirements) <= 0 {
+		return nil
+	}
+	httpCtx := HTTPContextFrom(ctx)
+	if httpCtx == nil {
+		return NoContext.Error()
+	}
+	if c, ok := httpCtx.(*HTTPCtx); ok {
+		c.secured = true
+	}
+	req := httpCtx.Request()
+	var failure error
+	var challenges []string
+	for _, r := range requirements {
+		s, ok := securitySchemes[r.Scheme]
+		if !ok {
+			return noSecurityScheme.Error(r.Scheme)
+		}
+		if s.scheme.Type == definition.HTTPAuthentication {
+			challenges = append(challenges, challenge(s.scheme.Scheme))
+		}
+		credential, ok := credentialOf(req, &s.scheme)
+		if !ok {
+			continue
+		}
+		err := s.authenticator(ctx, credential, r.Scopes)
+		if err == nil {
+			return nil
+		}
+		if failure == nil {
+			failure = err
+		}
+	}
+	if failure == nil {
+		names := make([]string, len(requirements))
+		for i, r := range requirements {
+			names[i] = r.Scheme
+		}
+		failure = missingCredential.Error(strings.Join(names, ", "))
+	}
+	headers := httpCtx.ResponseWriter().Header()
+	for _, c := range challenges {
+		headers.Add("WWW-Authenticate", c)
+	}
+	return failure
+}
+
+// Secured checks whether the request in ctx has been authenticated for security
+// requirements of a definition. Middlewares which share responses between
+// requests, such as caches, should not share responses of secured requests.
+func Secured(ctx context.Context) bool {
+	c, ok := ctx.Value(contextKeyUnderlyingHTTPContext).(*HTTPCtx)
+	return ok && c.secured
+}
+
+// credentialOf gets the credential of a scheme from request.
+func credentialOf(req *http.Request, scheme *definition.SecurityScheme) (string, bool) {
+	var credential string
+	switch scheme.Type {
+	case definition.HTTPAuthentication:
+		authorization := strings.TrimSpace(req.Header.Get("Authorization"))
+		index := strings.IndexByte(authorization, ' ')
+		if index < 0 || !strings.EqualFold(authorization[:index], scheme.Scheme) {
+			return "", false
+		}
+		credential = strings.TrimSpace(authorization[index+1:])
+	case definition.APIKeyAuthentication:
+		switch scheme.In {
+		case definition.Header:
+			credential = req.Header.Get(scheme.Key)
+		case definition.Query:
+			credential = req.URL.Query().Get(scheme.Key)
+		case definition.Cookie:
+			if cookie, err := req.Cookie(scheme.Key); err == nil {
+				credential = cookie.Value
+			}
+		}
+	}
+	return credential, credential != ""
+}
+
+// challenge returns the challenge of an authorization scheme, such as "Bearer".
+func challenge(scheme string) string {
+	return strings.ToUpper(scheme[:1]) + strings.ToLower(scheme[1:])
+}
@@ -17,8 +17,10 @@ limitations under the License.
 package service
 
 import (
+	"bytes"
 	"context"
 	"io"
+	"io/ioutil"
 	"mime/multipart"
 	"reflect"
 	"strings"
@@ -37,14 +39,16 @@ type ParameterGenerator interface {
 }
 
 var generators = map[definition.Source]ParameterGenerator{
-	definition.Path:   &PathParameterGenerator{},
-	definition.Query:  &QueryParameterGenerator{},
-	definition.Header: &HeaderParameterGenerator{},
-	definition.Form:   &FormParameterGenerator{},
-	definition.File:   &FileParameterGenerator{},
-	definition.Body:   &BodyParameterGenerator{},
-	definition.Auto:   &AutoParameterGenerator{},
-	definition.Prefab: &PrefabParameterGenerator{},
+	definition.Path:    &PathParameterGenerator{},
+	definition.Query:   &QueryParameterGenerator{},
+	definition.Header:  &HeaderParameterGenerator{},
+	definition.Cookie:  &CookieParameterGenerator{},
+	definition.Form:    &FormParameterGenerator{},
+	definition.File:    &FileParameterGenerator{},
+	definition.Body:    &BodyParameterGenerator{},
+	definition.RawBody: &RawBodyParameterGenerator{},
+	definition.Auto:    &AutoParameterGenerator{},
+	definition.Prefab:  &PrefabParameterGenerator{},
 }
 
 // ParameterGeneratorFor gets a parameter generator for specified source.
@@ -58,6 +62,26 @@ func RegisterParameterGenerator(generator ParameterGenerator) error {
 	return nil
 }
 
+var rawValues = map[definition.Source]func(vc ValueContainer, name string) ([]string, bool){
+	definition.Path: func(vc ValueContainer, name string) ([]string, bool) {
+		data, ok := vc.Path(name)
+		if !ok || len(data) <= 0 {
+			return nil, false
+		}
+		return []string{data}, true
+	},
+	definition.Query:  ValueContainer.Query,
+	definition.Header: ValueContainer.Header,
+	definition.Cookie: cookieValues,
+	definition.Form:   ValueContainer.Form,
+}
+
+// RawValuesFor returns a function which gets raw values of a parameter from value
+// container. It returns nil if the source doesn't provide raw values.
+func RawValuesFor(source definition.Source) func(vc ValueContainer, name string) ([]string, bool) {
+	return rawValues[source]
+}
+
 func assignable(defaultValue interface{}, target reflect.Type) error {
 	if defaultValue == nil {
 		return nil
@@ -118,6 +142,13 @@ func (g *QueryParameterGenerator) Source() definition.Source { return definition
 
 // Validate validates whether defaultValue and target type is valid.
 func (g *QueryParameterGenerator) Validate(name string, defaultValue interface{}, target reflect.Type) error {
+	if isQueryMap(target) {
+		// Name is the prefix of query parameters, so it can be empty.
+		if err := assignable(defaultValue, target); err != nil {
+			return err
+		}
+		return convertible(target.Elem())
+	}
 	if name == "" {
 		return noName.Error(g.Source())
 	}
@@ -130,9 +161,16 @@ func (g *QueryParameterGenerator) Validate(name string, defaultValue interface{}
 	return nil
 }
 
-// Generate generates an object by data from value container.
+// Generate generates an object by data from value container. If the target type
+// is a map with string keys, name is a prefix and all query parameters whose names
+// start with the prefix are collected into the map, keyed by the rest of their
+// names. Values are converted to the element type of the map. The map is absent
+// if no query parameter matches.
 func (g *QueryParameterGenerator) Generate(ctx context.Context, vc ValueContainer, consumers []Consumer,
 	name string, target reflect.Type) (interface{}, error) {
+	if isQueryMap(target) {
+		return g.generateMap(ctx, vc, name, target)
+	}
 	data, ok := vc.Query(name)
 	if !ok || len(data) <= 0 {
 		return nil, nil
@@ -143,6 +181,43 @@ func (g *QueryParameterGenerator) Generate(ctx context.Context, vc ValueContaine
 	return nil, nil
 }
 
+// generateMap collects query parameters with prefix into a map of type target.
+func (g *QueryParameterGenerator) generateMap(ctx context.Context, vc ValueContainer, prefix string, target reflect.Type) (interface{}, error) {
+	qc, ok := vc.(QueryPrefixContainer)
+	if !ok {
+		return nil, nil
+	}
+	queries := qc.QueryPrefix(prefix)
+	if len(queries) <= 0 {
+		return nil, nil
+	}
+	converter := ConverterFor(target.Elem())
+	result := reflect.MakeMapWithSize(target, len(queries))
+	for key, data := range queries {
+		value, err := converter(ctx, data)
+		if err != nil {
+			return nil, err
+		}
+		result.SetMapIndex(reflect.ValueOf(key).Convert(target.Key()), reflect.ValueOf(value))
+	}
+	return result.Interface(), nil
+}
+
+// isQueryMap checks whether target is a map bound from query parameters with a
+// prefix.
+func isQueryMap(target reflect.Type) bool {
+	return target.Kind() == reflect.Map && target.Key().Kind() == reflect.String
+}
+
+// QueryPrefixContainer is an optional interface for value containers which can
+// return query parameters by the prefix of names.
+type QueryPrefixContainer interface {
+	// QueryPrefix returns non-empty values of query parameters whose names start
+	// with prefix, keyed by the rest of their names. Parameters named prefix are
+	// excluded.
+	QueryPrefix(prefix string) map[string][]string
+}
+
 // HeaderParameterGenerator is used to generate object by value from request header.
 type HeaderParameterGenerator struct{}
 
@@ -176,6 +251,55 @@ func (g *HeaderParameterGenerator) Generate(ctx context.Context, vc ValueContain
 	return nil, nil
 }
 
+// CookieContainer is an optional interface for value containers which can return
+// cookies of the request.
+type CookieContainer interface {
+	// Cookie returns values of cookies with the name.
+	Cookie(name string) ([]string, bool)
+}
+
+// cookieValues returns values of cookies with the name if vc is a CookieContainer.
+func cookieValues(vc ValueContainer, name string) ([]string, bool) {
+	cc, ok := vc.(CookieContainer)
+	if !ok {
+		return nil, false
+	}
+	return cc.Cookie(name)
+}
+
+// CookieParameterGenerator is used to generate object by value from request cookies.
+type CookieParameterGenerator struct{}
+
+// Source returns the source generated by current generator.
+func (g *CookieParameterGenerator) Source() definition.Source { return definition.Cookie }
+
+// Validate validates whether defaultValue and target type is valid.
+func (g *CookieParameterGenerator) Validate(name string, defaultValue interface{}, target reflect.Type) error {
+	if name == "" {
+		return noName.Error(g.Source())
+	}
+	if err := assignable(defaultValue, target); err != nil {
+		return err
+	}
+	if err := convertible(target); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Generate generates an object by data from value container.
+func (g *CookieParameterGenerator) Generate(ctx context.Context, vc ValueContainer, consumers []Consumer,
+	name string, target reflect.Type) (interface{}, error) {
+	data, ok := cookieValues(vc, name)
+	if !ok || len(data) <= 0 {
+		return nil, nil
+	}
+	if converter := ConverterFor(target); converter != nil {
+		return converter(ctx, data)
+	}
+	return nil, nil
+}
+
 // FormParameterGenerator is used to generate object by value from request form.
 type FormParameterGenerator struct{}
 
@@ -227,7 +351,20 @@ func (c *repeatableCloserForFile) Close() error {
 	return nil
 }
 
+// FileHeadersContainer is an optional interface for value containers which can
+// return all files of a form field.
+type FileHeadersContainer interface {
+	// FileHeaders returns headers of all files of a form field when "Content-Type"
+	// is "multipart/form-data". It returns an error if the form can't be parsed,
+	// and no files if the request has no form.
+	FileHeaders(key string) ([]*multipart.FileHeader, error)
+}
+
+var fileHeadersType = reflect.TypeOf([]*multipart.FileHeader{})
+
 // FileParameterGenerator is used to generate file reader by value from request form file.
+// If the target type is []*multipart.FileHeader, all files of the form field are
+// generated. Zero files generate a nil slice.
 type FileParameterGenerator struct {
 }
 
@@ -243,6 +380,9 @@ func (g *FileParameterGenerator) Validate(name string, defaultValue interface{},
 	if err != nil {
 		return err
 	}
+	if target == fileHeadersType {
+		return nil
+	}
 	if !reflect.TypeOf((*multipart.File)(nil)).Elem().AssignableTo(target) {
 		return unassignableType.Error("multipart.File", target)
 	}
@@ -252,6 +392,17 @@ func (g *FileParameterGenerator) Validate(name string, defaultValue interface{},
 // Generate generates an object by data from value container.
 func (g *FileParameterGenerator) Generate(ctx context.Context, vc ValueContainer, consumers []Consumer,
 	name string, target reflect.Type) (interface{}, error) {
+	if target == fileHeadersType {
+		fc, ok := vc.(FileHeadersContainer)
+		if !ok {
+			return nil, nil
+		}
+		headers, err := fc.FileHeaders(name)
+		if err != nil || len(headers) == 0 {
+			return nil, err
+		}
+		return headers, nil
+	}
 	file, ok := vc.File(name)
 	if !ok {
 		return nil, nil
@@ -278,6 +429,7 @@ func (g *BodyParameterGenerator) Validate(name string, defaultValue interface{},
 	case kind == reflect.Struct:
 	case kind == reflect.Ptr && target.Elem().Kind() == reflect.Struct:
 	case kind == reflect.Interface && reflect.TypeOf((*io.ReadCloser)(nil)).Elem().AssignableTo(target):
+	case kind == reflect.Chan && target.ChanDir() == reflect.RecvDir:
 	default:
 		return invalidBodyType.Error(target)
 	}
@@ -306,20 +458,22 @@ func (c *repeatableCloserForBody) Close() error {
 func (g *BodyParameterGenerator) Generate(ctx context.Context, vc ValueContainer, consumers []Consumer,
 	name string, target reflect.Type) (interface{}, error) {
 	reader, contentType, ok := vc.Body()
+	kind := target.Kind()
+	if kind == reflect.Chan {
+		// Values are decoded into the channel one by one. A nil channel blocks
+		// receivers forever, so a closed channel is returned for absent bodies.
+		if !ok {
+			reader = nil
+		}
+		return streamBody(ctx, reader, contentType, consumerFor(consumers, contentType), target)
+	}
 	if !ok {
 		return nil, nil
 	}
-	kind := target.Kind()
 	if kind == reflect.Interface && reflect.TypeOf((*io.ReadCloser)(nil)).Elem().AssignableTo(target) {
 		return &repeatableCloserForBody{reader, false}, nil
 	}
-	var consumer Consumer
-	for _, c := range consumers {
-		if c.ContentType() == contentType {
-			consumer = c
-			break
-		}
-	}
+	consumer := consumerFor(consumers, contentType)
 	if consumer == nil {
 		return nil, nil
 	}
@@ -344,6 +498,63 @@ func (g *BodyParameterGenerator) Generate(ctx context.Context, vc ValueContainer
 	return value.Interface(), nil
 }
 
+// consumerFor returns the consumer of contentType in consumers.
+func consumerFor(consumers []Consumer, contentType string) Consumer {
+	for _, c := range consumers {
+		if c.ContentType() == contentType {
+			return c
+		}
+	}
+	return nil
+}
+
+// RawBodyParameterGenerator is used to generate bytes, string or reader from
+// unparsed request body.
+type RawBodyParameterGenerator struct{}
+
+// Source returns the source generated by current generator.
+func (g *RawBodyParameterGenerator) Source() definition.Source { return definition.RawBody }
+
+// Validate validates whether defaultValue and target type is valid.
+func (g *RawBodyParameterGenerator) Validate(name string, defaultValue interface{}, target reflect.Type) error {
+	err := assignable(defaultValue, target)
+	if err != nil {
+		return err
+	}
+	kind := target.Kind()
+	switch {
+	case kind == reflect.String:
+	case kind == reflect.Slice && target.Elem().Kind() == reflect.Uint8:
+	case kind == reflect.Interface && reflect.TypeOf((*io.ReadCloser)(nil)).Elem().AssignableTo(target):
+	default:
+		return invalidRawBodyType.Error(target)
+	}
+	return nil
+}
+
+// Generate generates an object by data from value container. An empty body
+// generates nothing.
+func (g *RawBodyParameterGenerator) Generate(ctx context.Context, vc ValueContainer, consumers []Consumer,
+	name string, target reflect.Type) (interface{}, error) {
+	var data []byte
+	var err error
+	if c, ok := vc.(*container); ok {
+		data, err = c.rawBody()
+	} else if reader, _, _ := vc.Body(); reader != nil {
+		data, err = ioutil.ReadAll(reader)
+	}
+	if err != nil || len(data) <= 0 {
+		return nil, err
+	}
+	switch target.Kind() {
+	case reflect.String:
+		return reflect.ValueOf(string(data)).Convert(target).Interface(), nil
+	case reflect.Slice:
+		return reflect.ValueOf(data).Convert(target).Interface(), nil
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
 // PrefabParameterGenerator is used to generate object by prefabs.
 type PrefabParameterGenerator struct{}
 
@@ -376,16 +587,139 @@ func (g *PrefabParameterGenerator) Generate(ctx context.Context, vc ValueContain
 	if prefab == nil {
 		return nil, noPrefab.Error(name)
 	}
-	return prefab.Make(ctx)
+	ins, err := prefab.Make(ctx)
+	if err != nil || ins == nil {
+		return ins, err
+	}
+	if typ := reflect.TypeOf(ins); !typ.AssignableTo(target) {
+		return nil, unassignableType.Error(typ, target)
+	}
+	return ins, nil
+}
+
+// autoValueKey is the context key of a value for auto parameters.
+type autoValueKey struct {
+	typ reflect.Type
+}
+
+// WithAutoValue returns a copy of ctx which carries value for auto parameters.
+// The value is keyed by its type, so a new value replaces the old one of the same
+// type. It's useful for middlewares to provide values (such as an authenticated
+// user) to handlers.
+func WithAutoValue(ctx context.Context, value interface{}) context.Context {
+	return context.WithValue(ctx, autoValueKey{reflect.TypeOf(value)}, value)
+}
+
+// SetAutoValue stores value for auto parameters in the http context of ctx, so
+// it's visible to everything which handles the request later, even if they don't
+// get the context derived from ctx. It's useful for operators to provide values
+// (such as the subject of a parsed token) to later operators, prefabs and the
+// handler:
+//  func(ctx context.Context, field string, token string) (string, error) {
+//      user, err := parse(token)
+//      ...
+//      service.SetAutoValue(ctx, user)
+//      return token, nil
+//  }
+// Parameters are generated in the order of definition, and operators of a
+// parameter run right after it's generated. So a value set by operators of a
+// parameter is visible to generators and operators of later parameters (such as
+// definition.AutoParameterFor) and the handler, but not earlier parameters.
+// Like WithAutoValue, the value is keyed by its type, and a new value replaces
+// the old one of the same type. Values set by SetAutoValue win over values set
+// by WithAutoValue. It returns false if there is no http context.
+func SetAutoValue(ctx context.Context, value interface{}) bool {
+	c, ok := ctx.Value(contextKeyUnderlyingHTTPContext).(*HTTPCtx)
+	if !ok || value == nil {
+		return false
+	}
+	c.autoValuesLock.Lock()
+	defer c.autoValuesLock.Unlock()
+	if c.autoValues == nil {
+		c.autoValues = map[reflect.Type]interface{}{}
+	}
+	c.autoValues[reflect.TypeOf(value)] = value
+	return true
+}
+
+// AutoValueFrom returns the value of type typ in ctx. The value must be set by
+// SetAutoValue or WithAutoValue. Prefabs can use it to get values set by
+// operators.
+func AutoValueFrom(ctx context.Context, typ reflect.Type) (interface{}, bool) {
+	if c, ok := ctx.Value(contextKeyUnderlyingHTTPContext).(*HTTPCtx); ok {
+		c.autoValuesLock.RLock()
+		value, ok := c.autoValues[typ]
+		c.autoValuesLock.RUnlock()
+		if ok {
+			return value, true
+		}
+	}
+	value := ctx.Value(autoValueKey{typ})
+	return value, value != nil
 }
 
-// AutoParameterGenerator generates an object from a struct type. The fields in a struct can have tag.
-// Tag name is "source". Its value format is "Source,Name".
+// SetBoundParameter records the value of a bound parameter in the http context of
+// ctx. Executors call it after operators of the parameter succeed, so the value is
+// the one passed to the function. It returns false if there is no http context.
+func SetBoundParameter(ctx context.Context, name string, value interface{}) bool {
+	c, ok := ctx.Value(contextKeyUnderlyingHTTPContext).(*HTTPCtx)
+	if !ok {
+		return false
+	}
+	c.boundLock.Lock()
+	defer c.boundLock.Unlock()
+	if c.bound == nil {
+		c.bound = map[string]interface{}{}
+	}
+	c.bound[name] = value
+	return true
+}
+
+// BoundParameter returns the value of a parameter which is already bound for the
+// request in ctx. Parameters are named as in definitions, and parameters without
+// names (such as the body) are named by their sources, such as "Body". The value
+// is the output of operators of the parameter. It's useful for operators to
+// validate values across parameters:
+//  func(ctx context.Context, field string, to time.Time) (time.Time, error) {
+//      from, ok := service.BoundParameter(ctx, "from")
+//      if ok && to.Before(from.(time.Time)) {
+//          return to, errors.BadRequest.Error("${field} is before from", field)
+//      }
+//      return to, nil
+//  }
+// Parameters are bound one by one in the order of definition, and operators of a
+// parameter run right after it's generated. The order is the same for every
+// request, so operators of a parameter always see all earlier parameters and
+// never see later ones. A parameter whose generator or operators failed is not
+// bound, so its value is absent even if errors are accumulated.
+func BoundParameter(ctx context.Context, name string) (interface{}, bool) {
+	c, ok := ctx.Value(contextKeyUnderlyingHTTPContext).(*HTTPCtx)
+	if !ok {
+		return nil, false
+	}
+	c.boundLock.RLock()
+	defer c.boundLock.RUnlock()
+	value, ok := c.bound[name]
+	return value, ok
+}
+
+// AutoParameterGenerator generates an object from a struct type. The target type must be a
+// struct or a pointer to struct, and the object is resolved by these rules:
+//  1. If the context has a value of the target type (set by SetAutoValue or WithAutoValue), the
+//     value is used.
+//     Types must be identical, so a *User parameter never receives a User value.
+//  2. Otherwise, if some fields of the struct have tag "source", the object is generated from
+//     the fields.
+//  3. Otherwise, an error is returned.
+//
+// Tag name is "source". Its value format is "Source,Name". If the name is omitted,
+// it's from tag "name".
 //
 // ex.
 // type Example struct {
 //     Start       int    `source:"Query,start"`
 //     ContentType string `source:"Header,Content-Type"`
+//     Limit       int    `source:"query" name:"limit"`
 // }
 type AutoParameterGenerator struct{}
 
@@ -406,6 +740,9 @@ func (g *AutoParameterGenerator) Validate(name string, defaultValue interface{},
 		if err != nil {
 			return err
 		}
+		if name == "" {
+			name = field.Tag.Get("name")
+		}
 		generator := ParameterGeneratorFor(source)
 		if generator == nil {
 			return NoParameterGenerator.Error(source)
@@ -435,6 +772,9 @@ func (g *AutoParameterGenerator) Validate(name string, defaultValue interface{},
 
 // Generate generates an object by data from value container.
 func (g *AutoParameterGenerator) Generate(ctx context.Context, vc ValueContainer, consumers []Consumer, name string, target reflect.Type) (interface{}, error) {
+	if ins, ok := AutoValueFrom(ctx, target); ok {
+		return ins, nil
+	}
 	var result reflect.Value
 	var value reflect.Value
 	if target.Kind() == reflect.Struct {
@@ -444,6 +784,9 @@ func (g *AutoParameterGenerator) Generate(ctx context.Context, vc ValueContainer
 		result = reflect.New(target.Elem())
 		value = result.Elem()
 	}
+	if !g.hasSourceFields(value.Type()) {
+		return nil, noAutoValue.Error(target)
+	}
 	if err := g.generate(ctx, vc, consumers, value); err != nil {
 		return nil, err
 	}
@@ -456,6 +799,9 @@ func (g *AutoParameterGenerator) generate(ctx context.Context, vc ValueContainer
 		if err != nil {
 			return err
 		}
+		if name == "" {
+			name = field.Tag.Get("name")
+		}
 		generator := ParameterGeneratorFor(source)
 		if generator == nil {
 			return NoParameterGenerator.Error(source)
@@ -465,6 +811,10 @@ func (g *AutoParameterGenerator) generate(ctx context.Context, vc ValueContainer
 			return err
 		}
 
+		if _, required := params.Get(AutoParameterConfigKeyRequired); ins == nil && required {
+			return RequiredParameter.Error(name, source)
+		}
+
 		defaultValue, exist := params.Get(AutoParameterConfigKeyDefaultValue)
 		if ins == nil && exist {
 			if c := ConverterFor(field.Type); c != nil {
@@ -474,7 +824,7 @@ func (g *AutoParameterGenerator) generate(ctx context.Context, vc ValueContainer
 		}
 
 		if ins != nil {
-			value.FieldByIndex(index).Set(reflect.ValueOf(ins))
+			fieldByIndex(value, index).Set(reflect.ValueOf(ins))
 		}
 
 		return nil
@@ -482,14 +832,33 @@ func (g *AutoParameterGenerator) generate(ctx context.Context, vc ValueContainer
 	return g.enum([]int{}, value.Type(), f)
 }
 
+// hasSourceFields checks whether the struct has fields with tag "source".
+func (g *AutoParameterGenerator) hasSourceFields(typ reflect.Type) bool {
+	found := false
+	_ = g.enum([]int{}, typ, func(index []int, field reflect.StructField) error {
+		found = true
+		return nil
+	})
+	return found
+}
+
+// enum walks fields with source tags. Fields of nested structs and embedded
+// struct pointers are promoted like encoding/json does.
 func (g *AutoParameterGenerator) enum(index []int, typ reflect.Type, f func(index []int, field reflect.StructField) error) error {
 	var err error
 	for i := 0; i < typ.NumField(); i++ {
 		field := typ.Field(i)
-		if field.Tag.Get("source") != "" {
-			err = f(append(index, i), field)
-		} else if field.Type.Kind() == reflect.Struct {
-			err = g.enum(append(index, i), field.Type, f)
+		// Copy index to avoid sharing the underlying array between fields.
+		fieldIndex := append(append(make([]int, 0, len(index)+1), index...), i)
+		switch {
+		case field.Tag.Get("source") != "":
+			err = f(fieldIndex, field)
+		case field.Type.Kind() == reflect.Struct:
+			err = g.enum(fieldIndex, field.Type, f)
+		case field.Anonymous && field.PkgPath == "" &&
+			field.Type.Kind() == reflect.Ptr && field.Type.Elem().Kind() == reflect.Struct:
+			// Only exported embedded pointers can be allocated.
+			err = g.enum(fieldIndex, field.Type.Elem(), f)
 		}
 		if err != nil {
 			return err
@@ -498,6 +867,21 @@ func (g *AutoParameterGenerator) enum(index []int, typ reflect.Type, f func(inde
 	return nil
 }
 
+// fieldByIndex returns the nested field by index. Unlike reflect.Value.FieldByIndex,
+// it allocates nil embedded struct pointers on the way.
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
 // AutoParameterConfig contains configs of AutoParameter.
 type AutoParameterConfig map[AutoParameterConfigKey]string
 
@@ -509,6 +893,8 @@ const (
 	AutoParameterConfigKeyDefaultValue AutoParameterConfigKey = "default"
 	// AutoParameterConfigKeyOptional is the key of optional tag.
 	AutoParameterConfigKeyOptional AutoParameterConfigKey = "optional"
+	// AutoParameterConfigKeyRequired is the key of required tag.
+	AutoParameterConfigKeyRequired AutoParameterConfigKey = "required"
 )
 
 // Get gets value of a config key.
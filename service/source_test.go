@@ -19,8 +19,12 @@ package service
 import (
 	"context"
 	"io"
+	"io/ioutil"
 	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/caicloud/nirvana/definition"
@@ -57,6 +61,13 @@ func (v *vc) Header(key string) ([]string, bool) {
 	return nil, true
 }
 
+func (v *vc) Cookie(name string) ([]string, bool) {
+	if name == testKey {
+		return []string{"cookie"}, true
+	}
+	return nil, false
+}
+
 func (v *vc) Form(key string) ([]string, bool) {
 	if key == testKey {
 		return []string{"form"}, true
@@ -152,6 +163,28 @@ func TestHeaderParameterGenerator(t *testing.T) {
 	}
 }
 
+func TestCookieParameterGenerator(t *testing.T) {
+	g := &CookieParameterGenerator{}
+	if g.Source() != definition.Cookie {
+		t.Fatalf("CookieParameterGenerator has a wrong source: %s", g.Source())
+	}
+	if err := g.Validate("test", "default", reflect.TypeOf("")); err != nil {
+		t.Fatal(err)
+	}
+	result, err := g.Generate(context.Background(), &vc{}, AllConsumers(), "test", reflect.TypeOf(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual("cookie", result) {
+		t.Fatalf("CookieParameterGenerator values is not equal: %+v, %+v", "cookie", result)
+	}
+	// Containers which are not CookieContainers have no cookies.
+	result, err = g.Generate(context.Background(), struct{ ValueContainer }{&vc{}}, AllConsumers(), "test", reflect.TypeOf(""))
+	if err != nil || result != nil {
+		t.Fatalf("Container without cookies should generate nothing: %v %v", result, err)
+	}
+}
+
 func TestFormParameterGenerator(t *testing.T) {
 	g := &FormParameterGenerator{}
 	if g.Source() != definition.Form {
@@ -222,6 +255,62 @@ func TestBodyParameterGenerator(t *testing.T) {
 	}
 }
 
+func TestRawBodyParameterGenerator(t *testing.T) {
+	g := &RawBodyParameterGenerator{}
+	if g.Source() != definition.RawBody {
+		t.Fatalf("RawBodyParameterGenerator has a wrong source: %s", g.Source())
+	}
+	for _, target := range []reflect.Type{reflect.TypeOf(&ts{}), reflect.TypeOf([]int{})} {
+		if err := g.Validate("", nil, target); err == nil {
+			t.Fatalf("RawBodyParameterGenerator should not accept %v", target)
+		}
+	}
+
+	const body = `{"value": "test body"}` + "\n"
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set("Content-Type", definition.MIMEJSON)
+	ctx := NewHTTPContext(httptest.NewRecorder(), req)
+	for _, target := range []reflect.Type{
+		reflect.TypeOf([]byte(nil)),
+		reflect.TypeOf(""),
+		reflect.TypeOf((*io.Reader)(nil)).Elem(),
+	} {
+		if err := g.Validate("", nil, target); err != nil {
+			t.Fatal(err)
+		}
+		result, err := g.Generate(ctx, ctx.ValueContainer(), AllConsumers(), "", target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var data []byte
+		switch r := result.(type) {
+		case []byte:
+			data = r
+		case string:
+			data = []byte(r)
+		case io.Reader:
+			data, _ = ioutil.ReadAll(r)
+		}
+		if string(data) != body {
+			t.Fatalf("RawBodyParameterGenerator result of %v is not correct: %q", target, result)
+		}
+	}
+
+	// The body is buffered for body parameters.
+	result, err := (&BodyParameterGenerator{}).Generate(ctx, ctx.ValueContainer(), AllConsumers(), "", reflect.TypeOf(&ts{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, ok := result.(*ts); !ok || r.Value != "test body" {
+		t.Fatalf("BodyParameterGenerator result after raw body is not correct: %+v", result)
+	}
+
+	ctx = NewHTTPContext(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+	if result, err := g.Generate(ctx, ctx.ValueContainer(), AllConsumers(), "", reflect.TypeOf("")); result != nil || err != nil {
+		t.Fatalf("Empty body should generate nothing: %v %v", result, err)
+	}
+}
+
 func TestPrefabParameterGenerator(t *testing.T) {
 	g := &PrefabParameterGenerator{}
 	if g.Source() != definition.Prefab {
@@ -283,6 +372,66 @@ func TestAutoParameterGenerator(t *testing.T) {
 	}
 }
 
+type embeddedName struct {
+	Name string `json:"name" source:"Path,test"`
+}
+
+type embeddedMeta struct {
+	embeddedName
+	Namespace string `json:"namespace" source:"Query,test"`
+}
+
+// EmbeddedLabels is exported because embedded pointers to unexported structs can't be allocated.
+type EmbeddedLabels struct {
+	Labels string `json:"labels" source:"Header,test"`
+}
+
+type embeddedObject struct {
+	embeddedMeta
+	*EmbeddedLabels
+}
+
+func TestEmbeddedStructs(t *testing.T) {
+	g := &BodyParameterGenerator{}
+	result, err := g.Generate(
+		context.Background(),
+		&vc2{
+			contentType: definition.MIMEJSON,
+			data:        `{"name":"body","namespace":"default","labels":"app"}`,
+		},
+		AllConsumers(),
+		"test",
+		reflect.TypeOf(&embeddedObject{}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, ok := result.(*embeddedObject); !ok ||
+		r.Name != "body" ||
+		r.Namespace != "default" ||
+		r.EmbeddedLabels == nil ||
+		r.Labels != "app" {
+		t.Fatalf("Embedded fields of body are not correct: %+v", result)
+	}
+
+	auto := &AutoParameterGenerator{}
+	target := reflect.TypeOf(&embeddedObject{})
+	if err := auto.Validate("test", nil, target); err != nil {
+		t.Fatal(err)
+	}
+	result, err = auto.Generate(context.Background(), &vc{}, AllConsumers(), "test", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, ok := result.(*embeddedObject); !ok ||
+		r.Name != "path" ||
+		r.Namespace != "query" ||
+		r.EmbeddedLabels == nil ||
+		r.Labels != "header" {
+		t.Fatalf("Embedded fields of auto parameter are not correct: %+v", result)
+	}
+}
+
 func TestInvalidAutoParameter(t *testing.T) {
 	g := &AutoParameterGenerator{}
 	if g.Source() != definition.Auto {
@@ -296,3 +445,73 @@ func TestInvalidAutoParameter(t *testing.T) {
 
 	t.Log(err)
 }
+
+type rs struct {
+	Missing string `source:"Query,missing,required"`
+}
+
+func TestAutoParameterRequired(t *testing.T) {
+	g := &AutoParameterGenerator{}
+	target := reflect.TypeOf(&rs{})
+	if err := g.Validate("test", nil, target); err != nil {
+		t.Fatal(err)
+	}
+	_, err := g.Generate(context.Background(), &vc{}, AllConsumers(), "test", target)
+	if !RequiredParameter.Derived(err) {
+		t.Fatalf("AutoParameterGenerator should return required parameter error: %v", err)
+	}
+}
+
+func TestPrefabRegistry(t *testing.T) {
+	g := &PrefabParameterGenerator{}
+	stringType := reflect.TypeOf("")
+	if err := g.Validate("unknown", nil, stringType); !noPrefab.Derived(err) {
+		t.Fatalf("Unknown prefab should be rejected: %v", err)
+	}
+	if _, err := g.Generate(context.Background(), &vc{}, AllConsumers(), "unknown", stringType); !noPrefab.Derived(err) {
+		t.Fatalf("Unknown prefab should be rejected: %v", err)
+	}
+
+	if err := RegisterPrefab(NewPrefab("test-prefab", stringType, func(ctx context.Context) (interface{}, error) {
+		return "prefab", nil
+	})); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Validate("test-prefab", nil, reflect.TypeOf(0)); !unassignableType.Derived(err) {
+		t.Fatalf("Prefab with mismatched type should be rejected: %v", err)
+	}
+	result, err := g.Generate(context.Background(), &vc{}, AllConsumers(), "test-prefab", stringType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "prefab" {
+		t.Fatalf("PrefabParameterGenerator result is not correct: %v", result)
+	}
+
+	// Maker returns an instance which doesn't match the declared type.
+	if err := RegisterPrefab(NewPrefab("test-liar", stringType, func(ctx context.Context) (interface{}, error) {
+		return 1, nil
+	})); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Generate(context.Background(), &vc{}, AllConsumers(), "test-liar", stringType); !unassignableType.Derived(err) {
+		t.Fatalf("Prefab instance with mismatched type should be rejected: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	ctx := NewHTTPContext(httptest.NewRecorder(), req)
+	result, err = g.Generate(ctx, &vc{}, AllConsumers(), "request", reflect.TypeOf(req))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.(*http.Request) != req {
+		t.Fatalf("PrefabParameterGenerator returns a wrong request: %v", result)
+	}
+	result, err = g.Generate(ctx, &vc{}, AllConsumers(), "response", reflect.TypeOf((*http.ResponseWriter)(nil)).Elem())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := result.(http.ResponseWriter); !ok {
+		t.Fatalf("PrefabParameterGenerator returns a wrong response writer: %v", result)
+	}
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/caicloud/nirvana/definition"
+)
+
+// StaticOptions contains options of Static.
+type StaticOptions struct {
+	// Index is the file served for directories. Default to "index.html".
+	Index string
+	// Fallback enables the fallback for single page applications. Requests of
+	// missing files are served with the index file of the root, so routes of the
+	// application are handled by the client. Requests which look like assets
+	// (their last path segments have extensions, such as "/app.js") still get
+	// 404 if files are missing.
+	Fallback bool
+	// MaxAge is the max age in "Cache-Control" of files. Index files always have
+	// "Cache-Control: no-cache", so clients get new builds which refer to new
+	// assets. Zero means "no-cache" for all files.
+	MaxAge time.Duration
+}
+
+// Static creates a descriptor which serves files of fs under path, such as:
+//
+//	builder.AddDescriptor(service.Static("/", http.Dir("dist"), service.StaticOptions{
+//	    Fallback: true,
+//	    MaxAge:   24 * time.Hour,
+//	}))
+//
+// Files are served with "Last-Modified" and weak "ETag" headers which are made of
+// modification times and sizes, so conditional and range requests work as
+// http.ServeContent. Directories are served with their index files and are never
+// listed. Only GET and HEAD requests are accepted. Like Mount, definitions with
+// more specific paths (such as "/api") take precedence over files.
+func Static(path string, fs http.FileSystem, options StaticOptions) definition.Descriptor {
+	if options.Index == "" {
+		options.Index = "index.html"
+	}
+	return Mount(path, &staticHandler{fs: fs, options: options}, true)
+}
+
+// staticHandler serves files of a file system.
+type staticHandler struct {
+	fs      http.FileSystem
+	options StaticOptions
+}
+
+// ServeHTTP serves the file of request path.
+func (h *staticHandler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		resp.Header().Set("Allow", "GET, HEAD")
+		http.Error(resp, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	name := path.Clean("/" + req.URL.Path)
+	if h.serveFile(resp, req, name) {
+		return
+	}
+	if h.options.Fallback && path.Ext(name) == "" && h.serveFile(resp, req, "/") {
+		return
+	}
+	http.NotFound(resp, req)
+}
+
+// serveFile serves the file or the index file of the directory of name. It
+// returns false if there is no such file.
+func (h *staticHandler) serveFile(resp http.ResponseWriter, req *http.Request, name string) bool {
+	file, err := h.fs.Open(name)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	if info.IsDir() {
+		name = path.Join(name, h.options.Index)
+		indexFile, err := h.fs.Open(name)
+		if err != nil {
+			return false
+		}
+		defer indexFile.Close()
+		file = indexFile
+		if info, err = file.Stat(); err != nil || info.IsDir() {
+			return false
+		}
+	}
+
+	header := resp.Header()
+	header.Set("ETag", staticETag(info))
+	if path.Base(name) == h.options.Index || h.options.MaxAge <= 0 {
+		header.Set("Cache-Control", "no-cache")
+	} else {
+		header.Set("Cache-Control", "public, max-age="+strconv.FormatInt(int64(h.options.MaxAge/time.Second), 10))
+	}
+	http.ServeContent(resp, req, name, info.ModTime(), file)
+	return true
+}
+
+// staticETag makes a weak etag of a file from its modification time and size.
+func staticETag(info os.FileInfo) string {
+	return fmt.Sprintf(`W/"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+}
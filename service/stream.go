@@ -0,0 +1,189 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+)
+
+// StreamDecoder decodes values of a stream one by one.
+type StreamDecoder interface {
+	// Decode decodes the next value into v, which is a pointer. It returns
+	// io.EOF if there is no more value.
+	Decode(v interface{}) error
+}
+
+// StreamConsumer is a consumer which decodes request bodies incrementally.
+// Body parameters of receive channels (such as <-chan *Record) require stream
+// consumers of the content types of requests.
+type StreamConsumer interface {
+	Consumer
+	// NewStreamDecoder creates a decoder for values in r.
+	NewStreamDecoder(r io.Reader) StreamDecoder
+}
+
+// NewStreamDecoder creates a decoder which reads lines of json.
+func (s *NDJSONSerializer) NewStreamDecoder(r io.Reader) StreamDecoder {
+	return json.NewDecoder(r)
+}
+
+// NewStreamDecoder creates a decoder which reads elements of a json array.
+func (s *JSONSerializer) NewStreamDecoder(r io.Reader) StreamDecoder {
+	return &jsonArrayDecoder{decoder: json.NewDecoder(r)}
+}
+
+// jsonArrayDecoder decodes elements of a json array one by one.
+type jsonArrayDecoder struct {
+	decoder *json.Decoder
+	started bool
+}
+
+// Decode decodes the next element into v.
+func (d *jsonArrayDecoder) Decode(v interface{}) error {
+	if !d.started {
+		d.started = true
+		token, err := d.decoder.Token()
+		if err != nil {
+			// An empty body has no element.
+			return err
+		}
+		if delim, ok := token.(json.Delim); !ok || delim != '[' {
+			return fmt.Errorf("stream of json must be an array, but got %v", token)
+		}
+	}
+	if !d.decoder.More() {
+		// Consume the closing bracket.
+		if _, err := d.decoder.Token(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	return d.decoder.Decode(v)
+}
+
+// streamConsumerOf returns the stream consumer of c.
+func streamConsumerOf(c Consumer) (StreamConsumer, bool) {
+	if sc, ok := c.(*suffixConsumer); ok {
+		c = sc.consumer
+	}
+	stream, ok := c.(StreamConsumer)
+	return stream, ok
+}
+
+// bodyStream records the state of decoding a request body into a channel.
+type bodyStream struct {
+	lock sync.Mutex
+	err  error
+}
+
+func (s *bodyStream) setError(err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.err = err
+}
+
+func (s *bodyStream) error() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.err
+}
+
+// streamBody decodes values of reader in a new goroutine and sends them to a
+// channel of type target. The channel is unbuffered, so decoding waits until the
+// last values are received. The channel is closed when all values are sent, a
+// value is malformed, or ctx is done. A read blocked on reader can't be
+// interrupted, so the decoding goroutine exits when the read returns. It's fine
+// for request bodies because reads fail after the connection is closed.
+func streamBody(ctx context.Context, reader io.Reader, contentType string, consumer Consumer, target reflect.Type) (interface{}, error) {
+	ch := reflect.MakeChan(reflect.ChanOf(reflect.BothDir, target.Elem()), 0)
+	if reader == nil {
+		// No body means no value.
+		ch.Close()
+		return ch.Convert(target).Interface(), nil
+	}
+	sc, ok := streamConsumerOf(consumer)
+	if !ok {
+		return nil, noStreamConsumer.Error(contentType)
+	}
+	decoder := sc.NewStreamDecoder(reader)
+	stream := &bodyStream{}
+	if c, ok := ctx.Value(contextKeyUnderlyingHTTPContext).(*HTTPCtx); ok {
+		c.stream = stream
+	}
+	values := make(chan reflect.Value)
+	go func() {
+		defer close(values)
+		for {
+			value := reflect.New(target.Elem())
+			if err := decoder.Decode(value.Interface()); err != nil {
+				if err != io.EOF {
+					stream.setError(invalidBody.Error(contentType, err))
+				}
+				return
+			}
+			select {
+			case values <- value.Elem():
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	// Values are forwarded by another goroutine, so the channel can be closed
+	// when ctx is done even if decoding is blocked.
+	go func() {
+		defer ch.Close()
+		done := reflect.ValueOf(ctx.Done())
+		for {
+			select {
+			case value, ok := <-values:
+				if !ok {
+					return
+				}
+				cases := []reflect.SelectCase{
+					{Dir: reflect.SelectSend, Chan: ch, Send: value},
+					{Dir: reflect.SelectRecv, Chan: done},
+				}
+				if chosen, _, _ := reflect.Select(cases); chosen == 0 {
+					continue
+				}
+			case <-ctx.Done():
+			}
+			stream.setError(ctx.Err())
+			return
+		}
+	}()
+	return ch.Convert(target).Interface(), nil
+}
+
+// BodyStreamError returns the error which stops decoding the request body in
+// ctx into a channel parameter, such as a malformed value (a bad request error)
+// or cancellation of ctx. It returns nil if there is no such parameter, or if all
+// values are decoded or are still being decoded. Functions should check it after
+// the channel is closed to know whether all values are received. If a function
+// returns without an error, the error is written as the response.
+func BodyStreamError(ctx context.Context) error {
+	c, ok := ctx.Value(contextKeyUnderlyingHTTPContext).(*HTTPCtx)
+	if !ok || c.stream == nil {
+		return nil
+	}
+	return c.stream.error()
+}
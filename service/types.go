@@ -77,3 +77,15 @@ type Builder interface {
 type Service interface {
 	http.Handler
 }
+
+// DynamicService is a service whose routes can be changed while it's serving.
+// Changes are atomic: a request is matched either with all routes before a
+// change or with all routes after it.
+type DynamicService interface {
+	Service
+	// Register adds descriptors to the service. It returns an error if
+	// descriptors conflict with existing routes.
+	Register(descriptors ...interface{}) error
+	// Deregister removes routes of paths and their sub paths.
+	Deregister(paths ...string) error
+}
@@ -21,8 +21,10 @@ import (
 	"io"
 	"mime"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/caicloud/nirvana/definition"
 	"github.com/caicloud/nirvana/errors"
@@ -44,6 +46,79 @@ func WrapHTTPHandlerFunc(f http.HandlerFunc) func(ctx context.Context) {
 	}
 }
 
+// mountPathKey is the key of path parameter which contains the path under a
+// mount point.
+const mountPathKey = "nirvanaMountPath"
+
+// Mount creates a descriptor which routes requests of all methods under path to
+// handler. Parameters, results and content types of definitions are bypassed,
+// and the handler writes responses by itself. If stripPrefix is true, the path
+// of request is trimmed to the path under mount point (like http.StripPrefix)
+// when handler is called. Definitions with more specific paths take precedence
+// over mounted handlers. Requests are still negotiated with registered consumers
+// and producers, so a request is rejected if no consumer accepts its content type
+// or no producer matches its "Accept" header:
+//  builder.AddDescriptor(service.Mount("/debug/pprof", http.DefaultServeMux, false))
+func Mount(path string, handler http.Handler, stripPrefix bool) definition.Descriptor {
+	function := WrapHTTPHandler(handler)
+	if stripPrefix {
+		function = func(ctx context.Context) {
+			httpCtx := HTTPContextFrom(ctx)
+			sub, _ := httpCtx.ValueContainer().Path(mountPathKey)
+			req := httpCtx.Request()
+			r := new(http.Request)
+			*r = *req
+			r.URL = new(url.URL)
+			*r.URL = *req.URL
+			r.URL.Path, r.URL.RawPath = mountedPath(req.URL.EscapedPath(), sub)
+			handler.ServeHTTP(httpCtx.ResponseWriter(), r)
+		}
+	}
+	definitions := []definition.Definition{{
+		Method:   definition.Any,
+		Consumes: []string{definition.MIMEAll},
+		Produces: []string{definition.MIMEAll},
+		Function: function,
+	}}
+	return definition.Descriptor{
+		Path:        path,
+		Definitions: definitions,
+		Children: []definition.Descriptor{{
+			Path:        "{" + mountPathKey + ":*}",
+			Definitions: definitions,
+		}},
+	}
+}
+
+// mountedPath returns the decoded and escaped forms of the path under a mount
+// point. sub is the value of the mount path parameter, whose segments are
+// decoded except escaped slashes. So it has as many segments as the tail of the
+// escaped path of request.
+func mountedPath(escaped string, sub string) (string, string) {
+	sub = strings.TrimPrefix(sub, "/")
+	if sub == "" {
+		return "/", ""
+	}
+	segments := strings.Count(sub, "/") + 1
+	index := len(escaped)
+	for ; segments > 0 && index > 0; segments-- {
+		index = strings.LastIndex(escaped[:index], "/")
+	}
+	if index < 0 {
+		index = 0
+	}
+	raw := "/" + strings.TrimPrefix(escaped[index:], "/")
+	path, err := url.PathUnescape(raw)
+	if err != nil {
+		return "/" + sub, ""
+	}
+	if (&url.URL{Path: path}).EscapedPath() == raw {
+		// Leave RawPath empty as url.URL does if it's the default encoding.
+		return path, ""
+	}
+	return path, raw
+}
+
 // FileNotFound is an error factory to show why can't find a file.
 // This error may contains private information. Don't return this error to end users directly.
 var FileNotFound = errors.NotFound.Build("Nirvana:Service:FileNotFound", "can't find file ${path} because ${reason}")
@@ -105,24 +180,53 @@ var (
 	NoParameterGenerator = errors.InternalServerError.Build("Nirvana:Service:NoParameterGenerator", "no parameter generator for source ${source}")
 	// NoProducerToWrite represents no producer to write error.
 	NoProducerToWrite = errors.NotAcceptable.Build("Nirvana:Service:noProducerToWrite", "can't find producer for accept types ${types}")
+	// RequiredParameter represents a required parameter is absent in request.
+	RequiredParameter = errors.BadRequest.Build("Nirvana:Service:RequiredParameter", "required parameter ${name} in ${source} is absent")
+	// PreconditionFailed represents a conditional request header is not satisfied.
+	PreconditionFailed = errors.PreconditionFailed.Build("Nirvana:Service:PreconditionFailed", "precondition in header ${header} is not satisfied")
+	// PanicRecovered represents a panic recovered in request handling.
+	PanicRecovered = errors.InternalServerError.Build("Nirvana:Service:PanicRecovered", "internal server error")
 )
 
 var (
 	invalidContentType     = errors.BadRequest.Build("Nirvana:Service:InvalidContentType", "invalid content type ${type}")
+	invalidBody            = errors.BadRequest.Build("Nirvana:Service:InvalidBody", "can't parse body as ${type}: ${reason}")
 	invalidConversion      = errors.BadRequest.Build("Nirvana:Service:InvalidConversion", "can't convert ${data} to ${type}")
+	invalidTime            = errors.BadRequest.Build("Nirvana:Service:InvalidTime", "can't convert ${data} to time, it should be in layouts ${layouts} or Unix seconds")
+	invalidFormField       = errors.BadRequest.Build("Nirvana:Service:InvalidFormField", "invalid form field ${field}: ${reason}")
+	invalidMultipartForm   = errors.BadRequest.Build("Nirvana:Service:InvalidMultipartForm", "can't parse multipart form: ${reason}")
+	unknownField           = errors.BadRequest.Build("Nirvana:Service:UnknownField", "field ${field} is not in response")
 	invalidConsumer        = errors.InternalServerError.Build("Nirvana:Service:invalidConsumer", "${type} is invalid for consumer")
 	invalidProducer        = errors.InternalServerError.Build("Nirvana:Service:invalidProducer", "${type} is invalid for producer")
+	invalidErrorSerializer = errors.InternalServerError.Build("Nirvana:Service:invalidErrorSerializer", "${type} is invalid for error serializer")
 	noConnectionHijacker   = errors.InternalServerError.Build("Nirvana:Service:noConnectionHijacker", "underlying http.ResponseWriter does not implement http.Hijacker")
+	requestURITooLong      = errors.RequestURITooLong.Build("Nirvana:Service:RequestURITooLong", "request uri is longer than ${limit} bytes")
+	requestHeaderTooLarge  = errors.RequestHeaderFieldsTooLarge.Build("Nirvana:Service:RequestHeaderTooLarge", "request header is larger than ${limit} bytes")
+	invalidHandshake       = errors.BadRequest.Build("Nirvana:Service:InvalidHandshake", "invalid websocket handshake: ${reason}")
+	forbiddenOrigin        = errors.Forbidden.Build("Nirvana:Service:ForbiddenOrigin", "websocket origin ${origin} is not allowed")
 	invalidMetaType        = errors.InternalServerError.Build("Nirvana:Service:invalidMetaType", "can't recognize meta for type ${type}")
+	invalidStreamType      = errors.InternalServerError.Build("Nirvana:Service:invalidStreamType", "${type} is neither io.Reader nor receive channel for stream")
+	invalidAttachmentType  = errors.InternalServerError.Build("Nirvana:Service:invalidAttachmentType", "${type} is neither FileContent nor *FileContent for attachment")
+	invalidRedirectionType = errors.InternalServerError.Build("Nirvana:Service:invalidRedirectionType", "${type} is neither Redirection nor *Redirection for redirect")
+	invalidRedirectCode    = errors.InternalServerError.Build("Nirvana:Service:invalidRedirectCode", "${code} is not a status code of redirection")
 	invalidMethod          = errors.InternalServerError.Build("Nirvana:Service:invalidMethod", "http method ${method} is invalid")
 	invalidStatusCode      = errors.InternalServerError.Build("Nirvana:Service:invalidStatusCode", "http status code must be in [100,599]")
 	invalidBodyType        = errors.InternalServerError.Build("Nirvana:Service:invalidBodyType", "${type} is not a valid type for body")
-	noPrefab               = errors.InternalServerError.Build("Nirvana:Service:noPrefab", "no prefab named ${name}")
+	invalidRawBodyType     = errors.InternalServerError.Build("Nirvana:Service:invalidRawBodyType", "${type} is not a valid type for raw body")
+	noStreamConsumer       = errors.UnsupportedMediaType.Build("Nirvana:Service:NoStreamConsumer", "content type ${type} can't be decoded as a stream")
+	invalidSecurityScheme  = errors.InternalServerError.Build("Nirvana:Service:invalidSecurityScheme", "security scheme '${name}' is invalid: ${reason}")
+	noSecurityScheme       = errors.InternalServerError.Build("Nirvana:Service:noSecurityScheme", "no security scheme named ${name}, you can register it by service.RegisterSecurityScheme()")
+	invalidCORSOptions     = errors.InternalServerError.Build("Nirvana:Service:invalidCORSOptions", "CORS options are invalid: ${reason}")
+	missingCredential      = errors.Unauthorized.Build("Nirvana:Service:MissingCredential", "credential of ${schemes} is required")
+	noPrefab               = errors.InternalServerError.Build("Nirvana:Service:noPrefab", "no prefab named ${name}, you can register it by service.RegisterPrefab()")
+	noAutoValue            = errors.InternalServerError.Build("Nirvana:Service:noAutoValue", "no value of type ${type} in context and the type has no field with source tag")
 	invalidAutoParameter   = errors.InternalServerError.Build("Nirvana:Service:invalidAutoParameter", "${type} is not a struct or a pointer to struct")
 	invalidFieldTag        = errors.InternalServerError.Build("Nirvana:Service:invalidFieldTag", "filed tag ${tag} is invalid")
 	noName                 = errors.InternalServerError.Build("Nirvana:Service:noName", "${source} must have a name")
 	invalidTypeForConsumer = errors.InternalServerError.Build("Nirvana:Service:invalidTypeForConsumer", "consumer ${content} can't consume data for type ${type}")
 	invalidTypeForProducer = errors.InternalServerError.Build("Nirvana:Service:invalidTypeForProducer", "producer ${content} can't produce data for type ${type}")
+	noPartProducer         = errors.InternalServerError.Build("Nirvana:Service:noPartProducer", "no producer for content type ${type} of part ${index}")
 	unassignableType       = errors.InternalServerError.Build("Nirvana:Service:unassignableType", "type ${typeA} can't assign to ${typeB}")
+	panicWithValue         = errors.InternalServerError.Build("Nirvana:Service:Panic", "panic: ${value}")
 	noConverter            = errors.InternalServerError.Build("Nirvana:Service:unassignableType", "no converter for type ${type}")
 )
@@ -0,0 +1,119 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/caicloud/nirvana/definition"
+)
+
+// InvalidParametersReason is the reason of ParameterErrors.
+const InvalidParametersReason = "Nirvana:Service:InvalidParameters"
+
+// FieldError describes an error of a parameter.
+type FieldError struct {
+	// Field is the name of the parameter. It's the source for parameters
+	// without name, such as "Body".
+	Field string `json:"field" xml:"field,attr"`
+	// Source is the source of the parameter.
+	Source definition.Source `json:"source" xml:"source,attr"`
+	// Reason is the reason of the error if it has one.
+	Reason string `json:"reason,omitempty" xml:"reason,attr,omitempty"`
+	// Message is the description of the error.
+	Message string `json:"message" xml:",chardata"`
+	// Data is the data of the error if it has. It's used to localize Message.
+	Data map[string]string `json:"-" xml:"-"`
+}
+
+// NewFieldError creates a FieldError from an error of a parameter.
+func NewFieldError(field string, source definition.Source, err error) FieldError {
+	fe := FieldError{
+		Field:   field,
+		Source:  source,
+		Message: err.Error(),
+	}
+	if r, ok := err.(interface{ Reason() string }); ok {
+		fe.Reason = r.Reason()
+	}
+	if d, ok := err.(interface{ Data() map[string]string }); ok {
+		fe.Data = d.Data()
+	}
+	return fe
+}
+
+// ParameterErrors aggregates errors of parameters in a request. It's a bad request
+// error, and its message is marshaled as:
+//  {
+//    "reason": "Nirvana:Service:InvalidParameters",
+//    "message": "2 parameters are invalid",
+//    "errors": [
+//      {"field": "name", "source": "Query", "reason": "...", "message": "..."},
+//      {"field": "Body", "source": "Body", "message": "..."}
+//    ]
+//  }
+// Errors are in the order of parameters.
+type ParameterErrors []FieldError
+
+type parameterErrorsMessage struct {
+	XMLName struct{}     `json:"-" xml:"error"`
+	Reason  string       `json:"reason" xml:"reason"`
+	Message string       `json:"message" xml:"message"`
+	Errors  []FieldError `json:"errors" xml:"errors>error"`
+}
+
+// Code returns status code of the errors.
+func (e ParameterErrors) Code() int {
+	return http.StatusBadRequest
+}
+
+// Reason returns the reason of the errors.
+func (e ParameterErrors) Reason() string {
+	return InvalidParametersReason
+}
+
+// Data returns nil. Details are in Message().
+func (e ParameterErrors) Data() map[string]string {
+	return nil
+}
+
+// Message returns an object which contains all errors.
+func (e ParameterErrors) Message() interface{} {
+	return &parameterErrorsMessage{
+		Reason:  InvalidParametersReason,
+		Message: e.summary(),
+		Errors:  e,
+	}
+}
+
+// Error returns the description of all errors.
+func (e ParameterErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, fe := range e {
+		messages[i] = fe.Field + ": " + fe.Message
+	}
+	return e.summary() + ": " + strings.Join(messages, "; ")
+}
+
+func (e ParameterErrors) summary() string {
+	if len(e) == 1 {
+		return "1 parameter is invalid"
+	}
+	return fmt.Sprintf("%d parameters are invalid", len(e))
+}
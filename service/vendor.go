@@ -0,0 +1,141 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// VendorType is a vendor media type which carries an API version, such as
+// "application/vnd.myapp.v2+json".
+type VendorType struct {
+	// Vendor is the name of the vendor tree, such as "myapp".
+	Vendor string
+	// Version is the version of the API, such as "v2". It's empty if the type
+	// has no version, such as "application/vnd.myapp+json".
+	Version string
+	// Suffix is the structured syntax suffix, such as "json".
+	Suffix string
+}
+
+// String returns the media type.
+func (t VendorType) String() string {
+	result := "application/vnd." + t.Vendor
+	if t.Version != "" {
+		result += "." + t.Version
+	}
+	if t.Suffix != "" {
+		result += "+" + t.Suffix
+	}
+	return result
+}
+
+// ParseVendorType parses a vendor media type. Parameters are ignored. The last
+// part of the subtype is the version if it's like "v2" or "v2beta1".
+func ParseVendorType(contentType string) (VendorType, bool) {
+	mt := MediaType(contentType)
+	if !strings.HasPrefix(mt, "application/vnd.") {
+		return VendorType{}, false
+	}
+	t := VendorType{}
+	subtype := mt[len("application/vnd."):]
+	if index := strings.LastIndexByte(subtype, '+'); index >= 0 {
+		subtype, t.Suffix = subtype[:index], subtype[index+1:]
+	}
+	if index := strings.LastIndexByte(subtype, '.'); index >= 0 && isVersion(subtype[index+1:]) {
+		subtype, t.Version = subtype[:index], subtype[index+1:]
+	}
+	if subtype == "" {
+		return VendorType{}, false
+	}
+	t.Vendor = subtype
+	return t, true
+}
+
+// isVersion checks whether s is like "v2".
+func isVersion(s string) bool {
+	return len(s) > 1 && s[0] == 'v' && s[1] >= '0' && s[1] <= '9'
+}
+
+var defaultVersions sync.Map
+
+// SetDefaultVersion sets the default version of a vendor. Requests which
+// accept unknown versions of the vendor, or accept the suffix type (such as
+// "application/json" for "+json"), are served by definitions which produce the
+// default version. Without a default version, requests for unknown versions
+// are rejected with 406. An empty version removes the default version.
+func SetDefaultVersion(vendor, version string) {
+	if version == "" {
+		defaultVersions.Delete(vendor)
+		return
+	}
+	defaultVersions.Store(vendor, version)
+}
+
+// DefaultVersion returns the default version of a vendor.
+func DefaultVersion(vendor string) string {
+	version, _ := defaultVersions.Load(vendor)
+	s, _ := version.(string)
+	return s
+}
+
+// suffixType returns the content type of the structured syntax suffix of a type,
+// such as "application/json" for "application/vnd.myapp+json". It returns empty
+// if the type has no suffix.
+func suffixType(contentType string) string {
+	mt := MediaType(contentType)
+	index := strings.LastIndexByte(mt, '+')
+	if index < 0 || index == len(mt)-1 {
+		return ""
+	}
+	return "application/" + mt[index+1:]
+}
+
+// suffixProducer produces a vendor type by the producer of its suffix.
+type suffixProducer struct {
+	contentType string
+	Producer
+}
+
+func (p *suffixProducer) ContentType() string {
+	return p.contentType
+}
+
+// ForRequest keeps the content type of producers for requests.
+func (p *suffixProducer) ForRequest(req *http.Request) Producer {
+	if rp, ok := p.Producer.(RequestAwareProducer); ok {
+		return &suffixProducer{p.contentType, rp.ForRequest(req)}
+	}
+	return p
+}
+
+// suffixConsumer consumes a vendor type by the consumer of its suffix.
+type suffixConsumer struct {
+	contentType string
+	consumer    Consumer
+}
+
+func (c *suffixConsumer) ContentType() string {
+	return c.contentType
+}
+
+func (c *suffixConsumer) Consume(r io.Reader, v interface{}) error {
+	return c.consumer.Consume(r, v)
+}
@@ -0,0 +1,482 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// WebSocketMessageType is the type of websocket messages.
+type WebSocketMessageType int
+
+const (
+	// WebSocketTextMessage is a message of UTF-8 text.
+	WebSocketTextMessage WebSocketMessageType = 1
+	// WebSocketBinaryMessage is a message of binary data.
+	WebSocketBinaryMessage WebSocketMessageType = 2
+)
+
+// Status codes of closing websocket connections. See RFC 6455, 7.4.1.
+const (
+	WebSocketNormalClosure   = 1000
+	WebSocketGoingAway       = 1001
+	WebSocketProtocolError   = 1002
+	WebSocketNoStatus        = 1005
+	WebSocketInvalidPayload  = 1007
+	WebSocketPolicyViolation = 1008
+	WebSocketMessageTooBig   = 1009
+	WebSocketInternalError   = 1011
+)
+
+// DefaultWebSocketReadLimit is the default max size of messages in bytes.
+const DefaultWebSocketReadLimit = 32 << 20
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// webSocketGUID is used to compute "Sec-WebSocket-Accept".
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocketCloseError is returned by reading a connection which is closed by an
+// unexpected status, such as an abnormal close frame from client or a protocol error.
+type WebSocketCloseError struct {
+	// Code is the status code of close frame.
+	Code int
+	// Text is the reason of close frame.
+	Text string
+}
+
+// Error returns the description of the error.
+func (e *WebSocketCloseError) Error() string {
+	if e.Text == "" {
+		return fmt.Sprintf("websocket closed with code %d", e.Code)
+	}
+	return fmt.Sprintf("websocket closed with code %d: %s", e.Code, e.Text)
+}
+
+// errWebSocketClosed is returned by operations of closed connections.
+var errWebSocketClosed = &WebSocketCloseError{Code: WebSocketNormalClosure, Text: "connection is closed"}
+
+// WebSocketOriginChecker checks whether header "Origin" of a websocket handshake
+// is allowed.
+type WebSocketOriginChecker func(req *http.Request) bool
+
+var originChecker WebSocketOriginChecker = WebSocketSameOrigin
+
+// SetWebSocketOriginChecker overrides the checker of websocket origins. Nil resets
+// it to WebSocketSameOrigin. Browsers don't apply CORS to websockets, so accepting
+// any origin allows other sites to connect with cookies of users.
+func SetWebSocketOriginChecker(checker WebSocketOriginChecker) {
+	if checker == nil {
+		checker = WebSocketSameOrigin
+	}
+	originChecker = checker
+}
+
+// WebSocketSameOrigin allows handshakes without header "Origin" (non-browser
+// clients) and handshakes whose origin has the same host as the request.
+func WebSocketSameOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, req.Host)
+}
+
+// WebSocketConn is a websocket connection of a handshake request. The connection
+// is upgraded when it's read or written for the first time, or when Upgrade is
+// called, so handlers can still return errors as normal responses before that.
+//
+// Messages must be read by one goroutine at a time, and can be written by many
+// goroutines. Pings from client are answered while reading.
+type WebSocketConn struct {
+	response  *response
+	key       string
+	readLimit int64
+
+	// lock protects the upgrade.
+	lock   sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+
+	// writeLock serializes frames.
+	writeLock sync.Mutex
+	writer    *bufio.Writer
+	closeSent bool
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// Upgrade switches the protocol of connection to websocket by a 101 response.
+// Headers of the response writer, such as headers set by middlewares, are sent
+// with the response. It does nothing if the connection has been upgraded.
+func (c *WebSocketConn) Upgrade() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.conn != nil {
+		return nil
+	}
+	select {
+	case <-c.done:
+		return errWebSocketClosed
+	default:
+	}
+	conn, rw, err := c.response.Hijack()
+	if err != nil {
+		return err
+	}
+	header := c.response.Header().Clone()
+	header.Set("Upgrade", "websocket")
+	header.Set("Connection", "Upgrade")
+	header.Set("Sec-WebSocket-Accept", acceptKey(c.key))
+	buf := bytes.NewBufferString("HTTP/1.1 101 Switching Protocols\r\n")
+	_ = header.Write(buf)
+	buf.WriteString("\r\n")
+	c.response.statusCode = http.StatusSwitchingProtocols
+	// Clear deadlines of server, such as ReadTimeout and WriteTimeout.
+	_ = conn.SetDeadline(time.Time{})
+	if _, err := rw.Writer.Write(buf.Bytes()); err == nil {
+		err = rw.Writer.Flush()
+	}
+	if err != nil {
+		_ = conn.Close()
+		c.closeOnce.Do(func() { close(c.done) })
+		return err
+	}
+	c.conn, c.reader, c.writer = conn, rw.Reader, rw.Writer
+	return nil
+}
+
+// Upgraded checks whether the connection has been upgraded.
+func (c *WebSocketConn) Upgraded() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.conn != nil
+}
+
+// SetReadLimit sets the max size of messages in bytes. A larger message closes the
+// connection with WebSocketMessageTooBig.
+func (c *WebSocketConn) SetReadLimit(limit int64) {
+	c.readLimit = limit
+}
+
+// Done returns a channel which is closed when the connection is closed.
+func (c *WebSocketConn) Done() <-chan struct{} {
+	return c.done
+}
+
+// ReadMessage reads a message. It returns io.EOF if client closes the connection
+// normally, and a *WebSocketCloseError for other close frames and protocol errors.
+// Fragmented messages are assembled.
+func (c *WebSocketConn) ReadMessage() (WebSocketMessageType, []byte, error) {
+	if err := c.Upgrade(); err != nil {
+		return 0, nil, err
+	}
+	var typ WebSocketMessageType
+	var message []byte
+	for {
+		fin, op, payload, err := c.readFrame(c.readLimit - int64(len(message)))
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+		switch op {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return 0, nil, c.fail(err)
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			code, text := WebSocketNoStatus, ""
+			if len(payload) >= 2 {
+				code, text = int(binary.BigEndian.Uint16(payload)), string(payload[2:])
+			}
+			reply := code
+			if reply == WebSocketNoStatus {
+				reply = WebSocketNormalClosure
+			}
+			_ = c.CloseWithStatus(reply, "")
+			switch code {
+			case WebSocketNormalClosure, WebSocketGoingAway, WebSocketNoStatus:
+				return 0, nil, io.EOF
+			}
+			return 0, nil, &WebSocketCloseError{Code: code, Text: text}
+		case opText, opBinary:
+			if typ != 0 {
+				return 0, nil, c.fail(&WebSocketCloseError{Code: WebSocketProtocolError, Text: "unfinished message"})
+			}
+			typ = WebSocketMessageType(op)
+		case opContinuation:
+			if typ == 0 {
+				return 0, nil, c.fail(&WebSocketCloseError{Code: WebSocketProtocolError, Text: "unexpected continuation"})
+			}
+		default:
+			return 0, nil, c.fail(&WebSocketCloseError{Code: WebSocketProtocolError, Text: "unknown opcode"})
+		}
+		message = append(message, payload...)
+		if !fin {
+			continue
+		}
+		if typ == WebSocketTextMessage && !utf8.Valid(message) {
+			return 0, nil, c.fail(&WebSocketCloseError{Code: WebSocketInvalidPayload, Text: "invalid utf-8 text"})
+		}
+		return typ, message, nil
+	}
+}
+
+// readFrame reads a frame whose payload is no more than limit.
+func (c *WebSocketConn) readFrame(limit int64) (fin bool, op byte, payload []byte, err error) {
+	var header [8]byte
+	if _, err := io.ReadFull(c.reader, header[:2]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, op = header[0]&0x80 != 0, header[0]&0x0F
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, &WebSocketCloseError{Code: WebSocketProtocolError, Text: "unexpected reserved bits"}
+	}
+	if header[1]&0x80 == 0 {
+		return false, 0, nil, &WebSocketCloseError{Code: WebSocketProtocolError, Text: "frames from client must be masked"}
+	}
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		if _, err := io.ReadFull(c.reader, header[:2]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(header[:2]))
+	case 127:
+		if _, err := io.ReadFull(c.reader, header[:8]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(header[:8])
+	}
+	if op >= opClose && (length > 125 || !fin) {
+		return false, 0, nil, &WebSocketCloseError{Code: WebSocketProtocolError, Text: "invalid control frame"}
+	}
+	if op < opClose && (limit < 0 || length > uint64(limit)) {
+		return false, 0, nil, &WebSocketCloseError{Code: WebSocketMessageTooBig, Text: "message is too big"}
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+// WriteMessage writes a message.
+func (c *WebSocketConn) WriteMessage(typ WebSocketMessageType, data []byte) error {
+	if typ != WebSocketTextMessage && typ != WebSocketBinaryMessage {
+		return fmt.Errorf("invalid websocket message type %d", typ)
+	}
+	if err := c.Upgrade(); err != nil {
+		return err
+	}
+	if err := c.writeFrame(byte(typ), data); err != nil {
+		return c.fail(err)
+	}
+	return nil
+}
+
+// writeFrame writes an unfragmented frame. Frames from server are not masked.
+func (c *WebSocketConn) writeFrame(op byte, payload []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	if c.closeSent {
+		return errWebSocketClosed
+	}
+	header := make([]byte, 2, 10)
+	header[0] = 0x80 | op
+	switch length := len(payload); {
+	case length < 126:
+		header[1] = byte(length)
+	case length <= 0xFFFF:
+		header[1] = 126
+		header = header[:4]
+		binary.BigEndian.PutUint16(header[2:], uint16(length))
+	default:
+		header[1] = 127
+		header = header[:10]
+		binary.BigEndian.PutUint64(header[2:], uint64(length))
+	}
+	if op == opClose {
+		c.closeSent = true
+	}
+	if _, err := c.writer.Write(header); err != nil {
+		return err
+	}
+	if _, err := c.writer.Write(payload); err != nil {
+		return err
+	}
+	return c.writer.Flush()
+}
+
+// fail closes the connection for err and returns err. Protocol errors are sent
+// to client by close frames.
+func (c *WebSocketConn) fail(err error) error {
+	if e, ok := err.(*WebSocketCloseError); ok && e != errWebSocketClosed {
+		_ = c.CloseWithStatus(e.Code, e.Text)
+		return err
+	}
+	select {
+	case <-c.done:
+		// The connection is closed by server.
+		return errWebSocketClosed
+	default:
+	}
+	c.closeConn()
+	return err
+}
+
+// Close closes the connection with WebSocketNormalClosure.
+func (c *WebSocketConn) Close() error {
+	return c.CloseWithStatus(WebSocketNormalClosure, "")
+}
+
+// CloseWithStatus sends a close frame with code and text, and closes the
+// connection. Text longer than 123 bytes is truncated. If the connection has not
+// been upgraded, it's closed without upgrade. It does nothing if the connection
+// has been closed.
+func (c *WebSocketConn) CloseWithStatus(code int, text string) error {
+	if !c.Upgraded() {
+		c.closeOnce.Do(func() { close(c.done) })
+		return nil
+	}
+	if len(text) > 123 {
+		text = text[:123]
+	}
+	payload := make([]byte, 2, 2+len(text))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, text...)
+	err := c.writeFrame(opClose, payload)
+	if err == errWebSocketClosed {
+		err = nil
+	}
+	c.closeConn()
+	return err
+}
+
+// closeConn closes the underlying connection.
+func (c *WebSocketConn) closeConn() {
+	c.closeOnce.Do(func() {
+		_ = c.conn.Close()
+		close(c.done)
+	})
+}
+
+// acceptKey computes "Sec-WebSocket-Accept" for "Sec-WebSocket-Key".
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + webSocketGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// headerContainsToken checks whether a comma-separated header contains token.
+func headerContainsToken(header http.Header, name, token string) bool {
+	for _, value := range header[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WebSocketPrefab returns a *WebSocketConn for a websocket handshake. It returns a
+// bad request error if the request is not a valid handshake, and a forbidden error
+// if the origin is not allowed (see SetWebSocketOriginChecker).
+type WebSocketPrefab struct{}
+
+// Name returns prefab name.
+func (p *WebSocketPrefab) Name() string {
+	return "websocket"
+}
+
+// Type is type of *WebSocketConn.
+func (p *WebSocketPrefab) Type() reflect.Type {
+	return reflect.TypeOf((*WebSocketConn)(nil))
+}
+
+// Make checks the handshake and returns a connection which is not upgraded.
+func (p *WebSocketPrefab) Make(ctx context.Context) (interface{}, error) {
+	value := ctx.Value(contextKeyUnderlyingHTTPContext)
+	httpCtx, ok := value.(*HTTPCtx)
+	if !ok {
+		return nil, NoContext.Error()
+	}
+	req := httpCtx.Request()
+	if req.Method != http.MethodGet {
+		return nil, invalidHandshake.Error("method must be GET")
+	}
+	if !headerContainsToken(req.Header, "Connection", "upgrade") {
+		return nil, invalidHandshake.Error("header Connection must contain upgrade")
+	}
+	if !headerContainsToken(req.Header, "Upgrade", "websocket") {
+		return nil, invalidHandshake.Error("header Upgrade must contain websocket")
+	}
+	if req.Header.Get("Sec-WebSocket-Version") != "13" {
+		httpCtx.response.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, invalidHandshake.Error("header Sec-WebSocket-Version must be 13")
+	}
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return nil, invalidHandshake.Error("header Sec-WebSocket-Key is invalid")
+	}
+	if !originChecker(req) {
+		return nil, forbiddenOrigin.Error(req.Header.Get("Origin"))
+	}
+	return &WebSocketConn{
+		response:  &httpCtx.response,
+		key:       key,
+		readLimit: DefaultWebSocketReadLimit,
+		done:      make(chan struct{}),
+	}, nil
+}
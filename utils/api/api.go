@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"reflect"
 
+	"github.com/caicloud/nirvana/definition"
 	"github.com/caicloud/nirvana/service"
 	builderutil "github.com/caicloud/nirvana/service/builder"
 	"github.com/caicloud/nirvana/utils/project"
@@ -31,6 +32,9 @@ type Definitions struct {
 	Definitions map[string][]Definition
 	// Types contains all types used by definitions.
 	Types map[TypeName]*Type
+	// SecuritySchemes contains security schemes which are registered by
+	// service.RegisterSecurityScheme.
+	SecuritySchemes []definition.SecurityScheme
 }
 
 // Subset returns a subset required by a definition filter.
@@ -44,7 +48,7 @@ func (d *Definitions) Subset(require func(path string, def *Definition) bool) *D
 		}
 	}
 	if len(definitions) > 0 {
-		result := &Definitions{definitions, map[TypeName]*Type{}}
+		result := &Definitions{definitions, map[TypeName]*Type{}, d.SecuritySchemes}
 		d.complete(result)
 		return result
 	}
@@ -133,21 +137,44 @@ func (ac *Container) AddDescriptor(descriptors ...interface{}) {
 	ac.descriptors = append(ac.descriptors, descriptors...)
 }
 
-// Generate generates API definitions.
+// Generate generates API definitions. definition.Descriptor and definition.RPCDescriptor
+// are handled in RESTful and RPC styles respectively, so both of them appear in the
+// result. Descriptors of other types are handled in apiStyle.
 func (ac *Container) Generate(apiStyle string) (*Definitions, error) {
-	builder := builderutil.New(service.APIStyle(apiStyle))
-	builder.SetModifier(ac.modifiers.Combine())
-	if err := builder.AddDescriptor(ac.descriptors...); err != nil {
-		return nil, err
+	var styles []service.APIStyle
+	descriptors := map[service.APIStyle][]interface{}{}
+	for _, descriptor := range ac.descriptors {
+		style := service.APIStyle(apiStyle)
+		switch descriptor.(type) {
+		case definition.Descriptor:
+			style = service.APIStyleREST
+		case definition.RPCDescriptor:
+			style = service.APIStyleRPC
+		}
+		if _, ok := descriptors[style]; !ok {
+			styles = append(styles, style)
+		}
+		descriptors[style] = append(descriptors[style], descriptor)
 	}
-	definitions := builder.Definitions()
-	result, err := NewPathDefinitions(ac.typeContainer, definitions, service.APIStyle(apiStyle))
-	if err != nil {
-		return nil, err
+	result := map[string][]Definition{}
+	for _, style := range styles {
+		builder := builderutil.New(style)
+		builder.SetModifier(ac.modifiers.Combine())
+		if err := builder.AddDescriptor(descriptors[style]...); err != nil {
+			return nil, err
+		}
+		definitions, err := NewPathDefinitions(ac.typeContainer, builder.Definitions(), builder.APIStyle())
+		if err != nil {
+			return nil, err
+		}
+		for path, defs := range definitions {
+			result[path] = append(result[path], defs...)
+		}
 	}
-	err = ac.typeContainer.Complete(ac.analyzer)
+	err := ac.typeContainer.Complete(ac.analyzer)
 	return &Definitions{
-		Definitions: result,
-		Types:       ac.typeContainer.Types(),
+		Definitions:     result,
+		Types:           ac.typeContainer.Types(),
+		SecuritySchemes: service.SecuritySchemes(),
 	}, err
 }
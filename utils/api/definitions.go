@@ -20,6 +20,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
 
 	"github.com/caicloud/nirvana/definition"
 	"github.com/caicloud/nirvana/service"
@@ -39,6 +42,10 @@ type Parameter struct {
 	Default []byte
 	// Optional used to set whether this parameter is optional or not.
 	Optional bool
+	// Constraints describes restrictions of parameter values.
+	Constraints definition.Constraints
+	// Separator splits raw values of parameter into elements of a list.
+	Separator string
 }
 
 // Result describes a function result.
@@ -47,6 +54,8 @@ type Result struct {
 	Destination definition.Destination
 	// Description describes the result.
 	Description string
+	// Field is the key of the result in response object.
+	Field string
 	// Type is result object type.
 	Type TypeName
 }
@@ -93,6 +102,13 @@ type Definition struct {
 	Parameters []Parameter
 	// Results describes function retrun values.
 	Results []Result
+	// Deprecated indicates that the API handler is deprecated.
+	Deprecated bool
+	// Security lists security requirements of the API handler.
+	Security []definition.SecurityRequirement
+	// Response is the type of response data produced by response operators.
+	// It's empty if the definition has no response operators.
+	Response TypeName
 	// Examples contains many examples for the API handler.
 	Examples []Example
 }
@@ -103,6 +119,9 @@ func NewDefinition(tc *TypeContainer, d *definition.Definition, apiStyle service
 	if apiStyle == service.APIStyleRPC {
 		code = http.StatusOK
 	}
+	if d.SuccessCode != 0 {
+		code = d.SuccessCode
+	}
 
 	cd := &Definition{
 		Method:        d.Method,
@@ -115,10 +134,14 @@ func NewDefinition(tc *TypeContainer, d *definition.Definition, apiStyle service
 		Produces:      d.Produces,
 		ErrorProduces: d.ErrorProduces,
 		Function:      tc.NameOfInstance(d.Function),
+		Deprecated:    d.Deprecated,
+		Security:      d.Security,
 	}
 	if d.Method == definition.Any {
 		cd.HTTPMethod = string(definition.Any)
-		cd.HTTPCode = http.StatusOK
+		if d.SuccessCode == 0 {
+			cd.HTTPCode = http.StatusOK
+		}
 	}
 	functionType := tc.Type(cd.Function)
 	if len(functionType.In) != len(d.Parameters) {
@@ -134,6 +157,8 @@ func NewDefinition(tc *TypeContainer, d *definition.Definition, apiStyle service
 			Description: p.Description,
 			Type:        functionType.In[i].Type,
 			Optional:    p.Optional,
+			Constraints: definition.ConstraintsFor(p.Operators...),
+			Separator:   p.Separator,
 		}
 		if p.Default != nil {
 			data, err := encode(p.Default)
@@ -142,8 +167,8 @@ func NewDefinition(tc *TypeContainer, d *definition.Definition, apiStyle service
 			}
 			param.Default = data
 		}
-		if len(p.Operators) > 0 {
-			param.Type = tc.NameOf(p.Operators[0].In())
+		if typ := definition.InTypeOf(p.Operators, nil); typ != nil {
+			param.Type = tc.NameOf(typ)
 		}
 		cd.Parameters = append(cd.Parameters, param)
 	}
@@ -151,13 +176,17 @@ func NewDefinition(tc *TypeContainer, d *definition.Definition, apiStyle service
 		result := Result{
 			Destination: r.Destination,
 			Description: r.Description,
+			Field:       r.Field,
 			Type:        functionType.Out[i].Type,
 		}
-		if len(r.Operators) > 0 {
-			result.Type = tc.NameOf(r.Operators[len(r.Operators)-1].Out())
+		if typ := definition.OutTypeOf(r.Operators, nil); typ != nil {
+			result.Type = tc.NameOf(typ)
 		}
 		cd.Results = append(cd.Results, result)
 	}
+	if typ := definition.OutTypeOf(d.ResponseOperators, nil); typ != nil {
+		cd.Response = tc.NameOf(typ)
+	}
 	for _, e := range d.Examples {
 		example := Example{
 			Description: e.Description,
@@ -196,12 +225,58 @@ func NewPathDefinitions(tc *TypeContainer, definitions map[string][]definition.D
 		if err != nil {
 			return nil, fmt.Errorf("definitions of path %s: %w", path, err)
 		}
+		if apiStyle == service.APIStyleRPC {
+			addRPCSelectors(tc, path, cds)
+		}
 		result[path] = cds
 
 	}
 	return result, nil
 }
 
+// addRPCSelectors adds query parameters "Version" and "Action" to definitions of
+// an RPC path like "/?Version=v1&Action=Echo". RPC services select actions by them,
+// so API documents need them to distinguish actions on the same path. Parameters
+// already defined by the definitions are not added again.
+func addRPCSelectors(tc *TypeContainer, path string, definitions []Definition) {
+	index := strings.Index(path, "?")
+	if index < 0 {
+		return
+	}
+	query, err := url.ParseQuery(path[index+1:])
+	if err != nil {
+		return
+	}
+	for i := range definitions {
+		d := &definitions[i]
+		var selectors []Parameter
+		for _, key := range []string{"Version", "Action"} {
+			value := query.Get(key)
+			if value == "" || hasParameter(d.Parameters, definition.Query, key) {
+				continue
+			}
+			selectors = append(selectors, Parameter{
+				Source:      definition.Query,
+				Name:        key,
+				Description: fmt.Sprintf("%s of the RPC action", strings.ToLower(key)),
+				Type:        tc.NameOf(reflect.TypeOf(value)),
+				Constraints: definition.Constraints{Enum: []interface{}{value}},
+			})
+		}
+		d.Parameters = append(selectors, d.Parameters...)
+	}
+}
+
+// hasParameter checks whether parameters contain a parameter with source and name.
+func hasParameter(parameters []Parameter, source definition.Source, name string) bool {
+	for _, p := range parameters {
+		if p.Source == source && p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
 // encode encodes instance to json format.
 func encode(ins interface{}) ([]byte, error) {
 	return json.Marshal(ins)
@@ -396,8 +396,9 @@ func (h *helper) Functions() ([]function, []string) {
 					Typ:          h.namer.Name(param.Type),
 				}
 				types = append(types, h.definitions.Types[param.Type])
-				if param.Source == definition.Body {
+				if param.Source == definition.Body || param.Source == definition.RawBody {
 					// Use first consumer as the name of body parameter.
+					p.Source = string(definition.Body)
 					p.Name = firstNonEmptyConsume
 				}
 				if param.Source == definition.Auto {
@@ -409,6 +410,9 @@ func (h *helper) Functions() ([]function, []string) {
 								// Ignore invalid source tag.
 								return
 							}
+							if name == "" {
+								name = field.Tag.Get("name")
+							}
 							extension := parameterExtension{
 								Source: string(source),
 								Name:   name,
@@ -0,0 +1,378 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openapi
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/caicloud/nirvana/definition"
+	"github.com/caicloud/nirvana/utils/api"
+	"github.com/caicloud/nirvana/utils/generators/swagger"
+	"github.com/caicloud/nirvana/utils/project"
+
+	"github.com/go-openapi/spec"
+)
+
+const (
+	definitionsPrefix = "#/definitions/"
+	componentsPrefix  = "#/components/schemas/"
+)
+
+// invalidComponentChars matches characters which are not allowed in component names.
+var invalidComponentChars = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
+
+// Generator is for generating OpenAPI 3.0 documents. It walks definitions by the
+// swagger generator and converts the swagger specifications, so that both versions
+// describe APIs in the same way.
+type Generator struct {
+	swagger *swagger.Generator
+}
+
+// NewDefaultGenerator creates an OpenAPI 3.0 generator with default mappings.
+func NewDefaultGenerator(
+	config *project.Config,
+	apis *api.Definitions,
+) *Generator {
+	return &Generator{
+		swagger: swagger.NewDefaultGenerator(config, apis),
+	}
+}
+
+// Generate generates OpenAPI 3.0 documents. Keys of the result are the same as
+// swagger generator.
+func (g *Generator) Generate() (map[string]Document, error) {
+	swaggers, err := g.swagger.Generate()
+	if err != nil {
+		return nil, err
+	}
+	documents := make(map[string]Document, len(swaggers))
+	for name, s := range swaggers {
+		s := s
+		documents[name] = *Convert(&s)
+	}
+	return documents, nil
+}
+
+// Convert converts a swagger 2.0 specification to an OpenAPI 3.0 document.
+func Convert(s *spec.Swagger) *Document {
+	c := &converter{swagger: s}
+	doc := &Document{
+		OpenAPI: Version,
+		Info:    s.Info,
+		Servers: c.servers(),
+		Paths:   map[string]*PathItem{},
+		Tags:    s.Tags,
+	}
+	if s.Paths != nil {
+		for path, item := range s.Paths.Paths {
+			item := item
+			doc.Paths[path] = c.pathItem(&item)
+		}
+	}
+	if len(s.Definitions) > 0 {
+		doc.Components = &Components{
+			Schemas: make(map[string]*spec.Schema, len(s.Definitions)),
+		}
+		for name, schema := range s.Definitions {
+			schema := schema
+			doc.Components.Schemas[componentName(name)] = c.schema(&schema)
+		}
+	}
+	if len(s.SecurityDefinitions) > 0 {
+		if doc.Components == nil {
+			doc.Components = &Components{}
+		}
+		doc.Components.SecuritySchemes = make(map[string]*SecurityScheme, len(s.SecurityDefinitions))
+		for name, scheme := range s.SecurityDefinitions {
+			doc.Components.SecuritySchemes[name] = securityScheme(scheme)
+		}
+	}
+	return doc
+}
+
+// securityScheme converts a security scheme of swagger. Extensions of bearer
+// tokens and API keys in cookies are converted to native schemes.
+func securityScheme(scheme *spec.SecurityScheme) *SecurityScheme {
+	result := &SecurityScheme{
+		Type:        scheme.Type,
+		Description: scheme.Description,
+		Name:        scheme.Name,
+		In:          scheme.In,
+	}
+	switch scheme.Type {
+	case "basic":
+		result.Type = "http"
+		result.Scheme = "basic"
+	case "apiKey":
+		if value, ok := scheme.Extensions.GetString("x-scheme"); ok {
+			result = &SecurityScheme{Type: "http", Description: scheme.Description, Scheme: value}
+			result.BearerFormat, _ = scheme.Extensions.GetString("x-bearer-format")
+		} else if value, ok := scheme.Extensions.GetString("x-cookie"); ok {
+			result.Name = value
+			result.In = "cookie"
+		}
+	}
+	return result
+}
+
+func componentName(name string) string {
+	return invalidComponentChars.ReplaceAllString(name, "_")
+}
+
+type converter struct {
+	swagger *spec.Swagger
+}
+
+func (c *converter) servers() []Server {
+	base := c.swagger.BasePath
+	if c.swagger.Host == "" {
+		if base == "" {
+			return nil
+		}
+		return []Server{{URL: base}}
+	}
+	schemes := c.swagger.Schemes
+	if len(schemes) <= 0 {
+		schemes = []string{"http"}
+	}
+	servers := make([]Server, 0, len(schemes))
+	for _, scheme := range schemes {
+		servers = append(servers, Server{URL: scheme + "://" + c.swagger.Host + base})
+	}
+	return servers
+}
+
+func (c *converter) pathItem(item *spec.PathItem) *PathItem {
+	return &PathItem{
+		Get:     c.operation(item.Get),
+		Put:     c.operation(item.Put),
+		Post:    c.operation(item.Post),
+		Delete:  c.operation(item.Delete),
+		Options: c.operation(item.Options),
+		Head:    c.operation(item.Head),
+		Patch:   c.operation(item.Patch),
+	}
+}
+
+func (c *converter) operation(op *spec.Operation) *Operation {
+	if op == nil {
+		return nil
+	}
+	consumes := op.Consumes
+	if len(consumes) <= 0 {
+		consumes = c.swagger.Consumes
+	}
+	produces := op.Produces
+	if len(produces) <= 0 {
+		produces = c.swagger.Produces
+	}
+	result := &Operation{
+		Tags:        op.Tags,
+		Summary:     op.Summary,
+		Description: op.Description,
+		OperationID: op.ID,
+		Deprecated:  op.Deprecated,
+		Security:    op.Security,
+		Responses:   map[string]*Response{},
+	}
+	var form []spec.Parameter
+	for _, p := range op.Parameters {
+		switch p.In {
+		case "body":
+			result.RequestBody = &RequestBody{
+				Description: p.Description,
+				Required:    p.Required,
+				Content:     c.content(consumes, c.schema(p.Schema), nil),
+			}
+		case "formData":
+			form = append(form, p)
+		default:
+			result.Parameters = append(result.Parameters, Parameter{
+				Name:        p.Name,
+				In:          p.In,
+				Description: p.Description,
+				// Path parameters are always required in OpenAPI 3.0.
+				Required: p.Required || p.In == "path",
+				Schema:   simpleSchema(&p.SimpleSchema, &p.CommonValidations),
+			})
+		}
+	}
+	if len(form) > 0 {
+		result.RequestBody = c.formBody(consumes, form)
+	}
+	if op.Responses != nil {
+		if op.Responses.Default != nil {
+			result.Responses["default"] = c.response(op.Responses.Default, produces)
+		}
+		for code, resp := range op.Responses.StatusCodeResponses {
+			resp := resp
+			result.Responses[strconv.Itoa(code)] = c.response(&resp, produces)
+		}
+	}
+	return result
+}
+
+func (c *converter) formBody(consumes []string, params []spec.Parameter) *RequestBody {
+	schema := new(spec.Schema).Typed("object", "")
+	hasFile := false
+	for _, p := range params {
+		if p.Type == "file" {
+			hasFile = true
+		}
+		property := simpleSchema(&p.SimpleSchema, &p.CommonValidations)
+		property.Description = p.Description
+		schema.SetProperty(p.Name, *property)
+		if p.Required {
+			schema.Required = append(schema.Required, p.Name)
+		}
+	}
+	var types []string
+	for _, ct := range consumes {
+		if ct == definition.MIMEURLEncoded || ct == definition.MIMEFormData {
+			types = append(types, ct)
+		}
+	}
+	if len(types) <= 0 {
+		if hasFile {
+			types = []string{definition.MIMEFormData}
+		} else {
+			types = []string{definition.MIMEURLEncoded}
+		}
+	}
+	return &RequestBody{
+		Required: len(schema.Required) > 0,
+		Content:  c.content(types, schema, nil),
+	}
+}
+
+func (c *converter) response(r *spec.Response, produces []string) *Response {
+	resp := &Response{
+		Description: r.Description,
+	}
+	if len(r.Headers) > 0 {
+		resp.Headers = make(map[string]Header, len(r.Headers))
+		for name, h := range r.Headers {
+			h := h
+			resp.Headers[name] = Header{
+				Description: h.Description,
+				Schema:      simpleSchema(&h.SimpleSchema, &h.CommonValidations),
+			}
+		}
+	}
+	if r.Schema != nil {
+		resp.Content = c.content(produces, c.schema(r.Schema), r.Examples)
+	}
+	return resp
+}
+
+func (c *converter) content(types []string, schema *spec.Schema, examples map[string]interface{}) map[string]MediaType {
+	if len(types) <= 0 {
+		types = []string{definition.MIMEJSON}
+	}
+	sorted := make([]string, len(types))
+	copy(sorted, types)
+	sort.Strings(sorted)
+	content := make(map[string]MediaType, len(sorted))
+	for _, ct := range sorted {
+		content[ct] = MediaType{
+			Schema:  schema,
+			Example: examples[ct],
+		}
+	}
+	return content
+}
+
+// schema returns a deep copy of s with references pointing to components.
+func (c *converter) schema(s *spec.Schema) *spec.Schema {
+	if s == nil {
+		return nil
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil
+	}
+	result := &spec.Schema{}
+	if err := json.Unmarshal(data, result); err != nil {
+		return nil
+	}
+	rewriteRefs(result)
+	return result
+}
+
+func rewriteRefs(s *spec.Schema) {
+	if ref := s.Ref.String(); strings.HasPrefix(ref, definitionsPrefix) {
+		s.Ref = spec.MustCreateRef(componentsPrefix + componentName(strings.TrimPrefix(ref, definitionsPrefix)))
+	}
+	for name, property := range s.Properties {
+		rewriteRefs(&property)
+		s.Properties[name] = property
+	}
+	if s.Items != nil {
+		if s.Items.Schema != nil {
+			rewriteRefs(s.Items.Schema)
+		}
+		for i := range s.Items.Schemas {
+			rewriteRefs(&s.Items.Schemas[i])
+		}
+	}
+	if s.AdditionalProperties != nil && s.AdditionalProperties.Schema != nil {
+		rewriteRefs(s.AdditionalProperties.Schema)
+	}
+	for _, schemas := range [][]spec.Schema{s.AllOf, s.AnyOf, s.OneOf} {
+		for i := range schemas {
+			rewriteRefs(&schemas[i])
+		}
+	}
+	if s.Not != nil {
+		rewriteRefs(s.Not)
+	}
+}
+
+// simpleSchema converts a swagger simple schema to a schema object.
+func simpleSchema(s *spec.SimpleSchema, v *spec.CommonValidations) *spec.Schema {
+	schema := &spec.Schema{}
+	switch s.Type {
+	case "":
+	case "file":
+		schema.Typed("string", "binary")
+	default:
+		schema.Typed(s.Type, s.Format)
+	}
+	schema.Default = s.Default
+	if s.Items != nil {
+		schema.Items = &spec.SchemaOrArray{
+			Schema: simpleSchema(&s.Items.SimpleSchema, &s.Items.CommonValidations),
+		}
+	}
+	schema.Maximum = v.Maximum
+	schema.ExclusiveMaximum = v.ExclusiveMaximum
+	schema.Minimum = v.Minimum
+	schema.ExclusiveMinimum = v.ExclusiveMinimum
+	schema.MaxLength = v.MaxLength
+	schema.MinLength = v.MinLength
+	schema.Pattern = v.Pattern
+	schema.MaxItems = v.MaxItems
+	schema.MinItems = v.MinItems
+	schema.UniqueItems = v.UniqueItems
+	schema.MultipleOf = v.MultipleOf
+	schema.Enum = v.Enum
+	return schema
+}
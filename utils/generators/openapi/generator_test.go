@@ -0,0 +1,140 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openapi
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-openapi/spec"
+)
+
+func TestConvert(t *testing.T) {
+	ref := spec.RefSchema(definitionsPrefix + "example.com_api.Application")
+	query := spec.QueryParam("name").Typed("string", "string")
+	query.Pattern = "^[a-z]+$"
+	s := &spec.Swagger{
+		SwaggerProps: spec.SwaggerProps{
+			Swagger: "2.0",
+			Host:    "localhost:8080",
+			Info:    &spec.Info{InfoProps: spec.InfoProps{Title: "test", Version: "v1"}},
+			Definitions: spec.Definitions{
+				"example.com_api.Application": *new(spec.Schema).
+					Typed("object", "").
+					SetProperty("children", *spec.ArrayProperty(ref)),
+			},
+			Paths: &spec.Paths{Paths: map[string]spec.PathItem{
+				"/api/v1/applications/{app}": {PathItemProps: spec.PathItemProps{
+					Put: &spec.Operation{OperationProps: spec.OperationProps{
+						Consumes: []string{"application/json"},
+						Produces: []string{"application/json"},
+						Parameters: []spec.Parameter{
+							*spec.PathParam("app").Typed("string", "string"),
+							*query,
+							*spec.BodyParam("body", ref),
+						},
+						Responses: &spec.Responses{ResponsesProps: spec.ResponsesProps{
+							StatusCodeResponses: map[int]spec.Response{
+								200: *spec.NewResponse().WithDescription("ok").WithSchema(ref),
+							},
+						}},
+					}},
+					Post: &spec.Operation{OperationProps: spec.OperationProps{
+						Parameters: []spec.Parameter{
+							*spec.FileParam("file").AsRequired(),
+						},
+					}},
+				}},
+			}},
+		},
+	}
+	doc := Convert(s)
+	if doc.OpenAPI != Version || len(doc.Servers) != 1 || doc.Servers[0].URL != "http://localhost:8080" {
+		t.Fatalf("Document has wrong metadata: %+v", doc)
+	}
+	want := componentsPrefix + "example.com_api.Application"
+	schema := doc.Components.Schemas["example.com_api.Application"]
+	if schema == nil || schema.Properties["children"].Items.Schema.Ref.String() != want {
+		t.Fatalf("References in components are not converted: %+v", schema)
+	}
+
+	item := doc.Paths["/api/v1/applications/{app}"]
+	put := item.Put
+	if len(put.Parameters) != 2 {
+		t.Fatalf("Operation has wrong parameters: %+v", put.Parameters)
+	}
+	if p := put.Parameters[0]; p.In != "path" || !p.Required {
+		t.Fatalf("Path parameter is not converted: %+v", p)
+	}
+	if p := put.Parameters[1]; p.In != "query" || p.Schema.Pattern != "^[a-z]+$" {
+		t.Fatalf("Query parameter is not converted: %+v", p)
+	}
+	if put.RequestBody == nil || put.RequestBody.Content["application/json"].Schema.Ref.String() != want {
+		t.Fatalf("Body parameter is not converted: %+v", put.RequestBody)
+	}
+	if r := put.Responses["200"]; r == nil || r.Content["application/json"].Schema.Ref.String() != want {
+		t.Fatalf("Response is not converted: %+v", r)
+	}
+	// The original specification should not be modified.
+	if ref.Ref.String() != definitionsPrefix+"example.com_api.Application" {
+		t.Fatalf("Original reference is modified: %s", ref.Ref.String())
+	}
+
+	post := item.Post
+	media, ok := post.RequestBody.Content["multipart/form-data"]
+	if !ok || media.Schema.Properties["file"].Format != "binary" || !post.RequestBody.Required {
+		t.Fatalf("Form parameter is not converted: %+v", post.RequestBody)
+	}
+}
+
+func TestConvertSecurity(t *testing.T) {
+	bearer := spec.APIKeyAuth("Authorization", "header")
+	bearer.AddExtension("x-scheme", "bearer")
+	bearer.AddExtension("x-bearer-format", "JWT")
+	session := spec.APIKeyAuth("Cookie", "header")
+	session.AddExtension("x-cookie", "sid")
+	s := &spec.Swagger{
+		SwaggerProps: spec.SwaggerProps{
+			Swagger: "2.0",
+			Info:    &spec.Info{InfoProps: spec.InfoProps{Title: "test", Version: "v1"}},
+			SecurityDefinitions: spec.SecurityDefinitions{
+				"bearer":  bearer,
+				"session": session,
+				"key":     spec.APIKeyAuth("X-API-Key", "header"),
+				"basic":   spec.BasicAuth(),
+			},
+			Paths: &spec.Paths{Paths: map[string]spec.PathItem{
+				"/api/v1/users": {PathItemProps: spec.PathItemProps{
+					Post: new(spec.Operation).SecuredWith("bearer", "users:write"),
+				}},
+			}},
+		},
+	}
+	doc := Convert(s)
+	if security := doc.Paths["/api/v1/users"].Post.Security; !reflect.DeepEqual(security, []map[string][]string{{"bearer": {"users:write"}}}) {
+		t.Fatalf("Security of operation is not desired: %v", security)
+	}
+	want := map[string]*SecurityScheme{
+		"bearer":  {Type: "http", Scheme: "bearer", BearerFormat: "JWT"},
+		"session": {Type: "apiKey", Name: "sid", In: "cookie"},
+		"key":     {Type: "apiKey", Name: "X-API-Key", In: "header"},
+		"basic":   {Type: "http", Scheme: "basic"},
+	}
+	if doc.Components == nil || !reflect.DeepEqual(doc.Components.SecuritySchemes, want) {
+		t.Fatalf("Security schemes are not desired: %+v", doc.Components)
+	}
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openapi
+
+import (
+	"github.com/go-openapi/spec"
+)
+
+// Version is the OpenAPI version of generated documents.
+const Version = "3.0.3"
+
+// Document is the root object of an OpenAPI 3.0 document.
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       *spec.Info           `json:"info"`
+	Servers    []Server             `json:"servers,omitempty"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components *Components          `json:"components,omitempty"`
+	Tags       []spec.Tag           `json:"tags,omitempty"`
+}
+
+// Server describes a server which hosts the APIs.
+type Server struct {
+	URL         string `json:"url"`
+	Description string `json:"description,omitempty"`
+}
+
+// PathItem describes the operations available on a single path.
+type PathItem struct {
+	Get     *Operation `json:"get,omitempty"`
+	Put     *Operation `json:"put,omitempty"`
+	Post    *Operation `json:"post,omitempty"`
+	Delete  *Operation `json:"delete,omitempty"`
+	Options *Operation `json:"options,omitempty"`
+	Head    *Operation `json:"head,omitempty"`
+	Patch   *Operation `json:"patch,omitempty"`
+}
+
+// Operation describes a single API operation on a path.
+type Operation struct {
+	Tags        []string              `json:"tags,omitempty"`
+	Summary     string                `json:"summary,omitempty"`
+	Description string                `json:"description,omitempty"`
+	OperationID string                `json:"operationId,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]*Response  `json:"responses"`
+	Deprecated  bool                  `json:"deprecated,omitempty"`
+	Security    []map[string][]string `json:"security,omitempty"`
+}
+
+// Parameter describes a single operation parameter. Body and form parameters
+// are described by RequestBody.
+type Parameter struct {
+	Name        string       `json:"name"`
+	In          string       `json:"in"`
+	Description string       `json:"description,omitempty"`
+	Required    bool         `json:"required,omitempty"`
+	Deprecated  bool         `json:"deprecated,omitempty"`
+	Schema      *spec.Schema `json:"schema,omitempty"`
+}
+
+// RequestBody describes a request body.
+type RequestBody struct {
+	Description string               `json:"description,omitempty"`
+	Required    bool                 `json:"required,omitempty"`
+	Content     map[string]MediaType `json:"content"`
+}
+
+// MediaType provides schema and example for a media type.
+type MediaType struct {
+	Schema  *spec.Schema `json:"schema,omitempty"`
+	Example interface{}  `json:"example,omitempty"`
+}
+
+// Response describes a single response from an API operation.
+type Response struct {
+	Description string               `json:"description"`
+	Headers     map[string]Header    `json:"headers,omitempty"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// Header describes a response header.
+type Header struct {
+	Description string       `json:"description,omitempty"`
+	Schema      *spec.Schema `json:"schema,omitempty"`
+}
+
+// Components holds reusable objects of a document.
+type Components struct {
+	Schemas         map[string]*spec.Schema    `json:"schemas,omitempty"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// SecurityScheme describes a security scheme which can be used by operations.
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Description  string `json:"description,omitempty"`
+	Name         string `json:"name,omitempty"`
+	In           string `json:"in,omitempty"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+}
@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"net/http"
 	"reflect"
+	"sort"
 	"strings"
 
 	"github.com/caicloud/nirvana/definition"
@@ -36,16 +37,20 @@ var defaultSourceMapping = map[definition.Source]string{
 	definition.Path:   "path",
 	definition.Query:  "query",
 	definition.Header: "header",
-	definition.Form:   "formData",
-	definition.File:   "formData",
-	definition.Body:   "body",
-	definition.Prefab: "",
+	// Swagger 2.0 has no location for cookies.
+	definition.Cookie:  "",
+	definition.Form:    "formData",
+	definition.File:    "formData",
+	definition.Body:    "body",
+	definition.RawBody: "body",
+	definition.Prefab:  "",
 }
 
 var defaultDestinationMapping = map[definition.Destination]string{
-	definition.Meta:  "header",
-	definition.Data:  "body",
-	definition.Error: "",
+	definition.Meta:       "header",
+	definition.Data:       "body",
+	definition.Error:      "",
+	definition.Attachment: "file",
 }
 
 // Generator is for generating swagger specifications.
@@ -186,9 +191,92 @@ func (g *Generator) buildSwaggerInfo(
 			swagger.Paths.Paths[path] = *item
 		}
 	}
+	swagger.Tags = g.tagsFor(swagger.Paths)
+	swagger.SecurityDefinitions = g.securityDefinitionsFor(swagger.Paths)
 	return swagger
 }
 
+// securityDefinitionsFor collects security schemes which are required by
+// operations in paths.
+func (g *Generator) securityDefinitionsFor(paths *spec.Paths) spec.SecurityDefinitions {
+	names := map[string]bool{}
+	for _, item := range paths.Paths {
+		for _, op := range []*spec.Operation{item.Get, item.Put, item.Post, item.Delete, item.Options, item.Head, item.Patch} {
+			if op == nil {
+				continue
+			}
+			for _, requirement := range op.Security {
+				for name := range requirement {
+					names[name] = true
+				}
+			}
+		}
+	}
+	if len(names) <= 0 {
+		return nil
+	}
+	definitions := spec.SecurityDefinitions{}
+	for _, scheme := range g.apis.SecuritySchemes {
+		if names[scheme.Name] {
+			definitions[scheme.Name] = securitySchemeFor(&scheme)
+		}
+	}
+	return definitions
+}
+
+// securitySchemeFor converts a security scheme. Swagger 2.0 only has basic
+// authentication and API keys in headers or queries, so other schemes are
+// described as API keys in headers with extensions. Bearer tokens are in header
+// "Authorization" with "x-scheme" and "x-bearer-format", and API keys in
+// cookies are in header "Cookie" with "x-cookie" (the name of the cookie).
+func securitySchemeFor(scheme *definition.SecurityScheme) *spec.SecurityScheme {
+	var result *spec.SecurityScheme
+	switch {
+	case scheme.Type == definition.HTTPAuthentication && strings.EqualFold(scheme.Scheme, "basic"):
+		result = spec.BasicAuth()
+	case scheme.Type == definition.HTTPAuthentication:
+		result = spec.APIKeyAuth("Authorization", "header")
+		result.AddExtension("x-scheme", strings.ToLower(scheme.Scheme))
+		if scheme.BearerFormat != "" {
+			result.AddExtension("x-bearer-format", scheme.BearerFormat)
+		}
+	case scheme.In == definition.Cookie:
+		result = spec.APIKeyAuth("Cookie", "header")
+		result.AddExtension("x-cookie", scheme.Key)
+	default:
+		result = spec.APIKeyAuth(scheme.Key, strings.ToLower(string(scheme.In)))
+	}
+	result.Description = scheme.Description
+	return result
+}
+
+// tagsFor collects tags of all operations in paths. Tags are sorted by name so
+// that documents group operations in a stable order.
+func (g *Generator) tagsFor(paths *spec.Paths) []spec.Tag {
+	names := map[string]bool{}
+	for _, item := range paths.Paths {
+		for _, op := range []*spec.Operation{item.Get, item.Put, item.Post, item.Delete, item.Options, item.Head, item.Patch} {
+			if op == nil {
+				continue
+			}
+			for _, name := range op.Tags {
+				names[name] = true
+			}
+		}
+	}
+	if len(names) <= 0 {
+		return nil
+	}
+	tags := make([]spec.Tag, 0, len(names))
+	for name := range names {
+		tags = append(tags, spec.NewTag(name, "", nil))
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i].Name < tags[j].Name
+	})
+	return tags
+}
+
 func (g *Generator) parseSchemas() {
 	for _, typ := range g.apis.Types {
 		g.schemaForType(typ)
@@ -199,7 +287,8 @@ func (g *Generator) schemaForType(typ *api.Type) *spec.Schema {
 	schema, ok := g.schemaMappings[typ.TypeName()]
 	if !ok {
 		switch typ.Kind {
-		case reflect.Array, reflect.Slice:
+		case reflect.Array, reflect.Slice, reflect.Chan:
+			// Values of channels (such as streams of bodies) are described as arrays.
 			elem := g.schemaForTypeName(typ.Elem)
 			if elem == nil {
 				break
@@ -427,6 +516,10 @@ func (g *Generator) operationFor(def *api.Definition) *spec.Operation {
 		}
 	}
 	operation.Description = g.escapeNewline(operation.Description)
+	operation.Deprecated = def.Deprecated
+	for _, r := range def.Security {
+		operation.SecuredWith(r.Scheme, append([]string{}, r.Scopes...)...)
+	}
 	for _, param := range def.Parameters {
 		parameters := g.generateParameter(&param)
 		if len(parameters) > 0 {
@@ -436,13 +529,21 @@ func (g *Generator) operationFor(def *api.Definition) *spec.Operation {
 	operation.Responses = &spec.Responses{
 		ResponsesProps: spec.ResponsesProps{
 			StatusCodeResponses: map[int]spec.Response{
-				def.HTTPCode: *g.generateResponse(def.Results, def.Examples),
+				def.HTTPCode: *g.generateResponse(def.Results, def.Response, def.Examples),
 			},
 		},
 	}
 	return operation
 }
 
+// collectionFormats maps parameter separators to collection formats of swagger.
+var collectionFormats = map[string]string{
+	",":  "csv",
+	" ":  "ssv",
+	"\t": "tsv",
+	"|":  "pipes",
+}
+
 func (g *Generator) generateParameter(param *api.Parameter) []spec.Parameter {
 	if param.Source == definition.Auto {
 		return g.generateAutoParameter(param.Type)
@@ -475,11 +576,15 @@ func (g *Generator) generateParameter(param *api.Parameter) []spec.Parameter {
 			// CollectionFormat has two valid valus: csv, multi.
 			// But we don't known which one should be used. So unknown.
 			parameter.CollectionFormat = "unknown"
+			if format, ok := collectionFormats[param.Separator]; ok {
+				parameter.CollectionFormat = format
+			}
 			parameter.Items = &spec.Items{}
 			parameter.Items.Type = schema.Items.Schema.Type[0]
 			parameter.Items.Format = schema.Items.Schema.Format
 		}
 		parameter.Schema = nil
+		g.applyConstraints(&parameter.CommonValidations, param.Constraints)
 	} else {
 		// add parameter name for body, it required by swagger ui,
 		// cause api.Parameter.Name is always nil when In is body
@@ -494,6 +599,15 @@ func (g *Generator) generateParameter(param *api.Parameter) []spec.Parameter {
 	return []spec.Parameter{parameter}
 }
 
+func (g *Generator) applyConstraints(v *spec.CommonValidations, c definition.Constraints) {
+	v.Pattern = c.Pattern
+	v.Enum = c.Enum
+	v.Minimum = c.Minimum
+	v.Maximum = c.Maximum
+	v.MinLength = c.MinLength
+	v.MaxLength = c.MaxLength
+}
+
 func (g *Generator) generateAutoParameter(typ api.TypeName) []spec.Parameter {
 	structType, ok := g.apis.Types[typ]
 	if !ok {
@@ -552,6 +666,9 @@ func (g *Generator) enum(typ *api.Type) []spec.Parameter {
 		parameters := []spec.Parameter(nil)
 		if tag != "" {
 			source, name, apc, err := service.ParseAutoParameterTag(tag)
+			if name == "" {
+				name = field.Tag.Get("name")
+			}
 			rawDefaultValue, defaultExist := apc.Get(service.AutoParameterConfigKeyDefaultValue)
 			var defaultValue []byte
 			if c := converters[string(field.Type)]; defaultExist && c != nil {
@@ -599,19 +716,37 @@ func parseDestination(d definition.Destination) definition.Destination {
 	}
 }
 
-func (g *Generator) generateResponse(results []api.Result, examples []api.Example) *spec.Response {
+func (g *Generator) generateResponse(results []api.Result, responseType api.TypeName, examples []api.Example) *spec.Response {
 	response := &spec.Response{}
 	for _, result := range results {
 		switch g.destinationMapping[parseDestination(result.Destination)] {
 		case "body":
-			response.Description = g.escapeNewline(result.Description)
 			schema := g.schemaForTypeName(result.Type)
 			// responses.xx.schema should NOT have additional properties
 			// additionalProperty: title
 			schema.Title = ""
-			response.Schema = schema
+			if result.Field == "" {
+				response.Description = g.escapeNewline(result.Description)
+				response.Schema = schema
+				continue
+			}
+			// Data results with fields are assembled into an object.
+			if response.Schema == nil {
+				response.Schema = new(spec.Schema).Typed("object", "")
+			}
+			schema.Description = g.escapeNewline(result.Description)
+			response.Schema.SetProperty(result.Field, *schema)
+		case "file":
+			response.Description = g.escapeNewline(result.Description)
+			response.Schema = new(spec.Schema).Typed("file", "")
 		}
 	}
+	if responseType != "" && response.Schema != nil {
+		// Response operators replace the data of results.
+		schema := g.schemaForTypeName(responseType)
+		schema.Title = ""
+		response.Schema = schema
+	}
 	for _, example := range examples {
 		if len(example.Instance) > 0 {
 			// Only show the first example which has data.
@@ -0,0 +1,186 @@
+/*
+Copyright 2020 Caicloud Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package swagger
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/caicloud/nirvana/definition"
+	"github.com/caicloud/nirvana/service"
+	"github.com/caicloud/nirvana/service/rpc"
+	"github.com/caicloud/nirvana/utils/api"
+	"github.com/caicloud/nirvana/utils/project"
+)
+
+func TestGenerateTags(t *testing.T) {
+	apis := &api.Definitions{
+		Types: map[api.TypeName]*api.Type{},
+		Definitions: map[string][]api.Definition{
+			"/api/v1/apps": {
+				{Method: definition.List, HTTPMethod: http.MethodGet, HTTPCode: http.StatusOK, Tags: []string{"apps"}},
+				{Method: definition.Create, HTTPMethod: http.MethodPost, HTTPCode: http.StatusCreated, Tags: []string{"apps", "apps"}},
+			},
+			"/api/v1/users": {
+				{Method: definition.List, HTTPMethod: http.MethodGet, HTTPCode: http.StatusOK, Tags: []string{"users", "admin"}},
+			},
+			"/healthz": {
+				{Method: definition.Get, HTTPMethod: http.MethodGet, HTTPCode: http.StatusOK},
+			},
+		},
+	}
+	swaggers, err := NewDefaultGenerator(&project.Config{Project: "test"}, apis).Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := swaggers["unknown"]
+	names := []string{}
+	for _, tag := range s.Tags {
+		names = append(names, tag.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"admin", "apps", "users"}) {
+		t.Fatalf("Tags of swagger are not desired: %v", names)
+	}
+	apps := s.Paths.Paths["/api/v1/apps"]
+	if !reflect.DeepEqual(apps.Get.Tags, []string{"apps"}) || !reflect.DeepEqual(apps.Post.Tags, []string{"apps"}) {
+		t.Fatalf("Operations are not grouped by tags: %v, %v", apps.Get.Tags, apps.Post.Tags)
+	}
+	if tags := s.Paths.Paths["/api/v1/users"].Get.Tags; !reflect.DeepEqual(tags, []string{"users", "admin"}) {
+		t.Fatalf("Operations are not grouped by tags: %v", tags)
+	}
+	if tags := s.Paths.Paths["/healthz"].Get.Tags; len(tags) != 0 {
+		t.Fatalf("Operations without tags should not be grouped: %v", tags)
+	}
+}
+
+func TestGenerateRPC(t *testing.T) {
+	builder := rpc.NewBuilder()
+	echo := func(ctx context.Context, message string) (string, error) { return message, nil }
+	if err := builder.AddDescriptor(definition.RPCDescriptor{
+		Path:     "/",
+		Consumes: []string{definition.MIMEURLEncoded},
+		Produces: []string{definition.MIMEJSON},
+		Actions: []definition.RPCAction{
+			{
+				Name:       "Echo",
+				Version:    "2020-10-10",
+				Tags:       []string{"echo"},
+				Function:   echo,
+				Parameters: []definition.Parameter{definition.FormParameterFor("message", "")},
+				Results:    definition.DataErrorResults(""),
+			},
+			{
+				Name:       "Echo",
+				Version:    "2020-12-12",
+				Function:   echo,
+				Parameters: []definition.Parameter{definition.FormParameterFor("message", "")},
+				Results:    definition.DataErrorResults(""),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	builder.SetModifier(service.FirstContextParameter())
+	tc := api.NewTypeContainer()
+	definitions, err := api.NewPathDefinitions(tc, builder.Definitions(), builder.APIStyle())
+	if err != nil {
+		t.Fatal(err)
+	}
+	swaggers, err := NewDefaultGenerator(&project.Config{Project: "test"}, &api.Definitions{
+		Definitions: definitions,
+		Types:       tc.Types(),
+	}).Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := swaggers["unknown"].Paths.Paths
+	if len(paths) != 2 {
+		t.Fatalf("RPC actions should have their own paths: %v", paths)
+	}
+	for _, version := range []string{"2020-10-10", "2020-12-12"} {
+		item, ok := paths["/?Version="+version+"&Action=Echo"]
+		if !ok || item.Post == nil || item.Get != nil {
+			t.Fatalf("RPC action of version %s should be a POST operation: %+v", version, item)
+		}
+		op := item.Post
+		if op.Summary != "Echo" || !reflect.DeepEqual(op.Consumes, []string{definition.MIMEURLEncoded}) ||
+			!reflect.DeepEqual(op.Produces, []string{definition.MIMEJSON}) {
+			t.Fatalf("Operation of version %s is not desired: %+v", version, op)
+		}
+		if _, ok := op.Responses.StatusCodeResponses[http.StatusOK]; !ok {
+			t.Fatalf("RPC action should respond 200: %v", op.Responses.StatusCodeResponses)
+		}
+		names := []string{}
+		for _, p := range op.Parameters {
+			names = append(names, p.In+":"+p.Name)
+		}
+		if !reflect.DeepEqual(names, []string{"query:Version", "query:Action", "formData:message"}) {
+			t.Fatalf("Parameters of version %s are not desired: %v", version, names)
+		}
+		if !reflect.DeepEqual(op.Parameters[0].Enum, []interface{}{version}) ||
+			!reflect.DeepEqual(op.Parameters[1].Enum, []interface{}{"Echo"}) || !op.Parameters[1].Required {
+			t.Fatalf("Selectors of version %s are not desired: %+v", version, op.Parameters[:2])
+		}
+	}
+}
+
+func TestGenerateSecurity(t *testing.T) {
+	apis := &api.Definitions{
+		Types: map[api.TypeName]*api.Type{},
+		Definitions: map[string][]api.Definition{
+			"/api/v1/users": {
+				{Method: definition.List, HTTPMethod: http.MethodGet, HTTPCode: http.StatusOK},
+				{Method: definition.Create, HTTPMethod: http.MethodPost, HTTPCode: http.StatusCreated, Security: []definition.SecurityRequirement{
+					definition.SecurityFor("bearer", "users:write"),
+					definition.SecurityFor("session"),
+				}},
+			},
+		},
+		SecuritySchemes: []definition.SecurityScheme{
+			definition.BearerScheme("bearer", "JWT"),
+			definition.APIKeyScheme("session", definition.Cookie, "sid"),
+			definition.APIKeyScheme("unused", definition.Header, "X-API-Key"),
+		},
+	}
+	swaggers, err := NewDefaultGenerator(&project.Config{Project: "test"}, apis).Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := swaggers["unknown"]
+	item := s.Paths.Paths["/api/v1/users"]
+	if item.Get.Security != nil {
+		t.Fatalf("Public operation should have no security: %v", item.Get.Security)
+	}
+	want := []map[string][]string{{"bearer": {"users:write"}}, {"session": {}}}
+	if !reflect.DeepEqual(item.Post.Security, want) {
+		t.Fatalf("Security of operation is not desired: %v", item.Post.Security)
+	}
+	if len(s.SecurityDefinitions) != 2 {
+		t.Fatalf("Only required schemes should be defined: %v", s.SecurityDefinitions)
+	}
+	bearer := s.SecurityDefinitions["bearer"]
+	if format, _ := bearer.Extensions.GetString("x-bearer-format"); bearer.Type != "apiKey" ||
+		bearer.In != "header" || bearer.Name != "Authorization" || format != "JWT" {
+		t.Fatalf("Bearer scheme is not desired: %+v", bearer)
+	}
+	session := s.SecurityDefinitions["session"]
+	if cookie, _ := session.Extensions.GetString("x-cookie"); session.Type != "apiKey" || cookie != "sid" {
+		t.Fatalf("Cookie scheme is not desired: %+v", session)
+	}
+}